@@ -5,8 +5,8 @@ import Image from 'next/image'
 import { useRouter } from 'next/navigation'
 import { Plus, X, Copy, Check, Trash2, LogOut, Settings } from 'lucide-react'
 import Link from 'next/link'
-import { api, Customer, Device, PairingCode } from '@/lib/api'
-import { getToken, clearAuth, isAdmin } from '@/lib/auth'
+import { api, logout, Customer, Device, PairingCode } from '@/lib/api'
+import { getToken, isAdmin } from '@/lib/auth'
 import { addWSHandler } from '@/lib/websocket'
 import { timeAgo, copyToClipboard } from '@/lib/utils'
 import { QRCodeSVG } from 'qrcode.react'
@@ -295,7 +295,7 @@ export default function DevicesPage() {
             <Settings className="w-4 h-4" />
           </Link>
           <button
-            onClick={() => { clearAuth(); router.push('/login') }}
+            onClick={() => { logout(); router.push('/login') }}
             className="inline-flex items-center gap-2 px-3 py-2 text-sm text-zinc-500 hover:text-white hover:bg-zinc-800/70 rounded-lg transition-colors"
           >
             <LogOut className="w-4 h-4" />
@@ -4,7 +4,7 @@ import { useState, useEffect, FormEvent } from 'react'
 import { useRouter } from 'next/navigation'
 import Image from 'next/image'
 import { Turnstile } from '@marsidev/react-turnstile'
-import { api } from '@/lib/api'
+import { api, TwoFactorChallenge, TwoFactorLogin } from '@/lib/api'
 import { setAuth } from '@/lib/auth'
 
 export default function LoginPage() {
@@ -18,10 +18,21 @@ export default function LoginPage() {
   const [showResend, setShowResend] = useState(false)
   const [resendLoading, setResendLoading] = useState(false)
   const [resendMessage, setResendMessage] = useState('')
+  const [challenge, setChallenge] = useState<{ token: string; setup: boolean } | null>(null)
+  const [enrollment, setEnrollment] = useState<{ secret: string; otpauth_url: string } | null>(null)
+  const [code, setCode] = useState('')
+  const [recoveryCodes, setRecoveryCodes] = useState<string[] | null>(null)
 
   useEffect(() => {
     const params = new URLSearchParams(window.location.search)
 
+    // Google OAuth login that still needs a 2FA code
+    const twoFactor = params.get('two_factor')
+    if (twoFactor) {
+      setChallenge({ token: twoFactor, setup: false })
+      window.history.replaceState({}, '', window.location.pathname)
+    }
+
     // Handle Google OAuth callback
     const token = params.get('token')
     const isGoogle = params.get('google')
@@ -33,7 +44,7 @@ export default function LoginPage() {
           email: payload.email,
           name: payload.email.split('@')[0],
           role: payload.role,
-        })
+        }, params.get('refresh_token') || undefined)
         router.push('/devices')
       } catch {
         // ignore invalid tokens
@@ -61,12 +72,16 @@ export default function LoginPage() {
       // Try customer login first
       try {
         const res = await api.customerAuth.login(email, password, turnstileToken)
+        if ('two_factor_required' in res) {
+          await startChallenge(res)
+          return
+        }
         setAuth(res.token, {
           id: res.customer.id,
           email: res.customer.email,
           name: res.customer.name || res.customer.email.split('@')[0],
           role: res.customer.role,
-        })
+        }, res.refresh_token)
         router.push('/devices')
         return
       } catch (customerErr) {
@@ -84,7 +99,11 @@ export default function LoginPage() {
 
       // Fallback to admin/operator login (no turnstile token needed)
       const res = await api.auth.login(email, password)
-      setAuth(res.token, res.user)
+      if ('two_factor_required' in res) {
+        await startChallenge(res)
+        return
+      }
+      setAuth(res.token, res.user, res.refresh_token)
       router.push('/devices')
     } catch (err) {
       setError(err instanceof Error ? err.message : 'Login failed')
@@ -93,6 +112,46 @@ export default function LoginPage() {
     }
   }
 
+  async function startChallenge(c: TwoFactorChallenge) {
+    setChallenge({ token: c.challenge_token, setup: c.setup_required })
+    if (c.setup_required) {
+      setEnrollment(await api.auth.setup2fa(c.challenge_token))
+    }
+  }
+
+  function finishLogin(res: TwoFactorLogin) {
+    if (res.user) {
+      setAuth(res.token, res.user, res.refresh_token)
+    } else if (res.customer) {
+      setAuth(res.token, {
+        id: res.customer.id,
+        email: res.customer.email,
+        name: res.customer.name || res.customer.email.split('@')[0],
+        role: res.customer.role || 'customer',
+      }, res.refresh_token)
+    }
+  }
+
+  async function handleTwoFactor(e: FormEvent) {
+    e.preventDefault()
+    if (!challenge) return
+    setError('')
+    setLoading(true)
+    try {
+      const res = await api.auth.verify2fa(challenge.token, code)
+      finishLogin(res)
+      if (res.recovery_codes) {
+        setRecoveryCodes(res.recovery_codes)
+        return
+      }
+      router.push('/devices')
+    } catch (err) {
+      setError(err instanceof Error ? err.message : 'Verification failed')
+    } finally {
+      setLoading(false)
+    }
+  }
+
   async function handleResend() {
     setResendLoading(true)
     setResendMessage('')
@@ -127,6 +186,51 @@ export default function LoginPage() {
           </div>
         )}
 
+        {recoveryCodes ? (
+          <div className="space-y-4">
+            <p className="text-sm text-zinc-300">
+              Two-factor authentication is on. Save these recovery codes somewhere safe; each one can be used once if you lose your authenticator.
+            </p>
+            <pre className="bg-zinc-800 border border-zinc-700 rounded p-3 text-sm font-mono text-white">{recoveryCodes.join('\n')}</pre>
+            <button
+              type="button"
+              onClick={() => router.push('/devices')}
+              className="w-full py-2 bg-brand-600 hover:bg-brand-500 text-white rounded font-medium"
+            >
+              I saved my recovery codes
+            </button>
+          </div>
+        ) : challenge ? (
+          <form onSubmit={handleTwoFactor} className="space-y-4">
+            {error && (
+              <div className="bg-red-900/50 border border-red-800 text-red-200 px-4 py-2 rounded text-sm">{error}</div>
+            )}
+            {enrollment ? (
+              <div className="text-sm text-zinc-300 space-y-2">
+                <p>Your account requires two-factor authentication. Add this key to your authenticator app, then enter the code it shows.</p>
+                <p className="font-mono break-all bg-zinc-800 border border-zinc-700 rounded p-2 text-white">{enrollment.secret}</p>
+              </div>
+            ) : (
+              <p className="text-sm text-zinc-300">Enter the code from your authenticator app, or a recovery code.</p>
+            )}
+            <input
+              type="text"
+              inputMode="numeric"
+              autoComplete="one-time-code"
+              value={code}
+              onChange={e => setCode(e.target.value)}
+              className="w-full px-3 py-2 bg-zinc-800 border border-zinc-700 rounded text-white focus:outline-none focus:border-brand-500 focus:ring-1 focus:ring-brand-500/50"
+              required
+            />
+            <button
+              type="submit"
+              disabled={loading}
+              className="w-full py-2 bg-brand-600 hover:bg-brand-500 disabled:bg-brand-800 text-white rounded font-medium"
+            >
+              {loading ? 'Verifying...' : 'Verify'}
+            </button>
+          </form>
+        ) : (
         <form onSubmit={handleSubmit} className="space-y-4">
           {error && (
             <div className="bg-red-900/50 border border-red-800 text-red-200 px-4 py-2 rounded text-sm">
@@ -182,6 +286,7 @@ export default function LoginPage() {
             {loading ? 'Signing in...' : 'Sign In'}
           </button>
         </form>
+        )}
 
         {/* Divider */}
         <div className="relative my-4">
@@ -13,6 +13,7 @@ export default function ResetPasswordPage() {
   const [error, setError] = useState('')
   const [loading, setLoading] = useState(false)
   const [noToken, setNoToken] = useState(false)
+  const [staff, setStaff] = useState(false)
 
   useEffect(() => {
     const params = new URLSearchParams(window.location.search)
@@ -21,6 +22,7 @@ export default function ResetPasswordPage() {
       setNoToken(true)
     } else {
       setToken(t)
+      setStaff(params.get('staff') === '1')
     }
   }, [])
 
@@ -39,7 +41,11 @@ export default function ResetPasswordPage() {
 
     setLoading(true)
     try {
-      await api.customerAuth.resetPassword(token, password, confirmPassword)
+      if (staff) {
+        await api.auth.setStaffPassword(token, password)
+      } else {
+        await api.customerAuth.resetPassword(token, password, confirmPassword)
+      }
       router.push('/login?message=password_updated')
     } catch (err) {
       const msg = err instanceof Error ? err.message : 'Reset failed'
@@ -2,7 +2,7 @@
 
 import { useEffect, useState } from 'react'
 import { api } from '@/lib/api'
-import { getToken } from '@/lib/auth'
+import { getRole, getToken } from '@/lib/auth'
 
 export default function SettingsPage() {
   const [webhookUrl, setWebhookUrl] = useState('')
@@ -10,8 +10,11 @@ export default function SettingsPage() {
   const [saving, setSaving] = useState(false)
   const [testing, setTesting] = useState(false)
   const [message, setMessage] = useState<{ type: 'success' | 'error'; text: string } | null>(null)
+  // Resellers can view the legacy webhook URL but not change or test it
+  const [readOnly, setReadOnly] = useState(false)
 
   useEffect(() => {
+    setReadOnly(getRole() === 'reseller')
     async function loadWebhookUrl() {
       const token = getToken()
       if (!token) return
@@ -88,27 +91,30 @@ export default function SettingsPage() {
               type="url"
               value={webhookUrl}
               onChange={(e) => setWebhookUrl(e.target.value)}
+              disabled={readOnly}
               placeholder="https://your-server.com/webhook"
               className="w-full bg-zinc-800 border border-zinc-700 text-white text-sm rounded-lg px-3 py-2 focus:outline-none focus:border-emerald-500 focus:ring-1 focus:ring-emerald-500 placeholder-zinc-600"
             />
           </div>
 
-          <div className="flex items-center gap-2 pt-1">
-            <button
-              onClick={handleSave}
-              disabled={saving || webhookUrl === savedUrl}
-              className="px-4 py-2 bg-emerald-600 hover:bg-emerald-500 disabled:bg-zinc-700 disabled:text-zinc-500 text-white text-sm font-medium rounded-lg transition-colors"
-            >
-              {saving ? 'Saving...' : 'Save'}
-            </button>
-            <button
-              onClick={handleTest}
-              disabled={testing || !webhookUrl}
-              className="px-4 py-2 bg-zinc-800 hover:bg-zinc-700 disabled:bg-zinc-800 disabled:text-zinc-600 text-zinc-300 hover:text-white text-sm font-medium rounded-lg border border-zinc-700 transition-colors"
-            >
-              {testing ? 'Sending...' : 'Send Test'}
-            </button>
-          </div>
+          {!readOnly && (
+            <div className="flex items-center gap-2 pt-1">
+              <button
+                onClick={handleSave}
+                disabled={saving || webhookUrl === savedUrl}
+                className="px-4 py-2 bg-emerald-600 hover:bg-emerald-500 disabled:bg-zinc-700 disabled:text-zinc-500 text-white text-sm font-medium rounded-lg transition-colors"
+              >
+                {saving ? 'Saving...' : 'Save'}
+              </button>
+              <button
+                onClick={handleTest}
+                disabled={testing || !webhookUrl}
+                className="px-4 py-2 bg-zinc-800 hover:bg-zinc-700 disabled:bg-zinc-800 disabled:text-zinc-600 text-zinc-300 hover:text-white text-sm font-medium rounded-lg border border-zinc-700 transition-colors"
+              >
+                {testing ? 'Sending...' : 'Send Test'}
+              </button>
+            </div>
+          )}
 
           {message && (
             <div
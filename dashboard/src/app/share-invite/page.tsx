@@ -0,0 +1,105 @@
+'use client'
+
+import { useState, useEffect } from 'react'
+import { useRouter } from 'next/navigation'
+import Image from 'next/image'
+import { api, DeviceShareInvitePreview } from '@/lib/api'
+import { getToken, isCustomer } from '@/lib/auth'
+
+export default function ShareInvitePage() {
+  const router = useRouter()
+  const [inviteToken, setInviteToken] = useState('')
+  const [preview, setPreview] = useState<DeviceShareInvitePreview | null>(null)
+  const [error, setError] = useState('')
+  const [loading, setLoading] = useState(true)
+  const [accepting, setAccepting] = useState(false)
+  const [signedIn, setSignedIn] = useState(false)
+
+  useEffect(() => {
+    const t = new URLSearchParams(window.location.search).get('token')
+    setSignedIn(!!getToken() && isCustomer())
+    if (!t) {
+      setError('This invitation link is missing a token.')
+      setLoading(false)
+      return
+    }
+    setInviteToken(t)
+    api.shareInvites.preview(t)
+      .then(setPreview)
+      .catch(() => setError('This invitation has expired, was revoked or has already been accepted.'))
+      .finally(() => setLoading(false))
+  }, [])
+
+  async function handleAccept() {
+    const token = getToken()
+    if (!token) return
+    setError('')
+    setAccepting(true)
+    try {
+      await api.shareInvites.accept(token, inviteToken)
+      router.push('/devices')
+    } catch (err) {
+      setError(err instanceof Error ? err.message : 'Failed to accept invitation')
+    } finally {
+      setAccepting(false)
+    }
+  }
+
+  return (
+    <div className="min-h-screen flex items-center justify-center relative">
+      {/* Radial glow */}
+      <div className="absolute inset-0 flex items-center justify-center pointer-events-none">
+        <div className="w-[600px] h-[600px] bg-brand-500/5 rounded-full blur-3xl" />
+      </div>
+
+      <div className="bg-zinc-900 p-8 rounded-xl border border-zinc-800 w-full max-w-md shadow-glow-sm relative text-center">
+        <div className="flex flex-col items-center mb-6">
+          <Image src="/logo.svg" alt="PocketProxy" width={48} height={48} className="rounded-xl mb-3" />
+        </div>
+
+        {loading && <p className="text-zinc-400 text-sm">Loading invitation...</p>}
+
+        {!loading && error && (
+          <div className="bg-red-900/50 border border-red-800 text-red-200 px-4 py-2 rounded text-sm mb-4">
+            {error}
+          </div>
+        )}
+
+        {!loading && preview && (
+          <>
+            <h2 className="text-xl font-bold text-white mb-3">A device was shared with you</h2>
+            <p className="text-zinc-400 text-sm mb-6">
+              <span className="text-white">{preview.organization_name}</span> invited{' '}
+              <span className="text-white">{preview.email}</span> to use the device{' '}
+              <span className="text-white">{preview.device_name}</span>.
+            </p>
+
+            {signedIn ? (
+              <button
+                onClick={handleAccept}
+                disabled={accepting}
+                className="w-full py-2 bg-brand-600 hover:bg-brand-500 disabled:bg-brand-800 text-white rounded font-medium"
+              >
+                {accepting ? 'Accepting...' : 'Accept invitation'}
+              </button>
+            ) : (
+              <>
+                <p className="text-zinc-400 text-sm mb-4">
+                  {preview.signup_required
+                    ? `Create an account with ${preview.email}, then open this link again to accept.`
+                    : `Sign in as ${preview.email}, then open this link again to accept.`}
+                </p>
+                <a
+                  href={preview.signup_required ? '/signup' : '/login'}
+                  className="block w-full py-2 bg-brand-600 hover:bg-brand-500 text-white rounded font-medium"
+                >
+                  {preview.signup_required ? 'Create account' : 'Sign in'}
+                </a>
+              </>
+            )}
+          </>
+        )}
+      </div>
+    </div>
+  )
+}
@@ -48,7 +48,7 @@ export default function VerifyEmailPage() {
         email: res.customer.email,
         name: res.customer.name || res.customer.email.split('@')[0],
         role: res.customer.role,
-      })
+      }, res.refresh_token)
       router.push('/devices')
     } catch (err) {
       setErrorMessage(err instanceof Error ? err.message : 'Verification failed')
@@ -5,7 +5,8 @@ import Image from 'next/image'
 import { usePathname } from 'next/navigation'
 import { Smartphone, ChevronLeft, ChevronRight, LogOut, Users } from 'lucide-react'
 import { cn } from '@/lib/utils'
-import { clearAuth, isAdmin } from '@/lib/auth'
+import { isAdmin } from '@/lib/auth'
+import { logout } from '@/lib/api'
 import { useRouter } from 'next/navigation'
 import { useState, useEffect } from 'react'
 
@@ -120,7 +121,7 @@ export default function Sidebar() {
       {/* Sign Out */}
       <div className="px-2 pb-3 border-t border-zinc-800 pt-3">
         <button
-          onClick={() => { clearAuth(); router.push('/login') }}
+          onClick={() => { logout(); router.push('/login') }}
           className={cn(
             'flex items-center text-sm text-zinc-500 hover:text-white hover:bg-zinc-800/70 rounded transition-colors w-full',
             collapsed ? 'justify-center px-2 py-2' : 'gap-3 px-3 py-2'
@@ -1,3 +1,5 @@
+import { clearAuth, getRefreshToken, setTokens } from './auth'
+
 const API_BASE = process.env.NEXT_PUBLIC_API_URL || 'http://localhost:8080/api'
 
 interface RequestOptions {
@@ -6,7 +8,32 @@ interface RequestOptions {
   token?: string
 }
 
-async function request<T>(path: string, options: RequestOptions = {}): Promise<T> {
+let refreshing: Promise<string | null> | null = null
+
+// refreshAccessToken trades the stored refresh token for a new token pair.
+// Concurrent callers share one request, since each refresh token works once.
+function refreshAccessToken(): Promise<string | null> {
+  const refreshToken = getRefreshToken()
+  if (!refreshToken) return Promise.resolve(null)
+  if (!refreshing) {
+    refreshing = fetch(`${API_BASE}/auth/refresh`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ refresh_token: refreshToken }),
+    })
+      .then(async (res) => {
+        if (!res.ok) return null
+        const pair: { token: string; refresh_token: string } = await res.json()
+        setTokens(pair.token, pair.refresh_token)
+        return pair.token
+      })
+      .catch(() => null)
+      .finally(() => { refreshing = null })
+  }
+  return refreshing
+}
+
+async function request<T>(path: string, options: RequestOptions = {}, retried = false): Promise<T> {
   const { method = 'GET', body, token } = options
   const headers: Record<string, string> = {
     'Content-Type': 'application/json',
@@ -21,6 +48,13 @@ async function request<T>(path: string, options: RequestOptions = {}): Promise<T
     body: body ? JSON.stringify(body) : undefined,
   })
 
+  if (res.status === 401 && token && !retried) {
+    const newToken = await refreshAccessToken()
+    if (newToken) {
+      return request<T>(path, { ...options, token: newToken }, true)
+    }
+  }
+
   if (!res.ok) {
     const error = await res.json().catch(() => ({ error: 'Request failed' }))
     throw new Error(error.error || `HTTP ${res.status}`)
@@ -112,6 +146,102 @@ export interface DeviceShare {
   created_at: string
 }
 
+export interface DeviceShareInvite {
+  id: string
+  device_id: string
+  email: string
+  can_rename: boolean
+  can_manage_ports: boolean
+  can_download_configs: boolean
+  can_rotate_ip: boolean
+  expires_at: string
+  created_at: string
+}
+
+export interface DeviceShareInvitePreview {
+  email: string
+  device_name: string
+  organization_name: string
+  expires_at: string
+  signup_required: boolean
+}
+
+export interface WebhookEndpoint {
+  id: string
+  kind: 'webhook' | 'slack' | 'telegram' | 'discord'
+  url: string
+  chat_id?: string
+  description: string
+  events: string[]
+  active: boolean
+  created_at: string
+  updated_at: string
+}
+
+export interface WebhookDelivery {
+  id: string
+  endpoint_id: string | null
+  url: string
+  event: string
+  payload: Record<string, unknown>
+  status: 'pending' | 'succeeded' | 'failed'
+  attempts: number
+  next_attempt_at: string
+  last_attempt_at: string | null
+  response_status: number | null
+  response_body: string
+  error: string
+  redelivery_of: string | null
+  created_at: string
+}
+
+export type AlertRuleType = 'battery_low' | 'signal_low' | 'network_downgrade' | 'carrier_changed' | 'app_outdated'
+
+export interface AlertRule {
+  id: string
+  device_id: string | null
+  name: string
+  type: AlertRuleType
+  threshold: number
+  value: string
+  duration_minutes: number
+  cooldown_minutes: number
+  notify_webhook: boolean
+  notify_email: boolean
+  active: boolean
+  created_at: string
+  updated_at: string
+}
+
+export interface AlertRuleInput {
+  device_id?: string | null
+  name?: string
+  type: AlertRuleType
+  threshold?: number
+  value?: string
+  duration_minutes?: number
+  cooldown_minutes?: number
+  notify_webhook?: boolean
+  notify_email?: boolean
+  active?: boolean
+}
+
+export interface AlertIncident {
+  id: string
+  rule_id: string
+  rule_name: string
+  device_id: string
+  device_name: string
+  type: AlertRuleType
+  status: 'open' | 'resolved'
+  message: string
+  observed: string
+  notified: boolean
+  opened_at: string
+  resolved_at: string | null
+  resolved_by: 'auto' | 'manual' | null
+}
+
 export interface AuthCustomer {
   id: string
   email: string
@@ -119,6 +249,22 @@ export interface AuthCustomer {
   role: string
 }
 
+// Returned instead of tokens when the account needs a second factor.
+export interface TwoFactorChallenge {
+  two_factor_required: true
+  setup_required: boolean
+  challenge_token: string
+  expires_at: string
+}
+
+export interface TwoFactorLogin {
+  token: string
+  refresh_token: string
+  user?: { id: string; email: string; name: string; role: string }
+  customer?: AuthCustomer
+  recovery_codes?: string[]
+}
+
 export interface IPHistoryEntry {
   id: string
   device_id: string
@@ -178,6 +324,86 @@ export interface UptimeSegment {
   end_time: string
 }
 
+export type TelemetryMetric = 'signal' | 'battery' | 'network' | 'carrier'
+
+export interface TelemetryPoint {
+  t: string
+  value: number | string
+  min?: number
+  max?: number
+  charging?: boolean
+}
+
+export interface TelemetrySeries {
+  device_id: string
+  metric: TelemetryMetric
+  resolution: 'raw' | 'hour'
+  from: string
+  to: string
+  points: TelemetryPoint[]
+}
+
+export interface MaintenanceWindow {
+  id: string
+  device_id: string | null
+  starts_at: string
+  ends_at: string
+  reason: string
+  created_by: string | null
+  created_at: string
+}
+
+export interface SLAStats {
+  up_seconds: number
+  down_seconds: number
+  maintenance_seconds: number
+  unknown_seconds: number
+  uptime_percent: number | null
+  outages: number
+  longest_outage_seconds: number
+  met?: boolean
+}
+
+export interface DeviceSLA extends SLAStats {
+  device_id: string
+  device_name: string
+  customer_id: string | null
+}
+
+export interface CustomerSLA extends SLAStats {
+  customer_id: string
+  customer_name: string
+  devices: number
+}
+
+export interface SLAReport {
+  period: string
+  from: string
+  to: string
+  target?: number
+  fleet: SLAStats
+  customers?: CustomerSLA[]
+  devices: DeviceSLA[]
+}
+
+export interface SLAParams {
+  period?: string
+  target?: number
+  customer_id?: string
+  device_id?: string
+}
+
+function slaQuery(params?: SLAParams, format?: 'csv') {
+  const qs = new URLSearchParams()
+  if (params?.period) qs.set('period', params.period)
+  if (params?.target !== undefined) qs.set('target', String(params.target))
+  if (params?.customer_id) qs.set('customer_id', params.customer_id)
+  if (params?.device_id) qs.set('device_id', params.device_id)
+  if (format) qs.set('format', format)
+  const q = qs.toString()
+  return q ? `?${q}` : ''
+}
+
 export interface OverviewStats {
   devices_total: number
   devices_online: number
@@ -191,9 +417,17 @@ export interface OverviewStats {
 export const api = {
   auth: {
     login: (email: string, password: string) =>
-      request<{ token: string; user: { id: string; email: string; name: string; role: string } }>(
+      request<{ token: string; refresh_token: string; user: { id: string; email: string; name: string; role: string } } | TwoFactorChallenge>(
         '/auth/login', { method: 'POST', body: { email, password } }
       ),
+    verify2fa: (challengeToken: string, code: string) =>
+      request<TwoFactorLogin>('/auth/2fa/verify', { method: 'POST', body: { challenge_token: challengeToken, code } }),
+    setup2fa: (challengeToken: string) =>
+      request<{ secret: string; otpauth_url: string }>('/auth/2fa/setup', { method: 'POST', body: { challenge_token: challengeToken } }),
+    logout: (refreshToken: string) =>
+      request<{ ok: boolean }>('/auth/logout', { method: 'POST', body: { refresh_token: refreshToken } }),
+    setStaffPassword: (token: string, password: string) =>
+      request<{ ok: boolean }>('/auth/staff/set-password', { method: 'POST', body: { token, password } }),
   },
   customerAuth: {
     signup: (email: string, password: string, turnstileToken: string) =>
@@ -202,14 +436,14 @@ export const api = {
         body: { email, password, turnstile_token: turnstileToken }
       }),
     login: (email: string, password: string, turnstileToken: string) =>
-      request<{ token: string; customer: AuthCustomer }>('/auth/customer/login', {
+      request<{ token: string; refresh_token: string; customer: AuthCustomer } | TwoFactorChallenge>('/auth/customer/login', {
         method: 'POST',
         body: { email, password, turnstile_token: turnstileToken }
       }),
     verifyEmailCheck: (token: string) =>
       request<{ valid: boolean; reason?: string }>(`/auth/customer/verify-email?token=${encodeURIComponent(token)}`),
     verifyEmail: (token: string) =>
-      request<{ token: string; customer: AuthCustomer }>('/auth/customer/verify-email', {
+      request<{ token: string; refresh_token: string; customer: AuthCustomer }>('/auth/customer/verify-email', {
         method: 'POST',
         body: { token }
       }),
@@ -248,6 +482,13 @@ export const api = {
       request<{ hourly: BandwidthHourly[] }>(`/devices/${id}/bandwidth/hourly?date=${date}&tz_offset=${tzOffset ?? 0}`, { token }),
     uptime: (token: string, id: string, date: string, tzOffset?: number) =>
       request<{ segments: UptimeSegment[] }>(`/devices/${id}/uptime?date=${date}&tz_offset=${tzOffset ?? 0}`, { token }),
+    telemetry: (token: string, id: string, params: { metric: TelemetryMetric; from?: string; to?: string; resolution?: 'raw' | 'hour' }) => {
+      const qs = new URLSearchParams({ metric: params.metric })
+      if (params.from) qs.set('from', params.from)
+      if (params.to) qs.set('to', params.to)
+      if (params.resolution) qs.set('resolution', params.resolution)
+      return request<TelemetrySeries>(`/devices/${id}/telemetry?${qs.toString()}`, { token })
+    },
   },
   stats: {
     overview: (token: string) =>
@@ -322,6 +563,96 @@ export const api = {
     delete: (token: string, id: string) =>
       request(`/device-shares/${id}`, { method: 'DELETE', token }),
   },
+  shareInvites: {
+    list: (token: string, deviceId?: string) =>
+      request<{ invites: DeviceShareInvite[] }>(`/share-invites${deviceId ? `?device_id=${deviceId}` : ''}`, { token }),
+    create: (token: string, data: { device_id: string; email: string; can_rename: boolean; can_manage_ports: boolean; can_download_configs: boolean; can_rotate_ip: boolean }) =>
+      request<DeviceShareInvite>('/share-invites', { method: 'POST', token, body: data }),
+    revoke: (token: string, id: string) =>
+      request(`/share-invites/${id}`, { method: 'DELETE', token }),
+    preview: (inviteToken: string) =>
+      request<DeviceShareInvitePreview>(`/public/share-invites/${encodeURIComponent(inviteToken)}`),
+    accept: (token: string, inviteToken: string) =>
+      request<DeviceShare>('/share-invites/accept', { method: 'POST', token, body: { token: inviteToken } }),
+  },
+  webhooks: {
+    list: (token: string) =>
+      request<{ endpoints: WebhookEndpoint[]; events: string[] }>('/webhooks', { token }),
+    create: (token: string, data: { kind?: WebhookEndpoint['kind']; url?: string; bot_token?: string; chat_id?: string; description?: string; events: string[] }) =>
+      request<WebhookEndpoint & { secret?: string }>('/webhooks', { method: 'POST', token, body: data }),
+    update: (token: string, id: string, data: { url?: string; bot_token?: string; chat_id?: string; description?: string; events: string[]; active: boolean }) =>
+      request<WebhookEndpoint>(`/webhooks/${id}`, { method: 'PUT', token, body: data }),
+    delete: (token: string, id: string) =>
+      request(`/webhooks/${id}`, { method: 'DELETE', token }),
+    rotateSecret: (token: string, id: string) =>
+      request<WebhookEndpoint & { secret: string }>(`/webhooks/${id}/rotate-secret`, { method: 'POST', token }),
+    test: (token: string, id: string) =>
+      request<WebhookDelivery>(`/webhooks/${id}/test`, { method: 'POST', token }),
+    deliveries: (token: string, params?: { endpoint_id?: string; status?: string; limit?: number }) => {
+      const qs = new URLSearchParams()
+      if (params?.endpoint_id) qs.set('endpoint_id', params.endpoint_id)
+      if (params?.status) qs.set('status', params.status)
+      if (params?.limit) qs.set('limit', String(params.limit))
+      const q = qs.toString()
+      return request<{ deliveries: WebhookDelivery[] }>(`/webhook-deliveries${q ? `?${q}` : ''}`, { token })
+    },
+    redeliver: (token: string, id: string) =>
+      request<WebhookDelivery>(`/webhook-deliveries/${id}/redeliver`, { method: 'POST', token }),
+  },
+  alerts: {
+    rules: (token: string) =>
+      request<{ rules: AlertRule[] }>('/alert-rules', { token }),
+    createRule: (token: string, data: AlertRuleInput) =>
+      request<AlertRule>('/alert-rules', { method: 'POST', token, body: data }),
+    updateRule: (token: string, id: string, data: AlertRuleInput) =>
+      request<AlertRule>(`/alert-rules/${id}`, { method: 'PUT', token, body: data }),
+    deleteRule: (token: string, id: string) =>
+      request(`/alert-rules/${id}`, { method: 'DELETE', token }),
+    incidents: (token: string, params?: { status?: 'open' | 'resolved'; device_id?: string; limit?: number }) => {
+      const qs = new URLSearchParams()
+      if (params?.status) qs.set('status', params.status)
+      if (params?.device_id) qs.set('device_id', params.device_id)
+      if (params?.limit) qs.set('limit', String(params.limit))
+      const q = qs.toString()
+      return request<{ incidents: AlertIncident[] }>(`/alert-incidents${q ? `?${q}` : ''}`, { token })
+    },
+    resolveIncident: (token: string, id: string) =>
+      request<AlertIncident>(`/alert-incidents/${id}/resolve`, { method: 'POST', token }),
+  },
+  sla: {
+    report: (token: string, params?: SLAParams) =>
+      request<SLAReport>(`/sla${slaQuery(params)}`, { token }),
+    downloadCSV: async (token: string, params?: SLAParams) => {
+      const res = await fetch(`${API_BASE}/sla${slaQuery(params, 'csv')}`, {
+        headers: { 'Authorization': `Bearer ${token}` },
+      })
+      if (!res.ok) {
+        const error = await res.json().catch(() => ({ error: 'Download failed' }))
+        throw new Error(error.error || `HTTP ${res.status}`)
+      }
+      const blob = await res.blob()
+      const disposition = res.headers.get('Content-Disposition')
+      let filename = 'sla.csv'
+      if (disposition) {
+        const match = disposition.match(/filename="?([^"]+)"?/)
+        if (match) filename = match[1]
+      }
+      const url = URL.createObjectURL(blob)
+      const a = document.createElement('a')
+      a.href = url
+      a.download = filename
+      a.click()
+      URL.revokeObjectURL(url)
+    },
+  },
+  maintenanceWindows: {
+    list: (token: string) =>
+      request<{ maintenance_windows: MaintenanceWindow[] }>('/maintenance-windows', { token }),
+    create: (token: string, data: { device_id?: string | null; starts_at: string; ends_at: string; reason?: string }) =>
+      request<MaintenanceWindow>('/maintenance-windows', { method: 'POST', token, body: data }),
+    delete: (token: string, id: string) =>
+      request(`/maintenance-windows/${id}`, { method: 'DELETE', token }),
+  },
   rotationLinks: {
     list: (token: string, deviceId: string) =>
       request<{ links: RotationLink[] }>(`/rotation-links?device_id=${deviceId}`, { token }),
@@ -354,3 +685,12 @@ export const api = {
       request<RelayServer>('/relay-servers', { method: 'POST', token, body: data }),
   },
 }
+
+// logout ends the server-side session (best effort) and forgets local tokens.
+export function logout() {
+  const refreshToken = getRefreshToken()
+  if (refreshToken) {
+    api.auth.logout(refreshToken).catch(() => {})
+  }
+  clearAuth()
+}
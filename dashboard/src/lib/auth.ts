@@ -2,15 +2,28 @@
 
 const TOKEN_KEY = 'pocketproxy_token'
 const USER_KEY = 'pocketproxy_user'
+const REFRESH_KEY = 'pocketproxy_refresh_token'
 
 export function getToken(): string | null {
   if (typeof window === 'undefined') return null
   return localStorage.getItem(TOKEN_KEY)
 }
 
-export function setAuth(token: string, user: { id: string; email: string; name: string; role: string }) {
-  localStorage.setItem(TOKEN_KEY, token)
+export function getRefreshToken(): string | null {
+  if (typeof window === 'undefined') return null
+  return localStorage.getItem(REFRESH_KEY)
+}
+
+export function setAuth(token: string, user: { id: string; email: string; name: string; role: string }, refreshToken?: string) {
   localStorage.setItem(USER_KEY, JSON.stringify(user))
+  setTokens(token, refreshToken)
+}
+
+export function setTokens(token: string, refreshToken?: string) {
+  localStorage.setItem(TOKEN_KEY, token)
+  if (refreshToken) {
+    localStorage.setItem(REFRESH_KEY, refreshToken)
+  }
 }
 
 export function getUser() {
@@ -22,6 +35,7 @@ export function getUser() {
 export function clearAuth() {
   localStorage.removeItem(TOKEN_KEY)
   localStorage.removeItem(USER_KEY)
+  localStorage.removeItem(REFRESH_KEY)
 }
 
 export function isAuthenticated(): boolean {
@@ -35,7 +49,7 @@ export function getRole(): string | null {
 
 export function isAdmin(): boolean {
   const role = getRole()
-  return role === 'admin' || role === 'operator'
+  return role === 'admin' || role === 'operator' || role === 'reseller'
 }
 
 export function isCustomer(): boolean {
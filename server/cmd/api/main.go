@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 
+	"github.com/google/uuid"
 	"github.com/mobileproxy/server/internal/api/handler"
 	"github.com/mobileproxy/server/internal/domain"
 	"github.com/mobileproxy/server/internal/repository"
@@ -34,33 +36,75 @@ func main() {
 	pairingRepo := repository.NewPairingCodeRepository(db)
 	relayServerRepo := repository.NewRelayServerRepository(db)
 	deviceShareRepo := repository.NewDeviceShareRepository(db)
+	aclRepo := repository.NewACLRepository(db)
+	sessionLogRepo := repository.NewSessionLogRepository(db)
+	quotaRepo := repository.NewQuotaRepository(db)
+	planRepo := repository.NewPlanRepository(db)
+	statementRepo := repository.NewStatementRepository(db)
+	billingRepo := repository.NewBillingRepository(db)
+	apiKeyRepo := repository.NewAPIKeyRepository(db)
+	sessionRepo := repository.NewAuthSessionRepository(db)
+	twoFactorRepo := repository.NewTwoFactorRepository(db)
+	settingsRepo := repository.NewSettingsRepository(db)
+	userTokenRepo := repository.NewUserTokenRepository(db)
+	auditRepo := repository.NewAuditRepository(db)
+	orgRepo := repository.NewOrganizationRepository(db)
+	resellerRepo := repository.NewResellerRepository(db)
+	shareInviteRepo := repository.NewDeviceShareInviteRepository(db)
 
 	// Services
 	iptablesService := service.NewIPTablesService()
 	vpnService := service.NewVPNService(cfg.VPN, iptablesService)
 	portService := service.NewPortService(deviceRepo, cfg.Ports)
-	authService := service.NewAuthService(userRepo, cfg.JWT)
+	sessionService := service.NewSessionService(sessionRepo, userRepo, customerRepo, cfg.JWT)
+	authService := service.NewAuthService(userRepo, sessionService, cfg.JWT)
+	twoFactorService := service.NewTwoFactorService(twoFactorRepo, settingsRepo, userRepo, customerRepo, sessionService, cfg.JWT)
+	authService.SetTwoFactorService(twoFactorService)
 	statusLogRepo := repository.NewStatusLogRepository(db)
 	deviceService := service.NewDeviceService(deviceRepo, ipHistRepo, commandRepo, portService, vpnService)
 	deviceService.SetStatusLogRepo(statusLogRepo)
-	deviceService.SetUserRepo(userRepo)
+	webhookRepo := repository.NewWebhookRepository(db)
+	webhookService := service.NewWebhookService(webhookRepo, deviceRepo, userRepo)
+	deviceService.SetWebhookService(webhookService)
+	telemetryRepo := repository.NewTelemetryRepository(db)
+	telemetryService := service.NewTelemetryService(telemetryRepo)
+	deviceService.SetTelemetryService(telemetryService)
+	alertRepo := repository.NewAlertRepository(db)
+	alertService := service.NewAlertService(alertRepo, deviceRepo, userRepo, customerRepo)
+	maintenanceRepo := repository.NewMaintenanceRepository(db)
+	slaService := service.NewSLAService(statusLogRepo, deviceRepo, customerRepo, maintenanceRepo)
 	deviceService.SetRelayServerRepo(relayServerRepo)
 	if v := os.Getenv("TUNNEL_PUSH_URL"); v != "" {
 		deviceService.SetTunnelPushURL(v)
 		log.Printf("Tunnel push URL configured: %s", v)
 	}
+	planService := service.NewPlanService(planRepo, customerRepo)
+	statementService := service.NewStatementService(statementRepo, planRepo)
+	paymentProvider, err := service.NewPaymentProvider(cfg.Billing)
+	if err != nil {
+		log.Fatalf("Billing: %v", err)
+	}
+	billingService := service.NewBillingService(paymentProvider, billingRepo, customerRepo, planService)
+	apiKeyService := service.NewAPIKeyService(apiKeyRepo)
 	connService := service.NewConnectionService(connRepo, deviceRepo)
+	connService.SetPlanService(planService)
 	connService.SetPortService(portService)
 	connService.SetRelayServerRepo(relayServerRepo)
+	connService.SetACLRepo(aclRepo)
+	connService.SetQuotaRepo(quotaRepo)
 	if v := os.Getenv("TUNNEL_PUSH_URL"); v != "" {
 		connService.SetTunnelPushURL(v)
 	}
 	bwRepo := repository.NewBandwidthRepository(db)
 	bwService := service.NewBandwidthService(bwRepo)
 	relayServerService := service.NewRelayServerService(relayServerRepo)
+	aclService := service.NewACLService(aclRepo, connRepo)
+	sessionLogService := service.NewSessionLogService(sessionLogRepo)
+	quotaService := service.NewQuotaService(quotaRepo, connService)
 
 	// Device share service (multi-tenant permission layer)
 	deviceShareService := service.NewDeviceShareService(deviceShareRepo, deviceRepo)
+	deviceShareService.SetPlanService(planService)
 
 	// Build server URL for pairing responses
 	serverURL := fmt.Sprintf("http://%s:%d", cfg.VPN.ServerIP, cfg.Server.Port)
@@ -69,10 +113,50 @@ func main() {
 	}
 	pairingService := service.NewPairingService(pairingRepo, deviceService, deviceRepo, connRepo, relayServerRepo, serverURL)
 
+	// Audit log: loaders give the audit middleware the before/after state to diff
+	auditService := service.NewAuditService(auditRepo)
+	auditService.RegisterTarget("device", func(ctx context.Context, id uuid.UUID) (interface{}, error) {
+		return deviceRepo.GetByID(ctx, id)
+	})
+	auditService.RegisterTarget("connection", func(ctx context.Context, id uuid.UUID) (interface{}, error) {
+		return connRepo.GetByID(ctx, id)
+	})
+	auditService.RegisterTarget("device_share", func(ctx context.Context, id uuid.UUID) (interface{}, error) {
+		return deviceShareRepo.GetByID(ctx, id)
+	})
+	auditService.RegisterTarget("customer", func(ctx context.Context, id uuid.UUID) (interface{}, error) {
+		return customerRepo.GetByID(ctx, id)
+	})
+	auditService.RegisterTarget("user", func(ctx context.Context, id uuid.UUID) (interface{}, error) {
+		return userRepo.GetByID(ctx, id)
+	})
+	auditService.RegisterTarget("plan", func(ctx context.Context, id uuid.UUID) (interface{}, error) {
+		return planRepo.GetByID(ctx, id)
+	})
+	auditService.RegisterTarget("relay_server", func(ctx context.Context, id uuid.UUID) (interface{}, error) {
+		return relayServerRepo.GetByID(ctx, id)
+	})
+	auditService.RegisterTarget("api_key", func(ctx context.Context, id uuid.UUID) (interface{}, error) {
+		return apiKeyRepo.GetByID(ctx, id)
+	})
+	auditService.RegisterTarget("organization", func(ctx context.Context, id uuid.UUID) (interface{}, error) {
+		return orgRepo.GetByID(ctx, id)
+	})
+	auditService.RegisterTarget("webhook", func(ctx context.Context, id uuid.UUID) (interface{}, error) {
+		return webhookService.GetEndpoint(ctx, id)
+	})
+	auditService.RegisterTarget("alert_rule", func(ctx context.Context, id uuid.UUID) (interface{}, error) {
+		return alertService.GetRule(ctx, id)
+	})
+	auditService.RegisterTarget("maintenance_window", func(ctx context.Context, id uuid.UUID) (interface{}, error) {
+		return slaService.GetMaintenanceWindow(ctx, id)
+	})
+	pairingService.SetAuditService(auditService)
+
 	// Peer sync service
 	var syncService *service.SyncService
 	if v := os.Getenv("PEER_API_URL"); v != "" {
-		syncService = service.NewSyncService(v)
+		syncService = service.NewSyncService(v, cfg.Server.RelaySecret)
 		pairingService.SetSyncService(syncService)
 		connService.SetSyncService(syncService)
 		log.Printf("Peer sync configured: %s", v)
@@ -85,21 +169,56 @@ func main() {
 	emailService := service.NewEmailService(cfg.Resend)
 	customerAuthService := service.NewCustomerAuthService(
 		customerRepo, customerTokenRepo, emailService,
-		cfg.JWT, cfg.Google, cfg.Turnstile,
+		sessionService, cfg.Google, cfg.Turnstile,
 	)
+	customerAuthService.SetTwoFactorService(twoFactorService)
+	userService := service.NewUserService(userRepo, userTokenRepo, emailService, sessionService)
+
+	// Organizations: member logins act for their organization's account
+	organizationService := service.NewOrganizationService(orgRepo, customerRepo, customerTokenRepo, emailService, sessionService)
+	deviceShareService.SetOrganizationService(organizationService)
+	shareInviteService := service.NewDeviceShareInviteService(shareInviteRepo, deviceShareService, deviceRepo, customerRepo, organizationService, emailService)
+
+	// Resellers: staff tenants scoped to the devices and customers allocated to them
+	resellerService := service.NewResellerService(resellerRepo, userRepo, deviceRepo, customerRepo, connRepo, pairingRepo, rotationLinkRepo)
+	resellerService.SetWebhookRepo(webhookRepo)
+	resellerService.SetAlertRepo(alertRepo)
 
 	// Handlers
 	customerHandler := handler.NewCustomerHandler(customerRepo)
+	customerHandler.SetBillingService(billingService)
+	customerHandler.SetSessionService(sessionService)
 	customerAuthHandler := handler.NewCustomerAuthHandler(customerAuthService)
 	vpnHandler := handler.NewVPNHandler(deviceService, vpnService, connService)
 	statsHandler := handler.NewStatsHandler(deviceRepo, connRepo, bwService)
 	rotationLinkHandler := handler.NewRotationLinkHandler(rotationLinkRepo, deviceService)
+	rotationLinkHandler.SetPlanService(planService)
+	rotationLinkHandler.SetResellerService(resellerService)
 	pairingHandler := handler.NewPairingHandler(pairingService)
+	pairingHandler.SetResellerService(resellerService)
 	relayServerHandler := handler.NewRelayServerHandler(relayServerService)
 	openvpnHandler := handler.NewOpenVPNHandler(connRepo, deviceService)
 	openvpnHandler.SetShareService(deviceShareService)
 	syncHandler := handler.NewSyncHandler(deviceRepo, connRepo)
 	deviceShareHandler := handler.NewDeviceShareHandler(deviceShareService)
+	deviceShareHandler.SetInviteService(shareInviteService)
+	aclHandler := handler.NewACLHandler(aclService, connService)
+	sessionLogHandler := handler.NewSessionLogHandler(sessionLogService, connService)
+	quotaHandler := handler.NewQuotaHandler(quotaService, connService)
+	planHandler := handler.NewPlanHandler(planService)
+	statementHandler := handler.NewStatementHandler(statementService)
+	billingHandler := handler.NewBillingHandler(billingService)
+	apiKeyHandler := handler.NewAPIKeyHandler(apiKeyService)
+	sessionHandler := handler.NewSessionHandler(sessionService)
+	twoFactorHandler := handler.NewTwoFactorHandler(twoFactorService)
+	userHandler := handler.NewUserHandler(userService)
+	auditHandler := handler.NewAuditHandler(auditService)
+	organizationHandler := handler.NewOrganizationHandler(organizationService)
+	resellerHandler := handler.NewResellerHandler(resellerService)
+	webhookHandler := handler.NewWebhookHandler(webhookService)
+	alertHandler := handler.NewAlertHandler(alertService)
+	telemetryHandler := handler.NewTelemetryHandler(telemetryService, deviceService, deviceShareService)
+	slaHandler := handler.NewSLAHandler(slaService)
 
 	// Router
 	router := handler.SetupRouter(
@@ -108,7 +227,28 @@ func main() {
 		pairingHandler, relayServerHandler, wsHub, openvpnHandler, syncHandler,
 		userRepo, customerAuthHandler,
 		deviceShareHandler, customerRepo, deviceShareService,
+		aclHandler,
+		sessionLogHandler,
+		quotaHandler,
+		planHandler, planService,
+		statementHandler,
+		billingHandler,
+		apiKeyHandler, apiKeyService,
+		sessionHandler, sessionService,
+		twoFactorHandler,
+		userHandler,
+		auditHandler, auditService,
+		organizationHandler, organizationService,
+		resellerHandler, resellerService,
+		webhookHandler,
+		alertHandler,
+		telemetryHandler,
+		slaHandler,
+		cfg.Server.RelaySecret,
 	)
+	if cfg.Server.RelaySecret == "" {
+		log.Printf("WARNING: RELAY_SECRET is not set; tunnel servers will be refused on /api/internal")
+	}
 
 	// Start server
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
@@ -140,9 +280,18 @@ func loadConfig() domain.Config {
 	if v := os.Getenv("JWT_SECRET"); v != "" {
 		cfg.JWT.Secret = v
 	}
+	if v := os.Getenv("JWT_ACCESS_MINUTES"); v != "" {
+		fmt.Sscanf(v, "%d", &cfg.JWT.AccessMinutes)
+	}
+	if v := os.Getenv("JWT_REFRESH_DAYS"); v != "" {
+		fmt.Sscanf(v, "%d", &cfg.JWT.RefreshDays)
+	}
 	if v := os.Getenv("SERVER_PORT"); v != "" {
 		fmt.Sscanf(v, "%d", &cfg.Server.Port)
 	}
+	if v := os.Getenv("RELAY_SECRET"); v != "" {
+		cfg.Server.RelaySecret = v
+	}
 	if v := os.Getenv("VPN_SERVER_IP"); v != "" {
 		cfg.VPN.ServerIP = v
 	}
@@ -172,6 +321,23 @@ func loadConfig() domain.Config {
 		cfg.Resend.BaseURL = v
 	}
 
+	// Billing
+	if v := os.Getenv("BILLING_PROVIDER"); v != "" {
+		cfg.Billing.Provider = v
+	}
+	if v := os.Getenv("BILLING_SECRET_KEY"); v != "" {
+		cfg.Billing.SecretKey = v
+	}
+	if v := os.Getenv("BILLING_WEBHOOK_SECRET"); v != "" {
+		cfg.Billing.WebhookSecret = v
+	}
+	if v := os.Getenv("BILLING_SUCCESS_URL"); v != "" {
+		cfg.Billing.SuccessURL = v
+	}
+	if v := os.Getenv("BILLING_CANCEL_URL"); v != "" {
+		cfg.Billing.CancelURL = v
+	}
+
 	// Cloudflare Turnstile
 	if v := os.Getenv("TURNSTILE_SITE_KEY"); v != "" {
 		cfg.Turnstile.SiteKey = v
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ──────────────────────────────────────────────────────────────────────────────
+// Destination ACLs
+//
+// The API owns the rules (global policy + per-connection rules). The tunnel
+// pulls a full snapshot from /api/internal/acl-policy every aclSyncInterval and
+// enforces it in the SOCKS forwarder (OpenVPN clients) and the proxy gateway
+// (HTTP/SOCKS5 ports). Denied attempts are buffered and reported back.
+//
+// Each policy (global, connection) must permit the destination: any matching
+// deny rule blocks, and if the policy has allow rules one of them must match.
+// CIDR rules are matched against addresses (resolved through the device for
+// hostname destinations) and the connection is pinned to the checked address.
+// ──────────────────────────────────────────────────────────────────────────────
+
+const (
+	aclSyncInterval = 15 * time.Second
+	aclFirstRetry   = 2 * time.Second // retry interval until the first policy lands
+	aclMaxPending   = 1000            // buffered denials before we start dropping
+)
+
+type aclRuleJSON struct {
+	ID       string `json:"id"`
+	Action   string `json:"action"`
+	RuleType string `json:"rule_type"`
+	Value    string `json:"value"`
+}
+
+type aclMatcher struct {
+	id     string
+	portLo uint16
+	portHi uint16
+	ipNet  *net.IPNet
+	domain string
+}
+
+func (m *aclMatcher) match(d *aclDest) bool {
+	switch {
+	case m.ipNet != nil:
+		return d.ip != nil && m.ipNet.Contains(d.ip)
+	case m.domain != "":
+		return d.host == m.domain || strings.HasSuffix(d.host, "."+m.domain)
+	default:
+		return d.port >= m.portLo && d.port <= m.portHi
+	}
+}
+
+type aclRuleSet struct {
+	allow   []aclMatcher
+	deny    []aclMatcher
+	hasCIDR bool
+}
+
+// evaluate returns whether the rule set permits d, and the matching rule ID
+// when a specific rule decided the outcome.
+func (rs *aclRuleSet) evaluate(d *aclDest) (bool, string) {
+	for i := range rs.deny {
+		if rs.deny[i].match(d) {
+			return false, rs.deny[i].id
+		}
+	}
+	if len(rs.allow) == 0 {
+		return true, ""
+	}
+	for i := range rs.allow {
+		if rs.allow[i].match(d) {
+			return true, rs.allow[i].id
+		}
+	}
+	return false, ""
+}
+
+type aclPolicy struct {
+	global aclRuleSet
+	conns  map[string]*aclRuleSet // connection ID -> rules
+}
+
+type aclDest struct {
+	host string
+	ip   net.IP // nil when only the hostname is known
+	port uint16
+}
+
+// aclRequest describes one outbound attempt to be checked.
+type aclRequest struct {
+	connID   string
+	username string
+	clientIP string
+	host     string // hostname if known, "" for IP-only destinations
+	ip       net.IP // destination IP if known
+	port     uint16
+
+	// resolve looks up a hostname destination through the device when CIDR
+	// rules need its addresses. Without it such destinations are refused.
+	resolve func(host string) ([]net.IP, error)
+}
+
+type aclDenial struct {
+	ConnectionID *string   `json:"connection_id,omitempty"`
+	Username     string    `json:"username"`
+	ClientIP     string    `json:"client_ip"`
+	DestHost     string    `json:"dest_host"`
+	DestPort     int       `json:"dest_port"`
+	RuleID       *string   `json:"rule_id,omitempty"`
+	Scope        string    `json:"scope"`
+	OccurredAt   time.Time `json:"occurred_at"`
+}
+
+func compileRuleSet(rules []aclRuleJSON) aclRuleSet {
+	var rs aclRuleSet
+	for _, r := range rules {
+		m := aclMatcher{id: r.ID}
+		switch r.RuleType {
+		case "port":
+			lo, hi, found := strings.Cut(r.Value, "-")
+			if !found {
+				hi = lo
+			}
+			loN, err1 := strconv.Atoi(lo)
+			hiN, err2 := strconv.Atoi(hi)
+			if err1 != nil || err2 != nil {
+				log.Printf("[acl] skipping bad port rule %s: %q", r.ID, r.Value)
+				continue
+			}
+			m.portLo, m.portHi = uint16(loN), uint16(hiN)
+		case "cidr":
+			_, ipNet, err := net.ParseCIDR(r.Value)
+			if err != nil {
+				log.Printf("[acl] skipping bad cidr rule %s: %q", r.ID, r.Value)
+				continue
+			}
+			m.ipNet = ipNet
+			rs.hasCIDR = true
+		case "domain":
+			m.domain = strings.ToLower(r.Value)
+		default:
+			continue
+		}
+		if r.Action == "allow" {
+			rs.allow = append(rs.allow, m)
+		} else {
+			rs.deny = append(rs.deny, m)
+		}
+	}
+	return rs
+}
+
+func (rs *aclRuleSet) empty() bool {
+	return len(rs.allow) == 0 && len(rs.deny) == 0
+}
+
+// evaluate checks d against the global rules, then the connection's. It
+// returns whether d is permitted and the scope and ID of the deciding rule.
+func (p *aclPolicy) evaluate(connID string, d *aclDest) (bool, string, string) {
+	ok, ruleID := p.global.evaluate(d)
+	connRules := p.conns[connID]
+	if !ok || connRules == nil {
+		return ok, "global", ruleID
+	}
+	ok, ruleID = connRules.evaluate(d)
+	return ok, "connection", ruleID
+}
+
+// needsAddresses reports whether CIDR rules apply to a connection, so its
+// destinations have to be checked, and connected to, by address.
+func (p *aclPolicy) needsAddresses(connID string) bool {
+	if p.global.hasCIDR {
+		return true
+	}
+	rs := p.conns[connID]
+	return rs != nil && rs.hasCIDR
+}
+
+// checkACL returns whether the destination is permitted. Everything is denied
+// until the first policy sync. Denials are logged and queued for reporting to
+// the API.
+//
+// When CIDR rules apply it also returns the address they were checked
+// against, and the caller must connect to that address rather than the
+// hostname: otherwise a name resolving elsewhere (a spoofed SNI, a rebinding
+// DNS answer) would reach a denied range. Hostname destinations are resolved
+// through the device via req.resolve, never by the relay itself.
+func (s *tunnelServer) checkACL(req aclRequest) (net.IP, bool) {
+	if req.host == "" && req.ip == nil {
+		s.denyACL(req, "global", "", "no destination")
+		return nil, false
+	}
+	p := s.acl.Load()
+	if p == nil {
+		// Fail closed until the first sync; later sync failures keep the last policy
+		s.denyACL(req, "global", "", "no ACL policy synced yet")
+		return nil, false
+	}
+
+	d := &aclDest{host: strings.ToLower(req.host), ip: req.ip, port: req.port}
+	if req.ip == nil && p.needsAddresses(req.connID) {
+		var ips []net.IP
+		err := errors.New("no resolver for this route")
+		if req.resolve != nil {
+			ips, err = req.resolve(d.host)
+		}
+		if err == nil && len(ips) == 0 {
+			err = errors.New("no addresses")
+		}
+		if err != nil {
+			s.denyACL(req, "global", "", "resolve failed: "+err.Error())
+			return nil, false
+		}
+		// Pin the first address that is permitted on its own
+		var scope, ruleID string
+		for _, ip := range ips {
+			d.ip = ip
+			var ok bool
+			if ok, scope, ruleID = p.evaluate(req.connID, d); ok {
+				return ip, true
+			}
+		}
+		s.denyACL(req, scope, ruleID, "")
+		return nil, false
+	}
+
+	ok, scope, ruleID := p.evaluate(req.connID, d)
+	if !ok {
+		s.denyACL(req, scope, ruleID, "")
+		return nil, false
+	}
+	if req.ip != nil && p.needsAddresses(req.connID) {
+		return req.ip, true
+	}
+	return nil, true
+}
+
+// aclScope returns the scope of the rules that apply to a connection, global
+// first, or "" if none do. Before the first sync everything is restricted.
+func (s *tunnelServer) aclScope(connID string) string {
+	p := s.acl.Load()
+	if p == nil || !p.global.empty() {
+		return "global"
+	}
+	if rs := p.conns[connID]; rs != nil && !rs.empty() {
+		return "connection"
+	}
+	return ""
+}
+
+// checkPacket is checkACL for NAT-routed OpenVPN packets, which only carry an
+// address and (TCP/UDP) a port. It runs for every packet, so each denied flow
+// is logged and reported once per sync interval rather than per packet.
+func (s *tunnelServer) checkPacket(req aclRequest) bool {
+	scope, ruleID, detail := "global", "", "no ACL policy synced yet"
+	if p := s.acl.Load(); p != nil {
+		var ok bool
+		if ok, scope, ruleID = p.evaluate(req.connID, &aclDest{ip: req.ip, port: req.port}); ok {
+			return true
+		}
+		detail = ""
+	}
+
+	flow := req.clientIP + ">" + net.JoinHostPort(req.ip.String(), strconv.Itoa(int(req.port)))
+	s.aclDenialsMu.Lock()
+	seen := s.aclDeniedFlows[flow]
+	if !seen && len(s.aclDeniedFlows) < aclMaxPending {
+		if s.aclDeniedFlows == nil {
+			s.aclDeniedFlows = make(map[string]bool)
+		}
+		s.aclDeniedFlows[flow] = true
+	}
+	s.aclDenialsMu.Unlock()
+	if !seen {
+		s.denyACL(req, scope, ruleID, detail)
+	}
+	return false
+}
+
+// packetDest returns the destination address of an IPv4 packet and, for TCP
+// and UDP, its port. Non-first fragments carry no port and report 0.
+func packetDest(pkt []byte) (net.IP, uint16) {
+	if len(pkt) < 20 || pkt[0]>>4 != 4 {
+		return nil, 0
+	}
+	ip := net.IPv4(pkt[16], pkt[17], pkt[18], pkt[19])
+	ihl := int(pkt[0]&0x0f) * 4
+	proto := pkt[9]
+	fragOffset := binary.BigEndian.Uint16(pkt[6:8]) & 0x1fff
+	if (proto != 6 && proto != 17) || fragOffset != 0 || len(pkt) < ihl+4 {
+		return ip, 0
+	}
+	return ip, binary.BigEndian.Uint16(pkt[ihl+2:])
+}
+
+// denyACL logs a denied attempt and queues it for reporting.
+func (s *tunnelServer) denyACL(req aclRequest, scope, ruleID, detail string) {
+	dest := req.host
+	if dest == "" && req.ip != nil {
+		dest = req.ip.String()
+	}
+	if detail != "" {
+		log.Printf("[acl] denied %s (conn=%s user=%s) -> %s:%d: %s",
+			req.clientIP, req.connID, req.username, dest, req.port, detail)
+	} else {
+		log.Printf("[acl] denied %s (conn=%s user=%s) -> %s:%d (%s rule %s)",
+			req.clientIP, req.connID, req.username, dest, req.port, scope, ruleID)
+	}
+
+	denial := aclDenial{
+		Username:   req.username,
+		ClientIP:   req.clientIP,
+		DestHost:   dest,
+		DestPort:   int(req.port),
+		Scope:      scope,
+		OccurredAt: time.Now().UTC(),
+	}
+	if req.connID != "" {
+		id := req.connID
+		denial.ConnectionID = &id
+	}
+	if ruleID != "" {
+		denial.RuleID = &ruleID
+	}
+	s.aclDenialsMu.Lock()
+	if len(s.aclDenials) < aclMaxPending {
+		s.aclDenials = append(s.aclDenials, denial)
+	}
+	s.aclDenialsMu.Unlock()
+}
+
+// aclSyncLoop pulls the policy and reports buffered denials every aclSyncInterval.
+func (s *tunnelServer) aclSyncLoop() {
+	// Every destination is denied until a policy lands, so don't wait a full interval
+	for !s.syncACLPolicy() {
+		time.Sleep(aclFirstRetry)
+	}
+	ticker := time.NewTicker(aclSyncInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.syncACLPolicy()
+		s.flushACLDenials()
+	}
+}
+
+// syncACLPolicy replaces the policy with a fresh snapshot. On failure the
+// previous one stays in force.
+func (s *tunnelServer) syncACLPolicy() bool {
+	resp, err := s.relayRequest(http.MethodGet, "/api/internal/acl-policy", nil)
+	if err != nil {
+		log.Printf("[acl] policy sync failed: %v", err)
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("[acl] policy sync failed: status %d", resp.StatusCode)
+		return false
+	}
+
+	var raw struct {
+		Global      []aclRuleJSON            `json:"global"`
+		Connections map[string][]aclRuleJSON `json:"connections"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		log.Printf("[acl] policy decode failed: %v", err)
+		return false
+	}
+
+	p := &aclPolicy{
+		global: compileRuleSet(raw.Global),
+		conns:  make(map[string]*aclRuleSet, len(raw.Connections)),
+	}
+	for id, rules := range raw.Connections {
+		rs := compileRuleSet(rules)
+		p.conns[id] = &rs
+	}
+	s.acl.Store(p)
+	return true
+}
+
+func (s *tunnelServer) flushACLDenials() {
+	s.aclDenialsMu.Lock()
+	batch := s.aclDenials
+	s.aclDenials = nil
+	s.aclDeniedFlows = nil
+	s.aclDenialsMu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+
+	body, _ := json.Marshal(batch)
+	resp, err := s.relayRequest(http.MethodPost, "/api/internal/acl-denials", body)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+	}
+	if err != nil {
+		log.Printf("[acl] denial report failed (%d dropped): %v", len(batch), err)
+		return
+	}
+	log.Printf("[acl] reported %d denials", len(batch))
+}
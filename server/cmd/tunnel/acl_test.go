@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestCompileRuleSet(t *testing.T) {
+	rs := compileRuleSet([]aclRuleJSON{
+		{ID: "p1", Action: "deny", RuleType: "port", Value: "25"},
+		{ID: "p2", Action: "allow", RuleType: "port", Value: "8000-8100"},
+		{ID: "p3", Action: "deny", RuleType: "port", Value: "smtp"},
+		{ID: "c1", Action: "deny", RuleType: "cidr", Value: "10.0.0.0/8"},
+		{ID: "c2", Action: "deny", RuleType: "cidr", Value: "10.0.0.300/8"},
+		{ID: "d1", Action: "allow", RuleType: "domain", Value: "Example.COM"},
+		{ID: "x1", Action: "deny", RuleType: "regex", Value: ".*"},
+	})
+	if len(rs.deny) != 2 || rs.deny[0].id != "p1" || rs.deny[1].id != "c1" {
+		t.Errorf("deny = %+v, want p1 and c1", rs.deny)
+	}
+	if len(rs.allow) != 2 || rs.allow[0].id != "p2" || rs.allow[1].id != "d1" {
+		t.Errorf("allow = %+v, want p2 and d1", rs.allow)
+	}
+	if rs.allow[0].portLo != 8000 || rs.allow[0].portHi != 8100 {
+		t.Errorf("port range = %d-%d, want 8000-8100", rs.allow[0].portLo, rs.allow[0].portHi)
+	}
+	if rs.allow[1].domain != "example.com" {
+		t.Errorf("domain = %q, want it lower-cased", rs.allow[1].domain)
+	}
+	if !rs.hasCIDR {
+		t.Error("hasCIDR = false, want true")
+	}
+	if compileRuleSet([]aclRuleJSON{{ID: "c2", RuleType: "cidr", Value: "bogus"}}).hasCIDR {
+		t.Error("hasCIDR set by a rule that was skipped")
+	}
+}
+
+func TestACLRuleSetEvaluate(t *testing.T) {
+	rs := compileRuleSet([]aclRuleJSON{
+		{ID: "deny-internal", Action: "deny", RuleType: "cidr", Value: "10.0.0.0/8"},
+		{ID: "deny-smtp", Action: "deny", RuleType: "port", Value: "25"},
+		{ID: "deny-ads", Action: "deny", RuleType: "domain", Value: "ads.example.com"},
+		{ID: "allow-example", Action: "allow", RuleType: "domain", Value: "example.com"},
+		{ID: "allow-docs", Action: "allow", RuleType: "cidr", Value: "192.0.2.0/24"},
+		{ID: "allow-v6", Action: "allow", RuleType: "cidr", Value: "2001:db8::/32"},
+	})
+
+	tests := []struct {
+		name   string
+		dest   aclDest
+		want   bool
+		ruleID string
+	}{
+		{"allowed domain", aclDest{host: "example.com", port: 443}, true, "allow-example"},
+		{"allowed subdomain", aclDest{host: "www.example.com", port: 443}, true, "allow-example"},
+		{"suffix is not a subdomain", aclDest{host: "notexample.com", port: 443}, false, ""},
+		{"denied subdomain wins over allow", aclDest{host: "x.ads.example.com", port: 443}, false, "deny-ads"},
+		{"denied port wins over allow", aclDest{host: "example.com", port: 25}, false, "deny-smtp"},
+		{"allowed cidr", aclDest{ip: net.ParseIP("192.0.2.10"), port: 80}, true, "allow-docs"},
+		{"allowed v6 cidr", aclDest{ip: net.ParseIP("2001:db8::5"), port: 80}, true, "allow-v6"},
+		{"denied cidr", aclDest{ip: net.ParseIP("10.1.2.3"), port: 80}, false, "deny-internal"},
+		{"denied cidr under allowed name", aclDest{host: "example.com", ip: net.ParseIP("10.1.2.3"), port: 443}, false, "deny-internal"},
+		{"not on the allow list", aclDest{ip: net.ParseIP("198.51.100.1"), port: 80}, false, ""},
+		{"cidr rules skip unresolved names", aclDest{host: "other.net", port: 80}, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, ruleID := rs.evaluate(&tt.dest)
+			if ok != tt.want || ruleID != tt.ruleID {
+				t.Errorf("evaluate() = %v, %q; want %v, %q", ok, ruleID, tt.want, tt.ruleID)
+			}
+		})
+	}
+
+	var empty aclRuleSet
+	if ok, _ := empty.evaluate(&aclDest{host: "anything.net", port: 1}); !ok {
+		t.Error("an empty rule set denied a destination")
+	}
+}
+
+func TestCheckACL(t *testing.T) {
+	global := compileRuleSet([]aclRuleJSON{
+		{ID: "g-private", Action: "deny", RuleType: "cidr", Value: "10.0.0.0/8"},
+		{ID: "g-smtp", Action: "deny", RuleType: "port", Value: "25"},
+	})
+	connRules := compileRuleSet([]aclRuleJSON{
+		{ID: "c-docs", Action: "allow", RuleType: "cidr", Value: "192.0.2.0/24"},
+		{ID: "c-example", Action: "allow", RuleType: "domain", Value: "example.com"},
+	})
+	domainOnly := compileRuleSet([]aclRuleJSON{
+		{ID: "d-blocked", Action: "deny", RuleType: "domain", Value: "blocked.test"},
+	})
+	policy := &aclPolicy{
+		global: global,
+		conns:  map[string]*aclRuleSet{"conn-a": &connRules},
+	}
+	domainPolicy := &aclPolicy{conns: map[string]*aclRuleSet{"conn-b": &domainOnly}}
+
+	resolveTo := func(addrs ...string) func(string) ([]net.IP, error) {
+		return func(string) ([]net.IP, error) {
+			var ips []net.IP
+			for _, a := range addrs {
+				ips = append(ips, net.ParseIP(a))
+			}
+			return ips, nil
+		}
+	}
+	mustNotResolve := func(host string) ([]net.IP, error) {
+		t.Errorf("resolved %q, want no lookup", host)
+		return nil, errors.New("unexpected lookup")
+	}
+
+	tests := []struct {
+		name       string
+		policy     *aclPolicy
+		req        aclRequest
+		wantOK     bool
+		wantPinned string
+	}{
+		{"no policy yet", nil, aclRequest{host: "example.com", port: 443}, false, ""},
+		{"no destination", policy, aclRequest{port: 443}, false, ""},
+		{"ip denied globally", policy, aclRequest{ip: net.ParseIP("10.0.0.1"), port: 80}, false, ""},
+		{"ip allowed is pinned", policy, aclRequest{ip: net.ParseIP("198.51.100.1"), port: 80}, true, "198.51.100.1"},
+		{"port denied globally", policy, aclRequest{ip: net.ParseIP("198.51.100.1"), port: 25}, false, ""},
+		{"connection allow list", policy, aclRequest{connID: "conn-a", ip: net.ParseIP("192.0.2.9"), port: 443}, true, "192.0.2.9"},
+		{"connection allow list miss", policy, aclRequest{connID: "conn-a", ip: net.ParseIP("198.51.100.1"), port: 443}, false, ""},
+		{"spoofed name resolving to denied range", policy,
+			aclRequest{host: "innocent.test", port: 443, resolve: resolveTo("10.9.9.9")}, false, ""},
+		{"name pinned to first permitted address", policy,
+			aclRequest{host: "mixed.test", port: 443, resolve: resolveTo("10.9.9.9", "198.51.100.4", "198.51.100.5")}, true, "198.51.100.4"},
+		{"connection allow by address", policy,
+			aclRequest{connID: "conn-a", host: "docs.test", port: 443, resolve: resolveTo("198.51.100.4", "192.0.2.7")}, true, "192.0.2.7"},
+		{"connection allow by name", policy,
+			aclRequest{connID: "conn-a", host: "WWW.Example.com", port: 443, resolve: resolveTo("198.51.100.4")}, true, "198.51.100.4"},
+		{"no resolver", policy, aclRequest{host: "example.com", port: 443}, false, ""},
+		{"resolve error", policy,
+			aclRequest{host: "example.com", port: 443, resolve: func(string) ([]net.IP, error) { return nil, errors.New("servfail") }}, false, ""},
+		{"resolve empty", policy, aclRequest{host: "example.com", port: 443, resolve: resolveTo()}, false, ""},
+		{"domain rules need no lookup", domainPolicy,
+			aclRequest{connID: "conn-b", host: "example.com", port: 443, resolve: mustNotResolve}, true, ""},
+		{"domain rule denies", domainPolicy,
+			aclRequest{connID: "conn-b", host: "cdn.blocked.test", port: 443, resolve: mustNotResolve}, false, ""},
+		{"ip not pinned without cidr rules", domainPolicy,
+			aclRequest{connID: "conn-b", ip: net.ParseIP("10.0.0.1"), port: 443}, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &tunnelServer{}
+			if tt.policy != nil {
+				s.acl.Store(tt.policy)
+			}
+			pinned, ok := s.checkACL(tt.req)
+			if ok != tt.wantOK {
+				t.Fatalf("checkACL() allowed = %v, want %v", ok, tt.wantOK)
+			}
+			if tt.wantPinned == "" && pinned != nil || tt.wantPinned != "" && !pinned.Equal(net.ParseIP(tt.wantPinned)) {
+				t.Errorf("pinned = %v, want %q", pinned, tt.wantPinned)
+			}
+			wantDenials := 0
+			if !ok {
+				wantDenials = 1
+			}
+			if len(s.aclDenials) != wantDenials {
+				t.Errorf("%d denials queued, want %d", len(s.aclDenials), wantDenials)
+			}
+		})
+	}
+}
+
+// ipv4Packet builds an IPv4 header (plus the first 4 bytes of a TCP/UDP header).
+func ipv4Packet(proto byte, dst string, port uint16, fragOffset uint16) []byte {
+	pkt := make([]byte, 24)
+	pkt[0] = 0x45
+	binary.BigEndian.PutUint16(pkt[6:], fragOffset)
+	pkt[9] = proto
+	copy(pkt[16:20], net.ParseIP(dst).To4())
+	binary.BigEndian.PutUint16(pkt[22:], port)
+	return pkt
+}
+
+func TestPacketDest(t *testing.T) {
+	tests := []struct {
+		name     string
+		pkt      []byte
+		wantIP   string
+		wantPort uint16
+	}{
+		{"udp", ipv4Packet(17, "192.0.2.1", 53, 0), "192.0.2.1", 53},
+		{"tcp", ipv4Packet(6, "192.0.2.1", 443, 0), "192.0.2.1", 443},
+		{"icmp", ipv4Packet(1, "192.0.2.1", 0, 0), "192.0.2.1", 0},
+		{"later fragment", ipv4Packet(17, "192.0.2.1", 53, 185), "192.0.2.1", 0},
+		{"more fragments flag only", ipv4Packet(17, "192.0.2.1", 53, 0x2000), "192.0.2.1", 53},
+		{"ipv6", append([]byte{0x60}, make([]byte, 39)...), "", 0},
+		{"short", []byte{0x45, 0x00}, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, port := packetDest(tt.pkt)
+			if tt.wantIP == "" && ip != nil || tt.wantIP != "" && !ip.Equal(net.ParseIP(tt.wantIP)) || port != tt.wantPort {
+				t.Errorf("packetDest() = %v, %d; want %q, %d", ip, port, tt.wantIP, tt.wantPort)
+			}
+		})
+	}
+}
+
+func TestCheckPacket(t *testing.T) {
+	rules := compileRuleSet([]aclRuleJSON{
+		{ID: "no-dns", Action: "deny", RuleType: "port", Value: "53"},
+		{ID: "no-private", Action: "deny", RuleType: "cidr", Value: "10.0.0.0/8"},
+	})
+	policy := &aclPolicy{conns: map[string]*aclRuleSet{"conn": &rules}}
+	req := func(dst string, port uint16) aclRequest {
+		return aclRequest{connID: "conn", clientIP: "10.9.0.2", ip: net.ParseIP(dst), port: port}
+	}
+
+	s := &tunnelServer{}
+	if s.checkPacket(req("192.0.2.1", 443)) {
+		t.Error("packet allowed before the first policy sync")
+	}
+	s.acl.Store(policy)
+
+	tests := []struct {
+		name string
+		req  aclRequest
+		want bool
+	}{
+		{"allowed", req("192.0.2.1", 443), true},
+		{"denied port", req("192.0.2.1", 53), false},
+		{"denied cidr", req("10.1.1.1", 123), false},
+		{"other connection", aclRequest{connID: "other", clientIP: "10.9.0.3", ip: net.ParseIP("10.1.1.1"), port: 53}, true},
+	}
+	for _, tt := range tests {
+		if got := s.checkPacket(tt.req); got != tt.want {
+			t.Errorf("%s: checkPacket() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Repeats of a denied flow are dropped without another report
+	s.checkPacket(req("192.0.2.1", 53))
+	if len(s.aclDenials) != 3 {
+		t.Errorf("%d denials queued, want 3 (one per flow)", len(s.aclDenials))
+	}
+}
+
+func TestACLScope(t *testing.T) {
+	connRules := compileRuleSet([]aclRuleJSON{{ID: "r", Action: "deny", RuleType: "port", Value: "25"}})
+	globalRules := compileRuleSet([]aclRuleJSON{{ID: "g", Action: "deny", RuleType: "port", Value: "25"}})
+
+	s := &tunnelServer{}
+	if got := s.aclScope("conn"); got != "global" {
+		t.Errorf("before sync: aclScope() = %q, want global", got)
+	}
+	s.acl.Store(&aclPolicy{conns: map[string]*aclRuleSet{"conn": &connRules}})
+	if got := s.aclScope("conn"); got != "connection" {
+		t.Errorf("aclScope(conn) = %q, want connection", got)
+	}
+	if got := s.aclScope("other"); got != "" {
+		t.Errorf("aclScope(other) = %q, want none", got)
+	}
+	s.acl.Store(&aclPolicy{global: globalRules})
+	if got := s.aclScope("other"); got != "global" {
+		t.Errorf("aclScope with global rules = %q, want global", got)
+	}
+}
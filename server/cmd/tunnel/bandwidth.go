@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// ──────────────────────────────────────────────────────────────────────────────
+// Bandwidth reporting
+//
+// Bytes are metered per connection ID as they are relayed (SOCKS forwarder,
+// proxy gateway, and NAT-routed OpenVPN packets). Every bwReportInterval the
+// counters are swapped to zero and the deltas are queued as a report with the
+// next sequence number. Reports are posted in order to
+// /api/internal/bandwidth-report and kept until acknowledged; the API applies
+// each (relay_id, seq) once, so retries never double count. A report the API
+// rejects outright (4xx) is dropped so it cannot block the ones behind it.
+// With BW_STATE_FILE set the queue is saved after every interval and reloaded
+// on start, so a tunnel restart loses at most the last unflushed interval.
+// ──────────────────────────────────────────────────────────────────────────────
+
+const (
+	bwReportInterval = 30 * time.Second
+	bwMaxPending     = 2880 // ~24h of reports before the oldest are dropped
+)
+
+type bwCounter struct {
+	up   atomic.Int64 // client -> destination
+	down atomic.Int64 // destination -> client
+}
+
+type bwDeltaJSON struct {
+	BytesUp   int64 `json:"bytes_up"`
+	BytesDown int64 `json:"bytes_down"`
+}
+
+type bandwidthReport struct {
+	RelayID       string                 `json:"relay_id"`
+	Seq           int64                  `json:"seq"`
+	IntervalStart time.Time              `json:"interval_start"`
+	IntervalEnd   time.Time              `json:"interval_end"`
+	Connections   map[string]bwDeltaJSON `json:"connections"`
+}
+
+// relayIdentity names this tunnel in bandwidth reports (RELAY_ID, else hostname).
+func relayIdentity() string {
+	if v := os.Getenv("RELAY_ID"); v != "" {
+		return v
+	}
+	if h, err := os.Hostname(); err == nil && h != "" {
+		return h
+	}
+	return "tunnel"
+}
+
+// bwCounter returns the bandwidth counter for a connection, or nil if unknown.
+func (s *tunnelServer) bwCounter(connID string) *bwCounter {
+	s.routingMu.Lock()
+	defer s.routingMu.Unlock()
+	return s.bwCounterLocked(connID)
+}
+
+// bwCounterLocked is bwCounter for callers already holding routingMu.
+func (s *tunnelServer) bwCounterLocked(connID string) *bwCounter {
+	if connID == "" {
+		return nil
+	}
+	c := s.connBandwidth[connID]
+	if c == nil {
+		c = &bwCounter{}
+		s.connBandwidth[connID] = c
+	}
+	return c
+}
+
+// meteredWriter adds every byte written to a counter, after passing the
+// session's quota throttle (if any).
+type meteredWriter struct {
+	w        io.Writer
+	counter  *atomic.Int64
+	throttle func(n int) error
+}
+
+func (m *meteredWriter) Write(p []byte) (int, error) {
+	if m.throttle != nil {
+		if err := m.throttle(len(p)); err != nil {
+			return 0, err
+		}
+	}
+	n, err := m.w.Write(p)
+	if m.counter != nil {
+		m.counter.Add(int64(n))
+	}
+	return n, err
+}
+
+// meter wraps w so bytes written count against the session's connection
+// (up = toward the destination) and are subject to its quota throttle.
+func (sess *session) meter(w io.Writer, up bool) io.Writer {
+	m := &meteredWriter{w: w, throttle: sess.throttle}
+	if sess.bw != nil {
+		if up {
+			m.counter = &sess.bw.up
+		} else {
+			m.counter = &sess.bw.down
+		}
+	}
+	if m.counter == nil && m.throttle == nil {
+		return w
+	}
+	return m
+}
+
+// countUp records n bytes sent upstream outside the relay (replayed or
+// rewritten handshake bytes).
+func (sess *session) countUp(n int) {
+	sess.bytesUp += int64(n)
+	if sess.bw != nil {
+		sess.bw.up.Add(int64(n))
+	}
+}
+
+// bandwidthReportLoop queues and sends a report every bwReportInterval.
+func (s *tunnelServer) bandwidthReportLoop() {
+	s.loadBandwidthReports()
+	ticker := time.NewTicker(bwReportInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.queueBandwidthReport()
+		s.sendBandwidthReports()
+		s.saveBandwidthReports()
+	}
+}
+
+// loadBandwidthReports restores the queue saved by a previous run.
+func (s *tunnelServer) loadBandwidthReports() {
+	if s.bwStateFile == "" {
+		return
+	}
+	data, err := os.ReadFile(s.bwStateFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("[bandwidth] read %s: %v", s.bwStateFile, err)
+		}
+		return
+	}
+	var pending []bandwidthReport
+	if err := json.Unmarshal(data, &pending); err != nil {
+		log.Printf("[bandwidth] parse %s: %v", s.bwStateFile, err)
+		return
+	}
+	s.bwPending = append(pending, s.bwPending...)
+	for _, r := range pending {
+		if r.Seq > s.bwSeq {
+			s.bwSeq = r.Seq
+		}
+	}
+	if len(pending) > 0 {
+		log.Printf("[bandwidth] restored %d pending reports from %s", len(pending), s.bwStateFile)
+	}
+}
+
+// saveBandwidthReports writes the queue to BW_STATE_FILE (via a temp file and
+// rename, so a crash never leaves a partial file).
+func (s *tunnelServer) saveBandwidthReports() {
+	if s.bwStateFile == "" {
+		return
+	}
+	data, _ := json.Marshal(s.bwPending)
+	tmp := s.bwStateFile + ".tmp"
+	err := os.WriteFile(tmp, data, 0o600)
+	if err == nil {
+		err = os.Rename(tmp, s.bwStateFile)
+	}
+	if err != nil {
+		log.Printf("[bandwidth] save %s: %v", s.bwStateFile, err)
+	}
+}
+
+// queueBandwidthReport swaps all counters to zero and queues the deltas.
+// Only the report goroutine touches bwSeq/bwPending/bwLast.
+func (s *tunnelServer) queueBandwidthReport() {
+	now := time.Now().UTC()
+	deltas := make(map[string]bwDeltaJSON)
+	s.routingMu.Lock()
+	for id, c := range s.connBandwidth {
+		up, down := c.up.Swap(0), c.down.Swap(0)
+		if up != 0 || down != 0 {
+			deltas[id] = bwDeltaJSON{BytesUp: up, BytesDown: down}
+		}
+	}
+	s.routingMu.Unlock()
+
+	start := s.bwLast
+	s.bwLast = now
+	if len(deltas) == 0 {
+		return
+	}
+
+	s.bwSeq++
+	s.bwPending = append(s.bwPending, bandwidthReport{
+		RelayID:       s.relayID,
+		Seq:           s.bwSeq,
+		IntervalStart: start.UTC(),
+		IntervalEnd:   now,
+		Connections:   deltas,
+	})
+	if over := len(s.bwPending) - bwMaxPending; over > 0 {
+		log.Printf("[bandwidth] report queue full, dropping %d oldest reports", over)
+		s.bwPending = s.bwPending[over:]
+	}
+}
+
+// sendBandwidthReports posts pending reports in sequence order. A report the
+// API rejects is dropped; any other failure stops the run so the rest are
+// retried next interval.
+func (s *tunnelServer) sendBandwidthReports() {
+	for len(s.bwPending) > 0 {
+		report := s.bwPending[0]
+		body, _ := json.Marshal(report)
+		resp, err := s.relayRequest(http.MethodPost, "/api/internal/bandwidth-report", body)
+		if err == nil {
+			resp.Body.Close()
+			if bwReportRejected(resp.StatusCode) {
+				log.Printf("[bandwidth] report seq=%d rejected with status %d, dropping it (%d connections)", report.Seq, resp.StatusCode, len(report.Connections))
+				s.bwPending = s.bwPending[1:]
+				continue
+			}
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("status %d", resp.StatusCode)
+			}
+		}
+		if err != nil {
+			log.Printf("[bandwidth] report seq=%d failed (%d pending): %v", report.Seq, len(s.bwPending), err)
+			return
+		}
+		s.bwPending = s.bwPending[1:]
+		log.Printf("[bandwidth] reported seq=%d (%d connections)", report.Seq, len(report.Connections))
+	}
+}
+
+// bwReportRejected reports whether status is a permanent rejection of the
+// report itself. 401 (wrong relay secret), 408 and 429 are the tunnel's or
+// the API's problem, not the report's, so those are retried.
+func bwReportRejected(status int) bool {
+	switch status {
+	case http.StatusUnauthorized, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return status >= 400 && status < 500
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestSendBandwidthReports(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses map[int64]int // seq -> response status (default 200)
+		want     []int64       // seqs still pending afterwards
+	}{
+		{"all acknowledged", nil, nil},
+		{"rejected report dropped", map[int64]int{2: http.StatusBadRequest}, nil},
+		{"server error stops", map[int64]int{2: http.StatusInternalServerError}, []int64{2, 3}},
+		{"bad secret retried", map[int64]int{1: http.StatusUnauthorized}, []int64{1, 2, 3}},
+		{"rate limited retried", map[int64]int{3: http.StatusTooManyRequests}, []int64{3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var report bandwidthReport
+				json.NewDecoder(r.Body).Decode(&report)
+				if status, ok := tt.statuses[report.Seq]; ok {
+					w.WriteHeader(status)
+				}
+			}))
+			defer api.Close()
+
+			s := &tunnelServer{apiURL: api.URL}
+			for seq := int64(1); seq <= 3; seq++ {
+				s.bwPending = append(s.bwPending, bandwidthReport{Seq: seq})
+			}
+			s.sendBandwidthReports()
+
+			var got []int64
+			for _, r := range s.bwPending {
+				got = append(got, r.Seq)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("pending = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("pending = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBandwidthReportsPersist(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bandwidth.json")
+	s := &tunnelServer{bwStateFile: file, bwPending: []bandwidthReport{
+		{RelayID: "relay", Seq: 41, Connections: map[string]bwDeltaJSON{"conn": {BytesUp: 10, BytesDown: 20}}},
+		{RelayID: "relay", Seq: 42},
+	}}
+	s.saveBandwidthReports()
+
+	restarted := &tunnelServer{bwStateFile: file, bwSeq: 7}
+	restarted.loadBandwidthReports()
+	if len(restarted.bwPending) != 2 || restarted.bwPending[0].Connections["conn"].BytesDown != 20 {
+		t.Fatalf("restored %+v, want the saved queue", restarted.bwPending)
+	}
+	if restarted.bwSeq != 42 {
+		t.Errorf("bwSeq = %d, want 42 so new reports follow the restored ones", restarted.bwSeq)
+	}
+
+	missing := &tunnelServer{bwStateFile: filepath.Join(t.TempDir(), "none.json")}
+	missing.loadBandwidthReports()
+	if len(missing.bwPending) != 0 {
+		t.Errorf("restored %d reports from a missing file", len(missing.bwPending))
+	}
+}
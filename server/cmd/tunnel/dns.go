@@ -0,0 +1,820 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ──────────────────────────────────────────────────────────────────────────────
+// DNS-over-device resolver for OpenVPN clients
+//
+// OpenVPN clients are pushed 10.9.0.1 as their resolver, and every UDP/TCP
+// port-53 packet from a mapped client is DNATed to this listener on tun0. Each
+// query is forwarded through the client's device SOCKS5 proxy so lookups
+// egress from the phone's carrier IP instead of the server's:
+//
+//   tcp  (default) — DNS-over-TCP to the upstream via SOCKS5 CONNECT
+//   udp            — SOCKS5 UDP ASSOCIATE relay, falls back to TCP on truncation
+//
+// Answers are cached per device (keyed by device VPN IP) for the record TTL.
+// ──────────────────────────────────────────────────────────────────────────────
+
+const (
+	dnsForwardPort     = 10053 // DNATed port-53 traffic from OpenVPN clients
+	dnsDefaultUpstream = "8.8.8.8:53"
+	dnsQueryTimeout    = 5 * time.Second
+	dnsMaxMsgSize      = 65535
+	dnsMinUDPSize      = 512  // RFC 1035 limit for clients without EDNS0
+	dnsCacheMaxEntries = 4096 // per device
+	dnsCacheMaxTTL     = 300 * time.Second
+	dnsNegativeTTL     = 30 * time.Second
+	dnsTypeA           = 1
+	dnsTypeAAAA        = 28
+	dnsTypeOPT         = 41
+	dnsRcodeServFail   = 2
+	dnsRcodeRefused    = 5
+)
+
+// dnsQueryLog describes one resolved query, passed to every registered hook.
+type dnsQueryLog struct {
+	ClientIP string
+	DeviceIP string
+	Name     string
+	Type     uint16
+	Rcode    int
+	Cached   bool
+	Duration time.Duration
+	Err      error
+}
+
+type dnsQueryHook func(entry dnsQueryLog)
+
+type dnsCacheEntry struct {
+	resp       []byte
+	stored     time.Time
+	expires    time.Time
+	ttlOffsets []int
+}
+
+type dnsResolver struct {
+	srv          *tunnelServer
+	upstreamHost string
+	upstreamPort uint16
+	mode         string // "tcp" or "udp"
+
+	cacheMu sync.Mutex
+	caches  map[string]map[string]*dnsCacheEntry // device VPN IP -> question key -> entry
+
+	hooksMu sync.RWMutex
+	hooks   []dnsQueryHook
+}
+
+func newDNSResolver(srv *tunnelServer) *dnsResolver {
+	upstream := dnsDefaultUpstream
+	if v := os.Getenv("DNS_UPSTREAM"); v != "" {
+		upstream = v
+	}
+	host, portStr, err := net.SplitHostPort(upstream)
+	if err != nil {
+		log.Printf("[dns] invalid DNS_UPSTREAM %q, using %s", upstream, dnsDefaultUpstream)
+		host, portStr, _ = net.SplitHostPort(dnsDefaultUpstream)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	mode := "tcp"
+	if os.Getenv("DNS_FORWARD_MODE") == "udp" {
+		mode = "udp"
+	}
+
+	r := &dnsResolver{
+		srv:          srv,
+		upstreamHost: host,
+		upstreamPort: uint16(port),
+		mode:         mode,
+		caches:       make(map[string]map[string]*dnsCacheEntry),
+	}
+	if os.Getenv("DNS_LOG_QUERIES") == "1" {
+		r.AddHook(func(e dnsQueryLog) {
+			log.Printf("[dns] %s via %s: %s type=%d rcode=%d cached=%v %s err=%v",
+				e.ClientIP, e.DeviceIP, e.Name, e.Type, e.Rcode, e.Cached, e.Duration.Round(time.Millisecond), e.Err)
+		})
+	}
+	return r
+}
+
+// AddHook registers a callback invoked after every query (query logging,
+// metrics, abuse tracking). Hooks run synchronously and must not block.
+func (r *dnsResolver) AddHook(h dnsQueryHook) {
+	r.hooksMu.Lock()
+	r.hooks = append(r.hooks, h)
+	r.hooksMu.Unlock()
+}
+
+func (r *dnsResolver) emit(e dnsQueryLog) {
+	r.hooksMu.RLock()
+	defer r.hooksMu.RUnlock()
+	for _, h := range r.hooks {
+		h(e)
+	}
+}
+
+// FlushDevice drops the cache for a device (called when its routing is torn
+// down, since the VPN IP may be reassigned to another phone).
+func (r *dnsResolver) FlushDevice(deviceVPNIP string) {
+	r.cacheMu.Lock()
+	delete(r.caches, deviceVPNIP)
+	r.cacheMu.Unlock()
+}
+
+// start runs UDP and TCP listeners on the tun0 address.
+func (r *dnsResolver) start() {
+	addr := fmt.Sprintf("%s:%d", tunIP, dnsForwardPort)
+
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		log.Printf("[dns] failed to listen on udp %s: %v", addr, err)
+		return
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("[dns] failed to listen on tcp %s: %v", addr, err)
+		pc.Close()
+		return
+	}
+	log.Printf("[dns] listening on %s (upstream=%s:%d mode=%s)", addr, r.upstreamHost, r.upstreamPort, r.mode)
+
+	go r.serveTCP(ln)
+	r.serveUDP(pc)
+}
+
+func (r *dnsResolver) serveUDP(pc net.PacketConn) {
+	buf := make([]byte, dnsMaxMsgSize)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("[dns] udp read error: %v", err)
+			continue
+		}
+		query := make([]byte, n)
+		copy(query, buf[:n])
+		go func() {
+			clientIP := from.(*net.UDPAddr).IP.String()
+			if resp := r.handleQuery(clientIP, query); resp != nil {
+				pc.WriteTo(truncateDNSResponse(resp, dnsUDPSize(query)), from)
+			}
+		}()
+	}
+}
+
+func (r *dnsResolver) serveTCP(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("[dns] tcp accept error: %v", err)
+			continue
+		}
+		go func() {
+			defer conn.Close()
+			clientIP := conn.RemoteAddr().(*net.TCPAddr).IP.String()
+			for {
+				conn.SetDeadline(time.Now().Add(10 * time.Second))
+				query, err := readDNSTCP(conn)
+				if err != nil {
+					return
+				}
+				resp := r.handleQuery(clientIP, query)
+				if resp == nil || writeDNSTCP(conn, resp) != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// handleQuery resolves one query for a client. Returns nil if the message is
+// too malformed to answer at all.
+func (r *dnsResolver) handleQuery(clientIP string, query []byte) []byte {
+	start := time.Now()
+	q, qEnd, err := parseDNSQuestion(query)
+	if err != nil {
+		return nil
+	}
+	entry := dnsQueryLog{ClientIP: clientIP, Name: q.name, Type: q.qtype}
+
+	r.srv.routingMu.Lock()
+	deviceIP, ok := r.srv.clientToDevice[clientIP]
+	auth := r.srv.clientSocksAuth[clientIP]
+	r.srv.routingMu.Unlock()
+	if !ok {
+		entry.Rcode = dnsRcodeRefused
+		entry.Err = errors.New("no device mapping")
+		entry.Duration = time.Since(start)
+		r.emit(entry)
+		return dnsErrorResponse(query, qEnd, dnsRcodeRefused)
+	}
+	entry.DeviceIP = deviceIP
+
+	key := q.key()
+	if resp := r.cacheGet(deviceIP, key, query[0:2]); resp != nil {
+		entry.Cached = true
+		entry.Rcode = int(resp[3] & 0x0f)
+		entry.Duration = time.Since(start)
+		r.emit(entry)
+		return resp
+	}
+
+	resp, err := r.exchange(deviceIP, auth, query)
+	entry.Duration = time.Since(start)
+	if err != nil {
+		entry.Rcode = dnsRcodeServFail
+		entry.Err = err
+		r.emit(entry)
+		return dnsErrorResponse(query, qEnd, dnsRcodeServFail)
+	}
+	entry.Rcode = int(resp[3] & 0x0f)
+	r.emit(entry)
+
+	r.cachePut(deviceIP, key, resp)
+	return resp
+}
+
+// exchange forwards a query through the device using the configured mode.
+func (r *dnsResolver) exchange(deviceIP string, auth socksAuth, query []byte) ([]byte, error) {
+	if r.mode == "udp" {
+		resp, err := r.exchangeUDP(deviceIP, auth, query)
+		if err == nil && resp[2]&0x02 == 0 { // not truncated
+			return resp, nil
+		}
+		if err != nil {
+			log.Printf("[dns] udp relay via %s failed, retrying over tcp: %v", deviceIP, err)
+		}
+	}
+	return r.exchangeTCP(deviceIP, auth, query)
+}
+
+func (r *dnsResolver) exchangeTCP(deviceIP string, auth socksAuth, query []byte) ([]byte, error) {
+	return r.exchangeVia(socksDialer(deviceIP, auth), query)
+}
+
+// exchangeVia sends a query as DNS-over-TCP to the upstream over a connection
+// opened by dial.
+func (r *dnsResolver) exchangeVia(dial upstreamDialer, query []byte) ([]byte, error) {
+	conn, err := dial(r.upstreamHost, r.upstreamPort)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dnsQueryTimeout))
+
+	if err := writeDNSTCP(conn, query); err != nil {
+		return nil, fmt.Errorf("write query: %w", err)
+	}
+	resp, err := readDNSTCP(conn)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	return checkDNSResponse(query, resp)
+}
+
+// upstreamDialer opens a TCP connection to host:port through a device proxy.
+type upstreamDialer func(host string, port uint16) (net.Conn, error)
+
+// socksDialer dials through a device's SOCKS5 proxy.
+func socksDialer(deviceIP string, auth socksAuth) upstreamDialer {
+	return func(host string, port uint16) (net.Conn, error) {
+		conn, err := net.DialTimeout("tcp", deviceIP+":1080", dnsQueryTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("dial device: %w", err)
+		}
+		conn.SetDeadline(time.Now().Add(dnsQueryTimeout))
+		if err := socks5Connect(conn, auth.user, auth.pass, host, port); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// LookupHost resolves a hostname through a device, for ACL checks that need
+// a destination's addresses. Answers share the device's query cache. IPv6 is
+// only queried when the name has no IPv4 address.
+func (r *dnsResolver) LookupHost(deviceIP string, dial upstreamDialer, host string) ([]net.IP, error) {
+	for _, qtype := range []uint16{dnsTypeA, dnsTypeAAAA} {
+		ips, err := r.lookup(deviceIP, dial, host, qtype)
+		if err != nil {
+			return nil, err
+		}
+		if len(ips) > 0 {
+			return ips, nil
+		}
+	}
+	return nil, fmt.Errorf("no addresses for %s", host)
+}
+
+func (r *dnsResolver) lookup(deviceIP string, dial upstreamDialer, host string, qtype uint16) ([]net.IP, error) {
+	query, err := buildDNSQuery(uint16(rand.Uint32()), host, qtype)
+	if err != nil {
+		return nil, err
+	}
+	q, _, err := parseDNSQuestion(query)
+	if err != nil {
+		return nil, err
+	}
+	key := q.key()
+	resp := r.cacheGet(deviceIP, key, query[0:2])
+	if resp == nil {
+		if resp, err = r.exchangeVia(dial, query); err != nil {
+			return nil, err
+		}
+		r.cachePut(deviceIP, key, resp)
+	}
+	switch rcode := resp[3] & 0x0f; rcode {
+	case 0:
+		return dnsAnswerIPs(resp, qtype)
+	case 3:
+		return nil, fmt.Errorf("no such host %s", host)
+	default:
+		return nil, fmt.Errorf("lookup %s: rcode %d", host, rcode)
+	}
+}
+
+func (r *dnsResolver) exchangeUDP(deviceIP string, auth socksAuth, query []byte) ([]byte, error) {
+	ctrl, err := net.DialTimeout("tcp", deviceIP+":1080", dnsQueryTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("dial device: %w", err)
+	}
+	defer ctrl.Close()
+	ctrl.SetDeadline(time.Now().Add(dnsQueryTimeout))
+
+	if err := socks5Auth(ctrl, auth.user, auth.pass); err != nil {
+		return nil, err
+	}
+	bndIP, bndPort, err := socks5Request(ctrl, 0x03, "0.0.0.0", 0)
+	if err != nil {
+		return nil, fmt.Errorf("udp associate %w", err)
+	}
+	// 0.0.0.0:0 means "same address/port as the TCP control connection"
+	relayIP := net.ParseIP(deviceIP)
+	if bndIP != nil && !bndIP.IsUnspecified() {
+		relayIP = bndIP
+	}
+	if bndPort == 0 {
+		bndPort = 1080
+	}
+
+	uc, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: relayIP, Port: int(bndPort)})
+	if err != nil {
+		return nil, fmt.Errorf("dial relay: %w", err)
+	}
+	defer uc.Close()
+	uc.SetDeadline(time.Now().Add(dnsQueryTimeout))
+
+	upstreamIP := net.ParseIP(r.upstreamHost).To4()
+	if upstreamIP == nil {
+		return nil, fmt.Errorf("udp mode requires an IPv4 upstream, got %s", r.upstreamHost)
+	}
+	// RSV(2) + FRAG(1) + ATYP(1) + ADDR(4) + PORT(2)
+	pkt := []byte{0x00, 0x00, 0x00, 0x01}
+	pkt = append(pkt, upstreamIP...)
+	pkt = binary.BigEndian.AppendUint16(pkt, r.upstreamPort)
+	pkt = append(pkt, query...)
+	if _, err := uc.Write(pkt); err != nil {
+		return nil, fmt.Errorf("write query: %w", err)
+	}
+
+	buf := make([]byte, dnsMaxMsgSize)
+	n, err := uc.Read(buf)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	hdr, err := socksUDPHeaderLen(buf[:n])
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]byte, n-hdr)
+	copy(resp, buf[hdr:n])
+	return checkDNSResponse(query, resp)
+}
+
+func socksUDPHeaderLen(pkt []byte) (int, error) {
+	if len(pkt) < 4 {
+		return 0, errors.New("short udp relay packet")
+	}
+	var n int
+	switch pkt[3] {
+	case 0x01:
+		n = 4 + 4 + 2
+	case 0x04:
+		n = 4 + 16 + 2
+	case 0x03:
+		if len(pkt) < 5 {
+			return 0, errors.New("short udp relay packet")
+		}
+		n = 4 + 1 + int(pkt[4]) + 2
+	default:
+		return 0, fmt.Errorf("udp relay: unknown address type %d", pkt[3])
+	}
+	if len(pkt) < n {
+		return 0, errors.New("short udp relay packet")
+	}
+	return n, nil
+}
+
+// ── Cache ───────────────────────────────────────────────────────────────────
+
+// cacheGet returns a copy of a cached response with the query's ID and TTLs
+// decremented by the time spent in cache, or nil on miss.
+func (r *dnsResolver) cacheGet(deviceIP, key string, id []byte) []byte {
+	r.cacheMu.Lock()
+	defer r.cacheMu.Unlock()
+
+	cache := r.caches[deviceIP]
+	if cache == nil {
+		return nil
+	}
+	e, ok := cache[key]
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	if now.After(e.expires) {
+		delete(cache, key)
+		return nil
+	}
+
+	resp := make([]byte, len(e.resp))
+	copy(resp, e.resp)
+	copy(resp[0:2], id)
+	elapsed := uint32(now.Sub(e.stored) / time.Second)
+	for _, off := range e.ttlOffsets {
+		ttl := binary.BigEndian.Uint32(resp[off:])
+		if ttl > elapsed {
+			ttl -= elapsed
+		} else {
+			ttl = 0
+		}
+		binary.BigEndian.PutUint32(resp[off:], ttl)
+	}
+	return resp
+}
+
+func (r *dnsResolver) cachePut(deviceIP, key string, resp []byte) {
+	rcode := resp[3] & 0x0f
+	if rcode != 0 && rcode != 3 { // only cache NOERROR and NXDOMAIN
+		return
+	}
+	minTTL, offsets, err := dnsRecordTTLs(resp)
+	if err != nil {
+		return
+	}
+	ttl := dnsNegativeTTL
+	if len(offsets) > 0 {
+		ttl = time.Duration(minTTL) * time.Second
+	}
+	if ttl > dnsCacheMaxTTL {
+		ttl = dnsCacheMaxTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	now := time.Now()
+	stored := make([]byte, len(resp))
+	copy(stored, resp)
+
+	r.cacheMu.Lock()
+	defer r.cacheMu.Unlock()
+	cache := r.caches[deviceIP]
+	if cache == nil {
+		cache = make(map[string]*dnsCacheEntry)
+		r.caches[deviceIP] = cache
+	}
+	if len(cache) >= dnsCacheMaxEntries {
+		for k, e := range cache {
+			if now.After(e.expires) {
+				delete(cache, k)
+			}
+		}
+		if len(cache) >= dnsCacheMaxEntries {
+			return
+		}
+	}
+	cache[key] = &dnsCacheEntry{resp: stored, stored: now, expires: now.Add(ttl), ttlOffsets: offsets}
+}
+
+// ── Client routing rules ────────────────────────────────────────────────────
+
+// setupClientDNS DNATs all port-53 traffic from an OpenVPN client to the
+// resolver. Must run after the catch-all TCP DNAT is inserted so these rules
+// sit above it in PREROUTING.
+func setupClientDNS(clientVPNIP string) {
+	teardownClientDNS(clientVPNIP)
+	target := tunIP + ":" + strconv.Itoa(dnsForwardPort)
+	for _, proto := range []string{"udp", "tcp"} {
+		if out, err := runCmd("iptables", "-t", "nat", "-I", "PREROUTING",
+			"-s", clientVPNIP+"/32", "-p", proto, "--dport", "53", "-j", "DNAT",
+			"--to-destination", target); err != nil {
+			log.Printf("[dns] iptables DNAT %s/53 for %s failed: %s: %v", proto, clientVPNIP, string(out), err)
+		}
+	}
+}
+
+func teardownClientDNS(clientVPNIP string) {
+	target := tunIP + ":" + strconv.Itoa(dnsForwardPort)
+	for _, proto := range []string{"udp", "tcp"} {
+		for {
+			if _, err := runCmd("iptables", "-t", "nat", "-D", "PREROUTING",
+				"-s", clientVPNIP+"/32", "-p", proto, "--dport", "53", "-j", "DNAT",
+				"--to-destination", target); err != nil {
+				break
+			}
+		}
+	}
+}
+
+// ── Wire format helpers ─────────────────────────────────────────────────────
+
+type dnsQuestion struct {
+	name   string
+	qtype  uint16
+	qclass uint16
+}
+
+func (q dnsQuestion) key() string {
+	return q.name + "/" + strconv.Itoa(int(q.qtype)) + "/" + strconv.Itoa(int(q.qclass))
+}
+
+// parseDNSQuestion parses the first question and returns it with the offset
+// just past it.
+func parseDNSQuestion(msg []byte) (dnsQuestion, int, error) {
+	if len(msg) < 12 {
+		return dnsQuestion{}, 0, errors.New("short dns header")
+	}
+	if binary.BigEndian.Uint16(msg[4:6]) != 1 {
+		return dnsQuestion{}, 0, errors.New("expected exactly one question")
+	}
+	name, off, err := readDNSName(msg, 12)
+	if err != nil {
+		return dnsQuestion{}, 0, err
+	}
+	if len(msg) < off+4 {
+		return dnsQuestion{}, 0, errors.New("short dns question")
+	}
+	return dnsQuestion{
+		name:   name,
+		qtype:  binary.BigEndian.Uint16(msg[off:]),
+		qclass: binary.BigEndian.Uint16(msg[off+2:]),
+	}, off + 4, nil
+}
+
+// readDNSName decodes a (possibly compressed) name at off and returns it
+// lower-cased along with the offset after the name at its original position.
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var name []byte
+	end := -1
+	for hops := 0; hops < 128; hops++ {
+		if off >= len(msg) {
+			return "", 0, errors.New("dns name out of bounds")
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			if len(name) == 0 {
+				return ".", end, nil
+			}
+			return string(name), end, nil
+		case l&0xc0 == 0xc0:
+			if off+1 >= len(msg) {
+				return "", 0, errors.New("dns pointer out of bounds")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, errors.New("dns label out of bounds")
+			}
+			if len(name) > 0 {
+				name = append(name, '.')
+			}
+			for _, c := range msg[off+1 : off+1+l] {
+				if c >= 'A' && c <= 'Z' {
+					c += 'a' - 'A'
+				}
+				name = append(name, c)
+			}
+			off += 1 + l
+		}
+	}
+	return "", 0, errors.New("dns name too many labels")
+}
+
+// dnsRecordTTLs walks every resource record and returns the minimum TTL and
+// the byte offsets of each TTL field (OPT pseudo-records excluded).
+func dnsRecordTTLs(msg []byte) (uint32, []int, error) {
+	_, off, err := parseDNSQuestion(msg)
+	if err != nil {
+		return 0, nil, err
+	}
+	count := int(binary.BigEndian.Uint16(msg[6:8])) +
+		int(binary.BigEndian.Uint16(msg[8:10])) +
+		int(binary.BigEndian.Uint16(msg[10:12]))
+
+	var minTTL uint32
+	var offsets []int
+	for i := 0; i < count; i++ {
+		_, off, err = readDNSName(msg, off)
+		if err != nil {
+			return 0, nil, err
+		}
+		if len(msg) < off+10 {
+			return 0, nil, errors.New("short resource record")
+		}
+		rrType := binary.BigEndian.Uint16(msg[off:])
+		rdLen := int(binary.BigEndian.Uint16(msg[off+8:]))
+		if rrType != dnsTypeOPT {
+			ttl := binary.BigEndian.Uint32(msg[off+4:])
+			if len(offsets) == 0 || ttl < minTTL {
+				minTTL = ttl
+			}
+			offsets = append(offsets, off+4)
+		}
+		off += 10 + rdLen
+		if off > len(msg) {
+			return 0, nil, errors.New("resource record out of bounds")
+		}
+	}
+	return minTTL, offsets, nil
+}
+
+// buildDNSQuery builds a recursive query for one name.
+func buildDNSQuery(id uint16, name string, qtype uint16) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > 253 {
+		return nil, fmt.Errorf("invalid dns name %q", name)
+	}
+	msg := make([]byte, 12, 12+len(name)+6)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	msg[2] = 0x01 // RD
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return nil, fmt.Errorf("invalid dns name %q", name)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, 1) // IN
+	return msg, nil
+}
+
+// dnsAnswerIPs returns the addresses of the qtype (A or AAAA) records in the
+// answer section.
+func dnsAnswerIPs(msg []byte, qtype uint16) ([]net.IP, error) {
+	_, off, err := parseDNSQuestion(msg)
+	if err != nil {
+		return nil, err
+	}
+	size := 4
+	if qtype == dnsTypeAAAA {
+		size = 16
+	}
+	var ips []net.IP
+	for i := 0; i < int(binary.BigEndian.Uint16(msg[6:8])); i++ {
+		_, off, err = readDNSName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		if len(msg) < off+10 {
+			return nil, errors.New("short resource record")
+		}
+		rrType := binary.BigEndian.Uint16(msg[off:])
+		rdLen := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+rdLen > len(msg) {
+			return nil, errors.New("resource record out of bounds")
+		}
+		if rrType == qtype && rdLen == size {
+			ip := make(net.IP, size)
+			copy(ip, msg[off:off+rdLen])
+			ips = append(ips, ip)
+		}
+		off += rdLen
+	}
+	return ips, nil
+}
+
+// checkDNSResponse verifies the response matches the query ID.
+func checkDNSResponse(query, resp []byte) ([]byte, error) {
+	if len(resp) < 12 {
+		return nil, errors.New("short dns response")
+	}
+	if resp[0] != query[0] || resp[1] != query[1] {
+		return nil, errors.New("dns response id mismatch")
+	}
+	return resp, nil
+}
+
+// dnsUDPSize returns the largest UDP response the client accepts: the payload
+// size of its EDNS0 OPT record, or 512 bytes without one.
+func dnsUDPSize(query []byte) int {
+	_, off, err := parseDNSQuestion(query)
+	if err != nil {
+		return dnsMinUDPSize
+	}
+	count := int(binary.BigEndian.Uint16(query[6:8])) +
+		int(binary.BigEndian.Uint16(query[8:10])) +
+		int(binary.BigEndian.Uint16(query[10:12]))
+	for i := 0; i < count; i++ {
+		_, off, err = readDNSName(query, off)
+		if err != nil || len(query) < off+10 {
+			return dnsMinUDPSize
+		}
+		if binary.BigEndian.Uint16(query[off:]) == dnsTypeOPT {
+			// The OPT record's CLASS field carries the payload size
+			size := int(binary.BigEndian.Uint16(query[off+2:]))
+			if size < dnsMinUDPSize {
+				return dnsMinUDPSize
+			}
+			return size
+		}
+		off += 10 + int(binary.BigEndian.Uint16(query[off+8:]))
+	}
+	return dnsMinUDPSize
+}
+
+// truncateDNSResponse cuts a response that doesn't fit in limit bytes down to
+// its header and question with the TC bit set, so the client retries over TCP.
+func truncateDNSResponse(resp []byte, limit int) []byte {
+	if len(resp) <= limit {
+		return resp
+	}
+	qEnd := 12
+	qdCount := uint16(0)
+	if _, off, err := parseDNSQuestion(resp); err == nil {
+		qEnd = off
+		qdCount = 1
+	}
+	out := make([]byte, qEnd)
+	copy(out, resp[:qEnd])
+	out[2] |= 0x02 // TC
+	binary.BigEndian.PutUint16(out[4:], qdCount)
+	binary.BigEndian.PutUint16(out[6:], 0)
+	binary.BigEndian.PutUint16(out[8:], 0)
+	binary.BigEndian.PutUint16(out[10:], 0)
+	return out
+}
+
+// dnsErrorResponse builds a header+question-only reply with the given rcode.
+func dnsErrorResponse(query []byte, qEnd int, rcode byte) []byte {
+	resp := make([]byte, qEnd)
+	copy(resp, query[:qEnd])
+	resp[2] = 0x80 | (query[2] & 0x01) // QR=1, preserve RD
+	resp[3] = 0x80 | rcode             // RA=1
+	binary.BigEndian.PutUint16(resp[6:], 0)
+	binary.BigEndian.PutUint16(resp[8:], 0)
+	binary.BigEndian.PutUint16(resp[10:], 0)
+	return resp
+}
+
+func readDNSTCP(conn net.Conn) ([]byte, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(conn, l[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(conn, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func writeDNSTCP(conn net.Conn, msg []byte) error {
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	_, err := conn.Write(buf)
+	return err
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+// dnsRR encodes a resource record whose name points at the question.
+func dnsRR(rrType uint16, ttl uint32, rdata []byte) []byte {
+	rr := []byte{0xc0, 0x0c}
+	rr = binary.BigEndian.AppendUint16(rr, rrType)
+	rr = binary.BigEndian.AppendUint16(rr, 1)
+	rr = binary.BigEndian.AppendUint32(rr, ttl)
+	rr = binary.BigEndian.AppendUint16(rr, uint16(len(rdata)))
+	return append(rr, rdata...)
+}
+
+// dnsOPT encodes an EDNS0 OPT pseudo-record advertising size.
+func dnsOPT(size uint16) []byte {
+	rr := []byte{0x00}
+	rr = binary.BigEndian.AppendUint16(rr, dnsTypeOPT)
+	rr = binary.BigEndian.AppendUint16(rr, size)
+	return append(rr, 0, 0, 0, 0, 0, 0)
+}
+
+// dnsTestQuery encodes a recursive A query for name.
+func dnsTestQuery(name string) []byte {
+	msg := []byte{0x12, 0x34, 0x01, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(name, ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0x00, 0x00, 0x01, 0x00, 0x01)
+}
+
+// dnsReply turns query into a response carrying the answer records.
+func dnsReply(query []byte, answers ...[]byte) []byte {
+	resp := append([]byte(nil), query...)
+	resp[2] |= 0x80
+	binary.BigEndian.PutUint16(resp[6:], uint16(len(answers)))
+	for _, a := range answers {
+		resp = append(resp, a...)
+	}
+	return resp
+}
+
+func TestBuildDNSQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"simple", "example.com", "example.com", false},
+		{"trailing dot", "Example.com.", "example.com", false},
+		{"empty", "", "", true},
+		{"root", ".", "", true},
+		{"empty label", "example..com", "", true},
+		{"long label", strings.Repeat("a", 64) + ".com", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := buildDNSQuery(7, tt.in, dnsTypeAAAA)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("buildDNSQuery(%q) succeeded, want error", tt.in)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("buildDNSQuery(%q): %v", tt.in, err)
+			}
+			question, end, err := parseDNSQuestion(q)
+			if err != nil {
+				t.Fatalf("parseDNSQuestion: %v", err)
+			}
+			if question.name != tt.want || question.qtype != dnsTypeAAAA || question.qclass != 1 || end != len(q) {
+				t.Errorf("parsed %+v (end %d of %d), want %s AAAA IN", question, end, len(q), tt.want)
+			}
+		})
+	}
+}
+
+func TestReadDNSNamePointerLoop(t *testing.T) {
+	msg := make([]byte, 14)
+	msg[12], msg[13] = 0xc0, 0x0c // points at itself
+	if _, _, err := readDNSName(msg, 12); err == nil {
+		t.Error("readDNSName on a pointer loop succeeded, want error")
+	}
+}
+
+func TestDNSAnswerIPs(t *testing.T) {
+	qA := dnsTestQuery("example.com")
+	qAAAA, err := buildDNSQuery(0x1234, "example.com", dnsTypeAAAA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v6 := net.ParseIP("2001:db8::1")
+	cname := []byte{3, 'w', 'w', 'w', 0xc0, 0x0c}
+
+	truncated := dnsReply(qA, dnsRR(dnsTypeA, 60, []byte{192, 0, 2, 1}))
+	truncated = truncated[:len(truncated)-2]
+
+	tests := []struct {
+		name    string
+		msg     []byte
+		qtype   uint16
+		want    []string
+		wantErr bool
+	}{
+		{"no answers", dnsReply(qA), dnsTypeA, nil, false},
+		{"a records", dnsReply(qA, dnsRR(dnsTypeA, 60, []byte{192, 0, 2, 1}), dnsRR(dnsTypeA, 60, []byte{192, 0, 2, 2})), dnsTypeA, []string{"192.0.2.1", "192.0.2.2"}, false},
+		{"cname then a", dnsReply(qA, dnsRR(5, 60, cname), dnsRR(dnsTypeA, 60, []byte{198, 51, 100, 7})), dnsTypeA, []string{"198.51.100.7"}, false},
+		{"aaaa record", dnsReply(qAAAA, dnsRR(dnsTypeAAAA, 60, v6)), dnsTypeAAAA, []string{"2001:db8::1"}, false},
+		{"wrong rdata size", dnsReply(qA, dnsRR(dnsTypeA, 60, []byte{192, 0, 2})), dnsTypeA, nil, false},
+		{"truncated record", truncated, dnsTypeA, nil, true},
+		{"short header", []byte{0x12, 0x34}, dnsTypeA, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ips, err := dnsAnswerIPs(tt.msg, tt.qtype)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("dnsAnswerIPs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(ips) != len(tt.want) {
+				t.Fatalf("dnsAnswerIPs() = %v, want %v", ips, tt.want)
+			}
+			for i, ip := range ips {
+				if !ip.Equal(net.ParseIP(tt.want[i])) {
+					t.Errorf("ips[%d] = %s, want %s", i, ip, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDNSUDPSize(t *testing.T) {
+	withOPT := func(size uint16) []byte {
+		q := append(dnsTestQuery("example.com"), dnsOPT(size)...)
+		binary.BigEndian.PutUint16(q[10:], 1)
+		return q
+	}
+	badOPT := withOPT(4096)
+	badOPT = badOPT[:len(badOPT)-4]
+
+	tests := []struct {
+		name  string
+		query []byte
+		want  int
+	}{
+		{"no edns", dnsTestQuery("example.com"), dnsMinUDPSize},
+		{"edns 4096", withOPT(4096), 4096},
+		{"edns 1232", withOPT(1232), 1232},
+		{"edns below minimum", withOPT(256), dnsMinUDPSize},
+		{"truncated opt", badOPT, dnsMinUDPSize},
+		{"garbage", []byte{1, 2, 3}, dnsMinUDPSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dnsUDPSize(tt.query); got != tt.want {
+				t.Errorf("dnsUDPSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncateDNSResponse(t *testing.T) {
+	q := dnsTestQuery("example.com")
+	var answers [][]byte
+	for i := 0; i < 40; i++ {
+		answers = append(answers, dnsRR(dnsTypeA, 60, []byte{192, 0, 2, byte(i)}))
+	}
+	large := dnsReply(q, answers...) // 40 * 16 bytes of answers
+
+	tests := []struct {
+		name      string
+		resp      []byte
+		limit     int
+		truncated bool
+	}{
+		{"fits", dnsReply(q, answers[0]), dnsMinUDPSize, false},
+		{"exactly at limit", large, len(large), false},
+		{"over 512", large, dnsMinUDPSize, true},
+		{"fits edns size", large, 4096, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateDNSResponse(tt.resp, tt.limit)
+			if !tt.truncated {
+				if !bytes.Equal(got, tt.resp) {
+					t.Error("response changed, want it untouched")
+				}
+				return
+			}
+			if len(got) > tt.limit {
+				t.Errorf("len = %d, want at most %d", len(got), tt.limit)
+			}
+			if got[2]&0x02 == 0 {
+				t.Error("TC bit not set")
+			}
+			if !bytes.Equal(got[:2], tt.resp[:2]) {
+				t.Error("id changed")
+			}
+			if qd := binary.BigEndian.Uint16(got[4:]); qd != 1 {
+				t.Errorf("qdcount = %d, want 1", qd)
+			}
+			for _, off := range []int{6, 8, 10} {
+				if n := binary.BigEndian.Uint16(got[off:]); n != 0 {
+					t.Errorf("count at %d = %d, want 0", off, n)
+				}
+			}
+			if question, end, err := parseDNSQuestion(got); err != nil || question.name != "example.com" || end != len(got) {
+				t.Errorf("parseDNSQuestion() = %+v, %d, %v; want the question only", question, end, err)
+			}
+		})
+	}
+
+	t.Run("unparseable question", func(t *testing.T) {
+		junk := make([]byte, 600)
+		binary.BigEndian.PutUint16(junk[4:], 3)
+		got := truncateDNSResponse(junk, dnsMinUDPSize)
+		if len(got) != 12 || got[2]&0x02 == 0 || binary.BigEndian.Uint16(got[4:]) != 0 {
+			t.Errorf("got %d bytes %x, want a bare header with TC set", len(got), got)
+		}
+	})
+}
@@ -0,0 +1,760 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ──────────────────────────────────────────────────────────────────────────────
+// Proxy gateway for HTTP/SOCKS5 connection ports
+//
+// TCP to a connection's external port is DNATed here instead of straight to the
+// device. The gateway dials the device's proxy (8080 HTTP / 1080 SOCKS5) and
+// passes the client's handshake through byte-for-byte, parsing just enough to
+// learn the credential and destination:
+//
+//   SOCKS5 — greeting, RFC 1929 auth and the CONNECT request
+//   HTTP   — the request head (CONNECT authority or absolute URI)
+//
+// The device still authenticates the client; the gateway only adds policy.
+// UDP (SOCKS5 UDP relay) keeps its direct DNAT to the device, so BIND and UDP
+// ASSOCIATE are refused for connections that have ACL rules.
+// ──────────────────────────────────────────────────────────────────────────────
+
+const (
+	gatewayPort             = 12346 // DNATed TCP from connection ports
+	gatewayHandshakeTimeout = 30 * time.Second
+	gatewayMaxHeadSize      = 16 * 1024
+)
+
+// gatewayRoute maps an external port to the device proxy behind it.
+// connID is empty for the legacy device-wide base ports, where the connection
+// is identified from the credential in the handshake instead.
+type gatewayRoute struct {
+	deviceIP  string
+	devPort   int
+	proxyType string // "http" or "socks5"
+	connID    string
+	username  string
+}
+
+func gatewayTarget() string {
+	return tunIP + ":" + strconv.Itoa(gatewayPort)
+}
+
+func (s *tunnelServer) registerGatewayRoute(extPort int, route gatewayRoute) {
+	s.routingMu.Lock()
+	s.gatewayRoutes[extPort] = route
+	if route.connID != "" && route.username != "" {
+		if s.deviceConns[route.deviceIP] == nil {
+			s.deviceConns[route.deviceIP] = make(map[string]string)
+		}
+		s.deviceConns[route.deviceIP][route.username] = route.connID
+	}
+	s.routingMu.Unlock()
+}
+
+func (s *tunnelServer) unregisterGatewayRoute(extPort int) {
+	s.routingMu.Lock()
+	if route, ok := s.gatewayRoutes[extPort]; ok {
+		if conns := s.deviceConns[route.deviceIP]; conns != nil && route.username != "" {
+			delete(conns, route.username)
+		}
+		delete(s.gatewayRoutes, extPort)
+	}
+	s.routingMu.Unlock()
+}
+
+// connIDForRoute resolves the connection for a gateway session from the
+// username the client authenticated with. A per-connection port only accepts
+// its own connection's credential (ok is false for any other, which the
+// device might still accept); device-wide ports look the username up.
+func (s *tunnelServer) connIDForRoute(route gatewayRoute, username string) (string, bool) {
+	if route.connID != "" {
+		return route.connID, username != "" && username == route.username
+	}
+	s.routingMu.Lock()
+	defer s.routingMu.Unlock()
+	return s.deviceConns[route.deviceIP][username], true
+}
+
+func (s *tunnelServer) startGateway() {
+	addr := gatewayTarget()
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("[gateway] failed to listen on %s: %v", addr, err)
+	}
+	log.Printf("[gateway] listening on %s", addr)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Printf("[gateway] accept error: %v", err)
+			continue
+		}
+		go s.handleGatewayConn(conn)
+	}
+}
+
+func (s *tunnelServer) handleGatewayConn(conn net.Conn) {
+	defer conn.Close()
+
+	_, extPort, err := getOriginalDst(conn)
+	if err != nil {
+		log.Printf("[gateway] getOriginalDst failed: %v", err)
+		return
+	}
+
+	s.routingMu.Lock()
+	route, ok := s.gatewayRoutes[int(extPort)]
+	s.routingMu.Unlock()
+	if !ok {
+		log.Printf("[gateway] no route for port %d", extPort)
+		return
+	}
+
+	clientIP := conn.RemoteAddr().(*net.TCPAddr).IP.String()
+	sess := newSession(route.proxyType, clientIP)
+	sess.connID, sess.username = route.connID, route.username
+	defer s.endSession(sess)
+
+	devAddr := net.JoinHostPort(route.deviceIP, strconv.Itoa(route.devPort))
+	upstream, err := net.DialTimeout("tcp", devAddr, 10*time.Second)
+	if err != nil {
+		log.Printf("[gateway] dial %s failed: %v", devAddr, err)
+		sess.reason = closeDialFailed
+		return
+	}
+	defer upstream.Close()
+	if tc, ok := upstream.(*net.TCPConn); ok {
+		tc.SetNoDelay(true)
+	}
+
+	br := bufio.NewReaderSize(conn, gatewayMaxHeadSize)
+
+	conn.SetDeadline(time.Now().Add(gatewayHandshakeTimeout))
+	upstream.SetDeadline(time.Now().Add(gatewayHandshakeTimeout))
+	var release func()
+	clientR := io.Reader(br)
+	if route.proxyType == "socks5" {
+		release, err = s.gatewaySOCKS5(conn, br, upstream, route, sess)
+	} else {
+		release, clientR, err = s.gatewayHTTP(conn, br, upstream, route, sess)
+	}
+	if err != nil {
+		log.Printf("[gateway] port %d (%s) handshake from %s failed: %v", extPort, route.proxyType, clientIP, err)
+		sess.reason = closeHandshake
+		return
+	}
+	if release == nil {
+		return
+	}
+	defer release()
+	conn.SetDeadline(time.Time{})
+	upstream.SetDeadline(time.Time{})
+
+	relayConns(conn, clientR, upstream, sess)
+}
+
+// gatewaySOCKS5 relays the SOCKS5 negotiation between client and device,
+// checking the CONNECT destination against the ACL and admitting the session
+// against the connection's limits before forwarding the request. It returns
+// the session's release func, or nil if the session was refused (with
+// sess.reason set).
+func (s *tunnelServer) gatewaySOCKS5(conn net.Conn, br *bufio.Reader, upstream net.Conn, route gatewayRoute, sess *session) (func(), error) {
+	// Greeting: VER NMETHODS METHODS...
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return nil, fmt.Errorf("greeting read: %w", err)
+	}
+	if hdr[0] != 0x05 {
+		return nil, fmt.Errorf("not socks5: version %d", hdr[0])
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(br, methods); err != nil {
+		return nil, fmt.Errorf("greeting read: %w", err)
+	}
+	if _, err := upstream.Write(append(hdr, methods...)); err != nil {
+		return nil, fmt.Errorf("greeting write: %w", err)
+	}
+
+	sel := make([]byte, 2)
+	if _, err := io.ReadFull(upstream, sel); err != nil {
+		return nil, fmt.Errorf("method read: %w", err)
+	}
+	if _, err := conn.Write(sel); err != nil {
+		return nil, err
+	}
+	if sel[1] == 0xff {
+		sess.reason = closeAuthFailed
+		return nil, nil
+	}
+
+	// RFC 1929: VER ULEN UNAME PLEN PASSWD
+	var username, password string
+	if sel[1] == 0x02 {
+		auth := make([]byte, 2)
+		if _, err := io.ReadFull(br, auth); err != nil {
+			return nil, fmt.Errorf("auth read: %w", err)
+		}
+		uname := make([]byte, auth[1])
+		if _, err := io.ReadFull(br, uname); err != nil {
+			return nil, fmt.Errorf("auth read: %w", err)
+		}
+		plen, err := br.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("auth read: %w", err)
+		}
+		passwd := make([]byte, plen)
+		if _, err := io.ReadFull(br, passwd); err != nil {
+			return nil, fmt.Errorf("auth read: %w", err)
+		}
+		username, password = string(uname), string(passwd)
+		if _, ok := s.connIDForRoute(route, username); !ok {
+			// Another connection's credential: fail auth before the device sees it
+			conn.Write([]byte{0x01, 0x01})
+			sess.username = username
+			sess.reason = closeAuthFailed
+			return nil, nil
+		}
+
+		msg := append(append(append(auth, uname...), plen), passwd...)
+		if _, err := upstream.Write(msg); err != nil {
+			return nil, fmt.Errorf("auth write: %w", err)
+		}
+		status := make([]byte, 2)
+		if _, err := io.ReadFull(upstream, status); err != nil {
+			return nil, fmt.Errorf("auth status read: %w", err)
+		}
+		if _, err := conn.Write(status); err != nil {
+			return nil, err
+		}
+		if status[1] != 0x00 {
+			sess.reason = closeAuthFailed
+			return nil, nil
+		}
+	}
+
+	// Request: VER CMD RSV ATYP DST.ADDR DST.PORT
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(br, req); err != nil {
+		return nil, fmt.Errorf("request read: %w", err)
+	}
+	var host string
+	var ip net.IP
+	switch req[3] {
+	case 0x01, 0x04:
+		n := 4
+		if req[3] == 0x04 {
+			n = 16
+		}
+		addr := make([]byte, n)
+		if _, err := io.ReadFull(br, addr); err != nil {
+			return nil, fmt.Errorf("request read: %w", err)
+		}
+		req = append(req, addr...)
+		ip = net.IP(addr)
+	case 0x03:
+		l, err := br.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("request read: %w", err)
+		}
+		name := make([]byte, l)
+		if _, err := io.ReadFull(br, name); err != nil {
+			return nil, fmt.Errorf("request read: %w", err)
+		}
+		req = append(append(req, l), name...)
+		host = string(name)
+	default:
+		return nil, fmt.Errorf("unknown address type %d", req[3])
+	}
+	portBuf := make([]byte, 2)
+	if _, err := io.ReadFull(br, portBuf); err != nil {
+		return nil, fmt.Errorf("request read: %w", err)
+	}
+	req = append(req, portBuf...)
+	port := uint16(portBuf[0])<<8 | uint16(portBuf[1])
+
+	connID, ok := s.connIDForRoute(route, username)
+	sess.connID, sess.username = connID, username
+	if !ok {
+		// No credential on a per-connection port (the device didn't ask for one)
+		conn.Write([]byte{0x05, 0x02, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		sess.reason = closeAuthFailed
+		return nil, nil
+	}
+	sess.bw = s.bwCounter(connID)
+	sess.setDest(host, ip, port)
+	if req[1] == 0x01 { // CONNECT
+		dial := socksDialer(route.deviceIP, socksAuth{user: username, pass: password})
+		pinned, allowed := s.checkACL(aclRequest{
+			connID:   connID,
+			username: username,
+			clientIP: sess.clientIP,
+			host:     host,
+			ip:       ip,
+			port:     port,
+			resolve: func(h string) ([]net.IP, error) {
+				return s.dns.LookupHost(route.deviceIP, dial, h)
+			},
+		})
+		if !allowed {
+			// REP 0x02: connection not allowed by ruleset
+			conn.Write([]byte{0x05, 0x02, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+			sess.reason = closeACLDenied
+			return nil, nil
+		}
+		if pinned != nil && host != "" {
+			req = socks5PinnedRequest(req[:3], pinned, port)
+		}
+	} else if scope := s.aclScope(connID); scope != "" {
+		// BIND and UDP ASSOCIATE traffic doesn't pass through the gateway, so
+		// it can't be checked: refuse them for connections under ACL rules
+		s.denyACL(aclRequest{
+			connID:   connID,
+			username: username,
+			clientIP: sess.clientIP,
+			host:     host,
+			ip:       ip,
+			port:     port,
+		}, scope, "", fmt.Sprintf("SOCKS5 command %d not permitted under ACL rules", req[1]))
+		conn.Write([]byte{0x05, 0x02, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		sess.reason = closeACLDenied
+		return nil, nil
+	}
+
+	release, reason := s.admitSession(connID)
+	if release == nil {
+		log.Printf("[gateway] %s (conn=%s user=%s) rejected: %s limit", sess.clientIP, connID, username, reason)
+		sess.reason = closeLimitPrefix + reason
+		// REP 0x01: general SOCKS server failure
+		conn.Write([]byte{0x05, 0x01, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return nil, nil
+	}
+	sess.throttle = s.quotaThrottle(connID)
+
+	if _, err := upstream.Write(req); err != nil {
+		release()
+		return nil, fmt.Errorf("request write: %w", err)
+	}
+	return release, nil
+}
+
+// gatewayHTTP reads the first request head, checks its destination and
+// forwards it. Plain (non-CONNECT) requests are forced to Connection: close and
+// only that request's body is relayed after the head: pipelined requests are
+// dropped, so every request on this port opens a new, separately checked
+// connection. It returns the session's release func and the reader to relay
+// the client side from, or nil if the request was refused (with sess.reason
+// set).
+func (s *tunnelServer) gatewayHTTP(conn net.Conn, br *bufio.Reader, upstream net.Conn, route gatewayRoute, sess *session) (func(), io.Reader, error) {
+	head, err := readHTTPHead(br)
+	if err != nil {
+		return nil, nil, fmt.Errorf("request head read: %w", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(head), "\r\n\r\n"), "\r\n")
+	parts := strings.SplitN(lines[0], " ", 3)
+	if len(parts) < 3 {
+		return nil, nil, fmt.Errorf("malformed request line %q", lines[0])
+	}
+	method, target := parts[0], parts[1]
+	var body *requestBodyReader
+	if method != "CONNECT" {
+		if body, err = newRequestBodyReader(br, lines); err != nil {
+			msg := "bad request framing\n"
+			fmt.Fprintf(conn, "HTTP/1.1 400 Bad Request\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", len(msg), msg)
+			sess.reason = closeHandshake
+			return nil, nil, nil
+		}
+	}
+
+	var username, proxyAuth, hostHeader string
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "proxy-authorization":
+			username, proxyAuth = basicAuthUser(value), value
+		case "host":
+			hostHeader = value
+		}
+	}
+
+	connID, ok := s.connIDForRoute(route, username)
+	sess.connID, sess.username = connID, username
+	if !ok {
+		body := "proxy authentication required\n"
+		fmt.Fprintf(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Basic realm=\"proxy\"\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", len(body), body)
+		sess.reason = closeAuthFailed
+		return nil, nil, nil
+	}
+	sess.bw = s.bwCounter(connID)
+	host, port := httpProxyDest(method, target, hostHeader)
+	sess.setDest(host, nil, port)
+	var ip net.IP
+	if parsed := net.ParseIP(host); parsed != nil {
+		ip, host = parsed, ""
+	}
+	dial := httpConnectDialer(net.JoinHostPort(route.deviceIP, strconv.Itoa(route.devPort)), proxyAuth)
+	pinned, allowed := s.checkACL(aclRequest{
+		connID:   connID,
+		username: username,
+		clientIP: sess.clientIP,
+		host:     host,
+		ip:       ip,
+		port:     port,
+		resolve: func(h string) ([]net.IP, error) {
+			return s.dns.LookupHost(route.deviceIP, dial, h)
+		},
+	})
+	if !allowed {
+		body := "blocked by proxy policy\n"
+		fmt.Fprintf(conn, "HTTP/1.1 403 Forbidden\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", len(body), body)
+		sess.reason = closeACLDenied
+		return nil, nil, nil
+	}
+	if pinned != nil && host != "" {
+		lines[0] = method + " " + pinnedRequestTarget(method, target, pinned, port) + " " + parts[2]
+		if method == "CONNECT" {
+			head = joinHTTPHead(lines)
+		}
+	}
+
+	release, reason := s.admitSession(connID)
+	if release == nil {
+		log.Printf("[gateway] %s (conn=%s user=%s) rejected: %s limit", sess.clientIP, connID, username, reason)
+		sess.reason = closeLimitPrefix + reason
+		if reason == "quota" {
+			body := "bandwidth quota exceeded\n"
+			fmt.Fprintf(conn, "HTTP/1.1 403 Forbidden\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", len(body), body)
+			return nil, nil, nil
+		}
+		body := "too many connections\n"
+		fmt.Fprintf(conn, "HTTP/1.1 429 Too Many Requests\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nRetry-After: 1\r\nConnection: close\r\n\r\n%s", len(body), body)
+		return nil, nil, nil
+	}
+	sess.throttle = s.quotaThrottle(connID)
+
+	if method != "CONNECT" {
+		head = forceConnectionClose(lines)
+	}
+	if _, err := upstream.Write(head); err != nil {
+		release()
+		return nil, nil, fmt.Errorf("request head write: %w", err)
+	}
+	sess.countUp(len(head))
+	if body != nil {
+		return release, body, nil
+	}
+	return release, br, nil
+}
+
+// requestBodyReader yields one plain request's body, raw as the client sent
+// it, then swallows whatever follows: a pipelined request behind it would reach
+// the device without being checked.
+type requestBodyReader struct {
+	r         *bufio.Reader
+	remaining int64  // body (or current chunk + CRLF) bytes left
+	pending   []byte // chunk framing line not yet returned
+	chunked   bool
+	trailer   bool // past the last chunk, reading trailers
+	done      bool
+}
+
+// newRequestBodyReader frames a request body from its headers. Requests that
+// carry both Content-Length and Transfer-Encoding, or a transfer coding other
+// than chunked, are refused: the device might frame them differently.
+func newRequestBodyReader(br *bufio.Reader, lines []string) (*requestBodyReader, error) {
+	b := &requestBodyReader{r: br}
+	length := ""
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "content-length":
+			if length != "" && length != value {
+				return nil, errors.New("conflicting Content-Length")
+			}
+			length = value
+		case "transfer-encoding":
+			if b.chunked || !strings.EqualFold(value, "chunked") {
+				return nil, fmt.Errorf("unsupported Transfer-Encoding %q", value)
+			}
+			b.chunked = true
+		}
+	}
+	switch {
+	case b.chunked && length != "":
+		return nil, errors.New("both Content-Length and Transfer-Encoding")
+	case length != "":
+		n, err := strconv.ParseInt(length, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid Content-Length %q", length)
+		}
+		b.remaining = n
+		b.done = n == 0
+	case !b.chunked:
+		b.done = true
+	}
+	return b, nil
+}
+
+func (b *requestBodyReader) Read(p []byte) (int, error) {
+	for {
+		if len(b.pending) > 0 {
+			n := copy(p, b.pending)
+			b.pending = b.pending[n:]
+			return n, nil
+		}
+		if b.remaining > 0 {
+			if int64(len(p)) > b.remaining {
+				p = p[:b.remaining]
+			}
+			n, err := b.r.Read(p)
+			b.remaining -= int64(n)
+			b.done = b.remaining == 0 && !b.chunked
+			if err == io.EOF && b.remaining > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+		if b.done {
+			// Hold the client side open until it closes, dropping anything sent
+			if _, err := io.Copy(io.Discard, b.r); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+
+		line, err := b.r.ReadSlice('\n')
+		if err != nil {
+			return 0, err
+		}
+		b.pending = append(b.pending[:0], line...)
+		trimmed := strings.TrimRight(string(line), "\r\n")
+		if b.trailer {
+			b.done = trimmed == ""
+			continue
+		}
+		sizeStr, _, _ := strings.Cut(trimmed, ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeStr), 16, 64)
+		if err != nil || size < 0 {
+			return 0, fmt.Errorf("invalid chunk size %q", trimmed)
+		}
+		if size == 0 {
+			b.trailer = true
+		} else {
+			b.remaining = size + 2 // chunk data + CRLF
+		}
+	}
+}
+
+// readHTTPHead reads up to and including the blank line ending the headers.
+func readHTTPHead(br *bufio.Reader) ([]byte, error) {
+	var head []byte
+	for {
+		line, err := br.ReadSlice('\n')
+		head = append(head, line...)
+		if err != nil {
+			return nil, err
+		}
+		if len(head) > gatewayMaxHeadSize {
+			return nil, fmt.Errorf("request head exceeds %d bytes", gatewayMaxHeadSize)
+		}
+		if bytes.HasSuffix(head, []byte("\r\n\r\n")) {
+			return head, nil
+		}
+	}
+}
+
+// httpProxyDest extracts the destination from a proxy request line.
+func httpProxyDest(method, target, hostHeader string) (string, uint16) {
+	hostport := ""
+	defaultPort := "80"
+	if method == "CONNECT" {
+		hostport = target
+		defaultPort = "443"
+	} else if u, err := url.Parse(target); err == nil && u.Host != "" {
+		hostport = u.Host
+		if u.Scheme == "https" {
+			defaultPort = "443"
+		}
+	} else {
+		hostport = hostHeader
+	}
+	if hostport == "" {
+		return "", 0
+	}
+
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host, portStr = strings.Trim(hostport, "[]"), defaultPort
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0
+	}
+	return host, uint16(port)
+}
+
+func basicAuthUser(value string) string {
+	scheme, cred, ok := strings.Cut(value, " ")
+	if !ok || !strings.EqualFold(scheme, "basic") {
+		return ""
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(cred))
+	if err != nil {
+		return ""
+	}
+	user, _, _ := strings.Cut(string(decoded), ":")
+	return user
+}
+
+// joinHTTPHead rebuilds a request head from its lines.
+func joinHTTPHead(lines []string) []byte {
+	return []byte(strings.Join(lines, "\r\n") + "\r\n\r\n")
+}
+
+// pinnedRequestTarget rewrites a request target to a checked address. The
+// Host header is left alone, so the origin still sees the hostname.
+func pinnedRequestTarget(method, target string, ip net.IP, port uint16) string {
+	hostport := net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+	if method == "CONNECT" {
+		return hostport
+	}
+	if u, err := url.Parse(target); err == nil && u.Host != "" {
+		u.Host = hostport
+		return u.String()
+	}
+	return "http://" + hostport + target // origin-form, destination from Host
+}
+
+// socks5PinnedRequest builds a SOCKS5 request (VER CMD RSV) to a checked
+// address in place of the client's hostname.
+func socks5PinnedRequest(hdr []byte, ip net.IP, port uint16) []byte {
+	req := append([]byte{}, hdr...)
+	if ip4 := ip.To4(); ip4 != nil {
+		req = append(append(req, 0x01), ip4...)
+	} else {
+		req = append(append(req, 0x04), ip.To16()...)
+	}
+	return append(req, byte(port>>8), byte(port))
+}
+
+// httpConnectDialer dials through a device's HTTP proxy with a CONNECT
+// tunnel, authenticating with the client's own Proxy-Authorization.
+func httpConnectDialer(devAddr, proxyAuth string) upstreamDialer {
+	return func(host string, port uint16) (net.Conn, error) {
+		conn, err := net.DialTimeout("tcp", devAddr, dnsQueryTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("dial device: %w", err)
+		}
+		conn.SetDeadline(time.Now().Add(dnsQueryTimeout))
+		target := net.JoinHostPort(host, strconv.Itoa(int(port)))
+		req := "CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n"
+		if proxyAuth != "" {
+			req += "Proxy-Authorization: " + proxyAuth + "\r\n"
+		}
+		if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("connect write: %w", err)
+		}
+		// The upstream only speaks after our query, so nothing past the
+		// reply head can be left behind in the buffered reader.
+		head, err := readHTTPHead(bufio.NewReader(conn))
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("connect read: %w", err)
+		}
+		if f := strings.Fields(string(head)); len(f) < 2 || f[1] != "200" {
+			conn.Close()
+			return nil, fmt.Errorf("connect refused: %q", strings.SplitN(string(head), "\r\n", 2)[0])
+		}
+		return conn, nil
+	}
+}
+
+// forceConnectionClose rebuilds a request head without keep-alive headers.
+func forceConnectionClose(lines []string) []byte {
+	var b bytes.Buffer
+	b.WriteString(lines[0])
+	b.WriteString("\r\n")
+	for _, line := range lines[1:] {
+		name, _, _ := strings.Cut(line, ":")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "connection", "proxy-connection", "keep-alive":
+			continue
+		}
+		b.WriteString(line)
+		b.WriteString("\r\n")
+	}
+	b.WriteString("Connection: close\r\n\r\n")
+	return b.Bytes()
+}
+
+// relayConns copies in both directions until both sides finish. clientR is
+// the (possibly buffered) reader for client, so peeked bytes are not lost.
+// Bytes are metered live into the session's bandwidth counters; totals and the
+// side that closed first are recorded on sess.
+func relayConns(client net.Conn, clientR io.Reader, upstream net.Conn, sess *session) {
+	var once sync.Once
+	closedBy := func(err error, side string) {
+		once.Do(func() {
+			if errors.Is(err, errQuotaExceeded) {
+				sess.reason = closeQuotaExceeded
+			} else if err != nil {
+				sess.reason = closeError
+			} else {
+				sess.reason = side
+			}
+		})
+	}
+
+	var up int64
+	done := make(chan struct{})
+	go func() {
+		n, err := io.Copy(sess.meter(upstream, true), clientR)
+		up = n
+		closedBy(err, closeClientClosed)
+		if tc, ok := upstream.(*net.TCPConn); ok {
+			tc.CloseWrite()
+		}
+		done <- struct{}{}
+	}()
+	down, err := io.Copy(sess.meter(client, false), upstream)
+	closedBy(err, closeUpstreamClosed)
+	if tc, ok := client.(*net.TCPConn); ok {
+		tc.CloseWrite()
+	}
+	<-done
+
+	sess.bytesUp += up
+	sess.bytesDown += down
+}
+
+// newConnGatewayRoute builds the route for a per-connection port.
+func newConnGatewayRoute(deviceIP, proxyType, connID, username string) gatewayRoute {
+	devPort := 8080
+	if proxyType == "socks5" {
+		devPort = 1080
+	}
+	return gatewayRoute{deviceIP: deviceIP, devPort: devPort, proxyType: proxyType, connID: connID, username: username}
+}
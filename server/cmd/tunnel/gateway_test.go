@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestConnIDForRoute(t *testing.T) {
+	s := &tunnelServer{
+		gatewayRoutes: make(map[int]gatewayRoute),
+		deviceConns:   make(map[string]map[string]string),
+	}
+	connPort := newConnGatewayRoute("192.168.255.2", "socks5", "conn-a", "alice")
+	s.registerGatewayRoute(30010, connPort)
+	s.registerGatewayRoute(30011, newConnGatewayRoute("192.168.255.2", "http", "conn-b", "bob"))
+	devicePort := gatewayRoute{deviceIP: "192.168.255.2", devPort: 1080, proxyType: "socks5"}
+
+	tests := []struct {
+		name     string
+		route    gatewayRoute
+		username string
+		wantID   string
+		wantOK   bool
+	}{
+		{"own credential", connPort, "alice", "conn-a", true},
+		{"other connection's credential", connPort, "bob", "conn-a", false},
+		{"no credential", connPort, "", "conn-a", false},
+		{"device port by username", devicePort, "bob", "conn-b", true},
+		{"device port unknown user", devicePort, "mallory", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, ok := s.connIDForRoute(tt.route, tt.username)
+			if id != tt.wantID || ok != tt.wantOK {
+				t.Errorf("connIDForRoute() = %q, %v; want %q, %v", id, ok, tt.wantID, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRequestBodyReader(t *testing.T) {
+	const next = "GET http://denied.test/ HTTP/1.1\r\nHost: denied.test\r\n\r\n"
+	tests := []struct {
+		name    string
+		headers []string
+		stream  string
+		want    string
+		wantErr bool
+	}{
+		{"no body", nil, next, "", false},
+		{"content length", []string{"Content-Length: 5"}, "hello" + next, "hello", false},
+		{"zero length", []string{"Content-Length: 0"}, next, "", false},
+		{"repeated equal length", []string{"Content-Length: 2", "content-length: 2"}, "hi" + next, "hi", false},
+		{"chunked", []string{"Transfer-Encoding: chunked"},
+			"5\r\nhello\r\n6;ext=1\r\n world\r\n0\r\nX-Trailer: 1\r\n\r\n" + next,
+			"5\r\nhello\r\n6;ext=1\r\n world\r\n0\r\nX-Trailer: 1\r\n\r\n", false},
+		{"conflicting lengths", []string{"Content-Length: 2", "Content-Length: 3"}, "", "", true},
+		{"length and chunked", []string{"Content-Length: 2", "Transfer-Encoding: chunked"}, "", "", true},
+		{"other coding", []string{"Transfer-Encoding: gzip, chunked"}, "", "", true},
+		{"negative length", []string{"Content-Length: -1"}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := append([]string{"POST http://example.com/ HTTP/1.1"}, tt.headers...)
+			body, err := newRequestBodyReader(bufio.NewReader(strings.NewReader(tt.stream)), lines)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("newRequestBodyReader() succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newRequestBodyReader(): %v", err)
+			}
+			got, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("relayed %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestBodyReaderTruncated(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+		stream  string
+	}{
+		{"short body", []string{"Content-Length: 10"}, "hello"},
+		{"bad chunk size", []string{"Transfer-Encoding: chunked"}, "zz\r\nhello\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := append([]string{"POST / HTTP/1.1"}, tt.headers...)
+			body, err := newRequestBodyReader(bufio.NewReader(strings.NewReader(tt.stream)), lines)
+			if err != nil {
+				t.Fatalf("newRequestBodyReader(): %v", err)
+			}
+			if _, err := io.ReadAll(body); err == nil {
+				t.Error("read succeeded, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ──────────────────────────────────────────────────────────────────────────────
+// Per-connection session limits
+//
+// The API owns max_concurrent_conns and max_conns_per_second on each proxy
+// connection. The tunnel pulls the limited connections from
+// /api/internal/connection-limits every limitsSyncInterval and admits every
+// new TCP session (SOCKS forwarder + proxy gateway) through admitSession.
+//
+// Concurrency is a simple active-session counter; the rate limit is a token
+// bucket holding at most one second's worth of new sessions. Rejections are
+// counted per connection and reported back as deltas.
+//
+// The same snapshot flags connections that are over their bandwidth quota.
+// Those are either cut (new sessions refused, open sessions and OpenVPN
+// packets dropped) or throttled to throttle_kbps with a byte token bucket.
+// ──────────────────────────────────────────────────────────────────────────────
+
+const limitsSyncInterval = 15 * time.Second
+
+// errQuotaExceeded ends a relay whose connection went over quota with the cut action.
+var errQuotaExceeded = errors.New("bandwidth quota exceeded")
+
+type connLimiter struct {
+	maxConcurrent int
+	perSecond     int
+	active        int
+	tokens        float64
+	lastRefill    time.Time
+
+	// Bandwidth quota: when overQuota, throttleBps > 0 shapes traffic to that
+	// many bytes/sec, otherwise the connection is cut.
+	overQuota      bool
+	throttleBps    float64
+	throttleTokens float64
+	throttleRefill time.Time
+
+	// Rejections since the last report
+	rejectedConcurrent int64
+	rejectedRate       int64
+}
+
+type connLimitsJSON struct {
+	MaxConcurrentConns int  `json:"max_concurrent_conns"`
+	MaxConnsPerSecond  int  `json:"max_conns_per_second"`
+	OverQuota          bool `json:"over_quota"`
+	ThrottleKbps       int  `json:"throttle_kbps"`
+}
+
+type connRejectionsJSON struct {
+	Concurrent int64 `json:"concurrent"`
+	Rate       int64 `json:"rate"`
+}
+
+// idle reports whether the limiter carries no state worth keeping.
+func (l *connLimiter) idle() bool {
+	return l.active == 0 && l.maxConcurrent == 0 && l.perSecond == 0 && !l.overQuota &&
+		l.rejectedConcurrent == 0 && l.rejectedRate == 0
+}
+
+// cut reports whether the connection is over quota without a throttle.
+func (l *connLimiter) cut() bool {
+	return l.overQuota && l.throttleBps == 0
+}
+
+// takeBytes spends n bytes from the throttle bucket and returns how long the
+// caller must wait before sending them. Callers hold limitsMu.
+func (l *connLimiter) takeBytes(n int) time.Duration {
+	now := time.Now()
+	l.throttleTokens += now.Sub(l.throttleRefill).Seconds() * l.throttleBps
+	if l.throttleTokens > l.throttleBps {
+		l.throttleTokens = l.throttleBps
+	}
+	l.throttleRefill = now
+	l.throttleTokens -= float64(n)
+	if l.throttleTokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.throttleTokens / l.throttleBps * float64(time.Second))
+}
+
+// admitSession counts a new session against connID's limits. It returns a
+// release func to call when the session ends, or nil and the reason
+// ("concurrent", "rate" or "quota") if the session must be rejected. Sessions
+// without a known connection are always admitted.
+func (s *tunnelServer) admitSession(connID string) (func(), string) {
+	if connID == "" {
+		return func() {}, ""
+	}
+
+	s.limitsMu.Lock()
+	defer s.limitsMu.Unlock()
+
+	l := s.limiters[connID]
+	if l == nil {
+		l = &connLimiter{}
+		s.limiters[connID] = l
+	}
+
+	if l.cut() {
+		return nil, "quota"
+	}
+	if l.maxConcurrent > 0 && l.active >= l.maxConcurrent {
+		l.rejectedConcurrent++
+		return nil, "concurrent"
+	}
+	if l.perSecond > 0 {
+		now := time.Now()
+		l.tokens += now.Sub(l.lastRefill).Seconds() * float64(l.perSecond)
+		if l.tokens > float64(l.perSecond) {
+			l.tokens = float64(l.perSecond)
+		}
+		l.lastRefill = now
+		if l.tokens < 1 {
+			l.rejectedRate++
+			return nil, "rate"
+		}
+		l.tokens--
+	}
+
+	l.active++
+	released := false
+	return func() {
+		s.limitsMu.Lock()
+		defer s.limitsMu.Unlock()
+		if released {
+			return
+		}
+		released = true
+		l.active--
+		if l.idle() && s.limiters[connID] == l {
+			delete(s.limiters, connID)
+		}
+	}, ""
+}
+
+// quotaThrottle returns the per-write quota check for an admitted session:
+// it blocks while the connection is throttled and fails once it is cut.
+func (s *tunnelServer) quotaThrottle(connID string) func(n int) error {
+	if connID == "" {
+		return nil
+	}
+	return func(n int) error {
+		s.limitsMu.Lock()
+		l := s.limiters[connID]
+		if l == nil || !l.overQuota {
+			s.limitsMu.Unlock()
+			return nil
+		}
+		if l.cut() {
+			s.limitsMu.Unlock()
+			return errQuotaExceeded
+		}
+		wait := l.takeBytes(n)
+		s.limitsMu.Unlock()
+		if wait > 0 {
+			time.Sleep(wait)
+		}
+		return nil
+	}
+}
+
+// allowPacket is the non-blocking quota check for NAT-routed OpenVPN packets:
+// over-quota packets are dropped when cut or when the throttle bucket is empty.
+func (s *tunnelServer) allowPacket(connID string, n int) bool {
+	if connID == "" {
+		return true
+	}
+	s.limitsMu.Lock()
+	defer s.limitsMu.Unlock()
+	l := s.limiters[connID]
+	if l == nil || !l.overQuota {
+		return true
+	}
+	if l.cut() {
+		return false
+	}
+	if l.takeBytes(n) > 0 {
+		l.throttleTokens += float64(n) // dropped, give the bytes back
+		return false
+	}
+	return true
+}
+
+// limitsSyncLoop pulls limits and reports rejection counters every limitsSyncInterval.
+func (s *tunnelServer) limitsSyncLoop() {
+	s.syncConnLimits()
+	ticker := time.NewTicker(limitsSyncInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.syncConnLimits()
+		s.flushRejections()
+	}
+}
+
+func (s *tunnelServer) syncConnLimits() {
+	resp, err := s.relayRequest(http.MethodGet, "/api/internal/connection-limits", nil)
+	if err != nil {
+		log.Printf("[limits] sync failed: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("[limits] sync failed: status %d", resp.StatusCode)
+		return
+	}
+
+	var raw struct {
+		Limits map[string]connLimitsJSON `json:"limits"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		log.Printf("[limits] decode failed: %v", err)
+		return
+	}
+
+	s.limitsMu.Lock()
+	defer s.limitsMu.Unlock()
+	for id, l := range s.limiters {
+		if _, ok := raw.Limits[id]; !ok {
+			l.maxConcurrent, l.perSecond = 0, 0
+			l.overQuota, l.throttleBps = false, 0
+			if l.idle() {
+				delete(s.limiters, id)
+			}
+		}
+	}
+	now := time.Now()
+	for id, lim := range raw.Limits {
+		l := s.limiters[id]
+		if l == nil {
+			l = &connLimiter{}
+			s.limiters[id] = l
+		}
+		if l.perSecond != lim.MaxConnsPerSecond {
+			// New or changed rate: start with a full bucket
+			l.tokens = float64(lim.MaxConnsPerSecond)
+			l.lastRefill = now
+		}
+		l.maxConcurrent = lim.MaxConcurrentConns
+		l.perSecond = lim.MaxConnsPerSecond
+
+		bps := float64(lim.ThrottleKbps) * 1000 / 8
+		if !lim.OverQuota {
+			bps = 0
+		}
+		if lim.OverQuota && (!l.overQuota || l.throttleBps != bps) {
+			log.Printf("[limits] conn %s over quota (throttle_kbps=%d)", id, lim.ThrottleKbps)
+			l.throttleTokens = bps
+			l.throttleRefill = now
+		} else if !lim.OverQuota && l.overQuota {
+			log.Printf("[limits] conn %s back under quota", id)
+		}
+		l.overQuota = lim.OverQuota
+		l.throttleBps = bps
+	}
+}
+
+func (s *tunnelServer) flushRejections() {
+	batch := make(map[string]connRejectionsJSON)
+	s.limitsMu.Lock()
+	for id, l := range s.limiters {
+		if l.rejectedConcurrent == 0 && l.rejectedRate == 0 {
+			continue
+		}
+		batch[id] = connRejectionsJSON{Concurrent: l.rejectedConcurrent, Rate: l.rejectedRate}
+		l.rejectedConcurrent, l.rejectedRate = 0, 0
+		if l.idle() {
+			delete(s.limiters, id)
+		}
+	}
+	s.limitsMu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+
+	for id, r := range batch {
+		log.Printf("[limits] conn %s rejected %d over concurrency, %d over rate", id, r.Concurrent, r.Rate)
+	}
+
+	body, _ := json.Marshal(batch)
+	resp, err := s.relayRequest(http.MethodPost, "/api/internal/connection-rejections", body)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+	}
+	if err != nil {
+		log.Printf("[limits] rejection report failed, will retry: %v", err)
+		// Put the counts back so the next flush retries them
+		s.limitsMu.Lock()
+		for id, r := range batch {
+			l := s.limiters[id]
+			if l == nil {
+				l = &connLimiter{}
+				s.limiters[id] = l
+			}
+			l.rejectedConcurrent += r.Concurrent
+			l.rejectedRate += r.Rate
+		}
+		s.limitsMu.Unlock()
+	}
+}
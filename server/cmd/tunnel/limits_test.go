@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAdmitSession(t *testing.T) {
+	tests := []struct {
+		name    string
+		limiter *connLimiter
+		attempt int
+		want    []string // rejection reason per attempt, "" if admitted
+	}{
+		{"no limits", &connLimiter{}, 3, []string{"", "", ""}},
+		{"concurrent cap", &connLimiter{maxConcurrent: 2}, 3, []string{"", "", "concurrent"}},
+		{"rate cap", &connLimiter{perSecond: 2}, 3, []string{"", "", "rate"}},
+		{"over quota cut", &connLimiter{overQuota: true}, 1, []string{"quota"}},
+		{"over quota throttled", &connLimiter{overQuota: true, throttleBps: 1000}, 1, []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &tunnelServer{limiters: map[string]*connLimiter{"conn": tt.limiter}}
+			for i := 0; i < tt.attempt; i++ {
+				release, reason := s.admitSession("conn")
+				if reason != tt.want[i] {
+					t.Fatalf("attempt %d: reason = %q, want %q", i, reason, tt.want[i])
+				}
+				if (release == nil) != (reason != "") {
+					t.Fatalf("attempt %d: release func = %v with reason %q", i, release != nil, reason)
+				}
+			}
+		})
+	}
+}
+
+func TestAdmitSessionRejectionCounters(t *testing.T) {
+	l := &connLimiter{maxConcurrent: 1}
+	s := &tunnelServer{limiters: map[string]*connLimiter{"conn": l}}
+	release, _ := s.admitSession("conn")
+	s.admitSession("conn")
+	s.admitSession("conn")
+	if l.rejectedConcurrent != 2 {
+		t.Errorf("rejectedConcurrent = %d, want 2", l.rejectedConcurrent)
+	}
+	release()
+	if _, reason := s.admitSession("conn"); reason != "" {
+		t.Errorf("after release: reason = %q, want admitted", reason)
+	}
+}
+
+func TestAdmitSessionReleasesIdleLimiter(t *testing.T) {
+	s := &tunnelServer{limiters: make(map[string]*connLimiter)}
+	release, reason := s.admitSession("conn")
+	if reason != "" {
+		t.Fatalf("reason = %q, want admitted", reason)
+	}
+	if s.limiters["conn"] == nil || s.limiters["conn"].active != 1 {
+		t.Fatal("session not counted")
+	}
+	release()
+	release() // a second release must not go negative
+	if _, ok := s.limiters["conn"]; ok {
+		t.Error("idle limiter kept after release")
+	}
+
+	if release, reason := s.admitSession(""); release == nil || reason != "" {
+		t.Errorf("session without connection: reason = %q, want admitted", reason)
+	}
+	if len(s.limiters) != 0 {
+		t.Error("limiter created for a session without connection")
+	}
+}
+
+func TestTakeBytes(t *testing.T) {
+	tests := []struct {
+		name     string
+		tokens   float64
+		take     int
+		wantWait time.Duration
+	}{
+		{"within bucket", 1000, 500, 0},
+		{"drains bucket", 1000, 1000, 0},
+		{"overdraws half a second", 1000, 1500, 500 * time.Millisecond},
+		{"empty bucket", 0, 2000, 2 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &connLimiter{overQuota: true, throttleBps: 1000, throttleTokens: tt.tokens, throttleRefill: time.Now()}
+			wait := l.takeBytes(tt.take)
+			if d := wait - tt.wantWait; d < -10*time.Millisecond || d > 10*time.Millisecond {
+				t.Errorf("takeBytes(%d) = %v, want about %v", tt.take, wait, tt.wantWait)
+			}
+		})
+	}
+
+	// Refill is capped at one second worth of bytes
+	l := &connLimiter{overQuota: true, throttleBps: 1000, throttleRefill: time.Now().Add(-time.Hour)}
+	if wait := l.takeBytes(1500); wait < 400*time.Millisecond {
+		t.Errorf("takeBytes after an idle hour = %v, want the burst capped at one second", wait)
+	}
+}
+
+func TestQuotaChecks(t *testing.T) {
+	tests := []struct {
+		name       string
+		limiter    *connLimiter
+		wantPacket bool
+		wantErr    error
+	}{
+		{"under quota", &connLimiter{}, true, nil},
+		{"cut", &connLimiter{overQuota: true}, false, errQuotaExceeded},
+		{"throttled with tokens", &connLimiter{overQuota: true, throttleBps: 10000, throttleTokens: 10000, throttleRefill: time.Now()}, true, nil},
+		{"throttled and empty", &connLimiter{overQuota: true, throttleBps: 10000, throttleRefill: time.Now()}, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &tunnelServer{limiters: map[string]*connLimiter{"conn": tt.limiter}}
+			if got := s.allowPacket("conn", 100); got != tt.wantPacket {
+				t.Errorf("allowPacket() = %v, want %v", got, tt.wantPacket)
+			}
+			if tt.wantErr != nil || tt.wantPacket {
+				if err := s.quotaThrottle("conn")(100); !errors.Is(err, tt.wantErr) {
+					t.Errorf("quotaThrottle() = %v, want %v", err, tt.wantErr)
+				}
+			}
+		})
+	}
+
+	s := &tunnelServer{limiters: make(map[string]*connLimiter)}
+	if !s.allowPacket("", 100) || s.quotaThrottle("") != nil {
+		t.Error("traffic without a connection was limited")
+	}
+}
@@ -72,6 +72,9 @@ type tunnelServer struct {
 	tunIface *water.Interface
 	apiURL   string
 
+	// Shared secret sent on /api/internal relay calls (RELAY_SECRET)
+	relaySecret string
+
 	mu      sync.RWMutex
 	clients map[string]*client // vpnIP string -> client
 	addrMap map[string]string  // udpAddr string -> vpnIP string
@@ -91,12 +94,38 @@ type tunnelServer struct {
 	deviceRouteTable   map[string]int              // device VPN IP (192.168.255.x) -> routing table number
 	clientToDevice     map[string]string           // client VPN IP (10.9.0.x) -> device VPN IP (192.168.255.x)
 	clientSocksAuth    map[string]socksAuth        // client VPN IP (10.9.0.x) -> SOCKS5 credentials
-	clientBandwidthUsed  map[string]*atomic.Int64  // client VPN IP -> bytes forwarded
-	clientBandwidthLimit map[string]int64          // client VPN IP -> limit (0=unlimited)
 
-	// Per-port bandwidth tracking for HTTP/SOCKS5 DNAT connections
-	portToUsername    map[int]string    // external port -> connection username
-	portBandwidthAcc map[int]int64     // external port -> accumulated bytes (running total)
+	// Unreported per-connection byte deltas (guarded by routingMu)
+	connBandwidth map[string]*bwCounter // connection ID -> bytes since last report
+
+	// Sequence-numbered bandwidth reports awaiting API acknowledgement
+	relayID     string
+	bwSeq       int64
+	bwPending   []bandwidthReport
+	bwLast      time.Time
+	bwStateFile string // BW_STATE_FILE: where bwPending survives restarts ("" = memory only)
+
+	// DNS-over-device resolver for OpenVPN clients
+	dns *dnsResolver
+
+	// Proxy gateway + ACL identity (guarded by routingMu)
+	gatewayRoutes map[int]gatewayRoute          // external port -> device proxy route
+	deviceConns   map[string]map[string]string  // device VPN IP -> username -> connection ID
+	clientConnID  map[string]string             // client VPN IP (10.9.0.x) -> connection ID
+
+	// Destination ACL policy (pulled from API) and denials pending report
+	acl            atomic.Pointer[aclPolicy]
+	aclDenialsMu   sync.Mutex
+	aclDenials     []aclDenial
+	aclDeniedFlows map[string]bool // NAT-routed flows already reported this interval
+
+	// Per-connection session limits and rejection counters, keyed by connection ID
+	limitsMu sync.Mutex
+	limiters map[string]*connLimiter
+
+	// Finished session records pending report to the API
+	sessionLogsMu sync.Mutex
+	sessionLogs   []sessionRecord
 }
 
 type socksAuth struct {
@@ -115,6 +144,10 @@ func main() {
 	if v := os.Getenv("API_URL"); v != "" {
 		apiURL = v
 	}
+	relaySecret := os.Getenv("RELAY_SECRET")
+	if relaySecret == "" {
+		log.Printf("WARNING: RELAY_SECRET is not set; the API will refuse policy syncs and reports")
+	}
 
 	// Create TUN interface
 	config := water.Config{DeviceType: water.TUN}
@@ -147,6 +180,7 @@ func main() {
 		udpConn:              conn,
 		tunIface:             iface,
 		apiURL:               apiURL,
+		relaySecret:          relaySecret,
 		clients:              make(map[string]*client),
 		addrMap:              make(map[string]string),
 		deviceMap:            make(map[string]*client),
@@ -155,11 +189,17 @@ func main() {
 		deviceRouteTable:     make(map[string]int),
 		clientToDevice:       make(map[string]string),
 		clientSocksAuth:      make(map[string]socksAuth),
-		clientBandwidthUsed:  make(map[string]*atomic.Int64),
-		clientBandwidthLimit: make(map[string]int64),
-		portToUsername:       make(map[int]string),
-		portBandwidthAcc:     make(map[int]int64),
-	}
+		connBandwidth:        make(map[string]*bwCounter),
+		relayID:              relayIdentity(),
+		bwSeq:                time.Now().UnixNano(), // monotonic across restarts
+		bwLast:               time.Now(),
+		bwStateFile:          os.Getenv("BW_STATE_FILE"),
+		gatewayRoutes:        make(map[int]gatewayRoute),
+		deviceConns:          make(map[string]map[string]string),
+		clientConnID:         make(map[string]string),
+		limiters:             make(map[string]*connLimiter),
+	}
+	srv.dns = newDNSResolver(srv)
 
 	// Start goroutines
 	go srv.udpToTun()
@@ -168,6 +208,11 @@ func main() {
 	go srv.tcpAuthListener(port)
 	go srv.startPushAPI()
 	go srv.startSocksForwarder()
+	go srv.dns.start()
+	go srv.startGateway()
+	go srv.aclSyncLoop()
+	go srv.limitsSyncLoop()
+	go srv.sessionLogLoop()
 
 	// Block forever
 	select {}
@@ -478,20 +523,24 @@ func (s *tunnelServer) tunToUdp() {
 		srcIP := net.IPv4(buf[13], buf[14], buf[15], buf[16]).String()
 		s.routingMu.Lock()
 		deviceIP, mapped := s.clientToDevice[srcIP]
-		ctr := s.clientBandwidthUsed[srcIP]
-		limit := s.clientBandwidthLimit[srcIP]
+		connID := s.clientConnID[srcIP]
+		username := s.clientSocksAuth[srcIP].user
+		bw := s.connBandwidth[connID]
 		s.routingMu.Unlock()
 
 		if mapped {
-			// Bandwidth enforcement — atomic increment outside lock
-			var used int64
-			if ctr != nil {
-				used = ctr.Add(int64(n))
+			// ACL: UDP and ICMP bypass the SOCKS forwarder, so check every packet
+			pktDst, pktPort := packetDest(buf[1 : 1+n])
+			if pktDst == nil || !s.checkPacket(aclRequest{connID: connID, username: username, clientIP: srcIP, ip: pktDst, port: pktPort}) {
+				continue
 			}
-			// Hard cutoff: drop packet silently if limit exceeded
-			if limit > 0 && used > limit {
+			// Quota enforcement: drop silently when cut or throttled
+			if !s.allowPacket(connID, n) {
 				continue
 			}
+			if bw != nil {
+				bw.up.Add(int64(n))
+			}
 
 			s.mu.RLock()
 			c, ok = s.clients[deviceIP]
@@ -589,6 +638,7 @@ func (s *tunnelServer) teardownDeviceRouting(deviceVPNIP string) {
 		return
 	}
 	delete(s.deviceRouteTable, deviceVPNIP)
+	delete(s.deviceConns, deviceVPNIP)
 
 	// Find and remove all client rules pointing to this device
 	var clientsToRemove []string
@@ -600,8 +650,7 @@ func (s *tunnelServer) teardownDeviceRouting(deviceVPNIP string) {
 	for _, clientIP := range clientsToRemove {
 		delete(s.clientToDevice, clientIP)
 		delete(s.clientSocksAuth, clientIP)
-		delete(s.clientBandwidthUsed, clientIP)
-		delete(s.clientBandwidthLimit, clientIP)
+		delete(s.clientConnID, clientIP)
 	}
 	s.routingMu.Unlock()
 
@@ -620,8 +669,10 @@ func (s *tunnelServer) teardownDeviceRouting(deviceVPNIP string) {
 				break
 			}
 		}
+		teardownClientDNS(clientIP)
 		log.Printf("[routing] removed client rules: %s/32", clientIP)
 	}
+	s.dns.FlushDevice(deviceVPNIP)
 
 	// Remove routing table
 	tableStr := strconv.Itoa(tableNum)
@@ -630,6 +681,7 @@ func (s *tunnelServer) teardownDeviceRouting(deviceVPNIP string) {
 }
 
 type connInfo struct {
+	ID        string `json:"id"`
 	Port      int    `json:"port"`
 	ProxyType string `json:"proxy_type"`
 	Username  string `json:"username"`
@@ -652,14 +704,11 @@ func (s *tunnelServer) notifyConnected(deviceID, vpnIP string) {
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err == nil && result.BasePort > 0 {
 		setupDNAT(result.BasePort, vpnIP)
+		s.registerGatewayRoute(result.BasePort, gatewayRoute{deviceIP: vpnIP, devPort: 8080, proxyType: "http"})
+		s.registerGatewayRoute(result.BasePort+1, gatewayRoute{deviceIP: vpnIP, devPort: 1080, proxyType: "socks5"})
 		for _, ci := range result.Connections {
 			setupSingleDNAT(ci.Port, vpnIP, ci.ProxyType)
-			// Track port→username mapping for bandwidth accounting
-			if ci.Username != "" {
-				s.routingMu.Lock()
-				s.portToUsername[ci.Port] = ci.Username
-				s.routingMu.Unlock()
-			}
+			s.registerGatewayRoute(ci.Port, newConnGatewayRoute(vpnIP, ci.ProxyType, ci.ID, ci.Username))
 		}
 		log.Printf("Notified API + DNAT setup: device %s vpn_ip=%s base_port=%d connections=%v", deviceID, vpnIP, result.BasePort, result.Connections)
 	} else {
@@ -667,6 +716,28 @@ func (s *tunnelServer) notifyConnected(deviceID, vpnIP string) {
 	}
 }
 
+// relaySecretHeader must match middleware.RelaySecretHeader on the API.
+const relaySecretHeader = "X-Relay-Secret"
+
+// relayRequest calls an /api/internal relay route with the shared secret.
+// body is sent as JSON unless nil.
+func (s *tunnelServer) relayRequest(method, path string, body []byte) (*http.Response, error) {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, s.apiURL+path, rd)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set(relaySecretHeader, s.relaySecret)
+	client := &http.Client{Timeout: 5 * time.Second}
+	return client.Do(req)
+}
+
 func (s *tunnelServer) notifyDisconnected(deviceID, vpnIP string) {
 	url := s.apiURL + "/api/internal/vpn/disconnected"
 	body := fmt.Sprintf(`{"device_id":"%s","vpn_ip":"%s"}`, deviceID, vpnIP)
@@ -683,13 +754,11 @@ func (s *tunnelServer) notifyDisconnected(deviceID, vpnIP string) {
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err == nil && result.BasePort > 0 {
 		teardownDNAT(result.BasePort, vpnIP)
+		s.unregisterGatewayRoute(result.BasePort)
+		s.unregisterGatewayRoute(result.BasePort + 1)
 		for _, ci := range result.Connections {
 			teardownSingleDNAT(ci.Port, vpnIP, ci.ProxyType)
-			// Clean up port→username tracking
-			s.routingMu.Lock()
-			delete(s.portToUsername, ci.Port)
-			delete(s.portBandwidthAcc, ci.Port)
-			s.routingMu.Unlock()
+			s.unregisterGatewayRoute(ci.Port)
 		}
 		log.Printf("Notified API + DNAT teardown: device %s vpn_ip=%s base_port=%d connections=%v", deviceID, vpnIP, result.BasePort, result.Connections)
 	} else {
@@ -713,8 +782,12 @@ func setupDNAT(basePort int, vpnIP string) {
 	}
 	for _, r := range rules {
 		for _, proto := range []string{"tcp", "udp"} {
-			args := fmt.Sprintf("-t nat -A PREROUTING -p %s --dport %d -j DNAT --to-destination %s:%d",
-				proto, r.extPort, vpnIP, r.devPort)
+			target := fmt.Sprintf("%s:%d", vpnIP, r.devPort)
+			if proto == "tcp" && r.devPort != 1081 {
+				target = gatewayTarget() // HTTP/SOCKS5 TCP goes through the ACL gateway
+			}
+			args := fmt.Sprintf("-t nat -A PREROUTING -p %s --dport %d -j DNAT --to-destination %s",
+				proto, r.extPort, target)
 			if out, err := runCmd("iptables", splitArgs(args)...); err != nil {
 				log.Printf("DNAT add %s:%d->%s:%d (%s) failed: %s: %v", "ext", r.extPort, vpnIP, r.devPort, proto, string(out), err)
 			}
@@ -736,12 +809,18 @@ func teardownDNAT(basePort int, vpnIP string) {
 	}
 	for _, r := range rules {
 		for _, proto := range []string{"tcp", "udp"} {
-			args := fmt.Sprintf("-t nat -D PREROUTING -p %s --dport %d -j DNAT --to-destination %s:%d",
-				proto, r.extPort, vpnIP, r.devPort)
-			// Loop to remove all duplicates
-			for {
-				if _, err := runCmd("iptables", splitArgs(args)...); err != nil {
-					break // no more matching rules
+			targets := []string{fmt.Sprintf("%s:%d", vpnIP, r.devPort)}
+			if proto == "tcp" {
+				targets = append(targets, gatewayTarget())
+			}
+			for _, target := range targets {
+				args := fmt.Sprintf("-t nat -D PREROUTING -p %s --dport %d -j DNAT --to-destination %s",
+					proto, r.extPort, target)
+				// Loop to remove all duplicates
+				for {
+					if _, err := runCmd("iptables", splitArgs(args)...); err != nil {
+						break // no more matching rules
+					}
 				}
 			}
 		}
@@ -759,8 +838,12 @@ func setupSingleDNAT(extPort int, vpnIP string, proxyType string) {
 		devPort = 1080
 	}
 	for _, proto := range []string{"tcp", "udp"} {
-		args := fmt.Sprintf("-t nat -A PREROUTING -p %s --dport %d -j DNAT --to-destination %s:%d",
-			proto, extPort, vpnIP, devPort)
+		target := fmt.Sprintf("%s:%d", vpnIP, devPort)
+		if proto == "tcp" {
+			target = gatewayTarget() // TCP goes through the ACL gateway
+		}
+		args := fmt.Sprintf("-t nat -A PREROUTING -p %s --dport %d -j DNAT --to-destination %s",
+			proto, extPort, target)
 		if out, err := runCmd("iptables", splitArgs(args)...); err != nil {
 			log.Printf("DNAT add %d->%s:%d (%s) failed: %s: %v", extPort, vpnIP, devPort, proto, string(out), err)
 		}
@@ -776,12 +859,18 @@ func teardownSingleDNAT(extPort int, vpnIP string, proxyType string) {
 		devPort = 1080
 	}
 	for _, proto := range []string{"tcp", "udp"} {
-		args := fmt.Sprintf("-t nat -D PREROUTING -p %s --dport %d -j DNAT --to-destination %s:%d",
-			proto, extPort, vpnIP, devPort)
-		// Loop to remove all duplicates
-		for {
-			if _, err := runCmd("iptables", splitArgs(args)...); err != nil {
-				break
+		targets := []string{fmt.Sprintf("%s:%d", vpnIP, devPort)}
+		if proto == "tcp" {
+			targets = append(targets, gatewayTarget()) // also clears pre-gateway direct rules
+		}
+		for _, target := range targets {
+			args := fmt.Sprintf("-t nat -D PREROUTING -p %s --dport %d -j DNAT --to-destination %s",
+				proto, extPort, target)
+			// Loop to remove all duplicates
+			for {
+				if _, err := runCmd("iptables", splitArgs(args)...); err != nil {
+					break
+				}
 			}
 		}
 	}
@@ -916,7 +1005,7 @@ func (s *tunnelServer) startPushAPI() {
 		}
 	}
 
-	go s.bandwidthFlushLoop()
+	go s.bandwidthReportLoop()
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/push-command", s.handlePushCommand)
@@ -992,8 +1081,9 @@ func (s *tunnelServer) handleRefreshDNAT(w http.ResponseWriter, r *http.Request)
 		DeviceID  string `json:"device_id"`
 		BasePort  int    `json:"base_port"`
 		VpnIP     string `json:"vpn_ip"`
-		ProxyType string `json:"proxy_type"`
-		Username  string `json:"username"`
+		ProxyType    string `json:"proxy_type"`
+		Username     string `json:"username"`
+		ConnectionID string `json:"connection_id"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "bad request", http.StatusBadRequest)
@@ -1003,14 +1093,11 @@ func (s *tunnelServer) handleRefreshDNAT(w http.ResponseWriter, r *http.Request)
 	if req.BasePort > 0 && req.VpnIP != "" {
 		if req.ProxyType != "" {
 			setupSingleDNAT(req.BasePort, req.VpnIP, req.ProxyType)
+			s.registerGatewayRoute(req.BasePort, newConnGatewayRoute(req.VpnIP, req.ProxyType, req.ConnectionID, req.Username))
 		} else {
 			setupDNAT(req.BasePort, req.VpnIP)
-		}
-		// Track port→username mapping for bandwidth accounting
-		if req.Username != "" {
-			s.routingMu.Lock()
-			s.portToUsername[req.BasePort] = req.Username
-			s.routingMu.Unlock()
+			s.registerGatewayRoute(req.BasePort, gatewayRoute{deviceIP: req.VpnIP, devPort: 8080, proxyType: "http"})
+			s.registerGatewayRoute(req.BasePort+1, gatewayRoute{deviceIP: req.VpnIP, devPort: 1080, proxyType: "socks5"})
 		}
 		log.Printf("Refresh DNAT: device=%s base_port=%d vpn_ip=%s type=%s username=%s", req.DeviceID, req.BasePort, req.VpnIP, req.ProxyType, req.Username)
 	}
@@ -1041,12 +1128,9 @@ func (s *tunnelServer) handleTeardownDNAT(w http.ResponseWriter, r *http.Request
 			teardownSingleDNAT(req.BasePort, req.VpnIP, req.ProxyType)
 		} else {
 			teardownDNAT(req.BasePort, req.VpnIP)
+			s.unregisterGatewayRoute(req.BasePort + 1)
 		}
-		// Clean up port→username tracking
-		s.routingMu.Lock()
-		delete(s.portToUsername, req.BasePort)
-		delete(s.portBandwidthAcc, req.BasePort)
-		s.routingMu.Unlock()
+		s.unregisterGatewayRoute(req.BasePort)
 		log.Printf("Teardown DNAT: device=%s base_port=%d vpn_ip=%s type=%s", req.DeviceID, req.BasePort, req.VpnIP, req.ProxyType)
 	}
 
@@ -1066,12 +1150,11 @@ func (s *tunnelServer) handleOpenVPNClientConnect(w http.ResponseWriter, r *http
 	}
 
 	var req struct {
-		ClientVPNIP    string `json:"client_vpn_ip"`    // 10.9.0.x
-		DeviceVPNIP    string `json:"device_vpn_ip"`    // 192.168.255.y
-		SocksUser      string `json:"socks_user"`
-		SocksPass      string `json:"socks_pass"`
-		BandwidthLimit int64  `json:"bandwidth_limit"` // bytes, 0 = unlimited
-		BandwidthUsed  int64  `json:"bandwidth_used"`  // current DB value — initial offset
+		ClientVPNIP  string `json:"client_vpn_ip"` // 10.9.0.x
+		DeviceVPNIP  string `json:"device_vpn_ip"` // 192.168.255.y
+		SocksUser    string `json:"socks_user"`
+		SocksPass    string `json:"socks_pass"`
+		ConnectionID string `json:"connection_id"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "bad request", http.StatusBadRequest)
@@ -1089,11 +1172,8 @@ func (s *tunnelServer) handleOpenVPNClientConnect(w http.ResponseWriter, r *http
 	}
 	s.clientToDevice[req.ClientVPNIP] = req.DeviceVPNIP
 	s.clientSocksAuth[req.ClientVPNIP] = socksAuth{user: req.SocksUser, pass: req.SocksPass}
-	// Initialize bandwidth counter from DB value (survives tunnel restarts)
-	ctr := &atomic.Int64{}
-	ctr.Store(req.BandwidthUsed)
-	s.clientBandwidthUsed[req.ClientVPNIP] = ctr
-	s.clientBandwidthLimit[req.ClientVPNIP] = req.BandwidthLimit
+	s.clientConnID[req.ClientVPNIP] = req.ConnectionID
+	s.bwCounterLocked(req.ConnectionID)
 	s.routingMu.Unlock()
 
 	// Remove any stale ip rule for this client (idempotent)
@@ -1130,6 +1210,9 @@ func (s *tunnelServer) handleOpenVPNClientConnect(w http.ResponseWriter, r *http
 		log.Printf("[routing] iptables DNAT add failed: %s: %v", string(out), err)
 	}
 
+	// DNS (UDP+TCP port 53) → device-backed resolver, inserted above the TCP DNAT
+	setupClientDNS(req.ClientVPNIP)
+
 	log.Printf("[routing] client %s -> table %d (device %s) + TCP DNAT -> %s (socks_user=%s)",
 		req.ClientVPNIP, tableNum, req.DeviceVPNIP, dnatTarget, req.SocksUser)
 	w.WriteHeader(http.StatusOK)
@@ -1156,8 +1239,7 @@ func (s *tunnelServer) handleOpenVPNClientDisconnect(w http.ResponseWriter, r *h
 	s.routingMu.Lock()
 	delete(s.clientToDevice, req.ClientVPNIP)
 	delete(s.clientSocksAuth, req.ClientVPNIP)
-	delete(s.clientBandwidthUsed, req.ClientVPNIP)
-	delete(s.clientBandwidthLimit, req.ClientVPNIP)
+	delete(s.clientConnID, req.ClientVPNIP)
 	s.routingMu.Unlock()
 
 	// Remove ip rules — loop to remove all duplicates
@@ -1176,177 +1258,27 @@ func (s *tunnelServer) handleOpenVPNClientDisconnect(w http.ResponseWriter, r *h
 			break
 		}
 	}
+	teardownClientDNS(req.ClientVPNIP)
 
 	log.Printf("[routing] client disconnect: removed rules for %s", req.ClientVPNIP)
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"ok":true}`))
 }
 
-// handleResetBandwidth resets the in-memory bandwidth counter for a client VPN IP.
-// Accepts client_vpn_ip directly or username for reverse lookup.
+// handleResetBandwidth is called by the API after it resets a connection's
+// bandwidth usage. Quota state comes from the limits snapshot, so re-pull it
+// now instead of waiting for the next sync.
 func (s *tunnelServer) handleResetBandwidth(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	var req struct {
-		ClientVPNIP string `json:"client_vpn_ip"`
-		Username    string `json:"username"`
-	}
-	json.NewDecoder(r.Body).Decode(&req)
-
-	s.routingMu.Lock()
-	targetIP := req.ClientVPNIP
-	if targetIP == "" && req.Username != "" {
-		for ip, auth := range s.clientSocksAuth {
-			if auth.user == req.Username {
-				targetIP = ip
-				break
-			}
-		}
-	}
-	if targetIP != "" {
-		if ctr, ok := s.clientBandwidthUsed[targetIP]; ok {
-			ctr.Store(0)
-		}
-	}
-	// Also reset DNAT port bandwidth accumulator for this username
-	if req.Username != "" {
-		for port, user := range s.portToUsername {
-			if user == req.Username {
-				s.portBandwidthAcc[port] = 0
-			}
-		}
-	}
-	s.routingMu.Unlock()
+	go s.syncConnLimits()
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"ok":true}`))
 }
 
-// bandwidthFlushLoop periodically flushes bandwidth counters to the API server.
-func (s *tunnelServer) bandwidthFlushLoop() {
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-	for range ticker.C {
-		s.flushBandwidthToAPI()
-	}
-}
-
-// readDNATBandwidth reads iptables byte counters for DNAT rules, zeros them,
-// and returns accumulated bandwidth per username for HTTP/SOCKS5 proxy connections.
-func (s *tunnelServer) readDNATBandwidth() map[string]int64 {
-	// Read counters: -v for verbose (includes bytes), -n for numeric, -x for exact counts
-	out, err := runCmd("iptables", "-t", "nat", "-L", "PREROUTING", "-v", "-n", "-x")
-	if err != nil {
-		log.Printf("[bandwidth] iptables read failed: %v", err)
-		return nil
-	}
-
-	// Parse output lines. Format example:
-	//   pkts bytes target prot opt in out source destination
-	//   42  12345 DNAT   tcp  --  *  *   0.0.0.0/0  0.0.0.0/0  tcp dpt:30048 to:192.168.255.2:8080
-	portBytes := make(map[int]int64)
-	lines := strings.Split(string(out), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if !strings.Contains(line, "DNAT") || !strings.Contains(line, "tcp") {
-			continue
-		}
-		fields := strings.Fields(line)
-		if len(fields) < 2 {
-			continue
-		}
-		byteCount, err := strconv.ParseInt(fields[1], 10, 64)
-		if err != nil || byteCount == 0 {
-			continue
-		}
-		// Find dpt:NNNNN in the fields
-		for _, f := range fields {
-			if strings.HasPrefix(f, "dpt:") {
-				portStr := strings.TrimPrefix(f, "dpt:")
-				port, err := strconv.Atoi(portStr)
-				if err == nil {
-					portBytes[port] += byteCount
-				}
-			}
-		}
-	}
-
-	// Zero the counters after reading
-	if _, err := runCmd("iptables", "-t", "nat", "-Z", "PREROUTING"); err != nil {
-		log.Printf("[bandwidth] iptables zero failed: %v", err)
-	}
-
-	// Map port bytes to usernames using portToUsername
-	result := make(map[string]int64)
-	s.routingMu.Lock()
-	for port, bytes := range portBytes {
-		if username, ok := s.portToUsername[port]; ok {
-			s.portBandwidthAcc[port] += bytes
-			result[username] += s.portBandwidthAcc[port]
-		}
-	}
-	// Also include ports that had no new traffic but have accumulated bytes
-	for port, acc := range s.portBandwidthAcc {
-		if acc > 0 {
-			if username, ok := s.portToUsername[port]; ok {
-				if _, already := result[username]; !already {
-					result[username] = acc
-				}
-			}
-		}
-	}
-	s.routingMu.Unlock()
-
-	return result
-}
-
-// flushBandwidthToAPI sends a snapshot of bandwidth usage (by username) to the API.
-func (s *tunnelServer) flushBandwidthToAPI() {
-	// 1. Snapshot OpenVPN client bandwidth (tracked via SOCKS5 forwarder)
-	s.routingMu.Lock()
-	snapshot := make(map[string]int64)
-	for ip, ctr := range s.clientBandwidthUsed {
-		if auth, ok := s.clientSocksAuth[ip]; ok {
-			snapshot[auth.user] = ctr.Load()
-		}
-	}
-	s.routingMu.Unlock()
-
-	// 2. Read DNAT (HTTP/SOCKS5 proxy) bandwidth from iptables counters
-	dnatBW := s.readDNATBandwidth()
-	for username, bytes := range dnatBW {
-		// A connection is either OpenVPN or HTTP/SOCKS5, so they won't overlap.
-		// Use max in case both somehow report for the same username.
-		if existing, ok := snapshot[username]; ok {
-			if bytes > existing {
-				snapshot[username] = bytes
-			}
-		} else {
-			snapshot[username] = bytes
-		}
-	}
-
-	if len(snapshot) == 0 {
-		return
-	}
-
-	body, _ := json.Marshal(snapshot)
-	apiURL := s.apiURL
-	if apiURL == "" {
-		apiURL = "http://127.0.0.1:8080"
-	}
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Post(apiURL+"/api/internal/bandwidth-flush", "application/json", bytes.NewReader(body))
-	if err != nil {
-		log.Printf("[bandwidth] flush failed: %v", err)
-		return
-	}
-	resp.Body.Close()
-	log.Printf("[bandwidth] flushed %d connections to API (ovpn=%d, dnat=%d)", len(snapshot), len(snapshot)-len(dnatBW), len(dnatBW))
-}
-
 // ──────────────────────────────────────────────────────────────────────────────
 // SOCKS5 transparent forwarder
 //
@@ -1354,9 +1286,10 @@ func (s *tunnelServer) flushBandwidthToAPI() {
 // For each connection:
 //   1. SO_ORIGINAL_DST → real destination (e.g. google.com:443)
 //   2. Source IP → look up device + SOCKS5 credentials
-//   3. Connect to device's SOCKS5 proxy (192.168.255.x:1080) via tun0
-//   4. SOCKS5 CONNECT to original destination
-//   5. Bidirectional relay with io.Copy
+//   3. Peek TLS SNI / HTTP Host so the CONNECT can carry the hostname
+//   4. Connect to device's SOCKS5 proxy (192.168.255.x:1080) via tun0
+//   5. SOCKS5 CONNECT to hostname (ATYP 0x03) or original IP, replay peeked bytes
+//   6. Bidirectional relay with io.Copy
 //
 // This achieves high throughput because the server↔device path uses kernel TCP
 // (through tun0/UDP tunnel), and the device↔internet path uses kernel TCP over
@@ -1397,6 +1330,7 @@ func (s *tunnelServer) handleForwardedConn(conn net.Conn) {
 	s.routingMu.Lock()
 	deviceIP, ok := s.clientToDevice[srcIP]
 	auth := s.clientSocksAuth[srcIP]
+	connID := s.clientConnID[srcIP]
 	s.routingMu.Unlock()
 
 	if !ok {
@@ -1404,11 +1338,44 @@ func (s *tunnelServer) handleForwardedConn(conn net.Conn) {
 		return
 	}
 
-	// 3. Connect to device's SOCKS5 proxy via tun0
+	sess := newSession("openvpn", srcIP)
+	sess.connID, sess.username = connID, auth.user
+	sess.bw = s.bwCounter(connID)
+	defer s.endSession(sess)
+
+	// 3. Peek at the client's first bytes for a TLS SNI / HTTP Host hostname
+	// so the device resolves the name itself (falls back to the original IP).
+	hostname, prefix := sniffHostname(conn)
+	sess.setDest(hostname, origIP, origPort)
+
+	pinned, allowed := s.checkACL(aclRequest{
+		connID:   connID,
+		username: auth.user,
+		clientIP: srcIP,
+		host:     hostname,
+		ip:       origIP,
+		port:     origPort,
+	})
+	if !allowed {
+		sess.reason = closeACLDenied
+		return
+	}
+
+	release, reason := s.admitSession(connID)
+	if release == nil {
+		log.Printf("[socks-fwd] %s (conn=%s) rejected: %s limit", srcIP, connID, reason)
+		sess.reason = closeLimitPrefix + reason
+		return
+	}
+	defer release()
+	sess.throttle = s.quotaThrottle(connID)
+
+	// 4. Connect to device's SOCKS5 proxy via tun0
 	socksAddr := fmt.Sprintf("%s:1080", deviceIP)
 	socksConn, err := net.DialTimeout("tcp", socksAddr, 10*time.Second)
 	if err != nil {
 		log.Printf("[socks-fwd] dial %s failed: %v", socksAddr, err)
+		sess.reason = closeDialFailed
 		return
 	}
 	defer socksConn.Close()
@@ -1418,30 +1385,33 @@ func (s *tunnelServer) handleForwardedConn(conn net.Conn) {
 		tc.SetNoDelay(true)
 	}
 
-	// 4. SOCKS5 handshake (username/password auth + CONNECT)
+	// 5. SOCKS5 handshake (username/password auth + CONNECT)
 	socksConn.SetDeadline(time.Now().Add(10 * time.Second))
+	// The sniffed name is client-controlled: under CIDR rules (pinned) connect
+	// to the address that was checked, not to whatever the name resolves to.
 	dstStr := origIP.String()
+	if hostname != "" && pinned == nil {
+		dstStr = hostname
+	}
 	if err := socks5Connect(socksConn, auth.user, auth.pass, dstStr, origPort); err != nil {
-		log.Printf("[socks-fwd] SOCKS5 handshake to %s for %s:%d failed: %v",
-			socksAddr, dstStr, origPort, err)
+		log.Printf("[socks-fwd] SOCKS5 handshake to %s for %s:%d (orig %s) failed: %v",
+			socksAddr, dstStr, origPort, origIP, err)
+		sess.reason = closeHandshake
 		return
 	}
-	socksConn.SetDeadline(time.Time{}) // clear deadline for relay
 
-	// 5. Bidirectional relay
-	done := make(chan struct{})
-	go func() {
-		io.Copy(socksConn, conn)
-		if tc, ok := socksConn.(*net.TCPConn); ok {
-			tc.CloseWrite()
+	// Replay whatever we consumed while sniffing
+	if len(prefix) > 0 {
+		if _, err := socksConn.Write(prefix); err != nil {
+			log.Printf("[socks-fwd] replay %d sniffed bytes to %s failed: %v", len(prefix), socksAddr, err)
+			return
 		}
-		done <- struct{}{}
-	}()
-	io.Copy(conn, socksConn)
-	if tc, ok := conn.(*net.TCPConn); ok {
-		tc.CloseWrite()
 	}
-	<-done
+	socksConn.SetDeadline(time.Time{}) // clear deadline for relay
+
+	// 6. Bidirectional relay
+	sess.countUp(len(prefix))
+	relayConns(conn, conn, socksConn, sess)
 }
 
 // getOriginalDst retrieves the original destination address of a connection
@@ -1491,7 +1461,19 @@ func getOriginalDst(conn net.Conn) (net.IP, uint16, error) {
 }
 
 // socks5Connect performs SOCKS5 handshake with username/password auth and CONNECT.
-func socks5Connect(conn net.Conn, user, pass, dstIP string, dstPort uint16) error {
+// dst may be an IPv4 literal (ATYP 0x01) or a hostname (ATYP 0x03).
+func socks5Connect(conn net.Conn, user, pass, dst string, dstPort uint16) error {
+	if err := socks5Auth(conn, user, pass); err != nil {
+		return err
+	}
+	if _, _, err := socks5Request(conn, 0x01, dst, dstPort); err != nil {
+		return fmt.Errorf("connect %w", err)
+	}
+	return nil
+}
+
+// socks5Auth negotiates username/password authentication (RFC 1929).
+func socks5Auth(conn net.Conn, user, pass string) error {
 	// Auth negotiation: offer username/password method (0x02)
 	if _, err := conn.Write([]byte{0x05, 0x01, 0x02}); err != nil {
 		return fmt.Errorf("auth negotiation write: %w", err)
@@ -1505,7 +1487,6 @@ func socks5Connect(conn net.Conn, user, pass, dstIP string, dstPort uint16) erro
 		return fmt.Errorf("auth method rejected: %x %x", resp[0], resp[1])
 	}
 
-	// Username/password auth (RFC 1929)
 	authBuf := make([]byte, 3+len(user)+len(pass))
 	authBuf[0] = 0x01 // subneg version
 	authBuf[1] = byte(len(user))
@@ -1522,31 +1503,62 @@ func socks5Connect(conn net.Conn, user, pass, dstIP string, dstPort uint16) erro
 	if resp[1] != 0x00 {
 		return fmt.Errorf("auth failed: status %d", resp[1])
 	}
+	return nil
+}
 
-	// CONNECT request (IPv4)
-	ip := net.ParseIP(dstIP).To4()
-	if ip == nil {
-		return fmt.Errorf("invalid IPv4: %s", dstIP)
-	}
-	req := make([]byte, 10)
-	req[0] = 0x05 // version
-	req[1] = 0x01 // CONNECT
-	req[2] = 0x00 // reserved
-	req[3] = 0x01 // IPv4
-	copy(req[4:8], ip)
-	binary.BigEndian.PutUint16(req[8:10], dstPort)
+// socks5Request sends a SOCKS5 command (0x01 CONNECT, 0x03 UDP ASSOCIATE) and
+// returns the bound address from the reply. bndIP is nil for domain replies.
+func socks5Request(conn net.Conn, cmd byte, dst string, dstPort uint16) (net.IP, uint16, error) {
+	req := []byte{0x05, cmd, 0x00} // version, command, reserved
+	if ip := net.ParseIP(dst); ip != nil {
+		ip4 := ip.To4()
+		if ip4 == nil {
+			return nil, 0, fmt.Errorf("invalid IPv4: %s", dst)
+		}
+		req = append(req, 0x01)
+		req = append(req, ip4...)
+	} else {
+		if len(dst) == 0 || len(dst) > maxHostnameLen {
+			return nil, 0, fmt.Errorf("invalid hostname: %q", dst)
+		}
+		req = append(req, 0x03, byte(len(dst)))
+		req = append(req, dst...)
+	}
+	req = binary.BigEndian.AppendUint16(req, dstPort)
 	if _, err := conn.Write(req); err != nil {
-		return fmt.Errorf("connect write: %w", err)
+		return nil, 0, fmt.Errorf("write: %w", err)
 	}
 
-	// Read reply (minimum 10 bytes for IPv4)
-	reply := make([]byte, 10)
+	// Reply: VER REP RSV ATYP, then BND.ADDR (variable) + BND.PORT
+	reply := make([]byte, 4)
 	if _, err := io.ReadFull(conn, reply); err != nil {
-		return fmt.Errorf("connect reply read: %w", err)
+		return nil, 0, fmt.Errorf("reply read: %w", err)
 	}
 	if reply[1] != 0x00 {
-		return fmt.Errorf("connect failed: status %d", reply[1])
+		return nil, 0, fmt.Errorf("failed: status %d", reply[1])
+	}
+	var bndLen int
+	switch reply[3] {
+	case 0x01:
+		bndLen = 4
+	case 0x04:
+		bndLen = 16
+	case 0x03:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return nil, 0, fmt.Errorf("reply read: %w", err)
+		}
+		bndLen = int(l[0])
+	default:
+		return nil, 0, fmt.Errorf("reply: unknown address type %d", reply[3])
 	}
-
-	return nil
+	bnd := make([]byte, bndLen+2)
+	if _, err := io.ReadFull(conn, bnd); err != nil {
+		return nil, 0, fmt.Errorf("reply read: %w", err)
+	}
+	var bndIP net.IP
+	if reply[3] != 0x03 {
+		bndIP = net.IP(bnd[:bndLen])
+	}
+	return bndIP, binary.BigEndian.Uint16(bnd[bndLen:]), nil
 }
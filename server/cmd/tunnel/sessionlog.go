@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ──────────────────────────────────────────────────────────────────────────────
+// Session access log
+//
+// Every TCP session through the SOCKS forwarder or the proxy gateway produces
+// one record: connection, client IP, destination, bytes each way, start/end
+// and why it closed. Records are buffered and posted in batches to
+// /api/internal/connection-sessions every sessionFlushInterval.
+//
+// Sessions that never resolve to a connection (e.g. failed handshakes on the
+// device-wide base ports) are not recorded.
+// ──────────────────────────────────────────────────────────────────────────────
+
+const (
+	sessionFlushInterval = 10 * time.Second
+	sessionMaxPending    = 10000 // buffered records before we start dropping
+	sessionMaxBatch      = 1000
+)
+
+// Close reasons
+const (
+	closeClientClosed   = "client_closed"
+	closeUpstreamClosed = "upstream_closed"
+	closeError          = "error"
+	closeACLDenied      = "acl_denied"
+	closeAuthFailed     = "auth_failed"
+	closeDialFailed     = "dial_failed"
+	closeHandshake      = "handshake_failed"
+	closeLimitPrefix    = "limit_" // + "concurrent" / "rate" / "quota"
+	closeQuotaExceeded  = "quota_exceeded"
+)
+
+// session tracks one proxied TCP session while it is open.
+type session struct {
+	connID    string
+	username  string
+	clientIP  string
+	proxyType string
+	destHost  string
+	destPort  uint16
+	bytesUp   int64 // client -> destination
+	bytesDown int64 // destination -> client
+	started   time.Time
+	reason    string
+
+	bw       *bwCounter        // connection's unreported bandwidth, nil if unknown
+	throttle func(n int) error // quota check before each relayed write, nil if unknown
+}
+
+type sessionRecord struct {
+	ConnectionID string    `json:"connection_id"`
+	Username     string    `json:"username"`
+	ClientIP     string    `json:"client_ip"`
+	ProxyType    string    `json:"proxy_type"`
+	DestHost     string    `json:"dest_host"`
+	DestPort     int       `json:"dest_port"`
+	BytesUp      int64     `json:"bytes_up"`
+	BytesDown    int64     `json:"bytes_down"`
+	StartedAt    time.Time `json:"started_at"`
+	EndedAt      time.Time `json:"ended_at"`
+	CloseReason  string    `json:"close_reason"`
+}
+
+func newSession(proxyType, clientIP string) *session {
+	return &session{proxyType: proxyType, clientIP: clientIP, started: time.Now()}
+}
+
+// setDest records the destination, preferring a hostname over the IP.
+func (sess *session) setDest(host string, ip net.IP, port uint16) {
+	sess.destHost = host
+	if host == "" && ip != nil {
+		sess.destHost = ip.String()
+	}
+	sess.destPort = port
+}
+
+// endSession queues the finished session for reporting.
+func (s *tunnelServer) endSession(sess *session) {
+	if sess.connID == "" {
+		return
+	}
+	if sess.reason == "" {
+		sess.reason = closeError
+	}
+	rec := sessionRecord{
+		ConnectionID: sess.connID,
+		Username:     sess.username,
+		ClientIP:     sess.clientIP,
+		ProxyType:    sess.proxyType,
+		DestHost:     sess.destHost,
+		DestPort:     int(sess.destPort),
+		BytesUp:      sess.bytesUp,
+		BytesDown:    sess.bytesDown,
+		StartedAt:    sess.started.UTC(),
+		EndedAt:      time.Now().UTC(),
+		CloseReason:  sess.reason,
+	}
+	s.sessionLogsMu.Lock()
+	if len(s.sessionLogs) < sessionMaxPending {
+		s.sessionLogs = append(s.sessionLogs, rec)
+	}
+	s.sessionLogsMu.Unlock()
+}
+
+// sessionLogLoop reports buffered session records every sessionFlushInterval.
+func (s *tunnelServer) sessionLogLoop() {
+	ticker := time.NewTicker(sessionFlushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.flushSessionLogs()
+	}
+}
+
+func (s *tunnelServer) flushSessionLogs() {
+	s.sessionLogsMu.Lock()
+	pending := s.sessionLogs
+	s.sessionLogs = nil
+	s.sessionLogsMu.Unlock()
+
+	for len(pending) > 0 {
+		n := len(pending)
+		if n > sessionMaxBatch {
+			n = sessionMaxBatch
+		}
+		batch := pending[:n]
+		pending = pending[n:]
+
+		body, _ := json.Marshal(batch)
+		resp, err := s.relayRequest(http.MethodPost, "/api/internal/connection-sessions", body)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("status %d", resp.StatusCode)
+			}
+		}
+		if err != nil {
+			log.Printf("[sessions] report failed (%d dropped): %v", len(batch)+len(pending), err)
+			return
+		}
+	}
+}
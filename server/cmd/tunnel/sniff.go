@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"time"
+)
+
+// ──────────────────────────────────────────────────────────────────────────────
+// Hostname sniffing for the transparent SOCKS forwarder
+//
+// iptables REDIRECT only gives us the destination IP the client resolved. By
+// peeking at the first bytes the client sends (TLS ClientHello or HTTP request
+// headers) we can recover the hostname and CONNECT by domain (ATYP 0x03), so
+// the phone resolves it over cellular. This keeps geo-DNS / CDN selection
+// consistent with the device's exit IP. The peeked bytes are replayed to the
+// device once the SOCKS5 tunnel is up.
+// ──────────────────────────────────────────────────────────────────────────────
+
+const (
+	sniffTimeout    = 300 * time.Millisecond // server-speaks-first protocols fall back after this
+	sniffMaxTLS     = 16*1024 + 5            // one full TLS record
+	sniffMaxHTTP    = 8 * 1024
+	maxHostnameLen  = 255
+	tlsRecordHeader = 5
+)
+
+// sniffHostname reads the client's opening bytes and extracts a hostname from
+// a TLS SNI extension or an HTTP Host header. It returns the hostname ("" if
+// none was found) and every byte consumed from conn, which the caller must
+// forward upstream before relaying the rest of the stream.
+func sniffHostname(conn net.Conn) (string, []byte) {
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, 0, 2048)
+	chunk := make([]byte, 2048)
+	for {
+		n, err := conn.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+
+		host, done := parseSniffed(buf)
+		if done || err != nil {
+			return host, buf
+		}
+	}
+}
+
+// parseSniffed inspects the bytes read so far. done is true once no further
+// reads can change the outcome (hostname found, protocol not recognised, or
+// size limit reached).
+func parseSniffed(buf []byte) (host string, done bool) {
+	if len(buf) == 0 {
+		return "", false
+	}
+
+	// TLS handshake record
+	if buf[0] == 0x16 {
+		if len(buf) < tlsRecordHeader {
+			return "", false
+		}
+		need := tlsRecordHeader + int(binary.BigEndian.Uint16(buf[3:5]))
+		if need > sniffMaxTLS {
+			need = sniffMaxTLS
+		}
+		if len(buf) < need {
+			return "", false
+		}
+		return parseTLSServerName(buf[tlsRecordHeader:need]), true
+	}
+
+	if looksLikeHTTP(buf) {
+		end := bytes.Index(buf, []byte("\r\n\r\n"))
+		if end < 0 && len(buf) < sniffMaxHTTP {
+			return "", false
+		}
+		if end < 0 {
+			end = len(buf)
+		}
+		return parseHTTPHost(buf[:end]), true
+	}
+
+	return "", true
+}
+
+var httpMethods = []string{"GET ", "POST ", "PUT ", "HEAD ", "DELETE ", "OPTIONS ", "PATCH ", "CONNECT ", "TRACE "}
+
+func looksLikeHTTP(buf []byte) bool {
+	for _, m := range httpMethods {
+		n := len(m)
+		if len(buf) < n {
+			// Partial prefix — keep reading if it could still match
+			if strings.HasPrefix(m, string(buf)) {
+				return true
+			}
+			continue
+		}
+		if string(buf[:n]) == m {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHTTPHost returns the Host header value (without port) from a request head.
+func parseHTTPHost(head []byte) string {
+	lines := strings.Split(string(head), "\r\n")
+	for _, line := range lines[1:] {
+		colon := strings.IndexByte(line, ':')
+		if colon <= 0 || !strings.EqualFold(strings.TrimSpace(line[:colon]), "host") {
+			continue
+		}
+		host := strings.TrimSpace(line[colon+1:])
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		return validHostname(host)
+	}
+	return ""
+}
+
+// parseTLSServerName walks a ClientHello handshake message and returns the
+// server_name extension value, or "" if absent or malformed.
+func parseTLSServerName(msg []byte) string {
+	// Handshake header: type(1) + length(3)
+	if len(msg) < 4 || msg[0] != 0x01 {
+		return ""
+	}
+	p := msg[4:]
+
+	// client_version(2) + random(32)
+	if len(p) < 34 {
+		return ""
+	}
+	p = p[34:]
+
+	// session_id
+	if len(p) < 1 || len(p) < 1+int(p[0]) {
+		return ""
+	}
+	p = p[1+int(p[0]):]
+
+	// cipher_suites
+	if len(p) < 2 {
+		return ""
+	}
+	n := int(binary.BigEndian.Uint16(p))
+	if len(p) < 2+n {
+		return ""
+	}
+	p = p[2+n:]
+
+	// compression_methods
+	if len(p) < 1 || len(p) < 1+int(p[0]) {
+		return ""
+	}
+	p = p[1+int(p[0]):]
+
+	// extensions
+	if len(p) < 2 {
+		return ""
+	}
+	n = int(binary.BigEndian.Uint16(p))
+	p = p[2:]
+	if len(p) > n {
+		p = p[:n]
+	}
+
+	for len(p) >= 4 {
+		extType := binary.BigEndian.Uint16(p[0:2])
+		extLen := int(binary.BigEndian.Uint16(p[2:4]))
+		p = p[4:]
+		if len(p) < extLen {
+			return ""
+		}
+		if extType == 0x0000 { // server_name
+			return parseSNIExtension(p[:extLen])
+		}
+		p = p[extLen:]
+	}
+	return ""
+}
+
+func parseSNIExtension(ext []byte) string {
+	if len(ext) < 2 {
+		return ""
+	}
+	list := ext[2:]
+	for len(list) >= 3 {
+		nameType := list[0]
+		nameLen := int(binary.BigEndian.Uint16(list[1:3]))
+		list = list[3:]
+		if len(list) < nameLen {
+			return ""
+		}
+		if nameType == 0x00 { // host_name
+			return validHostname(string(list[:nameLen]))
+		}
+		list = list[nameLen:]
+	}
+	return ""
+}
+
+// validHostname returns host lower-cased if it is a usable DNS name, or "" for
+// IP literals and anything that can't be sent as a SOCKS5 domain.
+func validHostname(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" || len(host) > maxHostnameLen || net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return ""
+	}
+	for i := 0; i < len(host); i++ {
+		c := host[i]
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '_') {
+			return ""
+		}
+	}
+	return host
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+// clientHello builds a TLS record carrying a minimal ClientHello with the
+// given server_name extension (none if sni is empty).
+func clientHello(sni string) []byte {
+	var exts []byte
+	if sni != "" {
+		var list []byte
+		list = append(list, 0x00) // host_name
+		list = binary.BigEndian.AppendUint16(list, uint16(len(sni)))
+		list = append(list, sni...)
+		ext := binary.BigEndian.AppendUint16(nil, uint16(len(list)))
+		ext = append(ext, list...)
+		exts = binary.BigEndian.AppendUint16(exts, 0x0000)
+		exts = binary.BigEndian.AppendUint16(exts, uint16(len(ext)))
+		exts = append(exts, ext...)
+	}
+
+	body := []byte{0x03, 0x03}               // client_version
+	body = append(body, make([]byte, 32)...) // random
+	body = append(body, 0x00)                // session_id
+	body = append(body, 0x00, 0x02, 0x13, 0x01)
+	body = append(body, 0x01, 0x00) // compression_methods
+	body = binary.BigEndian.AppendUint16(body, uint16(len(exts)))
+	body = append(body, exts...)
+
+	hs := []byte{0x01, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}
+	hs = append(hs, body...)
+
+	rec := []byte{0x16, 0x03, 0x01}
+	rec = binary.BigEndian.AppendUint16(rec, uint16(len(hs)))
+	return append(rec, hs...)
+}
+
+func TestParseSniffed(t *testing.T) {
+	hello := clientHello("Example.COM")
+
+	// A record header claiming more than sniffMaxTLS: only the first
+	// sniffMaxTLS bytes are waited for and parsed.
+	oversized := append([]byte(nil), hello...)
+	binary.BigEndian.PutUint16(oversized[3:5], 0xffff)
+	oversized = append(oversized, make([]byte, sniffMaxTLS-len(oversized))...)
+
+	// server_name extension whose length runs past the record
+	badExt := clientHello("example.com")
+	badExt[len(badExt)-len("example.com")-7] = 0xff
+
+	longHead := "GET / HTTP/1.1\r\nHost: example.com\r\nX-Pad: " + strings.Repeat("a", sniffMaxHTTP)
+
+	tests := []struct {
+		name     string
+		buf      []byte
+		wantHost string
+		wantDone bool
+	}{
+		{"empty", nil, "", false},
+		{"tls partial header", hello[:3], "", false},
+		{"tls truncated record", hello[:len(hello)-1], "", false},
+		{"tls full record", hello, "example.com", true},
+		{"tls no sni", clientHello(""), "", true},
+		{"tls ip sni", clientHello("192.0.2.1"), "", true},
+		{"tls oversized record incomplete", oversized[:sniffMaxTLS-1], "", false},
+		{"tls oversized record capped", oversized, "example.com", true},
+		{"tls extension overruns record", badExt, "", true},
+		{"tls not a client hello", []byte{0x16, 0x03, 0x01, 0x00, 0x04, 0x02, 0x00, 0x00, 0x00}, "", true},
+		{"http partial method", []byte("GE"), "", false},
+		{"http partial head", []byte("GET / HTTP/1.1\r\nHost: example.com\r\n"), "", false},
+		{"http host with port", []byte("GET / HTTP/1.1\r\nhost: Example.com:8080\r\n\r\n"), "example.com", true},
+		{"http ip host", []byte("GET / HTTP/1.1\r\nHost: [2001:db8::1]:80\r\n\r\n"), "", true},
+		{"http no host", []byte("GET / HTTP/1.0\r\n\r\n"), "", true},
+		{"http bad host", []byte("GET / HTTP/1.1\r\nHost: exa mple.com\r\n\r\n"), "", true},
+		{"http oversized head", []byte(longHead), "example.com", true},
+		{"other protocol", []byte("SSH-2.0-OpenSSH_9.6\r\n"), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, done := parseSniffed(tt.buf)
+			if host != tt.wantHost || done != tt.wantDone {
+				t.Errorf("parseSniffed() = %q, %v; want %q, %v", host, done, tt.wantHost, tt.wantDone)
+			}
+		})
+	}
+}
+
+func TestValidHostname(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Example.com.", "example.com"},
+		{"_dmarc.example.com", "_dmarc.example.com"},
+		{"", ""},
+		{"10.0.0.1", ""},
+		{"[::1]", ""},
+		{"exa\x00mple.com", ""},
+		{strings.Repeat("a", maxHostnameLen+1), ""},
+	}
+	for _, tt := range tests {
+		if got := validHostname(tt.in); got != tt.want {
+			t.Errorf("validHostname(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSniffHostnameReturnsConsumedBytes(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	hello := clientHello("example.com")
+	go func() {
+		// Split across writes so the record is reassembled
+		client.Write(hello[:10])
+		client.Write(hello[10:])
+	}()
+
+	host, consumed := sniffHostname(server)
+	if host != "example.com" {
+		t.Errorf("host = %q, want example.com", host)
+	}
+	if !bytes.Equal(consumed, hello) {
+		t.Errorf("consumed %d bytes, want the %d-byte record", len(consumed), len(hello))
+	}
+}
@@ -30,17 +30,88 @@ func main() {
 	bwRepo := repository.NewBandwidthRepository(db)
 	relayServerRepo := repository.NewRelayServerRepository(db)
 	userRepo := repository.NewUserRepository(db)
+	aclRepo := repository.NewACLRepository(db)
+	connRepo := repository.NewConnectionRepository(db)
+	sessionLogRepo := repository.NewSessionLogRepository(db)
+	quotaRepo := repository.NewQuotaRepository(db)
+	customerRepo := repository.NewCustomerRepository(db)
+	planRepo := repository.NewPlanRepository(db)
+	statementRepo := repository.NewStatementRepository(db)
+	authSessionRepo := repository.NewAuthSessionRepository(db)
+	auditRepo := repository.NewAuditRepository(db)
+	webhookRepo := repository.NewWebhookRepository(db)
+	alertRepo := repository.NewAlertRepository(db)
+	telemetryRepo := repository.NewTelemetryRepository(db)
 
 	statusLogRepo := repository.NewStatusLogRepository(db)
 	portService := service.NewPortService(deviceRepo, cfg.Ports)
 	deviceService := service.NewDeviceService(deviceRepo, ipHistRepo, commandRepo, portService, nil)
 	deviceService.SetStatusLogRepo(statusLogRepo)
 	deviceService.SetRelayServerRepo(relayServerRepo)
-	deviceService.SetUserRepo(userRepo)
+	webhookService := service.NewWebhookService(webhookRepo, deviceRepo, userRepo)
+	deviceService.SetWebhookService(webhookService)
 	if v := os.Getenv("TUNNEL_PUSH_URL"); v != "" {
 		deviceService.SetTunnelPushURL(v)
 	}
 	bwService := service.NewBandwidthService(bwRepo)
+	aclService := service.NewACLService(aclRepo, connRepo)
+	sessionLogService := service.NewSessionLogService(sessionLogRepo)
+	telemetryService := service.NewTelemetryService(telemetryRepo)
+	connService := service.NewConnectionService(connRepo, deviceRepo)
+	connService.SetQuotaRepo(quotaRepo)
+	connService.SetWebhookService(webhookService)
+	planService := service.NewPlanService(planRepo, customerRepo)
+	statementService := service.NewStatementService(statementRepo, planRepo)
+	authSessionService := service.NewSessionService(authSessionRepo, userRepo, customerRepo, cfg.JWT)
+	auditService := service.NewAuditService(auditRepo)
+	quotaService := service.NewQuotaService(quotaRepo, connService)
+	quotaService.SetWebhookService(webhookService)
+	emailService := service.NewEmailService(cfg.Resend)
+	quotaService.SetCustomerEmail(customerRepo, emailService)
+	alertService := service.NewAlertService(alertRepo, deviceRepo, userRepo, customerRepo)
+	alertService.SetWebhookService(webhookService)
+	alertService.SetEmailService(emailService)
+
+	// Session access log retention (days)
+	sessionRetentionDays := 30
+	if v := os.Getenv("SESSION_LOG_RETENTION_DAYS"); v != "" {
+		fmt.Sscanf(v, "%d", &sessionRetentionDays)
+	}
+
+	// Audit log retention (days, 0 = keep forever)
+	auditRetentionDays := 365
+	if v := os.Getenv("AUDIT_LOG_RETENTION_DAYS"); v != "" {
+		fmt.Sscanf(v, "%d", &auditRetentionDays)
+	}
+
+	// Device telemetry retention (days, 0 = keep forever): raw samples, then
+	// the hourly downsampled series
+	telemetryRawRetentionDays := 14
+	if v := os.Getenv("TELEMETRY_RAW_RETENTION_DAYS"); v != "" {
+		fmt.Sscanf(v, "%d", &telemetryRawRetentionDays)
+	}
+	telemetryHourlyRetentionDays := 365
+	if v := os.Getenv("TELEMETRY_HOURLY_RETENTION_DAYS"); v != "" {
+		fmt.Sscanf(v, "%d", &telemetryHourlyRetentionDays)
+	}
+
+	// Webhook delivery log retention (days)
+	webhookRetentionDays := 30
+	if v := os.Getenv("WEBHOOK_DELIVERY_RETENTION_DAYS"); v != "" {
+		fmt.Sscanf(v, "%d", &webhookRetentionDays)
+	}
+
+	// Raw bandwidth_logs retention (whole months, 0 = keep forever). With
+	// BANDWIDTH_RAW_RETENTION_MODE=detach old partitions are detached but kept.
+	rawRetentionMonths := 3
+	if v := os.Getenv("BANDWIDTH_RAW_RETENTION_MONTHS"); v != "" {
+		fmt.Sscanf(v, "%d", &rawRetentionMonths)
+	}
+	keepDetached := os.Getenv("BANDWIDTH_RAW_RETENTION_MODE") == "detach"
+	hourlyRetentionDays := 400
+	if v := os.Getenv("BANDWIDTH_HOURLY_RETENTION_DAYS"); v != "" {
+		fmt.Sscanf(v, "%d", &hourlyRetentionDays)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -76,6 +147,12 @@ func main() {
 		if err := bwService.EnsurePartitions(ctx); err != nil {
 			log.Printf("Error ensuring partitions: %v", err)
 		}
+		if err := sessionLogService.EnsurePartitions(ctx); err != nil {
+			log.Printf("Error ensuring session partitions: %v", err)
+		}
+		if err := telemetryService.EnsurePartitions(ctx); err != nil {
+			log.Printf("Error ensuring telemetry partitions: %v", err)
+		}
 		for {
 			select {
 			case <-ctx.Done():
@@ -84,6 +161,82 @@ func main() {
 				if err := bwService.EnsurePartitions(ctx); err != nil {
 					log.Printf("Error ensuring partitions: %v", err)
 				}
+				if err := sessionLogService.EnsurePartitions(ctx); err != nil {
+					log.Printf("Error ensuring session partitions: %v", err)
+				}
+				if err := telemetryService.EnsurePartitions(ctx); err != nil {
+					log.Printf("Error ensuring telemetry partitions: %v", err)
+				}
+			}
+		}
+	}()
+
+	// Usage snapshot and monthly statement close - every hour
+	go func() {
+		ticker := time.NewTicker(1 * time.Hour)
+		defer ticker.Stop()
+		runStatements := func() {
+			if err := statementService.SnapshotUsage(ctx); err != nil {
+				log.Printf("Error snapshotting customer usage: %v", err)
+			}
+			count, err := statementService.CloseMonth(ctx)
+			if err != nil {
+				log.Printf("Error closing statements: %v", err)
+			}
+			if count > 0 {
+				log.Printf("Closed %d monthly statements", count)
+			}
+		}
+		// Run immediately on start
+		runStatements()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				runStatements()
+			}
+		}
+	}()
+
+	// Bandwidth rollups - every minute
+	go func() {
+		ticker := time.NewTicker(1 * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := bwService.RunRollup(ctx); err != nil {
+					log.Printf("Error rolling up bandwidth: %v", err)
+				}
+			}
+		}
+	}()
+
+	// Bandwidth retention - every 6 hours: raw partitions and hourly rollups
+	go func() {
+		ticker := time.NewTicker(6 * time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				pruned, err := bwService.PruneRawPartitions(ctx, rawRetentionMonths, keepDetached)
+				if err != nil {
+					log.Printf("Error pruning bandwidth partitions: %v", err)
+				}
+				if len(pruned) > 0 {
+					log.Printf("Detached bandwidth partitions %v (retention %d months, kept=%v)", pruned, rawRetentionMonths, keepDetached)
+				}
+				count, err := bwService.PruneHourly(ctx, time.Duration(hourlyRetentionDays)*24*time.Hour)
+				if err != nil {
+					log.Printf("Error pruning hourly bandwidth rollups: %v", err)
+				} else if count > 0 {
+					log.Printf("Pruned %d hourly bandwidth rollup rows", count)
+				}
 			}
 		}
 	}()
@@ -104,6 +257,261 @@ func main() {
 		}
 	}()
 
+	// ACL denial pruner - every 6 hours, keeps 30 days
+	go func() {
+		ticker := time.NewTicker(6 * time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				count, err := aclService.PruneDenials(ctx, 30*24*time.Hour)
+				if err != nil {
+					log.Printf("Error pruning ACL denials: %v", err)
+				} else if count > 0 {
+					log.Printf("Pruned %d ACL denial records", count)
+				}
+			}
+		}
+	}()
+
+	// Bandwidth report ledger pruner - every 6 hours, keeps 7 days
+	go func() {
+		ticker := time.NewTicker(6 * time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				count, err := bwService.PruneReports(ctx, 7*24*time.Hour)
+				if err != nil {
+					log.Printf("Error pruning bandwidth reports: %v", err)
+				} else if count > 0 {
+					log.Printf("Pruned %d bandwidth report records", count)
+				}
+			}
+		}
+	}()
+
+	// Rotation history pruner - every 6 hours, keeps 7 days (plans look back 24h)
+	go func() {
+		ticker := time.NewTicker(6 * time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				count, err := planService.PruneRotations(ctx, 7*24*time.Hour)
+				if err != nil {
+					log.Printf("Error pruning rotation events: %v", err)
+				} else if count > 0 {
+					log.Printf("Pruned %d rotation events", count)
+				}
+			}
+		}
+	}()
+
+	// Login session pruner - every 6 hours, keeps ended sessions for 30 days
+	go func() {
+		ticker := time.NewTicker(6 * time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				count, err := authSessionService.PruneSessions(ctx, 30*24*time.Hour)
+				if err != nil {
+					log.Printf("Error pruning login sessions: %v", err)
+				} else if count > 0 {
+					log.Printf("Pruned %d login sessions", count)
+				}
+			}
+		}
+	}()
+
+	// Audit log pruner - every 6 hours
+	if auditRetentionDays > 0 {
+		go func() {
+			ticker := time.NewTicker(6 * time.Hour)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					count, err := auditService.Prune(ctx, time.Duration(auditRetentionDays)*24*time.Hour)
+					if err != nil {
+						log.Printf("Error pruning audit log: %v", err)
+					} else if count > 0 {
+						log.Printf("Pruned %d audit log entries", count)
+					}
+				}
+			}
+		}()
+	}
+
+	// Session log retention - every 6 hours, drops whole day partitions
+	go func() {
+		ticker := time.NewTicker(6 * time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				dropped, err := sessionLogService.PruneSessions(ctx, time.Duration(sessionRetentionDays)*24*time.Hour)
+				if err != nil {
+					log.Printf("Error pruning session logs: %v", err)
+				} else if len(dropped) > 0 {
+					log.Printf("Dropped %d session log partitions (retention %d days)", len(dropped), sessionRetentionDays)
+				}
+			}
+		}
+	}()
+
+	// Telemetry downsampling - every 5 minutes, recomputes the current and previous hour
+	go func() {
+		ticker := time.NewTicker(5 * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := telemetryService.RunRollup(ctx); err != nil {
+					log.Printf("Error rolling up telemetry: %v", err)
+				}
+			}
+		}
+	}()
+
+	// Telemetry retention - every 6 hours, drops raw day partitions and old hourly rows
+	go func() {
+		ticker := time.NewTicker(6 * time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				dropped, err := telemetryService.PruneSamples(ctx, time.Duration(telemetryRawRetentionDays)*24*time.Hour)
+				if err != nil {
+					log.Printf("Error pruning telemetry samples: %v", err)
+				} else if len(dropped) > 0 {
+					log.Printf("Dropped %d telemetry partitions (retention %d days)", len(dropped), telemetryRawRetentionDays)
+				}
+				count, err := telemetryService.PruneHourly(ctx, time.Duration(telemetryHourlyRetentionDays)*24*time.Hour)
+				if err != nil {
+					log.Printf("Error pruning hourly telemetry: %v", err)
+				} else if count > 0 {
+					log.Printf("Pruned %d hourly telemetry rows", count)
+				}
+			}
+		}
+	}()
+
+	// Bandwidth quota cycles and notifications - every minute
+	go func() {
+		ticker := time.NewTicker(1 * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				count, err := quotaService.RunResets(ctx)
+				if err != nil {
+					log.Printf("Error resetting quota cycles: %v", err)
+				} else if count > 0 {
+					log.Printf("Reset %d bandwidth quota cycles", count)
+				}
+				count, err = quotaService.RunNotifications(ctx)
+				if err != nil {
+					log.Printf("Error sending quota notifications: %v", err)
+				} else if count > 0 {
+					log.Printf("Sent %d bandwidth quota notifications", count)
+				}
+			}
+		}
+	}()
+
+	// Webhook deliveries - every 10 seconds, retries back off per delivery
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := webhookService.RunDeliveries(ctx); err != nil {
+					log.Printf("Error sending webhook deliveries: %v", err)
+				}
+			}
+		}
+	}()
+
+	// Connection expiry events - every minute
+	go func() {
+		ticker := time.NewTicker(1 * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				count, err := connService.NotifyExpired(ctx)
+				if err != nil {
+					log.Printf("Error queueing connection expiry events: %v", err)
+				} else if count > 0 {
+					log.Printf("Queued %d connection expiry events", count)
+				}
+			}
+		}
+	}()
+
+	// Alert rules - every minute
+	go func() {
+		ticker := time.NewTicker(1 * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				count, err := alertService.Evaluate(ctx)
+				if err != nil {
+					log.Printf("Error evaluating alert rules: %v", err)
+				} else if count > 0 {
+					log.Printf("Opened or resolved %d alert incidents", count)
+				}
+			}
+		}
+	}()
+
+	// Webhook delivery log pruner - every 6 hours
+	go func() {
+		ticker := time.NewTicker(6 * time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				count, err := webhookService.PruneDeliveries(ctx, time.Duration(webhookRetentionDays)*24*time.Hour)
+				if err != nil {
+					log.Printf("Error pruning webhook deliveries: %v", err)
+				} else if count > 0 {
+					log.Printf("Pruned %d webhook deliveries", count)
+				}
+			}
+		}
+	}()
+
 	log.Println("Worker started")
 	<-sigCh
 	log.Println("Worker shutting down")
@@ -127,5 +535,16 @@ func loadConfig() domain.Config {
 	if v := os.Getenv("DB_NAME"); v != "" {
 		cfg.Database.DBName = v
 	}
+
+	// Resend email (quota notifications)
+	if v := os.Getenv("RESEND_API_KEY"); v != "" {
+		cfg.Resend.APIKey = v
+	}
+	if v := os.Getenv("RESEND_FROM_EMAIL"); v != "" {
+		cfg.Resend.FromEmail = v
+	}
+	if v := os.Getenv("DASHBOARD_BASE_URL"); v != "" {
+		cfg.Resend.BaseURL = v
+	}
 	return cfg
 }
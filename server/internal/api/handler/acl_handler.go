@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/service"
+)
+
+type ACLHandler struct {
+	aclService  *service.ACLService
+	connService *service.ConnectionService
+}
+
+func NewACLHandler(aclService *service.ACLService, connService *service.ConnectionService) *ACLHandler {
+	return &ACLHandler{aclService: aclService, connService: connService}
+}
+
+// checkConnectionAccess resolves :id and, for customers, verifies they can see the connection.
+// Writes the error response and returns false if access is denied.
+func checkConnectionAccess(c *gin.Context, connService *service.ConnectionService) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid connection id"})
+		return uuid.Nil, false
+	}
+
+	role, _ := c.Get("user_role")
+	roleStr, _ := role.(string)
+
+	if roleStr == "customer" {
+		userIDVal, _ := c.Get("user_id")
+		customerID, _ := userIDVal.(uuid.UUID)
+		if _, err := connService.GetByIDForCustomer(c.Request.Context(), id, customerID); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return uuid.Nil, false
+		}
+		return id, true
+	}
+
+	if _, err := connService.GetByID(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "connection not found"})
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// GetConnectionACL returns the rules for a connection. Customers can view rules
+// on connections they have access to.
+func (h *ACLHandler) GetConnectionACL(c *gin.Context) {
+	id, ok := checkConnectionAccess(c, h.connService)
+	if !ok {
+		return
+	}
+	rules, err := h.aclService.GetConnectionRules(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// SetConnectionACL replaces the rules for a connection (admin only).
+func (h *ACLHandler) SetConnectionACL(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid connection id"})
+		return
+	}
+
+	var req domain.SetACLRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rules, err := h.aclService.SetConnectionRules(c.Request.Context(), id, req.Rules)
+	if err != nil {
+		writeACLError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// ListConnectionDenials returns recent denied attempts for a connection.
+func (h *ACLHandler) ListConnectionDenials(c *gin.Context) {
+	id, ok := checkConnectionAccess(c, h.connService)
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	denials, err := h.aclService.ListConnectionDenials(c.Request.Context(), id, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"denials": denials})
+}
+
+// GetGlobalACL returns the global policy applied to every connection (admin only).
+func (h *ACLHandler) GetGlobalACL(c *gin.Context) {
+	rules, err := h.aclService.GetGlobalRules(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// SetGlobalACL replaces the global policy (admin only).
+func (h *ACLHandler) SetGlobalACL(c *gin.Context) {
+	var req domain.SetACLRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rules, err := h.aclService.SetGlobalRules(c.Request.Context(), req.Rules)
+	if err != nil {
+		writeACLError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// ListDenials returns recent denied attempts across all connections (admin only).
+func (h *ACLHandler) ListDenials(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	denials, err := h.aclService.ListDenials(c.Request.Context(), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"denials": denials})
+}
+
+// Policy is an internal endpoint (no JWT) polled by tunnel servers for the full rule snapshot.
+func (h *ACLHandler) Policy(c *gin.Context) {
+	policy, err := h.aclService.GetPolicy(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, policy)
+}
+
+// RecordDenials is an internal endpoint (no JWT) where tunnel servers report blocked attempts.
+func (h *ACLHandler) RecordDenials(c *gin.Context) {
+	var denials []domain.ACLDenial
+	if err := c.ShouldBindJSON(&denials); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(denials) == 0 {
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+		return
+	}
+	if err := h.aclService.RecordDenials(c.Request.Context(), denials); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+func writeACLError(c *gin.Context, err error) {
+	switch {
+	case strings.Contains(err.Error(), "invalid acl"):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case strings.Contains(err.Error(), "get connection"):
+		c.JSON(http.StatusNotFound, gin.H{"error": "connection not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/service"
+)
+
+// AlertHandler manages alert rules and their incidents. Rules are owned like
+// webhook endpoints: personally by staff users and resellers, by the
+// organization for customers.
+type AlertHandler struct {
+	alertService *service.AlertService
+}
+
+func NewAlertHandler(alertService *service.AlertService) *AlertHandler {
+	return &AlertHandler{alertService: alertService}
+}
+
+// ListRules handles GET /api/alert-rules.
+func (h *AlertHandler) ListRules(c *gin.Context) {
+	rules, err := h.alertService.ListRules(c.Request.Context(), webhookOwner(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// CreateRule handles POST /api/alert-rules.
+func (h *AlertHandler) CreateRule(c *gin.Context) {
+	var req domain.AlertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule, err := h.alertService.CreateRule(c.Request.Context(), webhookOwner(c), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, rule)
+}
+
+// UpdateRule handles PUT /api/alert-rules/:id.
+func (h *AlertHandler) UpdateRule(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid alert rule id"})
+		return
+	}
+	var req domain.AlertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule, err := h.alertService.UpdateRule(c.Request.Context(), webhookOwner(c), id, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+// DeleteRule handles DELETE /api/alert-rules/:id.
+func (h *AlertHandler) DeleteRule(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid alert rule id"})
+		return
+	}
+	if err := h.alertService.DeleteRule(c.Request.Context(), webhookOwner(c), id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// ListIncidents handles GET /api/alert-incidents.
+// Query params: status (open, resolved), device_id, limit.
+func (h *AlertHandler) ListIncidents(c *gin.Context) {
+	status := c.Query("status")
+	switch status {
+	case "", domain.AlertIncidentOpen, domain.AlertIncidentResolved:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status"})
+		return
+	}
+	var deviceID *uuid.UUID
+	if v := c.Query("device_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid device_id"})
+			return
+		}
+		deviceID = &id
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	incidents, err := h.alertService.ListIncidents(c.Request.Context(), webhookOwner(c), status, deviceID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"incidents": incidents})
+}
+
+// ResolveIncident handles POST /api/alert-incidents/:id/resolve.
+func (h *AlertHandler) ResolveIncident(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid incident id"})
+		return
+	}
+	inc, err := h.alertService.ResolveIncident(c.Request.Context(), webhookOwner(c), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, inc)
+}
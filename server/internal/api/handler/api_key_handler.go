@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/service"
+)
+
+type APIKeyHandler struct {
+	apiKeyService *service.APIKeyService
+}
+
+func NewAPIKeyHandler(apiKeyService *service.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{apiKeyService: apiKeyService}
+}
+
+// ListMine returns the calling customer's API keys.
+func (h *APIKeyHandler) ListMine(c *gin.Context) {
+	role, _ := c.Get("user_role")
+	if roleStr, _ := role.(string); roleStr != "customer" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "customers only"})
+		return
+	}
+	userIDVal, _ := c.Get("user_id")
+	customerID, _ := userIDVal.(uuid.UUID)
+	h.respondList(c, customerID)
+}
+
+// ListForCustomer returns a customer's API keys (admins: any, customers: their own).
+func (h *APIKeyHandler) ListForCustomer(c *gin.Context) {
+	customerID, ok := customerAccess(c)
+	if !ok {
+		return
+	}
+	h.respondList(c, customerID)
+}
+
+func (h *APIKeyHandler) respondList(c *gin.Context, customerID uuid.UUID) {
+	keys, err := h.apiKeyService.List(c.Request.Context(), customerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// Create issues a new API key for the calling customer. The key is only
+// returned in this response.
+func (h *APIKeyHandler) Create(c *gin.Context) {
+	role, _ := c.Get("user_role")
+	if roleStr, _ := role.(string); roleStr != "customer" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "customers only"})
+		return
+	}
+	userIDVal, _ := c.Get("user_id")
+	customerID, _ := userIDVal.(uuid.UUID)
+
+	var req domain.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	resp, err := h.apiKeyService.Create(c.Request.Context(), customerID, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, resp)
+}
+
+// Revoke disables an API key. Customers can revoke their own keys, admins any.
+func (h *APIKeyHandler) Revoke(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid api key id"})
+		return
+	}
+
+	var owner *uuid.UUID
+	role, _ := c.Get("user_role")
+	if roleStr, _ := role.(string); roleStr == "customer" {
+		userIDVal, _ := c.Get("user_id")
+		customerID, _ := userIDVal.(uuid.UUID)
+		owner = &customerID
+	}
+
+	if err := h.apiKeyService.Revoke(c.Request.Context(), id, owner); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
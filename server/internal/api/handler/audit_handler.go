@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/service"
+)
+
+type AuditHandler struct {
+	auditService *service.AuditService
+}
+
+func NewAuditHandler(auditService *service.AuditService) *AuditHandler {
+	return &AuditHandler{auditService: auditService}
+}
+
+// List handles GET /api/audit. Staff see every entry; customers only their own
+// actions, including those made with their API keys.
+// Query params: actor_type, actor_id, customer_id, action (a trailing "."
+// matches a prefix), target_type, target_id, from, to (RFC 3339), limit, offset.
+func (h *AuditHandler) List(c *gin.Context) {
+	filter, ok := auditFilter(c)
+	if !ok {
+		return
+	}
+	if callerRole(c) == "customer" {
+		customerID := callerID(c)
+		filter.CustomerID = &customerID
+	}
+	h.respondList(c, filter)
+}
+
+// ListForCustomer handles GET /api/customers/:id/audit: the actions of one
+// customer (admins: any, customers: their own).
+func (h *AuditHandler) ListForCustomer(c *gin.Context) {
+	customerID, ok := customerAccess(c)
+	if !ok {
+		return
+	}
+	filter, ok := auditFilter(c)
+	if !ok {
+		return
+	}
+	filter.CustomerID = &customerID
+	h.respondList(c, filter)
+}
+
+func (h *AuditHandler) respondList(c *gin.Context, filter domain.AuditFilter) {
+	entries, err := h.auditService.List(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+// auditFilter parses the listing query params. Writes a 400 and returns false
+// on a malformed value.
+func auditFilter(c *gin.Context) (domain.AuditFilter, bool) {
+	f := domain.AuditFilter{
+		ActorType:  c.Query("actor_type"),
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+	}
+	for param, dst := range map[string]**uuid.UUID{
+		"actor_id":    &f.ActorID,
+		"customer_id": &f.CustomerID,
+		"target_id":   &f.TargetID,
+	} {
+		if v := c.Query(param); v != "" {
+			id, err := uuid.Parse(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + param})
+				return f, false
+			}
+			*dst = &id
+		}
+	}
+	for param, dst := range map[string]*time.Time{"from": &f.From, "to": &f.To} {
+		if v := c.Query(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + param + ", use RFC 3339"})
+				return f, false
+			}
+			*dst = t
+		}
+	}
+	f.Limit, _ = strconv.Atoi(c.Query("limit"))
+	f.Offset, _ = strconv.Atoi(c.Query("offset"))
+	return f, true
+}
@@ -23,7 +23,10 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	resp, err := h.authService.Login(c.Request.Context(), &req)
+	resp, err := h.authService.Login(c.Request.Context(), &req, clientInfo(c))
+	if respondTwoFactorChallenge(c, err) {
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/service"
+)
+
+// maxWebhookBody caps provider webhook payloads.
+const maxWebhookBody = 1 << 20
+
+type BillingHandler struct {
+	billingService *service.BillingService
+}
+
+func NewBillingHandler(billingService *service.BillingService) *BillingHandler {
+	return &BillingHandler{billingService: billingService}
+}
+
+// Checkout starts a payment provider checkout for the calling customer.
+func (h *BillingHandler) Checkout(c *gin.Context) {
+	role, _ := c.Get("user_role")
+	if roleStr, _ := role.(string); roleStr != "customer" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "customers only"})
+		return
+	}
+	userIDVal, _ := c.Get("user_id")
+	customerID, _ := userIDVal.(uuid.UUID)
+
+	var req domain.CheckoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	session, err := h.billingService.CreateCheckout(c.Request.Context(), customerID, req.PlanID)
+	if errors.Is(err, service.ErrBillingDisabled) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, session)
+}
+
+// GetAccount returns a customer's billing account (admins: any, customers: their own).
+func (h *BillingHandler) GetAccount(c *gin.Context) {
+	customerID, ok := customerAccess(c)
+	if !ok {
+		return
+	}
+	acct, err := h.billingService.GetAccount(c.Request.Context(), customerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"account": acct})
+}
+
+// Webhook is the public endpoint the payment provider posts signed events to.
+func (h *BillingHandler) Webhook(c *gin.Context) {
+	payload, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookBody))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "read body"})
+		return
+	}
+	err = h.billingService.HandleWebhook(c.Request.Context(), payload, c.Request.Header)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	case errors.Is(err, service.ErrInvalidSignature):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrBillingDisabled):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		log.Printf("[billing] webhook failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
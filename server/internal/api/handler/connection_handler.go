@@ -1,9 +1,12 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -12,8 +15,10 @@ import (
 )
 
 type ConnectionHandler struct {
-	connService  *service.ConnectionService
-	shareService *service.DeviceShareService
+	connService     *service.ConnectionService
+	shareService    *service.DeviceShareService
+	bwService       *service.BandwidthService
+	resellerService *service.ResellerService
 }
 
 func NewConnectionHandler(connService *service.ConnectionService) *ConnectionHandler {
@@ -24,6 +29,14 @@ func (h *ConnectionHandler) SetShareService(ss *service.DeviceShareService) {
 	h.shareService = ss
 }
 
+func (h *ConnectionHandler) SetBandwidthService(bs *service.BandwidthService) {
+	h.bwService = bs
+}
+
+func (h *ConnectionHandler) SetResellerService(rs *service.ResellerService) {
+	h.resellerService = rs
+}
+
 func (h *ConnectionHandler) Create(c *gin.Context) {
 	var req domain.CreateConnectionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -45,12 +58,25 @@ func (h *ConnectionHandler) Create(c *gin.Context) {
 		req.CustomerID = &customerID
 	}
 
+	if resellerID, ok := resellerID(c); ok {
+		ctx := c.Request.Context()
+		if !h.resellerService.InScope(ctx, resellerID, "device", req.DeviceID) ||
+			(req.CustomerID != nil && !h.resellerService.InScope(ctx, resellerID, "customer", *req.CustomerID)) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+	}
+
 	conn, err := h.connService.Create(c.Request.Context(), &req)
 	if err != nil {
 		if strings.Contains(err.Error(), "already exists") {
 			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 			return
 		}
+		if errors.Is(err, service.ErrPlanLimit) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -92,6 +118,31 @@ func (h *ConnectionHandler) List(c *gin.Context) {
 		return
 	}
 
+	if resellerID, ok := resellerID(c); ok {
+		if deviceIDStr != "" {
+			deviceID, err := uuid.Parse(deviceIDStr)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid device_id"})
+				return
+			}
+			conns, err := h.connService.ListByDeviceForReseller(c.Request.Context(), deviceID, resellerID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"connections": conns})
+			return
+		}
+
+		conns, err := h.connService.ListByReseller(c.Request.Context(), resellerID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"connections": conns})
+		return
+	}
+
 	if deviceIDStr != "" {
 		deviceID, err := uuid.Parse(deviceIDStr)
 		if err != nil {
@@ -237,23 +288,169 @@ func (h *ConnectionHandler) RegeneratePassword(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"password": newPass})
 }
 
-// BandwidthFlush is an internal endpoint (no JWT) called by the tunnel server every 30s.
-// Receives a map of {username -> bytes_used} and updates the DB.
-func (h *ConnectionHandler) BandwidthFlush(c *gin.Context) {
-	var data map[string]int64 // username -> bytes used
-	if err := c.ShouldBindJSON(&data); err != nil {
+// GetBandwidth returns today's and this month's relay-reported traffic for a connection.
+func (h *ConnectionHandler) GetBandwidth(c *gin.Context) {
+	id, ok := checkConnectionAccess(c, h.connService)
+	if !ok {
+		return
+	}
+
+	todayIn, todayOut, err := h.bwService.GetConnectionTodayTotal(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	monthIn, monthOut, err := h.bwService.GetConnectionMonthTotal(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"today_in":  todayIn,
+		"today_out": todayOut,
+		"month_in":  monthIn,
+		"month_out": monthOut,
+	})
+}
+
+func (h *ConnectionHandler) GetBandwidthHourly(c *gin.Context) {
+	id, ok := checkConnectionAccess(c, h.connService)
+	if !ok {
+		return
+	}
+
+	dateStr := c.DefaultQuery("date", time.Now().UTC().Format("2006-01-02"))
+	date, err := time.Parse("2006-01-02", dateStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid date format, use YYYY-MM-DD"})
+		return
+	}
+
+	tzOffset := 0
+	if tzStr := c.Query("tz_offset"); tzStr != "" {
+		fmt.Sscanf(tzStr, "%d", &tzOffset)
+	}
+
+	hourly, err := h.bwService.GetConnectionHourly(c.Request.Context(), id, date, tzOffset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"hourly": hourly})
+}
+
+// GetBandwidthDaily returns a per-day series ending on ?date (default today),
+// covering ?days days (default 30, max 366).
+func (h *ConnectionHandler) GetBandwidthDaily(c *gin.Context) {
+	id, ok := checkConnectionAccess(c, h.connService)
+	if !ok {
+		return
+	}
+
+	dateStr := c.DefaultQuery("date", time.Now().UTC().Format("2006-01-02"))
+	date, err := time.Parse("2006-01-02", dateStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid date format, use YYYY-MM-DD"})
+		return
+	}
+
+	days := 30
+	if v := c.Query("days"); v != "" {
+		fmt.Sscanf(v, "%d", &days)
+	}
+	if days < 1 || days > 366 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 366"})
+		return
+	}
+
+	tzOffset := 0
+	if tzStr := c.Query("tz_offset"); tzStr != "" {
+		fmt.Sscanf(tzStr, "%d", &tzOffset)
+	}
+
+	daily, err := h.bwService.GetConnectionDaily(c.Request.Context(), id, date, days, tzOffset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"daily": daily})
+}
+
+// BandwidthReport is an internal endpoint (no JWT) called by the tunnel server every 30s.
+// Receives sequence-numbered per-connection deltas and applies each (relay_id, seq) once.
+// Duplicates are acknowledged so the relay drops them from its retry queue.
+func (h *ConnectionHandler) BandwidthReport(c *gin.Context) {
+	var report domain.BandwidthReport
+	if err := c.ShouldBindJSON(&report); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	for username, used := range data {
-		if err := h.connService.UpdateBandwidthUsedByUsername(c.Request.Context(), username, used); err != nil {
-			log.Printf("[bandwidth-flush] failed for %s: %v", username, err)
-		}
+	applied, err := h.bwService.ApplyReport(c.Request.Context(), &report)
+	if err != nil {
+		log.Printf("[bandwidth-report] %s/%d failed: %v", report.RelayID, report.Seq, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// Forward to peer (dashboard VPS), unless this is already a peer sync
+	if applied && c.Query("from_peer") != "1" {
+		h.connService.SyncBandwidthReport(&report)
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "duplicate": !applied})
+}
+
+// SetLimits updates the session limits for a connection (admin only).
+func (h *ConnectionHandler) SetLimits(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid connection id"})
+		return
+	}
+
+	var req domain.ConnectionLimitsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	conn, err := h.connService.SetLimits(c.Request.Context(), id, req.MaxConcurrentConns, req.MaxConnsPerSecond)
+	switch {
+	case errors.Is(err, service.ErrConnectionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, service.ErrInvalidLimits):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
-	// Forward bandwidth data to peer (dashboard VPS), unless this is already a peer sync
-	if c.Query("from_peer") != "1" {
-		h.connService.SyncBandwidth(data)
+	c.JSON(http.StatusOK, conn)
+}
+
+// LimitsSnapshot is an internal endpoint (no JWT) polled by the tunnel server.
+// Returns {connection_id -> limits} for connections with any limit set.
+func (h *ConnectionHandler) LimitsSnapshot(c *gin.Context) {
+	limits, err := h.connService.GetLimitsSnapshot(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"limits": limits})
+}
+
+// RejectionsFlush is an internal endpoint (no JWT) called by the tunnel server.
+// Receives {connection_id -> rejection deltas} and adds them to the counters.
+func (h *ConnectionHandler) RejectionsFlush(c *gin.Context) {
+	var data map[string]domain.ConnectionRejections
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
+	h.connService.RecordRejections(c.Request.Context(), data)
 	c.JSON(http.StatusOK, gin.H{"ok": true})
 }
 
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -53,7 +54,10 @@ func (h *CustomerAuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	resp, err := h.customerAuthService.Login(c.Request.Context(), &req, c.ClientIP())
+	resp, err := h.customerAuthService.Login(c.Request.Context(), &req, clientInfo(c))
+	if respondTwoFactorChallenge(c, err) {
+		return
+	}
 	if err != nil {
 		if err.Error() == "email_not_verified" {
 			c.JSON(http.StatusForbidden, gin.H{
@@ -103,7 +107,10 @@ func (h *CustomerAuthHandler) VerifyEmail(c *gin.Context) {
 		return
 	}
 
-	resp, err := h.customerAuthService.VerifyEmail(c.Request.Context(), req.Token)
+	resp, err := h.customerAuthService.VerifyEmail(c.Request.Context(), req.Token, clientInfo(c))
+	if respondTwoFactorChallenge(c, err) {
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired verification token"})
 		return
@@ -196,13 +203,19 @@ func (h *CustomerAuthHandler) GoogleCallback(c *gin.Context) {
 	// Delete the state cookie immediately after verification
 	c.SetCookie("oauth_state", "", -1, "/", "", false, true)
 
-	resp, redirectBase, err := h.customerAuthService.GoogleCallback(c.Request.Context(), code, state)
+	resp, redirectBase, err := h.customerAuthService.GoogleCallback(c.Request.Context(), code, state, clientInfo(c))
+	var twoFactor *service.TwoFactorRequiredError
+	if errors.As(err, &twoFactor) {
+		// The login page asks for the code and finishes via /api/auth/2fa/verify
+		c.Redirect(http.StatusFound, fmt.Sprintf("%s/login?two_factor=%s", redirectBase, twoFactor.Challenge.ChallengeToken))
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Google authentication failed"})
 		return
 	}
 
-	// Redirect to dashboard login page; frontend reads token from query param
-	redirectURL := fmt.Sprintf("%s/login?token=%s&google=true", redirectBase, resp.Token)
+	// Redirect to dashboard login page; frontend reads tokens from query params
+	redirectURL := fmt.Sprintf("%s/login?token=%s&refresh_token=%s&google=true", redirectBase, resp.Token, resp.RefreshToken)
 	c.Redirect(http.StatusFound, redirectURL)
 }
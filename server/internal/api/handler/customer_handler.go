@@ -7,10 +7,13 @@ import (
 	"github.com/google/uuid"
 	"github.com/mobileproxy/server/internal/domain"
 	"github.com/mobileproxy/server/internal/repository"
+	"github.com/mobileproxy/server/internal/service"
 )
 
 type CustomerHandler struct {
-	customerRepo *repository.CustomerRepository
+	customerRepo   *repository.CustomerRepository
+	billingService *service.BillingService
+	sessionService *service.SessionService
 }
 
 func NewCustomerHandler(customerRepo *repository.CustomerRepository) *CustomerHandler {
@@ -33,6 +36,9 @@ func (h *CustomerHandler) Create(c *gin.Context) {
 		Email:  body.Email,
 		Active: true,
 	}
+	if resellerID, ok := resellerID(c); ok {
+		customer.ResellerID = &resellerID
+	}
 
 	if err := h.customerRepo.Create(c.Request.Context(), customer); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -43,6 +49,16 @@ func (h *CustomerHandler) Create(c *gin.Context) {
 }
 
 func (h *CustomerHandler) List(c *gin.Context) {
+	if resellerID, ok := resellerID(c); ok {
+		customers, err := h.customerRepo.ListByReseller(c.Request.Context(), resellerID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"customers": customers})
+		return
+	}
+
 	customers, err := h.customerRepo.List(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -136,34 +152,50 @@ func (h *CustomerHandler) Update(c *gin.Context) {
 	c.JSON(http.StatusOK, customer)
 }
 
-// Suspend deactivates a customer account (admin only).
-func (h *CustomerHandler) Suspend(c *gin.Context) {
+// SetBillingService lets manual suspend/activate take precedence over billing.
+func (h *CustomerHandler) SetBillingService(billingService *service.BillingService) {
+	h.billingService = billingService
+}
+
+// SetSessionService makes suspending a customer log them out everywhere.
+func (h *CustomerHandler) SetSessionService(sessionService *service.SessionService) {
+	h.sessionService = sessionService
+}
+
+// setActive toggles a customer's active flag on an admin's behalf.
+func (h *CustomerHandler) setActive(c *gin.Context, active bool) {
 	id, err := uuid.Parse(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid customer id"})
 		return
 	}
 
-	if err := h.customerRepo.UpdateActive(c.Request.Context(), id, false); err != nil {
+	if err := h.customerRepo.UpdateActive(c.Request.Context(), id, active); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if h.billingService != nil {
+		if err := h.billingService.AdminOverride(c.Request.Context(), id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if !active && h.sessionService != nil {
+		if err := h.sessionService.RevokeAll(c.Request.Context(), domain.SessionSubjectCustomer, id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
 	c.JSON(http.StatusOK, gin.H{"ok": true})
 }
 
+// Suspend deactivates a customer account (admin only).
+func (h *CustomerHandler) Suspend(c *gin.Context) {
+	h.setActive(c, false)
+}
+
 // Activate reactivates a suspended customer account (admin only).
 func (h *CustomerHandler) Activate(c *gin.Context) {
-	id, err := uuid.Parse(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid customer id"})
-		return
-	}
-
-	if err := h.customerRepo.UpdateActive(c.Request.Context(), id, true); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"ok": true})
+	h.setActive(c, true)
 }
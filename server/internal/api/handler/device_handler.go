@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"sync"
@@ -22,6 +23,7 @@ type DeviceHandler struct {
 	connService   *service.ConnectionService
 	bwService     *service.BandwidthService
 	shareService  *service.DeviceShareService
+	planService   *service.PlanService
 	wsHub         *WSHub
 	lastBytesMu   sync.Mutex
 	lastBytesMap  map[uuid.UUID]lastBytes
@@ -41,6 +43,10 @@ func (h *DeviceHandler) SetConnectionService(cs *service.ConnectionService) {
 	h.connService = cs
 }
 
+func (h *DeviceHandler) SetPlanService(ps *service.PlanService) {
+	h.planService = ps
+}
+
 func (h *DeviceHandler) Register(c *gin.Context) {
 	var req domain.DeviceRegistrationRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -73,6 +79,16 @@ func (h *DeviceHandler) List(c *gin.Context) {
 		return
 	}
 
+	if resellerID, ok := resellerID(c); ok {
+		devices, err := h.deviceService.ListByReseller(c.Request.Context(), resellerID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"devices": devices})
+		return
+	}
+
 	devices, err := h.deviceService.List(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -277,6 +293,20 @@ func (h *DeviceHandler) SendCommand(c *gin.Context) {
 				c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
 				return
 			}
+			if h.planService != nil {
+				source := "dashboard"
+				if _, viaKey := c.Get("api_key_id"); viaKey {
+					source = "api_key"
+				}
+				if err := h.planService.AllowRotation(c.Request.Context(), customerID, id, source); err != nil {
+					if errors.Is(err, service.ErrPlanLimit) {
+						c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+						return
+					}
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+			}
 		} else {
 			// All other commands (reboot, find_phone, etc.) are admin-only
 			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
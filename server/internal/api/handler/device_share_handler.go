@@ -10,13 +10,19 @@ import (
 )
 
 type DeviceShareHandler struct {
-	shareService *service.DeviceShareService
+	shareService  *service.DeviceShareService
+	inviteService *service.DeviceShareInviteService
 }
 
 func NewDeviceShareHandler(shareService *service.DeviceShareService) *DeviceShareHandler {
 	return &DeviceShareHandler{shareService: shareService}
 }
 
+// SetInviteService enables sharing devices by email invitation.
+func (h *DeviceShareHandler) SetInviteService(is *service.DeviceShareInviteService) {
+	h.inviteService = is
+}
+
 // CreateShare creates a new device share.
 // The caller must be the device owner (customer_id on device == user_id in JWT).
 func (h *DeviceShareHandler) CreateShare(c *gin.Context) {
@@ -150,3 +156,101 @@ func (h *DeviceShareHandler) DeleteShare(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"ok": true})
 }
+
+// ListInvites handles GET /api/share-invites: the caller's pending share
+// invitations, optionally filtered by device_id.
+func (h *DeviceShareHandler) ListInvites(c *gin.Context) {
+	orgID, ok := organizationID(c)
+	if !ok {
+		return
+	}
+	var deviceID *uuid.UUID
+	if deviceIDStr := c.Query("device_id"); deviceIDStr != "" {
+		id, err := uuid.Parse(deviceIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid device_id"})
+			return
+		}
+		deviceID = &id
+	}
+
+	invites, err := h.inviteService.List(c.Request.Context(), orgID, deviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"invites": invites})
+}
+
+// CreateInvite handles POST /api/share-invites: emails an invitation to share
+// one of the caller's devices with an email address.
+func (h *DeviceShareHandler) CreateInvite(c *gin.Context) {
+	orgID, ok := organizationID(c)
+	if !ok {
+		return
+	}
+	var req domain.CreateDeviceShareInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, loginID := sessionSubject(c)
+	invite, err := h.inviteService.Invite(c.Request.Context(), orgID, loginID, &req)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, invite)
+}
+
+// RevokeInvite handles DELETE /api/share-invites/:id.
+func (h *DeviceShareHandler) RevokeInvite(c *gin.Context) {
+	orgID, ok := organizationID(c)
+	if !ok {
+		return
+	}
+	inviteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid invite id"})
+		return
+	}
+
+	if err := h.inviteService.Revoke(c.Request.Context(), orgID, inviteID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// PreviewInvite handles GET /api/public/share-invites/:token, telling the
+// invitee what was shared and whether they need to sign up first.
+func (h *DeviceShareHandler) PreviewInvite(c *gin.Context) {
+	preview, err := h.inviteService.Preview(c.Request.Context(), c.Param("token"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, preview)
+}
+
+// AcceptInvite handles POST /api/share-invites/accept: creates the share for
+// the caller's organization. The caller must be signed in with the invited email.
+func (h *DeviceShareHandler) AcceptInvite(c *gin.Context) {
+	if _, ok := organizationID(c); !ok {
+		return
+	}
+	var req domain.AcceptDeviceShareInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, loginID := sessionSubject(c)
+	share, err := h.inviteService.Accept(c.Request.Context(), req.Token, loginID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, share)
+}
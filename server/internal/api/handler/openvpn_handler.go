@@ -114,6 +114,7 @@ func (h *OpenVPNHandler) Connect(c *gin.Context) {
 	body, _ := json.Marshal(map[string]interface{}{
 		"client_vpn_ip":   req.VpnIP,
 		"device_vpn_ip":   device.VpnIP,
+		"connection_id":   conn.ID.String(),
 		"socks_user":      conn.Username,
 		"socks_pass":      conn.PasswordHash,
 		"bandwidth_limit": conn.BandwidthLimit,
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/service"
+)
+
+// OrganizationHandler manages the caller's organization and its member logins.
+// Writes are owners only (see middleware.RequireOrgRole).
+type OrganizationHandler struct {
+	orgService *service.OrganizationService
+}
+
+func NewOrganizationHandler(orgService *service.OrganizationService) *OrganizationHandler {
+	return &OrganizationHandler{orgService: orgService}
+}
+
+// organizationID returns the caller's organization. Writes a 403 and returns
+// false for staff tokens, which belong to no organization.
+func organizationID(c *gin.Context) (uuid.UUID, bool) {
+	if callerRole(c) != "customer" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "customers only"})
+		return uuid.Nil, false
+	}
+	return callerID(c), true
+}
+
+// Get handles GET /api/organization: the organization, its members and the
+// caller's role.
+func (h *OrganizationHandler) Get(c *gin.Context) {
+	orgID, ok := organizationID(c)
+	if !ok {
+		return
+	}
+	resp, err := h.orgService.Get(c.Request.Context(), orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	role, _ := c.Get("org_role")
+	resp.Role, _ = role.(string)
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetForCustomer handles GET /api/customers/:id/organization (staff): the
+// organization a customer login belongs to.
+func (h *OrganizationHandler) GetForCustomer(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid customer id"})
+		return
+	}
+	orgID, err := h.orgService.OrganizationOf(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	resp, err := h.orgService.Get(c.Request.Context(), orgID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Update handles PUT /api/organization.
+func (h *OrganizationHandler) Update(c *gin.Context) {
+	orgID, ok := organizationID(c)
+	if !ok {
+		return
+	}
+	var req domain.UpdateOrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	org, err := h.orgService.Rename(c.Request.Context(), orgID, req.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, org)
+}
+
+// AddMember handles POST /api/organization/members: creates a login for the
+// new member and emails them a link to choose their password.
+func (h *OrganizationHandler) AddMember(c *gin.Context) {
+	orgID, ok := organizationID(c)
+	if !ok {
+		return
+	}
+	var req domain.AddOrganizationMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	member, err := h.orgService.AddMember(c.Request.Context(), orgID, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, member)
+}
+
+// UpdateMember handles PUT /api/organization/members/:id.
+func (h *OrganizationHandler) UpdateMember(c *gin.Context) {
+	orgID, ok := organizationID(c)
+	if !ok {
+		return
+	}
+	memberID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid member id"})
+		return
+	}
+	var req domain.UpdateOrganizationMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	member, err := h.orgService.UpdateMemberRole(c.Request.Context(), orgID, memberID, req.Role)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, member)
+}
+
+// RemoveMember handles DELETE /api/organization/members/:id. The member's
+// login is disabled and signed out.
+func (h *OrganizationHandler) RemoveMember(c *gin.Context) {
+	orgID, ok := organizationID(c)
+	if !ok {
+		return
+	}
+	memberID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid member id"})
+		return
+	}
+	if err := h.orgService.RemoveMember(c.Request.Context(), orgID, memberID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
@@ -10,13 +10,18 @@ import (
 )
 
 type PairingHandler struct {
-	pairingService *service.PairingService
+	pairingService  *service.PairingService
+	resellerService *service.ResellerService
 }
 
 func NewPairingHandler(pairingService *service.PairingService) *PairingHandler {
 	return &PairingHandler{pairingService: pairingService}
 }
 
+func (h *PairingHandler) SetResellerService(rs *service.ResellerService) {
+	h.resellerService = rs
+}
+
 // CreateCode creates a new pairing code (JWT auth required, admin only).
 // Accepts optional customer_id to stamp device ownership on claim.
 func (h *PairingHandler) CreateCode(c *gin.Context) {
@@ -34,7 +39,20 @@ func (h *PairingHandler) CreateCode(c *gin.Context) {
 		}
 	}
 
-	resp, err := h.pairingService.CreateCode(c.Request.Context(), req.ExpiresInMinutes, createdBy, req.RelayServerID, req.ReassignDeviceID, req.CustomerID)
+	// Codes created by a reseller allocate the paired device to them, and may
+	// only target their own devices and customers.
+	var resellerIDPtr *uuid.UUID
+	if resellerID, ok := resellerID(c); ok {
+		ctx := c.Request.Context()
+		if (req.ReassignDeviceID != nil && !h.resellerService.InScope(ctx, resellerID, "device", *req.ReassignDeviceID)) ||
+			(req.CustomerID != nil && !h.resellerService.InScope(ctx, resellerID, "customer", *req.CustomerID)) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		resellerIDPtr = &resellerID
+	}
+
+	resp, err := h.pairingService.CreateCode(c.Request.Context(), req.ExpiresInMinutes, createdBy, req.RelayServerID, req.ReassignDeviceID, req.CustomerID, resellerIDPtr)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -45,6 +63,16 @@ func (h *PairingHandler) CreateCode(c *gin.Context) {
 
 // ListCodes returns all pairing codes (JWT auth required)
 func (h *PairingHandler) ListCodes(c *gin.Context) {
+	if resellerID, ok := resellerID(c); ok {
+		codes, err := h.pairingService.ListByReseller(c.Request.Context(), resellerID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"pairing_codes": codes})
+		return
+	}
+
 	codes, err := h.pairingService.List(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -78,7 +106,7 @@ func (h *PairingHandler) ClaimCode(c *gin.Context) {
 		return
 	}
 
-	resp, err := h.pairingService.ClaimCode(c.Request.Context(), &req)
+	resp, err := h.pairingService.ClaimCode(c.Request.Context(), &req, c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/service"
+)
+
+type PlanHandler struct {
+	planService *service.PlanService
+}
+
+func NewPlanHandler(planService *service.PlanService) *PlanHandler {
+	return &PlanHandler{planService: planService}
+}
+
+func (h *PlanHandler) List(c *gin.Context) {
+	plans, err := h.planService.List(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"plans": plans})
+}
+
+func (h *PlanHandler) Create(c *gin.Context) {
+	var req domain.PlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	plan, err := h.planService.Create(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, plan)
+}
+
+func (h *PlanHandler) Update(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid plan id"})
+		return
+	}
+	var req domain.PlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	plan, err := h.planService.Update(c.Request.Context(), id, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, plan)
+}
+
+func (h *PlanHandler) Delete(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid plan id"})
+		return
+	}
+	if err := h.planService.Delete(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// AssignCustomerPlan sets a customer's plan; a null plan_id removes it (admin only).
+func (h *PlanHandler) AssignCustomerPlan(c *gin.Context) {
+	customerID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid customer id"})
+		return
+	}
+	var body struct {
+		PlanID *uuid.UUID `json:"plan_id"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.planService.AssignToCustomer(c.Request.Context(), customerID, body.PlanID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// GetCustomerUsage returns a customer's plan and usage (admin only).
+func (h *PlanHandler) GetCustomerUsage(c *gin.Context) {
+	customerID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid customer id"})
+		return
+	}
+	h.respondUsage(c, customerID)
+}
+
+// GetMyPlan returns the calling customer's plan and usage.
+func (h *PlanHandler) GetMyPlan(c *gin.Context) {
+	role, _ := c.Get("user_role")
+	if roleStr, _ := role.(string); roleStr != "customer" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "customers only"})
+		return
+	}
+	userIDVal, _ := c.Get("user_id")
+	customerID, _ := userIDVal.(uuid.UUID)
+	h.respondUsage(c, customerID)
+}
+
+func (h *PlanHandler) respondUsage(c *gin.Context, customerID uuid.UUID) {
+	usage, err := h.planService.GetUsage(c.Request.Context(), customerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, usage)
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/service"
+)
+
+type QuotaHandler struct {
+	quotaService *service.QuotaService
+	connService  *service.ConnectionService
+}
+
+func NewQuotaHandler(quotaService *service.QuotaService, connService *service.ConnectionService) *QuotaHandler {
+	return &QuotaHandler{quotaService: quotaService, connService: connService}
+}
+
+// GetQuota returns a connection's quota policy (null if none), current usage
+// and its most recent completed cycles.
+func (h *QuotaHandler) GetQuota(c *gin.Context) {
+	id, ok := checkConnectionAccess(c, h.connService)
+	if !ok {
+		return
+	}
+
+	conn, err := h.connService.GetByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "connection not found"})
+		return
+	}
+	quota, err := h.quotaService.Get(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	cycles, err := h.quotaService.ListCycles(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"quota":           quota,
+		"bandwidth_limit": conn.BandwidthLimit,
+		"bandwidth_used":  conn.BandwidthUsed,
+		"cycles":          cycles,
+	})
+}
+
+// SetQuota sets a connection's byte cap and quota policy (admin only).
+func (h *QuotaHandler) SetQuota(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid connection id"})
+		return
+	}
+	if _, err := h.connService.GetByID(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "connection not found"})
+		return
+	}
+
+	var req domain.SetQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	quota, err := h.quotaService.Set(c.Request.Context(), id, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, quota)
+}
+
+// DeleteQuota removes a connection's quota policy, leaving a lifetime byte cap (admin only).
+func (h *QuotaHandler) DeleteQuota(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid connection id"})
+		return
+	}
+	if err := h.quotaService.Delete(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/service"
+)
+
+// ResellerHandler serves reseller branding and lets staff allocate devices and
+// customers to resellers. What a reseller may reach is enforced by
+// middleware.ResellerScope and the reseller branches of the list handlers.
+type ResellerHandler struct {
+	resellerService *service.ResellerService
+}
+
+func NewResellerHandler(resellerService *service.ResellerService) *ResellerHandler {
+	return &ResellerHandler{resellerService: resellerService}
+}
+
+// resellerID returns the caller's id when the caller is a reseller.
+func resellerID(c *gin.Context) (uuid.UUID, bool) {
+	if callerRole(c) != "reseller" {
+		return uuid.Nil, false
+	}
+	return callerID(c), true
+}
+
+// GetBranding handles GET /api/reseller/branding.
+func (h *ResellerHandler) GetBranding(c *gin.Context) {
+	id, ok := resellerID(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "resellers only"})
+		return
+	}
+	branding, err := h.resellerService.GetBranding(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, branding)
+}
+
+// UpdateBranding handles PUT /api/reseller/branding.
+func (h *ResellerHandler) UpdateBranding(c *gin.Context) {
+	id, ok := resellerID(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "resellers only"})
+		return
+	}
+	var req domain.UpdateResellerBrandingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	branding, err := h.resellerService.UpdateBranding(c.Request.Context(), id, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, branding)
+}
+
+// AllocateDevice handles PUT /api/devices/:id/reseller (staff). A null
+// reseller_id returns the device to the house.
+func (h *ResellerHandler) AllocateDevice(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid device id"})
+		return
+	}
+	var req domain.AllocateResellerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.resellerService.AllocateDevice(c.Request.Context(), id, req.ResellerID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// AllocateCustomer handles PUT /api/customers/:id/reseller (staff).
+func (h *ResellerHandler) AllocateCustomer(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid customer id"})
+		return
+	}
+	var req domain.AllocateResellerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.resellerService.AllocateCustomer(c.Request.Context(), id, req.ResellerID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// GetMyBranding handles GET /api/branding: the branding of the reseller the
+// calling customer belongs to, or null for direct customers.
+func (h *ResellerHandler) GetMyBranding(c *gin.Context) {
+	if callerRole(c) != "customer" {
+		c.JSON(http.StatusOK, gin.H{"branding": nil})
+		return
+	}
+	branding, err := h.resellerService.BrandingForCustomer(c.Request.Context(), callerID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"branding": branding})
+}
+
+// GetPublicBranding handles GET /api/public/branding?domain=, letting the
+// login page of a reseller's white-label domain brand itself before sign-in.
+func (h *ResellerHandler) GetPublicBranding(c *gin.Context) {
+	host := c.Query("domain")
+	if host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "domain query parameter required"})
+		return
+	}
+	branding, err := h.resellerService.BrandingForDomain(c.Request.Context(), host)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"branding": branding})
+}
@@ -3,6 +3,7 @@ package handler
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"net/http"
 	"strings"
 
@@ -14,8 +15,10 @@ import (
 )
 
 type RotationLinkHandler struct {
-	linkRepo      *repository.RotationLinkRepository
-	deviceService *service.DeviceService
+	linkRepo        *repository.RotationLinkRepository
+	deviceService   *service.DeviceService
+	planService     *service.PlanService
+	resellerService *service.ResellerService
 }
 
 func NewRotationLinkHandler(linkRepo *repository.RotationLinkRepository, deviceService *service.DeviceService) *RotationLinkHandler {
@@ -26,6 +29,16 @@ func NewRotationLinkHandler(linkRepo *repository.RotationLinkRepository, deviceS
 }
 
 // generateToken creates a URL-safe random token
+// SetPlanService enables plan rotation limits for links on customer-owned devices.
+func (h *RotationLinkHandler) SetPlanService(ps *service.PlanService) {
+	h.planService = ps
+}
+
+// SetResellerService confines resellers to links on devices allocated to them.
+func (h *RotationLinkHandler) SetResellerService(rs *service.ResellerService) {
+	h.resellerService = rs
+}
+
 func generateToken() string {
 	b := make([]byte, 24)
 	rand.Read(b)
@@ -50,6 +63,10 @@ func (h *RotationLinkHandler) Create(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
 		return
 	}
+	if resellerID, ok := resellerID(c); ok && !h.resellerService.InScope(c.Request.Context(), resellerID, "device", body.DeviceID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
 
 	link := &domain.RotationLink{
 		ID:       uuid.New(),
@@ -66,13 +83,29 @@ func (h *RotationLinkHandler) Create(c *gin.Context) {
 	c.JSON(http.StatusCreated, link)
 }
 
-// List returns all rotation links for a device (JWT auth required)
+// List returns all rotation links for a device (JWT auth required). Resellers
+// may omit device_id to list links across all their devices.
 func (h *RotationLinkHandler) List(c *gin.Context) {
+	resellerID, isReseller := resellerID(c)
+	if isReseller && c.Query("device_id") == "" {
+		links, err := h.linkRepo.ListByReseller(c.Request.Context(), resellerID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"links": links})
+		return
+	}
+
 	deviceID, err := uuid.Parse(c.Query("device_id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "device_id query parameter required"})
 		return
 	}
+	if isReseller && !h.resellerService.InScope(c.Request.Context(), resellerID, "device", deviceID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
 
 	links, err := h.linkRepo.ListByDevice(c.Request.Context(), deviceID)
 	if err != nil {
@@ -113,6 +146,20 @@ func (h *RotationLinkHandler) Rotate(c *gin.Context) {
 		return
 	}
 
+	// Rotations on a customer's device count against the owner's plan
+	if h.planService != nil {
+		if device, err := h.deviceService.GetByID(c.Request.Context(), link.DeviceID); err == nil && device.CustomerID != nil {
+			if err := h.planService.AllowRotation(c.Request.Context(), *device.CustomerID, link.DeviceID, "rotation_link"); err != nil {
+				if errors.Is(err, service.ErrPlanLimit) {
+					c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+					return
+				}
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+	}
+
 	// Update last used timestamp
 	h.linkRepo.UpdateLastUsed(c.Request.Context(), link.ID)
 
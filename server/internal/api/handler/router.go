@@ -9,6 +9,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/mobileproxy/server/internal/api/middleware"
+	"github.com/mobileproxy/server/internal/domain"
 	"github.com/mobileproxy/server/internal/repository"
 	"github.com/mobileproxy/server/internal/service"
 )
@@ -32,6 +33,30 @@ func SetupRouter(
 	deviceShareHandler *DeviceShareHandler,
 	customerRepo *repository.CustomerRepository,
 	shareService *service.DeviceShareService,
+	aclHandler *ACLHandler,
+	sessionLogHandler *SessionLogHandler,
+	quotaHandler *QuotaHandler,
+	planHandler *PlanHandler,
+	planService *service.PlanService,
+	statementHandler *StatementHandler,
+	billingHandler *BillingHandler,
+	apiKeyHandler *APIKeyHandler,
+	apiKeyService *service.APIKeyService,
+	sessionHandler *SessionHandler,
+	sessionService *service.SessionService,
+	twoFactorHandler *TwoFactorHandler,
+	userHandler *UserHandler,
+	auditHandler *AuditHandler,
+	auditService *service.AuditService,
+	organizationHandler *OrganizationHandler,
+	organizationService *service.OrganizationService,
+	resellerHandler *ResellerHandler,
+	resellerService *service.ResellerService,
+	webhookHandler *WebhookHandler,
+	alertHandler *AlertHandler,
+	telemetryHandler *TelemetryHandler,
+	slaHandler *SLAHandler,
+	relaySecret string,
 ) *gin.Engine {
 	r := gin.Default()
 	r.Use(middleware.CORSMiddleware())
@@ -39,8 +64,11 @@ func SetupRouter(
 	authHandler := NewAuthHandler(authService)
 	deviceHandler := NewDeviceHandler(deviceService, bwService, wsHub, shareService)
 	deviceHandler.SetConnectionService(connService)
+	deviceHandler.SetPlanService(planService)
 	connHandler := NewConnectionHandler(connService)
 	connHandler.SetShareService(shareService)
+	connHandler.SetBandwidthService(bwService)
+	connHandler.SetResellerService(resellerService)
 
 	// Health check
 	r.GET("/health", func(c *gin.Context) {
@@ -49,6 +77,11 @@ func SetupRouter(
 
 	// Public routes
 	r.POST("/api/auth/login", authHandler.Login)
+	r.POST("/api/auth/refresh", sessionHandler.Refresh)
+	r.POST("/api/auth/logout", sessionHandler.Logout)
+	r.POST("/api/auth/2fa/verify", twoFactorHandler.Verify)
+	r.POST("/api/auth/2fa/setup", twoFactorHandler.Setup)
+	r.POST("/api/auth/staff/set-password", userHandler.SetPassword)
 
 	// Customer auth routes (public — no JWT required)
 	customerAuth := r.Group("/api/auth/customer")
@@ -67,6 +100,9 @@ func SetupRouter(
 	// Public endpoints (no auth)
 	r.GET("/api/public/rotate/:token", rotationLinkHandler.Rotate)
 	r.POST("/api/public/pair", pairingHandler.ClaimCode)
+	r.POST("/api/public/billing/webhook", billingHandler.Webhook)
+	r.GET("/api/public/branding", resellerHandler.GetPublicBranding)
+	r.GET("/api/public/share-invites/:token", deviceShareHandler.PreviewInvite)
 
 	// Device routes (authenticated by device token in future, open for MVP)
 	deviceAPI := r.Group("/api/devices")
@@ -78,8 +114,17 @@ func SetupRouter(
 
 	// Dashboard routes (JWT protected) — all authenticated users pass here
 	dashboard := r.Group("/api")
-	dashboard.Use(middleware.AuthMiddleware(authService))
+	dashboard.Use(middleware.AuthMiddleware(authService, sessionService, apiKeyService))
 	dashboard.Use(middleware.CustomerSuspensionCheck(customerRepo))
+	dashboard.Use(middleware.StaffAccessCheck(userRepo))
+	dashboard.Use(middleware.ResellerScope(resellerService))
+	dashboard.Use(middleware.OrganizationContext(organizationService))
+	dashboard.Use(middleware.Audit(auditService))
+
+	// Per-route permission checks for operators (admins hold all permissions)
+	can := middleware.RequirePermission
+	// Per-route organization role checks for customer members
+	ownerOnly := middleware.RequireOrgRole(domain.OrgRoleOwner)
 
 	// Admin-only sub-group: blocks customer tokens with 403
 	adminOnly := dashboard.Group("")
@@ -88,23 +133,56 @@ func SetupRouter(
 		adminOnly.GET("/stats/overview", statsHandler.Overview)
 
 		adminOnly.GET("/customers", customerHandler.List)
-		adminOnly.POST("/customers", customerHandler.Create)
+		adminOnly.POST("/customers", can(domain.PermCustomersManage), customerHandler.Create)
 		adminOnly.GET("/customers/:id", customerHandler.GetDetail)
-		adminOnly.PUT("/customers/:id", customerHandler.Update)
-		adminOnly.POST("/customers/:id/suspend", customerHandler.Suspend)
-		adminOnly.POST("/customers/:id/activate", customerHandler.Activate)
+		adminOnly.PUT("/customers/:id", can(domain.PermCustomersManage), customerHandler.Update)
+		adminOnly.POST("/customers/:id/suspend", can(domain.PermCustomersManage), customerHandler.Suspend)
+		adminOnly.POST("/customers/:id/activate", can(domain.PermCustomersManage), customerHandler.Activate)
+		adminOnly.GET("/customers/:id/plan", planHandler.GetCustomerUsage)
+		adminOnly.PUT("/customers/:id/plan", can(domain.PermCustomersManage), planHandler.AssignCustomerPlan)
+		adminOnly.DELETE("/customers/:id/2fa", can(domain.PermCustomersManage), twoFactorHandler.ResetCustomer)
+		adminOnly.GET("/customers/:id/organization", organizationHandler.GetForCustomer)
+		adminOnly.PUT("/customers/:id/reseller", can(domain.PermCustomersManage), resellerHandler.AllocateCustomer)
+		adminOnly.PUT("/devices/:id/reseller", can(domain.PermDevicesManage), resellerHandler.AllocateDevice)
+
+		// Reseller tenants: white-label branding
+		adminOnly.GET("/reseller/branding", resellerHandler.GetBranding)
+		adminOnly.PUT("/reseller/branding", resellerHandler.UpdateBranding)
+
+		adminOnly.GET("/plans", planHandler.List)
+		adminOnly.POST("/plans", can(domain.PermPlansManage), planHandler.Create)
+		adminOnly.PUT("/plans/:id", can(domain.PermPlansManage), planHandler.Update)
+		adminOnly.DELETE("/plans/:id", can(domain.PermPlansManage), planHandler.Delete)
 
 		adminOnly.GET("/rotation-links", rotationLinkHandler.List)
-		adminOnly.POST("/rotation-links", rotationLinkHandler.Create)
-		adminOnly.DELETE("/rotation-links/:id", rotationLinkHandler.Delete)
+		adminOnly.POST("/rotation-links", can(domain.PermDevicesCommand), rotationLinkHandler.Create)
+		adminOnly.DELETE("/rotation-links/:id", can(domain.PermDevicesCommand), rotationLinkHandler.Delete)
 
 		adminOnly.GET("/pairing-codes", pairingHandler.ListCodes)
-		adminOnly.POST("/pairing-codes", pairingHandler.CreateCode)
-		adminOnly.DELETE("/pairing-codes/:id", pairingHandler.DeleteCode)
+		adminOnly.POST("/pairing-codes", can(domain.PermDevicesManage), pairingHandler.CreateCode)
+		adminOnly.DELETE("/pairing-codes/:id", can(domain.PermDevicesManage), pairingHandler.DeleteCode)
 
 		adminOnly.GET("/relay-servers", relayServerHandler.List)
 		adminOnly.GET("/relay-servers/active", relayServerHandler.ListActive)
-		adminOnly.POST("/relay-servers", relayServerHandler.Create)
+		adminOnly.POST("/relay-servers", can(domain.PermRelaysManage), relayServerHandler.Create)
+
+		// Destination ACLs: global policy + per-connection rule changes
+		adminOnly.GET("/acl/global", aclHandler.GetGlobalACL)
+		adminOnly.PUT("/acl/global", can(domain.PermACLManage), aclHandler.SetGlobalACL)
+		adminOnly.GET("/acl/denials", aclHandler.ListDenials)
+		adminOnly.PUT("/connections/:id/acl", can(domain.PermConnectionsWrite), aclHandler.SetConnectionACL)
+		adminOnly.PUT("/connections/:id/limits", can(domain.PermConnectionsWrite), connHandler.SetLimits)
+		adminOnly.PUT("/connections/:id/quota", can(domain.PermConnectionsWrite), quotaHandler.SetQuota)
+		adminOnly.DELETE("/connections/:id/quota", can(domain.PermConnectionsWrite), quotaHandler.DeleteQuota)
+
+		// Maintenance windows, excluded from uptime SLA reports
+		adminOnly.GET("/maintenance-windows", slaHandler.ListMaintenanceWindows)
+		adminOnly.POST("/maintenance-windows", can(domain.PermDevicesManage), slaHandler.CreateMaintenanceWindow)
+		adminOnly.DELETE("/maintenance-windows/:id", can(domain.PermDevicesManage), slaHandler.DeleteMaintenanceWindow)
+
+		// Settings: security (2FA requirement for operators)
+		adminOnly.GET("/settings/security", twoFactorHandler.GetSettings)
+		adminOnly.PUT("/settings/security", twoFactorHandler.UpdateSettings)
 
 		// Settings: webhook URL management (admin only)
 		adminOnly.GET("/settings/webhook", func(c *gin.Context) {
@@ -118,7 +196,7 @@ func SetupRouter(
 			c.JSON(http.StatusOK, gin.H{"webhook_url": user.WebhookURL})
 		})
 
-		adminOnly.PUT("/settings/webhook", func(c *gin.Context) {
+		adminOnly.PUT("/settings/webhook", can(domain.PermSettingsWebhook), func(c *gin.Context) {
 			var body struct {
 				WebhookURL string `json:"webhook_url"`
 			}
@@ -139,7 +217,7 @@ func SetupRouter(
 			c.JSON(http.StatusOK, gin.H{"ok": true})
 		})
 
-		adminOnly.POST("/settings/webhook/test", func(c *gin.Context) {
+		adminOnly.POST("/settings/webhook/test", can(domain.PermSettingsWebhook), func(c *gin.Context) {
 			var body struct {
 				WebhookURL string `json:"webhook_url"`
 			}
@@ -163,31 +241,112 @@ func SetupRouter(
 		})
 	}
 
+	// Staff user management: admins only, so operators cannot grant themselves permissions
+	admins := adminOnly.Group("")
+	admins.Use(middleware.RequireAdmin())
+	{
+		admins.GET("/users", userHandler.List)
+		admins.POST("/users", userHandler.Create)
+		admins.GET("/users/:id", userHandler.Get)
+		admins.PUT("/users/:id", userHandler.Update)
+		admins.POST("/users/:id/disable", userHandler.Disable)
+		admins.POST("/users/:id/enable", userHandler.Enable)
+		admins.POST("/users/:id/reset-password", userHandler.ResetPassword)
+		admins.DELETE("/users/:id/2fa", twoFactorHandler.ResetUser)
+	}
+
 	// Mixed-access routes: device and connection endpoints (handlers branch internally by role)
 	{
+		dashboard.GET("/audit", auditHandler.List)
+		dashboard.GET("/customers/:id/audit", auditHandler.ListForCustomer)
+
+		dashboard.GET("/plan", planHandler.GetMyPlan)
+		dashboard.GET("/customers/:id/statements", statementHandler.List)
+		dashboard.GET("/customers/:id/statements/:period", statementHandler.Get)
+		dashboard.GET("/customers/:id/billing", billingHandler.GetAccount)
+		dashboard.POST("/billing/checkout", ownerOnly, billingHandler.Checkout)
+
+		dashboard.GET("/api-keys", apiKeyHandler.ListMine)
+		dashboard.POST("/api-keys", ownerOnly, apiKeyHandler.Create)
+		dashboard.DELETE("/api-keys/:id", ownerOnly, apiKeyHandler.Revoke)
+		dashboard.GET("/customers/:id/api-keys", apiKeyHandler.ListForCustomer)
+
+		dashboard.GET("/branding", resellerHandler.GetMyBranding)
+
+		dashboard.GET("/organization", organizationHandler.Get)
+		dashboard.PUT("/organization", ownerOnly, organizationHandler.Update)
+		dashboard.POST("/organization/members", ownerOnly, organizationHandler.AddMember)
+		dashboard.PUT("/organization/members/:id", ownerOnly, organizationHandler.UpdateMember)
+		dashboard.DELETE("/organization/members/:id", ownerOnly, organizationHandler.RemoveMember)
+
+		dashboard.GET("/sessions", sessionHandler.List)
+		dashboard.DELETE("/sessions/:id", sessionHandler.Revoke)
+		dashboard.POST("/sessions/revoke-all", sessionHandler.RevokeAll)
+
+		dashboard.GET("/2fa", twoFactorHandler.Status)
+		dashboard.POST("/2fa/enroll", twoFactorHandler.Enroll)
+		dashboard.POST("/2fa/confirm", twoFactorHandler.Confirm)
+		dashboard.POST("/2fa/disable", twoFactorHandler.Disable)
+		dashboard.POST("/2fa/recovery-codes", twoFactorHandler.RegenerateRecoveryCodes)
+
 		dashboard.GET("/devices", deviceHandler.List)
 		dashboard.GET("/devices/:id", deviceHandler.GetByID)
-		dashboard.PATCH("/devices/:id", deviceHandler.Update)
-		dashboard.POST("/devices/:id/commands", deviceHandler.SendCommand)
+		dashboard.PATCH("/devices/:id", can(domain.PermDevicesManage), deviceHandler.Update)
+		dashboard.POST("/devices/:id/commands", can(domain.PermDevicesCommand), deviceHandler.SendCommand)
 		dashboard.GET("/devices/:id/ip-history", deviceHandler.GetIPHistory)
 		dashboard.GET("/devices/:id/bandwidth", deviceHandler.GetBandwidth)
 		dashboard.GET("/devices/:id/bandwidth/hourly", deviceHandler.GetBandwidthHourly)
 		dashboard.GET("/devices/:id/uptime", deviceHandler.GetUptime)
+		dashboard.GET("/devices/:id/telemetry", telemetryHandler.GetDeviceTelemetry)
 		dashboard.GET("/devices/:id/commands", deviceHandler.GetCommands)
 
 		dashboard.GET("/connections", connHandler.List)
-		dashboard.POST("/connections", connHandler.Create)
+		dashboard.POST("/connections", can(domain.PermConnectionsWrite), connHandler.Create)
 		dashboard.GET("/connections/:id", connHandler.GetByID)
-		dashboard.PATCH("/connections/:id", connHandler.SetActive)
-		dashboard.DELETE("/connections/:id", connHandler.Delete)
-		dashboard.POST("/connections/:id/regenerate-password", connHandler.RegeneratePassword)
-		dashboard.POST("/connections/:id/reset-bandwidth", connHandler.ResetBandwidth)
+		dashboard.PATCH("/connections/:id", can(domain.PermConnectionsWrite), connHandler.SetActive)
+		dashboard.DELETE("/connections/:id", can(domain.PermConnectionsWrite), connHandler.Delete)
+		dashboard.POST("/connections/:id/regenerate-password", can(domain.PermConnectionsWrite), connHandler.RegeneratePassword)
+		dashboard.POST("/connections/:id/reset-bandwidth", can(domain.PermConnectionsWrite), connHandler.ResetBandwidth)
+		dashboard.GET("/connections/:id/acl", aclHandler.GetConnectionACL)
+		dashboard.GET("/connections/:id/acl/denials", aclHandler.ListConnectionDenials)
+		dashboard.GET("/connections/:id/sessions", sessionLogHandler.ListConnectionSessions)
+		dashboard.GET("/connections/:id/bandwidth", connHandler.GetBandwidth)
+		dashboard.GET("/connections/:id/bandwidth/hourly", connHandler.GetBandwidthHourly)
+		dashboard.GET("/connections/:id/bandwidth/daily", connHandler.GetBandwidthDaily)
+		dashboard.GET("/connections/:id/quota", quotaHandler.GetQuota)
 
 		// Device shares (accessible to authenticated users — handler checks ownership)
 		dashboard.GET("/device-shares", deviceShareHandler.ListShares)
-		dashboard.POST("/device-shares", deviceShareHandler.CreateShare)
-		dashboard.PUT("/device-shares/:id", deviceShareHandler.UpdateShare)
-		dashboard.DELETE("/device-shares/:id", deviceShareHandler.DeleteShare)
+		dashboard.POST("/device-shares", can(domain.PermCustomersManage), deviceShareHandler.CreateShare)
+		dashboard.PUT("/device-shares/:id", can(domain.PermCustomersManage), deviceShareHandler.UpdateShare)
+		dashboard.DELETE("/device-shares/:id", can(domain.PermCustomersManage), deviceShareHandler.DeleteShare)
+
+		// Device share invitations by email (customers)
+		dashboard.GET("/share-invites", deviceShareHandler.ListInvites)
+		dashboard.POST("/share-invites", deviceShareHandler.CreateInvite)
+		dashboard.DELETE("/share-invites/:id", deviceShareHandler.RevokeInvite)
+		dashboard.POST("/share-invites/accept", deviceShareHandler.AcceptInvite)
+
+		// Webhook endpoints and delivery log (staff own theirs, customers their organization's)
+		dashboard.GET("/webhooks", webhookHandler.List)
+		dashboard.POST("/webhooks", can(domain.PermSettingsWebhook), ownerOnly, webhookHandler.Create)
+		dashboard.PUT("/webhooks/:id", can(domain.PermSettingsWebhook), ownerOnly, webhookHandler.Update)
+		dashboard.DELETE("/webhooks/:id", can(domain.PermSettingsWebhook), ownerOnly, webhookHandler.Delete)
+		dashboard.POST("/webhooks/:id/rotate-secret", can(domain.PermSettingsWebhook), ownerOnly, webhookHandler.RotateSecret)
+		dashboard.POST("/webhooks/:id/test", can(domain.PermSettingsWebhook), ownerOnly, webhookHandler.Test)
+		dashboard.GET("/webhook-deliveries", webhookHandler.ListDeliveries)
+		dashboard.POST("/webhook-deliveries/:id/redeliver", can(domain.PermSettingsWebhook), ownerOnly, webhookHandler.Redeliver)
+
+		// Alert rules over device telemetry and their incidents (owned like webhooks)
+		dashboard.GET("/alert-rules", alertHandler.ListRules)
+		dashboard.POST("/alert-rules", can(domain.PermDevicesManage), ownerOnly, alertHandler.CreateRule)
+		dashboard.PUT("/alert-rules/:id", can(domain.PermDevicesManage), ownerOnly, alertHandler.UpdateRule)
+		dashboard.DELETE("/alert-rules/:id", can(domain.PermDevicesManage), ownerOnly, alertHandler.DeleteRule)
+		dashboard.GET("/alert-incidents", alertHandler.ListIncidents)
+		dashboard.POST("/alert-incidents/:id/resolve", can(domain.PermDevicesManage), ownerOnly, alertHandler.ResolveIncident)
+
+		// Uptime SLA report over the devices the caller can see (?format=csv to download)
+		dashboard.GET("/sla", slaHandler.Report)
 	}
 
 	// Internal VPN routes (called by OpenVPN scripts)
@@ -202,14 +361,31 @@ func SetupRouter(
 	// Internal sync routes (called by peer server)
 	if syncHandler != nil {
 		syncGroup := r.Group("/api/internal/sync")
+		syncGroup.Use(middleware.Audit(auditService))
 		{
 			syncGroup.POST("/device", syncHandler.SyncDevice)
 			syncGroup.POST("/connections", syncHandler.SyncConnections)
 		}
 	}
 
-	// Internal bandwidth flush (called by tunnel server, no JWT)
-	r.POST("/api/internal/bandwidth-flush", connHandler.BandwidthFlush)
+	// Internal relay routes (called by tunnel servers with the shared relay secret)
+	relay := r.Group("/api/internal")
+	relay.Use(middleware.RelayAuth(relaySecret))
+	{
+		// Sequence-numbered bandwidth reports (also forwarded by the peer API)
+		relay.POST("/bandwidth-report", connHandler.BandwidthReport)
+
+		// ACL policy pull + denial reports
+		relay.GET("/acl-policy", aclHandler.Policy)
+		relay.POST("/acl-denials", aclHandler.RecordDenials)
+
+		// Session limits pull + rejection counters
+		relay.GET("/connection-limits", connHandler.LimitsSnapshot)
+		relay.POST("/connection-rejections", connHandler.RejectionsFlush)
+
+		// Session access log
+		relay.POST("/connection-sessions", sessionLogHandler.RecordSessions)
+	}
 
 	// Internal OpenVPN client routes (called by OpenVPN client-server scripts)
 	if openvpnHandler != nil {
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/service"
+)
+
+type SessionHandler struct {
+	sessionService *service.SessionService
+}
+
+func NewSessionHandler(sessionService *service.SessionService) *SessionHandler {
+	return &SessionHandler{sessionService: sessionService}
+}
+
+// clientInfo describes the caller for a new or refreshed session.
+func clientInfo(c *gin.Context) domain.ClientInfo {
+	return domain.ClientInfo{IP: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+}
+
+// sessionSubject returns the subject type and id of the calling token. For
+// organization members that is their own login, not the organization.
+func sessionSubject(c *gin.Context) (string, uuid.UUID) {
+	role, _ := c.Get("user_role")
+	userIDVal, _ := c.Get("user_id")
+	userID, _ := userIDVal.(uuid.UUID)
+	if roleStr, _ := role.(string); roleStr == "customer" {
+		if memberIDVal, ok := c.Get("member_id"); ok {
+			userID, _ = memberIDVal.(uuid.UUID)
+		}
+		return domain.SessionSubjectCustomer, userID
+	}
+	return domain.SessionSubjectUser, userID
+}
+
+// Refresh handles POST /api/auth/refresh. The refresh token is single-use; the
+// response carries its replacement.
+func (h *SessionHandler) Refresh(c *gin.Context) {
+	var req domain.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pair, err := h.sessionService.Refresh(c.Request.Context(), req.RefreshToken, clientInfo(c))
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidRefreshToken) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, pair)
+}
+
+// Logout handles POST /api/auth/logout and ends the refresh token's session.
+func (h *SessionHandler) Logout(c *gin.Context) {
+	var req domain.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.sessionService.Logout(c.Request.Context(), req.RefreshToken); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// List returns the caller's active sessions.
+func (h *SessionHandler) List(c *gin.Context) {
+	if _, ok := c.Get("api_key_id"); ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not available to api keys"})
+		return
+	}
+	subjectType, subjectID := sessionSubject(c)
+	sessionVal, _ := c.Get("session_id")
+	current, _ := sessionVal.(uuid.UUID)
+
+	sessions, err := h.sessionService.List(c.Request.Context(), subjectType, subjectID, current)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// Revoke ends one of the caller's sessions.
+func (h *SessionHandler) Revoke(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session id"})
+		return
+	}
+	subjectType, subjectID := sessionSubject(c)
+
+	if err := h.sessionService.Revoke(c.Request.Context(), id, subjectType, subjectID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// RevokeAll logs the caller out everywhere, including the calling session.
+func (h *SessionHandler) RevokeAll(c *gin.Context) {
+	subjectType, subjectID := sessionSubject(c)
+
+	if err := h.sessionService.RevokeAll(c.Request.Context(), subjectType, subjectID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
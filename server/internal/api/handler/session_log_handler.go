@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/service"
+)
+
+type SessionLogHandler struct {
+	sessionService *service.SessionLogService
+	connService    *service.ConnectionService
+}
+
+func NewSessionLogHandler(sessionService *service.SessionLogService, connService *service.ConnectionService) *SessionLogHandler {
+	return &SessionLogHandler{sessionService: sessionService, connService: connService}
+}
+
+// ListConnectionSessions returns the access log for a connection.
+// Query params: from, to (RFC 3339, default last 24h) and limit.
+func (h *SessionLogHandler) ListConnectionSessions(c *gin.Context) {
+	id, ok := checkConnectionAccess(c, h.connService)
+	if !ok {
+		return
+	}
+
+	var from, to time.Time
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from, use RFC 3339"})
+			return
+		}
+		from = t
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to, use RFC 3339"})
+			return
+		}
+		to = t
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	sessions, err := h.sessionService.ListByConnection(c.Request.Context(), id, from, to, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// RecordSessions is an internal endpoint (no JWT) where tunnel servers report finished sessions.
+func (h *SessionLogHandler) RecordSessions(c *gin.Context) {
+	var sessions []domain.ConnectionSession
+	if err := c.ShouldBindJSON(&sessions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(sessions) == 0 {
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+		return
+	}
+	if err := h.sessionService.Record(c.Request.Context(), sessions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/service"
+)
+
+type SLAHandler struct {
+	slaService *service.SLAService
+}
+
+func NewSLAHandler(slaService *service.SLAService) *SLAHandler {
+	return &SLAHandler{slaService: slaService}
+}
+
+// Report handles GET /api/sla. Customers get their own devices, resellers
+// their allocated devices and staff the whole fleet.
+// Query params: period (YYYY-MM or YYYY-Www, default current month), target
+// (uptime percentage), customer_id, device_id and format (json or csv).
+func (h *SLAHandler) Report(c *gin.Context) {
+	q := service.SLAQuery{Period: c.Query("period")}
+	if v := c.Query("target"); v != "" {
+		target, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid target"})
+			return
+		}
+		q.Target = &target
+	}
+	if v := c.Query("customer_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid customer_id"})
+			return
+		}
+		q.CustomerID = &id
+	}
+	if v := c.Query("device_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid device_id"})
+			return
+		}
+		q.DeviceID = &id
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+		return
+	}
+
+	var report *domain.SLAReport
+	var err error
+	if callerRole(c) == "customer" {
+		report, err = h.slaService.ReportForCustomer(c.Request.Context(), callerID(c), q)
+	} else if resellerID, ok := resellerID(c); ok {
+		report, err = h.slaService.ReportForReseller(c.Request.Context(), resellerID, q)
+	} else {
+		report, err = h.slaService.Report(c.Request.Context(), q)
+	}
+	if errors.Is(err, service.ErrSLADeviceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if format == "csv" {
+		data, err := slaCSV(report)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "sla-"+report.Period+".csv"))
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", data)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// slaCSV writes one row per device followed by a fleet total row.
+func slaCSV(r *domain.SLAReport) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"period", "device_id", "device_name", "customer_id", "uptime_percent", "up_seconds", "down_seconds",
+		"maintenance_seconds", "unknown_seconds", "outages", "longest_outage_seconds", "met"})
+	row := func(id, name, customer string, s *domain.SLAStats) {
+		pct, met := "", ""
+		if s.UptimePercent != nil {
+			pct = strconv.FormatFloat(*s.UptimePercent, 'f', 3, 64)
+		}
+		if s.Met != nil {
+			met = strconv.FormatBool(*s.Met)
+		}
+		w.Write([]string{
+			r.Period, id, name, customer, pct,
+			strconv.FormatInt(s.UpSeconds, 10), strconv.FormatInt(s.DownSeconds, 10),
+			strconv.FormatInt(s.MaintenanceSeconds, 10), strconv.FormatInt(s.UnknownSeconds, 10),
+			strconv.Itoa(s.Outages), strconv.FormatInt(s.LongestOutageSeconds, 10), met,
+		})
+	}
+	for i := range r.Devices {
+		d := &r.Devices[i]
+		customer := ""
+		if d.CustomerID != nil {
+			customer = d.CustomerID.String()
+		}
+		row(d.DeviceID.String(), d.DeviceName, customer, &d.SLAStats)
+	}
+	row("TOTAL", fmt.Sprintf("%d devices", len(r.Devices)), "", &r.Fleet)
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// ListMaintenanceWindows handles GET /api/maintenance-windows.
+func (h *SLAHandler) ListMaintenanceWindows(c *gin.Context) {
+	windows, err := h.slaService.ListMaintenanceWindows(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"maintenance_windows": windows})
+}
+
+// CreateMaintenanceWindow handles POST /api/maintenance-windows.
+func (h *SLAHandler) CreateMaintenanceWindow(c *gin.Context) {
+	var req domain.MaintenanceWindowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	createdBy := callerID(c)
+	w, err := h.slaService.CreateMaintenanceWindow(c.Request.Context(), &req, &createdBy)
+	if errors.Is(err, service.ErrSLADeviceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, w)
+}
+
+// DeleteMaintenanceWindow handles DELETE /api/maintenance-windows/:id.
+func (h *SLAHandler) DeleteMaintenanceWindow(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid maintenance window id"})
+		return
+	}
+	err = h.slaService.DeleteMaintenanceWindow(c.Request.Context(), id)
+	if errors.Is(err, service.ErrMaintenanceWindowStarted) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/service"
+)
+
+type StatementHandler struct {
+	statementService *service.StatementService
+}
+
+func NewStatementHandler(statementService *service.StatementService) *StatementHandler {
+	return &StatementHandler{statementService: statementService}
+}
+
+// customerAccess resolves :id and lets admins see any customer and customers
+// only themselves. Writes the error response and returns false if denied.
+func customerAccess(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid customer id"})
+		return uuid.Nil, false
+	}
+	role, _ := c.Get("user_role")
+	if roleStr, _ := role.(string); roleStr == "customer" {
+		userIDVal, _ := c.Get("user_id")
+		if userID, _ := userIDVal.(uuid.UUID); userID != id {
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return uuid.Nil, false
+		}
+	}
+	return id, true
+}
+
+// List returns the open statement for the current month followed by closed ones.
+func (h *StatementHandler) List(c *gin.Context) {
+	customerID, ok := customerAccess(c)
+	if !ok {
+		return
+	}
+	statements, err := h.statementService.List(c.Request.Context(), customerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"statements": statements})
+}
+
+// Get returns one month's statement. :period is YYYY-MM or "current";
+// ?format= json (default), csv or html.
+func (h *StatementHandler) Get(c *gin.Context) {
+	customerID, ok := customerAccess(c)
+	if !ok {
+		return
+	}
+
+	period := time.Now().UTC()
+	if p := c.Param("period"); p != "current" {
+		t, err := time.Parse("2006-01", p)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid period, use YYYY-MM"})
+			return
+		}
+		period = t
+	}
+
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" && format != "html" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json, csv or html"})
+		return
+	}
+
+	st, err := h.statementService.Get(c.Request.Context(), customerID, period)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filename := fmt.Sprintf("statement-%s-%s", customerID, st.PeriodStart.Format("2006-01"))
+	switch format {
+	case "csv":
+		data, err := statementCSV(st)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".csv"))
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", data)
+	case "html":
+		var buf bytes.Buffer
+		if err := statementHTML.Execute(&buf, st); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+	default:
+		c.JSON(http.StatusOK, st)
+	}
+}
+
+// statementCSV writes one row per connection followed by a total row.
+func statementCSV(st *domain.Statement) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"period", "connection_id", "device_id", "username", "proxy_type", "days", "bytes_in", "bytes_out"})
+	period := st.PeriodStart.Format("2006-01")
+	for _, l := range st.Lines {
+		w.Write([]string{
+			period, l.ConnectionID.String(), l.DeviceID.String(), l.Username, l.ProxyType,
+			strconv.Itoa(l.Days), strconv.FormatInt(l.BytesIn, 10), strconv.FormatInt(l.BytesOut, 10),
+		})
+	}
+	w.Write([]string{
+		period, "TOTAL", fmt.Sprintf("%d devices / %d device-days / %d rotations", st.Devices, st.DeviceDays, st.Rotations),
+		"", "", strconv.Itoa(st.ConnectionDays), strconv.FormatInt(st.BytesIn, 10), strconv.FormatInt(st.BytesOut, 10),
+	})
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+var statementHTML = template.Must(template.New("statement").Funcs(template.FuncMap{
+	"gb":    func(b int64) string { return fmt.Sprintf("%.2f", float64(b)/(1<<30)) },
+	"month": func(t time.Time) string { return t.Format("January 2006") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Usage statement {{month .PeriodStart}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; color: #222; margin: 40px; }
+table { border-collapse: collapse; width: 100%; margin-top: 16px; }
+th, td { border-bottom: 1px solid #ddd; padding: 6px 8px; text-align: left; }
+td.num, th.num { text-align: right; }
+.muted { color: #888; }
+</style>
+</head>
+<body>
+<h1>Usage statement &mdash; {{month .PeriodStart}}</h1>
+<p class="muted">Customer {{.CustomerID}}{{if .PlanName}} &middot; Plan {{.PlanName}}{{end}} &middot; {{if eq .Status "closed"}}Closed {{.ClosedAt.Format "2006-01-02"}}{{else}}Open, figures may still change{{end}}</p>
+<table>
+<tr><th>Devices held</th><td class="num">{{.Devices}}</td></tr>
+<tr><th>Device-days</th><td class="num">{{.DeviceDays}}</td></tr>
+<tr><th>Connection-days</th><td class="num">{{.ConnectionDays}}</td></tr>
+<tr><th>Transferred in (GB)</th><td class="num">{{gb .BytesIn}}</td></tr>
+<tr><th>Transferred out (GB)</th><td class="num">{{gb .BytesOut}}</td></tr>
+<tr><th>IP rotations</th><td class="num">{{.Rotations}}</td></tr>
+</table>
+<h2>Connections</h2>
+<table>
+<tr><th>Username</th><th>Type</th><th>Device</th><th class="num">Days</th><th class="num">In (GB)</th><th class="num">Out (GB)</th></tr>
+{{range .Lines}}<tr><td>{{.Username}}</td><td>{{.ProxyType}}</td><td>{{.DeviceID}}</td><td class="num">{{.Days}}</td><td class="num">{{gb .BytesIn}}</td><td class="num">{{gb .BytesOut}}</td></tr>
+{{else}}<tr><td colspan="6" class="muted">No connections this period</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -86,6 +86,8 @@ type syncConnectionItem struct {
 	BasePort      *int       `json:"base_port"`
 	HTTPPort      *int       `json:"http_port"`
 	SOCKS5Port    *int       `json:"socks5_port"`
+	MaxConcurrentConns int   `json:"max_concurrent_conns"`
+	MaxConnsPerSecond  int   `json:"max_conns_per_second"`
 	ExpiresAt     *time.Time `json:"expires_at"`
 	CreatedAt     time.Time  `json:"created_at"`
 	UpdatedAt     time.Time  `json:"updated_at"`
@@ -120,6 +122,8 @@ func (h *SyncHandler) SyncConnections(c *gin.Context) {
 			BasePort:       ci.BasePort,
 			HTTPPort:       ci.HTTPPort,
 			SOCKS5Port:     ci.SOCKS5Port,
+			MaxConcurrentConns: ci.MaxConcurrentConns,
+			MaxConnsPerSecond:  ci.MaxConnsPerSecond,
 			ExpiresAt:      ci.ExpiresAt,
 			CreatedAt:      ci.CreatedAt,
 			UpdatedAt:      ci.UpdatedAt,
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/service"
+)
+
+type TelemetryHandler struct {
+	telemetryService *service.TelemetryService
+	deviceService    *service.DeviceService
+	shareService     *service.DeviceShareService
+}
+
+func NewTelemetryHandler(telemetryService *service.TelemetryService, deviceService *service.DeviceService, shareService *service.DeviceShareService) *TelemetryHandler {
+	return &TelemetryHandler{telemetryService: telemetryService, deviceService: deviceService, shareService: shareService}
+}
+
+// GetDeviceTelemetry returns one telemetry metric of a device as a time series.
+// Query params: metric (signal, battery, network, carrier), from, to (RFC 3339,
+// default last 24h) and resolution (raw or hour, default raw up to 48h).
+func (h *TelemetryHandler) GetDeviceTelemetry(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid device id"})
+		return
+	}
+
+	role, _ := c.Get("user_role")
+	roleStr, _ := role.(string)
+
+	if roleStr == "customer" {
+		userIDVal, _ := c.Get("user_id")
+		customerID, _ := userIDVal.(uuid.UUID)
+		allowed, err := h.shareService.CanAccess(c.Request.Context(), id, customerID)
+		if err != nil || !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+	} else if _, err := h.deviceService.GetByID(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+
+	var from, to time.Time
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from, use RFC 3339"})
+			return
+		}
+		from = t
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to, use RFC 3339"})
+			return
+		}
+		to = t
+	}
+
+	series, err := h.telemetryService.Series(c.Request.Context(), id, c.Query("metric"), c.Query("resolution"), from, to)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, series)
+}
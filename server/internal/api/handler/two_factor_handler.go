@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/service"
+)
+
+type TwoFactorHandler struct {
+	twoFactorService *service.TwoFactorService
+}
+
+func NewTwoFactorHandler(twoFactorService *service.TwoFactorService) *TwoFactorHandler {
+	return &TwoFactorHandler{twoFactorService: twoFactorService}
+}
+
+// respondTwoFactorChallenge answers a login that still needs a second factor
+// with its challenge. Returns false if err is something else.
+func respondTwoFactorChallenge(c *gin.Context, err error) bool {
+	var twoFactor *service.TwoFactorRequiredError
+	if !errors.As(err, &twoFactor) {
+		return false
+	}
+	c.JSON(http.StatusOK, twoFactor.Challenge)
+	return true
+}
+
+// respondTwoFactorError maps code-check failures to 401/429 and the rest to 400.
+func respondTwoFactorError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrTwoFactorLocked):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidTwoFactorCode), errors.Is(err, service.ErrInvalidChallenge):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
+
+func callerRole(c *gin.Context) string {
+	role, _ := c.Get("user_role")
+	roleStr, _ := role.(string)
+	return roleStr
+}
+
+// ─── Login (public) ───────────────────────────────────────────────────────────
+
+// Verify handles POST /api/auth/2fa/verify: the second step of a login.
+func (h *TwoFactorHandler) Verify(c *gin.Context) {
+	var req domain.TwoFactorVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	resp, err := h.twoFactorService.CompleteLogin(c.Request.Context(), req.ChallengeToken, req.Code, clientInfo(c))
+	if err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Setup handles POST /api/auth/2fa/setup: starts the enrollment a login with
+// setup_required asks for. The first code is then sent to Verify.
+func (h *TwoFactorHandler) Setup(c *gin.Context) {
+	var req domain.TwoFactorSetupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	enrollment, err := h.twoFactorService.SetupFromChallenge(c.Request.Context(), req.ChallengeToken)
+	if err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ─── Own account ──────────────────────────────────────────────────────────────
+
+// Status returns whether the caller has 2FA enabled or required.
+func (h *TwoFactorHandler) Status(c *gin.Context) {
+	subjectType, subjectID := sessionSubject(c)
+	status, err := h.twoFactorService.Status(c.Request.Context(), subjectType, subjectID, callerRole(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// Enroll starts enrollment and returns the secret to add to an authenticator app.
+func (h *TwoFactorHandler) Enroll(c *gin.Context) {
+	subjectType, subjectID := sessionSubject(c)
+	enrollment, err := h.twoFactorService.Enroll(c.Request.Context(), subjectType, subjectID)
+	if err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// Confirm enables 2FA with a first code and returns the recovery codes.
+func (h *TwoFactorHandler) Confirm(c *gin.Context) {
+	var req domain.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	subjectType, subjectID := sessionSubject(c)
+	codes, err := h.twoFactorService.Confirm(c.Request.Context(), subjectType, subjectID, req.Code)
+	if err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// Disable turns 2FA off; needs a current TOTP or recovery code.
+func (h *TwoFactorHandler) Disable(c *gin.Context) {
+	var req domain.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	subjectType, subjectID := sessionSubject(c)
+	if err := h.twoFactorService.Disable(c.Request.Context(), subjectType, subjectID, callerRole(c), req.Code); err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// RegenerateRecoveryCodes replaces the caller's recovery codes.
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	var req domain.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	subjectType, subjectID := sessionSubject(c)
+	codes, err := h.twoFactorService.RegenerateRecoveryCodes(c.Request.Context(), subjectType, subjectID, req.Code)
+	if err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// ─── Admin ────────────────────────────────────────────────────────────────────
+
+// ResetCustomer removes a customer's 2FA, e.g. after they lost their phone and
+// recovery codes.
+func (h *TwoFactorHandler) ResetCustomer(c *gin.Context) {
+	h.reset(c, domain.SessionSubjectCustomer)
+}
+
+// ResetUser removes a staff user's 2FA.
+func (h *TwoFactorHandler) ResetUser(c *gin.Context) {
+	h.reset(c, domain.SessionSubjectUser)
+}
+
+func (h *TwoFactorHandler) reset(c *gin.Context, subjectType string) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if err := h.twoFactorService.Reset(c.Request.Context(), subjectType, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+func (h *TwoFactorHandler) GetSettings(c *gin.Context) {
+	settings, err := h.twoFactorService.GetSettings(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateSettings changes the security settings. Admins only: operators must
+// not be able to lift their own 2FA requirement.
+func (h *TwoFactorHandler) UpdateSettings(c *gin.Context) {
+	if callerRole(c) != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admins only"})
+		return
+	}
+	var req domain.SecuritySettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.twoFactorService.UpdateSettings(c.Request.Context(), &req); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
@@ -31,6 +31,7 @@ type vpnConnectRequest struct {
 }
 
 type connectionPortInfo struct {
+	ID        string `json:"id"`
 	Port      int    `json:"port"`
 	ProxyType string `json:"proxy_type"`
 	Username  string `json:"username"`
@@ -85,6 +86,7 @@ func (h *VPNHandler) Connected(c *gin.Context) {
 			for _, conn := range conns {
 				if conn.BasePort != nil {
 					connections = append(connections, connectionPortInfo{
+						ID:        conn.ID.String(),
 						Port:      *conn.BasePort,
 						ProxyType: conn.ProxyType,
 						Username:  conn.Username,
@@ -143,6 +145,7 @@ func (h *VPNHandler) Disconnected(c *gin.Context) {
 			for _, conn := range conns {
 				if conn.BasePort != nil {
 					connections = append(connections, connectionPortInfo{
+						ID:        conn.ID.String(),
 						Port:      *conn.BasePort,
 						ProxyType: conn.ProxyType,
 						Username:  conn.Username,
@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/service"
+)
+
+// WebhookHandler manages webhook endpoints and their delivery log. Staff
+// users and resellers own endpoints personally; customer endpoints belong to
+// the organization.
+type WebhookHandler struct {
+	webhookService *service.WebhookService
+}
+
+func NewWebhookHandler(webhookService *service.WebhookService) *WebhookHandler {
+	return &WebhookHandler{webhookService: webhookService}
+}
+
+// webhookOwner returns whose endpoints the caller manages.
+func webhookOwner(c *gin.Context) domain.WebhookOwner {
+	id := callerID(c)
+	if callerRole(c) == "customer" {
+		return domain.WebhookOwner{CustomerID: &id}
+	}
+	return domain.WebhookOwner{UserID: &id}
+}
+
+// List handles GET /api/webhooks.
+func (h *WebhookHandler) List(c *gin.Context) {
+	endpoints, err := h.webhookService.ListEndpoints(c.Request.Context(), webhookOwner(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"endpoints": endpoints, "events": domain.WebhookEvents})
+}
+
+// Create handles POST /api/webhooks. The response carries the signing
+// secret, which is not shown again.
+func (h *WebhookHandler) Create(c *gin.Context) {
+	var req domain.CreateWebhookEndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	resp, err := h.webhookService.CreateEndpoint(c.Request.Context(), webhookOwner(c), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, resp)
+}
+
+// Update handles PUT /api/webhooks/:id.
+func (h *WebhookHandler) Update(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook id"})
+		return
+	}
+	var req domain.UpdateWebhookEndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	endpoint, err := h.webhookService.UpdateEndpoint(c.Request.Context(), webhookOwner(c), id, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, endpoint)
+}
+
+// Delete handles DELETE /api/webhooks/:id.
+func (h *WebhookHandler) Delete(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook id"})
+		return
+	}
+	if err := h.webhookService.DeleteEndpoint(c.Request.Context(), webhookOwner(c), id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// RotateSecret handles POST /api/webhooks/:id/rotate-secret.
+func (h *WebhookHandler) RotateSecret(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook id"})
+		return
+	}
+	resp, err := h.webhookService.RotateSecret(c.Request.Context(), webhookOwner(c), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Test handles POST /api/webhooks/:id/test: queues a "test" event for the
+// endpoint. The outcome shows up in the delivery log.
+func (h *WebhookHandler) Test(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook id"})
+		return
+	}
+	delivery, err := h.webhookService.Test(c.Request.Context(), webhookOwner(c), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, delivery)
+}
+
+// ListDeliveries handles GET /api/webhook-deliveries.
+// Query params: endpoint_id, status (pending, succeeded, failed), limit.
+func (h *WebhookHandler) ListDeliveries(c *gin.Context) {
+	var endpointID *uuid.UUID
+	if v := c.Query("endpoint_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid endpoint_id"})
+			return
+		}
+		endpointID = &id
+	}
+	status := c.Query("status")
+	switch status {
+	case "", domain.WebhookDeliveryPending, domain.WebhookDeliverySucceeded, domain.WebhookDeliveryFailed:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	deliveries, err := h.webhookService.ListDeliveries(c.Request.Context(), webhookOwner(c), endpointID, status, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
+
+// Redeliver handles POST /api/webhook-deliveries/:id/redeliver: queues a
+// copy of the delivery's payload, linked to the original.
+func (h *WebhookHandler) Redeliver(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid delivery id"})
+		return
+	}
+	delivery, err := h.webhookService.Redeliver(c.Request.Context(), webhookOwner(c), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, delivery)
+}
@@ -0,0 +1,219 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/service"
+)
+
+// maxAuditBody caps how much of a request or response body is kept for the log.
+const maxAuditBody = 64 << 10
+
+// auditTargetTypes maps the first path segment after /api/ to a target type.
+var auditTargetTypes = map[string]string{
+	"devices":             "device",
+	"connections":         "connection",
+	"device-shares":       "device_share",
+	"pairing-codes":       "pairing_code",
+	"customers":           "customer",
+	"users":               "user",
+	"plans":               "plan",
+	"rotation-links":      "rotation_link",
+	"relay-servers":       "relay_server",
+	"api-keys":            "api_key",
+	"sessions":            "session",
+	"2fa":                 "two_factor",
+	"organization":        "organization",
+	"share-invites":       "share_invite",
+	"webhooks":            "webhook",
+	"webhook-deliveries":  "webhook_delivery",
+	"alert-rules":         "alert_rule",
+	"alert-incidents":     "alert_incident",
+	"maintenance-windows": "maintenance_window",
+}
+
+type auditRoute struct {
+	action     string
+	targetType string
+	self       bool   // the target is the caller (own settings, own 2FA)
+	account    bool   // the target is the caller's organization, unless the route has an :id
+	service    string // actor label for unauthenticated internal routes
+	skip       bool
+}
+
+// auditRouteOverrides are routes whose action or target can't be derived from the path.
+var auditRouteOverrides = map[string]auditRoute{
+	"/api/settings/webhook":          {action: "user.webhook.update", targetType: "user", self: true},
+	"/api/settings/webhook/test":     {skip: true},
+	"/api/internal/sync/device":      {action: "device.sync", targetType: "device", service: "peer-sync"},
+	"/api/internal/sync/connections": {action: "connection.sync", targetType: "connection", service: "peer-sync"},
+	"/api/devices/:id/commands":      {action: "device.command", targetType: "device"},
+	"/api/organization/members":      {action: "organization.members.create", targetType: "organization", account: true},
+	"/api/reseller/branding":         {action: "user.branding.update", targetType: "user", self: true},
+	"/api/share-invites/accept":      {action: "share_invite.accept", targetType: "device_share"},
+}
+
+// auditRouteFor derives the action and target type from a route, e.g.
+// DELETE /api/connections/:id -> connection.delete and
+// POST /api/customers/:id/suspend -> customer.suspend.
+func auditRouteFor(method, route string) auditRoute {
+	if r, ok := auditRouteOverrides[route]; ok {
+		return r
+	}
+	segs := strings.Split(strings.TrimPrefix(route, "/api/"), "/")
+	targetType, ok := auditTargetTypes[segs[0]]
+	if !ok {
+		targetType = strings.ReplaceAll(segs[0], "-", "_")
+	}
+	parts := []string{targetType}
+	for _, seg := range segs[1:] {
+		if !strings.HasPrefix(seg, ":") {
+			parts = append(parts, strings.ReplaceAll(seg, "-", "_"))
+		}
+	}
+	r := auditRoute{targetType: targetType, self: targetType == "two_factor", account: targetType == "organization"}
+	switch {
+	case method == http.MethodPost && len(parts) > 1:
+		r.action = strings.Join(parts, ".")
+	case method == http.MethodPost:
+		r.action = targetType + ".create"
+	case method == http.MethodDelete:
+		r.action = strings.Join(parts, ".") + ".delete"
+	default:
+		r.action = strings.Join(parts, ".") + ".update"
+	}
+	return r
+}
+
+// auditWriter keeps a copy of the response body so created entities can be logged.
+type auditWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if w.body.Len() < maxAuditBody {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Audit records every successful state-changing request: actor, action,
+// target, the target's field-level diff and the source IP. Requests without
+// an authenticated caller are logged as internal.
+func Audit(auditService *service.AuditService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		method := c.Request.Method
+		if method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions {
+			c.Next()
+			return
+		}
+		route := auditRouteFor(method, c.FullPath())
+		if route.skip {
+			c.Next()
+			return
+		}
+
+		var reqBody []byte
+		if c.Request.Body != nil {
+			reqBody, _ = io.ReadAll(io.LimitReader(c.Request.Body, maxAuditBody))
+			c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(reqBody), c.Request.Body))
+		}
+
+		entry := &domain.AuditEntry{
+			ActorType:  domain.AuditActorInternal,
+			ActorLabel: route.service,
+			Action:     route.action,
+			TargetType: route.targetType,
+			Method:     method,
+			Path:       c.Request.URL.Path,
+			SourceIP:   c.ClientIP(),
+		}
+		setAuditActor(c, entry)
+
+		if route.self {
+			entry.TargetID = entry.ActorID
+		} else if id, err := uuid.Parse(c.Param("id")); err == nil {
+			entry.TargetID = &id
+		} else if route.account {
+			entry.TargetID = entry.CustomerID
+		}
+		ctx := c.Request.Context()
+		var before map[string]interface{}
+		if entry.TargetID != nil {
+			before = auditService.Snapshot(ctx, route.targetType, *entry.TargetID)
+		}
+
+		w := &auditWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+
+		entry.Status = w.Status()
+		if entry.Status >= http.StatusBadRequest {
+			return
+		}
+
+		var after map[string]interface{}
+		if entry.TargetID != nil {
+			after = auditService.Snapshot(ctx, route.targetType, *entry.TargetID)
+		} else if created := service.AuditFields(w.body.Bytes()); created != nil {
+			// Create: the response is the new entity
+			if id, err := uuid.Parse(stringField(created, "id")); err == nil {
+				entry.TargetID = &id
+				after = created
+			}
+		}
+		if before != nil || after != nil {
+			entry.Changes = service.AuditDiff(before, after)
+		}
+		target := after
+		if target == nil {
+			target = before
+		}
+		entry.Request = service.AuditRequest(reqBody, target)
+		auditService.Record(ctx, entry)
+	}
+}
+
+// setAuditActor fills the actor from what AuthMiddleware put in the context.
+func setAuditActor(c *gin.Context, entry *domain.AuditEntry) {
+	userIDVal, ok := c.Get("user_id")
+	if !ok {
+		return
+	}
+	userID, _ := userIDVal.(uuid.UUID)
+	role, _ := c.Get("user_role")
+	email, _ := c.Get("user_email")
+	entry.ActorLabel, _ = email.(string)
+
+	if keyIDVal, ok := c.Get("api_key_id"); ok {
+		keyID, _ := keyIDVal.(uuid.UUID)
+		entry.ActorType = domain.AuditActorAPIKey
+		entry.ActorID = &keyID
+		entry.CustomerID = &userID
+		return
+	}
+	entry.ActorID = &userID
+	if roleStr, _ := role.(string); roleStr == "customer" {
+		// user_id is the organization; the actor is the member login
+		entry.ActorType = domain.AuditActorCustomer
+		entry.CustomerID = &userID
+		if memberIDVal, ok := c.Get("member_id"); ok {
+			memberID, _ := memberIDVal.(uuid.UUID)
+			entry.ActorID = &memberID
+		}
+	} else {
+		entry.ActorType = domain.AuditActorUser
+	}
+}
+
+func stringField(m map[string]interface{}, key string) string {
+	s, _ := m[key].(string)
+	return s
+}
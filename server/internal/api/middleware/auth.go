@@ -3,14 +3,21 @@ package middleware
 import (
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
 	"github.com/mobileproxy/server/internal/repository"
 	"github.com/mobileproxy/server/internal/service"
 )
 
-func AuthMiddleware(authService *service.AuthService) gin.HandlerFunc {
+// AuthMiddleware accepts a JWT or, when apiKeyService is set, a customer API
+// key in the Bearer header. API keys authenticate as their customer and are
+// limited to the routes their scopes cover (see apiKeyScope). JWTs of a
+// revoked session are rejected, and so are JWTs without a session once
+// legacyTokenGrace has passed.
+func AuthMiddleware(authService *service.AuthService, sessionService *service.SessionService, apiKeyService *service.APIKeyService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -26,26 +33,122 @@ func AuthMiddleware(authService *service.AuthService) gin.HandlerFunc {
 			return
 		}
 
+		if apiKeyService != nil && strings.HasPrefix(parts[1], service.APIKeyPrefix) {
+			key, err := apiKeyService.Authenticate(c.Request.Context(), parts[1], c.ClientIP())
+			if err != nil || key == nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid api key"})
+				c.Abort()
+				return
+			}
+			scope := apiKeyScope(c.Request.Method, c.FullPath())
+			if scope == "" || !hasScope(key.Scopes, scope) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "api key lacks the required scope", "scope": scope})
+				c.Abort()
+				return
+			}
+			c.Set("user_id", key.CustomerID)
+			c.Set("user_role", "customer")
+			c.Set("api_key_id", key.ID)
+			c.Next()
+			return
+		}
+
 		claims, err := authService.ValidateToken(parts[1])
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			c.Abort()
 			return
 		}
+		if claims.SessionID == uuid.Nil {
+			if !legacyTokenUsable(claims, time.Now()) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "session required, sign in again"})
+				c.Abort()
+				return
+			}
+		} else if !sessionService.IsActive(c.Request.Context(), claims.SessionID) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "session revoked"})
+			c.Abort()
+			return
+		}
 
 		c.Set("user_id", claims.UserID)
 		c.Set("user_email", claims.Email)
 		c.Set("user_role", claims.Role)
+		c.Set("session_id", claims.SessionID)
 		c.Next()
 	}
 }
 
-// AdminOnlyMiddleware blocks access for customer-role tokens. Only "admin" and "operator" roles proceed.
+// legacyTokenGrace bounds how long access tokens issued before server-side
+// sessions existed (no sid claim, so they cannot be revoked) are still
+// accepted: the old default token lifetime, so none outlive the first day
+// after the upgrade whatever expiry they were signed with.
+const legacyTokenGrace = 24 * time.Hour
+
+// legacyTokenUsable reports whether a token without a session ID is within
+// legacyTokenGrace of being issued.
+func legacyTokenUsable(claims *service.JWTClaims, now time.Time) bool {
+	return claims.IssuedAt != nil && now.Sub(claims.IssuedAt.Time) < legacyTokenGrace
+}
+
+// apiKeyReadRoutes are the routes a key with ScopeRead may GET: device and
+// connection inventory, usage and status. Credentials (.ovpn downloads), key
+// and session management, billing and everything else are not listed.
+var apiKeyReadRoutes = map[string]bool{
+	"/api/plan":                             true,
+	"/api/sla":                              true,
+	"/api/devices":                          true,
+	"/api/devices/:id":                      true,
+	"/api/devices/:id/ip-history":           true,
+	"/api/devices/:id/bandwidth":            true,
+	"/api/devices/:id/bandwidth/hourly":     true,
+	"/api/devices/:id/uptime":               true,
+	"/api/devices/:id/telemetry":            true,
+	"/api/devices/:id/commands":             true,
+	"/api/connections":                      true,
+	"/api/connections/:id":                  true,
+	"/api/connections/:id/acl":              true,
+	"/api/connections/:id/acl/denials":      true,
+	"/api/connections/:id/sessions":         true,
+	"/api/connections/:id/bandwidth":        true,
+	"/api/connections/:id/bandwidth/hourly": true,
+	"/api/connections/:id/bandwidth/daily":  true,
+	"/api/connections/:id/quota":            true,
+}
+
+// apiKeyScope returns the scope an API key needs for a route, or "" if API
+// keys may not use it at all (key management, billing, sharing, ...).
+func apiKeyScope(method, route string) string {
+	switch {
+	case method == http.MethodGet || method == http.MethodHead:
+		if apiKeyReadRoutes[route] {
+			return domain.ScopeRead
+		}
+		return ""
+	case method == http.MethodPost && route == "/api/devices/:id/commands":
+		return domain.ScopeRotate
+	case route == "/api/connections" || strings.HasPrefix(route, "/api/connections/"):
+		return domain.ScopeManageConnections
+	}
+	return ""
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminOnlyMiddleware blocks access for customer-role tokens. Only "admin", "operator" and
+// "reseller" roles proceed; resellers are further confined by ResellerScope.
 func AdminOnlyMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		role, _ := c.Get("user_role")
 		roleStr, _ := role.(string)
-		if roleStr != "admin" && roleStr != "operator" {
+		if roleStr != "admin" && roleStr != "operator" && roleStr != "reseller" {
 			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
 			c.Abort()
 			return
@@ -84,6 +187,77 @@ func CustomerSuspensionCheck(customerRepo *repository.CustomerRepository) gin.Ha
 	}
 }
 
+// StaffAccessCheck loads admin/operator users on every request so that
+// disabling an account or changing its role takes effect immediately. It sets
+// "user_permissions" for RequirePermission. A no-op for customer tokens.
+func StaffAccessCheck(userRepo *repository.UserRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, _ := c.Get("user_role")
+		roleStr, _ := role.(string)
+		if roleStr == "customer" {
+			c.Next()
+			return
+		}
+
+		userIDVal, _ := c.Get("user_id")
+		userID, ok := userIDVal.(uuid.UUID)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user id"})
+			c.Abort()
+			return
+		}
+
+		user, err := userRepo.GetByID(c.Request.Context(), userID)
+		if err != nil || !user.Active {
+			c.JSON(http.StatusForbidden, gin.H{"error": "account disabled"})
+			c.Abort()
+			return
+		}
+
+		c.Set("user_role", user.Role)
+		c.Set("user_permissions", user.Permissions)
+		c.Next()
+	}
+}
+
+// RequirePermission blocks operators lacking perm. Admins hold every
+// permission; customers pass through, since mixed routes scope customer
+// access in the handler, and so do resellers, who are confined by
+// ResellerScope instead.
+func RequirePermission(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, _ := c.Get("user_role")
+		roleStr, _ := role.(string)
+		if roleStr == "customer" || roleStr == "admin" || roleStr == "reseller" {
+			c.Next()
+			return
+		}
+
+		permsVal, _ := c.Get("user_permissions")
+		perms, _ := permsVal.([]string)
+		if !hasScope(perms, perm) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "missing permission", "permission": perm})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireAdmin restricts a route to the admin role.
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, _ := c.Get("user_role")
+		roleStr, _ := role.(string)
+		if roleStr != "admin" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "admins only"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
 func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
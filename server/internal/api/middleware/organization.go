@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/service"
+)
+
+// orgSelfServiceRoutes concern only the calling login, so viewers may change them.
+var orgSelfServiceRoutes = []string{"/api/sessions", "/api/2fa"}
+
+// OrganizationContext makes customer logins act for their organization:
+// "user_id" becomes the organization id, so everything owned by the
+// organization is reachable through the existing ownership checks, while
+// "member_id" keeps the login and "org_role" its role. Viewers are limited to
+// reads. API keys already belong to the organization and staff tokens pass
+// through unchanged.
+func OrganizationContext(orgService *service.OrganizationService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, _ := c.Get("user_role")
+		if roleStr, _ := role.(string); roleStr != "customer" {
+			c.Next()
+			return
+		}
+		if _, isKey := c.Get("api_key_id"); isKey {
+			c.Next()
+			return
+		}
+
+		userIDVal, _ := c.Get("user_id")
+		loginID, _ := userIDVal.(uuid.UUID)
+		orgID, orgRole, err := orgService.Resolve(c.Request.Context(), loginID)
+		if errors.Is(err, service.ErrOrganizationSuspended) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "account suspended"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load organization"})
+			c.Abort()
+			return
+		}
+
+		if orgRole == domain.OrgRoleViewer && !isReadOnly(c.Request.Method) && !isSelfService(c.FullPath()) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "viewers have read-only access"})
+			c.Abort()
+			return
+		}
+
+		c.Set("user_id", orgID)
+		c.Set("member_id", loginID)
+		c.Set("org_role", orgRole)
+		c.Next()
+	}
+}
+
+// RequireOrgRole restricts a route to organization members holding one of
+// roles. Staff tokens and API keys (which carry no org role) pass through.
+func RequireOrgRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		roleVal, ok := c.Get("org_role")
+		if !ok {
+			c.Next()
+			return
+		}
+		orgRole, _ := roleVal.(string)
+		if !hasScope(roles, orgRole) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "insufficient organization role", "required": roles})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func isReadOnly(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func isSelfService(route string) bool {
+	for _, prefix := range orgSelfServiceRoutes {
+		if strings.HasPrefix(route, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RelaySecretHeader carries the secret shared by the API and its tunnel
+// servers (and peer API) on /api/internal relay calls.
+const RelaySecretHeader = "X-Relay-Secret"
+
+// RelayAuth guards the internal routes the tunnel server calls. They carry no
+// user credentials and /api/ is public, so without a configured secret they
+// are refused outright.
+func RelayAuth(secret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		got := c.GetHeader(RelaySecretHeader)
+		if secret == "" || subtle.ConstantTimeCompare([]byte(got), []byte(secret)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid relay secret"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/service"
+)
+
+// resellerRoutes are the dashboard routes open to resellers. Everything else
+// (plans, relays, global ACL, staff users, stats, ...) stays with our staff.
+var resellerRoutes = map[string]bool{
+	"GET /api/devices":                      true,
+	"GET /api/devices/:id":                  true,
+	"PATCH /api/devices/:id":                true,
+	"POST /api/devices/:id/commands":        true,
+	"GET /api/devices/:id/commands":         true,
+	"GET /api/devices/:id/ip-history":       true,
+	"GET /api/devices/:id/bandwidth":        true,
+	"GET /api/devices/:id/bandwidth/hourly": true,
+	"GET /api/devices/:id/uptime":           true,
+	"GET /api/devices/:id/telemetry":        true,
+
+	"GET /api/connections":                          true,
+	"POST /api/connections":                         true,
+	"GET /api/connections/:id":                      true,
+	"PATCH /api/connections/:id":                    true,
+	"DELETE /api/connections/:id":                   true,
+	"POST /api/connections/:id/regenerate-password": true,
+	"POST /api/connections/:id/reset-bandwidth":     true,
+	"GET /api/connections/:id/acl":                  true,
+	"PUT /api/connections/:id/acl":                  true,
+	"GET /api/connections/:id/acl/denials":          true,
+	"PUT /api/connections/:id/limits":               true,
+	"GET /api/connections/:id/quota":                true,
+	"PUT /api/connections/:id/quota":                true,
+	"DELETE /api/connections/:id/quota":             true,
+	"GET /api/connections/:id/sessions":             true,
+	"GET /api/connections/:id/bandwidth":            true,
+	"GET /api/connections/:id/bandwidth/hourly":     true,
+	"GET /api/connections/:id/bandwidth/daily":      true,
+	"GET /api/connections/:id/ovpn":                 true,
+
+	"GET /api/customers":                  true,
+	"POST /api/customers":                 true,
+	"GET /api/customers/:id":              true,
+	"PUT /api/customers/:id":              true,
+	"POST /api/customers/:id/suspend":     true,
+	"POST /api/customers/:id/activate":    true,
+	"DELETE /api/customers/:id/2fa":       true,
+	"GET /api/customers/:id/organization": true,
+	"GET /api/customers/:id/audit":        true,
+
+	"GET /api/pairing-codes":         true,
+	"POST /api/pairing-codes":        true,
+	"DELETE /api/pairing-codes/:id":  true,
+	"GET /api/rotation-links":        true,
+	"POST /api/rotation-links":       true,
+	"DELETE /api/rotation-links/:id": true,
+	"GET /api/relay-servers/active":  true,
+
+	"GET /api/settings/webhook":  true,
+	"GET /api/reseller/branding": true,
+	"PUT /api/reseller/branding": true,
+
+	"GET /api/webhooks":                          true,
+	"POST /api/webhooks":                         true,
+	"PUT /api/webhooks/:id":                      true,
+	"DELETE /api/webhooks/:id":                   true,
+	"POST /api/webhooks/:id/rotate-secret":       true,
+	"POST /api/webhooks/:id/test":                true,
+	"GET /api/webhook-deliveries":                true,
+	"POST /api/webhook-deliveries/:id/redeliver": true,
+	"GET /api/alert-rules":                       true,
+	"POST /api/alert-rules":                      true,
+	"PUT /api/alert-rules/:id":                   true,
+	"DELETE /api/alert-rules/:id":                true,
+	"GET /api/alert-incidents":                   true,
+	"POST /api/alert-incidents/:id/resolve":      true,
+
+	"GET /api/sla": true,
+}
+
+// ResellerScope confines reseller accounts to resellerRoutes and, on routes
+// with an :id, to entities allocated to them (see ResellerService.InScope).
+// Listing and create handlers scope the rest. Other callers pass through.
+func ResellerScope(resellerService *service.ResellerService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, _ := c.Get("user_role")
+		if roleStr, _ := role.(string); roleStr != "reseller" {
+			c.Next()
+			return
+		}
+
+		route := c.FullPath()
+		if !resellerRoutes[c.Request.Method+" "+route] && !isSelfService(route) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not available to resellers"})
+			c.Abort()
+			return
+		}
+
+		if idParam := c.Param("id"); idParam != "" && !isSelfService(route) {
+			id, err := uuid.Parse(idParam)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+				c.Abort()
+				return
+			}
+			userIDVal, _ := c.Get("user_id")
+			resellerID, _ := userIDVal.(uuid.UUID)
+			seg := strings.Split(strings.TrimPrefix(route, "/api/"), "/")[0]
+			if !resellerService.InScope(c.Request.Context(), resellerID, auditTargetTypes[seg], id) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
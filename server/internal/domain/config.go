@@ -11,11 +11,13 @@ type Config struct {
 	Google    GoogleOAuthConfig `json:"google"`
 	Resend    ResendConfig      `json:"resend"`
 	Turnstile TurnstileConfig   `json:"turnstile"`
+	Billing   BillingConfig     `json:"billing"`
 }
 
 type ServerConfig struct {
-	Host string `json:"host"`
-	Port int    `json:"port"`
+	Host        string `json:"host"`
+	Port        int    `json:"port"`
+	RelaySecret string `json:"relay_secret"` // shared with tunnel servers for /api/internal relay routes
 }
 
 type DatabaseConfig struct {
@@ -33,8 +35,9 @@ func (c DatabaseConfig) DSN() string {
 }
 
 type JWTConfig struct {
-	Secret      string `json:"secret"`
-	ExpireHours int    `json:"expire_hours"`
+	Secret        string `json:"secret"`
+	AccessMinutes int    `json:"access_minutes"` // access token (JWT) lifetime
+	RefreshDays   int    `json:"refresh_days"`   // idle lifetime of a login session
 }
 
 type VPNConfig struct {
@@ -71,6 +74,14 @@ type TurnstileConfig struct {
 	SecretKey string `json:"secret_key"`
 }
 
+type BillingConfig struct {
+	Provider      string `json:"provider"`       // "stripe", "fake", or empty to disable billing
+	SecretKey     string `json:"secret_key"`     // provider API key
+	WebhookSecret string `json:"webhook_secret"` // webhook signing secret
+	SuccessURL    string `json:"success_url"`    // where checkout returns after payment
+	CancelURL     string `json:"cancel_url"`
+}
+
 func DefaultConfig() Config {
 	return Config{
 		Server: ServerConfig{Host: "0.0.0.0", Port: 8080},
@@ -79,7 +90,7 @@ func DefaultConfig() Config {
 			User: "mobileproxy", Password: "mobileproxy",
 			DBName: "mobileproxy", SSLMode: "disable",
 		},
-		JWT: JWTConfig{Secret: "change-me-in-production", ExpireHours: 24},
+		JWT: JWTConfig{Secret: "change-me-in-production", AccessMinutes: 15, RefreshDays: 30},
 		VPN: VPNConfig{
 			ServerIP: "0.0.0.0", Subnet: "10.8.0.0", SubnetMask: "255.255.255.0",
 			CCDDir: "/etc/openvpn/ccd", ConfigDir: "/etc/openvpn",
@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -36,14 +37,74 @@ const (
 )
 
 type User struct {
-	ID           uuid.UUID `json:"id" db:"id"`
-	Email        string    `json:"email" db:"email"`
-	PasswordHash string    `json:"-" db:"password_hash"`
-	Name         string    `json:"name" db:"name"`
-	Role         string    `json:"role" db:"role"` // admin, operator
-	WebhookURL   *string   `json:"webhook_url,omitempty" db:"webhook_url"`
-	CreatedAt    time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+	ID           uuid.UUID  `json:"id" db:"id"`
+	Email        string     `json:"email" db:"email"`
+	PasswordHash string     `json:"-" db:"password_hash"`
+	Name         string     `json:"name" db:"name"`
+	Role         string     `json:"role" db:"role"` // admin, operator, reseller
+	Active       bool       `json:"active" db:"active"`
+	Permissions  []string   `json:"permissions" db:"permissions"` // operators only; admins hold all
+	WebhookURL   *string    `json:"webhook_url,omitempty" db:"webhook_url"`
+	LastLoginAt  *time.Time `json:"last_login_at,omitempty" db:"last_login_at"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// Staff permissions checked per route. Reads are open to all staff; these
+// gate changes.
+const (
+	PermDevicesCommand   = "devices:command"   // send commands, rotation links
+	PermDevicesManage    = "devices:manage"    // edit devices, pairing codes
+	PermConnectionsWrite = "connections:write" // create, change and delete connections
+	PermCustomersManage  = "customers:manage"  // customers, their plans and shares
+	PermPlansManage      = "plans:manage"
+	PermRelaysManage     = "relays:manage"
+	PermACLManage        = "acl:manage" // global destination ACL
+	PermSettingsWebhook  = "settings:webhook"
+)
+
+var AllPermissions = []string{
+	PermDevicesCommand, PermDevicesManage, PermConnectionsWrite, PermCustomersManage,
+	PermPlansManage, PermRelaysManage, PermACLManage, PermSettingsWebhook,
+}
+
+// UserAuthToken is a single-use link to set a staff user's password, sent on
+// invite or by an admin-initiated reset.
+type UserAuthToken struct {
+	ID        uuid.UUID  `json:"id" db:"id"`
+	UserID    uuid.UUID  `json:"user_id" db:"user_id"`
+	TokenHash string     `json:"-" db:"token_hash"`
+	Type      string     `json:"type" db:"type"` // "invite" or "password_reset"
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty" db:"used_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+// CreateUserRequest adds a staff user. Without a password they are emailed an
+// invite link to choose one.
+type CreateUserRequest struct {
+	Email       string   `json:"email" binding:"required,email"`
+	Name        string   `json:"name" binding:"required"`
+	Role        string   `json:"role" binding:"required,oneof=admin operator reseller"`
+	Permissions []string `json:"permissions"`
+	Password    string   `json:"password" binding:"omitempty,min=8"`
+}
+
+type UpdateUserRequest struct {
+	Name        *string   `json:"name"`
+	Role        *string   `json:"role" binding:"omitempty,oneof=admin operator reseller"`
+	Permissions *[]string `json:"permissions"`
+}
+
+// ResetUserPasswordRequest sets a new password directly, or with an empty
+// password emails the user a reset link.
+type ResetUserPasswordRequest struct {
+	Password string `json:"password" binding:"omitempty,min=8"`
+}
+
+type SetStaffPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8"`
 }
 
 type RelayServer struct {
@@ -84,6 +145,7 @@ type Device struct {
 	RelayServerIP     string       `json:"relay_server_ip" db:"-"`
 	AutoRotateMinutes int          `json:"auto_rotate_minutes" db:"auto_rotate_minutes"`
 	CustomerID        *uuid.UUID   `json:"customer_id" db:"customer_id"`
+	ResellerID        *uuid.UUID   `json:"reseller_id,omitempty" db:"reseller_id"` // allocated to a reseller
 	CreatedAt         time.Time    `json:"created_at" db:"created_at"`
 	UpdatedAt         time.Time    `json:"updated_at" db:"updated_at"`
 }
@@ -91,8 +153,8 @@ type Device struct {
 type DeviceShare struct {
 	ID                 uuid.UUID `json:"id" db:"id"`
 	DeviceID           uuid.UUID `json:"device_id" db:"device_id"`
-	OwnerID            uuid.UUID `json:"owner_id" db:"owner_id"`
-	SharedWith         uuid.UUID `json:"shared_with" db:"shared_with"`
+	OwnerID            uuid.UUID `json:"owner_id" db:"owner_id"`       // organization
+	SharedWith         uuid.UUID `json:"shared_with" db:"shared_with"` // organization
 	CanRename          bool      `json:"can_rename" db:"can_rename"`
 	CanManagePorts     bool      `json:"can_manage_ports" db:"can_manage_ports"`
 	CanDownloadConfigs bool      `json:"can_download_configs" db:"can_download_configs"`
@@ -101,17 +163,59 @@ type DeviceShare struct {
 	UpdatedAt          time.Time `json:"updated_at" db:"updated_at"`
 }
 
+// DeviceShareInvite is a pending share addressed to an email address. Accepting
+// it creates the DeviceShare for the invitee's organization.
+type DeviceShareInvite struct {
+	ID                 uuid.UUID  `json:"id" db:"id"`
+	DeviceID           uuid.UUID  `json:"device_id" db:"device_id"`
+	OwnerID            uuid.UUID  `json:"owner_id" db:"owner_id"`     // organization
+	InvitedBy          *uuid.UUID `json:"invited_by" db:"invited_by"` // member login
+	Email              string     `json:"email" db:"email"`
+	TokenHash          string     `json:"-" db:"token_hash"`
+	CanRename          bool       `json:"can_rename" db:"can_rename"`
+	CanManagePorts     bool       `json:"can_manage_ports" db:"can_manage_ports"`
+	CanDownloadConfigs bool       `json:"can_download_configs" db:"can_download_configs"`
+	CanRotateIP        bool       `json:"can_rotate_ip" db:"can_rotate_ip"`
+	ExpiresAt          time.Time  `json:"expires_at" db:"expires_at"`
+	CreatedAt          time.Time  `json:"created_at" db:"created_at"`
+}
+
+type CreateDeviceShareInviteRequest struct {
+	DeviceID           uuid.UUID `json:"device_id" binding:"required"`
+	Email              string    `json:"email" binding:"required,email"`
+	CanRename          bool      `json:"can_rename"`
+	CanManagePorts     bool      `json:"can_manage_ports"`
+	CanDownloadConfigs bool      `json:"can_download_configs"`
+	CanRotateIP        bool      `json:"can_rotate_ip"`
+}
+
+type AcceptDeviceShareInviteRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// DeviceShareInvitePreview is what the invite link shows before acceptance.
+// SignupRequired tells the invitee to create an account with Email first.
+type DeviceShareInvitePreview struct {
+	Email            string    `json:"email"`
+	DeviceName       string    `json:"device_name"`
+	OrganizationName string    `json:"organization_name"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	SignupRequired   bool      `json:"signup_required"`
+}
+
 type Customer struct {
-	ID            uuid.UUID `json:"id" db:"id"`
-	Name          string    `json:"name" db:"name"`
-	Email         string    `json:"email" db:"email"`
-	Active        bool      `json:"active" db:"active"`
-	PasswordHash  *string   `json:"-" db:"password_hash"`
-	EmailVerified bool      `json:"email_verified" db:"email_verified"`
-	GoogleID      *string   `json:"-" db:"google_id"`
-	GoogleEmail   *string   `json:"google_email,omitempty" db:"google_email"`
-	CreatedAt     time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+	ID            uuid.UUID  `json:"id" db:"id"`
+	Name          string     `json:"name" db:"name"`
+	Email         string     `json:"email" db:"email"`
+	Active        bool       `json:"active" db:"active"`
+	PasswordHash  *string    `json:"-" db:"password_hash"`
+	EmailVerified bool       `json:"email_verified" db:"email_verified"`
+	GoogleID      *string    `json:"-" db:"google_id"`
+	GoogleEmail   *string    `json:"google_email,omitempty" db:"google_email"`
+	PlanID        *uuid.UUID `json:"plan_id" db:"plan_id"`
+	ResellerID    *uuid.UUID `json:"reseller_id,omitempty" db:"reseller_id"` // end customer of a reseller
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" db:"updated_at"`
 }
 
 type CustomerAuthToken struct {
@@ -140,6 +244,11 @@ type ProxyConnection struct {
 	BasePort       *int       `json:"base_port" db:"base_port"`
 	HTTPPort       *int       `json:"http_port" db:"http_port"`
 	SOCKS5Port     *int       `json:"socks5_port" db:"socks5_port"`
+	// Session limits enforced by the relay (0 = unlimited) and rejection counters
+	MaxConcurrentConns int   `json:"max_concurrent_conns" db:"max_concurrent_conns"`
+	MaxConnsPerSecond  int   `json:"max_conns_per_second" db:"max_conns_per_second"`
+	RejectedConcurrent int64 `json:"rejected_concurrent" db:"rejected_concurrent"`
+	RejectedRate       int64 `json:"rejected_rate" db:"rejected_rate"`
 	ExpiresAt      *time.Time `json:"expires_at" db:"expires_at"`
 	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
 	UpdatedAt      time.Time  `json:"updated_at" db:"updated_at"`
@@ -163,6 +272,23 @@ type BandwidthLog struct {
 	IntervalEnd   time.Time  `json:"interval_end" db:"interval_end"`
 }
 
+// BandwidthReport is a sequence-numbered batch of per-connection byte deltas
+// from a relay. (RelayID, Seq) is applied at most once.
+type BandwidthReport struct {
+	RelayID       string                    `json:"relay_id" binding:"required"`
+	Seq           int64                     `json:"seq" binding:"required"`
+	IntervalStart time.Time                 `json:"interval_start"`
+	IntervalEnd   time.Time                 `json:"interval_end"`
+	Connections   map[string]BandwidthDelta `json:"connections"` // connection ID -> delta
+}
+
+// BandwidthDelta is bytes moved since the previous report.
+// BytesUp is client -> destination, BytesDown is destination -> client.
+type BandwidthDelta struct {
+	BytesUp   int64 `json:"bytes_up"`
+	BytesDown int64 `json:"bytes_down"`
+}
+
 type DeviceCommand struct {
 	ID         uuid.UUID     `json:"id" db:"id"`
 	DeviceID   uuid.UUID     `json:"device_id" db:"device_id"`
@@ -194,6 +320,7 @@ type PairingCode struct {
 	RelayServerID     *uuid.UUID `json:"relay_server_id" db:"relay_server_id"`
 	ReassignDeviceID  *uuid.UUID `json:"reassign_device_id" db:"reassign_device_id"`
 	CustomerID        *uuid.UUID `json:"customer_id" db:"customer_id"`
+	ResellerID        *uuid.UUID `json:"reseller_id,omitempty" db:"reseller_id"`
 	CreatedAt         time.Time  `json:"created_at" db:"created_at"`
 }
 
@@ -211,12 +338,185 @@ type BandwidthHourly struct {
 	UploadBytes   int64 `json:"upload_bytes"`
 }
 
+type BandwidthDaily struct {
+	Date          string `json:"date"` // YYYY-MM-DD, local to the requested tz_offset
+	DownloadBytes int64  `json:"download_bytes"`
+	UploadBytes   int64  `json:"upload_bytes"`
+}
+
 type UptimeSegment struct {
 	Status    string    `json:"status"`
 	StartTime time.Time `json:"start_time"`
 	EndTime   time.Time `json:"end_time"`
 }
 
+// MaintenanceWindow is declared downtime excluded from uptime SLA reports.
+// DeviceID nil covers every device.
+type MaintenanceWindow struct {
+	ID        uuid.UUID  `json:"id" db:"id"`
+	DeviceID  *uuid.UUID `json:"device_id" db:"device_id"`
+	StartsAt  time.Time  `json:"starts_at" db:"starts_at"`
+	EndsAt    time.Time  `json:"ends_at" db:"ends_at"`
+	Reason    string     `json:"reason" db:"reason"`
+	CreatedBy *uuid.UUID `json:"created_by" db:"created_by"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+type MaintenanceWindowRequest struct {
+	DeviceID *uuid.UUID `json:"device_id"`
+	StartsAt time.Time  `json:"starts_at" binding:"required"`
+	EndsAt   time.Time  `json:"ends_at" binding:"required"`
+	Reason   string     `json:"reason" binding:"max=255"`
+}
+
+// SLAStats is the uptime of a device or group of devices over a period.
+// Uptime is up time over measured time (up plus down): maintenance windows
+// and stretches without status data are left out. Online and rotating count
+// as up, offline and error as down.
+type SLAStats struct {
+	UpSeconds            int64    `json:"up_seconds"`
+	DownSeconds          int64    `json:"down_seconds"`
+	MaintenanceSeconds   int64    `json:"maintenance_seconds"`
+	UnknownSeconds       int64    `json:"unknown_seconds"`
+	UptimePercent        *float64 `json:"uptime_percent"` // nil without measured time
+	Outages              int      `json:"outages"`
+	LongestOutageSeconds int64    `json:"longest_outage_seconds"`
+	Met                  *bool    `json:"met,omitempty"` // against the requested target
+}
+
+type DeviceSLA struct {
+	DeviceID   uuid.UUID  `json:"device_id"`
+	DeviceName string     `json:"device_name"`
+	CustomerID *uuid.UUID `json:"customer_id"`
+	SLAStats
+}
+
+type CustomerSLA struct {
+	CustomerID   uuid.UUID `json:"customer_id"`
+	CustomerName string    `json:"customer_name"`
+	Devices      int       `json:"devices"`
+	SLAStats
+}
+
+// SLAReport is the uptime report for a calendar month or ISO week (UTC).
+type SLAReport struct {
+	Period    string        `json:"period"` // YYYY-MM or YYYY-Www
+	From      time.Time     `json:"from"`
+	To        time.Time     `json:"to"` // capped at the time of the report
+	Target    *float64      `json:"target,omitempty"`
+	Fleet     SLAStats      `json:"fleet"`
+	Customers []CustomerSLA `json:"customers,omitempty"`
+	Devices   []DeviceSLA   `json:"devices"`
+}
+
+// ACLRule is a destination allow/deny rule enforced by the relay.
+// ConnectionID nil = global policy applied to every connection.
+type ACLRule struct {
+	ID           uuid.UUID  `json:"id" db:"id"`
+	ConnectionID *uuid.UUID `json:"connection_id" db:"connection_id"`
+	Action       string     `json:"action" db:"action"`       // "allow" or "deny"
+	RuleType     string     `json:"rule_type" db:"rule_type"` // "port", "cidr" or "domain"
+	Value        string     `json:"value" db:"value"`         // "25", "6881-6889", "10.0.0.0/8", "example.com"
+	Description  *string    `json:"description" db:"description"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+}
+
+// ACLDenial is a blocked connection attempt reported by a relay.
+type ACLDenial struct {
+	ID           uuid.UUID  `json:"id" db:"id"`
+	ConnectionID *uuid.UUID `json:"connection_id" db:"connection_id"`
+	Username     string     `json:"username" db:"username"`
+	ClientIP     string     `json:"client_ip" db:"client_ip"`
+	DestHost     string     `json:"dest_host" db:"dest_host"`
+	DestPort     int        `json:"dest_port" db:"dest_port"`
+	RuleID       *uuid.UUID `json:"rule_id" db:"rule_id"`
+	Scope        string     `json:"scope" db:"scope"` // "global" or "connection"
+	OccurredAt   time.Time  `json:"occurred_at" db:"occurred_at"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+}
+
+// ConnectionSession is one proxied TCP session reported by a relay.
+// BytesUp is client -> destination, BytesDown is destination -> client.
+type ConnectionSession struct {
+	ID           uuid.UUID  `json:"id" db:"id"`
+	ConnectionID *uuid.UUID `json:"connection_id" db:"connection_id"`
+	Username     string     `json:"username" db:"username"`
+	ClientIP     string     `json:"client_ip" db:"client_ip"`
+	ProxyType    string     `json:"proxy_type" db:"proxy_type"` // "http", "socks5" or "openvpn"
+	DestHost     string     `json:"dest_host" db:"dest_host"`
+	DestPort     int        `json:"dest_port" db:"dest_port"`
+	BytesUp      int64      `json:"bytes_up" db:"bytes_up"`
+	BytesDown    int64      `json:"bytes_down" db:"bytes_down"`
+	StartedAt    time.Time  `json:"started_at" db:"started_at"`
+	EndedAt      time.Time  `json:"ended_at" db:"ended_at"`
+	DurationMs   int64      `json:"duration_ms" db:"-"`
+	CloseReason  string     `json:"close_reason" db:"close_reason"`
+}
+
+// Telemetry metrics and resolutions
+const (
+	TelemetrySignal  = "signal"
+	TelemetryBattery = "battery"
+	TelemetryNetwork = "network"
+	TelemetryCarrier = "carrier"
+
+	TelemetryResolutionRaw  = "raw"
+	TelemetryResolutionHour = "hour"
+)
+
+// TelemetrySample is one heartbeat's telemetry as stored in device_telemetry.
+type TelemetrySample struct {
+	DeviceID        uuid.UUID `json:"device_id" db:"device_id"`
+	RecordedAt      time.Time `json:"recorded_at" db:"recorded_at"`
+	BatteryLevel    *int      `json:"battery_level" db:"battery_level"`
+	BatteryCharging bool      `json:"battery_charging" db:"battery_charging"`
+	SignalStrength  *int      `json:"signal_strength" db:"signal_strength"` // dBm
+	NetworkType     string    `json:"network_type" db:"network_type"`
+	Carrier         string    `json:"carrier" db:"carrier"`
+}
+
+// TelemetryHourly is an hour of downsampled telemetry for a device.
+type TelemetryHourly struct {
+	DeviceID    uuid.UUID `db:"device_id"`
+	Bucket      time.Time `db:"bucket"`
+	Samples     int       `db:"samples"`
+	BatteryMin  *int      `db:"battery_min"`
+	BatteryAvg  *float64  `db:"battery_avg"`
+	BatteryMax  *int      `db:"battery_max"`
+	SignalMin   *int      `db:"signal_min"`
+	SignalAvg   *float64  `db:"signal_avg"`
+	SignalMax   *int      `db:"signal_max"`
+	NetworkType string    `db:"network_type"`
+	Carrier     string    `db:"carrier"`
+}
+
+// TelemetryPoint is one point of a telemetry series. Value is a number for
+// signal and battery and a string for network and carrier; hourly points of
+// numeric metrics carry the hour's average with its min and max.
+type TelemetryPoint struct {
+	Time     time.Time   `json:"t"`
+	Value    interface{} `json:"value"`
+	Min      *int        `json:"min,omitempty"`
+	Max      *int        `json:"max,omitempty"`
+	Charging *bool       `json:"charging,omitempty"` // raw battery samples
+}
+
+// TelemetrySeries is the response of GET /api/devices/:id/telemetry.
+type TelemetrySeries struct {
+	DeviceID   uuid.UUID        `json:"device_id"`
+	Metric     string           `json:"metric"`
+	Resolution string           `json:"resolution"`
+	From       time.Time        `json:"from"`
+	To         time.Time        `json:"to"`
+	Points     []TelemetryPoint `json:"points"`
+}
+
+// ACLPolicy is the full rule snapshot pulled by relays.
+type ACLPolicy struct {
+	Global      []ACLRule            `json:"global"`
+	Connections map[string][]ACLRule `json:"connections"` // connection ID -> rules
+}
+
 // API request/response types
 
 type DeviceRegistrationRequest struct {
@@ -262,8 +562,10 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	Token string `json:"token"`
-	User  User   `json:"user"`
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"` // when Token expires
+	User         User      `json:"user"`
 }
 
 type CreateConnectionRequest struct {
@@ -274,6 +576,249 @@ type CreateConnectionRequest struct {
 	ProxyType      string     `json:"proxy_type"` // "http" or "socks5", defaults to "http"
 	IPWhitelist    []string   `json:"ip_whitelist"`
 	BandwidthLimit int64      `json:"bandwidth_limit"`
+	MaxConcurrentConns int    `json:"max_concurrent_conns"`
+	MaxConnsPerSecond  int    `json:"max_conns_per_second"`
+}
+
+type ConnectionLimitsRequest struct {
+	MaxConcurrentConns int `json:"max_concurrent_conns" binding:"min=0"`
+	MaxConnsPerSecond  int `json:"max_conns_per_second" binding:"min=0"`
+}
+
+// ConnectionLimits is the relay-facing view of a connection's session limits
+// and bandwidth quota state.
+type ConnectionLimits struct {
+	MaxConcurrentConns int  `json:"max_concurrent_conns"`
+	MaxConnsPerSecond  int  `json:"max_conns_per_second"`
+	OverQuota          bool `json:"over_quota"`
+	ThrottleKbps       int  `json:"throttle_kbps"` // 0 = cut when over quota
+}
+
+// ConnectionRejections are rejection deltas reported by a relay since its last flush.
+type ConnectionRejections struct {
+	Concurrent int64 `json:"concurrent"`
+	Rate       int64 `json:"rate"`
+}
+
+// Quota periods
+const (
+	QuotaPeriodNone    = "none"
+	QuotaPeriodDaily   = "daily"
+	QuotaPeriodWeekly  = "weekly"
+	QuotaPeriodMonthly = "monthly"
+)
+
+// Over-quota actions
+const (
+	QuotaActionCut      = "cut"
+	QuotaActionThrottle = "throttle"
+)
+
+// BandwidthQuota is the recurring quota policy on a connection's bandwidth_limit.
+type BandwidthQuota struct {
+	ConnectionID    uuid.UUID  `json:"connection_id" db:"connection_id"`
+	Period          string     `json:"period" db:"period"`
+	AnchorAt        time.Time  `json:"anchor_at" db:"anchor_at"`
+	CycleStart      time.Time  `json:"cycle_start" db:"cycle_start"`
+	CycleEnd        *time.Time `json:"cycle_end,omitempty" db:"-"` // nil for period "none"
+	NotifyPercents  []int      `json:"notify_percents" db:"notify_percents"`
+	NotifiedPercent int        `json:"notified_percent" db:"notified_percent"`
+	OverAction      string     `json:"over_action" db:"over_action"`
+	ThrottleKbps    int        `json:"throttle_kbps" db:"throttle_kbps"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// BandwidthQuotaCycle records the usage of a completed quota cycle.
+type BandwidthQuotaCycle struct {
+	ID           uuid.UUID `json:"id" db:"id"`
+	ConnectionID uuid.UUID `json:"connection_id" db:"connection_id"`
+	CycleStart   time.Time `json:"cycle_start" db:"cycle_start"`
+	CycleEnd     time.Time `json:"cycle_end" db:"cycle_end"`
+	BytesUsed    int64     `json:"bytes_used" db:"bytes_used"`
+	BytesLimit   int64     `json:"bytes_limit" db:"bytes_limit"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+// QuotaUsage joins a quota policy with its connection's current usage.
+type QuotaUsage struct {
+	Quota          BandwidthQuota `json:"quota"`
+	DeviceID       uuid.UUID      `json:"device_id"`
+	CustomerID     *uuid.UUID     `json:"customer_id"`
+	Username       string         `json:"username"`
+	BandwidthLimit int64          `json:"bandwidth_limit"`
+	BandwidthUsed  int64          `json:"bandwidth_used"`
+}
+
+// SetQuotaRequest sets a connection's byte cap and its quota policy.
+// AnchorAt defaults to now; NotifyPercents defaults to 80 and 100.
+type SetQuotaRequest struct {
+	BandwidthLimit int64      `json:"bandwidth_limit" binding:"min=0"`
+	Period         string     `json:"period"`
+	AnchorAt       *time.Time `json:"anchor_at"`
+	NotifyPercents []int      `json:"notify_percents"`
+	OverAction     string     `json:"over_action"`
+	ThrottleKbps   int        `json:"throttle_kbps" binding:"min=0"`
+}
+
+// Plan defines what a customer is entitled to. Zero numeric limits mean unlimited.
+type Plan struct {
+	ID                     uuid.UUID `json:"id" db:"id"`
+	Name                   string    `json:"name" db:"name"`
+	Description            string    `json:"description" db:"description"`
+	MaxDevices             int       `json:"max_devices" db:"max_devices"`
+	MaxConnections         int       `json:"max_connections" db:"max_connections"`
+	MonthlyGB              int       `json:"monthly_gb" db:"monthly_gb"` // GiB per calendar month (UTC)
+	AllowedProxyTypes      []string  `json:"allowed_proxy_types" db:"allowed_proxy_types"`
+	MaxRotationsPerDay     int       `json:"max_rotations_per_day" db:"max_rotations_per_day"`
+	MinRotationIntervalSec int       `json:"min_rotation_interval_sec" db:"min_rotation_interval_sec"`
+	Active                 bool      `json:"active" db:"active"`
+	ProviderPriceID        string    `json:"provider_price_id" db:"provider_price_id"` // price charged at checkout
+	CreatedAt              time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt              time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type PlanRequest struct {
+	Name                   string   `json:"name" binding:"required"`
+	Description            string   `json:"description"`
+	MaxDevices             int      `json:"max_devices" binding:"min=0"`
+	MaxConnections         int      `json:"max_connections" binding:"min=0"`
+	MonthlyGB              int      `json:"monthly_gb" binding:"min=0"`
+	AllowedProxyTypes      []string `json:"allowed_proxy_types"`
+	MaxRotationsPerDay     int      `json:"max_rotations_per_day" binding:"min=0"`
+	MinRotationIntervalSec int      `json:"min_rotation_interval_sec" binding:"min=0"`
+	Active                 *bool    `json:"active"`
+	ProviderPriceID        string   `json:"provider_price_id"`
+}
+
+// PlanUsage is a customer's plan alongside what they currently use.
+type PlanUsage struct {
+	Plan           *Plan `json:"plan"` // nil = unrestricted
+	Devices        int   `json:"devices"`
+	Connections    int   `json:"connections"`
+	MonthBytes     int64 `json:"month_bytes"`
+	RotationsToday int   `json:"rotations_today"`
+}
+
+// Statement statuses
+const (
+	StatementOpen   = "open"   // current or not yet closed month, computed on request
+	StatementClosed = "closed" // stored by the worker after the month ended
+)
+
+// Statement summarizes a customer's usage over one UTC calendar month.
+// PeriodEnd is exclusive.
+type Statement struct {
+	ID             *uuid.UUID      `json:"id,omitempty" db:"id"`
+	CustomerID     uuid.UUID       `json:"customer_id" db:"customer_id"`
+	Status         string          `json:"status" db:"-"`
+	PeriodStart    time.Time       `json:"period_start" db:"period_start"`
+	PeriodEnd      time.Time       `json:"period_end" db:"period_end"`
+	PlanName       string          `json:"plan_name" db:"plan_name"`
+	Devices        int             `json:"devices" db:"devices"`
+	DeviceDays     int             `json:"device_days" db:"device_days"`
+	ConnectionDays int             `json:"connection_days" db:"connection_days"`
+	BytesIn        int64           `json:"bytes_in" db:"bytes_in"`
+	BytesOut       int64           `json:"bytes_out" db:"bytes_out"`
+	Rotations      int             `json:"rotations" db:"rotations"`
+	Lines          []StatementLine `json:"lines" db:"lines"`
+	ClosedAt       *time.Time      `json:"closed_at,omitempty" db:"closed_at"`
+}
+
+// StatementLine is one connection's share of a statement.
+type StatementLine struct {
+	ConnectionID uuid.UUID `json:"connection_id"`
+	DeviceID     uuid.UUID `json:"device_id"`
+	Username     string    `json:"username"`
+	ProxyType    string    `json:"proxy_type"`
+	Days         int       `json:"days"`
+	BytesIn      int64     `json:"bytes_in"`
+	BytesOut     int64     `json:"bytes_out"`
+}
+
+// Billing account statuses
+const (
+	BillingPending  = "pending"  // checkout started, not paid yet
+	BillingActive   = "active"
+	BillingPastDue  = "past_due" // renewal payment failed, customer suspended
+	BillingCanceled = "canceled"
+)
+
+// BillingAccount links a customer to their subscription at the payment provider.
+type BillingAccount struct {
+	CustomerID         uuid.UUID  `json:"customer_id" db:"customer_id"`
+	Provider           string     `json:"provider" db:"provider"`
+	ProviderCustomerID string     `json:"provider_customer_id" db:"provider_customer_id"`
+	SubscriptionID     string     `json:"subscription_id" db:"subscription_id"`
+	PlanID             *uuid.UUID `json:"plan_id" db:"plan_id"`
+	Status             string     `json:"status" db:"status"`
+	Suspended          bool       `json:"suspended" db:"suspended"` // customer suspended by billing
+	CreatedAt          time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// Billing event types, normalized across payment providers
+const (
+	BillingEventCheckoutCompleted    = "checkout.completed"
+	BillingEventPaymentSucceeded     = "payment.succeeded"
+	BillingEventPaymentFailed        = "payment.failed"
+	BillingEventSubscriptionCanceled = "subscription.canceled"
+)
+
+// BillingEvent is a verified provider webhook translated to what the billing
+// service acts on. CustomerID and PlanID are only known for checkouts (we pass
+// them through the provider); later events are matched by SubscriptionID.
+type BillingEvent struct {
+	ID                 string     `json:"id"`
+	Type               string     `json:"type"`
+	CustomerID         *uuid.UUID `json:"customer_id,omitempty"`
+	PlanID             *uuid.UUID `json:"plan_id,omitempty"`
+	ProviderCustomerID string     `json:"provider_customer_id,omitempty"`
+	SubscriptionID     string     `json:"subscription_id,omitempty"`
+}
+
+type CheckoutRequest struct {
+	PlanID uuid.UUID `json:"plan_id" binding:"required"`
+}
+
+// CheckoutSession is where the customer is sent to pay.
+type CheckoutSession struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// API key scopes
+const (
+	ScopeRead              = "read"               // device and connection reads (see middleware.apiKeyReadRoutes)
+	ScopeRotate            = "rotate"             // IP rotation commands
+	ScopeManageConnections = "manage_connections" // create, change and delete connections
+)
+
+// APIKey is a long-lived customer credential. The raw key is only returned by
+// CreateAPIKeyResponse; afterwards only its hash is kept.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id" db:"id"`
+	CustomerID uuid.UUID  `json:"customer_id" db:"customer_id"`
+	Name       string     `json:"name" db:"name"`
+	Prefix     string     `json:"prefix" db:"prefix"`
+	KeyHash    string     `json:"-" db:"key_hash"`
+	Scopes     []string   `json:"scopes" db:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at" db:"last_used_at"`
+	LastUsedIP *string    `json:"last_used_ip" db:"last_used_ip"`
+	ExpiresAt  *time.Time `json:"expires_at" db:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at" db:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	Scopes        []string `json:"scopes" binding:"required,min=1"`
+	ExpiresInDays int      `json:"expires_in_days" binding:"min=0"` // 0 = never
+}
+
+type CreateAPIKeyResponse struct {
+	Key    string `json:"key"` // shown once
+	APIKey APIKey `json:"api_key"`
 }
 
 type CommandRequest struct {
@@ -326,8 +871,431 @@ type CustomerLoginRequest struct {
 }
 
 type CustomerLoginResponse struct {
-	Token    string   `json:"token"`
-	Customer Customer `json:"customer"`
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"` // when Token expires
+	Customer     Customer  `json:"customer"`
+}
+
+// Session subject types
+const (
+	SessionSubjectUser     = "user"
+	SessionSubjectCustomer = "customer"
+)
+
+// AuthSession is a login on one device/browser, kept alive by refresh tokens.
+type AuthSession struct {
+	ID          uuid.UUID  `json:"id" db:"id"`
+	SubjectType string     `json:"subject_type" db:"subject_type"`
+	SubjectID   uuid.UUID  `json:"subject_id" db:"subject_id"`
+	UserAgent   string     `json:"user_agent" db:"user_agent"`
+	IP          string     `json:"ip" db:"ip"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt  time.Time  `json:"last_used_at" db:"last_used_at"`
+	ExpiresAt   time.Time  `json:"expires_at" db:"expires_at"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	Current     bool       `json:"current" db:"-"` // the session of the calling token
+}
+
+// TokenPair is an access token and the refresh token that renews it.
+type TokenPair struct {
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// ClientInfo describes where a login comes from, for the sessions list.
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// TwoFactor is a subject's TOTP enrollment. EnabledAt is nil until the first
+// code is confirmed.
+type TwoFactor struct {
+	SubjectType    string     `json:"subject_type" db:"subject_type"`
+	SubjectID      uuid.UUID  `json:"subject_id" db:"subject_id"`
+	Secret         string     `json:"-" db:"secret"`
+	EnabledAt      *time.Time `json:"enabled_at,omitempty" db:"enabled_at"`
+	LastUsedStep   int64      `json:"-" db:"last_used_step"`
+	FailedAttempts int        `json:"-" db:"failed_attempts"`
+	LockedUntil    *time.Time `json:"locked_until,omitempty" db:"locked_until"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+}
+
+type TwoFactorStatus struct {
+	Enabled           bool       `json:"enabled"`
+	Pending           bool       `json:"pending"`  // enrollment started, not confirmed
+	Required          bool       `json:"required"` // enforced by the operator 2FA setting
+	EnabledAt         *time.Time `json:"enabled_at,omitempty"`
+	RecoveryCodesLeft int        `json:"recovery_codes_left"`
+}
+
+// TwoFactorEnrollment is returned when enrollment starts; OTPAuthURL is meant
+// to be shown as a QR code.
+type TwoFactorEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURL string `json:"otpauth_url"`
+}
+
+// TwoFactorChallenge is the login response when a password was accepted but a
+// second factor is still needed. SetupRequired means the account must enroll
+// first (operators while the 2FA requirement is on).
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool      `json:"two_factor_required"`
+	SetupRequired     bool      `json:"setup_required"`
+	ChallengeToken    string    `json:"challenge_token"`
+	ExpiresAt         time.Time `json:"expires_at"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type TwoFactorSetupRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+}
+
+// TwoFactorVerifyRequest completes a login. Code is a TOTP code or a recovery code.
+type TwoFactorVerifyRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+// TwoFactorLoginResponse carries the session of a login completed with a
+// second factor. Exactly one of User and Customer is set. RecoveryCodes is only
+// present when the login also confirmed a new enrollment.
+type TwoFactorLoginResponse struct {
+	Token         string    `json:"token"`
+	RefreshToken  string    `json:"refresh_token"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	User          *User     `json:"user,omitempty"`
+	Customer      *Customer `json:"customer,omitempty"`
+	RecoveryCodes []string  `json:"recovery_codes,omitempty"`
+}
+
+type SecuritySettings struct {
+	RequireOperator2FA bool `json:"require_operator_2fa"`
+}
+
+// Audit actor types
+const (
+	AuditActorUser     = "user"
+	AuditActorCustomer = "customer"
+	AuditActorAPIKey   = "api_key"
+	AuditActorInternal = "internal" // peer sync, device pairing, ...
+)
+
+// AuditEntry records one state-changing action. CustomerID is the customer
+// account (organization) that acted, directly or through an API key, so
+// customers can list their own actions. Changes maps each changed field to its before/after value.
+type AuditEntry struct {
+	ID         uuid.UUID              `json:"id" db:"id"`
+	ActorType  string                 `json:"actor_type" db:"actor_type"`
+	ActorID    *uuid.UUID             `json:"actor_id" db:"actor_id"`
+	ActorLabel string                 `json:"actor_label" db:"actor_label"` // email, key prefix or service name
+	CustomerID *uuid.UUID             `json:"customer_id" db:"customer_id"`
+	Action     string                 `json:"action" db:"action"` // e.g. "connection.delete"
+	TargetType string                 `json:"target_type" db:"target_type"`
+	TargetID   *uuid.UUID             `json:"target_id" db:"target_id"`
+	Changes    map[string]AuditChange `json:"changes,omitempty" db:"changes"`
+	Request    json.RawMessage        `json:"request,omitempty" db:"request"`
+	Method     string                 `json:"method" db:"method"`
+	Path       string                 `json:"path" db:"path"`
+	Status     int                    `json:"status" db:"status"`
+	SourceIP   string                 `json:"source_ip" db:"source_ip"`
+	CreatedAt  time.Time              `json:"created_at" db:"created_at"`
+}
+
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditFilter narrows an audit log listing. Zero fields don't filter.
+type AuditFilter struct {
+	ActorType  string
+	ActorID    *uuid.UUID
+	CustomerID *uuid.UUID
+	Action     string // exact, or a prefix when it ends in "." (e.g. "connection.")
+	TargetType string
+	TargetID   *uuid.UUID
+	From       time.Time
+	To         time.Time
+	Limit      int
+	Offset     int
+}
+
+// Organization member roles
+const (
+	OrgRoleOwner   = "owner"   // everything, including members, billing and API keys
+	OrgRoleManager = "manager" // devices, connections and shares
+	OrgRoleViewer  = "viewer"  // read-only
+)
+
+// Organization is a team account. Its ID is the id of the founding customer
+// account, which holds the plan, billing and suspension state; every resource
+// owned by that customer id belongs to the organization.
+type Organization struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	Name      string    `json:"name" db:"name"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// OrganizationMember is a customer login of an organization. Email, Name and
+// Active come from the login's customer row.
+type OrganizationMember struct {
+	OrganizationID uuid.UUID `json:"organization_id" db:"organization_id"`
+	CustomerID     uuid.UUID `json:"customer_id" db:"customer_id"`
+	Role           string    `json:"role" db:"role"`
+	Email          string    `json:"email" db:"email"`
+	Name           string    `json:"name" db:"name"`
+	Active         bool      `json:"active" db:"active"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+type OrganizationResponse struct {
+	Organization *Organization        `json:"organization"`
+	Members      []OrganizationMember `json:"members"`
+	Role         string               `json:"role"` // the caller's role; empty for staff
+}
+
+type UpdateOrganizationRequest struct {
+	Name string `json:"name" binding:"required,max=255"`
+}
+
+type AddOrganizationMemberRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Name  string `json:"name"`
+	Role  string `json:"role" binding:"required,oneof=owner manager viewer"`
+}
+
+type UpdateOrganizationMemberRequest struct {
+	Role string `json:"role" binding:"required,oneof=owner manager viewer"`
+}
+
+// ResellerBranding is the white-label look a reseller's customers see. Domain
+// is the reseller's own dashboard host name, used to brand the login page.
+type ResellerBranding struct {
+	ResellerID   uuid.UUID `json:"reseller_id" db:"reseller_id"`
+	CompanyName  string    `json:"company_name" db:"company_name"`
+	LogoURL      string    `json:"logo_url" db:"logo_url"`
+	PrimaryColor string    `json:"primary_color" db:"primary_color"`
+	SupportEmail string    `json:"support_email" db:"support_email"`
+	Domain       *string   `json:"domain" db:"domain"`
+	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type UpdateResellerBrandingRequest struct {
+	CompanyName  string  `json:"company_name" binding:"max=255"`
+	LogoURL      string  `json:"logo_url" binding:"omitempty,url"`
+	PrimaryColor string  `json:"primary_color" binding:"omitempty,hexcolor"`
+	SupportEmail string  `json:"support_email" binding:"omitempty,email"`
+	Domain       *string `json:"domain" binding:"omitempty,hostname"`
+}
+
+// AllocateResellerRequest hands a device or customer to a reseller, or takes
+// it back with a null reseller_id.
+type AllocateResellerRequest struct {
+	ResellerID *uuid.UUID `json:"reseller_id"`
+}
+
+// Webhook event types
+const (
+	WebhookEventDeviceOffline          = "device.offline"
+	WebhookEventDeviceOnline           = "device.online"
+	WebhookEventBatteryLow             = "device.battery_low"
+	WebhookEventIPChanged              = "ip.changed"
+	WebhookEventRotationCompleted      = "rotation.completed"
+	WebhookEventConnectionQuotaReached = "connection.quota_reached"
+	WebhookEventConnectionExpired      = "connection.expired"
+	WebhookEventAlertTriggered         = "alert.triggered"
+	WebhookEventAlertResolved          = "alert.resolved"
+	WebhookEventTest                   = "test" // sent on request only, never subscribed
+)
+
+// WebhookEvents lists the event types endpoints can subscribe to.
+var WebhookEvents = []string{
+	WebhookEventDeviceOffline, WebhookEventDeviceOnline, WebhookEventBatteryLow, WebhookEventIPChanged,
+	WebhookEventRotationCompleted, WebhookEventConnectionQuotaReached, WebhookEventConnectionExpired,
+	WebhookEventAlertTriggered, WebhookEventAlertResolved,
+}
+
+// Webhook endpoint kinds. Chat kinds receive a formatted message instead of
+// the signed JSON event.
+const (
+	WebhookKindWebhook  = "webhook"
+	WebhookKindSlack    = "slack"    // Slack incoming webhook URL
+	WebhookKindTelegram = "telegram" // bot token + chat ID
+	WebhookKindDiscord  = "discord"  // Discord channel webhook URL
+)
+
+// Webhook delivery statuses
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// WebhookEndpoint receives signed event deliveries, or formatted messages
+// for chat kinds. It belongs to either a staff user (UserID) or a customer
+// organization (CustomerID). An empty Events list subscribes to every event.
+type WebhookEndpoint struct {
+	ID          uuid.UUID  `json:"id" db:"id"`
+	UserID      *uuid.UUID `json:"user_id,omitempty" db:"user_id"`
+	CustomerID  *uuid.UUID `json:"customer_id,omitempty" db:"customer_id"`
+	Kind        string     `json:"kind" db:"kind"`
+	URL         string     `json:"url" db:"url"`
+	ChatID      string     `json:"chat_id,omitempty" db:"chat_id"` // telegram
+	Description string     `json:"description" db:"description"`
+	Secret      string     `json:"-" db:"secret"` // signing secret, or the telegram bot token
+	Events      []string   `json:"events" db:"events"`
+	Active      bool       `json:"active" db:"active"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// WebhookOwner identifies whose endpoints or alert rules a request manages:
+// a staff user or a customer organization.
+type WebhookOwner struct {
+	UserID     *uuid.UUID
+	CustomerID *uuid.UUID
+}
+
+// WebhookDelivery is one queued event for one endpoint, with the outcome of
+// its latest attempt. EndpointID is nil for the legacy users.webhook_url.
+type WebhookDelivery struct {
+	ID             uuid.UUID       `json:"id" db:"id"`
+	EndpointID     *uuid.UUID      `json:"endpoint_id" db:"endpoint_id"`
+	UserID         *uuid.UUID      `json:"user_id,omitempty" db:"user_id"`
+	CustomerID     *uuid.UUID      `json:"customer_id,omitempty" db:"customer_id"`
+	URL            string          `json:"url" db:"url"`
+	Event          string          `json:"event" db:"event"`
+	Payload        json.RawMessage `json:"payload" db:"payload"`
+	Status         string          `json:"status" db:"status"`
+	Attempts       int             `json:"attempts" db:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at" db:"next_attempt_at"`
+	LastAttemptAt  *time.Time      `json:"last_attempt_at" db:"last_attempt_at"`
+	ResponseStatus *int            `json:"response_status" db:"response_status"`
+	ResponseBody   string          `json:"response_body" db:"response_body"`
+	Error          string          `json:"error" db:"error"`
+	RedeliveryOf   *uuid.UUID      `json:"redelivery_of" db:"redelivery_of"`
+	CreatedAt      time.Time       `json:"created_at" db:"created_at"`
+}
+
+// CreateWebhookEndpointRequest adds an endpoint. Kind defaults to webhook;
+// telegram channels take BotToken and ChatID instead of URL.
+type CreateWebhookEndpointRequest struct {
+	Kind        string   `json:"kind" binding:"omitempty,oneof=webhook slack telegram discord"`
+	URL         string   `json:"url" binding:"omitempty,url"`
+	BotToken    string   `json:"bot_token" binding:"max=64"`
+	ChatID      string   `json:"chat_id" binding:"max=64"`
+	Description string   `json:"description" binding:"max=255"`
+	Events      []string `json:"events"`
+}
+
+// UpdateWebhookEndpointRequest edits an endpoint; its kind is fixed. An
+// empty BotToken keeps a telegram channel's current token.
+type UpdateWebhookEndpointRequest struct {
+	URL         string   `json:"url" binding:"omitempty,url"`
+	BotToken    string   `json:"bot_token" binding:"max=64"`
+	ChatID      string   `json:"chat_id" binding:"max=64"`
+	Description string   `json:"description" binding:"max=255"`
+	Events      []string `json:"events"`
+	Active      bool     `json:"active"`
+}
+
+// WebhookEndpointSecretResponse is returned when an endpoint is created or
+// its secret rotated, the only times the signing secret is shown. Chat
+// channels have no signing secret.
+type WebhookEndpointSecretResponse struct {
+	*WebhookEndpoint
+	Secret string `json:"secret,omitempty"`
+}
+
+// Alert rule types
+const (
+	AlertBatteryLow       = "battery_low"       // Threshold: percent, while not charging
+	AlertSignalLow        = "signal_low"        // Threshold: dBm
+	AlertNetworkDowngrade = "network_downgrade" // Value: lowest acceptable network type, e.g. "4G"
+	AlertCarrierChanged   = "carrier_changed"   // Value: expected carrier, or empty for the first one seen
+	AlertAppOutdated      = "app_outdated"      // Value: minimum app version
+)
+
+// Alert incident statuses
+const (
+	AlertIncidentOpen     = "open"
+	AlertIncidentResolved = "resolved"
+)
+
+// AlertRule is a condition on device heartbeat telemetry. It fires once the
+// condition has held for DurationMinutes and notifies at most once per
+// CooldownMinutes for the same device. DeviceID nil covers every device the
+// owner can see.
+type AlertRule struct {
+	ID              uuid.UUID  `json:"id" db:"id"`
+	UserID          *uuid.UUID `json:"user_id,omitempty" db:"user_id"`
+	CustomerID      *uuid.UUID `json:"customer_id,omitempty" db:"customer_id"`
+	DeviceID        *uuid.UUID `json:"device_id" db:"device_id"`
+	Name            string     `json:"name" db:"name"`
+	Type            string     `json:"type" db:"type"`
+	Threshold       int        `json:"threshold" db:"threshold"`
+	Value           string     `json:"value" db:"value"`
+	DurationMinutes int        `json:"duration_minutes" db:"duration_minutes"`
+	CooldownMinutes int        `json:"cooldown_minutes" db:"cooldown_minutes"`
+	NotifyWebhook   bool       `json:"notify_webhook" db:"notify_webhook"`
+	NotifyEmail     bool       `json:"notify_email" db:"notify_email"`
+	Active          bool       `json:"active" db:"active"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// AlertRuleRequest creates or replaces an alert rule.
+type AlertRuleRequest struct {
+	DeviceID        *uuid.UUID `json:"device_id"`
+	Name            string     `json:"name" binding:"max=100"`
+	Type            string     `json:"type" binding:"required,oneof=battery_low signal_low network_downgrade carrier_changed app_outdated"`
+	Threshold       int        `json:"threshold"`
+	Value           string     `json:"value" binding:"max=64"`
+	DurationMinutes int        `json:"duration_minutes" binding:"min=0,max=1440"`
+	CooldownMinutes *int       `json:"cooldown_minutes" binding:"omitempty,min=0,max=10080"`
+	NotifyWebhook   bool       `json:"notify_webhook"`
+	NotifyEmail     bool       `json:"notify_email"`
+	Active          *bool      `json:"active"`
+}
+
+// AlertRuleState is the evaluation state of a rule for one device.
+type AlertRuleState struct {
+	RuleID         uuid.UUID
+	DeviceID       uuid.UUID
+	ConditionSince *time.Time
+	Baseline       string
+	LastNotifiedAt *time.Time
+}
+
+// AlertIncident is one period during which a rule's condition held for a
+// device. It resolves automatically once the condition clears, or by hand.
+type AlertIncident struct {
+	ID         uuid.UUID  `json:"id" db:"id"`
+	RuleID     uuid.UUID  `json:"rule_id" db:"rule_id"`
+	RuleName   string     `json:"rule_name" db:"rule_name"`
+	DeviceID   uuid.UUID  `json:"device_id" db:"device_id"`
+	DeviceName string     `json:"device_name" db:"device_name"`
+	UserID     *uuid.UUID `json:"user_id,omitempty" db:"user_id"`
+	CustomerID *uuid.UUID `json:"customer_id,omitempty" db:"customer_id"`
+	Type       string     `json:"type" db:"type"`
+	Status     string     `json:"status" db:"status"`
+	Message    string     `json:"message" db:"message"`
+	Observed   string     `json:"observed" db:"observed"`
+	Notified   bool       `json:"notified" db:"notified"`
+	OpenedAt   time.Time  `json:"opened_at" db:"opened_at"`
+	ResolvedAt *time.Time `json:"resolved_at" db:"resolved_at"`
+	ResolvedBy *string    `json:"resolved_by" db:"resolved_by"` // auto or manual
 }
 
 type ForgotPasswordRequest struct {
@@ -349,9 +1317,21 @@ type ResendVerificationRequest struct {
 	Email string `json:"email" binding:"required,email"`
 }
 
+type ACLRuleInput struct {
+	Action      string  `json:"action" binding:"required"`
+	RuleType    string  `json:"rule_type" binding:"required"`
+	Value       string  `json:"value" binding:"required"`
+	Description *string `json:"description"`
+}
+
+type SetACLRequest struct {
+	Rules []ACLRuleInput `json:"rules"`
+}
+
 // WebSocket message types
 
 type WSMessage struct {
 	Type    string      `json:"type"`
 	Payload interface{} `json:"payload"`
 }
+
@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+)
+
+type ACLRepository struct {
+	db *DB
+}
+
+func NewACLRepository(db *DB) *ACLRepository {
+	return &ACLRepository{db: db}
+}
+
+const aclRuleSelectCols = `id, connection_id, action, rule_type, value, description, created_at`
+
+const aclDenialSelectCols = `id, connection_id, username, client_ip, dest_host, dest_port, rule_id, scope, occurred_at, created_at`
+
+// ListByConnection returns the rules attached to a single connection.
+func (r *ACLRepository) ListByConnection(ctx context.Context, connectionID uuid.UUID) ([]domain.ACLRule, error) {
+	query := `SELECT ` + aclRuleSelectCols + ` FROM acl_rules WHERE connection_id = $1 ORDER BY created_at ASC`
+	return r.scanRules(ctx, query, connectionID)
+}
+
+// ListGlobal returns the global policy rules (connection_id IS NULL).
+func (r *ACLRepository) ListGlobal(ctx context.Context) ([]domain.ACLRule, error) {
+	query := `SELECT ` + aclRuleSelectCols + ` FROM acl_rules WHERE connection_id IS NULL ORDER BY created_at ASC`
+	return r.scanRules(ctx, query)
+}
+
+// ListAll returns every rule, global and per-connection.
+func (r *ACLRepository) ListAll(ctx context.Context) ([]domain.ACLRule, error) {
+	query := `SELECT ` + aclRuleSelectCols + ` FROM acl_rules ORDER BY created_at ASC`
+	return r.scanRules(ctx, query)
+}
+
+// Replace swaps the rule set for a connection (or the global policy when
+// connectionID is nil) in a single transaction.
+func (r *ACLRepository) Replace(ctx context.Context, connectionID *uuid.UUID, rules []domain.ACLRule) error {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if connectionID == nil {
+		_, err = tx.Exec(ctx, `DELETE FROM acl_rules WHERE connection_id IS NULL`)
+	} else {
+		_, err = tx.Exec(ctx, `DELETE FROM acl_rules WHERE connection_id = $1`, *connectionID)
+	}
+	if err != nil {
+		return fmt.Errorf("delete acl rules: %w", err)
+	}
+
+	for _, rule := range rules {
+		query := `INSERT INTO acl_rules (id, connection_id, action, rule_type, value, description)
+			VALUES ($1, $2, $3, $4, $5, $6)`
+		if _, err := tx.Exec(ctx, query,
+			rule.ID, connectionID, rule.Action, rule.RuleType, rule.Value, rule.Description); err != nil {
+			return fmt.Errorf("insert acl rule: %w", err)
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// DeleteByConnection removes all rules for a connection (called on connection delete).
+func (r *ACLRepository) DeleteByConnection(ctx context.Context, connectionID uuid.UUID) error {
+	_, err := r.db.Pool.Exec(ctx, `DELETE FROM acl_rules WHERE connection_id = $1`, connectionID)
+	return err
+}
+
+// CreateDenials inserts a batch of denied attempts reported by a relay.
+func (r *ACLRepository) CreateDenials(ctx context.Context, denials []domain.ACLDenial) error {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, d := range denials {
+		query := `INSERT INTO acl_denials (id, connection_id, username, client_ip, dest_host, dest_port, rule_id, scope, occurred_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+		if _, err := tx.Exec(ctx, query,
+			d.ID, d.ConnectionID, d.Username, d.ClientIP, d.DestHost, d.DestPort,
+			d.RuleID, d.Scope, d.OccurredAt); err != nil {
+			return fmt.Errorf("insert acl denial: %w", err)
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ListDenialsByConnection returns the most recent denials for a connection.
+func (r *ACLRepository) ListDenialsByConnection(ctx context.Context, connectionID uuid.UUID, limit int) ([]domain.ACLDenial, error) {
+	query := `SELECT ` + aclDenialSelectCols + ` FROM acl_denials WHERE connection_id = $1 ORDER BY occurred_at DESC LIMIT $2`
+	return r.scanDenials(ctx, query, connectionID, limit)
+}
+
+// ListDenials returns the most recent denials across all connections.
+func (r *ACLRepository) ListDenials(ctx context.Context, limit int) ([]domain.ACLDenial, error) {
+	query := `SELECT ` + aclDenialSelectCols + ` FROM acl_denials ORDER BY occurred_at DESC LIMIT $1`
+	return r.scanDenials(ctx, query, limit)
+}
+
+// DeleteDenialsBefore prunes denial records older than the cutoff.
+func (r *ACLRepository) DeleteDenialsBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := r.db.Pool.Exec(ctx, `DELETE FROM acl_denials WHERE occurred_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (r *ACLRepository) scanRules(ctx context.Context, query string, args ...interface{}) ([]domain.ACLRule, error) {
+	rows, err := r.db.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []domain.ACLRule
+	for rows.Next() {
+		var rule domain.ACLRule
+		if err := rows.Scan(
+			&rule.ID, &rule.ConnectionID, &rule.Action, &rule.RuleType, &rule.Value,
+			&rule.Description, &rule.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan acl rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (r *ACLRepository) scanDenials(ctx context.Context, query string, args ...interface{}) ([]domain.ACLDenial, error) {
+	rows, err := r.db.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var denials []domain.ACLDenial
+	for rows.Next() {
+		var d domain.ACLDenial
+		if err := rows.Scan(
+			&d.ID, &d.ConnectionID, &d.Username, &d.ClientIP, &d.DestHost, &d.DestPort,
+			&d.RuleID, &d.Scope, &d.OccurredAt, &d.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan acl denial: %w", err)
+		}
+		denials = append(denials, d)
+	}
+	return denials, nil
+}
@@ -0,0 +1,275 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/mobileproxy/server/internal/domain"
+)
+
+// AlertRepository stores alert rules, their per-device evaluation state and
+// the incidents they open.
+type AlertRepository struct {
+	db *DB
+}
+
+func NewAlertRepository(db *DB) *AlertRepository {
+	return &AlertRepository{db: db}
+}
+
+const alertRuleSelectCols = `id, user_id, customer_id, device_id, name, type, threshold, value,
+	duration_minutes, cooldown_minutes, notify_webhook, notify_email, active, created_at, updated_at`
+
+const alertIncidentSelectCols = `i.id, i.rule_id, r.name, i.device_id, d.name, i.user_id, i.customer_id, i.type,
+	i.status, i.message, i.observed, i.notified, i.opened_at, i.resolved_at, i.resolved_by`
+
+const alertIncidentFrom = ` FROM alert_incidents i
+	JOIN alert_rules r ON r.id = i.rule_id
+	JOIN devices d ON d.id = i.device_id`
+
+// ─── Rules ──────────────────────────────────────────────────────────────────
+
+func (r *AlertRepository) CreateRule(ctx context.Context, rule *domain.AlertRule) error {
+	query := `INSERT INTO alert_rules (id, user_id, customer_id, device_id, name, type, threshold, value,
+			duration_minutes, cooldown_minutes, notify_webhook, notify_email, active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING created_at, updated_at`
+	return r.db.Pool.QueryRow(ctx, query,
+		rule.ID, rule.UserID, rule.CustomerID, rule.DeviceID, rule.Name, rule.Type, rule.Threshold, rule.Value,
+		rule.DurationMinutes, rule.CooldownMinutes, rule.NotifyWebhook, rule.NotifyEmail, rule.Active,
+	).Scan(&rule.CreatedAt, &rule.UpdatedAt)
+}
+
+// UpdateRule replaces a rule's settings. Changing a rule restarts its
+// evaluation, so its per-device state is dropped.
+func (r *AlertRepository) UpdateRule(ctx context.Context, rule *domain.AlertRule) error {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `UPDATE alert_rules SET device_id = $2, name = $3, type = $4, threshold = $5, value = $6,
+			duration_minutes = $7, cooldown_minutes = $8, notify_webhook = $9, notify_email = $10, active = $11,
+			updated_at = NOW()
+		WHERE id = $1 RETURNING updated_at`
+	err = tx.QueryRow(ctx, query,
+		rule.ID, rule.DeviceID, rule.Name, rule.Type, rule.Threshold, rule.Value,
+		rule.DurationMinutes, rule.CooldownMinutes, rule.NotifyWebhook, rule.NotifyEmail, rule.Active,
+	).Scan(&rule.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("update alert rule: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM alert_rule_states WHERE rule_id = $1`, rule.ID); err != nil {
+		return fmt.Errorf("reset alert rule state: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+// GetRule returns a rule, or nil if there is none.
+func (r *AlertRepository) GetRule(ctx context.Context, id uuid.UUID) (*domain.AlertRule, error) {
+	query := `SELECT ` + alertRuleSelectCols + ` FROM alert_rules WHERE id = $1`
+	rule, err := scanAlertRule(r.db.Pool.QueryRow(ctx, query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return rule, err
+}
+
+// ListRules returns the rules of a staff user or customer organization.
+func (r *AlertRepository) ListRules(ctx context.Context, owner domain.WebhookOwner) ([]domain.AlertRule, error) {
+	query := `SELECT ` + alertRuleSelectCols + ` FROM alert_rules
+		WHERE user_id IS NOT DISTINCT FROM $1 AND customer_id IS NOT DISTINCT FROM $2
+		ORDER BY created_at ASC`
+	return r.listRules(ctx, query, owner.UserID, owner.CustomerID)
+}
+
+// ListActiveRules returns every active rule, for the worker.
+func (r *AlertRepository) ListActiveRules(ctx context.Context) ([]domain.AlertRule, error) {
+	query := `SELECT ` + alertRuleSelectCols + ` FROM alert_rules WHERE active ORDER BY created_at ASC`
+	return r.listRules(ctx, query)
+}
+
+func (r *AlertRepository) DeleteRule(ctx context.Context, id uuid.UUID) error {
+	_, err := r.db.Pool.Exec(ctx, `DELETE FROM alert_rules WHERE id = $1`, id)
+	return err
+}
+
+func (r *AlertRepository) listRules(ctx context.Context, query string, args ...interface{}) ([]domain.AlertRule, error) {
+	rows, err := r.db.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list alert rules: %w", err)
+	}
+	defer rows.Close()
+
+	rules := []domain.AlertRule{}
+	for rows.Next() {
+		rule, err := scanAlertRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *rule)
+	}
+	return rules, rows.Err()
+}
+
+func scanAlertRule(row pgx.Row) (*domain.AlertRule, error) {
+	var a domain.AlertRule
+	err := row.Scan(&a.ID, &a.UserID, &a.CustomerID, &a.DeviceID, &a.Name, &a.Type, &a.Threshold, &a.Value,
+		&a.DurationMinutes, &a.CooldownMinutes, &a.NotifyWebhook, &a.NotifyEmail, &a.Active, &a.CreatedAt, &a.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scan alert rule: %w", err)
+	}
+	return &a, nil
+}
+
+// ─── Evaluation state ───────────────────────────────────────────────────────
+
+// ListStates returns the evaluation state of every active rule, keyed by
+// rule and device.
+func (r *AlertRepository) ListStates(ctx context.Context) (map[[2]uuid.UUID]domain.AlertRuleState, error) {
+	query := `SELECT s.rule_id, s.device_id, s.condition_since, s.baseline, s.last_notified_at
+		FROM alert_rule_states s JOIN alert_rules r ON r.id = s.rule_id
+		WHERE r.active`
+	rows, err := r.db.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("list alert states: %w", err)
+	}
+	defer rows.Close()
+
+	states := map[[2]uuid.UUID]domain.AlertRuleState{}
+	for rows.Next() {
+		var s domain.AlertRuleState
+		if err := rows.Scan(&s.RuleID, &s.DeviceID, &s.ConditionSince, &s.Baseline, &s.LastNotifiedAt); err != nil {
+			return nil, fmt.Errorf("scan alert state: %w", err)
+		}
+		states[[2]uuid.UUID{s.RuleID, s.DeviceID}] = s
+	}
+	return states, rows.Err()
+}
+
+func (r *AlertRepository) SaveState(ctx context.Context, s *domain.AlertRuleState) error {
+	query := `INSERT INTO alert_rule_states (rule_id, device_id, condition_since, baseline, last_notified_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (rule_id, device_id) DO UPDATE SET
+			condition_since = EXCLUDED.condition_since, baseline = EXCLUDED.baseline,
+			last_notified_at = EXCLUDED.last_notified_at`
+	_, err := r.db.Pool.Exec(ctx, query, s.RuleID, s.DeviceID, s.ConditionSince, s.Baseline, s.LastNotifiedAt)
+	return err
+}
+
+// ResetState restarts the evaluation of a rule for a device with a new
+// baseline, e.g. when an incident is resolved by hand.
+func (r *AlertRepository) ResetState(ctx context.Context, ruleID, deviceID uuid.UUID, baseline string) error {
+	query := `INSERT INTO alert_rule_states (rule_id, device_id, baseline) VALUES ($1, $2, $3)
+		ON CONFLICT (rule_id, device_id) DO UPDATE SET baseline = EXCLUDED.baseline, condition_since = NULL`
+	_, err := r.db.Pool.Exec(ctx, query, ruleID, deviceID, baseline)
+	return err
+}
+
+// ─── Incidents ──────────────────────────────────────────────────────────────
+
+// OpenIncident records a new incident. Reports false if the rule already has
+// an open incident for the device.
+func (r *AlertRepository) OpenIncident(ctx context.Context, inc *domain.AlertIncident) (bool, error) {
+	query := `INSERT INTO alert_incidents (id, rule_id, device_id, user_id, customer_id, type, status, message, observed, notified, opened_at)
+		VALUES ($1, $2, $3, $4, $5, $6, 'open', $7, $8, $9, $10)
+		ON CONFLICT (rule_id, device_id) WHERE status = 'open' DO NOTHING`
+	tag, err := r.db.Pool.Exec(ctx, query,
+		inc.ID, inc.RuleID, inc.DeviceID, inc.UserID, inc.CustomerID, inc.Type, inc.Message, inc.Observed,
+		inc.Notified, inc.OpenedAt)
+	if err != nil {
+		return false, fmt.Errorf("open alert incident: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ResolveIncident closes an open incident. Reports false if it was not open.
+func (r *AlertRepository) ResolveIncident(ctx context.Context, id uuid.UUID, by string, at time.Time) (bool, error) {
+	tag, err := r.db.Pool.Exec(ctx, `UPDATE alert_incidents SET status = 'resolved', resolved_at = $3, resolved_by = $2
+		WHERE id = $1 AND status = 'open'`, id, by, at)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// MarkNotified records that an incident's notification went out.
+func (r *AlertRepository) MarkNotified(ctx context.Context, id uuid.UUID) error {
+	_, err := r.db.Pool.Exec(ctx, `UPDATE alert_incidents SET notified = TRUE WHERE id = $1`, id)
+	return err
+}
+
+// GetIncident returns an incident, or nil if there is none.
+func (r *AlertRepository) GetIncident(ctx context.Context, id uuid.UUID) (*domain.AlertIncident, error) {
+	query := `SELECT ` + alertIncidentSelectCols + alertIncidentFrom + ` WHERE i.id = $1`
+	inc, err := scanAlertIncident(r.db.Pool.QueryRow(ctx, query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return inc, err
+}
+
+// ListOpenIncidents returns every open incident keyed by rule and device,
+// for the worker.
+func (r *AlertRepository) ListOpenIncidents(ctx context.Context) (map[[2]uuid.UUID]domain.AlertIncident, error) {
+	query := `SELECT ` + alertIncidentSelectCols + alertIncidentFrom + ` WHERE i.status = 'open'`
+	incidents, err := r.listIncidents(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	open := make(map[[2]uuid.UUID]domain.AlertIncident, len(incidents))
+	for _, inc := range incidents {
+		open[[2]uuid.UUID{inc.RuleID, inc.DeviceID}] = inc
+	}
+	return open, nil
+}
+
+// ListIncidents returns an owner's incidents, newest first, optionally with
+// one status and/or for one device.
+func (r *AlertRepository) ListIncidents(ctx context.Context, owner domain.WebhookOwner, status string, deviceID *uuid.UUID, limit int) ([]domain.AlertIncident, error) {
+	query := `SELECT ` + alertIncidentSelectCols + alertIncidentFrom + `
+		WHERE i.user_id IS NOT DISTINCT FROM $1 AND i.customer_id IS NOT DISTINCT FROM $2
+			AND ($3 = '' OR i.status = $3)
+			AND ($4::uuid IS NULL OR i.device_id = $4)
+		ORDER BY i.opened_at DESC LIMIT $5`
+	return r.listIncidents(ctx, query, owner.UserID, owner.CustomerID, status, deviceID, limit)
+}
+
+func (r *AlertRepository) listIncidents(ctx context.Context, query string, args ...interface{}) ([]domain.AlertIncident, error) {
+	rows, err := r.db.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list alert incidents: %w", err)
+	}
+	defer rows.Close()
+
+	incidents := []domain.AlertIncident{}
+	for rows.Next() {
+		inc, err := scanAlertIncident(rows)
+		if err != nil {
+			return nil, err
+		}
+		incidents = append(incidents, *inc)
+	}
+	return incidents, rows.Err()
+}
+
+func scanAlertIncident(row pgx.Row) (*domain.AlertIncident, error) {
+	var i domain.AlertIncident
+	err := row.Scan(&i.ID, &i.RuleID, &i.RuleName, &i.DeviceID, &i.DeviceName, &i.UserID, &i.CustomerID, &i.Type,
+		&i.Status, &i.Message, &i.Observed, &i.Notified, &i.OpenedAt, &i.ResolvedAt, &i.ResolvedBy)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scan alert incident: %w", err)
+	}
+	return &i, nil
+}
@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/mobileproxy/server/internal/domain"
+)
+
+type APIKeyRepository struct {
+	db *DB
+}
+
+func NewAPIKeyRepository(db *DB) *APIKeyRepository {
+	return &APIKeyRepository{db: db}
+}
+
+const apiKeySelectCols = `id, customer_id, name, prefix, key_hash, scopes, last_used_at, last_used_ip,
+		expires_at, revoked_at, created_at`
+
+func (r *APIKeyRepository) Create(ctx context.Context, k *domain.APIKey) error {
+	query := `INSERT INTO api_keys (id, customer_id, name, prefix, key_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING created_at`
+	return r.db.Pool.QueryRow(ctx, query,
+		k.ID, k.CustomerID, k.Name, k.Prefix, k.KeyHash, k.Scopes, k.ExpiresAt,
+	).Scan(&k.CreatedAt)
+}
+
+// GetActiveByHash returns an unrevoked, unexpired key by hash, or nil.
+func (r *APIKeyRepository) GetActiveByHash(ctx context.Context, keyHash string) (*domain.APIKey, error) {
+	query := `SELECT ` + apiKeySelectCols + ` FROM api_keys
+		WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())`
+	k, err := r.scanAPIKey(r.db.Pool.QueryRow(ctx, query, keyHash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scan api key: %w", err)
+	}
+	return k, nil
+}
+
+func (r *APIKeyRepository) GetByID(ctx context.Context, id uuid.UUID) (*domain.APIKey, error) {
+	query := `SELECT ` + apiKeySelectCols + ` FROM api_keys WHERE id = $1`
+	k, err := r.scanAPIKey(r.db.Pool.QueryRow(ctx, query, id))
+	if err != nil {
+		return nil, fmt.Errorf("get api key: %w", err)
+	}
+	return k, nil
+}
+
+// ListByCustomer returns a customer's keys, revoked ones included, newest first.
+func (r *APIKeyRepository) ListByCustomer(ctx context.Context, customerID uuid.UUID) ([]domain.APIKey, error) {
+	query := `SELECT ` + apiKeySelectCols + ` FROM api_keys WHERE customer_id = $1 ORDER BY created_at DESC`
+	rows, err := r.db.Pool.Query(ctx, query, customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []domain.APIKey
+	for rows.Next() {
+		k, err := r.scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan api key: %w", err)
+		}
+		keys = append(keys, *k)
+	}
+	return keys, nil
+}
+
+// CountActive returns how many usable keys a customer has.
+func (r *APIKeyRepository) CountActive(ctx context.Context, customerID uuid.UUID) (int, error) {
+	query := `SELECT COUNT(*) FROM api_keys
+		WHERE customer_id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())`
+	var n int
+	err := r.db.Pool.QueryRow(ctx, query, customerID).Scan(&n)
+	return n, err
+}
+
+// TouchLastUsed records a use, writing at most once a minute per key.
+func (r *APIKeyRepository) TouchLastUsed(ctx context.Context, id uuid.UUID, ip string) error {
+	query := `UPDATE api_keys SET last_used_at = NOW(), last_used_ip = $2
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute' OR last_used_ip IS DISTINCT FROM $2)`
+	_, err := r.db.Pool.Exec(ctx, query, id, ip)
+	return err
+}
+
+func (r *APIKeyRepository) Revoke(ctx context.Context, id uuid.UUID) error {
+	query := `UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`
+	_, err := r.db.Pool.Exec(ctx, query, id)
+	return err
+}
+
+func (r *APIKeyRepository) scanAPIKey(row interface{ Scan(dest ...interface{}) error }) (*domain.APIKey, error) {
+	var k domain.APIKey
+	if err := row.Scan(
+		&k.ID, &k.CustomerID, &k.Name, &k.Prefix, &k.KeyHash, &k.Scopes, &k.LastUsedAt, &k.LastUsedIP,
+		&k.ExpiresAt, &k.RevokedAt, &k.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
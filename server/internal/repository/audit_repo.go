@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mobileproxy/server/internal/domain"
+)
+
+type AuditRepository struct {
+	db *DB
+}
+
+func NewAuditRepository(db *DB) *AuditRepository {
+	return &AuditRepository{db: db}
+}
+
+const auditSelectCols = `id, actor_type, actor_id, actor_label, customer_id, action, target_type, target_id,
+		changes, request, method, path, status, source_ip, created_at`
+
+func (r *AuditRepository) Create(ctx context.Context, e *domain.AuditEntry) error {
+	var changes []byte
+	if len(e.Changes) > 0 {
+		b, err := json.Marshal(e.Changes)
+		if err != nil {
+			return fmt.Errorf("marshal changes: %w", err)
+		}
+		changes = b
+	}
+	var request []byte
+	if len(e.Request) > 0 {
+		request = e.Request
+	}
+	query := `INSERT INTO audit_log (id, actor_type, actor_id, actor_label, customer_id, action, target_type, target_id,
+			changes, request, method, path, status, source_ip)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		RETURNING created_at`
+	return r.db.Pool.QueryRow(ctx, query,
+		e.ID, e.ActorType, e.ActorID, e.ActorLabel, e.CustomerID, e.Action, e.TargetType, e.TargetID,
+		changes, request, e.Method, e.Path, e.Status, e.SourceIP,
+	).Scan(&e.CreatedAt)
+}
+
+// List returns entries matching f, newest first.
+func (r *AuditRepository) List(ctx context.Context, f domain.AuditFilter) ([]domain.AuditEntry, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.ActorType != "" {
+		add("actor_type = $%d", f.ActorType)
+	}
+	if f.ActorID != nil {
+		add("actor_id = $%d", *f.ActorID)
+	}
+	if f.CustomerID != nil {
+		add("customer_id = $%d", *f.CustomerID)
+	}
+	if strings.HasSuffix(f.Action, ".") {
+		add("starts_with(action, $%d)", f.Action)
+	} else if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if f.TargetType != "" {
+		add("target_type = $%d", f.TargetType)
+	}
+	if f.TargetID != nil {
+		add("target_id = $%d", *f.TargetID)
+	}
+	if !f.From.IsZero() {
+		add("created_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("created_at < $%d", f.To)
+	}
+
+	query := `SELECT ` + auditSelectCols + ` FROM audit_log`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	args = append(args, f.Limit, f.Offset)
+	query += fmt.Sprintf(` ORDER BY created_at DESC LIMIT $%d OFFSET $%d`, len(args)-1, len(args))
+
+	rows, err := r.db.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []domain.AuditEntry
+	for rows.Next() {
+		var e domain.AuditEntry
+		var changes, request []byte
+		if err := rows.Scan(
+			&e.ID, &e.ActorType, &e.ActorID, &e.ActorLabel, &e.CustomerID, &e.Action, &e.TargetType, &e.TargetID,
+			&changes, &request, &e.Method, &e.Path, &e.Status, &e.SourceIP, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan audit entry: %w", err)
+		}
+		if len(changes) > 0 {
+			if err := json.Unmarshal(changes, &e.Changes); err != nil {
+				return nil, fmt.Errorf("decode audit changes: %w", err)
+			}
+		}
+		if len(request) > 0 {
+			e.Request = request
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// DeleteBefore removes entries older than cutoff and returns how many.
+func (r *AuditRepository) DeleteBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := r.db.Pool.Exec(ctx, `DELETE FROM audit_log WHERE created_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
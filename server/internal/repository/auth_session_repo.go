@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/mobileproxy/server/internal/domain"
+)
+
+type AuthSessionRepository struct {
+	db *DB
+}
+
+func NewAuthSessionRepository(db *DB) *AuthSessionRepository {
+	return &AuthSessionRepository{db: db}
+}
+
+const authSessionSelectCols = `id, subject_type, subject_id, user_agent, ip, created_at, last_used_at, expires_at, revoked_at`
+
+func (r *AuthSessionRepository) Create(ctx context.Context, s *domain.AuthSession, refreshHash string) error {
+	query := `INSERT INTO auth_sessions (id, subject_type, subject_id, refresh_hash, user_agent, ip, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING created_at, last_used_at`
+	return r.db.Pool.QueryRow(ctx, query,
+		s.ID, s.SubjectType, s.SubjectID, refreshHash, s.UserAgent, s.IP, s.ExpiresAt,
+	).Scan(&s.CreatedAt, &s.LastUsedAt)
+}
+
+// GetByRefreshHash finds the session a refresh token belongs to. reused is
+// true when the hash matched the session's previous (already rotated) token.
+// Returns nil if the token is unknown.
+func (r *AuthSessionRepository) GetByRefreshHash(ctx context.Context, hash string) (s *domain.AuthSession, reused bool, err error) {
+	query := `SELECT ` + authSessionSelectCols + `, previous_hash = $1 FROM auth_sessions
+		WHERE refresh_hash = $1 OR previous_hash = $1
+		LIMIT 1`
+	var sess domain.AuthSession
+	var prev *bool
+	err = r.db.Pool.QueryRow(ctx, query, hash).Scan(
+		&sess.ID, &sess.SubjectType, &sess.SubjectID, &sess.UserAgent, &sess.IP,
+		&sess.CreatedAt, &sess.LastUsedAt, &sess.ExpiresAt, &sess.RevokedAt, &prev)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("scan session: %w", err)
+	}
+	return &sess, prev != nil && *prev, nil
+}
+
+// Rotate replaces a live session's refresh token and extends it. Returns false
+// if oldHash is no longer current (a concurrent refresh won) or the session
+// was revoked.
+func (r *AuthSessionRepository) Rotate(ctx context.Context, id uuid.UUID, oldHash, newHash, ip string, expiresAt time.Time) (bool, error) {
+	query := `UPDATE auth_sessions SET previous_hash = refresh_hash, refresh_hash = $3, ip = $4,
+			last_used_at = NOW(), expires_at = $5
+		WHERE id = $1 AND refresh_hash = $2 AND revoked_at IS NULL AND expires_at > NOW()`
+	tag, err := r.db.Pool.Exec(ctx, query, id, oldHash, newHash, ip, expiresAt)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// IsActive reports whether a session exists, is not revoked and has not expired.
+func (r *AuthSessionRepository) IsActive(ctx context.Context, id uuid.UUID) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM auth_sessions WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW())`
+	var ok bool
+	err := r.db.Pool.QueryRow(ctx, query, id).Scan(&ok)
+	return ok, err
+}
+
+// ListActive returns a subject's live sessions, most recently used first.
+func (r *AuthSessionRepository) ListActive(ctx context.Context, subjectType string, subjectID uuid.UUID) ([]domain.AuthSession, error) {
+	query := `SELECT ` + authSessionSelectCols + ` FROM auth_sessions
+		WHERE subject_type = $1 AND subject_id = $2 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC`
+	rows, err := r.db.Pool.Query(ctx, query, subjectType, subjectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []domain.AuthSession
+	for rows.Next() {
+		var s domain.AuthSession
+		if err := rows.Scan(&s.ID, &s.SubjectType, &s.SubjectID, &s.UserAgent, &s.IP,
+			&s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt, &s.RevokedAt); err != nil {
+			return nil, fmt.Errorf("scan session: %w", err)
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
+
+// Revoke ends one session. With a non-nil subject, only if it belongs to them.
+// Returns false if nothing was revoked.
+func (r *AuthSessionRepository) Revoke(ctx context.Context, id uuid.UUID, subjectType string, subjectID *uuid.UUID) (bool, error) {
+	query := `UPDATE auth_sessions SET revoked_at = NOW()
+		WHERE id = $1 AND revoked_at IS NULL AND ($3::uuid IS NULL OR (subject_type = $2 AND subject_id = $3))`
+	tag, err := r.db.Pool.Exec(ctx, query, id, subjectType, subjectID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// RevokeAll ends every live session of a subject and returns their ids.
+func (r *AuthSessionRepository) RevokeAll(ctx context.Context, subjectType string, subjectID uuid.UUID) ([]uuid.UUID, error) {
+	query := `UPDATE auth_sessions SET revoked_at = NOW()
+		WHERE subject_type = $1 AND subject_id = $2 AND revoked_at IS NULL
+		RETURNING id`
+	rows, err := r.db.Pool.Query(ctx, query, subjectType, subjectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan session id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// DeleteEndedBefore removes sessions that expired or were revoked before cutoff.
+func (r *AuthSessionRepository) DeleteEndedBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	query := `DELETE FROM auth_sessions WHERE expires_at < $1 OR revoked_at < $1`
+	tag, err := r.db.Pool.Exec(ctx, query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
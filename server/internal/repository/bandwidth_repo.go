@@ -2,10 +2,13 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/mobileproxy/server/internal/domain"
 )
 
@@ -17,6 +20,11 @@ func NewBandwidthRepository(db *DB) *BandwidthRepository {
 	return &BandwidthRepository{db: db}
 }
 
+const (
+	bandwidthPartitionPrefix = "bandwidth_logs_"
+	bandwidthRollupName      = "bandwidth"
+)
+
 func (r *BandwidthRepository) Create(ctx context.Context, log *domain.BandwidthLog) error {
 	query := `INSERT INTO bandwidth_logs (id, device_id, connection_id, bytes_in, bytes_out, interval_start, interval_end)
 		VALUES ($1, $2, $3, $4, $5, $6, $7)`
@@ -28,7 +36,7 @@ func (r *BandwidthRepository) Create(ctx context.Context, log *domain.BandwidthL
 
 func (r *BandwidthRepository) GetDeviceTotalToday(ctx context.Context, deviceID uuid.UUID) (int64, int64, error) {
 	query := `SELECT COALESCE(SUM(bytes_in), 0), COALESCE(SUM(bytes_out), 0)
-		FROM bandwidth_logs WHERE device_id = $1 AND interval_start >= CURRENT_DATE`
+		FROM bandwidth_hourly WHERE device_id = $1 AND connection_id IS NULL AND bucket >= CURRENT_DATE`
 	var bytesIn, bytesOut int64
 	err := r.db.Pool.QueryRow(ctx, query, deviceID).Scan(&bytesIn, &bytesOut)
 	return bytesIn, bytesOut, err
@@ -38,7 +46,7 @@ func (r *BandwidthRepository) GetDeviceTotalMonth(ctx context.Context, deviceID
 	start := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
 	end := start.AddDate(0, 1, 0)
 	query := `SELECT COALESCE(SUM(bytes_in), 0), COALESCE(SUM(bytes_out), 0)
-		FROM bandwidth_logs WHERE device_id = $1 AND interval_start >= $2 AND interval_start < $3`
+		FROM bandwidth_daily WHERE device_id = $1 AND connection_id IS NULL AND day >= $2::date AND day < $3::date`
 	var bytesIn, bytesOut int64
 	err := r.db.Pool.QueryRow(ctx, query, deviceID, start, end).Scan(&bytesIn, &bytesOut)
 	return bytesIn, bytesOut, err
@@ -46,7 +54,7 @@ func (r *BandwidthRepository) GetDeviceTotalMonth(ctx context.Context, deviceID
 
 func (r *BandwidthRepository) GetTotalToday(ctx context.Context) (int64, int64, error) {
 	query := `SELECT COALESCE(SUM(bytes_in), 0), COALESCE(SUM(bytes_out), 0)
-		FROM bandwidth_logs WHERE interval_start >= CURRENT_DATE`
+		FROM bandwidth_hourly WHERE connection_id IS NULL AND bucket >= CURRENT_DATE`
 	var bytesIn, bytesOut int64
 	err := r.db.Pool.QueryRow(ctx, query).Scan(&bytesIn, &bytesOut)
 	return bytesIn, bytesOut, err
@@ -56,27 +64,90 @@ func (r *BandwidthRepository) GetTotalMonth(ctx context.Context, year int, month
 	start := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
 	end := start.AddDate(0, 1, 0)
 	query := `SELECT COALESCE(SUM(bytes_in), 0), COALESCE(SUM(bytes_out), 0)
-		FROM bandwidth_logs WHERE interval_start >= $1 AND interval_start < $2`
+		FROM bandwidth_daily WHERE connection_id IS NULL AND day >= $1::date AND day < $2::date`
 	var bytesIn, bytesOut int64
 	err := r.db.Pool.QueryRow(ctx, query, start, end).Scan(&bytesIn, &bytesOut)
 	return bytesIn, bytesOut, err
 }
 
 func (r *BandwidthRepository) GetDeviceHourly(ctx context.Context, deviceID uuid.UUID, date time.Time, tzOffsetMinutes int) ([]domain.BandwidthHourly, error) {
+	return r.getHourly(ctx, "device_id = $1 AND connection_id IS NULL", deviceID, date, tzOffsetMinutes)
+}
+
+// GetConnectionTotal sums relay-reported bytes for a connection in the hours [start, end).
+func (r *BandwidthRepository) GetConnectionTotal(ctx context.Context, connectionID uuid.UUID, start, end time.Time) (int64, int64, error) {
+	query := `SELECT COALESCE(SUM(bytes_in), 0), COALESCE(SUM(bytes_out), 0)
+		FROM bandwidth_hourly WHERE connection_id = $1 AND bucket >= $2 AND bucket < $3`
+	var bytesIn, bytesOut int64
+	err := r.db.Pool.QueryRow(ctx, query, connectionID, start, end).Scan(&bytesIn, &bytesOut)
+	return bytesIn, bytesOut, err
+}
+
+func (r *BandwidthRepository) GetConnectionHourly(ctx context.Context, connectionID uuid.UUID, date time.Time, tzOffsetMinutes int) ([]domain.BandwidthHourly, error) {
+	return r.getHourly(ctx, "connection_id = $1", connectionID, date, tzOffsetMinutes)
+}
+
+// GetConnectionDaily returns one entry per local day for the `days` days
+// ending on endDate (inclusive), oldest first. Built from hourly rollups so
+// any whole-hour timezone offset gets exact local days.
+func (r *BandwidthRepository) GetConnectionDaily(ctx context.Context, connectionID uuid.UUID, endDate time.Time, days, tzOffsetMinutes int) ([]domain.BandwidthDaily, error) {
+	offset := time.Duration(tzOffsetMinutes) * time.Minute
+	firstDay := time.Date(endDate.Year(), endDate.Month(), endDate.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -(days - 1))
+	start := firstDay.Add(offset)
+	end := start.AddDate(0, 0, days)
+
+	query := `SELECT to_char(bucket - $4 * interval '1 minute', 'YYYY-MM-DD') AS day,
+		COALESCE(SUM(bytes_in), 0) AS download_bytes,
+		COALESCE(SUM(bytes_out), 0) AS upload_bytes
+		FROM bandwidth_hourly
+		WHERE connection_id = $1 AND bucket >= $2 AND bucket < $3
+		GROUP BY day ORDER BY day`
+
+	rows, err := r.db.Pool.Query(ctx, query, connectionID, start, end, tzOffsetMinutes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dayMap := make(map[string]domain.BandwidthDaily)
+	for rows.Next() {
+		var d domain.BandwidthDaily
+		if err := rows.Scan(&d.Date, &d.DownloadBytes, &d.UploadBytes); err != nil {
+			return nil, err
+		}
+		dayMap[d.Date] = d
+	}
+
+	// Fill every day in the range
+	result := make([]domain.BandwidthDaily, days)
+	for i := 0; i < days; i++ {
+		key := firstDay.AddDate(0, 0, i).Format("2006-01-02")
+		if d, ok := dayMap[key]; ok {
+			result[i] = d
+		} else {
+			result[i] = domain.BandwidthDaily{Date: key}
+		}
+	}
+	return result, nil
+}
+
+// getHourly reads hourly rollup rows matching filter ($1 = id) by local hour.
+// Rollup buckets are UTC hours, so half-hour offsets land on the UTC hour.
+func (r *BandwidthRepository) getHourly(ctx context.Context, filter string, id uuid.UUID, date time.Time, tzOffsetMinutes int) ([]domain.BandwidthHourly, error) {
 	// Compute UTC start/end for the local day.
 	// tzOffsetMinutes matches JS getTimezoneOffset(): minutes from local→UTC.
 	// E.g. EST (UTC-5) = 300, CET (UTC+1) = -60.
 	start := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC).Add(time.Duration(tzOffsetMinutes) * time.Minute)
 	end := start.Add(24 * time.Hour)
 
-	query := `SELECT EXTRACT(HOUR FROM interval_start - $4 * interval '1 minute')::int AS hour,
+	query := `SELECT EXTRACT(HOUR FROM bucket - $4 * interval '1 minute')::int AS hour,
 		COALESCE(SUM(bytes_in), 0) AS download_bytes,
 		COALESCE(SUM(bytes_out), 0) AS upload_bytes
-		FROM bandwidth_logs
-		WHERE device_id = $1 AND interval_start >= $2 AND interval_start < $3
+		FROM bandwidth_hourly
+		WHERE ` + filter + ` AND bucket >= $2 AND bucket < $3
 		GROUP BY hour ORDER BY hour`
 
-	rows, err := r.db.Pool.Query(ctx, query, deviceID, start, end, tzOffsetMinutes)
+	rows, err := r.db.Pool.Query(ctx, query, id, start, end, tzOffsetMinutes)
 	if err != nil {
 		return nil, err
 	}
@@ -103,8 +174,60 @@ func (r *BandwidthRepository) GetDeviceHourly(ctx context.Context, deviceID uuid
 	return result, nil
 }
 
+// ApplyReport applies a relay bandwidth report in one transaction: the
+// (relay_id, seq) pair is recorded first and, if it was already seen, nothing
+// else happens. Otherwise each delta is added to proxy_connections.bandwidth_used
+// and written as a per-connection bandwidth_logs row. Returns false for duplicates.
+func (r *BandwidthRepository) ApplyReport(ctx context.Context, report *domain.BandwidthReport, deltas map[uuid.UUID]domain.BandwidthDelta) (bool, error) {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `INSERT INTO bandwidth_reports (relay_id, seq) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		report.RelayID, report.Seq)
+	if err != nil {
+		return false, fmt.Errorf("insert bandwidth report: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	for connID, d := range deltas {
+		var deviceID uuid.UUID
+		err := tx.QueryRow(ctx,
+			`UPDATE proxy_connections SET bandwidth_used = bandwidth_used + $2, updated_at = NOW() WHERE id = $1 RETURNING device_id`,
+			connID, d.BytesUp+d.BytesDown).Scan(&deviceID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue // connection deleted since the bytes were counted
+		}
+		if err != nil {
+			return false, fmt.Errorf("update bandwidth_used: %w", err)
+		}
+		id := connID
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO bandwidth_logs (id, device_id, connection_id, bytes_in, bytes_out, interval_start, interval_end)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			uuid.New(), deviceID, &id, d.BytesDown, d.BytesUp, report.IntervalStart, report.IntervalEnd); err != nil {
+			return false, fmt.Errorf("insert bandwidth log: %w", err)
+		}
+	}
+
+	return true, tx.Commit(ctx)
+}
+
+// DeleteReportsBefore prunes the idempotency ledger.
+func (r *BandwidthRepository) DeleteReportsBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := r.db.Pool.Exec(ctx, `DELETE FROM bandwidth_reports WHERE received_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
 func (r *BandwidthRepository) EnsurePartition(ctx context.Context, year int, month time.Month) error {
-	tableName := fmt.Sprintf("bandwidth_logs_%d_%02d", year, month)
+	tableName := fmt.Sprintf("%s%d_%02d", bandwidthPartitionPrefix, year, month)
 	start := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
 	end := start.AddDate(0, 1, 0)
 	query := fmt.Sprintf(
@@ -113,3 +236,139 @@ func (r *BandwidthRepository) EnsurePartition(ctx context.Context, year int, mon
 	_, err := r.db.Pool.Exec(ctx, query)
 	return err
 }
+
+// GetRollupWatermark returns the recorded_at up to which raw rows are rolled up.
+func (r *BandwidthRepository) GetRollupWatermark(ctx context.Context) (time.Time, error) {
+	var watermark time.Time
+	err := r.db.Pool.QueryRow(ctx, `SELECT watermark FROM bandwidth_rollup_state WHERE name = $1`,
+		bandwidthRollupName).Scan(&watermark)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return watermark, err
+}
+
+func (r *BandwidthRepository) SetRollupWatermark(ctx context.Context, watermark time.Time) error {
+	query := `INSERT INTO bandwidth_rollup_state (name, watermark) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET watermark = EXCLUDED.watermark`
+	_, err := r.db.Pool.Exec(ctx, query, bandwidthRollupName, watermark)
+	return err
+}
+
+// ListTouchedHours returns the UTC hours that have raw rows recorded in (from, to].
+func (r *BandwidthRepository) ListTouchedHours(ctx context.Context, from, to time.Time) ([]time.Time, error) {
+	query := `SELECT DISTINCT date_trunc('hour', interval_start, 'UTC') FROM bandwidth_logs
+		WHERE recorded_at > $1 AND recorded_at <= $2`
+	rows, err := r.db.Pool.Query(ctx, query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hours []time.Time
+	for rows.Next() {
+		var h time.Time
+		if err := rows.Scan(&h); err != nil {
+			return nil, fmt.Errorf("scan hour: %w", err)
+		}
+		hours = append(hours, h.UTC())
+	}
+	return hours, nil
+}
+
+// RollupHour recomputes the hourly rollup rows for the UTC hour starting at hour.
+func (r *BandwidthRepository) RollupHour(ctx context.Context, hour time.Time) error {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM bandwidth_hourly WHERE bucket = $1`, hour); err != nil {
+		return fmt.Errorf("clear hourly rollup: %w", err)
+	}
+	query := `INSERT INTO bandwidth_hourly (device_id, connection_id, bucket, bytes_in, bytes_out)
+		SELECT device_id, connection_id, $1, SUM(bytes_in), SUM(bytes_out)
+		FROM bandwidth_logs
+		WHERE interval_start >= $1 AND interval_start < $2
+		GROUP BY device_id, connection_id`
+	if _, err := tx.Exec(ctx, query, hour, hour.Add(time.Hour)); err != nil {
+		return fmt.Errorf("insert hourly rollup: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+// RollupDay recomputes the daily rollup rows for a UTC day from the hourly rollups.
+func (r *BandwidthRepository) RollupDay(ctx context.Context, day time.Time) error {
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM bandwidth_daily WHERE day = $1`, start); err != nil {
+		return fmt.Errorf("clear daily rollup: %w", err)
+	}
+	query := `INSERT INTO bandwidth_daily (device_id, connection_id, day, bytes_in, bytes_out)
+		SELECT device_id, connection_id, $1::date, SUM(bytes_in), SUM(bytes_out)
+		FROM bandwidth_hourly
+		WHERE bucket >= $2 AND bucket < $3
+		GROUP BY device_id, connection_id`
+	if _, err := tx.Exec(ctx, query, start, start, start.AddDate(0, 0, 1)); err != nil {
+		return fmt.Errorf("insert daily rollup: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+// DeleteHourlyBefore prunes hourly rollups older than cutoff.
+func (r *BandwidthRepository) DeleteHourlyBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := r.db.Pool.Exec(ctx, `DELETE FROM bandwidth_hourly WHERE bucket < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// ListPartitions returns the monthly raw partitions attached to bandwidth_logs,
+// keyed by name with the first day of their month.
+func (r *BandwidthRepository) ListPartitions(ctx context.Context) (map[string]time.Time, error) {
+	query := `SELECT c.relname FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		WHERE p.relname = 'bandwidth_logs'`
+	rows, err := r.db.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	partitions := make(map[string]time.Time)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("scan partition: %w", err)
+		}
+		month, err := time.Parse("2006_01", strings.TrimPrefix(name, bandwidthPartitionPrefix))
+		if err != nil {
+			continue // not one of ours
+		}
+		partitions[name] = month
+	}
+	return partitions, nil
+}
+
+// DetachPartition detaches a raw partition from bandwidth_logs, leaving it as
+// a standalone table, and drops it unless keep is set.
+func (r *BandwidthRepository) DetachPartition(ctx context.Context, name string, keep bool) error {
+	if _, err := r.db.Pool.Exec(ctx, `ALTER TABLE bandwidth_logs DETACH PARTITION `+name); err != nil {
+		return fmt.Errorf("detach partition %s: %w", name, err)
+	}
+	if keep {
+		return nil
+	}
+	if _, err := r.db.Pool.Exec(ctx, `DROP TABLE IF EXISTS `+name); err != nil {
+		return fmt.Errorf("drop partition %s: %w", name, err)
+	}
+	return nil
+}
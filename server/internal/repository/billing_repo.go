@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/mobileproxy/server/internal/domain"
+)
+
+type BillingRepository struct {
+	db *DB
+}
+
+func NewBillingRepository(db *DB) *BillingRepository {
+	return &BillingRepository{db: db}
+}
+
+const billingAccountSelectCols = `customer_id, provider, provider_customer_id, subscription_id, plan_id, status,
+		suspended, created_at, updated_at`
+
+// GetAccount returns a customer's billing account, or nil if they never checked out.
+func (r *BillingRepository) GetAccount(ctx context.Context, customerID uuid.UUID) (*domain.BillingAccount, error) {
+	query := `SELECT ` + billingAccountSelectCols + ` FROM billing_accounts WHERE customer_id = $1`
+	a, err := r.scanAccount(r.db.Pool.QueryRow(ctx, query, customerID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scan billing account: %w", err)
+	}
+	return a, nil
+}
+
+// GetAccountBySubscription returns the account for a provider subscription, or nil.
+func (r *BillingRepository) GetAccountBySubscription(ctx context.Context, provider, subscriptionID string) (*domain.BillingAccount, error) {
+	query := `SELECT ` + billingAccountSelectCols + ` FROM billing_accounts
+		WHERE provider = $1 AND subscription_id = $2`
+	a, err := r.scanAccount(r.db.Pool.QueryRow(ctx, query, provider, subscriptionID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scan billing account: %w", err)
+	}
+	return a, nil
+}
+
+// UpsertAccount creates or replaces a customer's billing account.
+func (r *BillingRepository) UpsertAccount(ctx context.Context, a *domain.BillingAccount) error {
+	query := `INSERT INTO billing_accounts (customer_id, provider, provider_customer_id, subscription_id, plan_id,
+			status, suspended)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (customer_id) DO UPDATE SET
+			provider = EXCLUDED.provider,
+			provider_customer_id = EXCLUDED.provider_customer_id,
+			subscription_id = EXCLUDED.subscription_id,
+			plan_id = EXCLUDED.plan_id,
+			status = EXCLUDED.status,
+			suspended = EXCLUDED.suspended,
+			updated_at = NOW()
+		RETURNING created_at, updated_at`
+	return r.db.Pool.QueryRow(ctx, query,
+		a.CustomerID, a.Provider, a.ProviderCustomerID, a.SubscriptionID, a.PlanID, a.Status, a.Suspended,
+	).Scan(&a.CreatedAt, &a.UpdatedAt)
+}
+
+// ClearSuspended hands a customer's active flag back to admins.
+func (r *BillingRepository) ClearSuspended(ctx context.Context, customerID uuid.UUID) error {
+	query := `UPDATE billing_accounts SET suspended = FALSE, updated_at = NOW() WHERE customer_id = $1 AND suspended`
+	_, err := r.db.Pool.Exec(ctx, query, customerID)
+	return err
+}
+
+// RecordEvent marks a webhook event as applied. Returns false if it already was.
+func (r *BillingRepository) RecordEvent(ctx context.Context, provider, eventID, eventType string, customerID *uuid.UUID) (bool, error) {
+	query := `INSERT INTO billing_events (provider, event_id, type, customer_id) VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING`
+	tag, err := r.db.Pool.Exec(ctx, query, provider, eventID, eventType, customerID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// DeleteEvent forgets an event whose handling failed, so the provider's retry is applied.
+func (r *BillingRepository) DeleteEvent(ctx context.Context, provider, eventID string) error {
+	_, err := r.db.Pool.Exec(ctx, `DELETE FROM billing_events WHERE provider = $1 AND event_id = $2`, provider, eventID)
+	return err
+}
+
+func (r *BillingRepository) scanAccount(row interface{ Scan(dest ...interface{}) error }) (*domain.BillingAccount, error) {
+	var a domain.BillingAccount
+	if err := row.Scan(
+		&a.CustomerID, &a.Provider, &a.ProviderCustomerID, &a.SubscriptionID, &a.PlanID, &a.Status,
+		&a.Suspended, &a.CreatedAt, &a.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
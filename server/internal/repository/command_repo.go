@@ -23,6 +23,18 @@ func (r *CommandRepository) Create(ctx context.Context, cmd *domain.DeviceComman
 	return err
 }
 
+func (r *CommandRepository) GetByID(ctx context.Context, id uuid.UUID) (*domain.DeviceCommand, error) {
+	query := `SELECT id, device_id, type, status, payload, result, created_at, executed_at
+		FROM device_commands WHERE id = $1`
+	var cmd domain.DeviceCommand
+	err := r.db.Pool.QueryRow(ctx, query, id).Scan(&cmd.ID, &cmd.DeviceID, &cmd.Type, &cmd.Status,
+		&cmd.Payload, &cmd.Result, &cmd.CreatedAt, &cmd.ExecutedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &cmd, nil
+}
+
 func (r *CommandRepository) GetPending(ctx context.Context, deviceID uuid.UUID) ([]domain.DeviceCommand, error) {
 	query := `SELECT id, device_id, type, status, payload, result, created_at, executed_at
 		FROM device_commands WHERE device_id = $1 AND status = 'pending'
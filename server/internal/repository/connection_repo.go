@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/mobileproxy/server/internal/domain"
@@ -16,18 +17,36 @@ func NewConnectionRepository(db *DB) *ConnectionRepository {
 	return &ConnectionRepository{db: db}
 }
 
-func (r *ConnectionRepository) Create(ctx context.Context, c *domain.ProxyConnection) error {
-	query := `INSERT INTO proxy_connections (id, device_id, customer_id, username, password_hash, password_plain, ip_whitelist, bandwidth_limit, active, proxy_type, base_port, http_port, socks5_port)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`
-	_, err := r.db.Pool.Exec(ctx, query,
+// Create inserts a connection. A non-nil check is first given the customer's
+// connection count under the plan lock, and its error aborts the insert.
+func (r *ConnectionRepository) Create(ctx context.Context, c *domain.ProxyConnection, check func(connections int) error) error {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if check != nil && c.CustomerID != nil {
+		if err := checkPlanCount(ctx, tx, *c.CustomerID, check, customerConnectionsQuery); err != nil {
+			return err
+		}
+	}
+
+	query := `INSERT INTO proxy_connections (id, device_id, customer_id, username, password_hash, password_plain, ip_whitelist, bandwidth_limit, active, proxy_type, base_port, http_port, socks5_port, max_concurrent_conns, max_conns_per_second)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`
+	_, err = tx.Exec(ctx, query,
 		c.ID, c.DeviceID, c.CustomerID, c.Username, c.PasswordHash, c.PasswordPlain,
 		c.IPWhitelist, c.BandwidthLimit, c.Active, c.ProxyType,
-		c.BasePort, c.HTTPPort, c.SOCKS5Port)
-	return err
+		c.BasePort, c.HTTPPort, c.SOCKS5Port, c.MaxConcurrentConns, c.MaxConnsPerSecond)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
 }
 
 const connSelectCols = `id, device_id, customer_id, username, password_hash, password_plain, ip_whitelist,
 		bandwidth_limit, bandwidth_used, active, proxy_type, base_port, http_port, socks5_port,
+		max_concurrent_conns, max_conns_per_second, rejected_concurrent, rejected_rate,
 		expires_at, created_at, updated_at`
 
 func (r *ConnectionRepository) GetByID(ctx context.Context, id uuid.UUID) (*domain.ProxyConnection, error) {
@@ -69,9 +88,29 @@ func (r *ConnectionRepository) UpdatePasswordHash(ctx context.Context, id uuid.U
 	return err
 }
 
-func (r *ConnectionRepository) UpdateBandwidthUsed(ctx context.Context, username string, used int64) error {
-	query := `UPDATE proxy_connections SET bandwidth_used = $2, updated_at = NOW() WHERE username = $1`
-	_, err := r.db.Pool.Exec(ctx, query, username, used)
+func (r *ConnectionRepository) UpdateLimits(ctx context.Context, id uuid.UUID, maxConcurrent, perSecond int) error {
+	query := `UPDATE proxy_connections SET max_concurrent_conns = $2, max_conns_per_second = $3, updated_at = NOW() WHERE id = $1`
+	_, err := r.db.Pool.Exec(ctx, query, id, maxConcurrent, perSecond)
+	return err
+}
+
+func (r *ConnectionRepository) UpdateBandwidthLimit(ctx context.Context, id uuid.UUID, limit int64) error {
+	query := `UPDATE proxy_connections SET bandwidth_limit = $2, updated_at = NOW() WHERE id = $1`
+	_, err := r.db.Pool.Exec(ctx, query, id, limit)
+	return err
+}
+
+// ListLimited returns active connections that have any session limit set.
+func (r *ConnectionRepository) ListLimited(ctx context.Context) ([]domain.ProxyConnection, error) {
+	query := `SELECT ` + connSelectCols + ` FROM proxy_connections
+		WHERE active = TRUE AND (max_concurrent_conns > 0 OR max_conns_per_second > 0)`
+	return r.scanConnections(ctx, query)
+}
+
+// AddRejections increments the relay-reported rejection counters.
+func (r *ConnectionRepository) AddRejections(ctx context.Context, id uuid.UUID, concurrent, rate int64) error {
+	query := `UPDATE proxy_connections SET rejected_concurrent = rejected_concurrent + $2, rejected_rate = rejected_rate + $3 WHERE id = $1`
+	_, err := r.db.Pool.Exec(ctx, query, id, concurrent, rate)
 	return err
 }
 
@@ -107,17 +146,46 @@ func (r *ConnectionRepository) ReplaceAllByDeviceID(ctx context.Context, deviceI
 	}
 	defer tx.Rollback(ctx)
 
-	if _, err := tx.Exec(ctx, `DELETE FROM proxy_connections WHERE device_id = $1`, deviceID); err != nil {
+	// Remember which expiries were already announced so a resync doesn't
+	// send connection.expired again.
+	rows, err := tx.Query(ctx, `DELETE FROM proxy_connections WHERE device_id = $1
+		RETURNING id, expires_at, expiry_notified_at`, deviceID)
+	if err != nil {
+		return fmt.Errorf("delete connections: %w", err)
+	}
+	type expiryNotice struct {
+		expiresAt  *time.Time
+		notifiedAt *time.Time
+	}
+	notified := map[uuid.UUID]expiryNotice{}
+	for rows.Next() {
+		var id uuid.UUID
+		var n expiryNotice
+		if err := rows.Scan(&id, &n.expiresAt, &n.notifiedAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("delete connections: %w", err)
+		}
+		if n.notifiedAt != nil {
+			notified[id] = n
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
 		return fmt.Errorf("delete connections: %w", err)
 	}
 
 	for _, c := range conns {
-		query := `INSERT INTO proxy_connections (id, device_id, customer_id, username, password_hash, password_plain, ip_whitelist, bandwidth_limit, bandwidth_used, active, proxy_type, base_port, http_port, socks5_port, expires_at, created_at, updated_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)`
+		var notifiedAt *time.Time
+		if n, ok := notified[c.ID]; ok && c.ExpiresAt != nil && n.expiresAt != nil && c.ExpiresAt.Equal(*n.expiresAt) {
+			notifiedAt = n.notifiedAt
+		}
+		query := `INSERT INTO proxy_connections (id, device_id, customer_id, username, password_hash, password_plain, ip_whitelist, bandwidth_limit, bandwidth_used, active, proxy_type, base_port, http_port, socks5_port, max_concurrent_conns, max_conns_per_second, expires_at, created_at, updated_at, expiry_notified_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)`
 		if _, err := tx.Exec(ctx, query,
 			c.ID, c.DeviceID, c.CustomerID, c.Username, c.PasswordHash, c.PasswordPlain,
 			c.IPWhitelist, c.BandwidthLimit, c.BandwidthUsed, c.Active, c.ProxyType,
-			c.BasePort, c.HTTPPort, c.SOCKS5Port, c.ExpiresAt, c.CreatedAt, c.UpdatedAt); err != nil {
+			c.BasePort, c.HTTPPort, c.SOCKS5Port, c.MaxConcurrentConns, c.MaxConnsPerSecond,
+			c.ExpiresAt, c.CreatedAt, c.UpdatedAt, notifiedAt); err != nil {
 			return fmt.Errorf("insert connection %s: %w", c.ID, err)
 		}
 	}
@@ -131,6 +199,7 @@ func (r *ConnectionRepository) scanConnection(row interface{ Scan(dest ...interf
 		&c.ID, &c.DeviceID, &c.CustomerID, &c.Username, &c.PasswordHash, &c.PasswordPlain,
 		&c.IPWhitelist, &c.BandwidthLimit, &c.BandwidthUsed, &c.Active, &c.ProxyType,
 		&c.BasePort, &c.HTTPPort, &c.SOCKS5Port,
+		&c.MaxConcurrentConns, &c.MaxConnsPerSecond, &c.RejectedConcurrent, &c.RejectedRate,
 		&c.ExpiresAt, &c.CreatedAt, &c.UpdatedAt)
 	if err != nil {
 		return nil, fmt.Errorf("scan connection: %w", err)
@@ -187,3 +256,50 @@ func (r *ConnectionRepository) ListByDeviceForCustomer(ctx context.Context, devi
 		ORDER BY created_at DESC`
 	return r.scanConnections(ctx, query, deviceID, customerID)
 }
+
+// ListByReseller returns connections on devices allocated to the reseller.
+func (r *ConnectionRepository) ListByReseller(ctx context.Context, resellerID uuid.UUID) ([]domain.ProxyConnection, error) {
+	query := `SELECT ` + connSelectCols + ` FROM proxy_connections pc
+		WHERE EXISTS (SELECT 1 FROM devices d WHERE d.id = pc.device_id AND d.reseller_id = $1)
+		ORDER BY created_at DESC`
+	return r.scanConnections(ctx, query, resellerID)
+}
+
+// GetByIDForReseller returns a connection by ID only if its device is allocated to the reseller.
+func (r *ConnectionRepository) GetByIDForReseller(ctx context.Context, connID uuid.UUID, resellerID uuid.UUID) (*domain.ProxyConnection, error) {
+	query := `SELECT ` + connSelectCols + ` FROM proxy_connections pc
+		WHERE pc.id = $1 AND EXISTS (
+			SELECT 1 FROM devices d WHERE d.id = pc.device_id AND d.reseller_id = $2
+		)`
+	return r.scanConnection(r.db.Pool.QueryRow(ctx, query, connID, resellerID))
+}
+
+// ListByDeviceForReseller returns connections for a device, but only if it is allocated to the reseller.
+func (r *ConnectionRepository) ListByDeviceForReseller(ctx context.Context, deviceID uuid.UUID, resellerID uuid.UUID) ([]domain.ProxyConnection, error) {
+	query := `SELECT ` + connSelectCols + ` FROM proxy_connections pc
+		WHERE pc.device_id = $1 AND EXISTS (
+			SELECT 1 FROM devices d WHERE d.id = $1 AND d.reseller_id = $2
+		)
+		ORDER BY created_at DESC`
+	return r.scanConnections(ctx, query, deviceID, resellerID)
+}
+
+// ListExpiredUnnotified returns connections past their expiry that no
+// connection.expired event has been sent for yet.
+func (r *ConnectionRepository) ListExpiredUnnotified(ctx context.Context) ([]domain.ProxyConnection, error) {
+	query := `SELECT ` + connSelectCols + ` FROM proxy_connections
+		WHERE expires_at IS NOT NULL AND expires_at <= NOW() AND expiry_notified_at IS NULL
+		ORDER BY expires_at ASC`
+	return r.scanConnections(ctx, query)
+}
+
+// MarkExpiryNotified records that the expiry of a connection was announced.
+// Reports false if another worker already did.
+func (r *ConnectionRepository) MarkExpiryNotified(ctx context.Context, id uuid.UUID) (bool, error) {
+	tag, err := r.db.Pool.Exec(ctx, `UPDATE proxy_connections SET expiry_notified_at = NOW()
+		WHERE id = $1 AND expiry_notified_at IS NULL`, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
@@ -17,17 +17,17 @@ func NewCustomerRepository(db *DB) *CustomerRepository {
 }
 
 func (r *CustomerRepository) Create(ctx context.Context, c *domain.Customer) error {
-	query := `INSERT INTO customers (id, name, email) VALUES ($1, $2, $3)`
-	_, err := r.db.Pool.Exec(ctx, query, c.ID, c.Name, c.Email)
+	query := `INSERT INTO customers (id, name, email, reseller_id) VALUES ($1, $2, $3, $4)`
+	_, err := r.db.Pool.Exec(ctx, query, c.ID, c.Name, c.Email, c.ResellerID)
 	return err
 }
 
 func (r *CustomerRepository) GetByID(ctx context.Context, id uuid.UUID) (*domain.Customer, error) {
-	query := `SELECT id, name, email, active, password_hash, email_verified, google_id, google_email, created_at, updated_at FROM customers WHERE id = $1`
+	query := `SELECT id, name, email, active, password_hash, email_verified, google_id, google_email, plan_id, reseller_id, created_at, updated_at FROM customers WHERE id = $1`
 	var c domain.Customer
 	err := r.db.Pool.QueryRow(ctx, query, id).Scan(
 		&c.ID, &c.Name, &c.Email, &c.Active,
-		&c.PasswordHash, &c.EmailVerified, &c.GoogleID, &c.GoogleEmail,
+		&c.PasswordHash, &c.EmailVerified, &c.GoogleID, &c.GoogleEmail, &c.PlanID, &c.ResellerID,
 		&c.CreatedAt, &c.UpdatedAt)
 	if err != nil {
 		return nil, fmt.Errorf("get customer: %w", err)
@@ -36,7 +36,7 @@ func (r *CustomerRepository) GetByID(ctx context.Context, id uuid.UUID) (*domain
 }
 
 func (r *CustomerRepository) List(ctx context.Context) ([]domain.Customer, error) {
-	query := `SELECT id, name, email, active, password_hash, email_verified, google_id, google_email, created_at, updated_at FROM customers ORDER BY name ASC`
+	query := `SELECT id, name, email, active, password_hash, email_verified, google_id, google_email, plan_id, reseller_id, created_at, updated_at FROM customers ORDER BY name ASC`
 	rows, err := r.db.Pool.Query(ctx, query)
 	if err != nil {
 		return nil, err
@@ -48,7 +48,7 @@ func (r *CustomerRepository) List(ctx context.Context) ([]domain.Customer, error
 		var c domain.Customer
 		err := rows.Scan(
 			&c.ID, &c.Name, &c.Email, &c.Active,
-			&c.PasswordHash, &c.EmailVerified, &c.GoogleID, &c.GoogleEmail,
+			&c.PasswordHash, &c.EmailVerified, &c.GoogleID, &c.GoogleEmail, &c.PlanID, &c.ResellerID,
 			&c.CreatedAt, &c.UpdatedAt)
 		if err != nil {
 			return nil, fmt.Errorf("scan customer: %w", err)
@@ -64,13 +64,20 @@ func (r *CustomerRepository) Update(ctx context.Context, c *domain.Customer) err
 	return err
 }
 
+// UpdatePlan assigns a plan to a customer (nil removes it).
+func (r *CustomerRepository) UpdatePlan(ctx context.Context, id uuid.UUID, planID *uuid.UUID) error {
+	query := `UPDATE customers SET plan_id = $2, updated_at = NOW() WHERE id = $1`
+	_, err := r.db.Pool.Exec(ctx, query, id, planID)
+	return err
+}
+
 // GetByEmail retrieves a customer by email address (case-insensitive).
 func (r *CustomerRepository) GetByEmail(ctx context.Context, email string) (*domain.Customer, error) {
-	query := `SELECT id, name, email, active, password_hash, email_verified, google_id, google_email, created_at, updated_at FROM customers WHERE LOWER(email) = LOWER($1)`
+	query := `SELECT id, name, email, active, password_hash, email_verified, google_id, google_email, plan_id, reseller_id, created_at, updated_at FROM customers WHERE LOWER(email) = LOWER($1)`
 	var c domain.Customer
 	err := r.db.Pool.QueryRow(ctx, query, email).Scan(
 		&c.ID, &c.Name, &c.Email, &c.Active,
-		&c.PasswordHash, &c.EmailVerified, &c.GoogleID, &c.GoogleEmail,
+		&c.PasswordHash, &c.EmailVerified, &c.GoogleID, &c.GoogleEmail, &c.PlanID, &c.ResellerID,
 		&c.CreatedAt, &c.UpdatedAt)
 	if err != nil {
 		return nil, fmt.Errorf("get customer by email: %w", err)
@@ -80,11 +87,11 @@ func (r *CustomerRepository) GetByEmail(ctx context.Context, email string) (*dom
 
 // GetByGoogleID retrieves a customer by their Google OAuth account ID.
 func (r *CustomerRepository) GetByGoogleID(ctx context.Context, googleID string) (*domain.Customer, error) {
-	query := `SELECT id, name, email, active, password_hash, email_verified, google_id, google_email, created_at, updated_at FROM customers WHERE google_id = $1`
+	query := `SELECT id, name, email, active, password_hash, email_verified, google_id, google_email, plan_id, reseller_id, created_at, updated_at FROM customers WHERE google_id = $1`
 	var c domain.Customer
 	err := r.db.Pool.QueryRow(ctx, query, googleID).Scan(
 		&c.ID, &c.Name, &c.Email, &c.Active,
-		&c.PasswordHash, &c.EmailVerified, &c.GoogleID, &c.GoogleEmail,
+		&c.PasswordHash, &c.EmailVerified, &c.GoogleID, &c.GoogleEmail, &c.PlanID, &c.ResellerID,
 		&c.CreatedAt, &c.UpdatedAt)
 	if err != nil {
 		return nil, fmt.Errorf("get customer by google id: %w", err)
@@ -156,3 +163,48 @@ func (r *CustomerRepository) GetStats(ctx context.Context, id uuid.UUID) (device
 
 	return deviceCount, shareCount, totalBandwidth, nil
 }
+
+// SetResellerID hands a customer to a reseller, or takes it back with nil.
+func (r *CustomerRepository) SetResellerID(ctx context.Context, id uuid.UUID, resellerID *uuid.UUID) error {
+	query := `UPDATE customers SET reseller_id = $2, updated_at = NOW() WHERE id = $1`
+	_, err := r.db.Pool.Exec(ctx, query, id, resellerID)
+	return err
+}
+
+// ListByReseller returns the end customers of a reseller, ordered by name.
+func (r *CustomerRepository) ListByReseller(ctx context.Context, resellerID uuid.UUID) ([]domain.Customer, error) {
+	query := `SELECT id, name, email, active, password_hash, email_verified, google_id, google_email, plan_id, reseller_id, created_at, updated_at FROM customers WHERE reseller_id = $1 ORDER BY name ASC`
+	rows, err := r.db.Pool.Query(ctx, query, resellerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var customers []domain.Customer
+	for rows.Next() {
+		var c domain.Customer
+		err := rows.Scan(
+			&c.ID, &c.Name, &c.Email, &c.Active,
+			&c.PasswordHash, &c.EmailVerified, &c.GoogleID, &c.GoogleEmail, &c.PlanID, &c.ResellerID,
+			&c.CreatedAt, &c.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("scan customer: %w", err)
+		}
+		customers = append(customers, c)
+	}
+	return customers, nil
+}
+
+// GetByIDForReseller gets a customer by ID only if it belongs to the reseller.
+func (r *CustomerRepository) GetByIDForReseller(ctx context.Context, id uuid.UUID, resellerID uuid.UUID) (*domain.Customer, error) {
+	query := `SELECT id, name, email, active, password_hash, email_verified, google_id, google_email, plan_id, reseller_id, created_at, updated_at FROM customers WHERE id = $1 AND reseller_id = $2`
+	var c domain.Customer
+	err := r.db.Pool.QueryRow(ctx, query, id, resellerID).Scan(
+		&c.ID, &c.Name, &c.Email, &c.Active,
+		&c.PasswordHash, &c.EmailVerified, &c.GoogleID, &c.GoogleEmail, &c.PlanID, &c.ResellerID,
+		&c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("get customer: %w", err)
+	}
+	return &c, nil
+}
@@ -36,7 +36,7 @@ const deviceSelectColumns = `d.id, d.name, d.description, d.android_id, d.status
 		d.base_port, d.http_port, d.socks5_port, d.udp_relay_port, d.ovpn_port,
 		d.last_heartbeat, d.app_version, d.device_model, d.android_version,
 		d.relay_server_id, COALESCE(rs.ip, '') as relay_server_ip,
-		d.auto_rotate_minutes, d.customer_id,
+		d.auto_rotate_minutes, d.customer_id, d.reseller_id,
 		d.created_at, d.updated_at`
 
 const deviceFromJoin = `FROM devices d LEFT JOIN relay_servers rs ON d.relay_server_id = rs.id`
@@ -175,7 +175,7 @@ func (r *DeviceRepository) scanDevice(row pgx.Row) (*domain.Device, error) {
 		&d.BasePort, &d.HTTPPort, &d.SOCKS5Port, &d.UDPRelayPort, &d.OVPNPort,
 		&d.LastHeartbeat, &d.AppVersion, &d.DeviceModel, &d.AndroidVersion,
 		&d.RelayServerID, &d.RelayServerIP,
-		&d.AutoRotateMinutes, &d.CustomerID,
+		&d.AutoRotateMinutes, &d.CustomerID, &d.ResellerID,
 		&d.CreatedAt, &d.UpdatedAt,
 	)
 	if err != nil {
@@ -193,7 +193,7 @@ func (r *DeviceRepository) scanDeviceRow(rows pgx.Rows) (*domain.Device, error)
 		&d.BasePort, &d.HTTPPort, &d.SOCKS5Port, &d.UDPRelayPort, &d.OVPNPort,
 		&d.LastHeartbeat, &d.AppVersion, &d.DeviceModel, &d.AndroidVersion,
 		&d.RelayServerID, &d.RelayServerIP,
-		&d.AutoRotateMinutes, &d.CustomerID,
+		&d.AutoRotateMinutes, &d.CustomerID, &d.ResellerID,
 		&d.CreatedAt, &d.UpdatedAt,
 	)
 	if err != nil {
@@ -276,3 +276,36 @@ func (r *DeviceRepository) GetByIDForCustomer(ctx context.Context, deviceID uuid
 		))`
 	return r.scanDevice(r.db.Pool.QueryRow(ctx, query, deviceID, customerID))
 }
+
+// SetResellerID allocates a device to a reseller, or takes it back with nil.
+func (r *DeviceRepository) SetResellerID(ctx context.Context, id uuid.UUID, resellerID *uuid.UUID) error {
+	query := `UPDATE devices SET reseller_id = $2, updated_at = NOW() WHERE id = $1`
+	_, err := r.db.Pool.Exec(ctx, query, id, resellerID)
+	return err
+}
+
+// ListByReseller returns the devices allocated to a reseller, ordered by name.
+func (r *DeviceRepository) ListByReseller(ctx context.Context, resellerID uuid.UUID) ([]domain.Device, error) {
+	query := `SELECT ` + deviceSelectColumns + ` ` + deviceFromJoin + ` WHERE d.reseller_id = $1 ORDER BY d.name ASC`
+	rows, err := r.db.Pool.Query(ctx, query, resellerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var devices []domain.Device
+	for rows.Next() {
+		d, err := r.scanDeviceRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, *d)
+	}
+	return devices, nil
+}
+
+// GetByIDForReseller gets a device by ID only if it is allocated to the reseller.
+func (r *DeviceRepository) GetByIDForReseller(ctx context.Context, deviceID uuid.UUID, resellerID uuid.UUID) (*domain.Device, error) {
+	query := `SELECT ` + deviceSelectColumns + ` ` + deviceFromJoin + ` WHERE d.id = $1 AND d.reseller_id = $2`
+	return r.scanDevice(r.db.Pool.QueryRow(ctx, query, deviceID, resellerID))
+}
@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/mobileproxy/server/internal/domain"
+)
+
+type DeviceShareInviteRepository struct {
+	db *DB
+}
+
+func NewDeviceShareInviteRepository(db *DB) *DeviceShareInviteRepository {
+	return &DeviceShareInviteRepository{db: db}
+}
+
+const shareInviteSelectCols = `id, device_id, owner_id, invited_by, email, token_hash,
+	can_rename, can_manage_ports, can_download_configs, can_rotate_ip, expires_at, created_at`
+
+// Upsert stores an invitation. Inviting the same email to the same device
+// again replaces the earlier invitation, so only the newest link works.
+func (r *DeviceShareInviteRepository) Upsert(ctx context.Context, inv *domain.DeviceShareInvite) error {
+	query := `INSERT INTO device_share_invites (id, device_id, owner_id, invited_by, email, token_hash,
+			can_rename, can_manage_ports, can_download_configs, can_rotate_ip, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (device_id, email) DO UPDATE SET
+			id = EXCLUDED.id, owner_id = EXCLUDED.owner_id, invited_by = EXCLUDED.invited_by,
+			token_hash = EXCLUDED.token_hash,
+			can_rename = EXCLUDED.can_rename, can_manage_ports = EXCLUDED.can_manage_ports,
+			can_download_configs = EXCLUDED.can_download_configs, can_rotate_ip = EXCLUDED.can_rotate_ip,
+			expires_at = EXCLUDED.expires_at, created_at = NOW()
+		RETURNING created_at`
+	return r.db.Pool.QueryRow(ctx, query,
+		inv.ID, inv.DeviceID, inv.OwnerID, inv.InvitedBy, inv.Email, inv.TokenHash,
+		inv.CanRename, inv.CanManagePorts, inv.CanDownloadConfigs, inv.CanRotateIP, inv.ExpiresAt,
+	).Scan(&inv.CreatedAt)
+}
+
+// GetByID returns an invitation, or nil if there is none.
+func (r *DeviceShareInviteRepository) GetByID(ctx context.Context, id uuid.UUID) (*domain.DeviceShareInvite, error) {
+	query := `SELECT ` + shareInviteSelectCols + ` FROM device_share_invites WHERE id = $1`
+	return scanShareInvite(r.db.Pool.QueryRow(ctx, query, id))
+}
+
+// GetByTokenHash returns the invitation a link points to, or nil. Expired
+// invitations are returned too so callers can tell them apart from unknown links.
+func (r *DeviceShareInviteRepository) GetByTokenHash(ctx context.Context, tokenHash string) (*domain.DeviceShareInvite, error) {
+	query := `SELECT ` + shareInviteSelectCols + ` FROM device_share_invites WHERE token_hash = $1`
+	return scanShareInvite(r.db.Pool.QueryRow(ctx, query, tokenHash))
+}
+
+// ListByOwner returns an organization's pending invitations, optionally for
+// one device only.
+func (r *DeviceShareInviteRepository) ListByOwner(ctx context.Context, ownerID uuid.UUID, deviceID *uuid.UUID) ([]domain.DeviceShareInvite, error) {
+	query := `SELECT ` + shareInviteSelectCols + ` FROM device_share_invites
+		WHERE owner_id = $1 AND ($2::uuid IS NULL OR device_id = $2)
+		ORDER BY created_at DESC`
+	rows, err := r.db.Pool.Query(ctx, query, ownerID, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("list share invites: %w", err)
+	}
+	defer rows.Close()
+
+	invites := []domain.DeviceShareInvite{}
+	for rows.Next() {
+		inv, err := scanShareInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, *inv)
+	}
+	return invites, rows.Err()
+}
+
+// Accept creates the share an invitation describes and consumes the
+// invitation in one transaction. An existing share between the same device
+// and organization is updated to the invited permissions. check is the
+// recipient's device-slot check, as for DeviceShareRepository.Create.
+func (r *DeviceShareInviteRepository) Accept(ctx context.Context, inviteID uuid.UUID, share *domain.DeviceShare, check func(otherDevices int) error) error {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `DELETE FROM device_share_invites WHERE id = $1`, inviteID)
+	if err != nil {
+		return fmt.Errorf("consume share invite: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("invitation already used")
+	}
+	if err := checkShareSlot(ctx, tx, share, check); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO device_shares (id, device_id, owner_id, shared_with, can_rename, can_manage_ports, can_download_configs, can_rotate_ip)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (device_id, shared_with) DO UPDATE SET
+			can_rename = EXCLUDED.can_rename, can_manage_ports = EXCLUDED.can_manage_ports,
+			can_download_configs = EXCLUDED.can_download_configs, can_rotate_ip = EXCLUDED.can_rotate_ip,
+			updated_at = NOW()
+		RETURNING id, created_at, updated_at`
+	err = tx.QueryRow(ctx, query,
+		share.ID, share.DeviceID, share.OwnerID, share.SharedWith,
+		share.CanRename, share.CanManagePorts, share.CanDownloadConfigs, share.CanRotateIP,
+	).Scan(&share.ID, &share.CreatedAt, &share.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("create device share: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *DeviceShareInviteRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	_, err := r.db.Pool.Exec(ctx, `DELETE FROM device_share_invites WHERE id = $1`, id)
+	return err
+}
+
+func scanShareInvite(row pgx.Row) (*domain.DeviceShareInvite, error) {
+	var inv domain.DeviceShareInvite
+	err := row.Scan(
+		&inv.ID, &inv.DeviceID, &inv.OwnerID, &inv.InvitedBy, &inv.Email, &inv.TokenHash,
+		&inv.CanRename, &inv.CanManagePorts, &inv.CanDownloadConfigs, &inv.CanRotateIP,
+		&inv.ExpiresAt, &inv.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scan share invite: %w", err)
+	}
+	return &inv, nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/mobileproxy/server/internal/domain"
 )
 
@@ -18,13 +19,40 @@ func NewDeviceShareRepository(db *DB) *DeviceShareRepository {
 
 const shareSelectCols = `id, device_id, owner_id, shared_with, can_rename, can_manage_ports, can_download_configs, can_rotate_ip, created_at, updated_at`
 
-func (r *DeviceShareRepository) Create(ctx context.Context, share *domain.DeviceShare) error {
+// Create inserts a share. A non-nil check is first given the recipient's
+// device count (not counting this device) under the plan lock, and its error
+// aborts the insert.
+func (r *DeviceShareRepository) Create(ctx context.Context, share *domain.DeviceShare, check func(otherDevices int) error) error {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := checkShareSlot(ctx, tx, share, check); err != nil {
+		return err
+	}
+
 	query := `INSERT INTO device_shares (id, device_id, owner_id, shared_with, can_rename, can_manage_ports, can_download_configs, can_rotate_ip)
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
-	_, err := r.db.Pool.Exec(ctx, query,
+	_, err = tx.Exec(ctx, query,
 		share.ID, share.DeviceID, share.OwnerID, share.SharedWith,
 		share.CanRename, share.CanManagePorts, share.CanDownloadConfigs, share.CanRotateIP)
-	return err
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// checkShareSlot runs a share's device-slot check for the recipient. The
+// shared device itself is left out of the count, so re-sharing a device the
+// recipient already holds never needs a new slot.
+func checkShareSlot(ctx context.Context, tx pgx.Tx, share *domain.DeviceShare, check func(otherDevices int) error) error {
+	if check == nil {
+		return nil
+	}
+	query := `SELECT COUNT(*) FROM ` + customerDevicesFrom + ` WHERE d.id <> $2`
+	return checkPlanCount(ctx, tx, share.SharedWith, check, query, share.DeviceID)
 }
 
 func (r *DeviceShareRepository) GetByID(ctx context.Context, id uuid.UUID) (*domain.DeviceShare, error) {
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/mobileproxy/server/internal/domain"
+)
+
+type MaintenanceRepository struct {
+	db *DB
+}
+
+func NewMaintenanceRepository(db *DB) *MaintenanceRepository {
+	return &MaintenanceRepository{db: db}
+}
+
+const maintenanceSelectCols = `id, device_id, starts_at, ends_at, reason, created_by, created_at`
+
+func (r *MaintenanceRepository) Create(ctx context.Context, w *domain.MaintenanceWindow) error {
+	query := `INSERT INTO maintenance_windows (id, device_id, starts_at, ends_at, reason, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING created_at`
+	return r.db.Pool.QueryRow(ctx, query, w.ID, w.DeviceID, w.StartsAt, w.EndsAt, w.Reason, w.CreatedBy).Scan(&w.CreatedAt)
+}
+
+// GetByID returns a window, or nil if there is none.
+func (r *MaintenanceRepository) GetByID(ctx context.Context, id uuid.UUID) (*domain.MaintenanceWindow, error) {
+	query := `SELECT ` + maintenanceSelectCols + ` FROM maintenance_windows WHERE id = $1`
+	var w domain.MaintenanceWindow
+	err := r.db.Pool.QueryRow(ctx, query, id).Scan(&w.ID, &w.DeviceID, &w.StartsAt, &w.EndsAt, &w.Reason, &w.CreatedBy, &w.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get maintenance window: %w", err)
+	}
+	return &w, nil
+}
+
+// List returns the newest windows first.
+func (r *MaintenanceRepository) List(ctx context.Context, limit int) ([]domain.MaintenanceWindow, error) {
+	query := `SELECT ` + maintenanceSelectCols + ` FROM maintenance_windows ORDER BY starts_at DESC LIMIT $1`
+	rows, err := r.db.Pool.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	windows := []domain.MaintenanceWindow{}
+	for rows.Next() {
+		var w domain.MaintenanceWindow
+		if err := rows.Scan(&w.ID, &w.DeviceID, &w.StartsAt, &w.EndsAt, &w.Reason, &w.CreatedBy, &w.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan maintenance window: %w", err)
+		}
+		windows = append(windows, w)
+	}
+	return windows, rows.Err()
+}
+
+// ListOverlapping returns the windows overlapping [from, to), optionally only
+// those covering one device (including fleet-wide windows), oldest first.
+func (r *MaintenanceRepository) ListOverlapping(ctx context.Context, from, to time.Time, deviceID *uuid.UUID) ([]domain.MaintenanceWindow, error) {
+	query := `SELECT ` + maintenanceSelectCols + ` FROM maintenance_windows
+		WHERE starts_at < $2 AND ends_at > $1
+			AND ($3::uuid IS NULL OR device_id IS NULL OR device_id = $3)
+		ORDER BY starts_at ASC`
+	rows, err := r.db.Pool.Query(ctx, query, from, to, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	windows := []domain.MaintenanceWindow{}
+	for rows.Next() {
+		var w domain.MaintenanceWindow
+		if err := rows.Scan(&w.ID, &w.DeviceID, &w.StartsAt, &w.EndsAt, &w.Reason, &w.CreatedBy, &w.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan maintenance window: %w", err)
+		}
+		windows = append(windows, w)
+	}
+	return windows, rows.Err()
+}
+
+func (r *MaintenanceRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	_, err := r.db.Pool.Exec(ctx, `DELETE FROM maintenance_windows WHERE id = $1`, id)
+	return err
+}
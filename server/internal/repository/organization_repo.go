@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/mobileproxy/server/internal/domain"
+)
+
+type OrganizationRepository struct {
+	db *DB
+}
+
+func NewOrganizationRepository(db *DB) *OrganizationRepository {
+	return &OrganizationRepository{db: db}
+}
+
+const organizationMemberSelectCols = `m.organization_id, m.customer_id, m.role, c.email, c.name, c.active, m.created_at`
+
+func (r *OrganizationRepository) GetByID(ctx context.Context, id uuid.UUID) (*domain.Organization, error) {
+	query := `SELECT id, name, created_at, updated_at FROM organizations WHERE id = $1`
+	var o domain.Organization
+	if err := r.db.Pool.QueryRow(ctx, query, id).Scan(&o.ID, &o.Name, &o.CreatedAt, &o.UpdatedAt); err != nil {
+		return nil, fmt.Errorf("get organization: %w", err)
+	}
+	return &o, nil
+}
+
+// EnsurePersonal creates the organization of a customer account that has none
+// yet, with the customer as its owner. A no-op for existing organizations.
+func (r *OrganizationRepository) EnsurePersonal(ctx context.Context, customer *domain.Customer) error {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	name := customer.Name
+	if name == "" {
+		name = customer.Email
+	}
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO organizations (id, name) VALUES ($1, $2) ON CONFLICT (id) DO NOTHING`,
+		customer.ID, name); err != nil {
+		return fmt.Errorf("create organization: %w", err)
+	}
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO organization_members (organization_id, customer_id, role) VALUES ($1, $1, $2) ON CONFLICT DO NOTHING`,
+		customer.ID, domain.OrgRoleOwner); err != nil {
+		return fmt.Errorf("add owner: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *OrganizationRepository) UpdateName(ctx context.Context, id uuid.UUID, name string) error {
+	_, err := r.db.Pool.Exec(ctx, `UPDATE organizations SET name = $2, updated_at = NOW() WHERE id = $1`, id, name)
+	return err
+}
+
+// GetMembership returns the membership of a customer login, or nil if the
+// login belongs to no organization.
+func (r *OrganizationRepository) GetMembership(ctx context.Context, customerID uuid.UUID) (*domain.OrganizationMember, error) {
+	query := `SELECT ` + organizationMemberSelectCols + `
+		FROM organization_members m JOIN customers c ON c.id = m.customer_id
+		WHERE m.customer_id = $1`
+	m, err := scanOrganizationMember(r.db.Pool.QueryRow(ctx, query, customerID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return m, err
+}
+
+func (r *OrganizationRepository) ListMembers(ctx context.Context, orgID uuid.UUID) ([]domain.OrganizationMember, error) {
+	query := `SELECT ` + organizationMemberSelectCols + `
+		FROM organization_members m JOIN customers c ON c.id = m.customer_id
+		WHERE m.organization_id = $1
+		ORDER BY m.created_at ASC`
+	rows, err := r.db.Pool.Query(ctx, query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []domain.OrganizationMember
+	for rows.Next() {
+		m, err := scanOrganizationMember(rows)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, *m)
+	}
+	return members, nil
+}
+
+// CreateMember inserts a new customer login and its membership in one transaction.
+func (r *OrganizationRepository) CreateMember(ctx context.Context, orgID uuid.UUID, customer *domain.Customer, role string) error {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO customers (id, name, email, active, email_verified) VALUES ($1, $2, $3, $4, $5)`,
+		customer.ID, customer.Name, customer.Email, customer.Active, customer.EmailVerified); err != nil {
+		return fmt.Errorf("create customer: %w", err)
+	}
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO organization_members (organization_id, customer_id, role) VALUES ($1, $2, $3)`,
+		orgID, customer.ID, role); err != nil {
+		return fmt.Errorf("add member: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *OrganizationRepository) UpdateRole(ctx context.Context, orgID, customerID uuid.UUID, role string) error {
+	_, err := r.db.Pool.Exec(ctx,
+		`UPDATE organization_members SET role = $3 WHERE organization_id = $1 AND customer_id = $2`,
+		orgID, customerID, role)
+	return err
+}
+
+func (r *OrganizationRepository) DeleteMember(ctx context.Context, orgID, customerID uuid.UUID) error {
+	_, err := r.db.Pool.Exec(ctx,
+		`DELETE FROM organization_members WHERE organization_id = $1 AND customer_id = $2`,
+		orgID, customerID)
+	return err
+}
+
+// CountActiveOwners returns how many enabled logins own the organization.
+func (r *OrganizationRepository) CountActiveOwners(ctx context.Context, orgID uuid.UUID) (int, error) {
+	var n int
+	err := r.db.Pool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM organization_members m JOIN customers c ON c.id = m.customer_id
+		WHERE m.organization_id = $1 AND m.role = $2 AND c.active`,
+		orgID, domain.OrgRoleOwner).Scan(&n)
+	return n, err
+}
+
+func scanOrganizationMember(row interface{ Scan(dest ...interface{}) error }) (*domain.OrganizationMember, error) {
+	var m domain.OrganizationMember
+	if err := row.Scan(&m.OrganizationID, &m.CustomerID, &m.Role, &m.Email, &m.Name, &m.Active, &m.CreatedAt); err != nil {
+		return nil, fmt.Errorf("scan organization member: %w", err)
+	}
+	return &m, nil
+}
@@ -18,20 +18,20 @@ func NewPairingCodeRepository(db *DB) *PairingCodeRepository {
 }
 
 func (r *PairingCodeRepository) Create(ctx context.Context, pc *domain.PairingCode) error {
-	query := `INSERT INTO pairing_codes (id, code, device_auth_token, expires_at, created_by, relay_server_id, reassign_device_id, customer_id)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
-	_, err := r.db.Pool.Exec(ctx, query, pc.ID, pc.Code, pc.DeviceAuthToken, pc.ExpiresAt, pc.CreatedBy, pc.RelayServerID, pc.ReassignDeviceID, pc.CustomerID)
+	query := `INSERT INTO pairing_codes (id, code, device_auth_token, expires_at, created_by, relay_server_id, reassign_device_id, customer_id, reseller_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err := r.db.Pool.Exec(ctx, query, pc.ID, pc.Code, pc.DeviceAuthToken, pc.ExpiresAt, pc.CreatedBy, pc.RelayServerID, pc.ReassignDeviceID, pc.CustomerID, pc.ResellerID)
 	return err
 }
 
 func (r *PairingCodeRepository) GetByCode(ctx context.Context, code string) (*domain.PairingCode, error) {
-	query := `SELECT id, code, device_auth_token, claimed_by_device_id, claimed_at, expires_at, created_by, relay_server_id, reassign_device_id, customer_id, created_at
+	query := `SELECT id, code, device_auth_token, claimed_by_device_id, claimed_at, expires_at, created_by, relay_server_id, reassign_device_id, customer_id, reseller_id, created_at
 		FROM pairing_codes
 		WHERE code = $1 AND claimed_at IS NULL AND expires_at > NOW()`
 	var pc domain.PairingCode
 	err := r.db.Pool.QueryRow(ctx, query, code).Scan(
 		&pc.ID, &pc.Code, &pc.DeviceAuthToken, &pc.ClaimedByDeviceID,
-		&pc.ClaimedAt, &pc.ExpiresAt, &pc.CreatedBy, &pc.RelayServerID, &pc.ReassignDeviceID, &pc.CustomerID, &pc.CreatedAt)
+		&pc.ClaimedAt, &pc.ExpiresAt, &pc.CreatedBy, &pc.RelayServerID, &pc.ReassignDeviceID, &pc.CustomerID, &pc.ResellerID, &pc.CreatedAt)
 	if err != nil {
 		return nil, fmt.Errorf("get pairing code: %w", err)
 	}
@@ -45,9 +45,34 @@ func (r *PairingCodeRepository) Claim(ctx context.Context, id uuid.UUID, deviceI
 }
 
 func (r *PairingCodeRepository) List(ctx context.Context) ([]domain.PairingCode, error) {
-	query := `SELECT id, code, device_auth_token, claimed_by_device_id, claimed_at, expires_at, created_by, relay_server_id, reassign_device_id, customer_id, created_at
+	query := `SELECT id, code, device_auth_token, claimed_by_device_id, claimed_at, expires_at, created_by, relay_server_id, reassign_device_id, customer_id, reseller_id, created_at
 		FROM pairing_codes ORDER BY created_at DESC`
-	rows, err := r.db.Pool.Query(ctx, query)
+	return r.list(ctx, query)
+}
+
+// ListByReseller returns the pairing codes created for a reseller.
+func (r *PairingCodeRepository) ListByReseller(ctx context.Context, resellerID uuid.UUID) ([]domain.PairingCode, error) {
+	query := `SELECT id, code, device_auth_token, claimed_by_device_id, claimed_at, expires_at, created_by, relay_server_id, reassign_device_id, customer_id, reseller_id, created_at
+		FROM pairing_codes WHERE reseller_id = $1 ORDER BY created_at DESC`
+	return r.list(ctx, query, resellerID)
+}
+
+// GetByIDForReseller gets a pairing code by ID only if it was created for the reseller.
+func (r *PairingCodeRepository) GetByIDForReseller(ctx context.Context, id uuid.UUID, resellerID uuid.UUID) (*domain.PairingCode, error) {
+	query := `SELECT id, code, device_auth_token, claimed_by_device_id, claimed_at, expires_at, created_by, relay_server_id, reassign_device_id, customer_id, reseller_id, created_at
+		FROM pairing_codes WHERE id = $1 AND reseller_id = $2`
+	var pc domain.PairingCode
+	err := r.db.Pool.QueryRow(ctx, query, id, resellerID).Scan(
+		&pc.ID, &pc.Code, &pc.DeviceAuthToken, &pc.ClaimedByDeviceID,
+		&pc.ClaimedAt, &pc.ExpiresAt, &pc.CreatedBy, &pc.RelayServerID, &pc.ReassignDeviceID, &pc.CustomerID, &pc.ResellerID, &pc.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("get pairing code: %w", err)
+	}
+	return &pc, nil
+}
+
+func (r *PairingCodeRepository) list(ctx context.Context, query string, args ...interface{}) ([]domain.PairingCode, error) {
+	rows, err := r.db.Pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -57,7 +82,7 @@ func (r *PairingCodeRepository) List(ctx context.Context) ([]domain.PairingCode,
 	for rows.Next() {
 		var pc domain.PairingCode
 		err := rows.Scan(&pc.ID, &pc.Code, &pc.DeviceAuthToken, &pc.ClaimedByDeviceID,
-			&pc.ClaimedAt, &pc.ExpiresAt, &pc.CreatedBy, &pc.RelayServerID, &pc.ReassignDeviceID, &pc.CustomerID, &pc.CreatedAt)
+			&pc.ClaimedAt, &pc.ExpiresAt, &pc.CreatedBy, &pc.RelayServerID, &pc.ReassignDeviceID, &pc.CustomerID, &pc.ResellerID, &pc.CreatedAt)
 		if err != nil {
 			return nil, fmt.Errorf("scan pairing code: %w", err)
 		}
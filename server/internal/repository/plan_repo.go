@@ -0,0 +1,247 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/mobileproxy/server/internal/domain"
+)
+
+type PlanRepository struct {
+	db *DB
+}
+
+func NewPlanRepository(db *DB) *PlanRepository {
+	return &PlanRepository{db: db}
+}
+
+const planSelectCols = `id, name, description, max_devices, max_connections, monthly_gb, allowed_proxy_types,
+		max_rotations_per_day, min_rotation_interval_sec, active, provider_price_id, created_at, updated_at`
+
+func (r *PlanRepository) Create(ctx context.Context, p *domain.Plan) error {
+	query := `INSERT INTO plans (id, name, description, max_devices, max_connections, monthly_gb, allowed_proxy_types,
+			max_rotations_per_day, min_rotation_interval_sec, active, provider_price_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING created_at, updated_at`
+	return r.db.Pool.QueryRow(ctx, query,
+		p.ID, p.Name, p.Description, p.MaxDevices, p.MaxConnections, p.MonthlyGB, p.AllowedProxyTypes,
+		p.MaxRotationsPerDay, p.MinRotationIntervalSec, p.Active, p.ProviderPriceID,
+	).Scan(&p.CreatedAt, &p.UpdatedAt)
+}
+
+func (r *PlanRepository) GetByID(ctx context.Context, id uuid.UUID) (*domain.Plan, error) {
+	query := `SELECT ` + planSelectCols + ` FROM plans WHERE id = $1`
+	p, err := r.scanPlan(r.db.Pool.QueryRow(ctx, query, id))
+	if err != nil {
+		return nil, fmt.Errorf("get plan: %w", err)
+	}
+	return p, nil
+}
+
+func (r *PlanRepository) List(ctx context.Context) ([]domain.Plan, error) {
+	query := `SELECT ` + planSelectCols + ` FROM plans ORDER BY name ASC`
+	rows, err := r.db.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plans []domain.Plan
+	for rows.Next() {
+		p, err := r.scanPlan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan plan: %w", err)
+		}
+		plans = append(plans, *p)
+	}
+	return plans, nil
+}
+
+func (r *PlanRepository) Update(ctx context.Context, p *domain.Plan) error {
+	query := `UPDATE plans SET name = $2, description = $3, max_devices = $4, max_connections = $5, monthly_gb = $6,
+			allowed_proxy_types = $7, max_rotations_per_day = $8, min_rotation_interval_sec = $9, active = $10,
+			provider_price_id = $11, updated_at = NOW()
+		WHERE id = $1
+		RETURNING created_at, updated_at`
+	return r.db.Pool.QueryRow(ctx, query,
+		p.ID, p.Name, p.Description, p.MaxDevices, p.MaxConnections, p.MonthlyGB, p.AllowedProxyTypes,
+		p.MaxRotationsPerDay, p.MinRotationIntervalSec, p.Active, p.ProviderPriceID,
+	).Scan(&p.CreatedAt, &p.UpdatedAt)
+}
+
+func (r *PlanRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	_, err := r.db.Pool.Exec(ctx, `DELETE FROM plans WHERE id = $1`, id)
+	return err
+}
+
+// GetForCustomer returns the customer's plan, or nil if none is assigned.
+func (r *PlanRepository) GetForCustomer(ctx context.Context, customerID uuid.UUID) (*domain.Plan, error) {
+	query := `SELECT ` + planSelectCols + ` FROM plans
+		WHERE id = (SELECT plan_id FROM customers WHERE id = $1)`
+	p, err := r.scanPlan(r.db.Pool.QueryRow(ctx, query, customerID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get customer plan: %w", err)
+	}
+	return p, nil
+}
+
+// Plan usage counts ($1 = customer ID), shared by the usage report and the
+// plan-limited inserts.
+const (
+	customerDevicesFrom = `(
+			SELECT id FROM devices WHERE customer_id = $1
+			UNION
+			SELECT device_id FROM device_shares WHERE shared_with = $1
+		) d`
+	customerDevicesQuery     = `SELECT COUNT(*) FROM ` + customerDevicesFrom
+	customerConnectionsQuery = `SELECT COUNT(*) FROM proxy_connections WHERE customer_id = $1`
+)
+
+// CountDevices counts devices a customer owns or has been shared.
+func (r *PlanRepository) CountDevices(ctx context.Context, customerID uuid.UUID) (int, error) {
+	var n int
+	err := r.db.Pool.QueryRow(ctx, customerDevicesQuery, customerID).Scan(&n)
+	return n, err
+}
+
+func (r *PlanRepository) CountConnections(ctx context.Context, customerID uuid.UUID) (int, error) {
+	var n int
+	err := r.db.Pool.QueryRow(ctx, customerConnectionsQuery, customerID).Scan(&n)
+	return n, err
+}
+
+// lockCustomerPlan holds the customer's plan lock until tx ends. Every insert
+// that counts against a plan limit takes it before counting, so two parallel
+// requests cannot both see the last free slot.
+func lockCustomerPlan(ctx context.Context, tx pgx.Tx, customerID uuid.UUID) error {
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, "plan:"+customerID.String()); err != nil {
+		return fmt.Errorf("lock customer plan: %w", err)
+	}
+	return nil
+}
+
+// checkPlanCount takes the customer's plan lock, runs a usage count query
+// ($1 = customer ID, then args) and passes the result to check.
+func checkPlanCount(ctx context.Context, tx pgx.Tx, customerID uuid.UUID, check func(n int) error, query string, args ...interface{}) error {
+	if err := lockCustomerPlan(ctx, tx, customerID); err != nil {
+		return err
+	}
+	var n int
+	if err := tx.QueryRow(ctx, query, append([]interface{}{customerID}, args...)...).Scan(&n); err != nil {
+		return fmt.Errorf("count plan usage: %w", err)
+	}
+	return check(n)
+}
+
+// GetMonthBytes sums bytes across the customer's connections since monthStart (daily rollups).
+func (r *PlanRepository) GetMonthBytes(ctx context.Context, customerID uuid.UUID, monthStart time.Time) (int64, error) {
+	query := `SELECT COALESCE(SUM(d.bytes_in + d.bytes_out), 0)
+		FROM bandwidth_daily d
+		JOIN proxy_connections pc ON pc.id = d.connection_id
+		WHERE pc.customer_id = $1 AND d.day >= $2::date`
+	var n int64
+	err := r.db.Pool.QueryRow(ctx, query, customerID, monthStart).Scan(&n)
+	return n, err
+}
+
+// ListConnectionsOverMonthlyCap returns active connections whose customer has
+// used up the plan's monthly_gb since monthStart.
+func (r *PlanRepository) ListConnectionsOverMonthlyCap(ctx context.Context, monthStart time.Time) ([]uuid.UUID, error) {
+	query := `WITH over AS (
+			SELECT cu.id
+			FROM customers cu
+			JOIN plans p ON p.id = cu.plan_id
+			WHERE p.monthly_gb > 0 AND (
+				SELECT COALESCE(SUM(d.bytes_in + d.bytes_out), 0)
+				FROM bandwidth_daily d
+				JOIN proxy_connections c ON c.id = d.connection_id
+				WHERE c.customer_id = cu.id AND d.day >= $1::date
+			) >= p.monthly_gb::bigint * 1073741824
+		)
+		SELECT pc.id FROM proxy_connections pc
+		JOIN over ON over.id = pc.customer_id
+		WHERE pc.active = true`
+	rows, err := r.db.Pool.Query(ctx, query, monthStart)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan connection id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// RecordRotation records a rotation against the customer. A non-nil check is
+// first given the customer's rotation stats since `since` under the plan lock,
+// and its error aborts the rotation.
+func (r *PlanRepository) RecordRotation(ctx context.Context, customerID, deviceID uuid.UUID, source string, since time.Time, check func(count int, last *time.Time) error) error {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if check != nil {
+		if err := lockCustomerPlan(ctx, tx, customerID); err != nil {
+			return err
+		}
+		var n int
+		var last *time.Time
+		if err := tx.QueryRow(ctx, rotationStatsQuery, customerID, since).Scan(&n, &last); err != nil {
+			return fmt.Errorf("rotation stats: %w", err)
+		}
+		if err := check(n, last); err != nil {
+			return err
+		}
+	}
+
+	query := `INSERT INTO rotation_events (customer_id, device_id, source) VALUES ($1, $2, $3)`
+	if _, err := tx.Exec(ctx, query, customerID, deviceID, source); err != nil {
+		return fmt.Errorf("record rotation: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+const rotationStatsQuery = `SELECT COUNT(*) FILTER (WHERE created_at >= $2), MAX(created_at)
+		FROM rotation_events WHERE customer_id = $1`
+
+// GetRotationStats returns how many rotations a customer made since `since`
+// and when they last rotated (nil if never).
+func (r *PlanRepository) GetRotationStats(ctx context.Context, customerID uuid.UUID, since time.Time) (int, *time.Time, error) {
+	var n int
+	var last *time.Time
+	err := r.db.Pool.QueryRow(ctx, rotationStatsQuery, customerID, since).Scan(&n, &last)
+	return n, last, err
+}
+
+func (r *PlanRepository) DeleteRotationsBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := r.db.Pool.Exec(ctx, `DELETE FROM rotation_events WHERE created_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (r *PlanRepository) scanPlan(row interface{ Scan(dest ...interface{}) error }) (*domain.Plan, error) {
+	var p domain.Plan
+	if err := row.Scan(
+		&p.ID, &p.Name, &p.Description, &p.MaxDevices, &p.MaxConnections, &p.MonthlyGB, &p.AllowedProxyTypes,
+		&p.MaxRotationsPerDay, &p.MinRotationIntervalSec, &p.Active, &p.ProviderPriceID, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
@@ -0,0 +1,213 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/mobileproxy/server/internal/domain"
+)
+
+type QuotaRepository struct {
+	db *DB
+}
+
+func NewQuotaRepository(db *DB) *QuotaRepository {
+	return &QuotaRepository{db: db}
+}
+
+const quotaSelectCols = `connection_id, period, anchor_at, cycle_start, notify_percents, notified_percent,
+		over_action, throttle_kbps, created_at, updated_at`
+
+const quotaCycleSelectCols = `id, connection_id, cycle_start, cycle_end, bytes_used, bytes_limit, created_at`
+
+// GetByConnection returns the quota policy for a connection, or pgx.ErrNoRows.
+func (r *QuotaRepository) GetByConnection(ctx context.Context, connectionID uuid.UUID) (*domain.BandwidthQuota, error) {
+	query := `SELECT ` + quotaSelectCols + ` FROM bandwidth_quotas WHERE connection_id = $1`
+	return r.scanQuota(r.db.Pool.QueryRow(ctx, query, connectionID))
+}
+
+// Upsert creates or replaces a connection's quota policy. Changing the policy
+// restarts notifications for the current cycle.
+func (r *QuotaRepository) Upsert(ctx context.Context, q *domain.BandwidthQuota) error {
+	query := `INSERT INTO bandwidth_quotas (connection_id, period, anchor_at, cycle_start, notify_percents,
+			notified_percent, over_action, throttle_kbps)
+		VALUES ($1, $2, $3, $4, $5, 0, $6, $7)
+		ON CONFLICT (connection_id) DO UPDATE SET
+			period = EXCLUDED.period, anchor_at = EXCLUDED.anchor_at, cycle_start = EXCLUDED.cycle_start,
+			notify_percents = EXCLUDED.notify_percents, notified_percent = 0,
+			over_action = EXCLUDED.over_action, throttle_kbps = EXCLUDED.throttle_kbps, updated_at = NOW()
+		RETURNING notified_percent, created_at, updated_at`
+	return r.db.Pool.QueryRow(ctx, query,
+		q.ConnectionID, q.Period, q.AnchorAt, q.CycleStart, q.NotifyPercents, q.OverAction, q.ThrottleKbps,
+	).Scan(&q.NotifiedPercent, &q.CreatedAt, &q.UpdatedAt)
+}
+
+func (r *QuotaRepository) Delete(ctx context.Context, connectionID uuid.UUID) error {
+	_, err := r.db.Pool.Exec(ctx, `DELETE FROM bandwidth_quotas WHERE connection_id = $1`, connectionID)
+	return err
+}
+
+// ListRecurring returns every quota policy with a reset period.
+func (r *QuotaRepository) ListRecurring(ctx context.Context) ([]domain.BandwidthQuota, error) {
+	query := `SELECT ` + quotaSelectCols + ` FROM bandwidth_quotas WHERE period <> 'none'`
+	rows, err := r.db.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var quotas []domain.BandwidthQuota
+	for rows.Next() {
+		q, err := r.scanQuota(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan quota: %w", err)
+		}
+		quotas = append(quotas, *q)
+	}
+	return quotas, nil
+}
+
+// ResetCycle closes the current cycle: the connection's usage is recorded in
+// bandwidth_quota_cycles, bandwidth_used is zeroed and the quota moves to
+// newStart with notifications re-armed.
+func (r *QuotaRepository) ResetCycle(ctx context.Context, connectionID uuid.UUID, prevStart, newStart time.Time) error {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var used, limit int64
+	err = tx.QueryRow(ctx,
+		`SELECT bandwidth_used, bandwidth_limit FROM proxy_connections WHERE id = $1 FOR UPDATE`,
+		connectionID).Scan(&used, &limit)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		// Connection gone (e.g. replaced by peer sync); just advance the cycle
+	case err != nil:
+		return fmt.Errorf("lock connection: %w", err)
+	default:
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO bandwidth_quota_cycles (connection_id, cycle_start, cycle_end, bytes_used, bytes_limit)
+			VALUES ($1, $2, $3, $4, $5)`,
+			connectionID, prevStart, newStart, used, limit); err != nil {
+			return fmt.Errorf("insert quota cycle: %w", err)
+		}
+		if _, err := tx.Exec(ctx,
+			`UPDATE proxy_connections SET bandwidth_used = 0, updated_at = NOW() WHERE id = $1`,
+			connectionID); err != nil {
+			return fmt.Errorf("reset bandwidth: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE bandwidth_quotas SET cycle_start = $2, notified_percent = 0, updated_at = NOW() WHERE connection_id = $1`,
+		connectionID, newStart); err != nil {
+		return fmt.Errorf("advance quota cycle: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ListUsage returns quota policies joined with their connection's usage, for
+// active connections with a byte cap.
+func (r *QuotaRepository) ListUsage(ctx context.Context) ([]domain.QuotaUsage, error) {
+	query := `SELECT q.connection_id, q.period, q.anchor_at, q.cycle_start, q.notify_percents, q.notified_percent,
+			q.over_action, q.throttle_kbps, q.created_at, q.updated_at,
+			pc.device_id, pc.customer_id, pc.username, pc.bandwidth_limit, pc.bandwidth_used
+		FROM bandwidth_quotas q
+		JOIN proxy_connections pc ON pc.id = q.connection_id
+		WHERE pc.active = true AND pc.bandwidth_limit > 0`
+	rows, err := r.db.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usage []domain.QuotaUsage
+	for rows.Next() {
+		var u domain.QuotaUsage
+		q := &u.Quota
+		if err := rows.Scan(
+			&q.ConnectionID, &q.Period, &q.AnchorAt, &q.CycleStart, &q.NotifyPercents, &q.NotifiedPercent,
+			&q.OverAction, &q.ThrottleKbps, &q.CreatedAt, &q.UpdatedAt,
+			&u.DeviceID, &u.CustomerID, &u.Username, &u.BandwidthLimit, &u.BandwidthUsed); err != nil {
+			return nil, fmt.Errorf("scan quota usage: %w", err)
+		}
+		usage = append(usage, u)
+	}
+	return usage, nil
+}
+
+// SetNotified records the highest notification threshold sent this cycle.
+func (r *QuotaRepository) SetNotified(ctx context.Context, connectionID uuid.UUID, percent int) error {
+	_, err := r.db.Pool.Exec(ctx,
+		`UPDATE bandwidth_quotas SET notified_percent = $2, updated_at = NOW() WHERE connection_id = $1`,
+		connectionID, percent)
+	return err
+}
+
+// ListOverQuota returns the relay-facing quota state of every active
+// connection that has used up its byte cap. Connections without a policy are cut.
+func (r *QuotaRepository) ListOverQuota(ctx context.Context) (map[uuid.UUID]domain.ConnectionLimits, error) {
+	query := `SELECT pc.id, COALESCE(q.over_action, 'cut'), COALESCE(q.throttle_kbps, 0)
+		FROM proxy_connections pc
+		LEFT JOIN bandwidth_quotas q ON q.connection_id = pc.id
+		WHERE pc.active = true AND pc.bandwidth_limit > 0 AND pc.bandwidth_used >= pc.bandwidth_limit`
+	rows, err := r.db.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	over := make(map[uuid.UUID]domain.ConnectionLimits)
+	for rows.Next() {
+		var id uuid.UUID
+		var action string
+		var kbps int
+		if err := rows.Scan(&id, &action, &kbps); err != nil {
+			return nil, fmt.Errorf("scan over quota: %w", err)
+		}
+		l := domain.ConnectionLimits{OverQuota: true}
+		if action == domain.QuotaActionThrottle {
+			l.ThrottleKbps = kbps
+		}
+		over[id] = l
+	}
+	return over, nil
+}
+
+// ListCycles returns a connection's completed cycles, newest first.
+func (r *QuotaRepository) ListCycles(ctx context.Context, connectionID uuid.UUID, limit int) ([]domain.BandwidthQuotaCycle, error) {
+	query := `SELECT ` + quotaCycleSelectCols + ` FROM bandwidth_quota_cycles
+		WHERE connection_id = $1 ORDER BY cycle_start DESC LIMIT $2`
+	rows, err := r.db.Pool.Query(ctx, query, connectionID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cycles []domain.BandwidthQuotaCycle
+	for rows.Next() {
+		var c domain.BandwidthQuotaCycle
+		if err := rows.Scan(&c.ID, &c.ConnectionID, &c.CycleStart, &c.CycleEnd, &c.BytesUsed, &c.BytesLimit, &c.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan quota cycle: %w", err)
+		}
+		cycles = append(cycles, c)
+	}
+	return cycles, nil
+}
+
+func (r *QuotaRepository) scanQuota(row interface{ Scan(dest ...interface{}) error }) (*domain.BandwidthQuota, error) {
+	var q domain.BandwidthQuota
+	if err := row.Scan(
+		&q.ConnectionID, &q.Period, &q.AnchorAt, &q.CycleStart, &q.NotifyPercents, &q.NotifiedPercent,
+		&q.OverAction, &q.ThrottleKbps, &q.CreatedAt, &q.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/mobileproxy/server/internal/domain"
+)
+
+// ResellerRepository stores reseller settings that have no place on the users row.
+type ResellerRepository struct {
+	db *DB
+}
+
+func NewResellerRepository(db *DB) *ResellerRepository {
+	return &ResellerRepository{db: db}
+}
+
+const brandingSelectCols = `reseller_id, company_name, logo_url, primary_color, support_email, domain, updated_at`
+
+// GetBranding returns a reseller's branding, or nil if none was set.
+func (r *ResellerRepository) GetBranding(ctx context.Context, resellerID uuid.UUID) (*domain.ResellerBranding, error) {
+	query := `SELECT ` + brandingSelectCols + ` FROM reseller_branding WHERE reseller_id = $1`
+	return scanBranding(r.db.Pool.QueryRow(ctx, query, resellerID))
+}
+
+// GetBrandingByDomain returns the branding registered for a dashboard host
+// name, or nil.
+func (r *ResellerRepository) GetBrandingByDomain(ctx context.Context, host string) (*domain.ResellerBranding, error) {
+	query := `SELECT ` + brandingSelectCols + ` FROM reseller_branding WHERE LOWER(domain) = LOWER($1)`
+	return scanBranding(r.db.Pool.QueryRow(ctx, query, host))
+}
+
+// GetBrandingForCustomer returns the branding of the reseller a customer
+// belongs to, or nil for direct customers.
+func (r *ResellerRepository) GetBrandingForCustomer(ctx context.Context, customerID uuid.UUID) (*domain.ResellerBranding, error) {
+	query := `SELECT ` + brandingSelectCols + ` FROM reseller_branding
+		WHERE reseller_id = (SELECT reseller_id FROM customers WHERE id = $1)`
+	return scanBranding(r.db.Pool.QueryRow(ctx, query, customerID))
+}
+
+func (r *ResellerRepository) UpsertBranding(ctx context.Context, b *domain.ResellerBranding) error {
+	query := `INSERT INTO reseller_branding (reseller_id, company_name, logo_url, primary_color, support_email, domain)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (reseller_id) DO UPDATE SET
+			company_name = EXCLUDED.company_name, logo_url = EXCLUDED.logo_url,
+			primary_color = EXCLUDED.primary_color, support_email = EXCLUDED.support_email,
+			domain = EXCLUDED.domain, updated_at = NOW()
+		RETURNING updated_at`
+	return r.db.Pool.QueryRow(ctx, query,
+		b.ResellerID, b.CompanyName, b.LogoURL, b.PrimaryColor, b.SupportEmail, b.Domain,
+	).Scan(&b.UpdatedAt)
+}
+
+func scanBranding(row pgx.Row) (*domain.ResellerBranding, error) {
+	var b domain.ResellerBranding
+	err := row.Scan(&b.ResellerID, &b.CompanyName, &b.LogoURL, &b.PrimaryColor, &b.SupportEmail, &b.Domain, &b.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scan reseller branding: %w", err)
+	}
+	return &b, nil
+}
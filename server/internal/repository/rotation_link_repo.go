@@ -35,7 +35,33 @@ func (r *RotationLinkRepository) GetByToken(ctx context.Context, token string) (
 
 func (r *RotationLinkRepository) ListByDevice(ctx context.Context, deviceID uuid.UUID) ([]domain.RotationLink, error) {
 	query := `SELECT id, device_id, token, name, created_at, last_used_at FROM rotation_links WHERE device_id = $1 ORDER BY created_at DESC`
-	rows, err := r.db.Pool.Query(ctx, query, deviceID)
+	return r.list(ctx, query, deviceID)
+}
+
+// ListByReseller returns rotation links on devices allocated to the reseller.
+func (r *RotationLinkRepository) ListByReseller(ctx context.Context, resellerID uuid.UUID) ([]domain.RotationLink, error) {
+	query := `SELECT rl.id, rl.device_id, rl.token, rl.name, rl.created_at, rl.last_used_at
+		FROM rotation_links rl JOIN devices d ON d.id = rl.device_id
+		WHERE d.reseller_id = $1 ORDER BY rl.created_at DESC`
+	return r.list(ctx, query, resellerID)
+}
+
+// GetByIDForReseller gets a rotation link by ID only if its device is allocated to the reseller.
+func (r *RotationLinkRepository) GetByIDForReseller(ctx context.Context, id uuid.UUID, resellerID uuid.UUID) (*domain.RotationLink, error) {
+	query := `SELECT rl.id, rl.device_id, rl.token, rl.name, rl.created_at, rl.last_used_at
+		FROM rotation_links rl JOIN devices d ON d.id = rl.device_id
+		WHERE rl.id = $1 AND d.reseller_id = $2`
+	var link domain.RotationLink
+	err := r.db.Pool.QueryRow(ctx, query, id, resellerID).Scan(
+		&link.ID, &link.DeviceID, &link.Token, &link.Name, &link.CreatedAt, &link.LastUsedAt)
+	if err != nil {
+		return nil, fmt.Errorf("get rotation link: %w", err)
+	}
+	return &link, nil
+}
+
+func (r *RotationLinkRepository) list(ctx context.Context, query string, args ...interface{}) ([]domain.RotationLink, error) {
+	rows, err := r.db.Pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
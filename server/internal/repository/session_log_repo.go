@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+)
+
+type SessionLogRepository struct {
+	db *DB
+}
+
+func NewSessionLogRepository(db *DB) *SessionLogRepository {
+	return &SessionLogRepository{db: db}
+}
+
+const sessionSelectCols = `id, connection_id, username, client_ip, proxy_type, dest_host, dest_port,
+		bytes_up, bytes_down, started_at, ended_at, close_reason`
+
+const sessionPartitionPrefix = "connection_sessions_"
+
+// CreateBatch inserts a batch of sessions reported by a relay.
+func (r *SessionLogRepository) CreateBatch(ctx context.Context, sessions []domain.ConnectionSession) error {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, s := range sessions {
+		query := `INSERT INTO connection_sessions (id, connection_id, username, client_ip, proxy_type, dest_host, dest_port,
+			bytes_up, bytes_down, started_at, ended_at, close_reason)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+		if _, err := tx.Exec(ctx, query,
+			s.ID, s.ConnectionID, s.Username, s.ClientIP, s.ProxyType, s.DestHost, s.DestPort,
+			s.BytesUp, s.BytesDown, s.StartedAt, s.EndedAt, s.CloseReason); err != nil {
+			return fmt.Errorf("insert session: %w", err)
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ListByConnection returns sessions for a connection started in [from, to), newest first.
+func (r *SessionLogRepository) ListByConnection(ctx context.Context, connectionID uuid.UUID, from, to time.Time, limit int) ([]domain.ConnectionSession, error) {
+	query := `SELECT ` + sessionSelectCols + ` FROM connection_sessions
+		WHERE connection_id = $1 AND started_at >= $2 AND started_at < $3
+		ORDER BY started_at DESC LIMIT $4`
+	rows, err := r.db.Pool.Query(ctx, query, connectionID, from, to, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []domain.ConnectionSession
+	for rows.Next() {
+		var s domain.ConnectionSession
+		if err := rows.Scan(
+			&s.ID, &s.ConnectionID, &s.Username, &s.ClientIP, &s.ProxyType, &s.DestHost, &s.DestPort,
+			&s.BytesUp, &s.BytesDown, &s.StartedAt, &s.EndedAt, &s.CloseReason); err != nil {
+			return nil, fmt.Errorf("scan session: %w", err)
+		}
+		s.DurationMs = s.EndedAt.Sub(s.StartedAt).Milliseconds()
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
+
+// EnsurePartition creates the daily partition covering day (UTC).
+func (r *SessionLogRepository) EnsurePartition(ctx context.Context, day time.Time) error {
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 1)
+	tableName := sessionPartitionPrefix + start.Format("2006_01_02")
+	query := fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s PARTITION OF connection_sessions FOR VALUES FROM ('%s') TO ('%s')`,
+		tableName, start.Format("2006-01-02"), end.Format("2006-01-02"))
+	_, err := r.db.Pool.Exec(ctx, query)
+	return err
+}
+
+// DropPartitionsBefore drops daily partitions whose whole range is before cutoff.
+// Returns the names of the dropped partitions.
+func (r *SessionLogRepository) DropPartitionsBefore(ctx context.Context, cutoff time.Time) ([]string, error) {
+	query := `SELECT c.relname FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		WHERE p.relname = 'connection_sessions'`
+	rows, err := r.db.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan partition: %w", err)
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+
+	var dropped []string
+	for _, name := range names {
+		day, err := time.Parse("2006_01_02", strings.TrimPrefix(name, sessionPartitionPrefix))
+		if err != nil {
+			continue // not one of ours
+		}
+		if !day.AddDate(0, 0, 1).After(cutoff) {
+			if _, err := r.db.Pool.Exec(ctx, `DROP TABLE IF EXISTS `+name); err != nil {
+				return dropped, fmt.Errorf("drop partition %s: %w", name, err)
+			}
+			dropped = append(dropped, name)
+		}
+	}
+	return dropped, nil
+}
@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// SettingsRepository stores instance-wide key/value settings (app_settings).
+type SettingsRepository struct {
+	db *DB
+}
+
+func NewSettingsRepository(db *DB) *SettingsRepository {
+	return &SettingsRepository{db: db}
+}
+
+// Get returns a setting's value, or "" if it was never set.
+func (r *SettingsRepository) Get(ctx context.Context, key string) (string, error) {
+	var value string
+	err := r.db.Pool.QueryRow(ctx, `SELECT value FROM app_settings WHERE key = $1`, key).Scan(&value)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return value, err
+}
+
+func (r *SettingsRepository) Set(ctx context.Context, key, value string) error {
+	query := `INSERT INTO app_settings (key, value) VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = NOW()`
+	_, err := r.db.Pool.Exec(ctx, query, key, value)
+	return err
+}
@@ -0,0 +1,216 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/mobileproxy/server/internal/domain"
+)
+
+type StatementRepository struct {
+	db *DB
+}
+
+func NewStatementRepository(db *DB) *StatementRepository {
+	return &StatementRepository{db: db}
+}
+
+const statementSelectCols = `id, customer_id, period_start, period_end, plan_name, devices, device_days,
+		connection_days, bytes_in, bytes_out, rotations, lines, closed_at`
+
+// SnapshotUsage records the devices (owned or shared) and connections every
+// customer holds on day (UTC). Safe to repeat; existing rows keep their values
+// apart from the connection's current username.
+func (r *StatementRepository) SnapshotUsage(ctx context.Context, day time.Time) error {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `INSERT INTO customer_device_days (customer_id, device_id, day)
+		SELECT customer_id, id, $1::date FROM devices WHERE customer_id IS NOT NULL
+		UNION
+		SELECT shared_with, device_id, $1::date FROM device_shares
+		ON CONFLICT DO NOTHING`
+	if _, err := tx.Exec(ctx, query, day); err != nil {
+		return fmt.Errorf("snapshot devices: %w", err)
+	}
+
+	query = `INSERT INTO customer_connection_days (customer_id, connection_id, device_id, username, proxy_type, day)
+		SELECT customer_id, id, device_id, username, proxy_type, $1::date
+		FROM proxy_connections WHERE customer_id IS NOT NULL
+		ON CONFLICT (customer_id, day, connection_id) DO UPDATE SET username = EXCLUDED.username`
+	if _, err := tx.Exec(ctx, query, day); err != nil {
+		return fmt.Errorf("snapshot connections: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// GetDeviceUsage returns the distinct devices a customer held in [start, end)
+// and the total device-days.
+func (r *StatementRepository) GetDeviceUsage(ctx context.Context, customerID uuid.UUID, start, end time.Time) (int, int, error) {
+	query := `SELECT COUNT(DISTINCT device_id), COUNT(*) FROM customer_device_days
+		WHERE customer_id = $1 AND day >= $2::date AND day < $3::date`
+	var devices, days int
+	err := r.db.Pool.QueryRow(ctx, query, customerID, start, end).Scan(&devices, &days)
+	return devices, days, err
+}
+
+// GetConnectionLines returns one line per connection the customer held in
+// [start, end). Bytes only count days the customer held the connection.
+func (r *StatementRepository) GetConnectionLines(ctx context.Context, customerID uuid.UUID, start, end time.Time) ([]domain.StatementLine, error) {
+	query := `WITH held AS (
+			SELECT connection_id, COUNT(*) AS days,
+				(array_agg(device_id ORDER BY day DESC))[1] AS device_id,
+				(array_agg(username ORDER BY day DESC))[1] AS username,
+				(array_agg(proxy_type ORDER BY day DESC))[1] AS proxy_type
+			FROM customer_connection_days
+			WHERE customer_id = $1 AND day >= $2::date AND day < $3::date
+			GROUP BY connection_id
+		), bytes AS (
+			SELECT d.connection_id, SUM(d.bytes_in) AS bytes_in, SUM(d.bytes_out) AS bytes_out
+			FROM bandwidth_daily d
+			JOIN customer_connection_days cd
+				ON cd.connection_id = d.connection_id AND cd.day = d.day AND cd.customer_id = $1
+			WHERE d.day >= $2::date AND d.day < $3::date
+			GROUP BY d.connection_id
+		)
+		SELECT h.connection_id, h.device_id, h.username, h.proxy_type, h.days,
+			COALESCE(b.bytes_in, 0), COALESCE(b.bytes_out, 0)
+		FROM held h LEFT JOIN bytes b ON b.connection_id = h.connection_id
+		ORDER BY h.username ASC`
+	rows, err := r.db.Pool.Query(ctx, query, customerID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []domain.StatementLine
+	for rows.Next() {
+		var l domain.StatementLine
+		if err := rows.Scan(&l.ConnectionID, &l.DeviceID, &l.Username, &l.ProxyType, &l.Days,
+			&l.BytesIn, &l.BytesOut); err != nil {
+			return nil, fmt.Errorf("scan statement line: %w", err)
+		}
+		lines = append(lines, l)
+	}
+	return lines, nil
+}
+
+// CountRotations counts IP rotation commands issued in [start, end) on
+// devices the customer held that day.
+func (r *StatementRepository) CountRotations(ctx context.Context, customerID uuid.UUID, start, end time.Time) (int, error) {
+	query := `SELECT COUNT(*) FROM device_commands dc
+		WHERE dc.type = $2 AND dc.created_at >= $3 AND dc.created_at < $4
+		AND EXISTS (
+			SELECT 1 FROM customer_device_days dd
+			WHERE dd.customer_id = $1 AND dd.device_id = dc.device_id
+			AND dd.day = (dc.created_at AT TIME ZONE 'UTC')::date
+		)`
+	var n int
+	err := r.db.Pool.QueryRow(ctx, query, customerID, domain.CommandRotateIP, start, end).Scan(&n)
+	return n, err
+}
+
+// ListCustomersToClose returns customers with usage in [start, end) that have
+// no statement for the period yet.
+func (r *StatementRepository) ListCustomersToClose(ctx context.Context, start, end time.Time) ([]uuid.UUID, error) {
+	query := `SELECT DISTINCT customer_id FROM customer_device_days
+			WHERE day >= $1::date AND day < $2::date
+		UNION
+		SELECT DISTINCT customer_id FROM customer_connection_days
+			WHERE day >= $1::date AND day < $2::date
+		EXCEPT
+		SELECT customer_id FROM statements WHERE period_start = $1::date`
+	rows, err := r.db.Pool.Query(ctx, query, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan customer id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Create stores a closed statement. A statement already closed for the same
+// customer and period is left untouched.
+func (r *StatementRepository) Create(ctx context.Context, st *domain.Statement) error {
+	lines, err := json.Marshal(st.Lines)
+	if err != nil {
+		return fmt.Errorf("marshal lines: %w", err)
+	}
+	query := `INSERT INTO statements (customer_id, period_start, period_end, plan_name, devices, device_days,
+			connection_days, bytes_in, bytes_out, rotations, lines)
+		VALUES ($1, $2::date, $3::date, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (customer_id, period_start) DO NOTHING`
+	_, err = r.db.Pool.Exec(ctx, query,
+		st.CustomerID, st.PeriodStart, st.PeriodEnd, st.PlanName, st.Devices, st.DeviceDays,
+		st.ConnectionDays, st.BytesIn, st.BytesOut, st.Rotations, lines)
+	return err
+}
+
+// Get returns the closed statement for a period, or nil if there is none.
+func (r *StatementRepository) Get(ctx context.Context, customerID uuid.UUID, periodStart time.Time) (*domain.Statement, error) {
+	query := `SELECT ` + statementSelectCols + ` FROM statements WHERE customer_id = $1 AND period_start = $2::date`
+	st, err := r.scanStatement(r.db.Pool.QueryRow(ctx, query, customerID, periodStart))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scan statement: %w", err)
+	}
+	return st, nil
+}
+
+// ListByCustomer returns a customer's closed statements, newest first.
+func (r *StatementRepository) ListByCustomer(ctx context.Context, customerID uuid.UUID) ([]domain.Statement, error) {
+	query := `SELECT ` + statementSelectCols + ` FROM statements WHERE customer_id = $1 ORDER BY period_start DESC`
+	rows, err := r.db.Pool.Query(ctx, query, customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var statements []domain.Statement
+	for rows.Next() {
+		st, err := r.scanStatement(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan statement: %w", err)
+		}
+		statements = append(statements, *st)
+	}
+	return statements, nil
+}
+
+func (r *StatementRepository) scanStatement(row interface{ Scan(dest ...interface{}) error }) (*domain.Statement, error) {
+	var st domain.Statement
+	var id uuid.UUID
+	var closedAt time.Time
+	var lines []byte
+	if err := row.Scan(
+		&id, &st.CustomerID, &st.PeriodStart, &st.PeriodEnd, &st.PlanName, &st.Devices, &st.DeviceDays,
+		&st.ConnectionDays, &st.BytesIn, &st.BytesOut, &st.Rotations, &lines, &closedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(lines, &st.Lines); err != nil {
+		return nil, fmt.Errorf("unmarshal lines: %w", err)
+	}
+	st.ID = &id
+	st.ClosedAt = &closedAt
+	st.Status = domain.StatementClosed
+	return &st, nil
+}
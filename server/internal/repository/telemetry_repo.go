@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+)
+
+// TelemetryRepository stores heartbeat telemetry samples and their hourly
+// downsampled series.
+type TelemetryRepository struct {
+	db *DB
+}
+
+func NewTelemetryRepository(db *DB) *TelemetryRepository {
+	return &TelemetryRepository{db: db}
+}
+
+const telemetryPartitionPrefix = "device_telemetry_"
+
+// Record stores a sample unless the device already has one recorded less than
+// minInterval earlier. force skips that check. Reports whether the sample was
+// stored.
+func (r *TelemetryRepository) Record(ctx context.Context, s *domain.TelemetrySample, minInterval time.Duration, force bool) (bool, error) {
+	query := `INSERT INTO device_telemetry (device_id, recorded_at, battery_level, battery_charging, signal_strength, network_type, carrier)
+		SELECT $1, $2, $3, $4, $5, $6, $7
+		WHERE $8::boolean OR NOT EXISTS (
+			SELECT 1 FROM device_telemetry
+			WHERE device_id = $1 AND recorded_at > $2::timestamptz - make_interval(secs => $9)
+		)
+		ON CONFLICT DO NOTHING`
+	tag, err := r.db.Pool.Exec(ctx, query,
+		s.DeviceID, s.RecordedAt, s.BatteryLevel, s.BatteryCharging, s.SignalStrength, s.NetworkType, s.Carrier,
+		force, minInterval.Seconds())
+	if err != nil {
+		return false, fmt.Errorf("insert telemetry sample: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ListSamples returns a device's samples recorded in [from, to), oldest first.
+func (r *TelemetryRepository) ListSamples(ctx context.Context, deviceID uuid.UUID, from, to time.Time, limit int) ([]domain.TelemetrySample, error) {
+	query := `SELECT device_id, recorded_at, battery_level, battery_charging, signal_strength, network_type, carrier
+		FROM device_telemetry
+		WHERE device_id = $1 AND recorded_at >= $2 AND recorded_at < $3
+		ORDER BY recorded_at ASC LIMIT $4`
+	rows, err := r.db.Pool.Query(ctx, query, deviceID, from, to, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var samples []domain.TelemetrySample
+	for rows.Next() {
+		var s domain.TelemetrySample
+		if err := rows.Scan(&s.DeviceID, &s.RecordedAt, &s.BatteryLevel, &s.BatteryCharging, &s.SignalStrength,
+			&s.NetworkType, &s.Carrier); err != nil {
+			return nil, fmt.Errorf("scan telemetry sample: %w", err)
+		}
+		samples = append(samples, s)
+	}
+	return samples, rows.Err()
+}
+
+// ListHourly returns a device's hourly telemetry for buckets in [from, to),
+// oldest first.
+func (r *TelemetryRepository) ListHourly(ctx context.Context, deviceID uuid.UUID, from, to time.Time) ([]domain.TelemetryHourly, error) {
+	query := `SELECT device_id, bucket, samples, battery_min, battery_avg, battery_max,
+			signal_min, signal_avg, signal_max, network_type, carrier
+		FROM device_telemetry_hourly
+		WHERE device_id = $1 AND bucket >= $2 AND bucket < $3
+		ORDER BY bucket ASC`
+	rows, err := r.db.Pool.Query(ctx, query, deviceID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hours []domain.TelemetryHourly
+	for rows.Next() {
+		var h domain.TelemetryHourly
+		if err := rows.Scan(&h.DeviceID, &h.Bucket, &h.Samples, &h.BatteryMin, &h.BatteryAvg, &h.BatteryMax,
+			&h.SignalMin, &h.SignalAvg, &h.SignalMax, &h.NetworkType, &h.Carrier); err != nil {
+			return nil, fmt.Errorf("scan telemetry hour: %w", err)
+		}
+		hours = append(hours, h)
+	}
+	return hours, rows.Err()
+}
+
+// LatestHourlyBucket returns the newest hourly bucket, or the zero time if
+// nothing has been downsampled yet.
+func (r *TelemetryRepository) LatestHourlyBucket(ctx context.Context) (time.Time, error) {
+	var bucket *time.Time
+	if err := r.db.Pool.QueryRow(ctx, `SELECT MAX(bucket) FROM device_telemetry_hourly`).Scan(&bucket); err != nil {
+		return time.Time{}, err
+	}
+	if bucket == nil {
+		return time.Time{}, nil
+	}
+	return bucket.UTC(), nil
+}
+
+// RollupSince recomputes the hourly rows of every UTC hour from the hour
+// starting at from onwards.
+func (r *TelemetryRepository) RollupSince(ctx context.Context, from time.Time) (int64, error) {
+	query := `INSERT INTO device_telemetry_hourly (device_id, bucket, samples, battery_min, battery_avg, battery_max,
+			signal_min, signal_avg, signal_max, network_type, carrier)
+		SELECT device_id, date_trunc('hour', recorded_at, 'UTC'), COUNT(*),
+			MIN(battery_level), AVG(battery_level), MAX(battery_level),
+			MIN(signal_strength), AVG(signal_strength), MAX(signal_strength),
+			COALESCE(mode() WITHIN GROUP (ORDER BY NULLIF(network_type, '')), ''),
+			COALESCE(mode() WITHIN GROUP (ORDER BY NULLIF(carrier, '')), '')
+		FROM device_telemetry
+		WHERE recorded_at >= $1
+		GROUP BY 1, 2
+		ON CONFLICT (device_id, bucket) DO UPDATE SET
+			samples = EXCLUDED.samples,
+			battery_min = EXCLUDED.battery_min, battery_avg = EXCLUDED.battery_avg, battery_max = EXCLUDED.battery_max,
+			signal_min = EXCLUDED.signal_min, signal_avg = EXCLUDED.signal_avg, signal_max = EXCLUDED.signal_max,
+			network_type = EXCLUDED.network_type, carrier = EXCLUDED.carrier`
+	tag, err := r.db.Pool.Exec(ctx, query, from)
+	if err != nil {
+		return 0, fmt.Errorf("roll up telemetry: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// DeleteHourlyBefore prunes hourly telemetry older than cutoff.
+func (r *TelemetryRepository) DeleteHourlyBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := r.db.Pool.Exec(ctx, `DELETE FROM device_telemetry_hourly WHERE bucket < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// EnsurePartition creates the daily partition covering day (UTC).
+func (r *TelemetryRepository) EnsurePartition(ctx context.Context, day time.Time) error {
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 1)
+	tableName := telemetryPartitionPrefix + start.Format("2006_01_02")
+	query := fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s PARTITION OF device_telemetry FOR VALUES FROM ('%s') TO ('%s')`,
+		tableName, start.Format("2006-01-02"), end.Format("2006-01-02"))
+	_, err := r.db.Pool.Exec(ctx, query)
+	return err
+}
+
+// DropPartitionsBefore drops daily partitions whose whole range is before cutoff.
+// Returns the names of the dropped partitions.
+func (r *TelemetryRepository) DropPartitionsBefore(ctx context.Context, cutoff time.Time) ([]string, error) {
+	query := `SELECT c.relname FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		WHERE p.relname = 'device_telemetry'`
+	rows, err := r.db.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan partition: %w", err)
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+
+	var dropped []string
+	for _, name := range names {
+		day, err := time.Parse("2006_01_02", strings.TrimPrefix(name, telemetryPartitionPrefix))
+		if err != nil {
+			continue // not one of ours
+		}
+		if !day.AddDate(0, 0, 1).After(cutoff) {
+			if _, err := r.db.Pool.Exec(ctx, `DROP TABLE IF EXISTS `+name); err != nil {
+				return dropped, fmt.Errorf("drop partition %s: %w", name, err)
+			}
+			dropped = append(dropped, name)
+		}
+	}
+	return dropped, nil
+}
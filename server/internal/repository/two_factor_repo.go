@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/mobileproxy/server/internal/domain"
+)
+
+type TwoFactorRepository struct {
+	db *DB
+}
+
+func NewTwoFactorRepository(db *DB) *TwoFactorRepository {
+	return &TwoFactorRepository{db: db}
+}
+
+// Get returns a subject's enrollment (confirmed or pending), or nil.
+func (r *TwoFactorRepository) Get(ctx context.Context, subjectType string, subjectID uuid.UUID) (*domain.TwoFactor, error) {
+	query := `SELECT subject_type, subject_id, secret, enabled_at, last_used_step, failed_attempts, locked_until, created_at
+		FROM two_factor WHERE subject_type = $1 AND subject_id = $2`
+	var t domain.TwoFactor
+	err := r.db.Pool.QueryRow(ctx, query, subjectType, subjectID).Scan(
+		&t.SubjectType, &t.SubjectID, &t.Secret, &t.EnabledAt, &t.LastUsedStep, &t.FailedAttempts,
+		&t.LockedUntil, &t.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scan two factor: %w", err)
+	}
+	return &t, nil
+}
+
+// SavePending stores a new unconfirmed secret, replacing an earlier pending
+// one. Returns false if the subject already has 2FA enabled.
+func (r *TwoFactorRepository) SavePending(ctx context.Context, subjectType string, subjectID uuid.UUID, secret string) (bool, error) {
+	query := `INSERT INTO two_factor (subject_type, subject_id, secret) VALUES ($1, $2, $3)
+		ON CONFLICT (subject_type, subject_id) DO UPDATE SET
+			secret = EXCLUDED.secret, last_used_step = 0, failed_attempts = 0, locked_until = NULL, created_at = NOW()
+		WHERE two_factor.enabled_at IS NULL`
+	tag, err := r.db.Pool.Exec(ctx, query, subjectType, subjectID, secret)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// Enable confirms a pending enrollment with the step of the code that proved
+// it and replaces the subject's recovery codes.
+func (r *TwoFactorRepository) Enable(ctx context.Context, subjectType string, subjectID uuid.UUID, step int64, codeHashes []string) error {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `UPDATE two_factor SET enabled_at = NOW(), last_used_step = $3, failed_attempts = 0, locked_until = NULL
+		WHERE subject_type = $1 AND subject_id = $2 AND enabled_at IS NULL`, subjectType, subjectID, step)
+	if err != nil {
+		return fmt.Errorf("enable two factor: %w", err)
+	}
+	if tag.RowsAffected() != 1 {
+		return fmt.Errorf("no pending enrollment")
+	}
+	if err := replaceRecoveryCodes(ctx, tx, subjectType, subjectID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ReplaceRecoveryCodes discards all recovery codes and stores new ones.
+func (r *TwoFactorRepository) ReplaceRecoveryCodes(ctx context.Context, subjectType string, subjectID uuid.UUID, codeHashes []string) error {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := replaceRecoveryCodes(ctx, tx, subjectType, subjectID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, subjectType string, subjectID uuid.UUID, codeHashes []string) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM two_factor_recovery_codes WHERE subject_type = $1 AND subject_id = $2`,
+		subjectType, subjectID); err != nil {
+		return fmt.Errorf("delete recovery codes: %w", err)
+	}
+	for _, h := range codeHashes {
+		if _, err := tx.Exec(ctx, `INSERT INTO two_factor_recovery_codes (subject_type, subject_id, code_hash) VALUES ($1, $2, $3)`,
+			subjectType, subjectID, h); err != nil {
+			return fmt.Errorf("insert recovery code: %w", err)
+		}
+	}
+	return nil
+}
+
+// UseStep records a successful TOTP code. Returns false if a code of the same
+// or a later step was already accepted (a replay).
+func (r *TwoFactorRepository) UseStep(ctx context.Context, subjectType string, subjectID uuid.UUID, step int64) (bool, error) {
+	query := `UPDATE two_factor SET last_used_step = $3, failed_attempts = 0, locked_until = NULL
+		WHERE subject_type = $1 AND subject_id = $2 AND last_used_step < $3`
+	tag, err := r.db.Pool.Exec(ctx, query, subjectType, subjectID, step)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// UseRecoveryCode consumes an unused recovery code. Returns false if none matched.
+func (r *TwoFactorRepository) UseRecoveryCode(ctx context.Context, subjectType string, subjectID uuid.UUID, codeHash string) (bool, error) {
+	query := `UPDATE two_factor_recovery_codes SET used_at = NOW()
+		WHERE id = (SELECT id FROM two_factor_recovery_codes
+			WHERE subject_type = $1 AND subject_id = $2 AND code_hash = $3 AND used_at IS NULL LIMIT 1)`
+	tag, err := r.db.Pool.Exec(ctx, query, subjectType, subjectID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 1 {
+		_, err = r.db.Pool.Exec(ctx, `UPDATE two_factor SET failed_attempts = 0, locked_until = NULL
+			WHERE subject_type = $1 AND subject_id = $2`, subjectType, subjectID)
+	}
+	return tag.RowsAffected() == 1, err
+}
+
+// RecordFailure counts a wrong code and locks the subject out until
+// lockUntil once maxAttempts consecutive codes were wrong.
+func (r *TwoFactorRepository) RecordFailure(ctx context.Context, subjectType string, subjectID uuid.UUID, maxAttempts int, lockUntil time.Time) error {
+	query := `UPDATE two_factor SET
+			failed_attempts = CASE WHEN failed_attempts + 1 >= $3 THEN 0 ELSE failed_attempts + 1 END,
+			locked_until = CASE WHEN failed_attempts + 1 >= $3 THEN $4 ELSE locked_until END
+		WHERE subject_type = $1 AND subject_id = $2`
+	_, err := r.db.Pool.Exec(ctx, query, subjectType, subjectID, maxAttempts, lockUntil)
+	return err
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a subject has left.
+func (r *TwoFactorRepository) CountRecoveryCodes(ctx context.Context, subjectType string, subjectID uuid.UUID) (int, error) {
+	query := `SELECT COUNT(*) FROM two_factor_recovery_codes
+		WHERE subject_type = $1 AND subject_id = $2 AND used_at IS NULL`
+	var n int
+	err := r.db.Pool.QueryRow(ctx, query, subjectType, subjectID).Scan(&n)
+	return n, err
+}
+
+// Delete removes a subject's enrollment and recovery codes.
+func (r *TwoFactorRepository) Delete(ctx context.Context, subjectType string, subjectID uuid.UUID) error {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM two_factor_recovery_codes WHERE subject_type = $1 AND subject_id = $2`,
+		subjectType, subjectID); err != nil {
+		return fmt.Errorf("delete recovery codes: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM two_factor WHERE subject_type = $1 AND subject_id = $2`,
+		subjectType, subjectID); err != nil {
+		return fmt.Errorf("delete two factor: %w", err)
+	}
+	return tx.Commit(ctx)
+}
@@ -16,48 +16,124 @@ func NewUserRepository(db *DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
+const userSelectCols = `id, email, password_hash, name, role, active, permissions, webhook_url, last_login_at,
+		created_at, updated_at`
+
 func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
-	query := `SELECT id, email, password_hash, name, role, webhook_url, created_at, updated_at FROM users WHERE email = $1`
-	var u domain.User
-	err := r.db.Pool.QueryRow(ctx, query, email).Scan(
-		&u.ID, &u.Email, &u.PasswordHash, &u.Name, &u.Role, &u.WebhookURL, &u.CreatedAt, &u.UpdatedAt)
+	query := `SELECT ` + userSelectCols + ` FROM users WHERE email = $1`
+	u, err := r.scanUser(r.db.Pool.QueryRow(ctx, query, email))
 	if err != nil {
 		return nil, fmt.Errorf("get user by email: %w", err)
 	}
-	return &u, nil
+	return u, nil
 }
 
 func (r *UserRepository) GetByID(ctx context.Context, id uuid.UUID) (*domain.User, error) {
-	query := `SELECT id, email, password_hash, name, role, webhook_url, created_at, updated_at FROM users WHERE id = $1`
-	var u domain.User
-	err := r.db.Pool.QueryRow(ctx, query, id).Scan(
-		&u.ID, &u.Email, &u.PasswordHash, &u.Name, &u.Role, &u.WebhookURL, &u.CreatedAt, &u.UpdatedAt)
+	query := `SELECT ` + userSelectCols + ` FROM users WHERE id = $1`
+	u, err := r.scanUser(r.db.Pool.QueryRow(ctx, query, id))
 	if err != nil {
 		return nil, fmt.Errorf("get user by id: %w", err)
 	}
-	return &u, nil
+	return u, nil
+}
+
+func (r *UserRepository) List(ctx context.Context) ([]domain.User, error) {
+	query := `SELECT ` + userSelectCols + ` FROM users ORDER BY created_at`
+	rows, err := r.db.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []domain.User
+	for rows.Next() {
+		u, err := r.scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan user: %w", err)
+		}
+		users = append(users, *u)
+	}
+	return users, nil
 }
 
 func (r *UserRepository) Create(ctx context.Context, u *domain.User) error {
-	query := `INSERT INTO users (id, email, password_hash, name, role) VALUES ($1, $2, $3, $4, $5)`
-	_, err := r.db.Pool.Exec(ctx, query, u.ID, u.Email, u.PasswordHash, u.Name, u.Role)
+	if u.Permissions == nil {
+		u.Permissions = []string{}
+	}
+	query := `INSERT INTO users (id, email, password_hash, name, role, active, permissions)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING created_at, updated_at`
+	return r.db.Pool.QueryRow(ctx, query,
+		u.ID, u.Email, u.PasswordHash, u.Name, u.Role, u.Active, u.Permissions,
+	).Scan(&u.CreatedAt, &u.UpdatedAt)
+}
+
+// Update saves name, role and permissions.
+func (r *UserRepository) Update(ctx context.Context, u *domain.User) error {
+	if u.Permissions == nil {
+		u.Permissions = []string{}
+	}
+	query := `UPDATE users SET name = $2, role = $3, permissions = $4, updated_at = NOW() WHERE id = $1`
+	_, err := r.db.Pool.Exec(ctx, query, u.ID, u.Name, u.Role, u.Permissions)
 	return err
 }
 
+func (r *UserRepository) UpdateActive(ctx context.Context, id uuid.UUID, active bool) error {
+	query := `UPDATE users SET active = $2, updated_at = NOW() WHERE id = $1`
+	_, err := r.db.Pool.Exec(ctx, query, id, active)
+	return err
+}
+
+func (r *UserRepository) UpdatePasswordHash(ctx context.Context, id uuid.UUID, hash string) error {
+	query := `UPDATE users SET password_hash = $2, updated_at = NOW() WHERE id = $1`
+	_, err := r.db.Pool.Exec(ctx, query, id, hash)
+	return err
+}
+
+func (r *UserRepository) TouchLastLogin(ctx context.Context, id uuid.UUID) error {
+	_, err := r.db.Pool.Exec(ctx, `UPDATE users SET last_login_at = NOW() WHERE id = $1`, id)
+	return err
+}
+
+// CountActiveAdmins returns how many enabled admin accounts exist, so the
+// last one cannot be disabled or demoted.
+func (r *UserRepository) CountActiveAdmins(ctx context.Context) (int, error) {
+	var n int
+	err := r.db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM users WHERE role = 'admin' AND active`).Scan(&n)
+	return n, err
+}
+
 func (r *UserRepository) UpdateWebhookURL(ctx context.Context, id uuid.UUID, url *string) error {
 	query := `UPDATE users SET webhook_url = $2, updated_at = NOW() WHERE id = $1`
 	_, err := r.db.Pool.Exec(ctx, query, id, url)
 	return err
 }
 
-// GetWebhookURLForDevice returns the first configured webhook URL from any user.
-// In a single-tenant system, there is typically one operator with a webhook configured.
-func (r *UserRepository) GetWebhookURLForDevice(ctx context.Context, deviceID uuid.UUID) (string, error) {
-	query := `SELECT webhook_url FROM users WHERE webhook_url IS NOT NULL AND webhook_url != '' LIMIT 1`
+// GetWebhookTargetForDevice returns the user whose legacy webhook URL receives
+// events of a device, and that URL: the reseller's own if the device is
+// allocated to one, otherwise the first one configured by a staff user.
+// Resellers never receive other devices' events.
+func (r *UserRepository) GetWebhookTargetForDevice(ctx context.Context, deviceID uuid.UUID) (uuid.UUID, string, error) {
+	query := `SELECT u.id, u.webhook_url FROM users u
+		LEFT JOIN devices d ON d.id = $1
+		WHERE u.webhook_url IS NOT NULL AND u.webhook_url != '' AND u.active
+			AND (u.id = d.reseller_id OR (d.reseller_id IS NULL AND u.role != 'reseller'))
+		LIMIT 1`
+	var id uuid.UUID
 	var url string
-	err := r.db.Pool.QueryRow(ctx, query).Scan(&url)
+	err := r.db.Pool.QueryRow(ctx, query, deviceID).Scan(&id, &url)
 	if err != nil {
-		return "", err
+		return uuid.Nil, "", err
 	}
-	return url, nil
+	return id, url, nil
+}
+
+func (r *UserRepository) scanUser(row interface{ Scan(dest ...interface{}) error }) (*domain.User, error) {
+	var u domain.User
+	if err := row.Scan(
+		&u.ID, &u.Email, &u.PasswordHash, &u.Name, &u.Role, &u.Active, &u.Permissions, &u.WebhookURL,
+		&u.LastLoginAt, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &u, nil
 }
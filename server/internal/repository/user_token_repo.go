@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+)
+
+type UserTokenRepository struct {
+	db *DB
+}
+
+func NewUserTokenRepository(db *DB) *UserTokenRepository {
+	return &UserTokenRepository{db: db}
+}
+
+// Create inserts a new staff user token (invite or password reset).
+func (r *UserTokenRepository) Create(ctx context.Context, token *domain.UserAuthToken) error {
+	query := `INSERT INTO user_auth_tokens (id, user_id, token_hash, type, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING created_at`
+	return r.db.Pool.QueryRow(ctx, query,
+		token.ID, token.UserID, token.TokenHash, token.Type, token.ExpiresAt,
+	).Scan(&token.CreatedAt)
+}
+
+// GetByHash retrieves a valid (unused, non-expired) token by its hash.
+func (r *UserTokenRepository) GetByHash(ctx context.Context, tokenHash string) (*domain.UserAuthToken, error) {
+	query := `SELECT id, user_id, token_hash, type, expires_at, used_at, created_at
+		FROM user_auth_tokens
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()`
+	var t domain.UserAuthToken
+	err := r.db.Pool.QueryRow(ctx, query, tokenHash).Scan(
+		&t.ID, &t.UserID, &t.TokenHash, &t.Type, &t.ExpiresAt, &t.UsedAt, &t.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("get token by hash: %w", err)
+	}
+	return &t, nil
+}
+
+// MarkUsed records the time a token was consumed so it cannot be reused.
+func (r *UserTokenRepository) MarkUsed(ctx context.Context, id uuid.UUID) error {
+	_, err := r.db.Pool.Exec(ctx, `UPDATE user_auth_tokens SET used_at = NOW() WHERE id = $1`, id)
+	return err
+}
+
+// DeleteByUser removes all outstanding tokens of a user, so only the newest link works.
+func (r *UserTokenRepository) DeleteByUser(ctx context.Context, userID uuid.UUID) error {
+	_, err := r.db.Pool.Exec(ctx, `DELETE FROM user_auth_tokens WHERE user_id = $1`, userID)
+	return err
+}
@@ -0,0 +1,224 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/mobileproxy/server/internal/domain"
+)
+
+// WebhookRepository stores webhook endpoints and their delivery queue.
+type WebhookRepository struct {
+	db *DB
+}
+
+func NewWebhookRepository(db *DB) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+const webhookEndpointSelectCols = `id, user_id, customer_id, kind, url, chat_id, description, secret, events, active, created_at, updated_at`
+
+const webhookDeliverySelectCols = `id, endpoint_id, user_id, customer_id, url, event, payload, status, attempts,
+	next_attempt_at, last_attempt_at, response_status, response_body, error, redelivery_of, created_at`
+
+// ─── Endpoints ──────────────────────────────────────────────────────────────
+
+func (r *WebhookRepository) CreateEndpoint(ctx context.Context, e *domain.WebhookEndpoint) error {
+	query := `INSERT INTO webhook_endpoints (id, user_id, customer_id, kind, url, chat_id, description, secret, events, active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING created_at, updated_at`
+	return r.db.Pool.QueryRow(ctx, query,
+		e.ID, e.UserID, e.CustomerID, e.Kind, e.URL, e.ChatID, e.Description, e.Secret, e.Events, e.Active,
+	).Scan(&e.CreatedAt, &e.UpdatedAt)
+}
+
+// GetEndpoint returns an endpoint, or nil if there is none.
+func (r *WebhookRepository) GetEndpoint(ctx context.Context, id uuid.UUID) (*domain.WebhookEndpoint, error) {
+	query := `SELECT ` + webhookEndpointSelectCols + ` FROM webhook_endpoints WHERE id = $1`
+	e, err := scanWebhookEndpoint(r.db.Pool.QueryRow(ctx, query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return e, err
+}
+
+// ListEndpoints returns the endpoints of a staff user or customer organization.
+func (r *WebhookRepository) ListEndpoints(ctx context.Context, owner domain.WebhookOwner) ([]domain.WebhookEndpoint, error) {
+	query := `SELECT ` + webhookEndpointSelectCols + ` FROM webhook_endpoints
+		WHERE user_id IS NOT DISTINCT FROM $1 AND customer_id IS NOT DISTINCT FROM $2
+		ORDER BY created_at ASC`
+	return r.listEndpoints(ctx, query, owner.UserID, owner.CustomerID)
+}
+
+// ListSubscribed returns the active endpoints that receive an event about a
+// device: every non-reseller staff endpoint, the endpoints of the reseller
+// the device is allocated to, and those of the given customer organizations.
+func (r *WebhookRepository) ListSubscribed(ctx context.Context, event string, resellerID *uuid.UUID, customerIDs []uuid.UUID) ([]domain.WebhookEndpoint, error) {
+	query := `SELECT e.id, e.user_id, e.customer_id, e.kind, e.url, e.chat_id, e.description, e.secret, e.events,
+			e.active, e.created_at, e.updated_at
+		FROM webhook_endpoints e
+		LEFT JOIN users u ON u.id = e.user_id
+		WHERE e.active AND (cardinality(e.events) = 0 OR $1 = ANY(e.events))
+			AND ((u.active AND (u.role != 'reseller' OR u.id = $2)) OR e.customer_id = ANY($3))`
+	return r.listEndpoints(ctx, query, event, resellerID, customerIDs)
+}
+
+func (r *WebhookRepository) UpdateEndpoint(ctx context.Context, e *domain.WebhookEndpoint) error {
+	query := `UPDATE webhook_endpoints SET url = $2, chat_id = $3, description = $4, secret = $5, events = $6, active = $7,
+			updated_at = NOW()
+		WHERE id = $1 RETURNING updated_at`
+	return r.db.Pool.QueryRow(ctx, query,
+		e.ID, e.URL, e.ChatID, e.Description, e.Secret, e.Events, e.Active,
+	).Scan(&e.UpdatedAt)
+}
+
+func (r *WebhookRepository) SetSecret(ctx context.Context, id uuid.UUID, secret string) error {
+	_, err := r.db.Pool.Exec(ctx, `UPDATE webhook_endpoints SET secret = $2, updated_at = NOW() WHERE id = $1`, id, secret)
+	return err
+}
+
+func (r *WebhookRepository) DeleteEndpoint(ctx context.Context, id uuid.UUID) error {
+	_, err := r.db.Pool.Exec(ctx, `DELETE FROM webhook_endpoints WHERE id = $1`, id)
+	return err
+}
+
+func (r *WebhookRepository) listEndpoints(ctx context.Context, query string, args ...interface{}) ([]domain.WebhookEndpoint, error) {
+	rows, err := r.db.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list webhook endpoints: %w", err)
+	}
+	defer rows.Close()
+
+	endpoints := []domain.WebhookEndpoint{}
+	for rows.Next() {
+		e, err := scanWebhookEndpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, *e)
+	}
+	return endpoints, rows.Err()
+}
+
+func scanWebhookEndpoint(row pgx.Row) (*domain.WebhookEndpoint, error) {
+	var e domain.WebhookEndpoint
+	err := row.Scan(&e.ID, &e.UserID, &e.CustomerID, &e.Kind, &e.URL, &e.ChatID, &e.Description, &e.Secret,
+		&e.Events, &e.Active, &e.CreatedAt, &e.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scan webhook endpoint: %w", err)
+	}
+	return &e, nil
+}
+
+// ─── Deliveries ─────────────────────────────────────────────────────────────
+
+// Enqueue queues deliveries for immediate sending.
+func (r *WebhookRepository) Enqueue(ctx context.Context, deliveries []domain.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	batch := &pgx.Batch{}
+	for _, d := range deliveries {
+		batch.Queue(`INSERT INTO webhook_deliveries (id, endpoint_id, user_id, customer_id, url, event, payload, redelivery_of)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			d.ID, d.EndpointID, d.UserID, d.CustomerID, d.URL, d.Event, d.Payload, d.RedeliveryOf)
+	}
+	if err := r.db.Pool.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("enqueue webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+// ClaimDue returns up to limit pending deliveries that are due and pushes
+// their next attempt out by lease, so concurrent workers don't pick them up
+// while they are being sent.
+func (r *WebhookRepository) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]domain.WebhookDelivery, error) {
+	query := `UPDATE webhook_deliveries SET next_attempt_at = NOW() + make_interval(secs => $2)
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING ` + webhookDeliverySelectCols
+	return r.listDeliveries(ctx, query, limit, lease.Seconds())
+}
+
+// RecordAttempt stores the outcome of a delivery attempt.
+func (r *WebhookRepository) RecordAttempt(ctx context.Context, d *domain.WebhookDelivery) error {
+	query := `UPDATE webhook_deliveries SET status = $2, attempts = $3, next_attempt_at = $4,
+			last_attempt_at = $5, response_status = $6, response_body = $7, error = $8
+		WHERE id = $1`
+	_, err := r.db.Pool.Exec(ctx, query,
+		d.ID, d.Status, d.Attempts, d.NextAttemptAt, d.LastAttemptAt, d.ResponseStatus, d.ResponseBody, d.Error)
+	return err
+}
+
+// GetDelivery returns a delivery, or nil if there is none.
+func (r *WebhookRepository) GetDelivery(ctx context.Context, id uuid.UUID) (*domain.WebhookDelivery, error) {
+	query := `SELECT ` + webhookDeliverySelectCols + ` FROM webhook_deliveries WHERE id = $1`
+	d, err := scanWebhookDelivery(r.db.Pool.QueryRow(ctx, query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return d, err
+}
+
+// ListDeliveries returns an owner's most recent deliveries, optionally for
+// one endpoint and/or with one status.
+func (r *WebhookRepository) ListDeliveries(ctx context.Context, owner domain.WebhookOwner, endpointID *uuid.UUID, status string, limit int) ([]domain.WebhookDelivery, error) {
+	query := `SELECT ` + webhookDeliverySelectCols + ` FROM webhook_deliveries
+		WHERE user_id IS NOT DISTINCT FROM $1 AND customer_id IS NOT DISTINCT FROM $2
+			AND ($3::uuid IS NULL OR endpoint_id = $3)
+			AND ($4 = '' OR status = $4)
+		ORDER BY created_at DESC LIMIT $5`
+	return r.listDeliveries(ctx, query, owner.UserID, owner.CustomerID, endpointID, status, limit)
+}
+
+// DeleteDeliveriesBefore prunes finished deliveries older than t.
+func (r *WebhookRepository) DeleteDeliveriesBefore(ctx context.Context, t time.Time) (int64, error) {
+	tag, err := r.db.Pool.Exec(ctx, `DELETE FROM webhook_deliveries WHERE created_at < $1 AND status != 'pending'`, t)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (r *WebhookRepository) listDeliveries(ctx context.Context, query string, args ...interface{}) ([]domain.WebhookDelivery, error) {
+	rows, err := r.db.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []domain.WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *d)
+	}
+	return deliveries, rows.Err()
+}
+
+func scanWebhookDelivery(row pgx.Row) (*domain.WebhookDelivery, error) {
+	var d domain.WebhookDelivery
+	err := row.Scan(&d.ID, &d.EndpointID, &d.UserID, &d.CustomerID, &d.URL, &d.Event, &d.Payload,
+		&d.Status, &d.Attempts, &d.NextAttemptAt, &d.LastAttemptAt, &d.ResponseStatus, &d.ResponseBody,
+		&d.Error, &d.RedeliveryOf, &d.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scan webhook delivery: %w", err)
+	}
+	return &d, nil
+}
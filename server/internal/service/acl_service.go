@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/repository"
+)
+
+const (
+	maxACLRules         = 200
+	defaultDenialsLimit = 100
+	maxDenialsLimit     = 1000
+)
+
+// ACLService manages destination allow/deny rules. Relays pull the full policy
+// from /api/internal/acl-policy and report denied attempts back.
+//
+// Evaluation (done on the relay): the global policy and the connection policy
+// must each permit the destination. Within a policy, any matching deny rule
+// blocks; if the policy has allow rules, the destination must match one of them.
+type ACLService struct {
+	aclRepo  *repository.ACLRepository
+	connRepo *repository.ConnectionRepository
+}
+
+func NewACLService(aclRepo *repository.ACLRepository, connRepo *repository.ConnectionRepository) *ACLService {
+	return &ACLService{aclRepo: aclRepo, connRepo: connRepo}
+}
+
+func (s *ACLService) GetConnectionRules(ctx context.Context, connectionID uuid.UUID) ([]domain.ACLRule, error) {
+	rules, err := s.aclRepo.ListByConnection(ctx, connectionID)
+	if err != nil {
+		return nil, err
+	}
+	if rules == nil {
+		rules = []domain.ACLRule{}
+	}
+	return rules, nil
+}
+
+func (s *ACLService) SetConnectionRules(ctx context.Context, connectionID uuid.UUID, inputs []domain.ACLRuleInput) ([]domain.ACLRule, error) {
+	if _, err := s.connRepo.GetByID(ctx, connectionID); err != nil {
+		return nil, fmt.Errorf("get connection: %w", err)
+	}
+	rules, err := buildACLRules(inputs)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.aclRepo.Replace(ctx, &connectionID, rules); err != nil {
+		return nil, err
+	}
+	return s.GetConnectionRules(ctx, connectionID)
+}
+
+func (s *ACLService) GetGlobalRules(ctx context.Context) ([]domain.ACLRule, error) {
+	rules, err := s.aclRepo.ListGlobal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if rules == nil {
+		rules = []domain.ACLRule{}
+	}
+	return rules, nil
+}
+
+func (s *ACLService) SetGlobalRules(ctx context.Context, inputs []domain.ACLRuleInput) ([]domain.ACLRule, error) {
+	rules, err := buildACLRules(inputs)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.aclRepo.Replace(ctx, nil, rules); err != nil {
+		return nil, err
+	}
+	return s.GetGlobalRules(ctx)
+}
+
+// GetPolicy returns the full rule snapshot for relays.
+func (s *ACLService) GetPolicy(ctx context.Context) (*domain.ACLPolicy, error) {
+	rules, err := s.aclRepo.ListAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	policy := &domain.ACLPolicy{
+		Global:      []domain.ACLRule{},
+		Connections: make(map[string][]domain.ACLRule),
+	}
+	for _, rule := range rules {
+		if rule.ConnectionID == nil {
+			policy.Global = append(policy.Global, rule)
+			continue
+		}
+		key := rule.ConnectionID.String()
+		policy.Connections[key] = append(policy.Connections[key], rule)
+	}
+	return policy, nil
+}
+
+// RecordDenials stores denied attempts reported by a relay.
+func (s *ACLService) RecordDenials(ctx context.Context, denials []domain.ACLDenial) error {
+	for i := range denials {
+		if denials[i].ID == uuid.Nil {
+			denials[i].ID = uuid.New()
+		}
+		if denials[i].OccurredAt.IsZero() {
+			denials[i].OccurredAt = time.Now()
+		}
+		if denials[i].Scope != "global" {
+			denials[i].Scope = "connection"
+		}
+	}
+	return s.aclRepo.CreateDenials(ctx, denials)
+}
+
+func (s *ACLService) ListConnectionDenials(ctx context.Context, connectionID uuid.UUID, limit int) ([]domain.ACLDenial, error) {
+	denials, err := s.aclRepo.ListDenialsByConnection(ctx, connectionID, clampDenialsLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	if denials == nil {
+		denials = []domain.ACLDenial{}
+	}
+	return denials, nil
+}
+
+func (s *ACLService) ListDenials(ctx context.Context, limit int) ([]domain.ACLDenial, error) {
+	denials, err := s.aclRepo.ListDenials(ctx, clampDenialsLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	if denials == nil {
+		denials = []domain.ACLDenial{}
+	}
+	return denials, nil
+}
+
+// PruneDenials deletes denial records older than the retention window.
+func (s *ACLService) PruneDenials(ctx context.Context, retention time.Duration) (int64, error) {
+	return s.aclRepo.DeleteDenialsBefore(ctx, time.Now().Add(-retention))
+}
+
+func clampDenialsLimit(limit int) int {
+	if limit <= 0 {
+		return defaultDenialsLimit
+	}
+	if limit > maxDenialsLimit {
+		return maxDenialsLimit
+	}
+	return limit
+}
+
+func buildACLRules(inputs []domain.ACLRuleInput) ([]domain.ACLRule, error) {
+	if len(inputs) > maxACLRules {
+		return nil, fmt.Errorf("invalid acl: at most %d rules allowed", maxACLRules)
+	}
+	rules := make([]domain.ACLRule, 0, len(inputs))
+	for i, in := range inputs {
+		value, err := normalizeACLValue(in.RuleType, in.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid acl rule %d: %w", i, err)
+		}
+		if in.Action != "allow" && in.Action != "deny" {
+			return nil, fmt.Errorf("invalid acl rule %d: action must be allow or deny", i)
+		}
+		rules = append(rules, domain.ACLRule{
+			ID:          uuid.New(),
+			Action:      in.Action,
+			RuleType:    in.RuleType,
+			Value:       value,
+			Description: in.Description,
+		})
+	}
+	return rules, nil
+}
+
+// normalizeACLValue validates a rule value and returns its canonical form.
+func normalizeACLValue(ruleType, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	switch ruleType {
+	case "port":
+		lo, hi, found := strings.Cut(value, "-")
+		if !found {
+			hi = lo
+		}
+		loN, err1 := strconv.Atoi(strings.TrimSpace(lo))
+		hiN, err2 := strconv.Atoi(strings.TrimSpace(hi))
+		if err1 != nil || err2 != nil || loN < 1 || hiN > 65535 || loN > hiN {
+			return "", fmt.Errorf("port must be 1-65535 or a range like 6881-6889")
+		}
+		if loN == hiN {
+			return strconv.Itoa(loN), nil
+		}
+		return fmt.Sprintf("%d-%d", loN, hiN), nil
+	case "cidr":
+		if ip := net.ParseIP(value); ip != nil {
+			if ip.To4() != nil {
+				return ip.String() + "/32", nil
+			}
+			return ip.String() + "/128", nil
+		}
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return "", fmt.Errorf("cidr must be an IP or CIDR like 10.0.0.0/8")
+		}
+		return ipNet.String(), nil
+	case "domain":
+		d := strings.ToLower(value)
+		d = strings.TrimPrefix(d, "*.")
+		d = strings.Trim(d, ".")
+		if d == "" || len(d) > 253 || net.ParseIP(d) != nil {
+			return "", fmt.Errorf("domain must be a hostname suffix like example.com")
+		}
+		for _, c := range d {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '_') {
+				return "", fmt.Errorf("domain contains invalid character %q", c)
+			}
+		}
+		return d, nil
+	default:
+		return "", fmt.Errorf("rule_type must be port, cidr or domain")
+	}
+}
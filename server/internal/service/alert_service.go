@@ -0,0 +1,586 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/repository"
+)
+
+const (
+	maxAlertRules          = 50
+	defaultAlertCooldown   = 60
+	defaultIncidentsLimit  = 50
+	maxIncidentsLimit      = 200
+	alertResolvedAuto      = "auto"
+	alertResolvedManual    = "manual"
+	minAlertSignalStrength = -150
+	maxAlertSignalStrength = -30
+)
+
+var (
+	errAlertRuleNotFound     = errors.New("alert rule not found")
+	errAlertIncidentNotFound = errors.New("alert incident not found")
+
+	appVersionRe = regexp.MustCompile(`^\d+(\.\d+)*$`)
+)
+
+// networkRanks orders the network types the app reports, slowest first.
+// Unknown is left out so it never counts as a downgrade.
+var networkRanks = map[string]int{
+	"2G":  1,
+	"3G":  2,
+	"3G+": 3,
+	"4G":  4,
+	"5G":  5,
+}
+
+// AlertService manages alert rules and evaluates them against the latest
+// heartbeat of each online device. The worker calls Evaluate every minute;
+// incidents open once a condition has held for the rule's duration and
+// resolve on their own when it clears.
+type AlertService struct {
+	alertRepo      *repository.AlertRepository
+	deviceRepo     *repository.DeviceRepository
+	userRepo       *repository.UserRepository
+	customerRepo   *repository.CustomerRepository
+	webhookService *WebhookService
+	emailService   *EmailService
+}
+
+func NewAlertService(alertRepo *repository.AlertRepository, deviceRepo *repository.DeviceRepository, userRepo *repository.UserRepository, customerRepo *repository.CustomerRepository) *AlertService {
+	return &AlertService{
+		alertRepo:    alertRepo,
+		deviceRepo:   deviceRepo,
+		userRepo:     userRepo,
+		customerRepo: customerRepo,
+	}
+}
+
+// SetWebhookService enables alert notifications through webhook endpoints
+// and chat channels.
+func (s *AlertService) SetWebhookService(ws *WebhookService) {
+	s.webhookService = ws
+}
+
+// SetEmailService enables alert notifications by email.
+func (s *AlertService) SetEmailService(es *EmailService) {
+	s.emailService = es
+}
+
+// ─── Rules ──────────────────────────────────────────────────────────────────
+
+func (s *AlertService) ListRules(ctx context.Context, owner domain.WebhookOwner) ([]domain.AlertRule, error) {
+	return s.alertRepo.ListRules(ctx, owner)
+}
+
+// GetRule returns a rule by ID (for audit snapshots).
+func (s *AlertService) GetRule(ctx context.Context, id uuid.UUID) (*domain.AlertRule, error) {
+	return s.alertRepo.GetRule(ctx, id)
+}
+
+func (s *AlertService) CreateRule(ctx context.Context, owner domain.WebhookOwner, req *domain.AlertRuleRequest) (*domain.AlertRule, error) {
+	existing, err := s.alertRepo.ListRules(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= maxAlertRules {
+		return nil, fmt.Errorf("at most %d alert rules allowed", maxAlertRules)
+	}
+	rule := &domain.AlertRule{
+		ID:         uuid.New(),
+		UserID:     owner.UserID,
+		CustomerID: owner.CustomerID,
+	}
+	if err := s.applyRuleRequest(ctx, owner, rule, req); err != nil {
+		return nil, err
+	}
+	if err := s.alertRepo.CreateRule(ctx, rule); err != nil {
+		return nil, fmt.Errorf("create alert rule: %w", err)
+	}
+	return rule, nil
+}
+
+// UpdateRule replaces a rule's settings and restarts its evaluation. Open
+// incidents stay open until the new condition clears.
+func (s *AlertService) UpdateRule(ctx context.Context, owner domain.WebhookOwner, id uuid.UUID, req *domain.AlertRuleRequest) (*domain.AlertRule, error) {
+	rule, err := s.ownedRule(ctx, owner, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.applyRuleRequest(ctx, owner, rule, req); err != nil {
+		return nil, err
+	}
+	if err := s.alertRepo.UpdateRule(ctx, rule); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// DeleteRule removes a rule together with its incidents.
+func (s *AlertService) DeleteRule(ctx context.Context, owner domain.WebhookOwner, id uuid.UUID) error {
+	if _, err := s.ownedRule(ctx, owner, id); err != nil {
+		return err
+	}
+	return s.alertRepo.DeleteRule(ctx, id)
+}
+
+func (s *AlertService) ownedRule(ctx context.Context, owner domain.WebhookOwner, id uuid.UUID) (*domain.AlertRule, error) {
+	rule, err := s.alertRepo.GetRule(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if rule == nil || !sameWebhookOwner(owner, rule.UserID, rule.CustomerID) {
+		return nil, errAlertRuleNotFound
+	}
+	return rule, nil
+}
+
+// applyRuleRequest validates a request and copies it onto the rule. Only the
+// field the rule type uses is kept.
+func (s *AlertService) applyRuleRequest(ctx context.Context, owner domain.WebhookOwner, rule *domain.AlertRule, req *domain.AlertRuleRequest) error {
+	threshold, value := 0, ""
+	switch req.Type {
+	case domain.AlertBatteryLow:
+		if req.Threshold < 1 || req.Threshold > 100 {
+			return errors.New("threshold must be a battery percentage between 1 and 100")
+		}
+		threshold = req.Threshold
+	case domain.AlertSignalLow:
+		if req.Threshold < minAlertSignalStrength || req.Threshold > maxAlertSignalStrength {
+			return fmt.Errorf("threshold must be a signal strength between %d and %d dBm", minAlertSignalStrength, maxAlertSignalStrength)
+		}
+		threshold = req.Threshold
+	case domain.AlertNetworkDowngrade:
+		if networkRanks[req.Value] == 0 {
+			return errors.New("value must be one of 2G, 3G, 3G+, 4G, 5G")
+		}
+		value = req.Value
+	case domain.AlertCarrierChanged:
+		value = strings.TrimSpace(req.Value)
+	case domain.AlertAppOutdated:
+		if !appVersionRe.MatchString(req.Value) {
+			return errors.New("value must be an app version such as 1.4.2")
+		}
+		value = req.Value
+	default:
+		return fmt.Errorf("unknown alert type %q", req.Type)
+	}
+
+	if req.DeviceID != nil {
+		if _, err := s.ownerDevice(ctx, owner, *req.DeviceID); err != nil {
+			return err
+		}
+	}
+
+	rule.DeviceID = req.DeviceID
+	rule.Name = strings.TrimSpace(req.Name)
+	if rule.Name == "" {
+		rule.Name = req.Type
+	}
+	rule.Type = req.Type
+	rule.Threshold = threshold
+	rule.Value = value
+	rule.DurationMinutes = req.DurationMinutes
+	rule.CooldownMinutes = defaultAlertCooldown
+	if req.CooldownMinutes != nil {
+		rule.CooldownMinutes = *req.CooldownMinutes
+	}
+	rule.NotifyWebhook = req.NotifyWebhook
+	rule.NotifyEmail = req.NotifyEmail
+	rule.Active = true
+	if req.Active != nil {
+		rule.Active = *req.Active
+	}
+	return nil
+}
+
+// ─── Incidents ──────────────────────────────────────────────────────────────
+
+// ListIncidents returns an owner's incidents, newest first.
+func (s *AlertService) ListIncidents(ctx context.Context, owner domain.WebhookOwner, status string, deviceID *uuid.UUID, limit int) ([]domain.AlertIncident, error) {
+	if limit <= 0 || limit > maxIncidentsLimit {
+		limit = defaultIncidentsLimit
+	}
+	return s.alertRepo.ListIncidents(ctx, owner, status, deviceID, limit)
+}
+
+// ResolveIncident closes an open incident by hand. The rule starts over for
+// the device: the condition must hold for the full duration again, and a
+// carrier change is accepted as the new baseline.
+func (s *AlertService) ResolveIncident(ctx context.Context, owner domain.WebhookOwner, id uuid.UUID) (*domain.AlertIncident, error) {
+	inc, err := s.alertRepo.GetIncident(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if inc == nil || !sameWebhookOwner(owner, inc.UserID, inc.CustomerID) {
+		return nil, errAlertIncidentNotFound
+	}
+	if inc.Status != domain.AlertIncidentOpen {
+		return nil, errors.New("incident is already resolved")
+	}
+
+	now := time.Now()
+	if _, err := s.alertRepo.ResolveIncident(ctx, id, alertResolvedManual, now); err != nil {
+		return nil, err
+	}
+	baseline := ""
+	if inc.Type == domain.AlertCarrierChanged {
+		if device, err := s.deviceRepo.GetByID(ctx, inc.DeviceID); err == nil {
+			baseline = device.Carrier
+		}
+	}
+	if err := s.alertRepo.ResetState(ctx, inc.RuleID, inc.DeviceID, baseline); err != nil {
+		return nil, err
+	}
+
+	by := alertResolvedManual
+	inc.Status = domain.AlertIncidentResolved
+	inc.ResolvedAt = &now
+	inc.ResolvedBy = &by
+	return inc, nil
+}
+
+// ─── Evaluation ─────────────────────────────────────────────────────────────
+
+// Evaluate checks every active rule against the devices it covers, opening
+// and resolving incidents. Returns the number of incidents opened or
+// resolved.
+func (s *AlertService) Evaluate(ctx context.Context) (int, error) {
+	rules, err := s.alertRepo.ListActiveRules(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(rules) == 0 {
+		return 0, nil
+	}
+	states, err := s.alertRepo.ListStates(ctx)
+	if err != nil {
+		return 0, err
+	}
+	open, err := s.alertRepo.ListOpenIncidents(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	devicesByOwner := map[domain.WebhookOwner][]domain.Device{}
+	changed := 0
+	for i := range rules {
+		rule := &rules[i]
+		owner := domain.WebhookOwner{UserID: rule.UserID, CustomerID: rule.CustomerID}
+		devices, ok := devicesByOwner[owner]
+		if !ok {
+			devices, err = s.ownerDevices(ctx, owner)
+			if err != nil {
+				log.Printf("[alerts] list devices for rule %s failed: %v", rule.ID, err)
+				continue
+			}
+			devicesByOwner[owner] = devices
+		}
+
+		for j := range devices {
+			device := &devices[j]
+			if rule.DeviceID != nil && *rule.DeviceID != device.ID {
+				continue
+			}
+			if device.Status != domain.DeviceStatusOnline {
+				continue
+			}
+			key := [2]uuid.UUID{rule.ID, device.ID}
+			state, ok := states[key]
+			if !ok {
+				state = domain.AlertRuleState{RuleID: rule.ID, DeviceID: device.ID}
+			}
+			var inc *domain.AlertIncident
+			if o, ok := open[key]; ok {
+				inc = &o
+			}
+			n, err := s.evaluateDevice(ctx, rule, device, &state, inc, now)
+			if err != nil {
+				log.Printf("[alerts] evaluate rule %s for device %s failed: %v", rule.ID, device.ID, err)
+			}
+			changed += n
+		}
+	}
+	return changed, nil
+}
+
+// evaluateDevice advances one rule for one device and saves its state.
+// Returns 1 if an incident was opened or resolved.
+func (s *AlertService) evaluateDevice(ctx context.Context, rule *domain.AlertRule, device *domain.Device, state *domain.AlertRuleState, inc *domain.AlertIncident, now time.Time) (int, error) {
+	before := *state
+	met, known, observed, message := checkAlertCondition(rule, device, state)
+	changed := 0
+
+	switch {
+	case !known:
+		// Telemetry missing from the heartbeat: keep the current state.
+	case met:
+		if state.ConditionSince == nil {
+			state.ConditionSince = &now
+		}
+		held := now.Sub(*state.ConditionSince) >= time.Duration(rule.DurationMinutes)*time.Minute
+		if inc == nil && held {
+			notify := state.LastNotifiedAt == nil ||
+				now.Sub(*state.LastNotifiedAt) >= time.Duration(rule.CooldownMinutes)*time.Minute
+			newInc := &domain.AlertIncident{
+				ID:         uuid.New(),
+				RuleID:     rule.ID,
+				RuleName:   rule.Name,
+				DeviceID:   device.ID,
+				DeviceName: device.Name,
+				UserID:     rule.UserID,
+				CustomerID: rule.CustomerID,
+				Type:       rule.Type,
+				Status:     domain.AlertIncidentOpen,
+				Message:    message,
+				Observed:   observed,
+				OpenedAt:   now,
+			}
+			opened, err := s.alertRepo.OpenIncident(ctx, newInc)
+			if err != nil {
+				return 0, err
+			}
+			if opened {
+				changed = 1
+				if notify && s.notify(ctx, rule, device, domain.WebhookEventAlertTriggered, newInc) {
+					state.LastNotifiedAt = &now
+					if err := s.alertRepo.MarkNotified(ctx, newInc.ID); err != nil {
+						log.Printf("[alerts] mark incident %s notified failed: %v", newInc.ID, err)
+					}
+				}
+			}
+		}
+	default:
+		state.ConditionSince = nil
+		if inc != nil {
+			resolved, err := s.alertRepo.ResolveIncident(ctx, inc.ID, alertResolvedAuto, now)
+			if err != nil {
+				return 0, err
+			}
+			if resolved {
+				changed = 1
+				if inc.Notified {
+					inc.Message = resolvedAlertMessage(rule, observed)
+					inc.Observed = observed
+					s.notify(ctx, rule, device, domain.WebhookEventAlertResolved, inc)
+				}
+			}
+		}
+	}
+
+	if sameAlertState(&before, state) {
+		return changed, nil
+	}
+	return changed, s.alertRepo.SaveState(ctx, state)
+}
+
+// checkAlertCondition reports whether a rule's condition holds for a device,
+// whether the heartbeat carried the telemetry it needs, the observed value
+// and a message describing it. The carrier baseline is recorded in state the
+// first time a carrier is seen.
+func checkAlertCondition(rule *domain.AlertRule, d *domain.Device, state *domain.AlertRuleState) (met, known bool, observed, message string) {
+	switch rule.Type {
+	case domain.AlertBatteryLow:
+		if d.BatteryLevel <= 0 { // the app reports 0 when it can't read the battery
+			return false, false, "", ""
+		}
+		observed = fmt.Sprintf("%d%%", d.BatteryLevel)
+		met = d.BatteryLevel < rule.Threshold && !d.BatteryCharging
+		return met, true, observed, fmt.Sprintf("Battery at %d%% and not charging (threshold %d%%)", d.BatteryLevel, rule.Threshold)
+	case domain.AlertSignalLow:
+		if d.SignalStrength == 0 {
+			return false, false, "", ""
+		}
+		observed = fmt.Sprintf("%d dBm", d.SignalStrength)
+		return d.SignalStrength < rule.Threshold, true, observed,
+			fmt.Sprintf("Signal at %d dBm (threshold %d dBm)", d.SignalStrength, rule.Threshold)
+	case domain.AlertNetworkDowngrade:
+		rank := networkRanks[d.NetworkType]
+		if rank == 0 {
+			return false, false, "", ""
+		}
+		return rank < networkRanks[rule.Value], true, d.NetworkType,
+			fmt.Sprintf("Network is %s (expected %s or better)", d.NetworkType, rule.Value)
+	case domain.AlertCarrierChanged:
+		if d.Carrier == "" {
+			return false, false, "", ""
+		}
+		expected := rule.Value
+		if expected == "" {
+			if state.Baseline == "" {
+				state.Baseline = d.Carrier
+			}
+			expected = state.Baseline
+		}
+		return !strings.EqualFold(d.Carrier, expected), true, d.Carrier,
+			fmt.Sprintf("Carrier is %s (expected %s)", d.Carrier, expected)
+	case domain.AlertAppOutdated:
+		if d.AppVersion == "" {
+			return false, false, "", ""
+		}
+		return compareVersions(d.AppVersion, rule.Value) < 0, true, d.AppVersion,
+			fmt.Sprintf("App version %s is older than %s", d.AppVersion, rule.Value)
+	}
+	return false, false, "", ""
+}
+
+func resolvedAlertMessage(rule *domain.AlertRule, observed string) string {
+	switch rule.Type {
+	case domain.AlertBatteryLow:
+		return "Battery at " + observed + " or charging"
+	case domain.AlertSignalLow:
+		return "Signal recovered to " + observed
+	case domain.AlertNetworkDowngrade:
+		return "Network is back to " + observed
+	case domain.AlertCarrierChanged:
+		return "Carrier is back to " + observed
+	case domain.AlertAppOutdated:
+		return "App updated to " + observed
+	}
+	return "Condition cleared"
+}
+
+// notify sends an alert event through the rule's channels. Reports whether
+// at least one notification was queued or sent.
+func (s *AlertService) notify(ctx context.Context, rule *domain.AlertRule, device *domain.Device, event string, inc *domain.AlertIncident) bool {
+	data := map[string]interface{}{
+		"incident_id": inc.ID.String(),
+		"rule_id":     rule.ID.String(),
+		"rule_name":   rule.Name,
+		"type":        rule.Type,
+		"device_id":   device.ID.String(),
+		"device_name": device.Name,
+		"message":     inc.Message,
+		"observed":    inc.Observed,
+	}
+	sent := false
+	if rule.NotifyWebhook && s.webhookService != nil {
+		owner := domain.WebhookOwner{UserID: rule.UserID, CustomerID: rule.CustomerID}
+		if err := s.webhookService.EmitTo(ctx, owner, event, data); err != nil {
+			log.Printf("[alerts] webhook for rule %s failed: %v", rule.ID, err)
+		} else {
+			sent = true
+		}
+	}
+	if rule.NotifyEmail && s.emailService != nil {
+		to, err := s.ownerEmail(ctx, rule)
+		if err == nil && to != "" {
+			title, lines := notificationText(event, data)
+			err = s.emailService.SendAlert(to, title, lines)
+		}
+		if err != nil {
+			log.Printf("[alerts] email for rule %s failed: %v", rule.ID, err)
+		} else if to != "" {
+			sent = true
+		}
+	}
+	return sent
+}
+
+func (s *AlertService) ownerEmail(ctx context.Context, rule *domain.AlertRule) (string, error) {
+	if rule.CustomerID != nil {
+		c, err := s.customerRepo.GetByID(ctx, *rule.CustomerID)
+		if err != nil {
+			return "", err
+		}
+		return c.Email, nil
+	}
+	u, err := s.userRepo.GetByID(ctx, *rule.UserID)
+	if err != nil {
+		return "", err
+	}
+	return u.Email, nil
+}
+
+// ownerDevices returns the devices an owner's rules cover: a customer
+// organization's own and shared devices, a reseller's allocated devices, or
+// every device for other staff. Deactivated staff users cover none.
+func (s *AlertService) ownerDevices(ctx context.Context, owner domain.WebhookOwner) ([]domain.Device, error) {
+	if owner.CustomerID != nil {
+		return s.deviceRepo.ListByCustomer(ctx, *owner.CustomerID)
+	}
+	u, err := s.userRepo.GetByID(ctx, *owner.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !u.Active {
+		return nil, nil
+	}
+	if u.Role == "reseller" {
+		return s.deviceRepo.ListByReseller(ctx, u.ID)
+	}
+	return s.deviceRepo.List(ctx)
+}
+
+// ownerDevice returns a device if the owner's rules may cover it.
+func (s *AlertService) ownerDevice(ctx context.Context, owner domain.WebhookOwner, deviceID uuid.UUID) (*domain.Device, error) {
+	var device *domain.Device
+	var err error
+	if owner.CustomerID != nil {
+		device, err = s.deviceRepo.GetByIDForCustomer(ctx, deviceID, *owner.CustomerID)
+	} else {
+		var u *domain.User
+		u, err = s.userRepo.GetByID(ctx, *owner.UserID)
+		if err == nil && u.Role == "reseller" {
+			device, err = s.deviceRepo.GetByIDForReseller(ctx, deviceID, u.ID)
+		} else if err == nil {
+			device, err = s.deviceRepo.GetByID(ctx, deviceID)
+		}
+	}
+	if err != nil || device == nil {
+		return nil, errors.New("device not found")
+	}
+	return device, nil
+}
+
+func sameAlertState(a, b *domain.AlertRuleState) bool {
+	return a.Baseline == b.Baseline &&
+		sameTime(a.ConditionSince, b.ConditionSince) &&
+		sameTime(a.LastNotifiedAt, b.LastNotifiedAt)
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// compareVersions compares dotted version strings numerically. Suffixes
+// after the digits of a part (e.g. "3-beta") are ignored.
+func compareVersions(a, b string) int {
+	pa, pb := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		va, vb := versionPart(pa, i), versionPart(pb, i)
+		if va != vb {
+			if va < vb {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionPart(parts []string, i int) int {
+	if i >= len(parts) {
+		return 0
+	}
+	p := parts[i]
+	end := 0
+	for end < len(p) && p[end] >= '0' && p[end] <= '9' {
+		end++
+	}
+	n, _ := strconv.Atoi(p[:end])
+	return n
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/repository"
+)
+
+const (
+	// APIKeyPrefix starts every raw API key, so the auth middleware can tell
+	// keys from JWTs in the same Bearer header.
+	APIKeyPrefix = "mpk_"
+
+	apiKeyDisplayLen       = 12 // characters of the raw key kept as its prefix
+	maxAPIKeysPerCustomer  = 20
+	maxAPIKeyExpiresInDays = 3650
+)
+
+var validAPIKeyScopes = map[string]bool{
+	domain.ScopeRead:              true,
+	domain.ScopeRotate:            true,
+	domain.ScopeManageConnections: true,
+}
+
+// APIKeyService issues and authenticates long-lived customer API keys.
+type APIKeyService struct {
+	apiKeyRepo *repository.APIKeyRepository
+}
+
+func NewAPIKeyService(apiKeyRepo *repository.APIKeyRepository) *APIKeyService {
+	return &APIKeyService{apiKeyRepo: apiKeyRepo}
+}
+
+// Create issues a key for a customer. The raw key is only in the response.
+func (s *APIKeyService) Create(ctx context.Context, customerID uuid.UUID, req *domain.CreateAPIKeyRequest) (*domain.CreateAPIKeyResponse, error) {
+	seen := make(map[string]bool)
+	var scopes []string
+	for _, scope := range req.Scopes {
+		if !validAPIKeyScopes[scope] {
+			return nil, fmt.Errorf("invalid scope %q: must be read, rotate or manage_connections", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	if req.ExpiresInDays > maxAPIKeyExpiresInDays {
+		return nil, fmt.Errorf("expires_in_days must be at most %d", maxAPIKeyExpiresInDays)
+	}
+
+	n, err := s.apiKeyRepo.CountActive(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	if n >= maxAPIKeysPerCustomer {
+		return nil, fmt.Errorf("at most %d active API keys per customer", maxAPIKeysPerCustomer)
+	}
+
+	token, _, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	raw := APIKeyPrefix + token
+	key := &domain.APIKey{
+		ID:         uuid.New(),
+		CustomerID: customerID,
+		Name:       req.Name,
+		Prefix:     raw[:apiKeyDisplayLen],
+		KeyHash:    hashToken(raw),
+		Scopes:     scopes,
+	}
+	if req.ExpiresInDays > 0 {
+		expires := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		key.ExpiresAt = &expires
+	}
+	if err := s.apiKeyRepo.Create(ctx, key); err != nil {
+		return nil, fmt.Errorf("create api key: %w", err)
+	}
+	return &domain.CreateAPIKeyResponse{Key: raw, APIKey: *key}, nil
+}
+
+func (s *APIKeyService) List(ctx context.Context, customerID uuid.UUID) ([]domain.APIKey, error) {
+	keys, err := s.apiKeyRepo.ListByCustomer(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		keys = []domain.APIKey{}
+	}
+	return keys, nil
+}
+
+// Revoke disables a key. A non-nil customerID must own the key.
+func (s *APIKeyService) Revoke(ctx context.Context, id uuid.UUID, customerID *uuid.UUID) error {
+	key, err := s.apiKeyRepo.GetByID(ctx, id)
+	if err != nil {
+		return fmt.Errorf("api key not found")
+	}
+	if customerID != nil && key.CustomerID != *customerID {
+		return fmt.Errorf("api key not found")
+	}
+	return s.apiKeyRepo.Revoke(ctx, id)
+}
+
+// Authenticate resolves a raw key to its record, or nil if it is unknown,
+// revoked or expired. Records the use.
+func (s *APIKeyService) Authenticate(ctx context.Context, raw, clientIP string) (*domain.APIKey, error) {
+	if !strings.HasPrefix(raw, APIKeyPrefix) {
+		return nil, nil
+	}
+	key, err := s.apiKeyRepo.GetActiveByHash(ctx, hashToken(raw))
+	if err != nil || key == nil {
+		return nil, err
+	}
+	if err := s.apiKeyRepo.TouchLastUsed(ctx, key.ID, clientIP); err != nil {
+		log.Printf("[api-keys] touch %s: %v", key.ID, err)
+	}
+	return key, nil
+}
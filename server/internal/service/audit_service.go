@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/repository"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// auditRedactedKeys are JSON fields never written to the audit log. Their
+// value is replaced so an entry still shows that e.g. a password was set.
+var auditRedactedKeys = map[string]bool{
+	"password":        true,
+	"password_hash":   true,
+	"password_plain":  true,
+	"key":             true,
+	"token":           true,
+	"auth_token":      true,
+	"refresh_token":   true,
+	"challenge_token": true,
+	"secret":          true,
+	"bot_token":       true,
+	"otpauth_url":     true,
+	"code":            true,
+	"recovery_codes":  true,
+	"vpn_config":      true,
+}
+
+// auditSecretURLKinds are webhook endpoint kinds whose URL is the credential
+// itself: anyone holding a Slack or Discord incoming-webhook URL can post to
+// the channel.
+var auditSecretURLKinds = map[string]bool{
+	domain.WebhookKindSlack:   true,
+	domain.WebhookKindDiscord: true,
+}
+
+// auditIgnoredFields change on every write and would only add noise to diffs.
+var auditIgnoredFields = map[string]bool{
+	"updated_at": true,
+}
+
+// AuditLoader returns the current state of an entity, used to diff it before
+// and after an action.
+type AuditLoader func(ctx context.Context, id uuid.UUID) (interface{}, error)
+
+// AuditService records who changed what. Entries are mostly written by the
+// audit middleware; targets with a registered loader get a field-level diff.
+type AuditService struct {
+	auditRepo *repository.AuditRepository
+	loaders   map[string]AuditLoader
+}
+
+func NewAuditService(auditRepo *repository.AuditRepository) *AuditService {
+	return &AuditService{auditRepo: auditRepo, loaders: make(map[string]AuditLoader)}
+}
+
+// RegisterTarget sets how to load entities of targetType. Call during setup only.
+func (s *AuditService) RegisterTarget(targetType string, loader AuditLoader) {
+	s.loaders[targetType] = loader
+}
+
+// Snapshot returns the redacted JSON fields of an entity, or nil if it can't
+// be loaded (unknown type, not found, ...).
+func (s *AuditService) Snapshot(ctx context.Context, targetType string, id uuid.UUID) map[string]interface{} {
+	loader, ok := s.loaders[targetType]
+	if !ok {
+		return nil
+	}
+	v, err := loader(ctx, id)
+	if err != nil || v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return AuditFields(b)
+}
+
+// Record stores an entry. Failures are logged, never returned: a broken audit
+// log must not fail the action that was already carried out.
+func (s *AuditService) Record(ctx context.Context, e *domain.AuditEntry) {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	if err := s.auditRepo.Create(ctx, e); err != nil {
+		log.Printf("[audit] record %s by %s %v: %v", e.Action, e.ActorType, e.ActorID, err)
+	}
+}
+
+// List returns entries matching f, newest first.
+func (s *AuditService) List(ctx context.Context, f domain.AuditFilter) ([]domain.AuditEntry, error) {
+	if f.Limit <= 0 {
+		f.Limit = defaultAuditLimit
+	}
+	if f.Limit > maxAuditLimit {
+		f.Limit = maxAuditLimit
+	}
+	if f.Offset < 0 {
+		f.Offset = 0
+	}
+	entries, err := s.auditRepo.List(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	if entries == nil {
+		entries = []domain.AuditEntry{}
+	}
+	return entries, nil
+}
+
+// Prune deletes entries older than retention.
+func (s *AuditService) Prune(ctx context.Context, retention time.Duration) (int64, error) {
+	return s.auditRepo.DeleteBefore(ctx, time.Now().Add(-retention))
+}
+
+// AuditFields decodes a JSON object and redacts secrets. Returns nil if raw is
+// not a JSON object.
+func AuditFields(raw []byte) map[string]interface{} {
+	var m map[string]interface{}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil
+	}
+	redact(m)
+	return m
+}
+
+// AuditRequest returns a redacted copy of a JSON request body, or nil if it
+// isn't JSON. target is the snapshot of the entity acted on, if any: an update
+// body doesn't repeat the endpoint kind, so the target's kind decides whether
+// its url is a secret.
+func AuditRequest(raw []byte, target map[string]interface{}) json.RawMessage {
+	var v interface{}
+	if len(raw) == 0 || json.Unmarshal(raw, &v) != nil {
+		return nil
+	}
+	redact(v)
+	if m, ok := v.(map[string]interface{}); ok && m["kind"] == nil && target != nil {
+		redactSecretURL(m, target["kind"])
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func redact(v interface{}) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			if auditRedactedKeys[k] {
+				if child != nil && child != "" {
+					t[k] = "[redacted]"
+				}
+				continue
+			}
+			redact(child)
+		}
+		redactSecretURL(t, t["kind"])
+	case []interface{}:
+		for _, child := range t {
+			redact(child)
+		}
+	}
+}
+
+// redactSecretURL redacts the url of an endpoint whose kind makes it a secret.
+func redactSecretURL(m map[string]interface{}, kind interface{}) {
+	if k, _ := kind.(string); !auditSecretURLKinds[k] {
+		return
+	}
+	if u, ok := m["url"]; ok && u != nil && u != "" {
+		m["url"] = "[redacted]"
+	}
+}
+
+// AuditDiff returns the fields that differ between two snapshots. A nil before
+// (create) or after (delete) yields every field of the other side.
+func AuditDiff(before, after map[string]interface{}) map[string]domain.AuditChange {
+	changes := make(map[string]domain.AuditChange)
+	for k, b := range before {
+		if auditIgnoredFields[k] {
+			continue
+		}
+		a, ok := after[k]
+		if !ok || !reflect.DeepEqual(a, b) {
+			changes[k] = domain.AuditChange{Before: b, After: a}
+		}
+	}
+	for k, a := range after {
+		if auditIgnoredFields[k] {
+			continue
+		}
+		if _, ok := before[k]; !ok {
+			changes[k] = domain.AuditChange{After: a}
+		}
+	}
+	return changes
+}
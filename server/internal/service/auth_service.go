@@ -3,7 +3,6 @@ package service
 import (
 	"context"
 	"fmt"
-	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
@@ -13,27 +12,37 @@ import (
 )
 
 type AuthService struct {
-	userRepo *repository.UserRepository
-	config   domain.JWTConfig
+	userRepo         *repository.UserRepository
+	sessionService   *SessionService
+	twoFactorService *TwoFactorService
+	config           domain.JWTConfig
 }
 
-func NewAuthService(userRepo *repository.UserRepository, config domain.JWTConfig) *AuthService {
+func NewAuthService(userRepo *repository.UserRepository, sessionService *SessionService, config domain.JWTConfig) *AuthService {
 	return &AuthService{
-		userRepo: userRepo,
-		config:   config,
+		userRepo:       userRepo,
+		sessionService: sessionService,
+		config:         config,
 	}
 }
 
+// SetTwoFactorService makes logins of accounts with 2FA (or required to have
+// it) return a TwoFactorRequiredError instead of a session.
+func (s *AuthService) SetTwoFactorService(twoFactorService *TwoFactorService) {
+	s.twoFactorService = twoFactorService
+}
+
 type JWTClaims struct {
-	UserID uuid.UUID `json:"user_id"`
-	Email  string    `json:"email"`
-	Role   string    `json:"role"`
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	SessionID uuid.UUID `json:"sid,omitempty"` // auth_sessions row; checked on every request
 	jwt.RegisteredClaims
 }
 
-func (s *AuthService) Login(ctx context.Context, req *domain.LoginRequest) (*domain.LoginResponse, error) {
+func (s *AuthService) Login(ctx context.Context, req *domain.LoginRequest, client domain.ClientInfo) (*domain.LoginResponse, error) {
 	user, err := s.userRepo.GetByEmail(ctx, req.Email)
-	if err != nil {
+	if err != nil || !user.Active || user.PasswordHash == "" {
 		return nil, fmt.Errorf("invalid credentials")
 	}
 
@@ -41,14 +50,26 @@ func (s *AuthService) Login(ctx context.Context, req *domain.LoginRequest) (*dom
 		return nil, fmt.Errorf("invalid credentials")
 	}
 
-	token, err := s.generateToken(user)
+	if s.twoFactorService != nil {
+		challenge, err := s.twoFactorService.LoginChallenge(ctx, domain.SessionSubjectUser, user.ID, user.Role)
+		if err != nil {
+			return nil, fmt.Errorf("two-factor check: %w", err)
+		}
+		if challenge != nil {
+			return nil, &TwoFactorRequiredError{Challenge: challenge}
+		}
+	}
+
+	pair, err := s.sessionService.IssueForUser(ctx, user, client)
 	if err != nil {
 		return nil, fmt.Errorf("generate token: %w", err)
 	}
 
 	return &domain.LoginResponse{
-		Token: token,
-		User:  *user,
+		Token:        pair.Token,
+		RefreshToken: pair.RefreshToken,
+		ExpiresAt:    pair.ExpiresAt,
+		User:         *user,
 	}, nil
 }
 
@@ -78,19 +99,3 @@ func (s *AuthService) HashPassword(password string) (string, error) {
 	}
 	return string(hash), nil
 }
-
-func (s *AuthService) generateToken(user *domain.User) (string, error) {
-	claims := JWTClaims{
-		UserID: user.ID,
-		Email:  user.Email,
-		Role:   user.Role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(s.config.ExpireHours) * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "mobileproxy",
-		},
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(s.config.Secret))
-}
@@ -2,6 +2,8 @@ package service
 
 import (
 	"context"
+	"fmt"
+	"sort"
 	"time"
 
 	"github.com/google/uuid"
@@ -9,6 +11,10 @@ import (
 	"github.com/mobileproxy/server/internal/repository"
 )
 
+// rollupLag keeps the rollup job behind NOW() so transactions still in
+// flight when it runs are not skipped by the watermark.
+const rollupLag = time.Minute
+
 type BandwidthService struct {
 	bwRepo *repository.BandwidthRepository
 }
@@ -53,6 +59,55 @@ func (s *BandwidthService) GetDeviceHourly(ctx context.Context, deviceID uuid.UU
 	return s.bwRepo.GetDeviceHourly(ctx, deviceID, date, tzOffsetMinutes)
 }
 
+func (s *BandwidthService) GetConnectionTodayTotal(ctx context.Context, connectionID uuid.UUID) (int64, int64, error) {
+	now := time.Now().UTC()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return s.bwRepo.GetConnectionTotal(ctx, connectionID, start, start.AddDate(0, 0, 1))
+}
+
+func (s *BandwidthService) GetConnectionMonthTotal(ctx context.Context, connectionID uuid.UUID) (int64, int64, error) {
+	now := time.Now().UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return s.bwRepo.GetConnectionTotal(ctx, connectionID, start, start.AddDate(0, 1, 0))
+}
+
+func (s *BandwidthService) GetConnectionHourly(ctx context.Context, connectionID uuid.UUID, date time.Time, tzOffsetMinutes int) ([]domain.BandwidthHourly, error) {
+	return s.bwRepo.GetConnectionHourly(ctx, connectionID, date, tzOffsetMinutes)
+}
+
+func (s *BandwidthService) GetConnectionDaily(ctx context.Context, connectionID uuid.UUID, endDate time.Time, days, tzOffsetMinutes int) ([]domain.BandwidthDaily, error) {
+	return s.bwRepo.GetConnectionDaily(ctx, connectionID, endDate, days, tzOffsetMinutes)
+}
+
+// ApplyReport applies a relay's per-connection deltas exactly once per
+// (relay_id, seq). Returns false if the report was a duplicate.
+func (s *BandwidthService) ApplyReport(ctx context.Context, report *domain.BandwidthReport) (bool, error) {
+	if report.IntervalEnd.IsZero() {
+		report.IntervalEnd = time.Now().UTC()
+	}
+	if report.IntervalStart.IsZero() || report.IntervalStart.After(report.IntervalEnd) {
+		report.IntervalStart = report.IntervalEnd
+	}
+	deltas := make(map[uuid.UUID]domain.BandwidthDelta, len(report.Connections))
+	for idStr, d := range report.Connections {
+		id, err := uuid.Parse(idStr)
+		if err != nil || d.BytesUp < 0 || d.BytesDown < 0 {
+			continue
+		}
+		if d.BytesUp == 0 && d.BytesDown == 0 {
+			continue
+		}
+		deltas[id] = d
+	}
+	return s.bwRepo.ApplyReport(ctx, report, deltas)
+}
+
+// PruneReports trims the report idempotency ledger. Relays never retry a
+// report for anywhere near this long.
+func (s *BandwidthService) PruneReports(ctx context.Context, retention time.Duration) (int64, error) {
+	return s.bwRepo.DeleteReportsBefore(ctx, time.Now().Add(-retention))
+}
+
 func (s *BandwidthService) EnsurePartitions(ctx context.Context) error {
 	now := time.Now()
 	// Ensure current month and next month partitions exist
@@ -62,3 +117,83 @@ func (s *BandwidthService) EnsurePartitions(ctx context.Context) error {
 	next := now.AddDate(0, 1, 0)
 	return s.bwRepo.EnsurePartition(ctx, next.Year(), next.Month())
 }
+
+// RunRollup folds raw rows recorded since the last run into the hourly and
+// daily rollups. Every touched hour (and its UTC day) is recomputed from
+// scratch, so late relay reports land in the right bucket. Returns the
+// number of hours recomputed.
+func (s *BandwidthService) RunRollup(ctx context.Context) (int, error) {
+	from, err := s.bwRepo.GetRollupWatermark(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("get watermark: %w", err)
+	}
+	to := time.Now().UTC().Add(-rollupLag)
+	if !to.After(from) {
+		return 0, nil
+	}
+
+	hours, err := s.bwRepo.ListTouchedHours(ctx, from, to)
+	if err != nil {
+		return 0, fmt.Errorf("list touched hours: %w", err)
+	}
+	sort.Slice(hours, func(i, j int) bool { return hours[i].Before(hours[j]) })
+
+	days := make(map[time.Time]bool)
+	for _, h := range hours {
+		if err := s.bwRepo.RollupHour(ctx, h); err != nil {
+			return 0, err
+		}
+		days[h.Truncate(24*time.Hour)] = true
+	}
+	for d := range days {
+		if err := s.bwRepo.RollupDay(ctx, d); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := s.bwRepo.SetRollupWatermark(ctx, to); err != nil {
+		return 0, fmt.Errorf("set watermark: %w", err)
+	}
+	return len(hours), nil
+}
+
+// PruneRawPartitions detaches monthly raw partitions that ended more than
+// `months` whole months ago and are fully reflected in the rollups. Detached
+// partitions are dropped unless keepDetached is set (e.g. to archive them).
+// Returns the affected partition names.
+func (s *BandwidthService) PruneRawPartitions(ctx context.Context, months int, keepDetached bool) ([]string, error) {
+	if months <= 0 {
+		return nil, nil
+	}
+	now := time.Now().UTC()
+	cutoff := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -months, 0)
+
+	watermark, err := s.bwRepo.GetRollupWatermark(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get watermark: %w", err)
+	}
+	partitions, err := s.bwRepo.ListPartitions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list partitions: %w", err)
+	}
+
+	var pruned []string
+	for name, month := range partitions {
+		end := month.AddDate(0, 1, 0)
+		if end.After(cutoff) || end.After(watermark) {
+			continue
+		}
+		if err := s.bwRepo.DetachPartition(ctx, name, keepDetached); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, name)
+	}
+	sort.Strings(pruned)
+	return pruned, nil
+}
+
+// PruneHourly drops hourly rollups older than the retention window. Daily
+// rollups are kept indefinitely.
+func (s *BandwidthService) PruneHourly(ctx context.Context, retention time.Duration) (int64, error) {
+	return s.bwRepo.DeleteHourlyBefore(ctx, time.Now().UTC().Add(-retention))
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/repository"
+)
+
+// ErrBillingDisabled is returned when no payment provider is configured.
+var ErrBillingDisabled = errors.New("billing is not configured")
+
+// BillingService sells plans through a PaymentProvider. Provider webhooks
+// assign the paid plan and suspend or reactivate the customer (the same
+// customers.active flag CustomerHandler.Suspend/Activate toggle). Billing only
+// reactivates customers it suspended itself, so an admin suspension sticks.
+type BillingService struct {
+	provider     PaymentProvider // nil = billing disabled
+	billingRepo  *repository.BillingRepository
+	customerRepo *repository.CustomerRepository
+	planService  *PlanService
+}
+
+func NewBillingService(provider PaymentProvider, billingRepo *repository.BillingRepository, customerRepo *repository.CustomerRepository, planService *PlanService) *BillingService {
+	return &BillingService{provider: provider, billingRepo: billingRepo, customerRepo: customerRepo, planService: planService}
+}
+
+// GetAccount returns a customer's billing account, or nil if they never checked out.
+func (s *BillingService) GetAccount(ctx context.Context, customerID uuid.UUID) (*domain.BillingAccount, error) {
+	return s.billingRepo.GetAccount(ctx, customerID)
+}
+
+// AdminOverride is called when an admin suspends or activates a customer by
+// hand; from then on billing no longer lifts that suspension.
+func (s *BillingService) AdminOverride(ctx context.Context, customerID uuid.UUID) error {
+	return s.billingRepo.ClearSuspended(ctx, customerID)
+}
+
+// CreateCheckout starts a checkout for an active plan.
+func (s *BillingService) CreateCheckout(ctx context.Context, customerID, planID uuid.UUID) (*domain.CheckoutSession, error) {
+	if s.provider == nil {
+		return nil, ErrBillingDisabled
+	}
+	customer, err := s.customerRepo.GetByID(ctx, customerID)
+	if err != nil {
+		return nil, fmt.Errorf("customer not found")
+	}
+	plan, err := s.planService.Get(ctx, planID)
+	if err != nil {
+		return nil, fmt.Errorf("plan not found")
+	}
+	if !plan.Active {
+		return nil, fmt.Errorf("plan %s is not available", plan.Name)
+	}
+	session, err := s.provider.CreateCheckout(customer, plan)
+	if err != nil {
+		return nil, fmt.Errorf("create checkout: %w", err)
+	}
+	return session, nil
+}
+
+// HandleWebhook verifies and applies a provider webhook. Each event is applied
+// once; a failed event is forgotten so the provider's retry goes through.
+func (s *BillingService) HandleWebhook(ctx context.Context, payload []byte, header http.Header) error {
+	if s.provider == nil {
+		return ErrBillingDisabled
+	}
+	evt, err := s.provider.ParseWebhook(payload, header)
+	if err != nil {
+		return err
+	}
+	if evt.Type == "" {
+		return nil
+	}
+
+	first, err := s.billingRepo.RecordEvent(ctx, s.provider.Name(), evt.ID, evt.Type, evt.CustomerID)
+	if err != nil {
+		return fmt.Errorf("record event: %w", err)
+	}
+	if !first {
+		return nil
+	}
+	if err := s.apply(ctx, evt); err != nil {
+		if delErr := s.billingRepo.DeleteEvent(ctx, s.provider.Name(), evt.ID); delErr != nil {
+			log.Printf("[billing] forget event %s: %v", evt.ID, delErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *BillingService) apply(ctx context.Context, evt *domain.BillingEvent) error {
+	if evt.Type == domain.BillingEventCheckoutCompleted {
+		return s.applyCheckout(ctx, evt)
+	}
+
+	acct, err := s.billingRepo.GetAccountBySubscription(ctx, s.provider.Name(), evt.SubscriptionID)
+	if err != nil {
+		return err
+	}
+	if acct == nil {
+		log.Printf("[billing] %s for unknown subscription %q, ignoring", evt.Type, evt.SubscriptionID)
+		return nil
+	}
+
+	switch evt.Type {
+	case domain.BillingEventPaymentSucceeded:
+		acct.Status = domain.BillingActive
+		if err := s.resume(ctx, acct); err != nil {
+			return err
+		}
+	case domain.BillingEventPaymentFailed:
+		acct.Status = domain.BillingPastDue
+		if err := s.suspend(ctx, acct); err != nil {
+			return err
+		}
+	case domain.BillingEventSubscriptionCanceled:
+		acct.Status = domain.BillingCanceled
+		if err := s.planService.AssignToCustomer(ctx, acct.CustomerID, nil); err != nil {
+			return fmt.Errorf("clear plan: %w", err)
+		}
+		if err := s.suspend(ctx, acct); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	log.Printf("[billing] customer %s: %s -> %s", acct.CustomerID, evt.Type, acct.Status)
+	return s.billingRepo.UpsertAccount(ctx, acct)
+}
+
+func (s *BillingService) applyCheckout(ctx context.Context, evt *domain.BillingEvent) error {
+	if evt.CustomerID == nil || evt.PlanID == nil {
+		return fmt.Errorf("checkout event %s is missing customer or plan", evt.ID)
+	}
+	acct, err := s.billingRepo.GetAccount(ctx, *evt.CustomerID)
+	if err != nil {
+		return err
+	}
+	if acct == nil {
+		acct = &domain.BillingAccount{CustomerID: *evt.CustomerID}
+	}
+	acct.Provider = s.provider.Name()
+	acct.ProviderCustomerID = evt.ProviderCustomerID
+	acct.SubscriptionID = evt.SubscriptionID
+	acct.PlanID = evt.PlanID
+	acct.Status = domain.BillingActive
+
+	if err := s.planService.AssignToCustomer(ctx, acct.CustomerID, evt.PlanID); err != nil {
+		return fmt.Errorf("assign plan: %w", err)
+	}
+	if err := s.resume(ctx, acct); err != nil {
+		return err
+	}
+	log.Printf("[billing] customer %s subscribed to plan %s", acct.CustomerID, *evt.PlanID)
+	return s.billingRepo.UpsertAccount(ctx, acct)
+}
+
+// suspend deactivates the customer unless they already are (e.g. by an admin).
+func (s *BillingService) suspend(ctx context.Context, acct *domain.BillingAccount) error {
+	customer, err := s.customerRepo.GetByID(ctx, acct.CustomerID)
+	if err != nil {
+		return fmt.Errorf("get customer: %w", err)
+	}
+	if !customer.Active {
+		return nil
+	}
+	if err := s.customerRepo.UpdateActive(ctx, acct.CustomerID, false); err != nil {
+		return fmt.Errorf("suspend customer: %w", err)
+	}
+	acct.Suspended = true
+	return nil
+}
+
+// resume reactivates the customer if billing suspended them.
+func (s *BillingService) resume(ctx context.Context, acct *domain.BillingAccount) error {
+	if !acct.Suspended {
+		return nil
+	}
+	if err := s.customerRepo.UpdateActive(ctx, acct.CustomerID, true); err != nil {
+		return fmt.Errorf("activate customer: %w", err)
+	}
+	acct.Suspended = false
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -17,6 +18,11 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+var (
+	ErrConnectionNotFound = errors.New("connection not found")
+	ErrInvalidLimits      = errors.New("limits must not be negative")
+)
+
 type ConnectionService struct {
 	connRepo        *repository.ConnectionRepository
 	deviceRepo      *repository.DeviceRepository
@@ -24,6 +30,10 @@ type ConnectionService struct {
 	portService     *PortService
 	tunnelPushURL   string // fallback static URL
 	syncService     *SyncService
+	aclRepo         *repository.ACLRepository
+	quotaRepo       *repository.QuotaRepository
+	planService     *PlanService
+	webhookService  *WebhookService
 }
 
 func (s *ConnectionService) SetSyncService(ss *SyncService) {
@@ -37,6 +47,23 @@ func NewConnectionService(connRepo *repository.ConnectionRepository, deviceRepo
 	}
 }
 
+func (s *ConnectionService) SetACLRepo(repo *repository.ACLRepository) {
+	s.aclRepo = repo
+}
+
+func (s *ConnectionService) SetQuotaRepo(repo *repository.QuotaRepository) {
+	s.quotaRepo = repo
+}
+
+func (s *ConnectionService) SetPlanService(ps *PlanService) {
+	s.planService = ps
+}
+
+// SetWebhookService enables connection.expired webhook events.
+func (s *ConnectionService) SetWebhookService(ws *WebhookService) {
+	s.webhookService = ws
+}
+
 func (s *ConnectionService) SetPortService(ps *PortService) {
 	s.portService = ps
 }
@@ -75,6 +102,15 @@ func (s *ConnectionService) Create(ctx context.Context, req *domain.CreateConnec
 	if proxyType != "http" && proxyType != "socks5" && proxyType != "openvpn" {
 		return nil, fmt.Errorf("invalid proxy_type: must be 'http', 'socks5', or 'openvpn'")
 	}
+	if req.MaxConcurrentConns < 0 || req.MaxConnsPerSecond < 0 {
+		return nil, fmt.Errorf("invalid limits: must be 0 (unlimited) or positive")
+	}
+	var planCheck func(connections int) error
+	if req.CustomerID != nil && s.planService != nil {
+		if planCheck, err = s.planService.CheckConnectionCreate(ctx, *req.CustomerID, proxyType); err != nil {
+			return nil, err
+		}
+	}
 
 	// Reject duplicate usernames for the same device
 	exists, err := s.connRepo.ExistsByDeviceAndUsername(ctx, req.DeviceID, req.Username)
@@ -93,17 +129,19 @@ func (s *ConnectionService) Create(ctx context.Context, req *domain.CreateConnec
 
 	plaintext := req.Password
 	conn := &domain.ProxyConnection{
-		ID:             uuid.New(),
-		DeviceID:       req.DeviceID,
-		CustomerID:     req.CustomerID,
-		Username:       req.Username,
-		PasswordHash:   string(hash),
-		PasswordPlain:  &plaintext,
-		Password:       req.Password,
-		IPWhitelist:    req.IPWhitelist,
-		BandwidthLimit: req.BandwidthLimit,
-		Active:         true,
-		ProxyType:      proxyType,
+		ID:                 uuid.New(),
+		DeviceID:           req.DeviceID,
+		CustomerID:         req.CustomerID,
+		Username:           req.Username,
+		PasswordHash:       string(hash),
+		PasswordPlain:      &plaintext,
+		Password:           req.Password,
+		IPWhitelist:        req.IPWhitelist,
+		BandwidthLimit:     req.BandwidthLimit,
+		MaxConcurrentConns: req.MaxConcurrentConns,
+		MaxConnsPerSecond:  req.MaxConnsPerSecond,
+		Active:             true,
+		ProxyType:          proxyType,
 	}
 	if conn.IPWhitelist == nil {
 		conn.IPWhitelist = []string{}
@@ -124,7 +162,7 @@ func (s *ConnectionService) Create(ctx context.Context, req *domain.CreateConnec
 		}
 	}
 
-	if err := s.connRepo.Create(ctx, conn); err != nil {
+	if err := s.connRepo.Create(ctx, conn, planCheck); err != nil {
 		return nil, fmt.Errorf("create connection: %w", err)
 	}
 
@@ -132,7 +170,7 @@ func (s *ConnectionService) Create(ctx context.Context, req *domain.CreateConnec
 	// Skip DNAT for openvpn — it uses the shared VPN server port, not per-connection ports
 	tunnelURL := s.getTunnelPushURL(ctx, device)
 	if conn.BasePort != nil && device.VpnIP != "" && tunnelURL != "" && proxyType != "openvpn" {
-		go s.refreshDNAT(tunnelURL, device.ID.String(), *conn.BasePort, device.VpnIP, proxyType, conn.Username)
+		go s.refreshDNAT(tunnelURL, device.ID.String(), *conn.BasePort, device.VpnIP, proxyType, conn.Username, conn.ID.String())
 	}
 
 	// Sync all connections for this device to peer server
@@ -185,6 +223,16 @@ func (s *ConnectionService) ListByDeviceForCustomer(ctx context.Context, deviceI
 	return s.connRepo.ListByDeviceForCustomer(ctx, deviceID, customerID)
 }
 
+// ListByReseller returns connections on devices allocated to a reseller.
+func (s *ConnectionService) ListByReseller(ctx context.Context, resellerID uuid.UUID) ([]domain.ProxyConnection, error) {
+	return s.connRepo.ListByReseller(ctx, resellerID)
+}
+
+// ListByDeviceForReseller returns connections for a device only if it is allocated to the reseller.
+func (s *ConnectionService) ListByDeviceForReseller(ctx context.Context, deviceID uuid.UUID, resellerID uuid.UUID) ([]domain.ProxyConnection, error) {
+	return s.connRepo.ListByDeviceForReseller(ctx, deviceID, resellerID)
+}
+
 func (s *ConnectionService) SetActive(ctx context.Context, id uuid.UUID, active bool) error {
 	return s.connRepo.UpdateActive(ctx, id, active)
 }
@@ -200,6 +248,18 @@ func (s *ConnectionService) Delete(ctx context.Context, id uuid.UUID) error {
 		return err
 	}
 
+	// acl_rules has no FK to proxy_connections (peer sync replaces rows), clean up here
+	if s.aclRepo != nil {
+		if err := s.aclRepo.DeleteByConnection(ctx, id); err != nil {
+			log.Printf("Delete ACL rules for connection %s failed: %v", id, err)
+		}
+	}
+	if s.quotaRepo != nil {
+		if err := s.quotaRepo.Delete(ctx, id); err != nil {
+			log.Printf("Delete quota for connection %s failed: %v", id, err)
+		}
+	}
+
 	// Tear down DNAT for the connection's port
 	if conn.BasePort != nil {
 		device, err := s.deviceRepo.GetByID(ctx, conn.DeviceID)
@@ -251,13 +311,14 @@ func (s *ConnectionService) RegeneratePassword(ctx context.Context, id uuid.UUID
 	return newPass, nil
 }
 
-func (s *ConnectionService) refreshDNAT(tunnelURL string, deviceID string, basePort int, vpnIP string, proxyType string, username string) {
+func (s *ConnectionService) refreshDNAT(tunnelURL string, deviceID string, basePort int, vpnIP string, proxyType string, username string, connectionID string) {
 	body, _ := json.Marshal(map[string]interface{}{
-		"device_id":  deviceID,
-		"base_port":  basePort,
-		"vpn_ip":     vpnIP,
-		"proxy_type": proxyType,
-		"username":   username,
+		"device_id":     deviceID,
+		"base_port":     basePort,
+		"vpn_ip":        vpnIP,
+		"proxy_type":    proxyType,
+		"username":      username,
+		"connection_id": connectionID,
 	})
 	client := &http.Client{Timeout: 3 * time.Second}
 	resp, err := client.Post(tunnelURL+"/refresh-dnat", "application/json", strings.NewReader(string(body)))
@@ -269,13 +330,111 @@ func (s *ConnectionService) refreshDNAT(tunnelURL string, deviceID string, baseP
 	log.Printf("Refresh DNAT sent for device=%s port=%d type=%s via %s", deviceID, basePort, proxyType, tunnelURL)
 }
 
-func (s *ConnectionService) UpdateBandwidthUsedByUsername(ctx context.Context, username string, used int64) error {
-	return s.connRepo.UpdateBandwidthUsed(ctx, username, used)
+// SetLimits updates a connection's session limits. Relays pick up the change
+// on their next limits sync.
+func (s *ConnectionService) SetLimits(ctx context.Context, id uuid.UUID, maxConcurrent, perSecond int) (*domain.ProxyConnection, error) {
+	if maxConcurrent < 0 || perSecond < 0 {
+		return nil, ErrInvalidLimits
+	}
+	if _, err := s.connRepo.GetByID(ctx, id); err != nil {
+		return nil, ErrConnectionNotFound
+	}
+	if err := s.connRepo.UpdateLimits(ctx, id, maxConcurrent, perSecond); err != nil {
+		return nil, fmt.Errorf("update limits: %w", err)
+	}
+	conn, err := s.connRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sync all connections for this device to peer server
+	if s.syncService != nil {
+		conns, err := s.connRepo.ListByDevice(ctx, conn.DeviceID)
+		if err == nil {
+			go s.syncService.SyncConnections(conn.DeviceID, conns)
+		}
+	}
+	return conn, nil
+}
+
+// SetBandwidthLimit updates a connection's byte cap and syncs it to the peer.
+func (s *ConnectionService) SetBandwidthLimit(ctx context.Context, id uuid.UUID, limit int64) (*domain.ProxyConnection, error) {
+	if err := s.connRepo.UpdateBandwidthLimit(ctx, id, limit); err != nil {
+		return nil, fmt.Errorf("update bandwidth limit: %w", err)
+	}
+	conn, err := s.connRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sync all connections for this device to peer server
+	if s.syncService != nil {
+		conns, err := s.connRepo.ListByDevice(ctx, conn.DeviceID)
+		if err == nil {
+			go s.syncService.SyncConnections(conn.DeviceID, conns)
+		}
+	}
+	return conn, nil
+}
+
+// GetLimitsSnapshot returns session limits and quota state for every limited
+// or over-quota connection, keyed by connection ID.
+func (s *ConnectionService) GetLimitsSnapshot(ctx context.Context) (map[string]domain.ConnectionLimits, error) {
+	conns, err := s.connRepo.ListLimited(ctx)
+	if err != nil {
+		return nil, err
+	}
+	limits := make(map[string]domain.ConnectionLimits, len(conns))
+	for _, c := range conns {
+		limits[c.ID.String()] = domain.ConnectionLimits{
+			MaxConcurrentConns: c.MaxConcurrentConns,
+			MaxConnsPerSecond:  c.MaxConnsPerSecond,
+		}
+	}
+
+	if s.quotaRepo != nil {
+		over, err := s.quotaRepo.ListOverQuota(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list over quota: %w", err)
+		}
+		for id, q := range over {
+			l := limits[id.String()]
+			l.OverQuota, l.ThrottleKbps = q.OverQuota, q.ThrottleKbps
+			limits[id.String()] = l
+		}
+	}
+
+	// Customers past their plan's monthly GB are cut, overriding any throttle
+	if s.planService != nil {
+		ids, err := s.planService.ConnectionsOverMonthlyCap(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list over plan cap: %w", err)
+		}
+		for _, id := range ids {
+			l := limits[id.String()]
+			l.OverQuota, l.ThrottleKbps = true, 0
+			limits[id.String()] = l
+		}
+	}
+	return limits, nil
+}
+
+// RecordRejections adds relay-reported rejection deltas to the connection counters.
+func (s *ConnectionService) RecordRejections(ctx context.Context, data map[string]domain.ConnectionRejections) {
+	for idStr, r := range data {
+		id, err := uuid.Parse(idStr)
+		if err != nil {
+			continue
+		}
+		if err := s.connRepo.AddRejections(ctx, id, r.Concurrent, r.Rate); err != nil {
+			log.Printf("[rejections] failed for %s: %v", idStr, err)
+		}
+	}
 }
 
-func (s *ConnectionService) SyncBandwidth(data map[string]int64) {
+func (s *ConnectionService) SyncBandwidthReport(report *domain.BandwidthReport) {
 	if s.syncService != nil {
-		go s.syncService.SyncBandwidth(data)
+		go s.syncService.SyncBandwidthReport(report)
 	}
 }
 
@@ -283,7 +442,8 @@ func (s *ConnectionService) ResetBandwidth(ctx context.Context, id uuid.UUID) er
 	if err := s.connRepo.ResetBandwidthUsed(ctx, id); err != nil {
 		return err
 	}
-	// Best-effort: reset tunnel in-memory counter to prevent 30s flush overwriting the zero
+	// Best-effort: have the tunnel re-pull quota state so a cut or throttled
+	// connection recovers now rather than at the next limits sync.
 	conn, err := s.connRepo.GetByID(ctx, id)
 	if err != nil {
 		return nil // DB reset succeeded; tunnel reset is best-effort
@@ -326,3 +486,37 @@ func (s *ConnectionService) teardownDNAT(tunnelURL string, deviceID string, base
 	resp.Body.Close()
 	log.Printf("Teardown DNAT sent for device=%s port=%d type=%s via %s", deviceID, basePort, proxyType, tunnelURL)
 }
+
+// NotifyExpired queues a connection.expired event for every connection that
+// has passed its expiry since the last run. Returns the number of events.
+func (s *ConnectionService) NotifyExpired(ctx context.Context) (int, error) {
+	if s.webhookService == nil {
+		return 0, nil
+	}
+	conns, err := s.connRepo.ListExpiredUnnotified(ctx)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, c := range conns {
+		ok, err := s.connRepo.MarkExpiryNotified(ctx, c.ID)
+		if err != nil {
+			return count, err
+		}
+		if !ok {
+			continue
+		}
+		err = s.webhookService.Emit(ctx, domain.WebhookEventConnectionExpired, c.DeviceID, c.CustomerID, map[string]interface{}{
+			"connection_id": c.ID.String(),
+			"username":      c.Username,
+			"device_id":     c.DeviceID.String(),
+			"expired_at":    c.ExpiresAt.UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			log.Printf("[webhook] queue expiry event failed for connection %s: %v", c.Username, err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
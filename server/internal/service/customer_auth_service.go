@@ -13,7 +13,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/mobileproxy/server/internal/domain"
 	"github.com/mobileproxy/server/internal/repository"
@@ -25,12 +24,13 @@ import (
 // CustomerAuthService implements all customer authentication flows:
 // signup, login, email verification, password reset, and Google OAuth.
 type CustomerAuthService struct {
-	customerRepo *repository.CustomerRepository
-	tokenRepo    *repository.CustomerTokenRepository
-	emailService *EmailService
-	jwtConfig    domain.JWTConfig
-	googleConfig domain.GoogleOAuthConfig
-	turnstileCfg domain.TurnstileConfig
+	customerRepo     *repository.CustomerRepository
+	tokenRepo        *repository.CustomerTokenRepository
+	emailService     *EmailService
+	sessionService   *SessionService
+	twoFactorService *TwoFactorService
+	googleConfig     domain.GoogleOAuthConfig
+	turnstileCfg     domain.TurnstileConfig
 }
 
 // NewCustomerAuthService constructs a CustomerAuthService with all required dependencies.
@@ -38,20 +38,26 @@ func NewCustomerAuthService(
 	customerRepo *repository.CustomerRepository,
 	tokenRepo *repository.CustomerTokenRepository,
 	emailService *EmailService,
-	jwtConfig domain.JWTConfig,
+	sessionService *SessionService,
 	googleConfig domain.GoogleOAuthConfig,
 	turnstileCfg domain.TurnstileConfig,
 ) *CustomerAuthService {
 	return &CustomerAuthService{
-		customerRepo: customerRepo,
-		tokenRepo:    tokenRepo,
-		emailService: emailService,
-		jwtConfig:    jwtConfig,
-		googleConfig: googleConfig,
-		turnstileCfg: turnstileCfg,
+		customerRepo:   customerRepo,
+		tokenRepo:      tokenRepo,
+		emailService:   emailService,
+		sessionService: sessionService,
+		googleConfig:   googleConfig,
+		turnstileCfg:   turnstileCfg,
 	}
 }
 
+// SetTwoFactorService makes logins of customers with 2FA enabled return a
+// TwoFactorRequiredError instead of a session.
+func (s *CustomerAuthService) SetTwoFactorService(twoFactorService *TwoFactorService) {
+	s.twoFactorService = twoFactorService
+}
+
 // ─── Private helpers ───────────────────────────────────────────────────────────
 
 // generateToken creates a cryptographically random 32-byte token.
@@ -107,21 +113,28 @@ func (s *CustomerAuthService) verifyTurnstile(token, remoteIP string) (bool, err
 	return result.Success, nil
 }
 
-// generateCustomerJWT mints a signed JWT for a customer with role "customer".
-// Expires in 24 hours.
-func (s *CustomerAuthService) generateCustomerJWT(customer *domain.Customer) (string, error) {
-	claims := JWTClaims{
-		UserID: customer.ID,
-		Email:  customer.Email,
-		Role:   "customer",
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "mobileproxy",
-		},
+// loginResponse starts a session for customer and wraps its tokens, unless
+// the customer must pass a second factor first.
+func (s *CustomerAuthService) loginResponse(ctx context.Context, customer *domain.Customer, client domain.ClientInfo) (*domain.CustomerLoginResponse, error) {
+	if s.twoFactorService != nil {
+		challenge, err := s.twoFactorService.LoginChallenge(ctx, domain.SessionSubjectCustomer, customer.ID, "customer")
+		if err != nil {
+			return nil, fmt.Errorf("two-factor check: %w", err)
+		}
+		if challenge != nil {
+			return nil, &TwoFactorRequiredError{Challenge: challenge}
+		}
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(s.jwtConfig.Secret))
+	pair, err := s.sessionService.IssueForCustomer(ctx, customer, client)
+	if err != nil {
+		return nil, fmt.Errorf("generate token: %w", err)
+	}
+	return &domain.CustomerLoginResponse{
+		Token:        pair.Token,
+		RefreshToken: pair.RefreshToken,
+		ExpiresAt:    pair.ExpiresAt,
+		Customer:     *customer,
+	}, nil
 }
 
 // googleOAuthConfig builds the oauth2.Config for Google.
@@ -218,8 +231,8 @@ func (s *CustomerAuthService) issueVerificationToken(ctx context.Context, custom
 // Returns a specific "email not verified" error (code "email_not_verified") if
 // the account exists but has not yet verified its email, so the frontend can
 // offer a resend option.
-func (s *CustomerAuthService) Login(ctx context.Context, req *domain.CustomerLoginRequest, remoteIP string) (*domain.CustomerLoginResponse, error) {
-	ok, err := s.verifyTurnstile(req.TurnstileToken, remoteIP)
+func (s *CustomerAuthService) Login(ctx context.Context, req *domain.CustomerLoginRequest, client domain.ClientInfo) (*domain.CustomerLoginResponse, error) {
+	ok, err := s.verifyTurnstile(req.TurnstileToken, client.IP)
 	if err != nil {
 		return nil, fmt.Errorf("turnstile check: %w", err)
 	}
@@ -245,15 +258,7 @@ func (s *CustomerAuthService) Login(ctx context.Context, req *domain.CustomerLog
 		return nil, fmt.Errorf("email_not_verified")
 	}
 
-	jwtToken, err := s.generateCustomerJWT(customer)
-	if err != nil {
-		return nil, fmt.Errorf("generate token: %w", err)
-	}
-
-	return &domain.CustomerLoginResponse{
-		Token:    jwtToken,
-		Customer: *customer,
-	}, nil
+	return s.loginResponse(ctx, customer, client)
 }
 
 // VerifyEmailCheck reports whether a raw email-verification token is valid
@@ -270,7 +275,7 @@ func (s *CustomerAuthService) VerifyEmailCheck(ctx context.Context, rawToken str
 
 // VerifyEmail consumes an email-verification token, marks the customer's email
 // as verified, and returns a JWT so the user is automatically logged in.
-func (s *CustomerAuthService) VerifyEmail(ctx context.Context, rawToken string) (*domain.CustomerLoginResponse, error) {
+func (s *CustomerAuthService) VerifyEmail(ctx context.Context, rawToken string, client domain.ClientInfo) (*domain.CustomerLoginResponse, error) {
 	hashed := hashToken(rawToken)
 	token, err := s.tokenRepo.GetByHash(ctx, hashed)
 	if err != nil {
@@ -290,15 +295,7 @@ func (s *CustomerAuthService) VerifyEmail(ctx context.Context, rawToken string)
 		return nil, fmt.Errorf("fetch customer: %w", err)
 	}
 
-	jwtToken, err := s.generateCustomerJWT(customer)
-	if err != nil {
-		return nil, fmt.Errorf("generate token: %w", err)
-	}
-
-	return &domain.CustomerLoginResponse{
-		Token:    jwtToken,
-		Customer: *customer,
-	}, nil
+	return s.loginResponse(ctx, customer, client)
 }
 
 // ResendVerification re-issues an email-verification link. Silently succeeds
@@ -362,8 +359,8 @@ func (s *CustomerAuthService) ForgotPassword(ctx context.Context, req *domain.Fo
 	return s.emailService.SendPasswordReset(email, raw)
 }
 
-// ResetPassword validates a password-reset token and updates the customer's
-// password hash.
+// ResetPassword validates a password-reset token, updates the customer's
+// password hash and logs them out everywhere.
 func (s *CustomerAuthService) ResetPassword(ctx context.Context, req *domain.ResetPasswordRequest) error {
 	if req.Password != req.ConfirmPassword {
 		return fmt.Errorf("passwords do not match")
@@ -388,7 +385,7 @@ func (s *CustomerAuthService) ResetPassword(ctx context.Context, req *domain.Res
 		return fmt.Errorf("update password: %w", err)
 	}
 
-	return nil
+	return s.sessionService.RevokeAll(ctx, domain.SessionSubjectCustomer, token.CustomerID)
 }
 
 // GoogleAuthURL returns the Google OAuth consent-screen URL and the random
@@ -411,8 +408,8 @@ func (s *CustomerAuthService) GoogleAuthURL() (authURL string, state string, err
 //  3. New user — creates an account with email_verified=true and generates JWT.
 //
 // The second return value is the redirect URL the handler should send the user
-// to after storing the JWT.
-func (s *CustomerAuthService) GoogleCallback(ctx context.Context, code, state string) (*domain.CustomerLoginResponse, string, error) {
+// to after storing the JWT. It is also set with a TwoFactorRequiredError.
+func (s *CustomerAuthService) GoogleCallback(ctx context.Context, code, state string, clientInfo domain.ClientInfo) (*domain.CustomerLoginResponse, string, error) {
 	cfg := s.googleOAuthConfig()
 
 	oauthToken, err := cfg.Exchange(ctx, code)
@@ -442,11 +439,8 @@ func (s *CustomerAuthService) GoogleCallback(ctx context.Context, code, state st
 	// Case 1: existing Google-linked account
 	customer, err := s.customerRepo.GetByGoogleID(ctx, userInfo.ID)
 	if err == nil {
-		jwtToken, err := s.generateCustomerJWT(customer)
-		if err != nil {
-			return nil, "", fmt.Errorf("generate token: %w", err)
-		}
-		return &domain.CustomerLoginResponse{Token: jwtToken, Customer: *customer}, s.emailService.baseURL + "/devices", nil
+		loginResp, err := s.loginResponse(ctx, customer, clientInfo)
+		return loginResp, s.emailService.baseURL + "/devices", err
 	}
 
 	// Case 2: existing email account — link Google
@@ -460,11 +454,8 @@ func (s *CustomerAuthService) GoogleCallback(ctx context.Context, code, state st
 		if err != nil {
 			return nil, "", fmt.Errorf("fetch customer after link: %w", err)
 		}
-		jwtToken, err := s.generateCustomerJWT(customer)
-		if err != nil {
-			return nil, "", fmt.Errorf("generate token: %w", err)
-		}
-		return &domain.CustomerLoginResponse{Token: jwtToken, Customer: *customer}, s.emailService.baseURL + "/devices", nil
+		loginResp, err := s.loginResponse(ctx, customer, clientInfo)
+		return loginResp, s.emailService.baseURL + "/devices", err
 	}
 
 	// Case 3: new user
@@ -491,9 +482,6 @@ func (s *CustomerAuthService) GoogleCallback(ctx context.Context, code, state st
 		return nil, "", fmt.Errorf("create google customer: %w", err)
 	}
 
-	jwtToken, err := s.generateCustomerJWT(customer)
-	if err != nil {
-		return nil, "", fmt.Errorf("generate token: %w", err)
-	}
-	return &domain.CustomerLoginResponse{Token: jwtToken, Customer: *customer}, s.emailService.baseURL + "/devices", nil
+	loginResp, err := s.loginResponse(ctx, customer, clientInfo)
+	return loginResp, s.emailService.baseURL + "/devices", err
 }
@@ -1,7 +1,6 @@
 package service
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -15,16 +14,21 @@ import (
 	"github.com/mobileproxy/server/internal/repository"
 )
 
+// lowBatteryPercent is the battery level below which a discharging device
+// triggers a device.battery_low event.
+const lowBatteryPercent = 20
+
 type DeviceService struct {
-	deviceRepo      *repository.DeviceRepository
-	ipHistRepo      *repository.IPHistoryRepository
-	commandRepo     *repository.CommandRepository
-	statusLogRepo   *repository.StatusLogRepository
-	relayServerRepo *repository.RelayServerRepository
-	userRepo        *repository.UserRepository
-	portService     *PortService
-	vpnService      *VPNService
-	tunnelPushURL   string // fallback static URL (e.g. http://178.156.210.156:8081)
+	deviceRepo       *repository.DeviceRepository
+	ipHistRepo       *repository.IPHistoryRepository
+	commandRepo      *repository.CommandRepository
+	statusLogRepo    *repository.StatusLogRepository
+	relayServerRepo  *repository.RelayServerRepository
+	webhookService   *WebhookService
+	telemetryService *TelemetryService
+	portService      *PortService
+	vpnService       *VPNService
+	tunnelPushURL    string // fallback static URL (e.g. http://178.156.210.156:8081)
 }
 
 func NewDeviceService(
@@ -53,9 +57,14 @@ func (s *DeviceService) SetStatusLogRepo(repo *repository.StatusLogRepository) {
 	s.statusLogRepo = repo
 }
 
-// SetUserRepo configures the user repository for webhook dispatch.
-func (s *DeviceService) SetUserRepo(repo *repository.UserRepository) {
-	s.userRepo = repo
+// SetWebhookService enables device, IP and rotation webhook events.
+func (s *DeviceService) SetWebhookService(ws *WebhookService) {
+	s.webhookService = ws
+}
+
+// SetTelemetryService records heartbeat telemetry as a time series.
+func (s *DeviceService) SetTelemetryService(ts *TelemetryService) {
+	s.telemetryService = ts
 }
 
 // SetRelayServerRepo configures the relay server repository for dynamic tunnel URL resolution.
@@ -157,13 +166,26 @@ func (s *DeviceService) Heartbeat(ctx context.Context, deviceID uuid.UUID, req *
 	}
 	// Send recovery webhook if device transitioned from offline to online
 	if wasOffline {
-		go s.sendRecoveryWebhook(ctx, *device)
+		s.sendRecoveryWebhook(ctx, *device)
 	}
 
 	// Update heartbeat
 	if err := s.deviceRepo.UpdateHeartbeat(ctx, deviceID, req); err != nil {
 		return nil, fmt.Errorf("update heartbeat: %w", err)
 	}
+	if s.telemetryService != nil {
+		if err := s.telemetryService.Record(ctx, device, req); err != nil {
+			log.Printf("Record telemetry failed (device=%s): %v", deviceID, err)
+		}
+	}
+
+	// Alert once when the battery drops below the threshold while discharging
+	if req.BatteryLevel > 0 && req.BatteryLevel < lowBatteryPercent && !req.BatteryCharging &&
+		(device.BatteryLevel >= lowBatteryPercent || device.BatteryCharging) {
+		s.emitWebhook(ctx, domain.WebhookEventBatteryLow, *device, map[string]interface{}{
+			"battery_level": req.BatteryLevel,
+		})
+	}
 
 	// Check for IP change
 	if req.CellularIP != "" && req.CellularIP != device.CellularIP {
@@ -174,6 +196,11 @@ func (s *DeviceService) Heartbeat(ctx context.Context, deviceID uuid.UUID, req *
 			Method:   "natural",
 		}
 		_ = s.ipHistRepo.Create(ctx, ipHist)
+		s.emitWebhook(ctx, domain.WebhookEventIPChanged, *device, map[string]interface{}{
+			"old_ip": device.CellularIP,
+			"new_ip": req.CellularIP,
+			"method": ipHist.Method,
+		})
 	}
 
 	// Get pending commands
@@ -214,6 +241,11 @@ func (s *DeviceService) GetByIDForCustomer(ctx context.Context, deviceID uuid.UU
 	return s.deviceRepo.GetByIDForCustomer(ctx, deviceID, customerID)
 }
 
+// ListByReseller returns the devices allocated to a reseller.
+func (s *DeviceService) ListByReseller(ctx context.Context, resellerID uuid.UUID) ([]domain.Device, error) {
+	return s.deviceRepo.ListByReseller(ctx, resellerID)
+}
+
 func (s *DeviceService) SendCommand(ctx context.Context, deviceID uuid.UUID, req *domain.CommandRequest) (*domain.DeviceCommand, error) {
 	cmd := &domain.DeviceCommand{
 		ID:       uuid.New(),
@@ -260,7 +292,13 @@ func (s *DeviceService) pushCommandToTunnel(tunnelURL string, deviceID uuid.UUID
 }
 
 func (s *DeviceService) UpdateCommandStatus(ctx context.Context, commandID uuid.UUID, status domain.CommandStatus, result string) error {
-	return s.commandRepo.UpdateStatus(ctx, commandID, status, result)
+	if err := s.commandRepo.UpdateStatus(ctx, commandID, status, result); err != nil {
+		return err
+	}
+	if status == domain.CommandStatusCompleted && s.webhookService != nil {
+		s.sendRotationWebhook(ctx, commandID)
+	}
+	return nil
 }
 
 func (s *DeviceService) GetIPHistory(ctx context.Context, deviceID uuid.UUID, limit int) ([]domain.IPHistory, error) {
@@ -354,7 +392,7 @@ func (s *DeviceService) MarkStaleOfflineWithLogs(ctx context.Context) (int64, er
 				continue
 			}
 			_ = s.statusLogRepo.Insert(ctx, d.ID, string(domain.DeviceStatusOffline), string(d.Status), now)
-			go s.sendOfflineWebhook(ctx, d)
+			s.sendOfflineWebhook(ctx, d)
 			count++
 		}
 	}
@@ -419,10 +457,10 @@ func (s *DeviceService) GetUptimeSegments(ctx context.Context, deviceID uuid.UUI
 	return segments, nil
 }
 
-// sendOfflineWebhook dispatches a webhook notification when a device goes offline.
+// sendOfflineWebhook queues a device.offline event.
 // Respects a 5-minute cooldown per device to avoid duplicate alerts.
 func (s *DeviceService) sendOfflineWebhook(ctx context.Context, d domain.Device) {
-	if s.userRepo == nil {
+	if s.webhookService == nil {
 		return
 	}
 
@@ -432,62 +470,58 @@ func (s *DeviceService) sendOfflineWebhook(ctx context.Context, d domain.Device)
 		return // cooldown active
 	}
 
-	webhookURL, err := s.userRepo.GetWebhookURLForDevice(ctx, d.ID)
-	if err != nil || webhookURL == "" {
-		return
-	}
-
 	var lastSeen string
 	if d.LastHeartbeat != nil {
 		lastSeen = d.LastHeartbeat.Format(time.RFC3339)
 	}
-	payload, _ := json.Marshal(map[string]interface{}{
-		"event":       "device.offline",
-		"device_id":   d.ID.String(),
-		"device_name": d.Name,
-		"last_seen":   lastSeen,
-		"timestamp":   time.Now().UTC().Format(time.RFC3339),
-	})
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(payload))
-	if err != nil {
-		log.Printf("[webhook] offline alert failed for device %s: %v", d.ID, err)
-		return
+	if s.emitWebhook(ctx, domain.WebhookEventDeviceOffline, d, map[string]interface{}{
+		"last_seen": lastSeen,
+	}) {
+		_ = s.deviceRepo.SetLastOfflineAlertAt(ctx, d.ID, time.Now().UTC())
 	}
-	resp.Body.Close()
-
-	// Only update cooldown on successful delivery
-	_ = s.deviceRepo.SetLastOfflineAlertAt(ctx, d.ID, time.Now().UTC())
-	log.Printf("[webhook] offline alert sent for device %s (status=%d)", d.Name, resp.StatusCode)
 }
 
-// sendRecoveryWebhook dispatches a webhook notification when a device comes back online.
+// sendRecoveryWebhook queues a device.online event when a device comes back online.
 func (s *DeviceService) sendRecoveryWebhook(ctx context.Context, d domain.Device) {
-	if s.userRepo == nil {
-		return
-	}
+	s.emitWebhook(ctx, domain.WebhookEventDeviceOnline, d, map[string]interface{}{
+		"reconnected_at": time.Now().UTC().Format(time.RFC3339),
+	})
+}
 
-	webhookURL, err := s.userRepo.GetWebhookURLForDevice(ctx, d.ID)
-	if err != nil || webhookURL == "" {
+// sendRotationWebhook queues a rotation.completed event if the completed
+// command was an IP rotation.
+func (s *DeviceService) sendRotationWebhook(ctx context.Context, commandID uuid.UUID) {
+	cmd, err := s.commandRepo.GetByID(ctx, commandID)
+	if err != nil || (cmd.Type != domain.CommandRotateIP && cmd.Type != "rotate_ip_airplane") {
 		return
 	}
-
-	now := time.Now().UTC()
-	payload, _ := json.Marshal(map[string]interface{}{
-		"event":          "device.online",
-		"device_id":      d.ID.String(),
-		"device_name":    d.Name,
-		"reconnected_at": now.Format(time.RFC3339),
-		"timestamp":      now.Format(time.RFC3339),
-	})
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(payload))
+	d, err := s.deviceRepo.GetByID(ctx, cmd.DeviceID)
 	if err != nil {
-		log.Printf("[webhook] recovery alert failed for device %s: %v", d.ID, err)
 		return
 	}
-	resp.Body.Close()
-	log.Printf("[webhook] recovery alert sent for device %s (status=%d)", d.Name, resp.StatusCode)
+	data := map[string]interface{}{
+		"command_id":   cmd.ID.String(),
+		"method":       string(cmd.Type),
+		"requested_at": cmd.CreatedAt.UTC().Format(time.RFC3339),
+		"result":       cmd.Result,
+	}
+	if cmd.ExecutedAt != nil {
+		data["completed_at"] = cmd.ExecutedAt.UTC().Format(time.RFC3339)
+	}
+	s.emitWebhook(ctx, domain.WebhookEventRotationCompleted, *d, data)
+}
+
+// emitWebhook queues a device event, adding the device fields every device
+// event carries. Reports whether the event was queued.
+func (s *DeviceService) emitWebhook(ctx context.Context, event string, d domain.Device, data map[string]interface{}) bool {
+	if s.webhookService == nil {
+		return false
+	}
+	data["device_id"] = d.ID.String()
+	data["device_name"] = d.Name
+	if err := s.webhookService.Emit(ctx, event, d.ID, nil, data); err != nil {
+		log.Printf("[webhook] queue %s failed for device %s: %v", event, d.ID, err)
+		return false
+	}
+	return true
 }
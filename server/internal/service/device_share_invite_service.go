@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/repository"
+)
+
+const shareInviteTTL = 7 * 24 * time.Hour
+
+var errInvalidShareInvite = errors.New("invalid or expired invitation")
+
+// DeviceShareInviteService shares devices with email addresses. The invitee
+// gets a one-time link; accepting it while signed in with that email creates
+// the DeviceShare for their organization. Invitees without an account sign up
+// first and then open the link again.
+type DeviceShareInviteService struct {
+	inviteRepo   *repository.DeviceShareInviteRepository
+	shareService *DeviceShareService
+	deviceRepo   *repository.DeviceRepository
+	customerRepo *repository.CustomerRepository
+	orgService   *OrganizationService
+	emailService *EmailService
+}
+
+func NewDeviceShareInviteService(
+	inviteRepo *repository.DeviceShareInviteRepository,
+	shareService *DeviceShareService,
+	deviceRepo *repository.DeviceRepository,
+	customerRepo *repository.CustomerRepository,
+	orgService *OrganizationService,
+	emailService *EmailService,
+) *DeviceShareInviteService {
+	return &DeviceShareInviteService{
+		inviteRepo:   inviteRepo,
+		shareService: shareService,
+		deviceRepo:   deviceRepo,
+		customerRepo: customerRepo,
+		orgService:   orgService,
+		emailService: emailService,
+	}
+}
+
+// Invite emails a share invitation for one of the organization's devices.
+// Re-inviting an address replaces its pending invitation.
+func (s *DeviceShareInviteService) Invite(ctx context.Context, orgID, invitedBy uuid.UUID, req *domain.CreateDeviceShareInviteRequest) (*domain.DeviceShareInvite, error) {
+	device, err := s.deviceRepo.GetByID(ctx, req.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+	if device.CustomerID == nil || *device.CustomerID != orgID {
+		return nil, errors.New("not device owner")
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	signupRequired := true
+	if existing, err := s.customerRepo.GetByEmail(ctx, email); err == nil {
+		signupRequired = false
+		recipientOrg, err := s.orgService.OrganizationOf(ctx, existing.ID)
+		if err != nil {
+			return nil, err
+		}
+		if recipientOrg == orgID {
+			return nil, errors.New("cannot share device with your own organization")
+		}
+	}
+
+	org, err := s.orgService.Get(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, hashed, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	inv := &domain.DeviceShareInvite{
+		ID:                 uuid.New(),
+		DeviceID:           device.ID,
+		OwnerID:            orgID,
+		InvitedBy:          &invitedBy,
+		Email:              email,
+		TokenHash:          hashed,
+		CanRename:          req.CanRename,
+		CanManagePorts:     req.CanManagePorts,
+		CanDownloadConfigs: req.CanDownloadConfigs,
+		CanRotateIP:        req.CanRotateIP,
+		ExpiresAt:          time.Now().Add(shareInviteTTL),
+	}
+	if err := s.inviteRepo.Upsert(ctx, inv); err != nil {
+		return nil, fmt.Errorf("store share invite: %w", err)
+	}
+	if err := s.emailService.SendDeviceShareInvite(email, org.Organization.Name, device.Name, raw, signupRequired); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// List returns an organization's pending invitations, optionally for one device.
+func (s *DeviceShareInviteService) List(ctx context.Context, orgID uuid.UUID, deviceID *uuid.UUID) ([]domain.DeviceShareInvite, error) {
+	return s.inviteRepo.ListByOwner(ctx, orgID, deviceID)
+}
+
+// Revoke deletes a pending invitation, invalidating its link.
+func (s *DeviceShareInviteService) Revoke(ctx context.Context, orgID, inviteID uuid.UUID) error {
+	inv, err := s.inviteRepo.GetByID(ctx, inviteID)
+	if err != nil {
+		return err
+	}
+	if inv == nil || inv.OwnerID != orgID {
+		return errors.New("invitation not found")
+	}
+	return s.inviteRepo.Delete(ctx, inviteID)
+}
+
+// Preview describes the invitation behind a link without consuming it.
+func (s *DeviceShareInviteService) Preview(ctx context.Context, rawToken string) (*domain.DeviceShareInvitePreview, error) {
+	inv, err := s.pending(ctx, rawToken)
+	if err != nil {
+		return nil, err
+	}
+	device, err := s.deviceRepo.GetByID(ctx, inv.DeviceID)
+	if err != nil {
+		return nil, errInvalidShareInvite
+	}
+	org, err := s.orgService.Get(ctx, inv.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.customerRepo.GetByEmail(ctx, inv.Email)
+	return &domain.DeviceShareInvitePreview{
+		Email:            inv.Email,
+		DeviceName:       device.Name,
+		OrganizationName: org.Organization.Name,
+		ExpiresAt:        inv.ExpiresAt,
+		SignupRequired:   err != nil,
+	}, nil
+}
+
+// Accept consumes an invitation on behalf of the signed-in login, which must
+// use the invited email address, and shares the device with its organization.
+func (s *DeviceShareInviteService) Accept(ctx context.Context, rawToken string, loginID uuid.UUID) (*domain.DeviceShare, error) {
+	inv, err := s.pending(ctx, rawToken)
+	if err != nil {
+		return nil, err
+	}
+	login, err := s.customerRepo.GetByID(ctx, loginID)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(login.Email, inv.Email) {
+		return nil, errors.New("this invitation was sent to a different email address")
+	}
+
+	share := &domain.DeviceShare{
+		ID:                 uuid.New(),
+		DeviceID:           inv.DeviceID,
+		OwnerID:            inv.OwnerID,
+		SharedWith:         loginID,
+		CanRename:          inv.CanRename,
+		CanManagePorts:     inv.CanManagePorts,
+		CanDownloadConfigs: inv.CanDownloadConfigs,
+		CanRotateIP:        inv.CanRotateIP,
+	}
+	slotCheck, err := s.shareService.prepareShare(ctx, share)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.inviteRepo.Accept(ctx, inv.ID, share, slotCheck); err != nil {
+		return nil, err
+	}
+	return share, nil
+}
+
+// pending returns the unexpired invitation for a raw link token.
+func (s *DeviceShareInviteService) pending(ctx context.Context, rawToken string) (*domain.DeviceShareInvite, error) {
+	inv, err := s.inviteRepo.GetByTokenHash(ctx, hashToken(rawToken))
+	if err != nil {
+		return nil, err
+	}
+	if inv == nil || time.Now().After(inv.ExpiresAt) {
+		return nil, errInvalidShareInvite
+	}
+	return inv, nil
+}
@@ -10,8 +10,10 @@ import (
 )
 
 type DeviceShareService struct {
-	shareRepo  *repository.DeviceShareRepository
-	deviceRepo *repository.DeviceRepository
+	shareRepo   *repository.DeviceShareRepository
+	deviceRepo  *repository.DeviceRepository
+	planService *PlanService
+	orgService  *OrganizationService
 }
 
 func NewDeviceShareService(shareRepo *repository.DeviceShareRepository, deviceRepo *repository.DeviceRepository) *DeviceShareService {
@@ -21,6 +23,17 @@ func NewDeviceShareService(shareRepo *repository.DeviceShareRepository, deviceRe
 	}
 }
 
+// SetPlanService enables device-slot enforcement for share recipients.
+func (s *DeviceShareService) SetPlanService(ps *PlanService) {
+	s.planService = ps
+}
+
+// SetOrganizationService lets shares be addressed to any member login of the
+// recipient organization.
+func (s *DeviceShareService) SetOrganizationService(org *OrganizationService) {
+	s.orgService = org
+}
+
 // CanAccess returns true if the customer owns the device OR has any share on it.
 func (s *DeviceShareService) CanAccess(ctx context.Context, deviceID uuid.UUID, customerID uuid.UUID) (bool, error) {
 	device, err := s.deviceRepo.GetByID(ctx, deviceID)
@@ -68,19 +81,43 @@ func (s *DeviceShareService) CanDo(ctx context.Context, deviceID uuid.UUID, cust
 }
 
 // CreateShare creates a share after validating the caller is the device owner.
+// Shares are between organizations: SharedWith may name any member login and
+// is stored as that member's organization.
 func (s *DeviceShareService) CreateShare(ctx context.Context, share *domain.DeviceShare) error {
-	device, err := s.deviceRepo.GetByID(ctx, share.DeviceID)
+	slotCheck, err := s.prepareShare(ctx, share)
 	if err != nil {
 		return err
 	}
+	share.ID = uuid.New()
+	return s.shareRepo.Create(ctx, share, slotCheck)
+}
+
+// prepareShare checks that share.OwnerID owns the device and resolves
+// SharedWith to its organization. It returns the recipient's device-slot
+// check for the share insert to run (nil if unrestricted).
+func (s *DeviceShareService) prepareShare(ctx context.Context, share *domain.DeviceShare) (func(otherDevices int) error, error) {
+	device, err := s.deviceRepo.GetByID(ctx, share.DeviceID)
+	if err != nil {
+		return nil, err
+	}
 	if device.CustomerID == nil || *device.CustomerID != share.OwnerID {
-		return errors.New("not device owner")
+		return nil, errors.New("not device owner")
+	}
+	if s.orgService != nil {
+		orgID, err := s.orgService.OrganizationOf(ctx, share.SharedWith)
+		if err != nil {
+			return nil, err
+		}
+		share.SharedWith = orgID
 	}
 	if share.SharedWith == share.OwnerID {
-		return errors.New("cannot share device with yourself")
+		return nil, errors.New("cannot share device with your own organization")
 	}
-	share.ID = uuid.New()
-	return s.shareRepo.Create(ctx, share)
+	// A new share takes one of the recipient's device slots
+	if s.planService == nil {
+		return nil, nil
+	}
+	return s.planService.CheckDeviceSlot(ctx, share.SharedWith)
 }
 
 // UpdateShare updates share permissions after validating the caller is the device owner.
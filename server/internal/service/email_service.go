@@ -2,6 +2,7 @@ package service
 
 import (
 	"fmt"
+	"html"
 	"log"
 
 	resend "github.com/resend/resend-go/v3"
@@ -92,6 +93,197 @@ func (s *EmailService) SendPasswordReset(to, rawToken string) error {
 	return nil
 }
 
+// SendQuotaWarning tells a customer that a proxy connection has reached a
+// percentage of its bandwidth quota. Falls back to stdout logging in development.
+func (s *EmailService) SendQuotaWarning(to, username string, percent int, used, limit int64) error {
+	link := fmt.Sprintf("%s/connections", s.baseURL)
+	subject := fmt.Sprintf("Proxy %s has used %d%% of its bandwidth quota", username, percent)
+	body := fmt.Sprintf("Your proxy connection %s has used %s of its %s bandwidth quota (%d%%).", username, formatBytes(used), formatBytes(limit), percent)
+	if percent >= 100 {
+		body += " Traffic is now limited until the quota resets."
+	}
+	html := buildEmailHTML(
+		"Bandwidth quota notice",
+		body,
+		"View Connections",
+		link,
+		"You receive this notice once per threshold in each quota cycle.",
+	)
+
+	if s.client == nil {
+		log.Printf("[EmailService] DEV — would send quota warning to %s\nSubject: %s\n", to, subject)
+		return nil
+	}
+
+	params := &resend.SendEmailRequest{
+		From:    s.from,
+		To:      []string{to},
+		Subject: subject,
+		Html:    html,
+	}
+	_, err := s.client.Emails.Send(params)
+	if err != nil {
+		return fmt.Errorf("send quota warning email: %w", err)
+	}
+	return nil
+}
+
+// SendStaffPasswordLink sends a staff user a link to choose a password: an
+// invite for a new account, or an admin-initiated reset. Falls back to stdout
+// logging in development.
+func (s *EmailService) SendStaffPasswordLink(to, rawToken string, invite bool) error {
+	link := fmt.Sprintf("%s/reset-password?token=%s&staff=1", s.baseURL, rawToken)
+	subject := "Reset your PocketProxy staff password"
+	html := buildEmailHTML(
+		"Reset your PocketProxy password",
+		"An administrator reset the password of your staff account. Click the button below to choose a new one.",
+		"Choose Password",
+		link,
+		"This link expires in 24 hours.",
+	)
+	if invite {
+		subject = "You've been invited to PocketProxy"
+		html = buildEmailHTML(
+			"You've been invited to PocketProxy",
+			"An administrator created a staff account for you. Click the button below to choose your password and sign in.",
+			"Set Password",
+			link,
+			"This link expires in 72 hours.",
+		)
+	}
+
+	if s.client == nil {
+		log.Printf("[EmailService] DEV — would send staff password email to %s\nSubject: %s\nLink: %s\n", to, subject, link)
+		return nil
+	}
+
+	params := &resend.SendEmailRequest{
+		From:    s.from,
+		To:      []string{to},
+		Subject: subject,
+		Html:    html,
+	}
+	_, err := s.client.Emails.Send(params)
+	if err != nil {
+		return fmt.Errorf("send staff password email: %w", err)
+	}
+	return nil
+}
+
+// SendOrganizationInvite sends a new organization member the link to choose
+// the password of their login.
+func (s *EmailService) SendOrganizationInvite(to, orgName, rawToken string) error {
+	link := fmt.Sprintf("%s/reset-password?token=%s", s.baseURL, rawToken)
+	subject := fmt.Sprintf("You've been invited to %s on PocketProxy", orgName)
+	body := buildEmailHTML(
+		"You've been invited to PocketProxy",
+		fmt.Sprintf("You've been added to the %s team. Click the button below to choose your password and sign in.", html.EscapeString(orgName)),
+		"Set Password",
+		link,
+		"This link expires in 72 hours.",
+	)
+
+	if s.client == nil {
+		log.Printf("[EmailService] DEV — would send organization invite to %s\nSubject: %s\nLink: %s\n", to, subject, link)
+		return nil
+	}
+
+	params := &resend.SendEmailRequest{
+		From:    s.from,
+		To:      []string{to},
+		Subject: subject,
+		Html:    body,
+	}
+	_, err := s.client.Emails.Send(params)
+	if err != nil {
+		return fmt.Errorf("send organization invite: %w", err)
+	}
+	return nil
+}
+
+// SendDeviceShareInvite sends a device share invitation. Invitees without an
+// account are asked to sign up with this address before accepting.
+func (s *EmailService) SendDeviceShareInvite(to, orgName, deviceName, rawToken string, signup bool) error {
+	link := fmt.Sprintf("%s/share-invite?token=%s", s.baseURL, rawToken)
+	subject := fmt.Sprintf("%s shared a device with you on PocketProxy", orgName)
+	text := fmt.Sprintf("%s invited you to use the device %s. Click the button below to accept the invitation.",
+		html.EscapeString(orgName), html.EscapeString(deviceName))
+	if signup {
+		text += " You'll be asked to create a PocketProxy account with this email address first."
+	}
+	body := buildEmailHTML(
+		"A device was shared with you",
+		text,
+		"View Invitation",
+		link,
+		"This invitation expires in 7 days.",
+	)
+
+	if s.client == nil {
+		log.Printf("[EmailService] DEV — would send device share invite to %s\nSubject: %s\nLink: %s\n", to, subject, link)
+		return nil
+	}
+
+	params := &resend.SendEmailRequest{
+		From:    s.from,
+		To:      []string{to},
+		Subject: subject,
+		Html:    body,
+	}
+	_, err := s.client.Emails.Send(params)
+	if err != nil {
+		return fmt.Errorf("send device share invite: %w", err)
+	}
+	return nil
+}
+
+// SendAlert tells the owner of an alert rule that it fired or resolved.
+func (s *EmailService) SendAlert(to, title string, lines []string) error {
+	link := fmt.Sprintf("%s/devices", s.baseURL)
+	text := html.EscapeString(title)
+	for _, l := range lines {
+		text += "<br>" + html.EscapeString(l)
+	}
+	body := buildEmailHTML(
+		"Device alert",
+		text,
+		"View Devices",
+		link,
+		"You receive this notice because an alert rule on your account has email notifications enabled.",
+	)
+
+	if s.client == nil {
+		log.Printf("[EmailService] DEV — would send alert to %s\nSubject: %s\n", to, title)
+		return nil
+	}
+
+	params := &resend.SendEmailRequest{
+		From:    s.from,
+		To:      []string{to},
+		Subject: title,
+		Html:    body,
+	}
+	_, err := s.client.Emails.Send(params)
+	if err != nil {
+		return fmt.Errorf("send alert email: %w", err)
+	}
+	return nil
+}
+
+// formatBytes renders a byte count with a binary unit suffix (e.g. "1.5 GB").
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
 // buildEmailHTML returns a simple inline-styled transactional email body.
 // Brand colour: #8b5cf6 (purple-500).
 func buildEmailHTML(title, bodyText, buttonLabel, buttonURL, footerNote string) string {
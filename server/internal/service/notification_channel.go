@@ -0,0 +1,150 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/mobileproxy/server/internal/domain"
+)
+
+// telegramAPIBase is stored as the URL of telegram channels; the bot token
+// is only added when a message is sent, so it never shows up in the
+// endpoint listing or the delivery log.
+const telegramAPIBase = "https://api.telegram.org"
+
+var (
+	telegramBotTokenRe = regexp.MustCompile(`^[0-9]+:[A-Za-z0-9_-]+$`)
+	telegramChatIDRe   = regexp.MustCompile(`^(-?[0-9]+|@[A-Za-z0-9_]{5,32})$`)
+)
+
+// validateChannelTarget checks the destination fields an endpoint of the
+// given kind needs.
+func validateChannelTarget(kind, rawURL, botToken, chatID string) error {
+	if kind == domain.WebhookKindTelegram {
+		if !telegramBotTokenRe.MatchString(botToken) {
+			return errors.New("bot_token must be a Telegram bot token")
+		}
+		if !telegramChatIDRe.MatchString(chatID) {
+			return errors.New("chat_id must be a numeric chat ID or @channel name")
+		}
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return errors.New("url must be an http or https URL")
+	}
+	// Hostnames are checked again when delivering, after DNS resolution
+	host := u.Hostname()
+	if ip := net.ParseIP(host); (ip != nil && !isPublicIP(ip)) || strings.EqualFold(host, "localhost") ||
+		strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return errors.New("url must point to a public address")
+	}
+	if kind == domain.WebhookKindWebhook {
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return errors.New("url must be an http or https URL")
+		}
+		return nil
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("%s webhook URL must use https", kind)
+	}
+	return nil
+}
+
+// channelRequest returns where to send a delivery to a chat endpoint and the
+// message body in the format the chat service expects.
+func channelRequest(e *domain.WebhookEndpoint, d *domain.WebhookDelivery) (string, []byte, error) {
+	var data map[string]interface{}
+	if err := json.Unmarshal(d.Payload, &data); err != nil {
+		return "", nil, fmt.Errorf("decode payload: %w", err)
+	}
+	title, lines := notificationText(d.Event, data)
+
+	var body interface{}
+	target := d.URL
+	switch e.Kind {
+	case domain.WebhookKindSlack:
+		body = map[string]interface{}{"text": "*" + slackEscape(title) + "*\n" + slackEscape(strings.Join(lines, "\n"))}
+	case domain.WebhookKindDiscord:
+		body = map[string]interface{}{
+			"content":          "**" + title + "**\n" + strings.Join(lines, "\n"),
+			"allowed_mentions": map[string]interface{}{"parse": []string{}},
+		}
+	case domain.WebhookKindTelegram:
+		target = telegramAPIBase + "/bot" + e.Secret + "/sendMessage"
+		body = map[string]interface{}{
+			"chat_id":                  e.ChatID,
+			"text":                     "<b>" + html.EscapeString(title) + "</b>\n" + html.EscapeString(strings.Join(lines, "\n")),
+			"parse_mode":               "HTML",
+			"disable_web_page_preview": true,
+		}
+	default:
+		return "", nil, fmt.Errorf("unknown endpoint kind %q", e.Kind)
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return "", nil, err
+	}
+	return target, b, nil
+}
+
+// notificationText renders an event as a message title and detail lines.
+func notificationText(event string, data map[string]interface{}) (string, []string) {
+	str := func(k string) string {
+		if v, ok := data[k].(string); ok {
+			return v
+		}
+		return ""
+	}
+	num := func(k string) int64 {
+		if v, ok := data[k].(float64); ok {
+			return int64(v)
+		}
+		return 0
+	}
+	device := str("device_name")
+	if device == "" {
+		device = str("device_id")
+	}
+
+	switch event {
+	case domain.WebhookEventDeviceOffline:
+		lines := []string{}
+		if seen := str("last_seen"); seen != "" {
+			lines = append(lines, "Last seen: "+seen)
+		}
+		return "Device " + device + " is offline", lines
+	case domain.WebhookEventDeviceOnline:
+		return "Device " + device + " is back online", []string{"Reconnected at: " + str("reconnected_at")}
+	case domain.WebhookEventBatteryLow:
+		return fmt.Sprintf("Device %s battery low (%d%%)", device, num("battery_level")),
+			[]string{"The phone is not charging. Plug it in to keep the proxy up."}
+	case domain.WebhookEventIPChanged:
+		return "Device " + device + " changed IP", []string{str("old_ip") + " → " + str("new_ip")}
+	case domain.WebhookEventRotationCompleted:
+		return "Device " + device + " rotated its IP", []string{"Method: " + str("method")}
+	case domain.WebhookEventConnectionQuotaReached:
+		return fmt.Sprintf("Proxy %s used %d%% of its bandwidth quota", str("username"), num("percent")),
+			[]string{fmt.Sprintf("%s of %s used", formatBytes(num("bytes_used")), formatBytes(num("bytes_limit")))}
+	case domain.WebhookEventConnectionExpired:
+		return "Proxy " + str("username") + " expired", []string{"Expired at: " + str("expired_at")}
+	case domain.WebhookEventAlertTriggered:
+		return "Alert: " + str("rule_name") + " on " + device, []string{str("message")}
+	case domain.WebhookEventAlertResolved:
+		return "Resolved: " + str("rule_name") + " on " + device, []string{str("message")}
+	case domain.WebhookEventTest:
+		return "Test notification from PocketProxy", []string{"This channel is set up correctly."}
+	}
+	return event, nil
+}
+
+// slackEscape escapes the characters Slack treats as control sequences.
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/repository"
+)
+
+const organizationInviteTTL = 72 * time.Hour
+
+var ErrOrganizationSuspended = errors.New("organization suspended")
+
+// OrganizationService manages team accounts: member logins, their roles and
+// which organization a login acts for. An organization's id is the id of its
+// founding customer account, so a login without a membership acts for a
+// personal organization with its own id.
+type OrganizationService struct {
+	orgRepo        *repository.OrganizationRepository
+	customerRepo   *repository.CustomerRepository
+	tokenRepo      *repository.CustomerTokenRepository
+	emailService   *EmailService
+	sessionService *SessionService
+}
+
+func NewOrganizationService(orgRepo *repository.OrganizationRepository, customerRepo *repository.CustomerRepository, tokenRepo *repository.CustomerTokenRepository, emailService *EmailService, sessionService *SessionService) *OrganizationService {
+	return &OrganizationService{orgRepo: orgRepo, customerRepo: customerRepo, tokenRepo: tokenRepo, emailService: emailService, sessionService: sessionService}
+}
+
+// Resolve returns the organization a customer login acts for and the login's
+// role in it. Fails with ErrOrganizationSuspended when the organization's
+// account is disabled.
+func (s *OrganizationService) Resolve(ctx context.Context, customerID uuid.UUID) (uuid.UUID, string, error) {
+	m, err := s.orgRepo.GetMembership(ctx, customerID)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	if m == nil {
+		return customerID, domain.OrgRoleOwner, nil
+	}
+	if m.OrganizationID != customerID {
+		account, err := s.customerRepo.GetByID(ctx, m.OrganizationID)
+		if err != nil {
+			return uuid.Nil, "", err
+		}
+		if !account.Active {
+			return uuid.Nil, "", ErrOrganizationSuspended
+		}
+	}
+	return m.OrganizationID, m.Role, nil
+}
+
+// OrganizationOf returns the organization a customer login belongs to.
+func (s *OrganizationService) OrganizationOf(ctx context.Context, customerID uuid.UUID) (uuid.UUID, error) {
+	m, err := s.orgRepo.GetMembership(ctx, customerID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if m == nil {
+		return customerID, nil
+	}
+	return m.OrganizationID, nil
+}
+
+// Get returns an organization with its members, creating the personal
+// organization of an account that predates organizations.
+func (s *OrganizationService) Get(ctx context.Context, orgID uuid.UUID) (*domain.OrganizationResponse, error) {
+	org, err := s.ensure(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	members, err := s.orgRepo.ListMembers(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if members == nil {
+		members = []domain.OrganizationMember{}
+	}
+	return &domain.OrganizationResponse{Organization: org, Members: members}, nil
+}
+
+func (s *OrganizationService) Rename(ctx context.Context, orgID uuid.UUID, name string) (*domain.Organization, error) {
+	if _, err := s.ensure(ctx, orgID); err != nil {
+		return nil, err
+	}
+	if err := s.orgRepo.UpdateName(ctx, orgID, strings.TrimSpace(name)); err != nil {
+		return nil, fmt.Errorf("update organization: %w", err)
+	}
+	return s.orgRepo.GetByID(ctx, orgID)
+}
+
+// AddMember creates a login for a new member and emails them a link to choose
+// their password. The email must not belong to any existing customer.
+func (s *OrganizationService) AddMember(ctx context.Context, orgID uuid.UUID, req *domain.AddOrganizationMemberRequest) (*domain.OrganizationMember, error) {
+	org, err := s.ensure(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if _, err := s.customerRepo.GetByEmail(ctx, email); err == nil {
+		return nil, fmt.Errorf("email already registered")
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = email
+	}
+	customer := &domain.Customer{
+		ID:            uuid.New(),
+		Name:          name,
+		Email:         email,
+		Active:        true,
+		EmailVerified: true, // the invite link proves ownership
+	}
+	if err := s.orgRepo.CreateMember(ctx, orgID, customer, req.Role); err != nil {
+		return nil, err
+	}
+
+	raw, hashed, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	token := &domain.CustomerAuthToken{
+		ID:         uuid.New(),
+		CustomerID: customer.ID,
+		TokenHash:  hashed,
+		Type:       "password_reset",
+		ExpiresAt:  time.Now().Add(organizationInviteTTL),
+		CreatedAt:  time.Now(),
+	}
+	if err := s.tokenRepo.Create(ctx, token); err != nil {
+		return nil, fmt.Errorf("store invite token: %w", err)
+	}
+	if err := s.emailService.SendOrganizationInvite(email, org.Name, raw); err != nil {
+		return nil, err
+	}
+	return s.orgRepo.GetMembership(ctx, customer.ID)
+}
+
+// UpdateMemberRole changes a member's role. The last active owner cannot be
+// demoted.
+func (s *OrganizationService) UpdateMemberRole(ctx context.Context, orgID, memberID uuid.UUID, role string) (*domain.OrganizationMember, error) {
+	m, err := s.member(ctx, orgID, memberID)
+	if err != nil {
+		return nil, err
+	}
+	if m.Role == domain.OrgRoleOwner && role != domain.OrgRoleOwner {
+		if err := s.checkNotLastOwner(ctx, m); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.orgRepo.UpdateRole(ctx, orgID, memberID, role); err != nil {
+		return nil, fmt.Errorf("update member: %w", err)
+	}
+	m.Role = role
+	return m, nil
+}
+
+// RemoveMember removes a member and disables their login, ending all its
+// sessions. The founding account holds the organization's plan and billing
+// and cannot be removed.
+func (s *OrganizationService) RemoveMember(ctx context.Context, orgID, memberID uuid.UUID) error {
+	if memberID == orgID {
+		return fmt.Errorf("the founding account cannot be removed")
+	}
+	m, err := s.member(ctx, orgID, memberID)
+	if err != nil {
+		return err
+	}
+	if m.Role == domain.OrgRoleOwner {
+		if err := s.checkNotLastOwner(ctx, m); err != nil {
+			return err
+		}
+	}
+	if err := s.orgRepo.DeleteMember(ctx, orgID, memberID); err != nil {
+		return fmt.Errorf("remove member: %w", err)
+	}
+	if err := s.customerRepo.UpdateActive(ctx, memberID, false); err != nil {
+		return fmt.Errorf("disable login: %w", err)
+	}
+	return s.sessionService.RevokeAll(ctx, domain.SessionSubjectCustomer, memberID)
+}
+
+func (s *OrganizationService) ensure(ctx context.Context, orgID uuid.UUID) (*domain.Organization, error) {
+	if org, err := s.orgRepo.GetByID(ctx, orgID); err == nil {
+		return org, nil
+	}
+	customer, err := s.customerRepo.GetByID(ctx, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("organization not found")
+	}
+	if err := s.orgRepo.EnsurePersonal(ctx, customer); err != nil {
+		return nil, err
+	}
+	return s.orgRepo.GetByID(ctx, orgID)
+}
+
+func (s *OrganizationService) member(ctx context.Context, orgID, memberID uuid.UUID) (*domain.OrganizationMember, error) {
+	m, err := s.orgRepo.GetMembership(ctx, memberID)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil || m.OrganizationID != orgID {
+		return nil, fmt.Errorf("member not found")
+	}
+	return m, nil
+}
+
+func (s *OrganizationService) checkNotLastOwner(ctx context.Context, m *domain.OrganizationMember) error {
+	n, err := s.orgRepo.CountActiveOwners(ctx, m.OrganizationID)
+	if err != nil {
+		return err
+	}
+	if m.Active && n <= 1 {
+		return fmt.Errorf("cannot remove the last owner")
+	}
+	return nil
+}
@@ -23,12 +23,17 @@ type PairingService struct {
 	relayServerRepo *repository.RelayServerRepository
 	serverURL       string // e.g. "http://178.156.240.184:8080"
 	syncService     *SyncService
+	auditService    *AuditService
 }
 
 func (s *PairingService) SetSyncService(ss *SyncService) {
 	s.syncService = ss
 }
 
+func (s *PairingService) SetAuditService(as *AuditService) {
+	s.auditService = as
+}
+
 func NewPairingService(
 	pairingRepo *repository.PairingCodeRepository,
 	deviceService *DeviceService,
@@ -63,7 +68,9 @@ func generateAuthToken() string {
 	return hex.EncodeToString(b)
 }
 
-func (s *PairingService) CreateCode(ctx context.Context, expiresInMinutes int, createdBy *uuid.UUID, relayServerID *uuid.UUID, reassignDeviceID *uuid.UUID, customerID *uuid.UUID) (*domain.CreatePairingCodeResponse, error) {
+// CreateCode issues a pairing code. A device paired with it is owned by
+// customerID and allocated to resellerID when set.
+func (s *PairingService) CreateCode(ctx context.Context, expiresInMinutes int, createdBy *uuid.UUID, relayServerID *uuid.UUID, reassignDeviceID *uuid.UUID, customerID *uuid.UUID, resellerID *uuid.UUID) (*domain.CreatePairingCodeResponse, error) {
 	if expiresInMinutes <= 0 {
 		expiresInMinutes = 5
 	}
@@ -93,6 +100,7 @@ func (s *PairingService) CreateCode(ctx context.Context, expiresInMinutes int, c
 		RelayServerID:    relayServerID,
 		ReassignDeviceID: reassignDeviceID,
 		CustomerID:       customerID,
+		ResellerID:       resellerID,
 	}
 
 	if err := s.pairingRepo.Create(ctx, pc); err != nil {
@@ -106,7 +114,8 @@ func (s *PairingService) CreateCode(ctx context.Context, expiresInMinutes int, c
 	}, nil
 }
 
-func (s *PairingService) ClaimCode(ctx context.Context, req *domain.ClaimPairingCodeRequest) (*domain.ClaimPairingCodeResponse, error) {
+// ClaimCode pairs the calling app with a code. sourceIP is only used for the audit log.
+func (s *PairingService) ClaimCode(ctx context.Context, req *domain.ClaimPairingCodeRequest, sourceIP string) (*domain.ClaimPairingCodeResponse, error) {
 	// Normalize code: strip dashes, uppercase
 	code := strings.ToUpper(strings.ReplaceAll(req.Code, "-", ""))
 
@@ -138,6 +147,13 @@ func (s *PairingService) ClaimCode(ctx context.Context, req *domain.ClaimPairing
 		}
 	}
 
+	// Allocate the device to the reseller the code was created for
+	if pc.ResellerID != nil {
+		if err := s.deviceRepo.SetResellerID(ctx, regResp.DeviceID, pc.ResellerID); err != nil {
+			return nil, fmt.Errorf("set reseller id: %w", err)
+		}
+	}
+
 	// Set relay server on the device
 	if pc.RelayServerID != nil {
 		if err := s.deviceRepo.UpdateRelayServer(ctx, regResp.DeviceID, *pc.RelayServerID); err != nil {
@@ -164,6 +180,8 @@ func (s *PairingService) ClaimCode(ctx context.Context, req *domain.ClaimPairing
 		}
 	}
 
+	s.auditClaim(ctx, pc, regResp.DeviceID, sourceIP)
+
 	// Resolve relay server IP
 	var relayServerIP string
 	if pc.RelayServerID != nil && s.relayServerRepo != nil {
@@ -195,10 +213,55 @@ func (s *PairingService) ClaimCode(ctx context.Context, req *domain.ClaimPairing
 	}, nil
 }
 
+// auditClaim records the pairing, and the reassignment of the old device's
+// connections if the code asked for one. The staff user who created the code
+// is kept in the changes since the claim itself is unauthenticated.
+func (s *PairingService) auditClaim(ctx context.Context, pc *domain.PairingCode, deviceID uuid.UUID, sourceIP string) {
+	if s.auditService == nil {
+		return
+	}
+	changes := map[string]domain.AuditChange{
+		"pairing_code_id": {After: pc.ID},
+		"code_created_by": {After: pc.CreatedBy},
+		"customer_id":     {After: pc.CustomerID},
+		"reseller_id":     {After: pc.ResellerID},
+		"relay_server_id": {After: pc.RelayServerID},
+	}
+	s.auditService.Record(ctx, &domain.AuditEntry{
+		ActorType:  domain.AuditActorInternal,
+		ActorLabel: "pairing",
+		Action:     "device.pair",
+		TargetType: "device",
+		TargetID:   &deviceID,
+		Changes:    changes,
+		SourceIP:   sourceIP,
+	})
+	if pc.ReassignDeviceID != nil {
+		s.auditService.Record(ctx, &domain.AuditEntry{
+			ActorType:  domain.AuditActorInternal,
+			ActorLabel: "pairing",
+			Action:     "device.reassign",
+			TargetType: "device",
+			TargetID:   pc.ReassignDeviceID,
+			Changes: map[string]domain.AuditChange{
+				"connections_device_id": {Before: *pc.ReassignDeviceID, After: deviceID},
+				"pairing_code_id":       {After: pc.ID},
+				"code_created_by":       {After: pc.CreatedBy},
+			},
+			SourceIP: sourceIP,
+		})
+	}
+}
+
 func (s *PairingService) List(ctx context.Context) ([]domain.PairingCode, error) {
 	return s.pairingRepo.List(ctx)
 }
 
+// ListByReseller returns the pairing codes created for a reseller.
+func (s *PairingService) ListByReseller(ctx context.Context, resellerID uuid.UUID) ([]domain.PairingCode, error) {
+	return s.pairingRepo.ListByReseller(ctx, resellerID)
+}
+
 func (s *PairingService) Delete(ctx context.Context, id uuid.UUID) error {
 	return s.pairingRepo.Delete(ctx, id)
 }
@@ -0,0 +1,243 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+)
+
+// ErrInvalidSignature is returned for webhooks that fail signature verification.
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// PaymentProvider is the billing backend. Implementations create hosted
+// checkout pages and turn their signed webhooks into domain.BillingEvents.
+type PaymentProvider interface {
+	Name() string
+	// CreateCheckout starts a subscription checkout for a customer and plan.
+	CreateCheckout(customer *domain.Customer, plan *domain.Plan) (*domain.CheckoutSession, error)
+	// ParseWebhook verifies the payload's signature and normalizes it. Events
+	// the billing service doesn't act on come back with an empty Type.
+	ParseWebhook(payload []byte, header http.Header) (*domain.BillingEvent, error)
+}
+
+// NewPaymentProvider returns the provider named in cfg, or nil if billing is disabled.
+func NewPaymentProvider(cfg domain.BillingConfig) (PaymentProvider, error) {
+	if cfg.Provider == "" {
+		return nil, nil
+	}
+	if cfg.WebhookSecret == "" {
+		return nil, fmt.Errorf("billing provider %q needs a webhook secret", cfg.Provider)
+	}
+	switch cfg.Provider {
+	case "stripe":
+		if cfg.SecretKey == "" {
+			return nil, fmt.Errorf("billing provider stripe needs a secret key")
+		}
+		return &StripeProvider{cfg: cfg, client: &http.Client{Timeout: 15 * time.Second}}, nil
+	case "fake":
+		return NewFakeProvider(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown billing provider %q", cfg.Provider)
+	}
+}
+
+func hmacSHA256Hex(secret string, parts ...[]byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	for _, p := range parts {
+		mac.Write(p)
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ─── Fake provider ────────────────────────────────────────────────────────────
+
+// FakeProvider is a local stand-in for development and tests. Checkout
+// redirects straight to the success URL, and webhooks are domain.BillingEvent
+// JSON signed with HMAC-SHA256 of the body in X-Fake-Signature (see Sign).
+type FakeProvider struct {
+	cfg domain.BillingConfig
+}
+
+func NewFakeProvider(cfg domain.BillingConfig) *FakeProvider {
+	return &FakeProvider{cfg: cfg}
+}
+
+func (p *FakeProvider) Name() string { return "fake" }
+
+func (p *FakeProvider) CreateCheckout(customer *domain.Customer, plan *domain.Plan) (*domain.CheckoutSession, error) {
+	id := "fake_cs_" + uuid.New().String()
+	return &domain.CheckoutSession{
+		ID:  id,
+		URL: fmt.Sprintf("%s?session_id=%s&customer_id=%s&plan_id=%s", p.cfg.SuccessURL, id, customer.ID, plan.ID),
+	}, nil
+}
+
+// Sign returns the X-Fake-Signature value for a webhook payload.
+func (p *FakeProvider) Sign(payload []byte) string {
+	return hmacSHA256Hex(p.cfg.WebhookSecret, payload)
+}
+
+func (p *FakeProvider) ParseWebhook(payload []byte, header http.Header) (*domain.BillingEvent, error) {
+	if !hmac.Equal([]byte(header.Get("X-Fake-Signature")), []byte(p.Sign(payload))) {
+		return nil, ErrInvalidSignature
+	}
+	var evt domain.BillingEvent
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		return nil, fmt.Errorf("parse event: %w", err)
+	}
+	if evt.ID == "" {
+		return nil, fmt.Errorf("event id is required")
+	}
+	return &evt, nil
+}
+
+// ─── Stripe ───────────────────────────────────────────────────────────────────
+
+const (
+	stripeAPIURL             = "https://api.stripe.com/v1"
+	stripeSignatureTolerance = 5 * time.Minute
+)
+
+// StripeProvider uses Stripe Checkout in subscription mode. The customer and
+// plan ids travel as client_reference_id and metadata, and are copied onto the
+// subscription so cancellations can be matched too.
+type StripeProvider struct {
+	cfg    domain.BillingConfig
+	client *http.Client
+}
+
+func (p *StripeProvider) Name() string { return "stripe" }
+
+func (p *StripeProvider) CreateCheckout(customer *domain.Customer, plan *domain.Plan) (*domain.CheckoutSession, error) {
+	if plan.ProviderPriceID == "" {
+		return nil, fmt.Errorf("plan %s has no provider price", plan.Name)
+	}
+	form := url.Values{
+		"mode":                    {"subscription"},
+		"line_items[0][price]":    {plan.ProviderPriceID},
+		"line_items[0][quantity]": {"1"},
+		"client_reference_id":     {customer.ID.String()},
+		"customer_email":          {customer.Email},
+		"success_url":             {p.cfg.SuccessURL},
+		"cancel_url":              {p.cfg.CancelURL},
+		"metadata[customer_id]":   {customer.ID.String()},
+		"metadata[plan_id]":       {plan.ID.String()},
+		"subscription_data[metadata][customer_id]": {customer.ID.String()},
+		"subscription_data[metadata][plan_id]":     {plan.ID.String()},
+	}
+	req, err := http.NewRequest(http.MethodPost, stripeAPIURL+"/checkout/sessions", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(p.cfg.SecretKey, "")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("stripe request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read stripe response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("stripe checkout: status %d: %s", resp.StatusCode, body)
+	}
+	var session struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(body, &session); err != nil {
+		return nil, fmt.Errorf("parse stripe response: %w", err)
+	}
+	return &domain.CheckoutSession{ID: session.ID, URL: session.URL}, nil
+}
+
+// verifySignature checks a Stripe-Signature header ("t=<unix>,v1=<hex>,...").
+func (p *StripeProvider) verifySignature(payload []byte, sigHeader string) error {
+	var ts string
+	var sigs []string
+	for _, part := range strings.Split(sigHeader, ",") {
+		k, v, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sigs = append(sigs, v)
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || len(sigs) == 0 {
+		return ErrInvalidSignature
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > stripeSignatureTolerance || age < -stripeSignatureTolerance {
+		return ErrInvalidSignature
+	}
+	expected := hmacSHA256Hex(p.cfg.WebhookSecret, []byte(ts), []byte("."), payload)
+	for _, sig := range sigs {
+		if hmac.Equal([]byte(sig), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+func (p *StripeProvider) ParseWebhook(payload []byte, header http.Header) (*domain.BillingEvent, error) {
+	if err := p.verifySignature(payload, header.Get("Stripe-Signature")); err != nil {
+		return nil, err
+	}
+
+	var event struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			Object struct {
+				ID                string            `json:"id"`
+				ClientReferenceID string            `json:"client_reference_id"`
+				Customer          string            `json:"customer"`
+				Subscription      string            `json:"subscription"`
+				Metadata          map[string]string `json:"metadata"`
+			} `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("parse event: %w", err)
+	}
+	obj := event.Data.Object
+	evt := &domain.BillingEvent{ID: event.ID, ProviderCustomerID: obj.Customer, SubscriptionID: obj.Subscription}
+
+	switch event.Type {
+	case "checkout.session.completed":
+		evt.Type = domain.BillingEventCheckoutCompleted
+		if id, err := uuid.Parse(obj.ClientReferenceID); err == nil {
+			evt.CustomerID = &id
+		}
+		if id, err := uuid.Parse(obj.Metadata["plan_id"]); err == nil {
+			evt.PlanID = &id
+		}
+	case "invoice.paid":
+		evt.Type = domain.BillingEventPaymentSucceeded
+	case "invoice.payment_failed":
+		evt.Type = domain.BillingEventPaymentFailed
+	case "customer.subscription.deleted":
+		evt.Type = domain.BillingEventSubscriptionCanceled
+		evt.SubscriptionID = obj.ID
+	}
+	return evt, nil
+}
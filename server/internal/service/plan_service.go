@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/repository"
+)
+
+// ErrPlanLimit is wrapped by every entitlement check failure so handlers can
+// answer 403 with the specific reason.
+var ErrPlanLimit = errors.New("plan limit reached")
+
+var validProxyTypes = map[string]bool{"http": true, "socks5": true, "openvpn": true}
+
+// PlanService manages customer plans and enforces their entitlements.
+// Customers without a plan are unrestricted, and so are admins.
+type PlanService struct {
+	planRepo     *repository.PlanRepository
+	customerRepo *repository.CustomerRepository
+}
+
+func NewPlanService(planRepo *repository.PlanRepository, customerRepo *repository.CustomerRepository) *PlanService {
+	return &PlanService{planRepo: planRepo, customerRepo: customerRepo}
+}
+
+func (s *PlanService) List(ctx context.Context) ([]domain.Plan, error) {
+	plans, err := s.planRepo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if plans == nil {
+		plans = []domain.Plan{}
+	}
+	return plans, nil
+}
+
+func (s *PlanService) Get(ctx context.Context, id uuid.UUID) (*domain.Plan, error) {
+	return s.planRepo.GetByID(ctx, id)
+}
+
+func (s *PlanService) Create(ctx context.Context, req *domain.PlanRequest) (*domain.Plan, error) {
+	p := &domain.Plan{ID: uuid.New(), Active: true}
+	if err := applyPlanRequest(p, req); err != nil {
+		return nil, err
+	}
+	if err := s.planRepo.Create(ctx, p); err != nil {
+		return nil, fmt.Errorf("create plan: %w", err)
+	}
+	return p, nil
+}
+
+func (s *PlanService) Update(ctx context.Context, id uuid.UUID, req *domain.PlanRequest) (*domain.Plan, error) {
+	p, err := s.planRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyPlanRequest(p, req); err != nil {
+		return nil, err
+	}
+	if err := s.planRepo.Update(ctx, p); err != nil {
+		return nil, fmt.Errorf("update plan: %w", err)
+	}
+	return p, nil
+}
+
+// Delete removes a plan; customers on it become unrestricted.
+func (s *PlanService) Delete(ctx context.Context, id uuid.UUID) error {
+	return s.planRepo.Delete(ctx, id)
+}
+
+func applyPlanRequest(p *domain.Plan, req *domain.PlanRequest) error {
+	types := req.AllowedProxyTypes
+	if types == nil {
+		types = []string{"http", "socks5", "openvpn"}
+	}
+	for _, t := range types {
+		if !validProxyTypes[t] {
+			return fmt.Errorf("invalid proxy type %q: must be http, socks5 or openvpn", t)
+		}
+	}
+	p.Name = req.Name
+	p.Description = req.Description
+	p.MaxDevices = req.MaxDevices
+	p.MaxConnections = req.MaxConnections
+	p.MonthlyGB = req.MonthlyGB
+	p.AllowedProxyTypes = types
+	p.MaxRotationsPerDay = req.MaxRotationsPerDay
+	p.MinRotationIntervalSec = req.MinRotationIntervalSec
+	p.ProviderPriceID = req.ProviderPriceID
+	if req.Active != nil {
+		p.Active = *req.Active
+	}
+	return nil
+}
+
+// AssignToCustomer sets (or with nil, clears) a customer's plan.
+func (s *PlanService) AssignToCustomer(ctx context.Context, customerID uuid.UUID, planID *uuid.UUID) error {
+	if _, err := s.customerRepo.GetByID(ctx, customerID); err != nil {
+		return err
+	}
+	if planID != nil {
+		p, err := s.planRepo.GetByID(ctx, *planID)
+		if err != nil {
+			return err
+		}
+		if !p.Active {
+			return fmt.Errorf("plan %s is not active", p.Name)
+		}
+	}
+	return s.customerRepo.UpdatePlan(ctx, customerID, planID)
+}
+
+// GetUsage returns the customer's plan and current consumption against it.
+func (s *PlanService) GetUsage(ctx context.Context, customerID uuid.UUID) (*domain.PlanUsage, error) {
+	plan, err := s.planRepo.GetForCustomer(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	usage := &domain.PlanUsage{Plan: plan}
+	if usage.Devices, err = s.planRepo.CountDevices(ctx, customerID); err != nil {
+		return nil, fmt.Errorf("count devices: %w", err)
+	}
+	if usage.Connections, err = s.planRepo.CountConnections(ctx, customerID); err != nil {
+		return nil, fmt.Errorf("count connections: %w", err)
+	}
+	if usage.MonthBytes, err = s.planRepo.GetMonthBytes(ctx, customerID, monthStartUTC(time.Now())); err != nil {
+		return nil, fmt.Errorf("month bytes: %w", err)
+	}
+	if usage.RotationsToday, _, err = s.planRepo.GetRotationStats(ctx, customerID, time.Now().Add(-24*time.Hour)); err != nil {
+		return nil, fmt.Errorf("rotation stats: %w", err)
+	}
+	return usage, nil
+}
+
+// CheckConnectionCreate enforces allowed_proxy_types and returns the
+// max_connections check for ConnectionRepository.Create to run under the
+// customer's plan lock (nil if the customer has no connection cap).
+func (s *PlanService) CheckConnectionCreate(ctx context.Context, customerID uuid.UUID, proxyType string) (func(connections int) error, error) {
+	plan, err := s.planRepo.GetForCustomer(ctx, customerID)
+	if err != nil || plan == nil {
+		return nil, err
+	}
+	allowed := false
+	for _, t := range plan.AllowedProxyTypes {
+		if t == proxyType {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%w: proxy type %s not included in plan %s", ErrPlanLimit, proxyType, plan.Name)
+	}
+	if plan.MaxConnections == 0 {
+		return nil, nil
+	}
+	return func(connections int) error {
+		if connections >= plan.MaxConnections {
+			return fmt.Errorf("%w: plan %s allows %d connections", ErrPlanLimit, plan.Name, plan.MaxConnections)
+		}
+		return nil
+	}, nil
+}
+
+// CheckDeviceSlot returns the max_devices check for a device being shared
+// with a customer, for the share insert to run under the customer's plan lock
+// (nil if the customer has no device cap).
+func (s *PlanService) CheckDeviceSlot(ctx context.Context, customerID uuid.UUID) (func(otherDevices int) error, error) {
+	plan, err := s.planRepo.GetForCustomer(ctx, customerID)
+	if err != nil || plan == nil || plan.MaxDevices == 0 {
+		return nil, err
+	}
+	return func(otherDevices int) error {
+		if otherDevices >= plan.MaxDevices {
+			return fmt.Errorf("%w: plan %s allows %d devices", ErrPlanLimit, plan.Name, plan.MaxDevices)
+		}
+		return nil
+	}, nil
+}
+
+// AllowRotation enforces the rotation limits and, if allowed, records the
+// rotation against the customer. The check and the record happen under the
+// customer's plan lock, so parallel rotations cannot both take the last slot.
+func (s *PlanService) AllowRotation(ctx context.Context, customerID, deviceID uuid.UUID, source string) error {
+	plan, err := s.planRepo.GetForCustomer(ctx, customerID)
+	if err != nil {
+		return err
+	}
+	var check func(count int, last *time.Time) error
+	if plan != nil && (plan.MaxRotationsPerDay > 0 || plan.MinRotationIntervalSec > 0) {
+		check = func(count int, last *time.Time) error {
+			if plan.MaxRotationsPerDay > 0 && count >= plan.MaxRotationsPerDay {
+				return fmt.Errorf("%w: plan %s allows %d rotations per day", ErrPlanLimit, plan.Name, plan.MaxRotationsPerDay)
+			}
+			if plan.MinRotationIntervalSec > 0 && last != nil {
+				if wait := time.Duration(plan.MinRotationIntervalSec)*time.Second - time.Since(*last); wait > 0 {
+					return fmt.Errorf("%w: next rotation allowed in %ds", ErrPlanLimit, int(wait.Seconds())+1)
+				}
+			}
+			return nil
+		}
+	}
+	return s.planRepo.RecordRotation(ctx, customerID, deviceID, source, time.Now().Add(-24*time.Hour), check)
+}
+
+// ConnectionsOverMonthlyCap lists active connections whose customer has
+// exhausted the plan's monthly GB this calendar month (UTC).
+func (s *PlanService) ConnectionsOverMonthlyCap(ctx context.Context) ([]uuid.UUID, error) {
+	return s.planRepo.ListConnectionsOverMonthlyCap(ctx, monthStartUTC(time.Now()))
+}
+
+// PruneRotations trims rotation history older than the retention window.
+func (s *PlanService) PruneRotations(ctx context.Context, retention time.Duration) (int64, error) {
+	return s.planRepo.DeleteRotationsBefore(ctx, time.Now().Add(-retention))
+}
+
+func monthStartUTC(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
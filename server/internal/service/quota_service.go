@@ -0,0 +1,297 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/repository"
+)
+
+const quotaCyclesLimit = 24
+
+// QuotaService manages recurring bandwidth quotas. The byte cap is the
+// connection's bandwidth_limit; a quota adds a reset period anchored to a
+// cycle start, notification thresholds, and whether relays cut or throttle
+// the connection once it is over. The worker calls RunResets and
+// RunNotifications; relays learn over-quota state from the limits snapshot.
+type QuotaService struct {
+	quotaRepo      *repository.QuotaRepository
+	connService    *ConnectionService
+	webhookService *WebhookService
+	customerRepo   *repository.CustomerRepository
+	emailService   *EmailService
+}
+
+func NewQuotaService(quotaRepo *repository.QuotaRepository, connService *ConnectionService) *QuotaService {
+	return &QuotaService{quotaRepo: quotaRepo, connService: connService}
+}
+
+// SetWebhookService enables connection.quota_reached webhook events.
+func (s *QuotaService) SetWebhookService(ws *WebhookService) {
+	s.webhookService = ws
+}
+
+// SetCustomerEmail enables email notifications to the connection's customer.
+func (s *QuotaService) SetCustomerEmail(repo *repository.CustomerRepository, email *EmailService) {
+	s.customerRepo = repo
+	s.emailService = email
+}
+
+// Get returns the quota policy for a connection, or nil if it has none.
+func (s *QuotaService) Get(ctx context.Context, connectionID uuid.UUID) (*domain.BandwidthQuota, error) {
+	q, err := s.quotaRepo.GetByConnection(ctx, connectionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if q.Period != domain.QuotaPeriodNone {
+		_, end := quotaCycleBounds(q.Period, q.AnchorAt, q.CycleStart)
+		q.CycleEnd = &end
+	}
+	return q, nil
+}
+
+// ListCycles returns the most recent completed cycles for a connection.
+func (s *QuotaService) ListCycles(ctx context.Context, connectionID uuid.UUID) ([]domain.BandwidthQuotaCycle, error) {
+	cycles, err := s.quotaRepo.ListCycles(ctx, connectionID, quotaCyclesLimit)
+	if err != nil {
+		return nil, err
+	}
+	if cycles == nil {
+		cycles = []domain.BandwidthQuotaCycle{}
+	}
+	return cycles, nil
+}
+
+// Set validates and stores a connection's byte cap and quota policy.
+func (s *QuotaService) Set(ctx context.Context, connectionID uuid.UUID, req *domain.SetQuotaRequest) (*domain.BandwidthQuota, error) {
+	period := req.Period
+	if period == "" {
+		period = domain.QuotaPeriodNone
+	}
+	switch period {
+	case domain.QuotaPeriodNone, domain.QuotaPeriodDaily, domain.QuotaPeriodWeekly, domain.QuotaPeriodMonthly:
+	default:
+		return nil, fmt.Errorf("invalid period %q: must be none, daily, weekly or monthly", period)
+	}
+
+	action := req.OverAction
+	if action == "" {
+		action = domain.QuotaActionCut
+	}
+	switch action {
+	case domain.QuotaActionCut:
+	case domain.QuotaActionThrottle:
+		if req.ThrottleKbps <= 0 {
+			return nil, fmt.Errorf("throttle_kbps must be positive when over_action is throttle")
+		}
+	default:
+		return nil, fmt.Errorf("invalid over_action %q: must be cut or throttle", action)
+	}
+
+	percents := req.NotifyPercents
+	if percents == nil {
+		percents = []int{80, 100}
+	}
+	seen := make(map[int]bool)
+	var notify []int
+	for _, p := range percents {
+		if p < 1 || p > 100 {
+			return nil, fmt.Errorf("notify percent %d out of range 1-100", p)
+		}
+		if !seen[p] {
+			seen[p] = true
+			notify = append(notify, p)
+		}
+	}
+	sort.Ints(notify)
+	if notify == nil {
+		notify = []int{}
+	}
+
+	now := time.Now().UTC()
+	anchor := now.Truncate(time.Second)
+	if req.AnchorAt != nil {
+		anchor = req.AnchorAt.UTC()
+	}
+	cycleStart := anchor
+	if period != domain.QuotaPeriodNone {
+		cycleStart, _ = quotaCycleBounds(period, anchor, now)
+	}
+
+	if _, err := s.connService.SetBandwidthLimit(ctx, connectionID, req.BandwidthLimit); err != nil {
+		return nil, err
+	}
+
+	q := &domain.BandwidthQuota{
+		ConnectionID:   connectionID,
+		Period:         period,
+		AnchorAt:       anchor,
+		CycleStart:     cycleStart,
+		NotifyPercents: notify,
+		OverAction:     action,
+		ThrottleKbps:   req.ThrottleKbps,
+	}
+	if action == domain.QuotaActionCut {
+		q.ThrottleKbps = 0
+	}
+	if err := s.quotaRepo.Upsert(ctx, q); err != nil {
+		return nil, fmt.Errorf("save quota: %w", err)
+	}
+	if period != domain.QuotaPeriodNone {
+		_, end := quotaCycleBounds(period, anchor, cycleStart)
+		q.CycleEnd = &end
+	}
+	return q, nil
+}
+
+// Delete removes a connection's quota policy. The byte cap is left as is.
+func (s *QuotaService) Delete(ctx context.Context, connectionID uuid.UUID) error {
+	return s.quotaRepo.Delete(ctx, connectionID)
+}
+
+// RunResets starts a new cycle for every quota whose cycle has ended,
+// archiving the finished cycle's usage. Returns the number of resets.
+func (s *QuotaService) RunResets(ctx context.Context) (int, error) {
+	quotas, err := s.quotaRepo.ListRecurring(ctx)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+	count := 0
+	for _, q := range quotas {
+		start, _ := quotaCycleBounds(q.Period, q.AnchorAt, now)
+		if !start.After(q.CycleStart) {
+			continue
+		}
+		if err := s.quotaRepo.ResetCycle(ctx, q.ConnectionID, q.CycleStart, start); err != nil {
+			log.Printf("[quota] reset failed for connection %s: %v", q.ConnectionID, err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// RunNotifications sends a notification for the highest threshold each
+// connection has crossed this cycle and not yet been notified about.
+// Returns the number of notifications sent.
+func (s *QuotaService) RunNotifications(ctx context.Context) (int, error) {
+	usage, err := s.quotaRepo.ListUsage(ctx)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, u := range usage {
+		percent := int(u.BandwidthUsed * 100 / u.BandwidthLimit)
+		threshold := 0
+		for _, p := range u.Quota.NotifyPercents {
+			if p <= percent && p > u.Quota.NotifiedPercent {
+				threshold = p
+			}
+		}
+		if threshold == 0 {
+			continue
+		}
+		s.notify(ctx, u, threshold)
+		if err := s.quotaRepo.SetNotified(ctx, u.Quota.ConnectionID, threshold); err != nil {
+			log.Printf("[quota] mark notified failed for connection %s: %v", u.Quota.ConnectionID, err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// notify queues the threshold webhook event and emails the connection's
+// customer. Both are best-effort.
+func (s *QuotaService) notify(ctx context.Context, u domain.QuotaUsage, percent int) {
+	if s.webhookService != nil {
+		s.sendQuotaWebhook(ctx, u, percent)
+	}
+
+	if s.customerRepo != nil && s.emailService != nil && u.CustomerID != nil {
+		customer, err := s.customerRepo.GetByID(ctx, *u.CustomerID)
+		if err != nil || customer.Email == "" {
+			return
+		}
+		if err := s.emailService.SendQuotaWarning(customer.Email, u.Username, percent, u.BandwidthUsed, u.BandwidthLimit); err != nil {
+			log.Printf("[quota] email to customer %s failed: %v", customer.ID, err)
+		}
+	}
+}
+
+func (s *QuotaService) sendQuotaWebhook(ctx context.Context, u domain.QuotaUsage, percent int) {
+	data := map[string]interface{}{
+		"connection_id": u.Quota.ConnectionID.String(),
+		"username":      u.Username,
+		"device_id":     u.DeviceID.String(),
+		"percent":       percent,
+		"bytes_used":    u.BandwidthUsed,
+		"bytes_limit":   u.BandwidthLimit,
+		"period":        u.Quota.Period,
+		"cycle_start":   u.Quota.CycleStart.UTC().Format(time.RFC3339),
+		"over_action":   u.Quota.OverAction,
+	}
+	if u.Quota.Period != domain.QuotaPeriodNone {
+		_, end := quotaCycleBounds(u.Quota.Period, u.Quota.AnchorAt, u.Quota.CycleStart)
+		data["cycle_end"] = end.UTC().Format(time.RFC3339)
+	}
+	if err := s.webhookService.Emit(ctx, domain.WebhookEventConnectionQuotaReached, u.DeviceID, u.CustomerID, data); err != nil {
+		log.Printf("[webhook] queue quota alert failed for connection %s: %v", u.Username, err)
+	}
+}
+
+// quotaCycleBounds returns the [start, end) of the cycle containing t for a
+// recurring period anchored at anchor.
+func quotaCycleBounds(period string, anchor, t time.Time) (time.Time, time.Time) {
+	anchor, t = anchor.UTC(), t.UTC()
+
+	// Estimate the cycle index, then correct it against the real boundaries
+	// (monthly cycles vary in length).
+	var k int
+	switch period {
+	case domain.QuotaPeriodDaily:
+		k = int(math.Floor(t.Sub(anchor).Hours() / 24))
+	case domain.QuotaPeriodWeekly:
+		k = int(math.Floor(t.Sub(anchor).Hours() / (24 * 7)))
+	default:
+		k = (t.Year()-anchor.Year())*12 + int(t.Month()) - int(anchor.Month())
+	}
+	for quotaBoundary(period, anchor, k).After(t) {
+		k--
+	}
+	for !quotaBoundary(period, anchor, k+1).After(t) {
+		k++
+	}
+	return quotaBoundary(period, anchor, k), quotaBoundary(period, anchor, k+1)
+}
+
+// quotaBoundary returns the start of the k-th cycle after anchor. Monthly
+// cycles keep the anchor's day of month, clamped to shorter months
+// (an anchor on the 31st resets on Feb 28/29, then Mar 31).
+func quotaBoundary(period string, anchor time.Time, k int) time.Time {
+	switch period {
+	case domain.QuotaPeriodDaily:
+		return anchor.AddDate(0, 0, k)
+	case domain.QuotaPeriodWeekly:
+		return anchor.AddDate(0, 0, 7*k)
+	}
+	first := time.Date(anchor.Year(), anchor.Month()+time.Month(k), 1,
+		anchor.Hour(), anchor.Minute(), anchor.Second(), anchor.Nanosecond(), time.UTC)
+	day := anchor.Day()
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/repository"
+)
+
+// ResellerService scopes reseller accounts to the devices allocated to them
+// and the customers they own, and manages their branding.
+type ResellerService struct {
+	resellerRepo *repository.ResellerRepository
+	userRepo     *repository.UserRepository
+	deviceRepo   *repository.DeviceRepository
+	customerRepo *repository.CustomerRepository
+	connRepo     *repository.ConnectionRepository
+	pairingRepo  *repository.PairingCodeRepository
+	linkRepo     *repository.RotationLinkRepository
+	webhookRepo  *repository.WebhookRepository
+	alertRepo    *repository.AlertRepository
+}
+
+func NewResellerService(
+	resellerRepo *repository.ResellerRepository,
+	userRepo *repository.UserRepository,
+	deviceRepo *repository.DeviceRepository,
+	customerRepo *repository.CustomerRepository,
+	connRepo *repository.ConnectionRepository,
+	pairingRepo *repository.PairingCodeRepository,
+	linkRepo *repository.RotationLinkRepository,
+) *ResellerService {
+	return &ResellerService{
+		resellerRepo: resellerRepo,
+		userRepo:     userRepo,
+		deviceRepo:   deviceRepo,
+		customerRepo: customerRepo,
+		connRepo:     connRepo,
+		pairingRepo:  pairingRepo,
+		linkRepo:     linkRepo,
+	}
+}
+
+// SetWebhookRepo lets resellers reach their own webhook endpoints and deliveries.
+func (s *ResellerService) SetWebhookRepo(repo *repository.WebhookRepository) {
+	s.webhookRepo = repo
+}
+
+// SetAlertRepo lets resellers reach their own alert rules and incidents.
+func (s *ResellerService) SetAlertRepo(repo *repository.AlertRepository) {
+	s.alertRepo = repo
+}
+
+// InScope reports whether an entity is visible to a reseller. Unknown target
+// types are never in scope.
+func (s *ResellerService) InScope(ctx context.Context, resellerID uuid.UUID, targetType string, id uuid.UUID) bool {
+	var err error
+	switch targetType {
+	case "device":
+		_, err = s.deviceRepo.GetByIDForReseller(ctx, id, resellerID)
+	case "connection":
+		_, err = s.connRepo.GetByIDForReseller(ctx, id, resellerID)
+	case "customer":
+		_, err = s.customerRepo.GetByIDForReseller(ctx, id, resellerID)
+	case "pairing_code":
+		_, err = s.pairingRepo.GetByIDForReseller(ctx, id, resellerID)
+	case "rotation_link":
+		_, err = s.linkRepo.GetByIDForReseller(ctx, id, resellerID)
+	case "webhook":
+		if s.webhookRepo == nil {
+			return false
+		}
+		e, err := s.webhookRepo.GetEndpoint(ctx, id)
+		return err == nil && e != nil && e.UserID != nil && *e.UserID == resellerID
+	case "webhook_delivery":
+		if s.webhookRepo == nil {
+			return false
+		}
+		d, err := s.webhookRepo.GetDelivery(ctx, id)
+		return err == nil && d != nil && d.UserID != nil && *d.UserID == resellerID
+	case "alert_rule":
+		if s.alertRepo == nil {
+			return false
+		}
+		r, err := s.alertRepo.GetRule(ctx, id)
+		return err == nil && r != nil && r.UserID != nil && *r.UserID == resellerID
+	case "alert_incident":
+		if s.alertRepo == nil {
+			return false
+		}
+		i, err := s.alertRepo.GetIncident(ctx, id)
+		return err == nil && i != nil && i.UserID != nil && *i.UserID == resellerID
+	default:
+		return false
+	}
+	return err == nil
+}
+
+// AllocateDevice hands a device to a reseller, or takes it back with nil.
+func (s *ResellerService) AllocateDevice(ctx context.Context, deviceID uuid.UUID, resellerID *uuid.UUID) error {
+	if _, err := s.deviceRepo.GetByID(ctx, deviceID); err != nil {
+		return fmt.Errorf("device not found")
+	}
+	if err := s.checkReseller(ctx, resellerID); err != nil {
+		return err
+	}
+	return s.deviceRepo.SetResellerID(ctx, deviceID, resellerID)
+}
+
+// AllocateCustomer hands a customer to a reseller, or takes it back with nil.
+func (s *ResellerService) AllocateCustomer(ctx context.Context, customerID uuid.UUID, resellerID *uuid.UUID) error {
+	if _, err := s.customerRepo.GetByID(ctx, customerID); err != nil {
+		return fmt.Errorf("customer not found")
+	}
+	if err := s.checkReseller(ctx, resellerID); err != nil {
+		return err
+	}
+	return s.customerRepo.SetResellerID(ctx, customerID, resellerID)
+}
+
+// GetBranding returns a reseller's branding, empty if none was set yet.
+func (s *ResellerService) GetBranding(ctx context.Context, resellerID uuid.UUID) (*domain.ResellerBranding, error) {
+	b, err := s.resellerRepo.GetBranding(ctx, resellerID)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		b = &domain.ResellerBranding{ResellerID: resellerID}
+	}
+	return b, nil
+}
+
+// UpdateBranding replaces a reseller's branding. A domain can only belong to
+// one reseller.
+func (s *ResellerService) UpdateBranding(ctx context.Context, resellerID uuid.UUID, req *domain.UpdateResellerBrandingRequest) (*domain.ResellerBranding, error) {
+	b := &domain.ResellerBranding{
+		ResellerID:   resellerID,
+		CompanyName:  strings.TrimSpace(req.CompanyName),
+		LogoURL:      req.LogoURL,
+		PrimaryColor: req.PrimaryColor,
+		SupportEmail: req.SupportEmail,
+	}
+	if req.Domain != nil && *req.Domain != "" {
+		host := strings.ToLower(*req.Domain)
+		existing, err := s.resellerRepo.GetBrandingByDomain(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil && existing.ResellerID != resellerID {
+			return nil, fmt.Errorf("domain already in use")
+		}
+		b.Domain = &host
+	}
+	if err := s.resellerRepo.UpsertBranding(ctx, b); err != nil {
+		return nil, fmt.Errorf("update branding: %w", err)
+	}
+	return b, nil
+}
+
+// BrandingForCustomer returns the branding of a customer's reseller, or nil
+// for direct customers.
+func (s *ResellerService) BrandingForCustomer(ctx context.Context, customerID uuid.UUID) (*domain.ResellerBranding, error) {
+	return s.resellerRepo.GetBrandingForCustomer(ctx, customerID)
+}
+
+// BrandingForDomain returns the branding registered for a dashboard host, or nil.
+func (s *ResellerService) BrandingForDomain(ctx context.Context, host string) (*domain.ResellerBranding, error) {
+	return s.resellerRepo.GetBrandingByDomain(ctx, host)
+}
+
+func (s *ResellerService) checkReseller(ctx context.Context, resellerID *uuid.UUID) error {
+	if resellerID == nil {
+		return nil
+	}
+	user, err := s.userRepo.GetByID(ctx, *resellerID)
+	if err != nil || user.Role != "reseller" {
+		return fmt.Errorf("reseller not found")
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/repository"
+)
+
+const (
+	defaultSessionsLimit = 100
+	maxSessionsLimit     = 1000
+	defaultSessionsRange = 24 * time.Hour
+)
+
+// SessionLogService stores the per-session access log reported by relays.
+// Sessions live in a table partitioned by day; partitions are created lazily
+// on insert and dropped by the worker after the retention window.
+type SessionLogService struct {
+	sessionRepo *repository.SessionLogRepository
+
+	mu      sync.Mutex
+	ensured map[string]bool // partition days already created by this process
+}
+
+func NewSessionLogService(sessionRepo *repository.SessionLogRepository) *SessionLogService {
+	return &SessionLogService{sessionRepo: sessionRepo, ensured: make(map[string]bool)}
+}
+
+// Record normalizes and stores a batch of sessions, creating any missing day partitions.
+func (s *SessionLogService) Record(ctx context.Context, sessions []domain.ConnectionSession) error {
+	now := time.Now().UTC()
+	for i := range sessions {
+		if sessions[i].ID == uuid.Nil {
+			sessions[i].ID = uuid.New()
+		}
+		if sessions[i].EndedAt.IsZero() {
+			sessions[i].EndedAt = now
+		}
+		if sessions[i].StartedAt.IsZero() || sessions[i].StartedAt.After(sessions[i].EndedAt) {
+			sessions[i].StartedAt = sessions[i].EndedAt
+		}
+		if err := s.ensurePartition(ctx, sessions[i].StartedAt); err != nil {
+			return fmt.Errorf("ensure partition: %w", err)
+		}
+	}
+	return s.sessionRepo.CreateBatch(ctx, sessions)
+}
+
+func (s *SessionLogService) ensurePartition(ctx context.Context, t time.Time) error {
+	key := t.UTC().Format("2006-01-02")
+	s.mu.Lock()
+	done := s.ensured[key]
+	s.mu.Unlock()
+	if done {
+		return nil
+	}
+	if err := s.sessionRepo.EnsurePartition(ctx, t.UTC()); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.ensured[key] = true
+	s.mu.Unlock()
+	return nil
+}
+
+// ListByConnection returns sessions started in [from, to). Zero bounds default
+// to the last 24 hours.
+func (s *SessionLogService) ListByConnection(ctx context.Context, connectionID uuid.UUID, from, to time.Time, limit int) ([]domain.ConnectionSession, error) {
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.Add(-defaultSessionsRange)
+	}
+	if limit <= 0 {
+		limit = defaultSessionsLimit
+	}
+	if limit > maxSessionsLimit {
+		limit = maxSessionsLimit
+	}
+	sessions, err := s.sessionRepo.ListByConnection(ctx, connectionID, from, to, limit)
+	if err != nil {
+		return nil, err
+	}
+	if sessions == nil {
+		sessions = []domain.ConnectionSession{}
+	}
+	return sessions, nil
+}
+
+// EnsurePartitions creates partitions for today and the next two days.
+func (s *SessionLogService) EnsurePartitions(ctx context.Context) error {
+	now := time.Now().UTC()
+	for i := 0; i < 3; i++ {
+		if err := s.sessionRepo.EnsurePartition(ctx, now.AddDate(0, 0, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PruneSessions drops day partitions entirely older than the retention window.
+func (s *SessionLogService) PruneSessions(ctx context.Context, retention time.Duration) ([]string, error) {
+	return s.sessionRepo.DropPartitionsBefore(ctx, time.Now().UTC().Add(-retention))
+}
@@ -0,0 +1,262 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/repository"
+)
+
+// ErrInvalidRefreshToken is returned for unknown, expired, revoked or replayed refresh tokens.
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// sessionCacheTTL bounds how long another API instance may keep accepting
+// access tokens of a session revoked elsewhere.
+const sessionCacheTTL = 30 * time.Second
+
+type sessionCacheEntry struct {
+	active  bool
+	checked time.Time
+}
+
+// SessionService issues short-lived access tokens (JWTs carrying a session id)
+// and rotating refresh tokens backed by auth_sessions, for both staff users and
+// customers. Revoking a session stops its refresh token at once and its access
+// tokens within sessionCacheTTL.
+type SessionService struct {
+	sessionRepo  *repository.AuthSessionRepository
+	userRepo     *repository.UserRepository
+	customerRepo *repository.CustomerRepository
+	config       domain.JWTConfig
+
+	mu    sync.Mutex
+	cache map[uuid.UUID]sessionCacheEntry
+}
+
+func NewSessionService(sessionRepo *repository.AuthSessionRepository, userRepo *repository.UserRepository, customerRepo *repository.CustomerRepository, config domain.JWTConfig) *SessionService {
+	return &SessionService{
+		sessionRepo:  sessionRepo,
+		userRepo:     userRepo,
+		customerRepo: customerRepo,
+		config:       config,
+		cache:        make(map[uuid.UUID]sessionCacheEntry),
+	}
+}
+
+func (s *SessionService) accessTTL() time.Duration {
+	return time.Duration(s.config.AccessMinutes) * time.Minute
+}
+
+func (s *SessionService) refreshTTL() time.Duration {
+	return time.Duration(s.config.RefreshDays) * 24 * time.Hour
+}
+
+// IssueForUser starts a session for a staff user and records the login time.
+func (s *SessionService) IssueForUser(ctx context.Context, user *domain.User, client domain.ClientInfo) (*domain.TokenPair, error) {
+	pair, err := s.issue(ctx, domain.SessionSubjectUser, user.ID, user.Email, user.Role, client)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.userRepo.TouchLastLogin(ctx, user.ID); err != nil {
+		log.Printf("[sessions] record last login for user %s: %v", user.ID, err)
+	}
+	return pair, nil
+}
+
+// IssueForCustomer starts a session for a customer.
+func (s *SessionService) IssueForCustomer(ctx context.Context, customer *domain.Customer, client domain.ClientInfo) (*domain.TokenPair, error) {
+	return s.issue(ctx, domain.SessionSubjectCustomer, customer.ID, customer.Email, "customer", client)
+}
+
+func (s *SessionService) issue(ctx context.Context, subjectType string, subjectID uuid.UUID, email, role string, client domain.ClientInfo) (*domain.TokenPair, error) {
+	refresh, refreshHash, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	sess := &domain.AuthSession{
+		ID:          uuid.New(),
+		SubjectType: subjectType,
+		SubjectID:   subjectID,
+		UserAgent:   client.UserAgent,
+		IP:          client.IP,
+		ExpiresAt:   time.Now().Add(s.refreshTTL()),
+	}
+	if err := s.sessionRepo.Create(ctx, sess, refreshHash); err != nil {
+		return nil, fmt.Errorf("create session: %w", err)
+	}
+	return s.pair(sess.ID, subjectID, email, role, refresh)
+}
+
+// pair signs an access token for the session and bundles it with refresh.
+func (s *SessionService) pair(sessionID, subjectID uuid.UUID, email, role, refresh string) (*domain.TokenPair, error) {
+	expiresAt := time.Now().Add(s.accessTTL())
+	claims := JWTClaims{
+		UserID:    subjectID,
+		Email:     email,
+		Role:      role,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    "mobileproxy",
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(s.config.Secret))
+	if err != nil {
+		return nil, fmt.Errorf("sign token: %w", err)
+	}
+	return &domain.TokenPair{Token: token, RefreshToken: refresh, ExpiresAt: expiresAt}, nil
+}
+
+// Refresh exchanges a refresh token for a new token pair. Presenting a refresh
+// token that was already rotated means it leaked, so the whole session is
+// revoked. Suspended customers get no new tokens.
+func (s *SessionService) Refresh(ctx context.Context, refreshToken string, client domain.ClientInfo) (*domain.TokenPair, error) {
+	oldHash := hashToken(refreshToken)
+	sess, reused, err := s.sessionRepo.GetByRefreshHash(ctx, oldHash)
+	if err != nil {
+		return nil, err
+	}
+	if sess == nil || sess.RevokedAt != nil || time.Now().After(sess.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if reused {
+		log.Printf("[sessions] refresh token replayed for session %s (%s %s), revoking", sess.ID, sess.SubjectType, sess.SubjectID)
+		s.revoke(ctx, sess.ID, "", nil)
+		return nil, ErrInvalidRefreshToken
+	}
+
+	var email, role string
+	switch sess.SubjectType {
+	case domain.SessionSubjectUser:
+		user, err := s.userRepo.GetByID(ctx, sess.SubjectID)
+		if err != nil || !user.Active {
+			s.revoke(ctx, sess.ID, "", nil)
+			return nil, ErrInvalidRefreshToken
+		}
+		email, role = user.Email, user.Role
+	case domain.SessionSubjectCustomer:
+		customer, err := s.customerRepo.GetByID(ctx, sess.SubjectID)
+		if err != nil || !customer.Active {
+			s.revoke(ctx, sess.ID, "", nil)
+			return nil, ErrInvalidRefreshToken
+		}
+		email, role = customer.Email, "customer"
+	default:
+		return nil, ErrInvalidRefreshToken
+	}
+
+	refresh, newHash, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	ok, err := s.sessionRepo.Rotate(ctx, sess.ID, oldHash, newHash, client.IP, time.Now().Add(s.refreshTTL()))
+	if err != nil {
+		return nil, fmt.Errorf("rotate session: %w", err)
+	}
+	if !ok {
+		return nil, ErrInvalidRefreshToken
+	}
+	return s.pair(sess.ID, sess.SubjectID, email, role, refresh)
+}
+
+// Logout revokes the session a refresh token belongs to.
+func (s *SessionService) Logout(ctx context.Context, refreshToken string) error {
+	sess, _, err := s.sessionRepo.GetByRefreshHash(ctx, hashToken(refreshToken))
+	if err != nil {
+		return err
+	}
+	if sess != nil {
+		s.revoke(ctx, sess.ID, "", nil)
+	}
+	return nil
+}
+
+// List returns a subject's live sessions, flagging the one with id current.
+func (s *SessionService) List(ctx context.Context, subjectType string, subjectID, current uuid.UUID) ([]domain.AuthSession, error) {
+	sessions, err := s.sessionRepo.ListActive(ctx, subjectType, subjectID)
+	if err != nil {
+		return nil, err
+	}
+	if sessions == nil {
+		sessions = []domain.AuthSession{}
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+	return sessions, nil
+}
+
+// Revoke ends one of the subject's sessions.
+func (s *SessionService) Revoke(ctx context.Context, id uuid.UUID, subjectType string, subjectID uuid.UUID) error {
+	ok, err := s.sessionRepo.Revoke(ctx, id, subjectType, &subjectID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("session not found")
+	}
+	s.forget(id)
+	return nil
+}
+
+// RevokeAll logs a subject out everywhere.
+func (s *SessionService) RevokeAll(ctx context.Context, subjectType string, subjectID uuid.UUID) error {
+	ids, err := s.sessionRepo.RevokeAll(ctx, subjectType, subjectID)
+	if err != nil {
+		return fmt.Errorf("revoke sessions: %w", err)
+	}
+	for _, id := range ids {
+		s.forget(id)
+	}
+	return nil
+}
+
+func (s *SessionService) revoke(ctx context.Context, id uuid.UUID, subjectType string, subjectID *uuid.UUID) {
+	if _, err := s.sessionRepo.Revoke(ctx, id, subjectType, subjectID); err != nil {
+		log.Printf("[sessions] revoke %s: %v", id, err)
+	}
+	s.forget(id)
+}
+
+func (s *SessionService) forget(id uuid.UUID) {
+	s.mu.Lock()
+	s.cache[id] = sessionCacheEntry{active: false, checked: time.Now()}
+	s.mu.Unlock()
+}
+
+// IsActive reports whether an access token's session is still live, caching
+// the answer for sessionCacheTTL.
+func (s *SessionService) IsActive(ctx context.Context, id uuid.UUID) bool {
+	s.mu.Lock()
+	entry, ok := s.cache[id]
+	s.mu.Unlock()
+	if ok && time.Since(entry.checked) < sessionCacheTTL {
+		return entry.active
+	}
+
+	active, err := s.sessionRepo.IsActive(ctx, id)
+	if err != nil {
+		log.Printf("[sessions] check %s: %v", id, err)
+		return ok && entry.active // keep the last answer while the DB is unavailable
+	}
+	s.mu.Lock()
+	if len(s.cache) > 100000 {
+		s.cache = make(map[uuid.UUID]sessionCacheEntry)
+	}
+	s.cache[id] = sessionCacheEntry{active: active, checked: time.Now()}
+	s.mu.Unlock()
+	return active
+}
+
+// PruneSessions deletes sessions that ended more than retention ago.
+func (s *SessionService) PruneSessions(ctx context.Context, retention time.Duration) (int64, error) {
+	return s.sessionRepo.DeleteEndedBefore(ctx, time.Now().Add(-retention))
+}
@@ -0,0 +1,414 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/repository"
+)
+
+const (
+	maxMaintenanceWindow = 31 * 24 * time.Hour
+	maxMaintenanceList   = 500
+	// maintenanceClockSkew lets a window declared to start "now" through
+	// despite the client's clock running slightly behind.
+	maintenanceClockSkew = time.Minute
+)
+
+var (
+	ErrSLADeviceNotFound        = errors.New("device not found")
+	ErrMaintenanceWindowStarted = errors.New("maintenance window has already started and can no longer be changed")
+)
+
+// SLAQuery selects an uptime report. Period is YYYY-MM or an ISO week
+// (YYYY-Www) and defaults to the current month. Target is an uptime
+// percentage to check each device against.
+type SLAQuery struct {
+	Period     string
+	Target     *float64
+	CustomerID *uuid.UUID
+	DeviceID   *uuid.UUID
+}
+
+// SLAService computes uptime reports from device status transitions, leaving
+// out declared maintenance windows.
+type SLAService struct {
+	statusLogRepo   *repository.StatusLogRepository
+	deviceRepo      *repository.DeviceRepository
+	customerRepo    *repository.CustomerRepository
+	maintenanceRepo *repository.MaintenanceRepository
+}
+
+func NewSLAService(statusLogRepo *repository.StatusLogRepository, deviceRepo *repository.DeviceRepository, customerRepo *repository.CustomerRepository, maintenanceRepo *repository.MaintenanceRepository) *SLAService {
+	return &SLAService{
+		statusLogRepo:   statusLogRepo,
+		deviceRepo:      deviceRepo,
+		customerRepo:    customerRepo,
+		maintenanceRepo: maintenanceRepo,
+	}
+}
+
+// CreateMaintenanceWindow declares an upcoming maintenance window. Windows
+// can't start in the past: excluding downtime that already happened would
+// rewrite uptime reports after the fact.
+func (s *SLAService) CreateMaintenanceWindow(ctx context.Context, req *domain.MaintenanceWindowRequest, createdBy *uuid.UUID) (*domain.MaintenanceWindow, error) {
+	if req.StartsAt.Before(time.Now().Add(-maintenanceClockSkew)) {
+		return nil, errors.New("starts_at must not be in the past")
+	}
+	if !req.EndsAt.After(req.StartsAt) {
+		return nil, errors.New("ends_at must be after starts_at")
+	}
+	if req.EndsAt.Sub(req.StartsAt) > maxMaintenanceWindow {
+		return nil, errors.New("maintenance window must not exceed 31 days")
+	}
+	if req.DeviceID != nil {
+		if _, err := s.deviceRepo.GetByID(ctx, *req.DeviceID); err != nil {
+			return nil, ErrSLADeviceNotFound
+		}
+	}
+	w := &domain.MaintenanceWindow{
+		ID:        uuid.New(),
+		DeviceID:  req.DeviceID,
+		StartsAt:  req.StartsAt.UTC(),
+		EndsAt:    req.EndsAt.UTC(),
+		Reason:    req.Reason,
+		CreatedBy: createdBy,
+	}
+	if err := s.maintenanceRepo.Create(ctx, w); err != nil {
+		return nil, fmt.Errorf("create maintenance window: %w", err)
+	}
+	return w, nil
+}
+
+func (s *SLAService) ListMaintenanceWindows(ctx context.Context) ([]domain.MaintenanceWindow, error) {
+	return s.maintenanceRepo.List(ctx, maxMaintenanceList)
+}
+
+func (s *SLAService) GetMaintenanceWindow(ctx context.Context, id uuid.UUID) (*domain.MaintenanceWindow, error) {
+	return s.maintenanceRepo.GetByID(ctx, id)
+}
+
+// DeleteMaintenanceWindow removes a window that hasn't started yet. Once it
+// has, it's part of the reported uptime and stays.
+func (s *SLAService) DeleteMaintenanceWindow(ctx context.Context, id uuid.UUID) error {
+	w, err := s.maintenanceRepo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if w == nil {
+		return errors.New("maintenance window not found")
+	}
+	if !w.StartsAt.After(time.Now()) {
+		return ErrMaintenanceWindowStarted
+	}
+	return s.maintenanceRepo.Delete(ctx, id)
+}
+
+// Report returns the uptime of every device, with a per-customer breakdown.
+func (s *SLAService) Report(ctx context.Context, q SLAQuery) (*domain.SLAReport, error) {
+	devices, err := s.deviceRepo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.report(ctx, devices, q, true)
+}
+
+// ReportForReseller is Report limited to the devices allocated to a reseller.
+func (s *SLAService) ReportForReseller(ctx context.Context, resellerID uuid.UUID, q SLAQuery) (*domain.SLAReport, error) {
+	devices, err := s.deviceRepo.ListByReseller(ctx, resellerID)
+	if err != nil {
+		return nil, err
+	}
+	return s.report(ctx, devices, q, true)
+}
+
+// ReportForCustomer covers the devices a customer owns or has shared access
+// to. It has no customer breakdown and ignores q.CustomerID.
+func (s *SLAService) ReportForCustomer(ctx context.Context, customerID uuid.UUID, q SLAQuery) (*domain.SLAReport, error) {
+	devices, err := s.deviceRepo.ListByCustomer(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	q.CustomerID = nil
+	return s.report(ctx, devices, q, false)
+}
+
+func (s *SLAService) report(ctx context.Context, devices []domain.Device, q SLAQuery, byCustomer bool) (*domain.SLAReport, error) {
+	now := time.Now().UTC()
+	period, from, to, err := parseSLAPeriod(q.Period, now)
+	if err != nil {
+		return nil, err
+	}
+	if q.Target != nil && (*q.Target <= 0 || *q.Target > 100) {
+		return nil, errors.New("target must be a percentage between 0 and 100")
+	}
+
+	var selected []domain.Device
+	for _, d := range devices {
+		if q.DeviceID != nil && d.ID != *q.DeviceID {
+			continue
+		}
+		if q.CustomerID != nil && (d.CustomerID == nil || *d.CustomerID != *q.CustomerID) {
+			continue
+		}
+		selected = append(selected, d)
+	}
+	if q.DeviceID != nil && len(selected) == 0 {
+		return nil, ErrSLADeviceNotFound
+	}
+
+	windows, err := s.maintenanceRepo.ListOverlapping(ctx, from, to, q.DeviceID)
+	if err != nil {
+		return nil, fmt.Errorf("list maintenance windows: %w", err)
+	}
+
+	report := &domain.SLAReport{
+		Period:  period,
+		From:    from,
+		To:      to,
+		Target:  q.Target,
+		Devices: []domain.DeviceSLA{},
+	}
+	customers := make(map[uuid.UUID]*domain.CustomerSLA)
+	for i := range selected {
+		d := &selected[i]
+		stats, err := s.deviceStats(ctx, d, from, to, windows)
+		if err != nil {
+			return nil, err
+		}
+		finishSLAStats(&stats, q.Target)
+		report.Devices = append(report.Devices, domain.DeviceSLA{
+			DeviceID:   d.ID,
+			DeviceName: d.Name,
+			CustomerID: d.CustomerID,
+			SLAStats:   stats,
+		})
+		addSLAStats(&report.Fleet, &stats)
+
+		if !byCustomer || d.CustomerID == nil {
+			continue
+		}
+		cs, ok := customers[*d.CustomerID]
+		if !ok {
+			cs = &domain.CustomerSLA{CustomerID: *d.CustomerID}
+			if c, err := s.customerRepo.GetByID(ctx, *d.CustomerID); err == nil {
+				cs.CustomerName = c.Name
+			}
+			customers[*d.CustomerID] = cs
+		}
+		cs.Devices++
+		addSLAStats(&cs.SLAStats, &stats)
+	}
+	finishSLAStats(&report.Fleet, q.Target)
+
+	for _, cs := range customers {
+		finishSLAStats(&cs.SLAStats, q.Target)
+		report.Customers = append(report.Customers, *cs)
+	}
+	sort.Slice(report.Customers, func(i, j int) bool {
+		return report.Customers[i].CustomerName < report.Customers[j].CustomerName
+	})
+	return report, nil
+}
+
+type slaSegment struct {
+	status     string
+	start, end time.Time
+}
+
+// deviceStats replays a device's status transitions over [from, to). Time
+// before the device was registered is not counted.
+func (s *SLAService) deviceStats(ctx context.Context, d *domain.Device, from, to time.Time, windows []domain.MaintenanceWindow) (domain.SLAStats, error) {
+	var stats domain.SLAStats
+	if d.CreatedAt.After(from) {
+		from = d.CreatedAt.UTC()
+	}
+	if !from.Before(to) {
+		return stats, nil
+	}
+
+	logs, err := s.statusLogRepo.GetByDeviceAndRange(ctx, d.ID, from, to)
+	if err != nil {
+		return stats, fmt.Errorf("list status logs: %w", err)
+	}
+	status := "unknown"
+	if last, err := s.statusLogRepo.GetLastStatusBefore(ctx, d.ID, from); err == nil && last != nil {
+		status = last.Status
+	} else if len(logs) > 0 {
+		status = logs[0].PreviousStatus
+	}
+
+	segments := make([]slaSegment, 0, len(logs)+1)
+	start := from
+	for _, l := range logs {
+		if l.ChangedAt.After(start) {
+			segments = append(segments, slaSegment{status: status, start: start, end: l.ChangedAt})
+			start = l.ChangedAt
+		}
+		status = l.Status
+	}
+	segments = append(segments, slaSegment{status: status, start: start, end: to})
+
+	maintenance := mergeMaintenance(windows, d.ID, from, to)
+	for _, m := range maintenance {
+		stats.MaintenanceSeconds += int64(m[1].Sub(m[0]) / time.Second)
+	}
+
+	var run time.Duration
+	inOutage := false
+	for _, seg := range segments {
+		measured := seg.end.Sub(seg.start)
+		for _, m := range maintenance {
+			measured -= overlapDuration(seg.start, seg.end, m[0], m[1])
+		}
+		secs := int64(measured / time.Second)
+		switch slaStatusClass(seg.status) {
+		case "up":
+			stats.UpSeconds += secs
+			inOutage = false
+		case "down":
+			stats.DownSeconds += secs
+			if measured <= 0 {
+				continue // entirely inside maintenance
+			}
+			if !inOutage {
+				stats.Outages++
+				inOutage = true
+				run = 0
+			}
+			run += measured
+			if r := int64(run / time.Second); r > stats.LongestOutageSeconds {
+				stats.LongestOutageSeconds = r
+			}
+		default:
+			stats.UnknownSeconds += secs
+			inOutage = false
+		}
+	}
+	return stats, nil
+}
+
+func slaStatusClass(status string) string {
+	switch domain.DeviceStatus(status) {
+	case domain.DeviceStatusOnline, domain.DeviceStatusRotating:
+		return "up"
+	case domain.DeviceStatusOffline, domain.DeviceStatusError:
+		return "down"
+	}
+	return "unknown"
+}
+
+// mergeMaintenance returns the windows covering a device, clipped to
+// [from, to) and merged so overlapping windows aren't counted twice. windows
+// must be ordered by start.
+func mergeMaintenance(windows []domain.MaintenanceWindow, deviceID uuid.UUID, from, to time.Time) [][2]time.Time {
+	var merged [][2]time.Time
+	for _, w := range windows {
+		if w.DeviceID != nil && *w.DeviceID != deviceID {
+			continue
+		}
+		start, end := w.StartsAt, w.EndsAt
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if !start.Before(end) {
+			continue
+		}
+		if n := len(merged); n > 0 && !start.After(merged[n-1][1]) {
+			if end.After(merged[n-1][1]) {
+				merged[n-1][1] = end
+			}
+			continue
+		}
+		merged = append(merged, [2]time.Time{start, end})
+	}
+	return merged
+}
+
+func overlapDuration(aStart, aEnd, bStart, bEnd time.Time) time.Duration {
+	start, end := aStart, aEnd
+	if bStart.After(start) {
+		start = bStart
+	}
+	if bEnd.Before(end) {
+		end = bEnd
+	}
+	if !start.Before(end) {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+func addSLAStats(total, s *domain.SLAStats) {
+	total.UpSeconds += s.UpSeconds
+	total.DownSeconds += s.DownSeconds
+	total.MaintenanceSeconds += s.MaintenanceSeconds
+	total.UnknownSeconds += s.UnknownSeconds
+	total.Outages += s.Outages
+	if s.LongestOutageSeconds > total.LongestOutageSeconds {
+		total.LongestOutageSeconds = s.LongestOutageSeconds
+	}
+}
+
+// finishSLAStats fills in the uptime percentage, rounded to three decimals,
+// and whether it meets target.
+func finishSLAStats(s *domain.SLAStats, target *float64) {
+	measured := s.UpSeconds + s.DownSeconds
+	if measured == 0 {
+		return
+	}
+	pct := math.Round(float64(s.UpSeconds)/float64(measured)*100000) / 1000
+	s.UptimePercent = &pct
+	if target != nil {
+		met := pct >= *target
+		s.Met = &met
+	}
+}
+
+// parseSLAPeriod resolves a report period to its UTC bounds, with the end
+// capped at now. An empty period is the current month.
+func parseSLAPeriod(period string, now time.Time) (string, time.Time, time.Time, error) {
+	if period == "" {
+		period = now.Format("2006-01")
+	}
+	var from, to time.Time
+	var year, week int
+	if _, err := fmt.Sscanf(period, "%d-W%d", &year, &week); err == nil {
+		from = isoWeekStart(year, week)
+		if y, w := from.ISOWeek(); y != year || w != week || fmt.Sprintf("%04d-W%02d", year, week) != period {
+			return "", from, to, errors.New("invalid period, use YYYY-MM or YYYY-Www")
+		}
+		to = from.AddDate(0, 0, 7)
+	} else {
+		t, err := time.Parse("2006-01", period)
+		if err != nil {
+			return "", from, to, errors.New("invalid period, use YYYY-MM or YYYY-Www")
+		}
+		from = t
+		to = from.AddDate(0, 1, 0)
+	}
+	if !from.Before(now) {
+		return "", from, to, errors.New("period has not started yet")
+	}
+	if to.After(now) {
+		to = now
+	}
+	return period, from, to, nil
+}
+
+// isoWeekStart returns the Monday starting an ISO week. Week 1 is the week
+// containing January 4th.
+func isoWeekStart(year, week int) time.Time {
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+	monday := jan4.AddDate(0, 0, -((int(jan4.Weekday()) + 6) % 7))
+	return monday.AddDate(0, 0, 7*(week-1))
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/repository"
+)
+
+// statementCloseDelay is how long after a month ends before its statements are
+// closed, so late bandwidth reports (relays retry for up to a day) and the
+// rollups have caught up.
+const statementCloseDelay = 26 * time.Hour
+
+// StatementService builds monthly usage statements per customer. The current
+// month (and any month not yet closed) is computed on request; the worker
+// stores a closed statement for every customer once a month is over.
+type StatementService struct {
+	statementRepo *repository.StatementRepository
+	planRepo      *repository.PlanRepository
+}
+
+func NewStatementService(statementRepo *repository.StatementRepository, planRepo *repository.PlanRepository) *StatementService {
+	return &StatementService{statementRepo: statementRepo, planRepo: planRepo}
+}
+
+// List returns the customer's closed statements, newest first, preceded by the
+// open statement for the current month.
+func (s *StatementService) List(ctx context.Context, customerID uuid.UUID) ([]domain.Statement, error) {
+	current, err := s.build(ctx, customerID, monthStartUTC(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	closed, err := s.statementRepo.ListByCustomer(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	return append([]domain.Statement{*current}, closed...), nil
+}
+
+// Get returns the statement for the month containing periodStart: the closed
+// one if it exists, otherwise an open one computed now.
+func (s *StatementService) Get(ctx context.Context, customerID uuid.UUID, periodStart time.Time) (*domain.Statement, error) {
+	periodStart = monthStartUTC(periodStart)
+	if periodStart.After(time.Now()) {
+		return nil, fmt.Errorf("period %s has not started", periodStart.Format("2006-01"))
+	}
+	st, err := s.statementRepo.Get(ctx, customerID, periodStart)
+	if err != nil {
+		return nil, err
+	}
+	if st != nil {
+		return st, nil
+	}
+	return s.build(ctx, customerID, periodStart)
+}
+
+func (s *StatementService) build(ctx context.Context, customerID uuid.UUID, periodStart time.Time) (*domain.Statement, error) {
+	periodEnd := periodStart.AddDate(0, 1, 0)
+	st := &domain.Statement{
+		CustomerID:  customerID,
+		Status:      domain.StatementOpen,
+		PeriodStart: periodStart,
+		PeriodEnd:   periodEnd,
+	}
+
+	var err error
+	if st.Devices, st.DeviceDays, err = s.statementRepo.GetDeviceUsage(ctx, customerID, periodStart, periodEnd); err != nil {
+		return nil, fmt.Errorf("device usage: %w", err)
+	}
+	lines, err := s.statementRepo.GetConnectionLines(ctx, customerID, periodStart, periodEnd)
+	if err != nil {
+		return nil, fmt.Errorf("connection usage: %w", err)
+	}
+	if lines == nil {
+		lines = []domain.StatementLine{}
+	}
+	st.Lines = lines
+	for _, l := range lines {
+		st.ConnectionDays += l.Days
+		st.BytesIn += l.BytesIn
+		st.BytesOut += l.BytesOut
+	}
+	if st.Rotations, err = s.statementRepo.CountRotations(ctx, customerID, periodStart, periodEnd); err != nil {
+		return nil, fmt.Errorf("rotations: %w", err)
+	}
+
+	plan, err := s.planRepo.GetForCustomer(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	if plan != nil {
+		st.PlanName = plan.Name
+	}
+	return st, nil
+}
+
+// SnapshotUsage records what every customer holds today. The worker calls it
+// hourly so short-lived connections and shares still show up.
+func (s *StatementService) SnapshotUsage(ctx context.Context) error {
+	return s.statementRepo.SnapshotUsage(ctx, time.Now().UTC())
+}
+
+// CloseMonth stores statements for the previous month once statementCloseDelay
+// has passed since it ended. Returns how many statements were closed.
+func (s *StatementService) CloseMonth(ctx context.Context) (int, error) {
+	now := time.Now().UTC()
+	periodEnd := monthStartUTC(now)
+	if now.Sub(periodEnd) < statementCloseDelay {
+		periodEnd = periodEnd.AddDate(0, -1, 0)
+	}
+	periodStart := periodEnd.AddDate(0, -1, 0)
+
+	customerIDs, err := s.statementRepo.ListCustomersToClose(ctx, periodStart, periodEnd)
+	if err != nil {
+		return 0, err
+	}
+	closed := 0
+	for _, id := range customerIDs {
+		st, err := s.build(ctx, id, periodStart)
+		if err != nil {
+			return closed, fmt.Errorf("build statement for %s: %w", id, err)
+		}
+		if err := s.statementRepo.Create(ctx, st); err != nil {
+			return closed, fmt.Errorf("store statement for %s: %w", id, err)
+		}
+		closed++
+	}
+	return closed, nil
+}
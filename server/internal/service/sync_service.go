@@ -11,15 +11,20 @@ import (
 	"github.com/mobileproxy/server/internal/domain"
 )
 
+// relaySecretHeader carries the shared relay secret (see middleware.RelayAuth).
+const relaySecretHeader = "X-Relay-Secret"
+
 type SyncService struct {
-	peerAPIURL string
-	client     *http.Client
+	peerAPIURL  string
+	relaySecret string // sent on relay-authenticated internal routes
+	client      *http.Client
 }
 
-func NewSyncService(peerAPIURL string) *SyncService {
+func NewSyncService(peerAPIURL, relaySecret string) *SyncService {
 	return &SyncService{
-		peerAPIURL: peerAPIURL,
-		client:     &http.Client{Timeout: 3 * time.Second},
+		peerAPIURL:  peerAPIURL,
+		relaySecret: relaySecret,
+		client:      &http.Client{Timeout: 3 * time.Second},
 	}
 }
 
@@ -55,45 +60,49 @@ func (s *SyncService) SyncDevice(device *domain.Device, authToken string) {
 
 func (s *SyncService) SyncConnections(deviceID uuid.UUID, connections []domain.ProxyConnection) {
 	type connItem struct {
-		ID             uuid.UUID  `json:"id"`
-		DeviceID       uuid.UUID  `json:"device_id"`
-		CustomerID     *uuid.UUID `json:"customer_id"`
-		Username       string     `json:"username"`
-		PasswordHash   string     `json:"password_hash"`
-		PasswordPlain  string     `json:"password_plain"`
-		IPWhitelist    []string   `json:"ip_whitelist"`
-		BandwidthLimit int64      `json:"bandwidth_limit"`
-		BandwidthUsed  int64      `json:"bandwidth_used"`
-		Active         bool       `json:"active"`
-		ProxyType      string     `json:"proxy_type"`
-		BasePort       *int       `json:"base_port"`
-		HTTPPort       *int       `json:"http_port"`
-		SOCKS5Port     *int       `json:"socks5_port"`
-		ExpiresAt      *time.Time `json:"expires_at"`
-		CreatedAt      time.Time  `json:"created_at"`
-		UpdatedAt      time.Time  `json:"updated_at"`
+		ID                 uuid.UUID  `json:"id"`
+		DeviceID           uuid.UUID  `json:"device_id"`
+		CustomerID         *uuid.UUID `json:"customer_id"`
+		Username           string     `json:"username"`
+		PasswordHash       string     `json:"password_hash"`
+		PasswordPlain      string     `json:"password_plain"`
+		IPWhitelist        []string   `json:"ip_whitelist"`
+		BandwidthLimit     int64      `json:"bandwidth_limit"`
+		BandwidthUsed      int64      `json:"bandwidth_used"`
+		Active             bool       `json:"active"`
+		ProxyType          string     `json:"proxy_type"`
+		BasePort           *int       `json:"base_port"`
+		HTTPPort           *int       `json:"http_port"`
+		SOCKS5Port         *int       `json:"socks5_port"`
+		MaxConcurrentConns int        `json:"max_concurrent_conns"`
+		MaxConnsPerSecond  int        `json:"max_conns_per_second"`
+		ExpiresAt          *time.Time `json:"expires_at"`
+		CreatedAt          time.Time  `json:"created_at"`
+		UpdatedAt          time.Time  `json:"updated_at"`
 	}
 
 	items := make([]connItem, len(connections))
 	for i, c := range connections {
 		items[i] = connItem{
-			ID:             c.ID,
-			DeviceID:       c.DeviceID,
-			CustomerID:     c.CustomerID,
-			Username:       c.Username,
-			PasswordHash:   c.PasswordHash,
-			PasswordPlain:  c.PasswordHash,
-			IPWhitelist:    c.IPWhitelist,
-			BandwidthLimit: c.BandwidthLimit,
-			BandwidthUsed:  c.BandwidthUsed,
-			Active:         c.Active,
-			ProxyType:      c.ProxyType,
-			BasePort:       c.BasePort,
-			HTTPPort:       c.HTTPPort,
-			SOCKS5Port:     c.SOCKS5Port,
-			ExpiresAt:      c.ExpiresAt,
-			CreatedAt:      c.CreatedAt,
-			UpdatedAt:      c.UpdatedAt,
+			ID:                 c.ID,
+			DeviceID:           c.DeviceID,
+			CustomerID:         c.CustomerID,
+			Username:           c.Username,
+			PasswordHash:       c.PasswordHash,
+			PasswordPlain:      c.PasswordHash,
+			IPWhitelist:        c.IPWhitelist,
+			BandwidthLimit:     c.BandwidthLimit,
+			BandwidthUsed:      c.BandwidthUsed,
+			Active:             c.Active,
+			ProxyType:          c.ProxyType,
+			BasePort:           c.BasePort,
+			HTTPPort:           c.HTTPPort,
+			SOCKS5Port:         c.SOCKS5Port,
+			MaxConcurrentConns: c.MaxConcurrentConns,
+			MaxConnsPerSecond:  c.MaxConnsPerSecond,
+			ExpiresAt:          c.ExpiresAt,
+			CreatedAt:          c.CreatedAt,
+			UpdatedAt:          c.UpdatedAt,
 		}
 		if items[i].IPWhitelist == nil {
 			items[i].IPWhitelist = []string{}
@@ -115,14 +124,22 @@ func (s *SyncService) SyncConnections(deviceID uuid.UUID, connections []domain.P
 	log.Printf("[sync] SyncConnections device=%s (%d conns) to peer: %d", deviceID, len(connections), resp.StatusCode)
 }
 
-// SyncBandwidth forwards bandwidth flush data to the peer API server.
-func (s *SyncService) SyncBandwidth(data map[string]int64) {
-	body, _ := json.Marshal(data)
-	resp, err := s.client.Post(s.peerAPIURL+"/api/internal/bandwidth-flush?from_peer=1", "application/json", bytes.NewReader(body))
+// SyncBandwidthReport forwards a relay bandwidth report to the peer API server.
+// The peer dedupes on (relay_id, seq) just like the local API.
+func (s *SyncService) SyncBandwidthReport(report *domain.BandwidthReport) {
+	body, _ := json.Marshal(report)
+	req, err := http.NewRequest(http.MethodPost, s.peerAPIURL+"/api/internal/bandwidth-report?from_peer=1", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[sync] SyncBandwidthReport to peer failed: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(relaySecretHeader, s.relaySecret)
+	resp, err := s.client.Do(req)
 	if err != nil {
-		log.Printf("[sync] SyncBandwidth to peer failed: %v", err)
+		log.Printf("[sync] SyncBandwidthReport to peer failed: %v", err)
 		return
 	}
 	resp.Body.Close()
-	log.Printf("[sync] SyncBandwidth %d usernames to peer: %d", len(data), resp.StatusCode)
+	log.Printf("[sync] SyncBandwidthReport %s/%d (%d connections) to peer: %d", report.RelayID, report.Seq, len(report.Connections), resp.StatusCode)
 }
@@ -0,0 +1,274 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/repository"
+)
+
+const (
+	// telemetrySampleInterval downsamples heartbeats on write: a device gets
+	// at most one sample per interval unless its network type, carrier or
+	// charging state changed.
+	telemetrySampleInterval = time.Minute
+
+	defaultTelemetryRange = 24 * time.Hour
+	autoRawTelemetryRange = 48 * time.Hour // longer ranges default to hourly points
+	maxRawTelemetryRange  = 7 * 24 * time.Hour
+	maxTelemetryRange     = 366 * 24 * time.Hour
+	maxTelemetrySamples   = 20000
+)
+
+// TelemetryService records heartbeat telemetry as a time series and serves
+// it per device. Raw samples live in a table partitioned by day; the worker
+// downsamples them into hourly rows, which are kept longer.
+type TelemetryService struct {
+	telemetryRepo *repository.TelemetryRepository
+
+	mu      sync.Mutex
+	ensured map[string]bool // partition days already created by this process
+}
+
+func NewTelemetryService(telemetryRepo *repository.TelemetryRepository) *TelemetryService {
+	return &TelemetryService{telemetryRepo: telemetryRepo, ensured: make(map[string]bool)}
+}
+
+// Record stores the telemetry of a heartbeat. prev is the device row before
+// the heartbeat was applied.
+func (s *TelemetryService) Record(ctx context.Context, prev *domain.Device, req *domain.HeartbeatRequest) error {
+	sample := &domain.TelemetrySample{
+		DeviceID:        prev.ID,
+		RecordedAt:      time.Now().UTC(),
+		BatteryCharging: req.BatteryCharging,
+		NetworkType:     req.NetworkType,
+		Carrier:         req.Carrier,
+	}
+	// The app reports 0 when it couldn't read the battery or signal
+	if req.BatteryLevel > 0 {
+		level := req.BatteryLevel
+		sample.BatteryLevel = &level
+	}
+	if req.SignalStrength != 0 {
+		signal := req.SignalStrength
+		sample.SignalStrength = &signal
+	}
+	changed := prev.NetworkType != req.NetworkType || prev.Carrier != req.Carrier ||
+		prev.BatteryCharging != req.BatteryCharging
+
+	if err := s.ensurePartition(ctx, sample.RecordedAt); err != nil {
+		return fmt.Errorf("ensure partition: %w", err)
+	}
+	_, err := s.telemetryRepo.Record(ctx, sample, telemetrySampleInterval, changed)
+	return err
+}
+
+func (s *TelemetryService) ensurePartition(ctx context.Context, t time.Time) error {
+	key := t.UTC().Format("2006-01-02")
+	s.mu.Lock()
+	done := s.ensured[key]
+	s.mu.Unlock()
+	if done {
+		return nil
+	}
+	if err := s.telemetryRepo.EnsurePartition(ctx, t.UTC()); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.ensured[key] = true
+	s.mu.Unlock()
+	return nil
+}
+
+// Series returns one metric of a device over [from, to). Zero bounds default
+// to the last 24 hours. An empty resolution picks raw samples for ranges up
+// to 48 hours and hourly points beyond.
+func (s *TelemetryService) Series(ctx context.Context, deviceID uuid.UUID, metric, resolution string, from, to time.Time) (*domain.TelemetrySeries, error) {
+	switch metric {
+	case domain.TelemetrySignal, domain.TelemetryBattery, domain.TelemetryNetwork, domain.TelemetryCarrier:
+	default:
+		return nil, errors.New("metric must be one of signal, battery, network, carrier")
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.Add(-defaultTelemetryRange)
+	}
+	if !from.Before(to) {
+		return nil, errors.New("from must be before to")
+	}
+	span := to.Sub(from)
+	if span > maxTelemetryRange {
+		return nil, errors.New("range must not exceed 366 days")
+	}
+	switch resolution {
+	case "":
+		resolution = domain.TelemetryResolutionRaw
+		if span > autoRawTelemetryRange {
+			resolution = domain.TelemetryResolutionHour
+		}
+	case domain.TelemetryResolutionRaw:
+		if span > maxRawTelemetryRange {
+			return nil, errors.New("raw resolution is limited to 7 days, use resolution=hour")
+		}
+	case domain.TelemetryResolutionHour:
+	default:
+		return nil, errors.New("resolution must be raw or hour")
+	}
+
+	series := &domain.TelemetrySeries{
+		DeviceID:   deviceID,
+		Metric:     metric,
+		Resolution: resolution,
+		From:       from,
+		To:         to,
+		Points:     []domain.TelemetryPoint{},
+	}
+	if resolution == domain.TelemetryResolutionRaw {
+		samples, err := s.telemetryRepo.ListSamples(ctx, deviceID, from, to, maxTelemetrySamples)
+		if err != nil {
+			return nil, err
+		}
+		for i := range samples {
+			if p, ok := samplePoint(&samples[i], metric); ok {
+				series.Points = append(series.Points, p)
+			}
+		}
+		return series, nil
+	}
+
+	hours, err := s.telemetryRepo.ListHourly(ctx, deviceID, from.Truncate(time.Hour), to)
+	if err != nil {
+		return nil, err
+	}
+	for i := range hours {
+		if p, ok := hourlyPoint(&hours[i], metric); ok {
+			series.Points = append(series.Points, p)
+		}
+	}
+	return series, nil
+}
+
+// samplePoint extracts a metric from a raw sample. Reports false if the
+// sample doesn't carry it.
+func samplePoint(s *domain.TelemetrySample, metric string) (domain.TelemetryPoint, bool) {
+	p := domain.TelemetryPoint{Time: s.RecordedAt}
+	switch metric {
+	case domain.TelemetrySignal:
+		if s.SignalStrength == nil {
+			return p, false
+		}
+		p.Value = *s.SignalStrength
+	case domain.TelemetryBattery:
+		if s.BatteryLevel == nil {
+			return p, false
+		}
+		charging := s.BatteryCharging
+		p.Value = *s.BatteryLevel
+		p.Charging = &charging
+	case domain.TelemetryNetwork:
+		if s.NetworkType == "" {
+			return p, false
+		}
+		p.Value = s.NetworkType
+	case domain.TelemetryCarrier:
+		if s.Carrier == "" {
+			return p, false
+		}
+		p.Value = s.Carrier
+	}
+	return p, true
+}
+
+// hourlyPoint extracts a metric from an hourly row. Numeric metrics carry
+// the hour's average, rounded to one decimal, with its min and max.
+func hourlyPoint(h *domain.TelemetryHourly, metric string) (domain.TelemetryPoint, bool) {
+	p := domain.TelemetryPoint{Time: h.Bucket}
+	switch metric {
+	case domain.TelemetrySignal:
+		if h.SignalAvg == nil {
+			return p, false
+		}
+		p.Value = math.Round(*h.SignalAvg*10) / 10
+		p.Min, p.Max = h.SignalMin, h.SignalMax
+	case domain.TelemetryBattery:
+		if h.BatteryAvg == nil {
+			return p, false
+		}
+		p.Value = math.Round(*h.BatteryAvg*10) / 10
+		p.Min, p.Max = h.BatteryMin, h.BatteryMax
+	case domain.TelemetryNetwork:
+		if h.NetworkType == "" {
+			return p, false
+		}
+		p.Value = h.NetworkType
+	case domain.TelemetryCarrier:
+		if h.Carrier == "" {
+			return p, false
+		}
+		p.Value = h.Carrier
+	}
+	return p, true
+}
+
+// EnsurePartitions creates partitions for today and the next two days.
+func (s *TelemetryService) EnsurePartitions(ctx context.Context) error {
+	now := time.Now().UTC()
+	for i := 0; i < 3; i++ {
+		if err := s.telemetryRepo.EnsurePartition(ctx, now.AddDate(0, 0, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunRollup recomputes the hourly telemetry of the previous and current hour,
+// or from the newest hourly bucket if the worker fell further behind.
+// Returns the number of hourly rows written.
+func (s *TelemetryService) RunRollup(ctx context.Context) (int64, error) {
+	latest, err := s.telemetryRepo.LatestHourlyBucket(ctx)
+	if err != nil {
+		return 0, err
+	}
+	from := time.Now().UTC().Truncate(time.Hour).Add(-time.Hour)
+	if latest.Before(from) {
+		from = latest // zero on the first run: downsample everything
+	}
+	return s.telemetryRepo.RollupSince(ctx, from)
+}
+
+// PruneSamples drops raw day partitions entirely older than the retention
+// window. A zero retention keeps everything. Partitions the rollup hasn't
+// reached yet are kept regardless, so a worker that was down longer than the
+// retention doesn't lose samples that were never downsampled.
+func (s *TelemetryService) PruneSamples(ctx context.Context, retention time.Duration) ([]string, error) {
+	if retention <= 0 {
+		return nil, nil
+	}
+	cutoff := time.Now().UTC().Add(-retention)
+	latest, err := s.telemetryRepo.LatestHourlyBucket(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get latest hourly bucket: %w", err)
+	}
+	// Every sample before the newest hourly bucket has been rolled up
+	if latest.Before(cutoff) {
+		cutoff = latest
+	}
+	return s.telemetryRepo.DropPartitionsBefore(ctx, cutoff)
+}
+
+// PruneHourly deletes hourly telemetry older than the retention window. A
+// zero retention keeps everything.
+func (s *TelemetryService) PruneHourly(ctx context.Context, retention time.Duration) (int64, error) {
+	if retention <= 0 {
+		return 0, nil
+	}
+	return s.telemetryRepo.DeleteHourlyBefore(ctx, time.Now().UTC().Add(-retention))
+}
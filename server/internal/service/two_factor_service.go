@@ -0,0 +1,518 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/repository"
+)
+
+const (
+	totpPeriod = 30 // seconds per code (RFC 6238 default)
+	totpDigits = 6  // totpCode formats exactly six
+	totpSkew   = 1  // accept codes one period early or late for clock drift
+
+	twoFactorIssuer       = "PocketProxy"
+	twoFactorChallengeTTL = 5 * time.Minute
+	twoFactorMaxAttempts  = 5
+	twoFactorLockout      = 15 * time.Minute
+	recoveryCodeCount     = 10
+
+	settingRequireOperator2FA = "require_operator_2fa"
+)
+
+var (
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+	ErrTwoFactorLocked      = errors.New("too many invalid two-factor codes, try again later")
+	ErrInvalidChallenge     = errors.New("invalid or expired two-factor challenge")
+)
+
+// TwoFactorRequiredError is returned by a login whose password was accepted
+// but which still needs a second factor. Challenge goes back to the client,
+// which completes the login with TwoFactorService.CompleteLogin.
+type TwoFactorRequiredError struct {
+	Challenge *domain.TwoFactorChallenge
+}
+
+func (e *TwoFactorRequiredError) Error() string {
+	return "two-factor authentication required"
+}
+
+// twoFactorClaims identify the subject of a pending login. They are signed
+// with a key derived from the JWT secret so they never pass as access tokens.
+type twoFactorClaims struct {
+	SubjectType string    `json:"subject_type"`
+	SubjectID   uuid.UUID `json:"subject_id"`
+	Setup       bool      `json:"setup,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// TwoFactorService manages TOTP enrollment and recovery codes for staff users
+// and customers, and the second step of their logins.
+type TwoFactorService struct {
+	twoFactorRepo  *repository.TwoFactorRepository
+	settingsRepo   *repository.SettingsRepository
+	userRepo       *repository.UserRepository
+	customerRepo   *repository.CustomerRepository
+	sessionService *SessionService
+	challengeKey   []byte
+}
+
+func NewTwoFactorService(
+	twoFactorRepo *repository.TwoFactorRepository,
+	settingsRepo *repository.SettingsRepository,
+	userRepo *repository.UserRepository,
+	customerRepo *repository.CustomerRepository,
+	sessionService *SessionService,
+	config domain.JWTConfig,
+) *TwoFactorService {
+	return &TwoFactorService{
+		twoFactorRepo:  twoFactorRepo,
+		settingsRepo:   settingsRepo,
+		userRepo:       userRepo,
+		customerRepo:   customerRepo,
+		sessionService: sessionService,
+		challengeKey:   []byte(config.Secret + ":2fa"),
+	}
+}
+
+// ─── Settings ─────────────────────────────────────────────────────────────────
+
+func (s *TwoFactorService) GetSettings(ctx context.Context) (*domain.SecuritySettings, error) {
+	v, err := s.settingsRepo.Get(ctx, settingRequireOperator2FA)
+	if err != nil {
+		return nil, fmt.Errorf("get settings: %w", err)
+	}
+	return &domain.SecuritySettings{RequireOperator2FA: v == "true"}, nil
+}
+
+func (s *TwoFactorService) UpdateSettings(ctx context.Context, settings *domain.SecuritySettings) error {
+	v := "false"
+	if settings.RequireOperator2FA {
+		v = "true"
+	}
+	return s.settingsRepo.Set(ctx, settingRequireOperator2FA, v)
+}
+
+// required reports whether a subject may not log in without 2FA.
+func (s *TwoFactorService) required(ctx context.Context, subjectType, role string) (bool, error) {
+	if subjectType != domain.SessionSubjectUser || role != "operator" {
+		return false, nil
+	}
+	settings, err := s.GetSettings(ctx)
+	if err != nil {
+		return false, err
+	}
+	return settings.RequireOperator2FA, nil
+}
+
+// ─── Enrollment ───────────────────────────────────────────────────────────────
+
+func (s *TwoFactorService) Status(ctx context.Context, subjectType string, subjectID uuid.UUID, role string) (*domain.TwoFactorStatus, error) {
+	tf, err := s.twoFactorRepo.Get(ctx, subjectType, subjectID)
+	if err != nil {
+		return nil, err
+	}
+	required, err := s.required(ctx, subjectType, role)
+	if err != nil {
+		return nil, err
+	}
+	status := &domain.TwoFactorStatus{Required: required}
+	if tf == nil {
+		return status, nil
+	}
+	status.Enabled = tf.EnabledAt != nil
+	status.Pending = tf.EnabledAt == nil
+	status.EnabledAt = tf.EnabledAt
+	if status.Enabled {
+		if status.RecoveryCodesLeft, err = s.twoFactorRepo.CountRecoveryCodes(ctx, subjectType, subjectID); err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// Enroll starts (or restarts) enrollment with a fresh secret. It takes effect
+// once Confirm sees a valid code for it.
+func (s *TwoFactorService) Enroll(ctx context.Context, subjectType string, subjectID uuid.UUID) (*domain.TwoFactorEnrollment, error) {
+	account, err := s.accountName(ctx, subjectType, subjectID)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	ok, err := s.twoFactorRepo.SavePending(ctx, subjectType, subjectID, secret)
+	if err != nil {
+		return nil, fmt.Errorf("save enrollment: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("two-factor authentication is already enabled")
+	}
+	return &domain.TwoFactorEnrollment{Secret: secret, OTPAuthURL: otpauthURL(secret, account)}, nil
+}
+
+// Confirm enables a pending enrollment and returns the recovery codes, which
+// are not shown again.
+func (s *TwoFactorService) Confirm(ctx context.Context, subjectType string, subjectID uuid.UUID, code string) ([]string, error) {
+	tf, err := s.twoFactorRepo.Get(ctx, subjectType, subjectID)
+	if err != nil {
+		return nil, err
+	}
+	if tf == nil || tf.EnabledAt != nil {
+		return nil, fmt.Errorf("no pending two-factor enrollment")
+	}
+	if err := s.checkLock(tf); err != nil {
+		return nil, err
+	}
+	step, ok := matchTOTP(tf.Secret, normalizeCode(code), time.Now(), tf.LastUsedStep)
+	if !ok {
+		s.recordFailure(ctx, tf)
+		return nil, ErrInvalidTwoFactorCode
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.twoFactorRepo.Enable(ctx, subjectType, subjectID, step, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable turns 2FA off after checking a current code. Operators cannot while
+// the requirement is on.
+func (s *TwoFactorService) Disable(ctx context.Context, subjectType string, subjectID uuid.UUID, role, code string) error {
+	required, err := s.required(ctx, subjectType, role)
+	if err != nil {
+		return err
+	}
+	if required {
+		return fmt.Errorf("two-factor authentication is required for operators")
+	}
+	tf, err := s.enabled(ctx, subjectType, subjectID)
+	if err != nil {
+		return err
+	}
+	if err := s.verify(ctx, tf, code); err != nil {
+		return err
+	}
+	return s.twoFactorRepo.Delete(ctx, subjectType, subjectID)
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after checking a current code.
+func (s *TwoFactorService) RegenerateRecoveryCodes(ctx context.Context, subjectType string, subjectID uuid.UUID, code string) ([]string, error) {
+	tf, err := s.enabled(ctx, subjectType, subjectID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.verify(ctx, tf, code); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.twoFactorRepo.ReplaceRecoveryCodes(ctx, subjectType, subjectID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Reset removes a subject's 2FA without a code (admin recovery for a lost device).
+func (s *TwoFactorService) Reset(ctx context.Context, subjectType string, subjectID uuid.UUID) error {
+	return s.twoFactorRepo.Delete(ctx, subjectType, subjectID)
+}
+
+// ─── Login ────────────────────────────────────────────────────────────────────
+
+// LoginChallenge returns the challenge a login must answer before a session
+// is issued, or nil if the subject needs no second factor.
+func (s *TwoFactorService) LoginChallenge(ctx context.Context, subjectType string, subjectID uuid.UUID, role string) (*domain.TwoFactorChallenge, error) {
+	tf, err := s.twoFactorRepo.Get(ctx, subjectType, subjectID)
+	if err != nil {
+		return nil, err
+	}
+	setup := false
+	if tf == nil || tf.EnabledAt == nil {
+		required, err := s.required(ctx, subjectType, role)
+		if err != nil {
+			return nil, err
+		}
+		if !required {
+			return nil, nil
+		}
+		setup = true
+	}
+
+	expiresAt := time.Now().Add(twoFactorChallengeTTL)
+	claims := twoFactorClaims{
+		SubjectType: subjectType,
+		SubjectID:   subjectID,
+		Setup:       setup,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    "mobileproxy",
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.challengeKey)
+	if err != nil {
+		return nil, fmt.Errorf("sign challenge: %w", err)
+	}
+	return &domain.TwoFactorChallenge{
+		TwoFactorRequired: true,
+		SetupRequired:     setup,
+		ChallengeToken:    token,
+		ExpiresAt:         expiresAt,
+	}, nil
+}
+
+// SetupFromChallenge starts the enrollment a setup challenge asks for.
+func (s *TwoFactorService) SetupFromChallenge(ctx context.Context, challengeToken string) (*domain.TwoFactorEnrollment, error) {
+	claims, err := s.parseChallenge(challengeToken)
+	if err != nil {
+		return nil, err
+	}
+	if !claims.Setup {
+		return nil, fmt.Errorf("two-factor authentication is already enabled")
+	}
+	return s.Enroll(ctx, claims.SubjectType, claims.SubjectID)
+}
+
+// CompleteLogin checks the code for a challenge and issues the session. For a
+// setup challenge the code confirms the new enrollment and the recovery codes
+// are returned with the session.
+func (s *TwoFactorService) CompleteLogin(ctx context.Context, challengeToken, code string, client domain.ClientInfo) (*domain.TwoFactorLoginResponse, error) {
+	claims, err := s.parseChallenge(challengeToken)
+	if err != nil {
+		return nil, err
+	}
+
+	tf, err := s.twoFactorRepo.Get(ctx, claims.SubjectType, claims.SubjectID)
+	if err != nil {
+		return nil, err
+	}
+	var recoveryCodes []string
+	switch {
+	case tf == nil:
+		return nil, ErrInvalidChallenge
+	case tf.EnabledAt == nil:
+		if !claims.Setup {
+			return nil, ErrInvalidChallenge
+		}
+		if recoveryCodes, err = s.Confirm(ctx, claims.SubjectType, claims.SubjectID, code); err != nil {
+			return nil, err
+		}
+	default:
+		if err := s.verify(ctx, tf, code); err != nil {
+			return nil, err
+		}
+	}
+
+	resp := &domain.TwoFactorLoginResponse{RecoveryCodes: recoveryCodes}
+	var pair *domain.TokenPair
+	switch claims.SubjectType {
+	case domain.SessionSubjectUser:
+		user, err := s.userRepo.GetByID(ctx, claims.SubjectID)
+		if err != nil || !user.Active {
+			return nil, ErrInvalidChallenge
+		}
+		if pair, err = s.sessionService.IssueForUser(ctx, user, client); err != nil {
+			return nil, err
+		}
+		resp.User = user
+	case domain.SessionSubjectCustomer:
+		customer, err := s.customerRepo.GetByID(ctx, claims.SubjectID)
+		if err != nil || !customer.Active {
+			return nil, ErrInvalidChallenge
+		}
+		if pair, err = s.sessionService.IssueForCustomer(ctx, customer, client); err != nil {
+			return nil, err
+		}
+		resp.Customer = customer
+	default:
+		return nil, ErrInvalidChallenge
+	}
+	resp.Token, resp.RefreshToken, resp.ExpiresAt = pair.Token, pair.RefreshToken, pair.ExpiresAt
+	return resp, nil
+}
+
+func (s *TwoFactorService) parseChallenge(tokenString string) (*twoFactorClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &twoFactorClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return s.challengeKey, nil
+	})
+	if err != nil {
+		return nil, ErrInvalidChallenge
+	}
+	claims, ok := token.Claims.(*twoFactorClaims)
+	if !ok || !token.Valid {
+		return nil, ErrInvalidChallenge
+	}
+	return claims, nil
+}
+
+// ─── Code checks ──────────────────────────────────────────────────────────────
+
+func (s *TwoFactorService) enabled(ctx context.Context, subjectType string, subjectID uuid.UUID) (*domain.TwoFactor, error) {
+	tf, err := s.twoFactorRepo.Get(ctx, subjectType, subjectID)
+	if err != nil {
+		return nil, err
+	}
+	if tf == nil || tf.EnabledAt == nil {
+		return nil, fmt.Errorf("two-factor authentication is not enabled")
+	}
+	return tf, nil
+}
+
+// verify accepts a TOTP code (once) or an unused recovery code. Repeated
+// failures lock the subject out for twoFactorLockout.
+func (s *TwoFactorService) verify(ctx context.Context, tf *domain.TwoFactor, code string) error {
+	if err := s.checkLock(tf); err != nil {
+		return err
+	}
+	code = normalizeCode(code)
+	if len(code) == totpDigits {
+		if step, ok := matchTOTP(tf.Secret, code, time.Now(), tf.LastUsedStep); ok {
+			used, err := s.twoFactorRepo.UseStep(ctx, tf.SubjectType, tf.SubjectID, step)
+			if err != nil {
+				return err
+			}
+			if used {
+				return nil
+			}
+		}
+	} else if code != "" {
+		used, err := s.twoFactorRepo.UseRecoveryCode(ctx, tf.SubjectType, tf.SubjectID, hashToken(code))
+		if err != nil {
+			return err
+		}
+		if used {
+			return nil
+		}
+	}
+	s.recordFailure(ctx, tf)
+	return ErrInvalidTwoFactorCode
+}
+
+func (s *TwoFactorService) checkLock(tf *domain.TwoFactor) error {
+	if tf.LockedUntil != nil && time.Now().Before(*tf.LockedUntil) {
+		return ErrTwoFactorLocked
+	}
+	return nil
+}
+
+func (s *TwoFactorService) recordFailure(ctx context.Context, tf *domain.TwoFactor) {
+	_ = s.twoFactorRepo.RecordFailure(ctx, tf.SubjectType, tf.SubjectID, twoFactorMaxAttempts, time.Now().Add(twoFactorLockout))
+}
+
+func (s *TwoFactorService) accountName(ctx context.Context, subjectType string, subjectID uuid.UUID) (string, error) {
+	switch subjectType {
+	case domain.SessionSubjectUser:
+		user, err := s.userRepo.GetByID(ctx, subjectID)
+		if err != nil {
+			return "", fmt.Errorf("user not found")
+		}
+		return user.Email, nil
+	case domain.SessionSubjectCustomer:
+		customer, err := s.customerRepo.GetByID(ctx, subjectID)
+		if err != nil {
+			return "", fmt.Errorf("customer not found")
+		}
+		return customer.Email, nil
+	}
+	return "", fmt.Errorf("unknown subject type %q", subjectType)
+}
+
+// ─── TOTP (RFC 6238) ──────────────────────────────────────────────────────────
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateTOTPSecret returns a random 160-bit secret, base32-encoded as
+// authenticator apps expect.
+func generateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate totp secret: %w", err)
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+func otpauthURL(secret, account string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", twoFactorIssuer)
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + url.PathEscape(twoFactorIssuer+":"+account) + "?" + v.Encode()
+}
+
+// totpCode computes the HOTP value (RFC 4226) for a time step.
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", v%1000000)
+}
+
+// matchTOTP checks code against the steps around now, ignoring steps at or
+// before lastUsed. Returns the matching step.
+func matchTOTP(secret, code string, now time.Time, lastUsed int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastUsed {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// ─── Recovery codes ───────────────────────────────────────────────────────────
+
+// generateRecoveryCodes returns recoveryCodeCount codes formatted as
+// "xxxxx-xxxxx" and the hashes of their normalized form.
+func generateRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("generate recovery code: %w", err)
+		}
+		raw := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, hashToken(raw))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeCode strips the separators users type or paste with codes.
+func normalizeCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
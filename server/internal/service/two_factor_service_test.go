@@ -0,0 +1,83 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the RFC 6238 SHA-1 test key "12345678901234567890" in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	key, err := totpEncoding.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Appendix B vectors, truncated to six digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(key, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("totpCode(T=%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	key, _ := totpEncoding.DecodeString(rfc6238Secret)
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / totpPeriod
+	codeAt := func(step int64) string { return totpCode(key, step) }
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		lastUsed int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", rfc6238Secret, codeAt(current), 0, current, true},
+		{"one step early", rfc6238Secret, codeAt(current - 1), 0, current - 1, true},
+		{"one step late", rfc6238Secret, codeAt(current + 1), 0, current + 1, true},
+		{"two steps early", rfc6238Secret, codeAt(current - 2), 0, 0, false},
+		{"two steps late", rfc6238Secret, codeAt(current + 2), 0, 0, false},
+		{"replay of used step", rfc6238Secret, codeAt(current), current, 0, false},
+		{"older step after newer one used", rfc6238Secret, codeAt(current - 1), current, 0, false},
+		{"newer step after older one used", rfc6238Secret, codeAt(current + 1), current, current + 1, true},
+		{"wrong code", rfc6238Secret, "000000", 0, 0, false},
+		{"short code", rfc6238Secret, codeAt(current)[:5], 0, 0, false},
+		{"bad secret", "not base32!", codeAt(current), 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := matchTOTP(tt.secret, tt.code, now, tt.lastUsed)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("matchTOTP() = %d, %v; want %d, %v", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNormalizeCode(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{" 123 456 ", "123456"},
+		{"ABCDE-FGHIJ", "abcdefghij"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeCode(tt.in); got != tt.want {
+			t.Errorf("normalizeCode(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
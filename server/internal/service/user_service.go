@@ -0,0 +1,248 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	staffInviteTTL = 72 * time.Hour
+	staffResetTTL  = 24 * time.Hour
+)
+
+var validPermissions = func() map[string]bool {
+	m := make(map[string]bool)
+	for _, p := range domain.AllPermissions {
+		m[p] = true
+	}
+	return m
+}()
+
+// UserService manages staff accounts (admins and operators): invites,
+// permissions, disabling and password resets.
+type UserService struct {
+	userRepo       *repository.UserRepository
+	tokenRepo      *repository.UserTokenRepository
+	emailService   *EmailService
+	sessionService *SessionService
+}
+
+func NewUserService(userRepo *repository.UserRepository, tokenRepo *repository.UserTokenRepository, emailService *EmailService, sessionService *SessionService) *UserService {
+	return &UserService{userRepo: userRepo, tokenRepo: tokenRepo, emailService: emailService, sessionService: sessionService}
+}
+
+func (s *UserService) List(ctx context.Context) ([]domain.User, error) {
+	users, err := s.userRepo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if users == nil {
+		users = []domain.User{}
+	}
+	return users, nil
+}
+
+func (s *UserService) Get(ctx context.Context, id uuid.UUID) (*domain.User, error) {
+	return s.userRepo.GetByID(ctx, id)
+}
+
+// Create adds a staff user. Without a password the account cannot log in until
+// the user follows the emailed invite link.
+func (s *UserService) Create(ctx context.Context, req *domain.CreateUserRequest) (*domain.User, error) {
+	perms, err := normalizePermissions(req.Role, req.Permissions)
+	if err != nil {
+		return nil, err
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if _, err := s.userRepo.GetByEmail(ctx, email); err == nil {
+		return nil, fmt.Errorf("email already registered")
+	}
+
+	user := &domain.User{
+		ID:          uuid.New(),
+		Email:       email,
+		Name:        req.Name,
+		Role:        req.Role,
+		Active:      true,
+		Permissions: perms,
+	}
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("hash password: %w", err)
+		}
+		user.PasswordHash = string(hash)
+	}
+	if err := s.userRepo.Create(ctx, user); err != nil {
+		return nil, fmt.Errorf("create user: %w", err)
+	}
+	if req.Password == "" {
+		if err := s.sendPasswordLink(ctx, user, true); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+// Update changes a user's name, role or permissions. Admins cannot demote
+// themselves, and the last active admin cannot be demoted.
+func (s *UserService) Update(ctx context.Context, actorID, id uuid.UUID, req *domain.UpdateUserRequest) (*domain.User, error) {
+	user, err := s.userRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	if req.Name != nil {
+		user.Name = *req.Name
+	}
+	if req.Role != nil && *req.Role != user.Role {
+		if user.Role == "admin" {
+			if id == actorID {
+				return nil, fmt.Errorf("you cannot change your own role")
+			}
+			if err := s.checkNotLastAdmin(ctx, user); err != nil {
+				return nil, err
+			}
+		}
+		user.Role = *req.Role
+	}
+	perms := user.Permissions
+	if req.Permissions != nil {
+		perms = *req.Permissions
+	}
+	if user.Permissions, err = normalizePermissions(user.Role, perms); err != nil {
+		return nil, err
+	}
+	if err := s.userRepo.Update(ctx, user); err != nil {
+		return nil, fmt.Errorf("update user: %w", err)
+	}
+	return user, nil
+}
+
+// SetActive enables or disables a user. Disabling ends all their sessions.
+func (s *UserService) SetActive(ctx context.Context, actorID, id uuid.UUID, active bool) error {
+	user, err := s.userRepo.GetByID(ctx, id)
+	if err != nil {
+		return fmt.Errorf("user not found")
+	}
+	if !active {
+		if id == actorID {
+			return fmt.Errorf("you cannot disable your own account")
+		}
+		if user.Role == "admin" && user.Active {
+			if err := s.checkNotLastAdmin(ctx, user); err != nil {
+				return err
+			}
+		}
+	}
+	if err := s.userRepo.UpdateActive(ctx, id, active); err != nil {
+		return fmt.Errorf("update user: %w", err)
+	}
+	if !active {
+		return s.sessionService.RevokeAll(ctx, domain.SessionSubjectUser, id)
+	}
+	return nil
+}
+
+// ResetPassword sets password directly, or with an empty password emails the
+// user a reset link. Either way the user is logged out everywhere.
+func (s *UserService) ResetPassword(ctx context.Context, id uuid.UUID, password string) error {
+	user, err := s.userRepo.GetByID(ctx, id)
+	if err != nil {
+		return fmt.Errorf("user not found")
+	}
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return fmt.Errorf("hash password: %w", err)
+		}
+		if err := s.userRepo.UpdatePasswordHash(ctx, id, string(hash)); err != nil {
+			return fmt.Errorf("update password: %w", err)
+		}
+	} else if err := s.sendPasswordLink(ctx, user, false); err != nil {
+		return err
+	}
+	return s.sessionService.RevokeAll(ctx, domain.SessionSubjectUser, id)
+}
+
+// SetPasswordWithToken consumes an invite or reset link and sets the password.
+func (s *UserService) SetPasswordWithToken(ctx context.Context, rawToken, password string) error {
+	token, err := s.tokenRepo.GetByHash(ctx, hashToken(rawToken))
+	if err != nil {
+		return fmt.Errorf("invalid or expired token")
+	}
+	if err := s.tokenRepo.MarkUsed(ctx, token.ID); err != nil {
+		return fmt.Errorf("mark token used: %w", err)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+	if err := s.userRepo.UpdatePasswordHash(ctx, token.UserID, string(hash)); err != nil {
+		return fmt.Errorf("update password: %w", err)
+	}
+	return s.sessionService.RevokeAll(ctx, domain.SessionSubjectUser, token.UserID)
+}
+
+func (s *UserService) sendPasswordLink(ctx context.Context, user *domain.User, invite bool) error {
+	if err := s.tokenRepo.DeleteByUser(ctx, user.ID); err != nil {
+		return fmt.Errorf("delete old tokens: %w", err)
+	}
+	raw, hashed, err := generateToken()
+	if err != nil {
+		return err
+	}
+	token := &domain.UserAuthToken{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		TokenHash: hashed,
+		Type:      "password_reset",
+		ExpiresAt: time.Now().Add(staffResetTTL),
+	}
+	if invite {
+		token.Type = "invite"
+		token.ExpiresAt = time.Now().Add(staffInviteTTL)
+	}
+	if err := s.tokenRepo.Create(ctx, token); err != nil {
+		return fmt.Errorf("store token: %w", err)
+	}
+	return s.emailService.SendStaffPasswordLink(user.Email, raw, invite)
+}
+
+func (s *UserService) checkNotLastAdmin(ctx context.Context, user *domain.User) error {
+	n, err := s.userRepo.CountActiveAdmins(ctx)
+	if err != nil {
+		return err
+	}
+	if user.Active && n <= 1 {
+		return fmt.Errorf("cannot remove the last active admin")
+	}
+	return nil
+}
+
+// normalizePermissions validates and de-duplicates permissions. Admins hold
+// every permission implicitly and resellers are bounded by their scope
+// instead, so none are stored for either.
+func normalizePermissions(role string, perms []string) ([]string, error) {
+	if role == "admin" || role == "reseller" {
+		return []string{}, nil
+	}
+	seen := make(map[string]bool)
+	out := []string{}
+	for _, p := range perms {
+		if !validPermissions[p] {
+			return nil, fmt.Errorf("invalid permission %q", p)
+		}
+		if !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
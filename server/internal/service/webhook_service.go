@@ -0,0 +1,595 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mobileproxy/server/internal/domain"
+	"github.com/mobileproxy/server/internal/repository"
+)
+
+const (
+	maxWebhookEndpoints   = 20
+	webhookMaxAttempts    = 8
+	webhookBaseBackoff    = 30 * time.Second
+	webhookMaxBackoff     = 6 * time.Hour
+	webhookClaimBatch     = 50
+	webhookClaimLease     = 2 * time.Minute
+	webhookResponseMaxLen = 1024
+	webhookMaxRedirects   = 3
+)
+
+var errWebhookNotFound = errors.New("webhook not found")
+
+// WebhookService fans device and connection events out to subscribed
+// endpoints. Events are queued in webhook_deliveries and sent by the worker,
+// which retries failures with exponential backoff. Endpoint deliveries are
+// signed with the endpoint's secret; deliveries to the legacy per-user
+// webhook URL are not.
+type WebhookService struct {
+	webhookRepo *repository.WebhookRepository
+	deviceRepo  *repository.DeviceRepository
+	userRepo    *repository.UserRepository
+	client      *http.Client
+}
+
+func NewWebhookService(webhookRepo *repository.WebhookRepository, deviceRepo *repository.DeviceRepository, userRepo *repository.UserRepository) *WebhookService {
+	return &WebhookService{
+		webhookRepo: webhookRepo,
+		deviceRepo:  deviceRepo,
+		userRepo:    userRepo,
+		client:      newWebhookClient(),
+	}
+}
+
+// ─── Endpoints ──────────────────────────────────────────────────────────────
+
+func (s *WebhookService) ListEndpoints(ctx context.Context, owner domain.WebhookOwner) ([]domain.WebhookEndpoint, error) {
+	return s.webhookRepo.ListEndpoints(ctx, owner)
+}
+
+// GetEndpoint returns an endpoint by ID (for audit snapshots).
+func (s *WebhookService) GetEndpoint(ctx context.Context, id uuid.UUID) (*domain.WebhookEndpoint, error) {
+	return s.webhookRepo.GetEndpoint(ctx, id)
+}
+
+// CreateEndpoint adds an endpoint or chat channel. Webhook endpoints are
+// returned with their signing secret, which is not shown again.
+func (s *WebhookService) CreateEndpoint(ctx context.Context, owner domain.WebhookOwner, req *domain.CreateWebhookEndpointRequest) (*domain.WebhookEndpointSecretResponse, error) {
+	kind := req.Kind
+	if kind == "" {
+		kind = domain.WebhookKindWebhook
+	}
+	if err := validateChannelTarget(kind, req.URL, req.BotToken, req.ChatID); err != nil {
+		return nil, err
+	}
+	if err := validateWebhookEvents(req.Events); err != nil {
+		return nil, err
+	}
+	existing, err := s.webhookRepo.ListEndpoints(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= maxWebhookEndpoints {
+		return nil, fmt.Errorf("at most %d webhook endpoints allowed", maxWebhookEndpoints)
+	}
+	e := &domain.WebhookEndpoint{
+		ID:          uuid.New(),
+		UserID:      owner.UserID,
+		CustomerID:  owner.CustomerID,
+		Kind:        kind,
+		URL:         req.URL,
+		Description: req.Description,
+		Events:      normalizeWebhookEvents(req.Events),
+		Active:      true,
+	}
+	switch kind {
+	case domain.WebhookKindWebhook:
+		secret, _, err := generateToken()
+		if err != nil {
+			return nil, err
+		}
+		e.Secret = secret
+	case domain.WebhookKindTelegram:
+		e.URL = telegramAPIBase
+		e.ChatID = req.ChatID
+		e.Secret = req.BotToken
+	}
+	if err := s.webhookRepo.CreateEndpoint(ctx, e); err != nil {
+		return nil, fmt.Errorf("create webhook endpoint: %w", err)
+	}
+	resp := &domain.WebhookEndpointSecretResponse{WebhookEndpoint: e}
+	if kind == domain.WebhookKindWebhook {
+		resp.Secret = e.Secret
+	}
+	return resp, nil
+}
+
+func (s *WebhookService) UpdateEndpoint(ctx context.Context, owner domain.WebhookOwner, id uuid.UUID, req *domain.UpdateWebhookEndpointRequest) (*domain.WebhookEndpoint, error) {
+	e, err := s.ownedEndpoint(ctx, owner, id)
+	if err != nil {
+		return nil, err
+	}
+	botToken := req.BotToken
+	if botToken == "" {
+		botToken = e.Secret
+	}
+	if err := validateChannelTarget(e.Kind, req.URL, botToken, req.ChatID); err != nil {
+		return nil, err
+	}
+	if err := validateWebhookEvents(req.Events); err != nil {
+		return nil, err
+	}
+	if e.Kind == domain.WebhookKindTelegram {
+		e.ChatID = req.ChatID
+		e.Secret = botToken
+	} else {
+		e.URL = req.URL
+	}
+	e.Description = req.Description
+	e.Events = normalizeWebhookEvents(req.Events)
+	e.Active = req.Active
+	if err := s.webhookRepo.UpdateEndpoint(ctx, e); err != nil {
+		return nil, fmt.Errorf("update webhook endpoint: %w", err)
+	}
+	return e, nil
+}
+
+// RotateSecret replaces an endpoint's signing secret. Deliveries already
+// queued are signed with the new secret when they are sent.
+func (s *WebhookService) RotateSecret(ctx context.Context, owner domain.WebhookOwner, id uuid.UUID) (*domain.WebhookEndpointSecretResponse, error) {
+	e, err := s.ownedEndpoint(ctx, owner, id)
+	if err != nil {
+		return nil, err
+	}
+	if e.Kind != domain.WebhookKindWebhook {
+		return nil, errors.New("only webhook endpoints have a signing secret")
+	}
+	secret, _, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.webhookRepo.SetSecret(ctx, id, secret); err != nil {
+		return nil, fmt.Errorf("rotate webhook secret: %w", err)
+	}
+	e.Secret = secret
+	return &domain.WebhookEndpointSecretResponse{WebhookEndpoint: e, Secret: secret}, nil
+}
+
+// DeleteEndpoint removes an endpoint together with its delivery log.
+func (s *WebhookService) DeleteEndpoint(ctx context.Context, owner domain.WebhookOwner, id uuid.UUID) error {
+	if _, err := s.ownedEndpoint(ctx, owner, id); err != nil {
+		return err
+	}
+	return s.webhookRepo.DeleteEndpoint(ctx, id)
+}
+
+// Test queues a "test" event for one endpoint, regardless of its
+// subscriptions.
+func (s *WebhookService) Test(ctx context.Context, owner domain.WebhookOwner, id uuid.UUID) (*domain.WebhookDelivery, error) {
+	e, err := s.ownedEndpoint(ctx, owner, id)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := webhookPayload(domain.WebhookEventTest, map[string]interface{}{
+		"endpoint_id": e.ID.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	d := newWebhookDelivery(e.ID, e.UserID, e.CustomerID, e.URL, domain.WebhookEventTest, payload)
+	if err := s.webhookRepo.Enqueue(ctx, []domain.WebhookDelivery{*d}); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (s *WebhookService) ownedEndpoint(ctx context.Context, owner domain.WebhookOwner, id uuid.UUID) (*domain.WebhookEndpoint, error) {
+	e, err := s.webhookRepo.GetEndpoint(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil || !sameWebhookOwner(owner, e.UserID, e.CustomerID) {
+		return nil, errWebhookNotFound
+	}
+	return e, nil
+}
+
+// ─── Deliveries ─────────────────────────────────────────────────────────────
+
+// ListDeliveries returns an owner's delivery log, newest first.
+func (s *WebhookService) ListDeliveries(ctx context.Context, owner domain.WebhookOwner, endpointID *uuid.UUID, status string, limit int) ([]domain.WebhookDelivery, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	return s.webhookRepo.ListDeliveries(ctx, owner, endpointID, status, limit)
+}
+
+// Redeliver queues a fresh copy of an earlier delivery's payload. Endpoint
+// deliveries go to the endpoint's current URL.
+func (s *WebhookService) Redeliver(ctx context.Context, owner domain.WebhookOwner, deliveryID uuid.UUID) (*domain.WebhookDelivery, error) {
+	orig, err := s.webhookRepo.GetDelivery(ctx, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	if orig == nil || !sameWebhookOwner(owner, orig.UserID, orig.CustomerID) {
+		return nil, errors.New("delivery not found")
+	}
+	target := orig.URL
+	if orig.EndpointID != nil {
+		e, err := s.webhookRepo.GetEndpoint(ctx, *orig.EndpointID)
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			return nil, errWebhookNotFound
+		}
+		target = e.URL
+	}
+	d := newWebhookDelivery(uuid.Nil, orig.UserID, orig.CustomerID, target, orig.Event, orig.Payload)
+	d.EndpointID = orig.EndpointID
+	d.RedeliveryOf = &orig.ID
+	if err := s.webhookRepo.Enqueue(ctx, []domain.WebhookDelivery{*d}); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Emit queues an event about a device for every endpoint that should see
+// it: staff endpoints (only the allocated reseller's among resellers), the
+// endpoints of the device owner's organization and, for connection events,
+// of the connection's customer. The legacy webhook URL gets a copy too.
+func (s *WebhookService) Emit(ctx context.Context, event string, deviceID uuid.UUID, customerID *uuid.UUID, data map[string]interface{}) error {
+	device, err := s.deviceRepo.GetByID(ctx, deviceID)
+	if err != nil {
+		return fmt.Errorf("get device: %w", err)
+	}
+	var customerIDs []uuid.UUID
+	if device.CustomerID != nil {
+		customerIDs = append(customerIDs, *device.CustomerID)
+	}
+	if customerID != nil && (device.CustomerID == nil || *customerID != *device.CustomerID) {
+		customerIDs = append(customerIDs, *customerID)
+	}
+
+	endpoints, err := s.webhookRepo.ListSubscribed(ctx, event, device.ResellerID, customerIDs)
+	if err != nil {
+		return err
+	}
+	payload, err := webhookPayload(event, data)
+	if err != nil {
+		return err
+	}
+
+	deliveries := make([]domain.WebhookDelivery, 0, len(endpoints)+1)
+	for _, e := range endpoints {
+		deliveries = append(deliveries, *newWebhookDelivery(e.ID, e.UserID, e.CustomerID, e.URL, event, payload))
+	}
+	if s.userRepo != nil {
+		if userID, target, err := s.userRepo.GetWebhookTargetForDevice(ctx, deviceID); err == nil && target != "" {
+			deliveries = append(deliveries, *newWebhookDelivery(uuid.Nil, &userID, nil, target, event, payload))
+		}
+	}
+	return s.webhookRepo.Enqueue(ctx, deliveries)
+}
+
+// EmitTo queues an event for the active endpoints of one owner that are
+// subscribed to it, e.g. for the owner's own alert rules.
+func (s *WebhookService) EmitTo(ctx context.Context, owner domain.WebhookOwner, event string, data map[string]interface{}) error {
+	endpoints, err := s.webhookRepo.ListEndpoints(ctx, owner)
+	if err != nil {
+		return err
+	}
+	payload, err := webhookPayload(event, data)
+	if err != nil {
+		return err
+	}
+
+	var deliveries []domain.WebhookDelivery
+	for _, e := range endpoints {
+		if !e.Active || (len(e.Events) > 0 && !slices.Contains(e.Events, event)) {
+			continue
+		}
+		deliveries = append(deliveries, *newWebhookDelivery(e.ID, e.UserID, e.CustomerID, e.URL, event, payload))
+	}
+	return s.webhookRepo.Enqueue(ctx, deliveries)
+}
+
+// RunDeliveries sends the deliveries that are due and records each outcome.
+// Returns the number of attempts made.
+func (s *WebhookService) RunDeliveries(ctx context.Context) (int, error) {
+	due, err := s.webhookRepo.ClaimDue(ctx, webhookClaimBatch, webhookClaimLease)
+	if err != nil {
+		return 0, err
+	}
+	for i := range due {
+		d := &due[i]
+		s.attempt(ctx, d)
+		if err := s.webhookRepo.RecordAttempt(ctx, d); err != nil {
+			log.Printf("[webhook] record attempt failed for delivery %s: %v", d.ID, err)
+		}
+	}
+	return len(due), nil
+}
+
+// PruneDeliveries deletes finished deliveries older than the retention.
+func (s *WebhookService) PruneDeliveries(ctx context.Context, retention time.Duration) (int64, error) {
+	return s.webhookRepo.DeleteDeliveriesBefore(ctx, time.Now().Add(-retention))
+}
+
+// attempt sends one delivery and updates its status, attempt count and next
+// attempt time in place.
+func (s *WebhookService) attempt(ctx context.Context, d *domain.WebhookDelivery) {
+	now := time.Now().UTC()
+	d.Attempts++
+	d.LastAttemptAt = &now
+	d.ResponseStatus = nil
+	d.ResponseBody = ""
+	d.Error = ""
+
+	// Legacy webhook URL deliveries have no endpoint: plain, unsigned JSON.
+	e := &domain.WebhookEndpoint{Kind: domain.WebhookKindWebhook}
+	if d.EndpointID != nil {
+		var err error
+		e, err = s.webhookRepo.GetEndpoint(ctx, *d.EndpointID)
+		if err != nil {
+			d.Error = err.Error()
+			s.scheduleRetry(d, now)
+			return
+		}
+		if e == nil || !e.Active {
+			d.Status = domain.WebhookDeliveryFailed
+			d.Error = "endpoint disabled"
+			return
+		}
+	}
+
+	target, body := d.URL, []byte(d.Payload)
+	if e.Kind != domain.WebhookKindWebhook {
+		var err error
+		if target, body, err = channelRequest(e, d); err != nil {
+			d.Status = domain.WebhookDeliveryFailed
+			d.Error = err.Error()
+			return
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		d.Status = domain.WebhookDeliveryFailed
+		d.Error = redactSecret(err.Error(), e)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "PocketProxy-Webhook/1.0")
+	if e.Kind == domain.WebhookKindWebhook {
+		timestamp := strconv.FormatInt(now.Unix(), 10)
+		req.Header.Set("X-Webhook-Event", d.Event)
+		req.Header.Set("X-Webhook-Delivery", d.ID.String())
+		req.Header.Set("X-Webhook-Timestamp", timestamp)
+		if e.Secret != "" {
+			req.Header.Set("X-Webhook-Signature", signWebhook(e.Secret, timestamp, body))
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		d.Error = redactSecret(err.Error(), e)
+		s.scheduleRetry(d, now)
+		return
+	}
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseMaxLen))
+	resp.Body.Close()
+	status := resp.StatusCode
+	d.ResponseStatus = &status
+	d.ResponseBody = string(respBody)
+
+	if status >= 200 && status < 300 {
+		d.Status = domain.WebhookDeliverySucceeded
+		return
+	}
+	d.Error = fmt.Sprintf("endpoint responded with HTTP %d", status)
+	s.scheduleRetry(d, now)
+}
+
+// scheduleRetry backs off exponentially from webhookBaseBackoff, giving up
+// after webhookMaxAttempts.
+func (s *WebhookService) scheduleRetry(d *domain.WebhookDelivery, now time.Time) {
+	if d.Attempts >= webhookMaxAttempts {
+		d.Status = domain.WebhookDeliveryFailed
+		return
+	}
+	backoff := webhookBaseBackoff << (d.Attempts - 1)
+	if backoff > webhookMaxBackoff {
+		backoff = webhookMaxBackoff
+	}
+	d.Status = domain.WebhookDeliveryPending
+	d.NextAttemptAt = now.Add(backoff)
+}
+
+// signWebhook returns the X-Webhook-Signature value: the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the endpoint secret.
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookPayload builds the flat JSON body receivers have always gotten:
+// the event data plus "event" and "timestamp".
+func webhookPayload(event string, data map[string]interface{}) (json.RawMessage, error) {
+	body := make(map[string]interface{}, len(data)+2)
+	for k, v := range data {
+		body[k] = v
+	}
+	body["event"] = event
+	body["timestamp"] = time.Now().UTC().Format(time.RFC3339)
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal webhook payload: %w", err)
+	}
+	return payload, nil
+}
+
+// newWebhookDelivery returns a pending delivery; a nil endpointID marks a
+// legacy webhook URL delivery.
+func newWebhookDelivery(endpointID uuid.UUID, userID, customerID *uuid.UUID, target, event string, payload json.RawMessage) *domain.WebhookDelivery {
+	d := &domain.WebhookDelivery{
+		ID:            uuid.New(),
+		UserID:        userID,
+		CustomerID:    customerID,
+		URL:           target,
+		Event:         event,
+		Payload:       payload,
+		Status:        domain.WebhookDeliveryPending,
+		NextAttemptAt: time.Now().UTC(),
+		CreatedAt:     time.Now().UTC(),
+	}
+	if endpointID != uuid.Nil {
+		d.EndpointID = &endpointID
+	}
+	return d
+}
+
+// redactSecret hides a telegram bot token, which is part of the request URL
+// and so of transport errors, before an error is stored in the delivery log.
+func redactSecret(msg string, e *domain.WebhookEndpoint) string {
+	if e.Kind != domain.WebhookKindTelegram || e.Secret == "" {
+		return msg
+	}
+	return strings.ReplaceAll(msg, e.Secret, "<bot-token>")
+}
+
+func validateWebhookEvents(events []string) error {
+	for _, ev := range events {
+		known := false
+		for _, k := range domain.WebhookEvents {
+			if ev == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown webhook event %q", ev)
+		}
+	}
+	return nil
+}
+
+// normalizeWebhookEvents drops duplicates and turns nil into an empty list
+// (subscribed to every event).
+func normalizeWebhookEvents(events []string) []string {
+	out := []string{}
+	seen := make(map[string]bool, len(events))
+	for _, ev := range events {
+		if !seen[ev] {
+			seen[ev] = true
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+func sameWebhookOwner(owner domain.WebhookOwner, userID, customerID *uuid.UUID) bool {
+	return sameUUID(owner.UserID, userID) && sameUUID(owner.CustomerID, customerID)
+}
+
+func sameUUID(a, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// ─── Outbound client ────────────────────────────────────────────────────────
+
+var errNonPublicTarget = errors.New("webhook target is not a public address")
+
+// nonPublicPrefixes are ranges outside net.IP's loopback/private/link-local
+// helpers that are still not reachable on the internet.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"), // NAT64, may map to private IPv4
+}
+
+// isPublicIP reports whether ip is a routable internet address.
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsLinkLocalMulticast() {
+		return false
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range nonPublicPrefixes {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// newWebhookClient returns the client deliveries are sent with. Endpoint URLs
+// are customer-controlled and the response is shown back to them, so every
+// connection is checked after DNS resolution: loopback, private, link-local
+// and other non-public addresses are refused, whether they come from the URL,
+// a rebinding DNS answer or a redirect. Environment proxies are ignored since
+// they would hide the real destination from the check.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("%w: %s", errNonPublicTarget, host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= webhookMaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", webhookMaxRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			if ip := net.ParseIP(req.URL.Hostname()); ip != nil && !isPublicIP(ip) {
+				return fmt.Errorf("%w: redirect to %s", errNonPublicTarget, ip)
+			}
+			return nil
+		},
+	}
+}
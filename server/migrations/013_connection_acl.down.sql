@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS acl_denials;
+DROP TABLE IF EXISTS acl_rules;
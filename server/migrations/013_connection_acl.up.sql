@@ -0,0 +1,32 @@
+-- Destination ACL rules enforced by the relay (SOCKS forwarder + proxy gateway).
+-- connection_id NULL = global policy applied to every connection.
+-- No FK on connection_id: peer sync replaces proxy_connections rows wholesale,
+-- which would cascade-delete rules. ConnectionService.Delete cleans them up.
+CREATE TABLE IF NOT EXISTS acl_rules (
+    id            UUID         NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    connection_id UUID,
+    action        VARCHAR(10)  NOT NULL CHECK (action IN ('allow', 'deny')),
+    rule_type     VARCHAR(10)  NOT NULL CHECK (rule_type IN ('port', 'cidr', 'domain')),
+    value         VARCHAR(255) NOT NULL,
+    description   TEXT,
+    created_at    TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_acl_rules_connection ON acl_rules(connection_id);
+
+-- Denied connection attempts reported by relays
+CREATE TABLE IF NOT EXISTS acl_denials (
+    id            UUID         NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    connection_id UUID,
+    username      VARCHAR(255) NOT NULL DEFAULT '',
+    client_ip     VARCHAR(45)  NOT NULL DEFAULT '',
+    dest_host     VARCHAR(255) NOT NULL,
+    dest_port     INTEGER      NOT NULL,
+    rule_id       UUID,
+    scope         VARCHAR(20)  NOT NULL CHECK (scope IN ('global', 'connection')),
+    occurred_at   TIMESTAMPTZ  NOT NULL,
+    created_at    TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_acl_denials_connection ON acl_denials(connection_id, occurred_at DESC);
+CREATE INDEX IF NOT EXISTS idx_acl_denials_occurred   ON acl_denials(occurred_at DESC);
@@ -0,0 +1,4 @@
+ALTER TABLE proxy_connections DROP COLUMN IF EXISTS rejected_rate;
+ALTER TABLE proxy_connections DROP COLUMN IF EXISTS rejected_concurrent;
+ALTER TABLE proxy_connections DROP COLUMN IF EXISTS max_conns_per_second;
+ALTER TABLE proxy_connections DROP COLUMN IF EXISTS max_concurrent_conns;
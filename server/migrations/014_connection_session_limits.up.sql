@@ -0,0 +1,7 @@
+-- Per-connection session limits enforced by the relay (0 = unlimited)
+ALTER TABLE proxy_connections ADD COLUMN IF NOT EXISTS max_concurrent_conns INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE proxy_connections ADD COLUMN IF NOT EXISTS max_conns_per_second INTEGER NOT NULL DEFAULT 0;
+
+-- Cumulative rejection counters reported by relays
+ALTER TABLE proxy_connections ADD COLUMN IF NOT EXISTS rejected_concurrent BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE proxy_connections ADD COLUMN IF NOT EXISTS rejected_rate BIGINT NOT NULL DEFAULT 0;
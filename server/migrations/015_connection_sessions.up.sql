@@ -0,0 +1,22 @@
+-- Per-session access log emitted by relays (SOCKS forwarder + proxy gateway).
+-- Partitioned by day on started_at; partitions are created on demand by the API
+-- and the worker, and dropped by the worker once past the retention window.
+-- No FK on connection_id: sessions outlive connections for abuse handling.
+CREATE TABLE IF NOT EXISTS connection_sessions (
+    id            UUID         NOT NULL DEFAULT uuid_generate_v4(),
+    connection_id UUID,
+    username      VARCHAR(255) NOT NULL DEFAULT '',
+    client_ip     VARCHAR(45)  NOT NULL DEFAULT '',
+    proxy_type    VARCHAR(10)  NOT NULL DEFAULT '',
+    dest_host     VARCHAR(255) NOT NULL DEFAULT '',
+    dest_port     INTEGER      NOT NULL DEFAULT 0,
+    bytes_up      BIGINT       NOT NULL DEFAULT 0,
+    bytes_down    BIGINT       NOT NULL DEFAULT 0,
+    started_at    TIMESTAMPTZ  NOT NULL,
+    ended_at      TIMESTAMPTZ  NOT NULL,
+    close_reason  VARCHAR(32)  NOT NULL DEFAULT '',
+    created_at    TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id, started_at)
+) PARTITION BY RANGE (started_at);
+
+CREATE INDEX IF NOT EXISTS idx_connection_sessions_connection ON connection_sessions(connection_id, started_at DESC);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_bandwidth_logs_connection;
+DROP TABLE IF EXISTS bandwidth_reports;
@@ -0,0 +1,15 @@
+-- Idempotency ledger for relay bandwidth reports: a (relay_id, seq) pair is
+-- applied at most once, so relays can safely retry unacknowledged reports.
+CREATE TABLE IF NOT EXISTS bandwidth_reports (
+    relay_id    VARCHAR(64)  NOT NULL,
+    seq         BIGINT       NOT NULL,
+    received_at TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (relay_id, seq)
+);
+
+CREATE INDEX IF NOT EXISTS idx_bandwidth_reports_received ON bandwidth_reports(received_at);
+
+-- Per-connection rows in bandwidth_logs (connection_id set) sit alongside the
+-- device heartbeat rows (connection_id NULL).
+CREATE INDEX IF NOT EXISTS idx_bandwidth_logs_connection ON bandwidth_logs(connection_id, interval_start)
+    WHERE connection_id IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS bandwidth_quota_cycles;
+DROP TABLE IF EXISTS bandwidth_quotas;
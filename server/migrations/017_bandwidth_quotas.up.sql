@@ -0,0 +1,29 @@
+-- Recurring bandwidth quota policy per connection. The byte cap itself stays
+-- in proxy_connections.bandwidth_limit; the worker zeroes bandwidth_used at
+-- each cycle boundary. period 'none' keeps the cap lifetime (manual reset).
+-- No FK on connection_id for the same reason as acl_rules (peer sync).
+CREATE TABLE IF NOT EXISTS bandwidth_quotas (
+    connection_id    UUID        NOT NULL PRIMARY KEY,
+    period           VARCHAR(10) NOT NULL DEFAULT 'none' CHECK (period IN ('none', 'daily', 'weekly', 'monthly')),
+    anchor_at        TIMESTAMPTZ NOT NULL,
+    cycle_start      TIMESTAMPTZ NOT NULL,
+    notify_percents  INTEGER[]   NOT NULL DEFAULT '{80,100}',
+    notified_percent INTEGER     NOT NULL DEFAULT 0,
+    over_action      VARCHAR(10) NOT NULL DEFAULT 'cut' CHECK (over_action IN ('cut', 'throttle')),
+    throttle_kbps    INTEGER     NOT NULL DEFAULT 0,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Usage of each completed cycle, written when the worker resets it
+CREATE TABLE IF NOT EXISTS bandwidth_quota_cycles (
+    id            UUID        NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    connection_id UUID        NOT NULL,
+    cycle_start   TIMESTAMPTZ NOT NULL,
+    cycle_end     TIMESTAMPTZ NOT NULL,
+    bytes_used    BIGINT      NOT NULL,
+    bytes_limit   BIGINT      NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_bandwidth_quota_cycles_connection ON bandwidth_quota_cycles(connection_id, cycle_start DESC);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS bandwidth_rollup_state;
+DROP TABLE IF EXISTS bandwidth_daily;
+DROP TABLE IF EXISTS bandwidth_hourly;
+DROP INDEX IF EXISTS idx_bandwidth_logs_recorded;
+ALTER TABLE bandwidth_logs DROP COLUMN IF EXISTS recorded_at;
@@ -0,0 +1,53 @@
+-- When each raw row was written, so the rollup job can find late-arriving
+-- rows (relays retry reports for hours) and recompute just the touched hours.
+ALTER TABLE bandwidth_logs ADD COLUMN IF NOT EXISTS recorded_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+CREATE INDEX IF NOT EXISTS idx_bandwidth_logs_recorded ON bandwidth_logs(recorded_at);
+
+-- Hourly and daily (UTC) rollups of bandwidth_logs. connection_id NULL rows
+-- are device heartbeat totals, as in the raw table.
+CREATE TABLE IF NOT EXISTS bandwidth_hourly (
+    device_id     UUID        NOT NULL,
+    connection_id UUID,
+    bucket        TIMESTAMPTZ NOT NULL,
+    bytes_in      BIGINT      NOT NULL DEFAULT 0,
+    bytes_out     BIGINT      NOT NULL DEFAULT 0,
+    UNIQUE NULLS NOT DISTINCT (device_id, connection_id, bucket)
+);
+
+CREATE INDEX IF NOT EXISTS idx_bandwidth_hourly_bucket ON bandwidth_hourly(bucket);
+CREATE INDEX IF NOT EXISTS idx_bandwidth_hourly_connection ON bandwidth_hourly(connection_id, bucket)
+    WHERE connection_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS bandwidth_daily (
+    device_id     UUID   NOT NULL,
+    connection_id UUID,
+    day           DATE   NOT NULL,
+    bytes_in      BIGINT NOT NULL DEFAULT 0,
+    bytes_out     BIGINT NOT NULL DEFAULT 0,
+    UNIQUE NULLS NOT DISTINCT (device_id, connection_id, day)
+);
+
+CREATE INDEX IF NOT EXISTS idx_bandwidth_daily_day ON bandwidth_daily(day);
+
+-- Raw rows recorded up to watermark are reflected in the rollups
+CREATE TABLE IF NOT EXISTS bandwidth_rollup_state (
+    name      VARCHAR(32) NOT NULL PRIMARY KEY,
+    watermark TIMESTAMPTZ NOT NULL
+);
+
+-- Backfill from existing raw rows
+INSERT INTO bandwidth_hourly (device_id, connection_id, bucket, bytes_in, bytes_out)
+SELECT device_id, connection_id, date_trunc('hour', interval_start, 'UTC'), SUM(bytes_in), SUM(bytes_out)
+FROM bandwidth_logs
+GROUP BY 1, 2, 3
+ON CONFLICT DO NOTHING;
+
+INSERT INTO bandwidth_daily (device_id, connection_id, day, bytes_in, bytes_out)
+SELECT device_id, connection_id, (bucket AT TIME ZONE 'UTC')::date, SUM(bytes_in), SUM(bytes_out)
+FROM bandwidth_hourly
+GROUP BY 1, 2, 3
+ON CONFLICT DO NOTHING;
+
+INSERT INTO bandwidth_rollup_state (name, watermark)
+SELECT 'bandwidth', COALESCE(MAX(recorded_at), NOW()) FROM bandwidth_logs
+ON CONFLICT (name) DO NOTHING;
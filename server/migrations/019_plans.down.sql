@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS rotation_events;
+ALTER TABLE customers DROP COLUMN IF EXISTS plan_id;
+DROP TABLE IF EXISTS plans;
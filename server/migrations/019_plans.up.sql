@@ -0,0 +1,31 @@
+-- Customer plans: what a customer is entitled to. 0 = unlimited for every
+-- numeric limit. Customers without a plan are unrestricted.
+CREATE TABLE IF NOT EXISTS plans (
+    id                        UUID         NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    name                      VARCHAR(100) NOT NULL UNIQUE,
+    description               TEXT         NOT NULL DEFAULT '',
+    max_devices               INTEGER      NOT NULL DEFAULT 0,
+    max_connections           INTEGER      NOT NULL DEFAULT 0,
+    monthly_gb                INTEGER      NOT NULL DEFAULT 0,
+    allowed_proxy_types       TEXT[]       NOT NULL DEFAULT '{http,socks5,openvpn}',
+    max_rotations_per_day     INTEGER      NOT NULL DEFAULT 0,
+    min_rotation_interval_sec INTEGER      NOT NULL DEFAULT 0,
+    active                    BOOLEAN      NOT NULL DEFAULT TRUE,
+    created_at                TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    updated_at                TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS plan_id UUID REFERENCES plans(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_customers_plan ON customers(plan_id);
+
+-- Customer-initiated IP rotations (dashboard command or rotation link), for
+-- per-plan rotation limits
+CREATE TABLE IF NOT EXISTS rotation_events (
+    id          UUID        NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    customer_id UUID        NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    device_id   UUID        NOT NULL,
+    source      VARCHAR(20) NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_rotation_events_customer ON rotation_events(customer_id, created_at DESC);
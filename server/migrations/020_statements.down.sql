@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS statements;
+DROP TABLE IF EXISTS customer_connection_days;
+DROP TABLE IF EXISTS customer_device_days;
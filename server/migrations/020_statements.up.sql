@@ -0,0 +1,60 @@
+-- Which devices and connections each customer held on each UTC day. Snapshotted
+-- by the worker so statements still account for connections and shares that
+-- have since been deleted. No FK on device/connection ids for the same reason.
+CREATE TABLE IF NOT EXISTS customer_device_days (
+    customer_id UUID NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    device_id   UUID NOT NULL,
+    day         DATE NOT NULL,
+    PRIMARY KEY (customer_id, day, device_id)
+);
+
+CREATE TABLE IF NOT EXISTS customer_connection_days (
+    customer_id   UUID         NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    connection_id UUID         NOT NULL,
+    device_id     UUID         NOT NULL,
+    username      VARCHAR(255) NOT NULL,
+    proxy_type    VARCHAR(20)  NOT NULL,
+    day           DATE         NOT NULL,
+    PRIMARY KEY (customer_id, day, connection_id)
+);
+
+-- Closed monthly statements. period_end is exclusive; lines holds the
+-- per-connection breakdown.
+CREATE TABLE IF NOT EXISTS statements (
+    id              UUID         NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    customer_id     UUID         NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    period_start    DATE         NOT NULL,
+    period_end      DATE         NOT NULL,
+    plan_name       VARCHAR(100) NOT NULL DEFAULT '',
+    devices         INTEGER      NOT NULL DEFAULT 0,
+    device_days     INTEGER      NOT NULL DEFAULT 0,
+    connection_days INTEGER      NOT NULL DEFAULT 0,
+    bytes_in        BIGINT       NOT NULL DEFAULT 0,
+    bytes_out       BIGINT       NOT NULL DEFAULT 0,
+    rotations       INTEGER      NOT NULL DEFAULT 0,
+    lines           JSONB        NOT NULL DEFAULT '[]',
+    closed_at       TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    UNIQUE (customer_id, period_start)
+);
+
+-- Backfill the current and previous month from what exists now
+INSERT INTO customer_device_days (customer_id, device_id, day)
+SELECT h.customer_id, h.device_id, d::date
+FROM (
+    SELECT customer_id, id AS device_id, created_at FROM devices WHERE customer_id IS NOT NULL
+    UNION ALL
+    SELECT shared_with, device_id, created_at FROM device_shares
+) h,
+generate_series(
+    GREATEST(h.created_at AT TIME ZONE 'UTC', date_trunc('month', NOW() AT TIME ZONE 'UTC') - INTERVAL '1 month')::date,
+    (NOW() AT TIME ZONE 'UTC')::date, INTERVAL '1 day') d
+ON CONFLICT DO NOTHING;
+
+INSERT INTO customer_connection_days (customer_id, connection_id, device_id, username, proxy_type, day)
+SELECT pc.customer_id, pc.id, pc.device_id, pc.username, pc.proxy_type, d::date
+FROM proxy_connections pc,
+generate_series(
+    GREATEST(pc.created_at AT TIME ZONE 'UTC', date_trunc('month', NOW() AT TIME ZONE 'UTC') - INTERVAL '1 month')::date,
+    (NOW() AT TIME ZONE 'UTC')::date, INTERVAL '1 day') d
+WHERE pc.customer_id IS NOT NULL
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS billing_events;
+DROP TABLE IF EXISTS billing_accounts;
+ALTER TABLE plans DROP COLUMN IF EXISTS provider_price_id;
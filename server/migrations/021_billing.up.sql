@@ -0,0 +1,28 @@
+-- Payment provider price charged for a plan (e.g. a Stripe price id)
+ALTER TABLE plans ADD COLUMN IF NOT EXISTS provider_price_id VARCHAR(255) NOT NULL DEFAULT '';
+
+-- A customer's subscription at the payment provider. suspended is set when
+-- billing (not an admin) suspended the customer, so only billing lifts it.
+CREATE TABLE IF NOT EXISTS billing_accounts (
+    customer_id          UUID         NOT NULL PRIMARY KEY REFERENCES customers(id) ON DELETE CASCADE,
+    provider             VARCHAR(20)  NOT NULL,
+    provider_customer_id VARCHAR(255) NOT NULL DEFAULT '',
+    subscription_id      VARCHAR(255) NOT NULL DEFAULT '',
+    plan_id              UUID         REFERENCES plans(id) ON DELETE SET NULL,
+    status               VARCHAR(20)  NOT NULL DEFAULT 'pending',
+    suspended            BOOLEAN      NOT NULL DEFAULT FALSE,
+    created_at           TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    updated_at           TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_billing_accounts_subscription ON billing_accounts(provider, subscription_id);
+
+-- Webhook events already applied, so provider retries are no-ops
+CREATE TABLE IF NOT EXISTS billing_events (
+    provider    VARCHAR(20)  NOT NULL,
+    event_id    VARCHAR(255) NOT NULL,
+    type        VARCHAR(64)  NOT NULL,
+    customer_id UUID,
+    received_at TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (provider, event_id)
+);
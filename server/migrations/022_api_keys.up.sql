@@ -0,0 +1,17 @@
+-- Long-lived customer API keys for automation. Only the SHA-256 of the key is
+-- stored; prefix is the first characters, kept so customers can tell keys apart.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id           UUID         NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    customer_id  UUID         NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    name         VARCHAR(100) NOT NULL,
+    prefix       VARCHAR(16)  NOT NULL,
+    key_hash     VARCHAR(64)  NOT NULL UNIQUE,
+    scopes       TEXT[]       NOT NULL DEFAULT '{read}',
+    last_used_at TIMESTAMPTZ,
+    last_used_ip VARCHAR(45),
+    expires_at   TIMESTAMPTZ,
+    revoked_at   TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_customer ON api_keys(customer_id);
@@ -0,0 +1,19 @@
+-- Server-side login sessions for staff users and customers. Each holds the
+-- hash of its current refresh token; the previous hash is kept so a replayed
+-- (stolen) refresh token can be detected and the session revoked.
+CREATE TABLE IF NOT EXISTS auth_sessions (
+    id            UUID        NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    subject_type  VARCHAR(10) NOT NULL, -- 'user' or 'customer'
+    subject_id    UUID        NOT NULL,
+    refresh_hash  VARCHAR(64) NOT NULL UNIQUE,
+    previous_hash VARCHAR(64),
+    user_agent    TEXT        NOT NULL DEFAULT '',
+    ip            VARCHAR(45) NOT NULL DEFAULT '',
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at    TIMESTAMPTZ NOT NULL,
+    revoked_at    TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_auth_sessions_subject ON auth_sessions(subject_type, subject_id);
+CREATE INDEX IF NOT EXISTS idx_auth_sessions_previous ON auth_sessions(previous_hash) WHERE previous_hash IS NOT NULL;
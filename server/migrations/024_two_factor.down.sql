@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS app_settings;
+DROP TABLE IF EXISTS two_factor_recovery_codes;
+DROP TABLE IF EXISTS two_factor;
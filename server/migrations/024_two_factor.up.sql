@@ -0,0 +1,33 @@
+-- TOTP two-factor authentication for staff users and customers. A row with
+-- enabled_at NULL is an enrollment that has not been confirmed yet.
+-- last_used_step blocks replaying a code inside its 30-second window.
+CREATE TABLE IF NOT EXISTS two_factor (
+    subject_type    VARCHAR(10) NOT NULL, -- 'user' or 'customer'
+    subject_id      UUID        NOT NULL,
+    secret          VARCHAR(64) NOT NULL, -- base32 TOTP secret
+    enabled_at      TIMESTAMPTZ,
+    last_used_step  BIGINT      NOT NULL DEFAULT 0,
+    failed_attempts INTEGER     NOT NULL DEFAULT 0,
+    locked_until    TIMESTAMPTZ,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (subject_type, subject_id)
+);
+
+-- Single-use recovery codes (SHA-256 of the normalized code).
+CREATE TABLE IF NOT EXISTS two_factor_recovery_codes (
+    id           UUID        NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    subject_type VARCHAR(10) NOT NULL,
+    subject_id   UUID        NOT NULL,
+    code_hash    VARCHAR(64) NOT NULL,
+    used_at      TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_two_factor_recovery_subject ON two_factor_recovery_codes(subject_type, subject_id);
+
+-- Instance-wide settings changed from the dashboard.
+CREATE TABLE IF NOT EXISTS app_settings (
+    key        VARCHAR(100) NOT NULL PRIMARY KEY,
+    value      TEXT         NOT NULL,
+    updated_at TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
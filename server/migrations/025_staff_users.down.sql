@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS user_auth_tokens;
+ALTER TABLE users DROP COLUMN IF EXISTS last_login_at;
+ALTER TABLE users DROP COLUMN IF EXISTS permissions;
+ALTER TABLE users DROP COLUMN IF EXISTS active;
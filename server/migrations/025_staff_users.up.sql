@@ -0,0 +1,25 @@
+-- Staff user management: disabling accounts and per-user permissions.
+-- Admins implicitly hold every permission; operators only those listed.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS active BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS permissions TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_login_at TIMESTAMPTZ;
+
+-- Operators could do everything admins could until now; keep that until an
+-- admin narrows it down.
+UPDATE users SET permissions = ARRAY[
+    'devices:command', 'devices:manage', 'connections:write', 'customers:manage',
+    'plans:manage', 'relays:manage', 'acl:manage', 'settings:webhook'
+] WHERE role = 'operator';
+
+-- Invite (set first password) and admin-initiated password reset links.
+CREATE TABLE IF NOT EXISTS user_auth_tokens (
+    id         UUID        NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    user_id    UUID        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    type       VARCHAR(20) NOT NULL CHECK (type IN ('invite', 'password_reset')),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_auth_tokens_user ON user_auth_tokens(user_id, type);
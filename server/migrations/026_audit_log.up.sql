@@ -0,0 +1,25 @@
+-- Audit trail of state-changing actions: who (actor), what (action + target),
+-- the field-level before/after diff and where from. No FKs: entries must
+-- outlive the users, customers and entities they mention.
+CREATE TABLE IF NOT EXISTS audit_log (
+    id          UUID         NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    actor_type  VARCHAR(10)  NOT NULL, -- 'user', 'customer', 'api_key' or 'internal'
+    actor_id    UUID,
+    actor_label VARCHAR(255) NOT NULL DEFAULT '',
+    customer_id UUID,
+    action      VARCHAR(100) NOT NULL,
+    target_type VARCHAR(50)  NOT NULL DEFAULT '',
+    target_id   UUID,
+    changes     JSONB,
+    request     JSONB,
+    method      VARCHAR(10)  NOT NULL DEFAULT '',
+    path        TEXT         NOT NULL DEFAULT '',
+    status      INTEGER      NOT NULL DEFAULT 0,
+    source_ip   VARCHAR(45)  NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_created ON audit_log(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log(target_type, target_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(actor_type, actor_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_log_customer ON audit_log(customer_id, created_at DESC) WHERE customer_id IS NOT NULL;
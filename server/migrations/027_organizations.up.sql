@@ -0,0 +1,29 @@
+-- Organizations: team accounts with several member logins.
+-- An organization shares its id with its founding customer account, which
+-- keeps carrying the plan, billing and suspension state. Everything owned by
+-- a customer id (devices, connections, shares, API keys, ...) is therefore
+-- owned by the organization, and existing data needs no migration.
+CREATE TABLE IF NOT EXISTS organizations (
+    id         UUID         NOT NULL PRIMARY KEY REFERENCES customers(id) ON DELETE CASCADE,
+    name       VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+-- A login belongs to exactly one organization.
+CREATE TABLE IF NOT EXISTS organization_members (
+    organization_id UUID        NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    customer_id     UUID        NOT NULL UNIQUE REFERENCES customers(id) ON DELETE CASCADE,
+    role            VARCHAR(10) NOT NULL CHECK (role IN ('owner', 'manager', 'viewer')),
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (organization_id, customer_id)
+);
+
+-- Every existing customer becomes the owner of a personal organization.
+INSERT INTO organizations (id, name)
+SELECT id, name FROM customers
+ON CONFLICT (id) DO NOTHING;
+
+INSERT INTO organization_members (organization_id, customer_id, role)
+SELECT id, id, 'owner' FROM customers
+ON CONFLICT DO NOTHING;
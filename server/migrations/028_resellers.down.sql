@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS reseller_branding;
+DROP INDEX IF EXISTS idx_pairing_codes_reseller;
+DROP INDEX IF EXISTS idx_customers_reseller;
+DROP INDEX IF EXISTS idx_devices_reseller;
+ALTER TABLE pairing_codes DROP COLUMN IF EXISTS reseller_id;
+ALTER TABLE customers DROP COLUMN IF EXISTS reseller_id;
+ALTER TABLE devices DROP COLUMN IF EXISTS reseller_id;
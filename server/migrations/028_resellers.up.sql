@@ -0,0 +1,22 @@
+-- Resellers: staff-tier accounts that buy capacity wholesale and manage their
+-- own end customers. A reseller is a users row with role 'reseller' and only
+-- sees devices allocated to it, customers it owns and what hangs off those.
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS reseller_id UUID REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS reseller_id UUID REFERENCES users(id) ON DELETE SET NULL;
+-- Devices paired with a reseller's code are allocated to that reseller.
+ALTER TABLE pairing_codes ADD COLUMN IF NOT EXISTS reseller_id UUID REFERENCES users(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_devices_reseller ON devices(reseller_id) WHERE reseller_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_customers_reseller ON customers(reseller_id) WHERE reseller_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_pairing_codes_reseller ON pairing_codes(reseller_id) WHERE reseller_id IS NOT NULL;
+
+-- White-label settings shown to a reseller's customers.
+CREATE TABLE IF NOT EXISTS reseller_branding (
+    reseller_id   UUID         NOT NULL PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    company_name  VARCHAR(255) NOT NULL DEFAULT '',
+    logo_url      TEXT         NOT NULL DEFAULT '',
+    primary_color VARCHAR(7)   NOT NULL DEFAULT '',
+    support_email VARCHAR(255) NOT NULL DEFAULT '',
+    domain        VARCHAR(255) UNIQUE,
+    updated_at    TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,20 @@
+-- Device share invitations: an owner shares a device with an email address.
+-- The invitee receives a one-time link; accepting it (after signing up if
+-- needed) creates the device_shares row and removes the invitation.
+CREATE TABLE IF NOT EXISTS device_share_invites (
+    id                   UUID         NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    device_id            UUID         NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    owner_id             UUID         NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    invited_by           UUID         REFERENCES customers(id) ON DELETE SET NULL,
+    email                VARCHAR(255) NOT NULL,
+    token_hash           VARCHAR(64)  NOT NULL UNIQUE,
+    can_rename           BOOLEAN      NOT NULL DEFAULT FALSE,
+    can_manage_ports     BOOLEAN      NOT NULL DEFAULT FALSE,
+    can_download_configs BOOLEAN      NOT NULL DEFAULT FALSE,
+    can_rotate_ip        BOOLEAN      NOT NULL DEFAULT FALSE,
+    expires_at           TIMESTAMPTZ  NOT NULL,
+    created_at           TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    UNIQUE (device_id, email)
+);
+
+CREATE INDEX IF NOT EXISTS idx_device_share_invites_owner ON device_share_invites(owner_id);
@@ -0,0 +1,3 @@
+ALTER TABLE proxy_connections DROP COLUMN IF EXISTS expiry_notified_at;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhook_endpoints;
@@ -0,0 +1,48 @@
+-- Webhook endpoints: any number per staff user or customer organization, each
+-- subscribed to a set of event types (empty = all) and signed with its secret.
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+    id          UUID         NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    user_id     UUID         REFERENCES users(id) ON DELETE CASCADE,
+    customer_id UUID         REFERENCES customers(id) ON DELETE CASCADE,
+    url         TEXT         NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    secret      VARCHAR(64)  NOT NULL,
+    events      TEXT[]       NOT NULL DEFAULT '{}',
+    active      BOOLEAN      NOT NULL DEFAULT TRUE,
+    created_at  TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    updated_at  TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    CHECK ((user_id IS NULL) != (customer_id IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_endpoints_user ON webhook_endpoints(user_id) WHERE user_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_webhook_endpoints_customer ON webhook_endpoints(customer_id) WHERE customer_id IS NOT NULL;
+
+-- Durable delivery queue and log. Deliveries without an endpoint go to a
+-- staff user's legacy users.webhook_url, unsigned. url is captured at enqueue
+-- time so the log shows where each attempt went.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id              UUID        NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    endpoint_id     UUID        REFERENCES webhook_endpoints(id) ON DELETE CASCADE,
+    user_id         UUID        REFERENCES users(id) ON DELETE CASCADE,
+    customer_id     UUID        REFERENCES customers(id) ON DELETE CASCADE,
+    url             TEXT        NOT NULL,
+    event           VARCHAR(64) NOT NULL,
+    payload         JSONB       NOT NULL,
+    status          VARCHAR(10) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+    attempts        INT         NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_attempt_at TIMESTAMPTZ,
+    response_status INT,
+    response_body   TEXT        NOT NULL DEFAULT '',
+    error           TEXT        NOT NULL DEFAULT '',
+    redelivery_of   UUID        REFERENCES webhook_deliveries(id) ON DELETE SET NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_endpoint ON webhook_deliveries(endpoint_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_user ON webhook_deliveries(user_id, created_at DESC) WHERE endpoint_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_created ON webhook_deliveries(created_at);
+
+-- connection.expired fires once per expiry.
+ALTER TABLE proxy_connections ADD COLUMN IF NOT EXISTS expiry_notified_at TIMESTAMPTZ;
@@ -0,0 +1,2 @@
+ALTER TABLE webhook_endpoints DROP COLUMN IF EXISTS chat_id;
+ALTER TABLE webhook_endpoints DROP COLUMN IF EXISTS kind;
@@ -0,0 +1,7 @@
+-- Chat notification channels are webhook endpoints of another kind: the
+-- delivery queue stays the same, only the request sent per attempt differs.
+-- Telegram channels keep the bot token in secret and the target chat in chat_id.
+ALTER TABLE webhook_endpoints
+    ADD COLUMN IF NOT EXISTS kind VARCHAR(16) NOT NULL DEFAULT 'webhook'
+        CHECK (kind IN ('webhook', 'slack', 'telegram', 'discord')),
+    ADD COLUMN IF NOT EXISTS chat_id VARCHAR(64) NOT NULL DEFAULT '';
@@ -0,0 +1,58 @@
+-- Alert rules over heartbeat telemetry, owned like webhook endpoints by a
+-- staff user or a customer organization. A rule covers one device or, with
+-- device_id NULL, every device its owner can see.
+CREATE TABLE IF NOT EXISTS alert_rules (
+    id               UUID         NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    user_id          UUID         REFERENCES users(id) ON DELETE CASCADE,
+    customer_id      UUID         REFERENCES customers(id) ON DELETE CASCADE,
+    device_id        UUID         REFERENCES devices(id) ON DELETE CASCADE,
+    name             VARCHAR(100) NOT NULL DEFAULT '',
+    type             VARCHAR(32)  NOT NULL
+        CHECK (type IN ('battery_low', 'signal_low', 'network_downgrade', 'carrier_changed', 'app_outdated')),
+    threshold        INT          NOT NULL DEFAULT 0,
+    value            VARCHAR(64)  NOT NULL DEFAULT '',
+    duration_minutes INT          NOT NULL DEFAULT 0,
+    cooldown_minutes INT          NOT NULL DEFAULT 60,
+    notify_webhook   BOOLEAN      NOT NULL DEFAULT TRUE,
+    notify_email     BOOLEAN      NOT NULL DEFAULT FALSE,
+    active           BOOLEAN      NOT NULL DEFAULT TRUE,
+    created_at       TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    CHECK ((user_id IS NULL) != (customer_id IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_alert_rules_user ON alert_rules(user_id) WHERE user_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_alert_rules_customer ON alert_rules(customer_id) WHERE customer_id IS NOT NULL;
+
+-- Per rule and device evaluation state: since when the condition has held,
+-- the carrier baseline for carrier_changed, and the last notification sent
+-- (for the cooldown).
+CREATE TABLE IF NOT EXISTS alert_rule_states (
+    rule_id          UUID        NOT NULL REFERENCES alert_rules(id) ON DELETE CASCADE,
+    device_id        UUID        NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    condition_since  TIMESTAMPTZ,
+    baseline         VARCHAR(64) NOT NULL DEFAULT '',
+    last_notified_at TIMESTAMPTZ,
+    PRIMARY KEY (rule_id, device_id)
+);
+
+CREATE TABLE IF NOT EXISTS alert_incidents (
+    id          UUID        NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    rule_id     UUID        NOT NULL REFERENCES alert_rules(id) ON DELETE CASCADE,
+    device_id   UUID        NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    user_id     UUID        REFERENCES users(id) ON DELETE CASCADE,
+    customer_id UUID        REFERENCES customers(id) ON DELETE CASCADE,
+    type        VARCHAR(32) NOT NULL,
+    status      VARCHAR(10) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'resolved')),
+    message     TEXT        NOT NULL DEFAULT '',
+    observed    VARCHAR(64) NOT NULL DEFAULT '',
+    notified    BOOLEAN     NOT NULL DEFAULT FALSE,
+    opened_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    resolved_at TIMESTAMPTZ,
+    resolved_by VARCHAR(16) CHECK (resolved_by IN ('auto', 'manual'))
+);
+
+-- At most one open incident per rule and device.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_alert_incidents_open ON alert_incidents(rule_id, device_id) WHERE status = 'open';
+CREATE INDEX IF NOT EXISTS idx_alert_incidents_user ON alert_incidents(user_id, opened_at DESC) WHERE user_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_alert_incidents_customer ON alert_incidents(customer_id, opened_at DESC) WHERE customer_id IS NOT NULL;
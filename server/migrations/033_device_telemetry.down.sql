@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS device_telemetry_hourly;
+DROP TABLE IF EXISTS device_telemetry;
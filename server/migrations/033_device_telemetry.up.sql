@@ -0,0 +1,35 @@
+-- Heartbeat telemetry samples, so signal, battery and network history
+-- survives the next heartbeat overwriting the devices row. At most one sample
+-- per device a minute is kept unless the network type, carrier or charging
+-- state changed. Partitioned by day on recorded_at; partitions are created on
+-- demand by the API and the worker, and dropped by the worker once past the
+-- raw retention window. No FK on device_id, as for connection_sessions.
+CREATE TABLE IF NOT EXISTS device_telemetry (
+    device_id        UUID        NOT NULL,
+    recorded_at      TIMESTAMPTZ NOT NULL,
+    battery_level    SMALLINT,
+    battery_charging BOOLEAN     NOT NULL DEFAULT FALSE,
+    signal_strength  INTEGER,                -- dBm, NULL when unknown
+    network_type     VARCHAR(16) NOT NULL DEFAULT '',
+    carrier          VARCHAR(64) NOT NULL DEFAULT '',
+    PRIMARY KEY (device_id, recorded_at)
+) PARTITION BY RANGE (recorded_at);
+
+-- Hourly (UTC) downsampled telemetry, kept longer than the raw samples.
+-- network_type and carrier hold the most common value in the hour.
+CREATE TABLE IF NOT EXISTS device_telemetry_hourly (
+    device_id    UUID        NOT NULL,
+    bucket       TIMESTAMPTZ NOT NULL,
+    samples      INTEGER     NOT NULL DEFAULT 0,
+    battery_min  SMALLINT,
+    battery_avg  REAL,
+    battery_max  SMALLINT,
+    signal_min   INTEGER,
+    signal_avg   REAL,
+    signal_max   INTEGER,
+    network_type VARCHAR(16) NOT NULL DEFAULT '',
+    carrier      VARCHAR(64) NOT NULL DEFAULT '',
+    PRIMARY KEY (device_id, bucket)
+);
+
+CREATE INDEX IF NOT EXISTS idx_device_telemetry_hourly_bucket ON device_telemetry_hourly(bucket);
@@ -0,0 +1,14 @@
+-- Declared maintenance windows, excluded from uptime SLA reports. device_id
+-- NULL covers every device.
+CREATE TABLE IF NOT EXISTS maintenance_windows (
+    id         UUID         NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    device_id  UUID         REFERENCES devices(id) ON DELETE CASCADE,
+    starts_at  TIMESTAMPTZ  NOT NULL,
+    ends_at    TIMESTAMPTZ  NOT NULL,
+    reason     VARCHAR(255) NOT NULL DEFAULT '',
+    created_by UUID         REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    CHECK (ends_at > starts_at)
+);
+
+CREATE INDEX IF NOT EXISTS idx_maintenance_windows_range ON maintenance_windows(starts_at, ends_at);